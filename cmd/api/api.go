@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/binary"
@@ -8,9 +9,11 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,6 +25,8 @@ import (
 	"github.com/networknext/next/modules/core"
 	db "github.com/networknext/next/modules/database"
 	"github.com/networknext/next/modules/envvar"
+	"github.com/networknext/next/modules/handlers"
+	"github.com/networknext/next/modules/packets"
 	"github.com/networknext/next/modules/portal"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -31,6 +36,8 @@ import (
 
 var redisPortalClient redis.Cmdable
 var redisRelayBackendClient *redis.Client
+var relayBackendLeaseStore common.LeaseStore
+var relayBackendLeaseDataStore common.LeaseDataStore
 
 var controller *admin.Controller
 
@@ -45,6 +52,7 @@ var serverCruncherURL string
 var topSessionsWatcher *portal.TopSessionsWatcher
 var topServersWatcher *portal.TopServersWatcher
 var mapDataWatcher *portal.MapDataWatcher
+var portalEventHub *portal.EventHub
 var adminTimeSeriesWatcher *common.RedisTimeSeriesWatcher
 var buyerTimeSeriesWatcher *common.RedisTimeSeriesWatcher
 var relayTimeSeriesWatcher *common.RedisTimeSeriesWatcher
@@ -68,7 +76,7 @@ func main() {
 		core.Debug("redis time series hostname: %s", redisTimeSeriesHostname)
 	}
 
-	privateKey = envvar.GetString("API_PRIVATE_KEY", "")
+	privateKey = envvar.GetSecretString("API_PRIVATE_KEY", "")
 	pgsqlConfig = envvar.GetString("PGSQL_CONFIG", "host=127.0.0.1 port=5432 user=developer password=developer dbname=postgres sslmode=disable")
 	databaseURL = envvar.GetString("DATABASE_URL", "")
 	sessionCruncherURL = envvar.GetString("SESSION_CRUNCHER_URL", "http://127.0.0.1:40200")
@@ -116,6 +124,13 @@ func main() {
 
 	}
 
+	redisRelayBackendClient = common.CreateRedisClient(redisRelayBackendHostname)
+
+	if envvar.GetString("LEADER_ELECTION_BACKEND", common.LeaderElectionBackend_Redis) == common.LeaderElectionBackend_Etcd {
+		relayBackendLeaseStore = common.CreateEtcdLeaseStore(envvar.GetString("ETCD_URL", "http://127.0.0.1:2379"))
+		relayBackendLeaseDataStore = common.CreateRedisLeaseDataStore(redisRelayBackendClient)
+	}
+
 	if enableAdmin {
 
 		controller = admin.CreateController(pgsqlConfig)
@@ -125,47 +140,70 @@ func main() {
 		service.Router.HandleFunc("/admin/database", isAdminAuthorized(adminDatabaseHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/commit", isAdminAuthorized(adminCommitHandler)).Methods("PUT")
 
-		service.Router.HandleFunc("/admin/create_seller", isAdminAuthorized(adminCreateSellerHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/create_seller", isAdminAudited("seller", adminCreateSellerHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/sellers", isAdminAuthorized(adminReadSellersHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/seller/{sellerId}", isAdminAuthorized(adminReadSellerHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_seller", isAdminAuthorized(adminUpdateSellerHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_seller/{sellerId}", isAdminAuthorized(adminDeleteSellerHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/update_seller", isAdminAudited("seller", adminUpdateSellerHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_seller/{sellerId}", isAdminAudited("seller", adminDeleteSellerHandler)).Methods("DELETE")
 
-		service.Router.HandleFunc("/admin/create_buyer", isAdminAuthorized(adminCreateBuyerHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/create_buyer", isAdminAudited("buyer", adminCreateBuyerHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/buyers", isAdminAuthorized(adminReadBuyersHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/buyer/{buyerId}", isAdminAuthorized(adminReadBuyerHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_buyer", isAdminAuthorized(adminUpdateBuyerHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_buyer/{buyerId}", isAdminAuthorized(adminDeleteBuyerHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/update_buyer", isAdminAudited("buyer", adminUpdateBuyerHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_buyer/{buyerId}", isAdminAudited("buyer", adminDeleteBuyerHandler)).Methods("DELETE")
 
-		service.Router.HandleFunc("/admin/create_datacenter", isAdminAuthorized(adminCreateDatacenterHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/create_datacenter", isAdminAudited("datacenter", adminCreateDatacenterHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/datacenters", isAdminAuthorized(adminReadDatacentersHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/datacenter/{datacenterId}", isAdminAuthorized(adminReadDatacenterHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_datacenter", isAdminAuthorized(adminUpdateDatacenterHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_datacenter/{datacenterId}", isAdminAuthorized(adminDeleteDatacenterHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/update_datacenter", isAdminAudited("datacenter", adminUpdateDatacenterHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_datacenter/{datacenterId}", isAdminAudited("datacenter", adminDeleteDatacenterHandler)).Methods("DELETE")
 
-		service.Router.HandleFunc("/admin/create_relay", isAdminAuthorized(adminCreateRelayHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/create_relay", isAdminAudited("relay", adminCreateRelayHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/relays", isAdminAuthorized(adminReadRelaysHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/relay/{relayId}", isAdminAuthorized(adminReadRelayHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_relay", isAdminAuthorized(adminUpdateRelayHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_relay/{relayId}", isAdminAuthorized(adminDeleteRelayHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/update_relay", isAdminAudited("relay", adminUpdateRelayHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_relay/{relayId}", isAdminAudited("relay", adminDeleteRelayHandler)).Methods("DELETE")
 
-		service.Router.HandleFunc("/admin/create_route_shader", isAdminAuthorized(adminCreateRouteShaderHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/create_route_shader", isAdminAudited("route_shader", adminCreateRouteShaderHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/route_shaders", isAdminAuthorized(adminReadRouteShadersHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/route_shader/{routeShaderId}", isAdminAuthorized(adminReadRouteShaderHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_route_shader", isAdminAuthorized(adminUpdateRouteShaderHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_route_shader/{routeShaderId}", isAdminAuthorized(adminDeleteRouteShaderHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/update_route_shader", isAdminAudited("route_shader", adminUpdateRouteShaderHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_route_shader/{routeShaderId}", isAdminAudited("route_shader", adminDeleteRouteShaderHandler)).Methods("DELETE")
 
-		service.Router.HandleFunc("/admin/create_buyer_datacenter_settings", isAdminAuthorized(adminCreateBuyerDatacenterSettingsHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/create_buyer_datacenter_settings", isAdminAudited("buyer_datacenter_settings", adminCreateBuyerDatacenterSettingsHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/buyer_datacenter_settings", isAdminAuthorized(adminReadBuyerDatacenterSettingsListHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/buyer_datacenter_settings/{buyerId}/{datacenterId}", isAdminAuthorized(adminReadBuyerDatacenterSettingsHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_buyer_datacenter_settings", isAdminAuthorized(adminUpdateBuyerDatacenterSettingsHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_buyer_datacenter_settings/{buyerId}/{datacenterId}", isAdminAuthorized(adminDeleteBuyerDatacenterSettingsHandler)).Methods("DELETE")
-
-		service.Router.HandleFunc("/admin/create_relay_keypair", isAdminAuthorized(adminCreateRelayKeypairHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/update_buyer_datacenter_settings", isAdminAudited("buyer_datacenter_settings", adminUpdateBuyerDatacenterSettingsHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_buyer_datacenter_settings/{buyerId}/{datacenterId}", isAdminAudited("buyer_datacenter_settings", adminDeleteBuyerDatacenterSettingsHandler)).Methods("DELETE")
+
+		service.Router.HandleFunc("/admin/create_buyer_key", isAdminAudited("buyer_key", adminCreateBuyerKeyHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/buyer_keys", isAdminAuthorized(adminReadBuyerKeysHandler)).Methods("GET")
+		service.Router.HandleFunc("/admin/buyer_key/{buyerKeyId}", isAdminAuthorized(adminReadBuyerKeyHandler)).Methods("GET")
+		service.Router.HandleFunc("/admin/update_buyer_key", isAdminAudited("buyer_key", adminUpdateBuyerKeyHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_buyer_key/{buyerKeyId}", isAdminAudited("buyer_key", adminDeleteBuyerKeyHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/create_relay_keypair", isAdminAudited("relay_keypair", adminCreateRelayKeypairHandler)).Methods("POST")
 		service.Router.HandleFunc("/admin/relay_keypairs", isAdminAuthorized(adminReadRelayKeypairsHandler)).Methods("GET")
 		service.Router.HandleFunc("/admin/relay_keypair/{relayKeypairId}", isAdminAuthorized(adminReadRelayKeypairHandler)).Methods("GET")
-		service.Router.HandleFunc("/admin/update_relay_keypair", isAdminAuthorized(adminUpdateRelayKeypairHandler)).Methods("PUT")
-		service.Router.HandleFunc("/admin/delete_relay_keypair/{relayKeypairId}", isAdminAuthorized(adminDeleteRelayKeypairHandler)).Methods("DELETE")
+		service.Router.HandleFunc("/admin/update_relay_keypair", isAdminAudited("relay_keypair", adminUpdateRelayKeypairHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/delete_relay_keypair/{relayKeypairId}", isAdminAudited("relay_keypair", adminDeleteRelayKeypairHandler)).Methods("DELETE")
+
+		service.Router.HandleFunc("/admin/rollouts", isAdminAuthorized(adminRolloutsHandler)).Methods("GET")
+		service.Router.HandleFunc("/admin/start_rollout", isAdminAudited("rollout", adminStartRolloutHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/pause_rollout", isAdminAudited("rollout", adminPauseRolloutHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/resume_rollout", isAdminAudited("rollout", adminResumeRolloutHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/abort_rollout", isAdminAudited("rollout", adminAbortRolloutHandler)).Methods("PUT")
+
+		go rolloutController(service.Context)
+
+		service.Router.HandleFunc("/admin/create_relay_bootstrap_token", isAdminAudited("relay_bootstrap_token", adminCreateRelayBootstrapTokenHandler)).Methods("POST")
+		service.Router.HandleFunc("/admin/pending_relays", isAdminAuthorized(adminPendingRelaysHandler)).Methods("GET")
+		service.Router.HandleFunc("/admin/approve_pending_relay/{registrationId}", isAdminAudited("pending_relay", adminApprovePendingRelayHandler)).Methods("PUT")
+		service.Router.HandleFunc("/admin/reject_pending_relay/{registrationId}", isAdminAudited("pending_relay", adminRejectPendingRelayHandler)).Methods("PUT")
+
+		service.Router.HandleFunc("/admin/audit", isAdminAuthorized(adminAuditHandler)).Methods("GET")
+
+		service.Router.HandleFunc("/admin/matrix_snapshots/{name}", isAdminAuthorized(adminMatrixSnapshotsHandler)).Methods("GET")
+		service.Router.HandleFunc("/admin/matrix_snapshot/{name}/{timestamp}", isAdminAuthorized(adminMatrixSnapshotHandler)).Methods("GET")
 	}
 
 	if enablePortal {
@@ -315,21 +353,30 @@ func main() {
 		}
 
 		if len(redisPortalCluster) > 0 {
-			redisPortalClient = common.CreateRedisClusterClient(redisPortalCluster)
+			redisPortalClusterClient := common.CreateRedisClusterClient(redisPortalCluster)
+			redisPortalClient = redisPortalClusterClient
+			portalEventHub = portal.CreateEventHub(service.Context, redisPortalClusterClient)
 		} else {
-			redisPortalClient = common.CreateRedisClient(redisPortalHostname)
+			redisPortalSingleClient := common.CreateRedisClient(redisPortalHostname)
+			redisPortalClient = redisPortalSingleClient
+			portalEventHub = portal.CreateEventHub(service.Context, redisPortalSingleClient)
 		}
 
-		redisRelayBackendClient = common.CreateRedisClient(redisRelayBackendHostname)
-
 		service.Router.HandleFunc("/portal/session_counts", isPortalAuthorized(portalSessionCountsHandler))
 		service.Router.HandleFunc("/portal/sessions", isPortalAuthorized(portalSessionsHandler))
 		service.Router.HandleFunc("/portal/sessions/{page}", isPortalAuthorized(portalSessionsHandler))
 		service.Router.HandleFunc("/portal/session/{session_id}", isPortalAuthorized(portalSessionDataHandler))
 
+		service.Router.HandleFunc("/portal/stream/session/{session_id}", isPortalAuthorized(portalStreamSessionHandler))
+		service.Router.HandleFunc("/portal/stream/buyer/{buyer_code}", isPortalAuthorized(portalStreamBuyerHandler))
+		service.Router.HandleFunc("/portal/stream/relays", isPortalAuthorized(portalStreamRelaysHandler))
+
 		service.Router.HandleFunc("/portal/servers/{page}", isPortalAuthorized(portalServersHandler))
 		service.Router.HandleFunc("/portal/server/{server_id}", isPortalAuthorized(portalServerDataHandler))
 		service.Router.HandleFunc("/portal/server/{server_id}/{page}", isPortalAuthorized(portalServerDataHandler))
+		service.Router.HandleFunc("/portal/worst_servers/{buyer_code}", isPortalAuthorized(portalWorstServersHandler))
+		service.Router.HandleFunc("/portal/sdk_versions", isPortalAuthorized(portalSDKVersionsHandler))
+		service.Router.HandleFunc("/portal/sdk_versions/{buyer_code}", isPortalAuthorized(portalSDKVersionsHandler))
 
 		service.Router.HandleFunc("/portal/relay_count", isPortalAuthorized(portalRelayCountHandler))
 		service.Router.HandleFunc("/portal/relays", isPortalAuthorized(portalRelaysHandler))
@@ -351,6 +398,10 @@ func main() {
 
 		service.Router.HandleFunc("/portal/map_data", isPortalAuthorized(portalMapDataHandler))
 
+		service.Router.HandleFunc("/portal/heatmap/{precision}", isPortalAuthorized(portalHeatmapHandler))
+		service.Router.HandleFunc("/portal/heatmap/buyer/{buyer_code}/{precision}", isPortalAuthorized(portalHeatmapHandler))
+		service.Router.HandleFunc("/portal/heatmap/datacenter/{datacenter_name}/{precision}", isPortalAuthorized(portalHeatmapHandler))
+
 		service.Router.HandleFunc("/portal/cost_matrix", isPortalAuthorized(portalCostMatrixHandler))
 
 		service.Router.HandleFunc("/portal/admin_data", isPortalAuthorized(portalAdminDataHandler))
@@ -421,10 +472,12 @@ func isAuthorized(check func(claims *Claims) bool, endpoint func(http.ResponseWr
 			return
 		}
 
-		endpoint(w, r)
+		endpoint(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, &claims)))
 	}
 }
 
+type claimsContextKey struct{}
+
 func isAdminAuthorized(endpoint func(http.ResponseWriter, *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return isAuthorized(func(claims *Claims) bool { return claims.Admin }, endpoint)
 }
@@ -435,6 +488,343 @@ func isPortalAuthorized(endpoint func(http.ResponseWriter, *http.Request)) func(
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// Every admin mutation is wrapped in isAdminAudited, which writes an entry to the append-only audit log in postgres
+// with the actor from the JWT, the endpoint, and the entity before and after the change. The mutation and its audit entry
+// run in one postgres transaction, so a mutation is never applied without being logged. Failed mutations are not logged.
+// The database commit can't be undone once uploaded, so it writes its own audit entry before the upload instead.
+// Changes the api makes on its own, like relay versions written back by a rollout, are logged with a system actor.
+// The actor is the subject of the JWT. Keys without one can't make audited changes, since nobody could be held to them.
+
+const RolloutAuditActor = "rollout controller"
+
+type auditEntity struct {
+	fields []string // id fields in the update request body
+	vars   []string // id vars in the delete route
+	read   func(controller *admin.Controller, ids []uint64) (any, error)
+}
+
+var auditEntities = map[string]auditEntity{
+	"seller": {
+		fields: []string{"seller_id"},
+		vars:   []string{"sellerId"},
+		read:   func(controller *admin.Controller, ids []uint64) (any, error) { return controller.ReadSeller(ids[0]) },
+	},
+	"buyer": {
+		fields: []string{"buyer_id"},
+		vars:   []string{"buyerId"},
+		read:   func(controller *admin.Controller, ids []uint64) (any, error) { return controller.ReadBuyer(ids[0]) },
+	},
+	"datacenter": {
+		fields: []string{"datacenter_id"},
+		vars:   []string{"datacenterId"},
+		read: func(controller *admin.Controller, ids []uint64) (any, error) {
+			return controller.ReadDatacenter(ids[0])
+		},
+	},
+	"relay": {
+		fields: []string{"relay_id"},
+		vars:   []string{"relayId"},
+		read:   func(controller *admin.Controller, ids []uint64) (any, error) { return controller.ReadRelay(ids[0]) },
+	},
+	"route_shader": {
+		fields: []string{"route_shader_id"},
+		vars:   []string{"routeShaderId"},
+		read: func(controller *admin.Controller, ids []uint64) (any, error) {
+			return controller.ReadRouteShader(ids[0])
+		},
+	},
+	"buyer_datacenter_settings": {
+		fields: []string{"buyer_id", "datacenter_id"},
+		vars:   []string{"buyerId", "datacenterId"},
+		read: func(controller *admin.Controller, ids []uint64) (any, error) {
+			return controller.ReadBuyerDatacenterSettings(ids[0], ids[1])
+		},
+	},
+	"buyer_key": {
+		fields: []string{"buyer_key_id"},
+		vars:   []string{"buyerKeyId"},
+		read:   func(controller *admin.Controller, ids []uint64) (any, error) { return controller.ReadBuyerKey(ids[0]) },
+	},
+	"relay_keypair": {
+		fields: []string{"relay_keypair_id"},
+		vars:   []string{"relayKeypairId"},
+		read: func(controller *admin.Controller, ids []uint64) (any, error) {
+			return controller.ReadRelayKeypair(ids[0])
+		},
+	},
+	"rollout": {
+		read: func(controller *admin.Controller, ids []uint64) (any, error) {
+			return common.LoadRollout(service.Context, redisRelayBackendClient)
+		},
+	},
+}
+
+// the response is buffered so nothing reaches the client until the mutation and its audit entry have committed together
+
+type auditResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (writer *auditResponseWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *auditResponseWriter) WriteHeader(status int) {
+	writer.status = status
+}
+
+func (writer *auditResponseWriter) Write(data []byte) (int, error) {
+	return writer.body.Write(data)
+}
+
+func (writer *auditResponseWriter) flush(w http.ResponseWriter) {
+	w.WriteHeader(writer.status)
+	w.Write(writer.body.Bytes())
+}
+
+type controllerContextKey struct{}
+
+// requestController is the transaction controller for an audited mutation, otherwise the shared controller
+
+func requestController(r *http.Request) *admin.Controller {
+	if transaction, ok := r.Context().Value(controllerContextKey{}).(*admin.Controller); ok {
+		return transaction
+	}
+	return controller
+}
+
+// redis isn't part of the audit transaction, so audited handlers defer their redis side effects until the transaction
+// commits, and register how to undo the ones that can't wait. without a transaction, afterCommit runs immediately
+
+type auditHooks struct {
+	commit   []func()
+	rollback []func()
+}
+
+type auditHooksContextKey struct{}
+
+func afterCommit(r *http.Request, hook func()) {
+	if hooks, ok := r.Context().Value(auditHooksContextKey{}).(*auditHooks); ok {
+		hooks.commit = append(hooks.commit, hook)
+		return
+	}
+	hook()
+}
+
+func onRollback(r *http.Request, hook func()) {
+	if hooks, ok := r.Context().Value(auditHooksContextKey{}).(*auditHooks); ok {
+		hooks.rollback = append(hooks.rollback, hook)
+	}
+}
+
+func isAdminAudited(entity string, endpoint func(http.ResponseWriter, *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return isAdminAuthorized(func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuditActor(w, r) {
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			core.Error("failed to read request body for audit: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		transaction, err := controller.BeginTransaction()
+		if err != nil {
+			core.Error("failed to begin audit transaction: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		hooks := &auditHooks{}
+		committed := false
+		defer func() {
+			if !committed {
+				transaction.Rollback()
+				for _, hook := range hooks.rollback {
+					hook()
+				}
+			}
+		}()
+
+		ctx := context.WithValue(r.Context(), controllerContextKey{}, transaction)
+		r = r.WithContext(context.WithValue(ctx, auditHooksContextKey{}, hooks))
+
+		before := auditBefore(entity, r, body)
+
+		writer := &auditResponseWriter{header: w.Header(), status: http.StatusOK}
+
+		endpoint(writer, r)
+
+		after, ok := auditAfter(writer, body)
+		if !ok {
+			writer.flush(w)
+			return
+		}
+
+		if err := audit(r, entity, before, after); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := transaction.Commit(); err != nil {
+			core.Error("failed to commit audit transaction for %s: %v", r.URL.Path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		committed = true
+
+		for _, hook := range hooks.commit {
+			hook()
+		}
+
+		writer.flush(w)
+	})
+}
+
+// auditAfter extracts the entity after a successful mutation from its response. Failed mutations return false.
+
+func auditAfter(writer *auditResponseWriter, body []byte) (json.RawMessage, bool) {
+	if writer.status != http.StatusOK {
+		return nil, false
+	}
+
+	response := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(writer.body.Bytes(), &response); err != nil {
+		return nil, false
+	}
+	if errorMessage, exists := response["error"]; exists {
+		if string(errorMessage) != `""` && string(errorMessage) != "null" {
+			return nil, false
+		}
+		delete(response, "error")
+	}
+
+	var after json.RawMessage
+	switch len(response) {
+	case 0:
+		if len(bytes.TrimSpace(body)) > 0 {
+			after = body
+		}
+	case 1:
+		for _, value := range response {
+			after = value
+		}
+	default:
+		after, _ = json.Marshal(response)
+	}
+	return after, true
+}
+
+// auditBefore reads the entity as it was prior to an update or delete. Creates and entities without a reader have no before.
+
+func auditBefore(entity string, r *http.Request, body []byte) json.RawMessage {
+	auditEntity, exists := auditEntities[entity]
+	if !exists {
+		return nil
+	}
+	vars := mux.Vars(r)
+	fields := make(map[string]json.RawMessage)
+	json.Unmarshal(body, &fields)
+	ids := make([]uint64, len(auditEntity.fields))
+	for i := range ids {
+		value, exists := vars[auditEntity.vars[i]]
+		if !exists {
+			value = strings.Trim(string(fields[auditEntity.fields[i]]), `"`)
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			return nil
+		}
+		ids[i] = id
+	}
+	data, err := auditEntity.read(requestController(r), ids)
+	if err != nil {
+		return nil
+	}
+	before, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	return before
+}
+
+func audit(r *http.Request, entity string, before json.RawMessage, after json.RawMessage) error {
+	entry := admin.AuditEntry{
+		Timestamp: time.Now().Unix(),
+		Actor:     auditActor(r),
+		Endpoint:  r.URL.Path,
+		Entity:    entity,
+		Before:    redactAuditJSON(before),
+		After:     redactAuditJSON(after),
+	}
+	if _, err := requestController(r).CreateAuditEntry(&entry); err != nil {
+		core.Error("failed to write audit entry for %s: %v", entry.Endpoint, err)
+		return err
+	}
+	return nil
+}
+
+func auditActor(r *http.Request) string {
+	claims, ok := r.Context().Value(claimsContextKey{}).(*Claims)
+	if !ok {
+		return ""
+	}
+	return claims.Subject
+}
+
+// requireAuditActor rejects audited changes from keys without a subject, like admin keys from older versions of 'next keygen'
+
+func requireAuditActor(w http.ResponseWriter, r *http.Request) bool {
+	if auditActor(r) != "" {
+		return true
+	}
+	core.Error("rejected %s: admin api key has no subject, so the change can't be audited", r.URL.Path)
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, "Admin API key has no operator. Generate one with 'next keygen admin <operator>'")
+	return false
+}
+
+// never let private keys or bootstrap tokens into the audit log
+
+func redactAuditJSON(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(redactAuditValue(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func redactAuditValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, inner := range value {
+			if strings.Contains(key, "private_key") || strings.Contains(key, "token") {
+				value[key] = "<redacted>"
+			} else {
+				value[key] = redactAuditValue(inner)
+			}
+		}
+	case []any:
+		for i := range value {
+			value[i] = redactAuditValue(value[i])
+		}
+	}
+	return value
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
 func pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
@@ -490,6 +880,7 @@ type PortalSessionData struct {
 	NumRouteRelays  int      `json:"num_route_relays"`
 	RouteRelayIds   []uint64 `json:"route_relay_ids,string"`
 	RouteRelayNames []string `json:"route_relay_names"`
+	MetricValue     float32  `json:"metric_value,omitempty"`
 }
 
 func upgradeSessionData(database *db.Database, input *portal.SessionData, output *PortalSessionData) {
@@ -532,10 +923,56 @@ func upgradeSessionData(database *db.Database, input *portal.SessionData, output
 
 type PortalSessionsResponse struct {
 	Sessions   []PortalSessionData `json:"sessions"`
+	Metric     string              `json:"metric,omitempty"`
 	OutputPage int                 `json:"output_page"`
 	NumPages   int                 `json:"num_pages"`
 }
 
+// parseRankingQuery reads the optional metric, buyer and datacenter query parameters used by the session and
+// server lists. buyer and datacenter filters are only supported when ranking by a metric.
+
+func parseRankingQuery(r *http.Request, metrics []string) (string, uint64, uint64, bool) {
+
+	query := r.URL.Query()
+
+	metric := query.Get("metric")
+	buyerCode := query.Get("buyer")
+	datacenterName := query.Get("datacenter")
+
+	if metric == "" {
+		return "", 0, 0, buyerCode == "" && datacenterName == ""
+	}
+
+	if !slices.Contains(metrics, metric) {
+		return "", 0, 0, false
+	}
+
+	database := service.Database()
+	if database == nil {
+		return "", 0, 0, false
+	}
+
+	buyerId := uint64(0)
+	if buyerCode != "" {
+		buyer := database.GetBuyerByCode(buyerCode)
+		if buyer == nil {
+			return "", 0, 0, false
+		}
+		buyerId = buyer.Id
+	}
+
+	datacenterId := uint64(0)
+	if datacenterName != "" {
+		datacenter := database.GetDatacenterByName(datacenterName)
+		if datacenter == nil {
+			return "", 0, 0, false
+		}
+		datacenterId = datacenter.Id
+	}
+
+	return metric, buyerId, datacenterId, true
+}
+
 func portalSessionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
@@ -545,9 +982,26 @@ func portalSessionsHandler(w http.ResponseWriter, r *http.Request) {
 		page = 0
 	}
 
+	metric, buyerId, datacenterId, ok := parseRankingQuery(r, portal.SessionMetrics)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	// Get the "top sessions" (around 10k max) with the biggest improvement as an array of session ids
 	// This lets us scale the portal up to any number of sessions. We'll only ever display the top ~10k in the portal session list.
-	sessionIds := topSessionsWatcher.GetTopSessions()
+	// When a metric is requested, the session cruncher ranks sessions by that metric instead, optionally filtered by buyer and datacenter.
+	var sessionIds []uint64
+	metricValues := make(map[uint64]float32)
+	if metric == "" {
+		sessionIds = topSessionsWatcher.GetTopSessions()
+	} else {
+		var values []float32
+		sessionIds, values = portal.GetTopSessionsByMetric(sessionCruncherURL, metric, buyerId, datacenterId, portal.MaxRankingCount)
+		for i := range sessionIds {
+			metricValues[sessionIds[i]] = values[i]
+		}
+	}
 
 	// Apply a very simple pagination algorithm to narrow session ids down to a single page of ~100 session ids
 	begin, end, outputPage, numPages := core.DoPagination_Simple(int(page), len(sessionIds))
@@ -561,14 +1015,20 @@ func portalSessionsHandler(w http.ResponseWriter, r *http.Request) {
 	upgradedSessions := make([]PortalSessionData, len(sessions))
 	for i := range upgradedSessions {
 		upgradeSessionData(service.Database(), sessions[i], &upgradedSessions[i])
+		upgradedSessions[i].MetricValue = metricValues[upgradedSessions[i].SessionId]
 	}
 
 	// Sometimes the score is out of date between redis and the session cruncher. Sort the sessions page here to fix it
-	sort.SliceStable(upgradedSessions, func(i, j int) bool { return upgradedSessions[i].Score < upgradedSessions[j].Score })
+	if metric == "" {
+		sort.SliceStable(upgradedSessions, func(i, j int) bool { return upgradedSessions[i].Score < upgradedSessions[j].Score })
+	} else {
+		sort.SliceStable(upgradedSessions, func(i, j int) bool { return upgradedSessions[i].MetricValue > upgradedSessions[j].MetricValue })
+	}
 
 	// Fill out the HTTP response struct and fire it back as JSON
 	response := PortalSessionsResponse{}
 	response.Sessions = upgradedSessions
+	response.Metric = metric
 	response.OutputPage = outputPage
 	response.NumPages = numPages
 	w.Header().Set("Content-Type", "application/json")
@@ -637,10 +1097,11 @@ func upgradeServerRelayData(database *db.Database, input []portal.ServerRelayDat
 }
 
 type PortalSessionDataResponse struct {
-	SessionData     PortalSessionData       `json:"session_data"`
-	SliceData       []portal.SliceData      `json:"slice_data"`
-	ClientRelayData []PortalClientRelayData `json:"client_relay_data"`
-	ServerRelayData []PortalServerRelayData `json:"server_relay_data"`
+	SessionData     PortalSessionData          `json:"session_data"`
+	SliceData       []portal.SliceData         `json:"slice_data"`
+	ClientRelayData []PortalClientRelayData    `json:"client_relay_data"`
+	ServerRelayData []PortalServerRelayData    `json:"server_relay_data"`
+	SessionReports  []portal.SessionReportData `json:"session_reports"`
 }
 
 func portalSessionDataHandler(w http.ResponseWriter, r *http.Request) {
@@ -675,6 +1136,8 @@ func portalSessionDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	upgradeServerRelayData(database, serverRelayData, &response.ServerRelayData)
 
+	response.SessionReports = portal.GetSessionReports(service.Context, redisPortalClient, sessionId)
+
 	w.WriteHeader(http.StatusOK)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -685,22 +1148,24 @@ func portalSessionDataHandler(w http.ResponseWriter, r *http.Request) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 type PortalServerData struct {
-	ServerAddress    string `json:"server_address"`
-	SDKVersion_Major uint8  `json:"sdk_version_major"`
-	SDKVersion_Minor uint8  `json:"sdk_version_minor"`
-	SDKVersion_Patch uint8  `json:"sdk_version_patch"`
-	BuyerId          uint64 `json:"buyer_id,string"`
-	ServerId         uint64 `json:"server_id,string"`
-	DatacenterId     uint64 `json:"datacenter_id,string"`
-	NumSessions      uint32 `json:"num_sessions"`
-	Uptime           uint64 `json:"uptime,string"`
-	BuyerName        string `json:"buyer_name"`
-	BuyerCode        string `json:"buyer_code"`
-	DatacenterName   string `json:"datacenter_name"`
+	ServerAddress    string  `json:"server_address"`
+	SDKVersion_Major uint8   `json:"sdk_version_major"`
+	SDKVersion_Minor uint8   `json:"sdk_version_minor"`
+	SDKVersion_Patch uint8   `json:"sdk_version_patch"`
+	BuyerId          uint64  `json:"buyer_id,string"`
+	ServerId         uint64  `json:"server_id,string"`
+	DatacenterId     uint64  `json:"datacenter_id,string"`
+	NumSessions      uint32  `json:"num_sessions"`
+	Uptime           uint64  `json:"uptime,string"`
+	BuyerName        string  `json:"buyer_name"`
+	BuyerCode        string  `json:"buyer_code"`
+	DatacenterName   string  `json:"datacenter_name"`
+	MetricValue      float32 `json:"metric_value,omitempty"`
 }
 
 type PortalServersResponse struct {
 	Servers    []PortalServerData `json:"servers"`
+	Metric     string             `json:"metric,omitempty"`
 	OutputPage int                `json:"output_page"`
 	NumPages   int                `json:"num_pages"`
 }
@@ -733,17 +1198,93 @@ func portalServersHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		page = 0
 	}
-	serverIds := topServersWatcher.GetTopServers()
+	metric, buyerId, datacenterId, ok := parseRankingQuery(r, portal.ServerMetrics)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var serverIds []uint64
+	metricValues := make(map[uint64]float32)
+	if metric == "" {
+		serverIds = topServersWatcher.GetTopServers()
+	} else {
+		var values []float32
+		serverIds, values = portal.GetTopServersByMetric(serverCruncherURL, metric, buyerId, datacenterId, portal.MaxRankingCount)
+		for i := range serverIds {
+			metricValues[serverIds[i]] = values[i]
+		}
+	}
 	begin, end, outputPage, numPages := core.DoPagination_Simple(int(page), len(serverIds))
 	serverIds = serverIds[begin:end]
 	servers := portal.GetServerList(service.Context, redisPortalClient, serverIds)
 	response := PortalServersResponse{}
 	response.Servers = make([]PortalServerData, len(servers))
+	response.Metric = metric
 	response.OutputPage = outputPage
 	response.NumPages = numPages
 	database := service.Database()
 	for i := range servers {
 		upgradeServer(database, servers[i], &response.Servers[i])
+		response.Servers[i].MetricValue = metricValues[servers[i].ServerId]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// portalSDKVersionsHandler breaks the top servers out by buyer and sdk version, flagging versions that are below
+// the buyer's min or recommended sdk version.
+
+type PortalSDKVersion struct {
+	BuyerId            uint64 `json:"buyer_id,string"`
+	BuyerName          string `json:"buyer_name"`
+	BuyerCode          string `json:"buyer_code"`
+	SDKVersion         string `json:"sdk_version"`
+	NumServers         int    `json:"num_servers"`
+	NumSessions        uint64 `json:"num_sessions"`
+	TooOld             bool   `json:"too_old"`
+	UpgradeRecommended bool   `json:"upgrade_recommended"`
+}
+
+type PortalSDKVersionsResponse struct {
+	SDKVersions []PortalSDKVersion `json:"sdk_versions"`
+}
+
+func portalSDKVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	database := service.Database()
+	if database == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var filterBuyer *db.Buyer
+	if buyerCode, exists := mux.Vars(r)["buyer_code"]; exists {
+		filterBuyer = database.GetBuyerByCode(buyerCode)
+		if filterBuyer == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	servers := portal.GetServerList(service.Context, redisPortalClient, topServersWatcher.GetTopServers())
+	counts := portal.CountSDKVersions(servers)
+	response := PortalSDKVersionsResponse{SDKVersions: make([]PortalSDKVersion, 0, len(counts))}
+	for i := range counts {
+		if filterBuyer != nil && counts[i].BuyerId != filterBuyer.Id {
+			continue
+		}
+		version := packets.SDKVersion{Major: int32(counts[i].SDKVersion_Major), Minor: int32(counts[i].SDKVersion_Minor), Patch: int32(counts[i].SDKVersion_Patch)}
+		output := PortalSDKVersion{
+			BuyerId:     counts[i].BuyerId,
+			SDKVersion:  version.String(),
+			NumServers:  counts[i].NumServers,
+			NumSessions: counts[i].NumSessions,
+		}
+		if buyer := database.BuyerMap[counts[i].BuyerId]; buyer != nil {
+			output.BuyerName = buyer.Name
+			output.BuyerCode = buyer.Code
+			output.TooOld = handlers.SDK_VersionTooOld(buyer, version)
+			output.UpgradeRecommended = handlers.SDK_VersionUpgradeRecommended(buyer, version)
+		}
+		response.SDKVersions = append(response.SDKVersions, output)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -751,10 +1292,11 @@ func portalServersHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type PortalServerDataResponse struct {
-	ServerData     PortalServerData    `json:"server_data"`
-	ServerSessions []PortalSessionData `json:"server_sessions"`
-	OutputPage     int                 `json:"output_page"`
-	NumPages       int                 `json:"num_pages"`
+	ServerData     PortalServerData     `json:"server_data"`
+	ServerHealth   *portal.ServerHealth `json:"server_health"`
+	ServerSessions []PortalSessionData  `json:"server_sessions"`
+	OutputPage     int                  `json:"output_page"`
+	NumPages       int                  `json:"num_pages"`
 }
 
 func portalServerDataHandler(w http.ResponseWriter, r *http.Request) {
@@ -804,6 +1346,8 @@ func portalServerDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	upgradeServer(database, serverData, &response.ServerData)
 
+	response.ServerHealth = portal.GetServerHealth(service.Context, redisPortalClient, serverId, time.Now().Unix()/60)
+
 	response.OutputPage = outputPage
 	response.NumPages = numPages
 
@@ -814,6 +1358,156 @@ func portalServerDataHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+type PortalWorstServerData struct {
+	ServerData   PortalServerData    `json:"server_data"`
+	ServerHealth portal.ServerHealth `json:"server_health"`
+}
+
+type PortalWorstServersResponse struct {
+	Servers []PortalWorstServerData `json:"servers"`
+}
+
+func portalWorstServersHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+
+	buyerCode := vars["buyer_code"]
+
+	count := portal.MaxWorstServers
+	countString := r.URL.Query().Get("count")
+	if countString != "" {
+		value, err := strconv.Atoi(countString)
+		if err != nil || value <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		count = value
+	}
+
+	database := service.Database()
+	if database == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	buyer := database.GetBuyerByCode(buyerCode)
+	if buyer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	worstServers := portal.GetWorstServers(service.Context, redisPortalClient, buyer.Id, time.Now().Unix()/60, count)
+
+	serverIds := make([]uint64, len(worstServers))
+	for i := range worstServers {
+		serverIds[i] = worstServers[i].ServerId
+	}
+
+	serverMap := make(map[uint64]*portal.ServerData)
+	servers := portal.GetServerList(service.Context, redisPortalClient, serverIds)
+	for i := range servers {
+		serverMap[servers[i].ServerId] = servers[i]
+	}
+
+	response := PortalWorstServersResponse{}
+	response.Servers = make([]PortalWorstServerData, len(worstServers))
+	for i := range worstServers {
+		response.Servers[i].ServerHealth = *worstServers[i]
+		serverData := serverMap[worstServers[i].ServerId]
+		if serverData != nil {
+			upgradeServer(database, serverData, &response.Servers[i].ServerData)
+		} else {
+			response.Servers[i].ServerData.ServerId = worstServers[i].ServerId
+			response.Servers[i].ServerData.BuyerId = buyer.Id
+			response.Servers[i].ServerData.BuyerName = buyer.Name
+			response.Servers[i].ServerData.BuyerCode = buyer.Code
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+const PortalStreamKeepAliveSeconds = 15
+
+// streamPortalEvents holds the request open as a server-sent event stream, writing each event published on
+// the redis channel as it arrives. a comment line is sent periodically so proxies don't close idle streams.
+
+func streamPortalEvents(w http.ResponseWriter, r *http.Request, channel string, eventName string) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	subscriber, err := portalEventHub.Subscribe(r.Context(), channel)
+	if err != nil {
+		core.Error("failed to subscribe to portal events on %s: %v", channel, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer portalEventHub.Unsubscribe(service.Context, subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(time.Second * PortalStreamKeepAliveSeconds)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-service.Context.Done():
+			return
+		case <-ticker.C:
+			portal.WriteKeepAlive(w)
+			flusher.Flush()
+		case data := <-subscriber.Events:
+			portal.WriteEvent(w, eventName, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func portalStreamSessionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionId, err := strconv.ParseUint(vars["session_id"], 16, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	streamPortalEvents(w, r, portal.SessionEventChannel(sessionId), "slice")
+}
+
+func portalStreamBuyerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database := service.Database()
+	if database == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	buyer := database.GetBuyerByCode(vars["buyer_code"])
+	if buyer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	streamPortalEvents(w, r, portal.BuyerEventChannel(buyer.Id), "route_decision")
+}
+
+func portalStreamRelaysHandler(w http.ResponseWriter, r *http.Request) {
+	streamPortalEvents(w, r, portal.EventChannel_Relays, "relay_status")
+}
+
 // --------------------------------------------------------------------------------------------------------------------
 
 type PortalRelayCountResponse struct {
@@ -836,6 +1530,12 @@ type PortalRelayData struct {
 	StartTime                           uint64   `json:"start_time,string"`
 	RelayFlags                          uint64   `json:"relay_flags,string"`
 	RelayVersion                        string   `json:"relay_version"`
+	CPUPercent                          float32  `json:"cpu_percent"`
+	SoftIRQPercent                      float32  `json:"softirq_percent"`
+	MemoryUsedMB                        uint32   `json:"memory_used_mb"`
+	MemoryTotalMB                       uint32   `json:"memory_total_mb"`
+	NICRxDropsPerSecond                 float32  `json:"nic_rx_drops_per_second"`
+	NICTxDropsPerSecond                 float32  `json:"nic_tx_drops_per_second"`
 	SellerId                            uint64   `json:"seller_id,string"`
 	SellerName                          string   `json:"seller_name"`
 	SellerCode                          string   `json:"seller_code"`
@@ -864,6 +1564,12 @@ func upgradeRelayData(database *db.Database, input *portal.RelayData, output *Po
 	output.StartTime = input.StartTime
 	output.RelayFlags = input.RelayFlags
 	output.RelayVersion = input.RelayVersion
+	output.CPUPercent = input.CPUPercent
+	output.SoftIRQPercent = input.SoftIRQPercent
+	output.MemoryUsedMB = input.MemoryUsedMB
+	output.MemoryTotalMB = input.MemoryTotalMB
+	output.NICRxDropsPerSecond = input.NICRxDropsPerSecond
+	output.NICTxDropsPerSecond = input.NICTxDropsPerSecond
 	currentTime := uint64(time.Now().Unix())
 	if database != nil {
 		relay := database.GetRelay(input.RelayId)
@@ -1366,14 +2072,77 @@ func portalMapDataHandler(w http.ResponseWriter, r *http.Request) {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
-func portalCostMatrixHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.WriteHeader(http.StatusOK)
-	data := common.LoadMasterServiceData(service.Context, redisRelayBackendClient, "relay_backend", "cost_matrix")
-	w.Write(data)
+type PortalHeatmapResponse struct {
+	Precision int                  `json:"precision"`
+	Cells     []portal.HeatmapCell `json:"cells"`
 }
 
-// ---------------------------------------------------------------------------------------------------------------------
+func portalHeatmapHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+
+	precision, err := strconv.Atoi(vars["precision"])
+	if err != nil || precision < portal.MinHeatmapPrecision || precision > portal.MaxHeatmapPrecision {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	database := service.Database()
+	if database == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	scope := ""
+	id := uint64(0)
+
+	if buyerCode, exists := vars["buyer_code"]; exists {
+		buyer := database.GetBuyerByCode(buyerCode)
+		if buyer == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		scope = "buyer"
+		id = buyer.Id
+	}
+
+	if datacenterName, exists := vars["datacenter_name"]; exists {
+		datacenter := database.GetDatacenterByName(datacenterName)
+		if datacenter == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		scope = "datacenter"
+		id = datacenter.Id
+	}
+
+	response := PortalHeatmapResponse{}
+	response.Precision = precision
+	response.Cells = portal.GetHeatmap(sessionCruncherURL, scope, id, precision)
+	if response.Cells == nil {
+		response.Cells = []portal.HeatmapCell{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func portalCostMatrixHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	var data []byte
+	if relayBackendLeaseStore != nil {
+		data = common.LoadLeaseLeaderServiceData(service.Context, relayBackendLeaseStore, relayBackendLeaseDataStore, "relay_backend", "cost_matrix")
+	} else {
+		data = common.LoadMasterServiceData(service.Context, redisRelayBackendClient, "relay_backend", "cost_matrix")
+	}
+	w.Write(data)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
 
 type PortalAdminDataResponse struct {
 	AcceleratedPercent_Timestamps []uint64  `json:"accelerated_percent_timestamps,string"`
@@ -1498,6 +2267,9 @@ func bash(command string) bool {
 }
 
 func adminCommitHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAuditActor(w, r) {
+		return
+	}
 	var request AdminCommitRequest
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
@@ -1550,6 +2322,16 @@ func adminCommitHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// IMPORTANT: the audit entry is written before the upload, so a database never goes live without one
+	var before json.RawMessage
+	if previous := service.Database(); previous != nil {
+		before, _ = json.Marshal(previous.GetHeader())
+	}
+	after, _ := json.Marshal(database.GetHeader())
+	if err := audit(r, "database", before, after); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	if !bash(fmt.Sprintf("gsutil cp %s %s", tempFileB, databaseURL)) {
 		core.Error("could not upload database.bin to database bucket")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -1577,7 +2359,7 @@ func adminCreateSellerHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	sellerId, err := controller.CreateSeller(&sellerData)
+	sellerId, err := requestController(r).CreateSeller(&sellerData)
 	if err != nil {
 		core.Error("failed to create seller: %v", err)
 		response.Error = err.Error()
@@ -1597,7 +2379,7 @@ type AdminReadSellersResponse struct {
 }
 
 func adminReadSellersHandler(w http.ResponseWriter, r *http.Request) {
-	sellers, err := controller.ReadSellers()
+	sellers, err := requestController(r).ReadSellers()
 	response := AdminReadSellersResponse{Sellers: sellers}
 	if err != nil {
 		core.Error("failed to read sellers: %v", err)
@@ -1621,7 +2403,7 @@ func adminReadSellerHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	seller, err := controller.ReadSeller(sellerId)
+	seller, err := requestController(r).ReadSeller(sellerId)
 	response := AdminReadSellerResponse{Seller: seller}
 	if err != nil {
 		response.Error = err.Error()
@@ -1645,7 +2427,7 @@ func adminUpdateSellerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := AdminUpdateSellerResponse{Seller: seller}
-	err = controller.UpdateSeller(&seller)
+	err = requestController(r).UpdateSeller(&seller)
 	if err != nil {
 		core.Error("failed to update seller: %v", err)
 		response.Error = err.Error()
@@ -1671,7 +2453,7 @@ func adminDeleteSellerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	core.Debug("delete seller %d", sellerId)
 	response := AdminDeleteSellerResponse{}
-	err = controller.DeleteSeller(sellerId)
+	err = requestController(r).DeleteSeller(sellerId)
 	if err != nil {
 		core.Error("failed to delete seller: %v", err)
 		response.Error = err.Error()
@@ -1697,7 +2479,7 @@ func adminCreateBuyerHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	buyerId, err := controller.CreateBuyer(&buyerData)
+	buyerId, err := requestController(r).CreateBuyer(&buyerData)
 	if err != nil {
 		core.Error("failed to create buyer: %v", err)
 		response.Error = err.Error()
@@ -1717,7 +2499,7 @@ type AdminReadBuyersResponse struct {
 }
 
 func adminReadBuyersHandler(w http.ResponseWriter, r *http.Request) {
-	buyers, err := controller.ReadBuyers()
+	buyers, err := requestController(r).ReadBuyers()
 	response := AdminReadBuyersResponse{Buyers: buyers}
 	if err != nil {
 		core.Error("failed to read buyers: %v", err)
@@ -1741,7 +2523,7 @@ func adminReadBuyerHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	buyer, err := controller.ReadBuyer(buyerId)
+	buyer, err := requestController(r).ReadBuyer(buyerId)
 	response := AdminReadBuyerResponse{Buyer: buyer}
 	if err != nil {
 		core.Error("failed to read buyer: %v", err)
@@ -1766,7 +2548,7 @@ func adminUpdateBuyerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := AdminUpdateBuyerResponse{Buyer: buyer}
-	err = controller.UpdateBuyer(&buyer)
+	err = requestController(r).UpdateBuyer(&buyer)
 	if err != nil {
 		core.Error("failed to update buyer: %v", err)
 		response.Error = err.Error()
@@ -1792,7 +2574,7 @@ func adminDeleteBuyerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	core.Debug("delete buyer %d", buyerId)
 	response := AdminDeleteBuyerResponse{}
-	err = controller.DeleteBuyer(buyerId)
+	err = requestController(r).DeleteBuyer(buyerId)
 	if err != nil {
 		core.Error("failed to delete buyer: %v", err)
 		response.Error = err.Error()
@@ -1818,7 +2600,7 @@ func adminCreateDatacenterHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	datacenterId, err := controller.CreateDatacenter(&datacenterData)
+	datacenterId, err := requestController(r).CreateDatacenter(&datacenterData)
 	if err != nil {
 		core.Error("failed to create datacenter: %v", err)
 		response.Error = err.Error()
@@ -1838,7 +2620,7 @@ type AdminReadDatacentersResponse struct {
 }
 
 func adminReadDatacentersHandler(w http.ResponseWriter, r *http.Request) {
-	datacenters, err := controller.ReadDatacenters()
+	datacenters, err := requestController(r).ReadDatacenters()
 	response := AdminReadDatacentersResponse{Datacenters: datacenters}
 	if err != nil {
 		core.Error("failed to read datacenters: %v", err)
@@ -1864,7 +2646,7 @@ func adminReadDatacenterHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	datacenter, err := controller.ReadDatacenter(datacenterId)
+	datacenter, err := requestController(r).ReadDatacenter(datacenterId)
 	response := AdminReadDatacenterResponse{Datacenter: datacenter}
 	if err != nil {
 		core.Error("failed to read datacenter: %v", err)
@@ -1891,7 +2673,7 @@ func adminUpdateDatacenterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := AdminUpdateDatacenterResponse{Datacenter: datacenter}
-	err = controller.UpdateDatacenter(&datacenter)
+	err = requestController(r).UpdateDatacenter(&datacenter)
 	if err != nil {
 		core.Error("failed to update datacenter: %v", err)
 		response.Error = err.Error()
@@ -1917,7 +2699,7 @@ func adminDeleteDatacenterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	core.Debug("delete datacenter %d", datacenterId)
 	response := AdminDeleteDatacenterResponse{}
-	err = controller.DeleteDatacenter(datacenterId)
+	err = requestController(r).DeleteDatacenter(datacenterId)
 	if err != nil {
 		core.Error("failed to delete datacenter: %v", err)
 		response.Error = err.Error()
@@ -1943,7 +2725,7 @@ func adminCreateRelayHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	relayId, err := controller.CreateRelay(&relayData)
+	relayId, err := requestController(r).CreateRelay(&relayData)
 	if err != nil {
 		core.Error("failed to create relay: %v", err)
 		response.Error = err.Error()
@@ -1963,7 +2745,7 @@ type AdminReadRelaysResponse struct {
 }
 
 func adminReadRelaysHandler(w http.ResponseWriter, r *http.Request) {
-	relays, err := controller.ReadRelays()
+	relays, err := requestController(r).ReadRelays()
 	response := AdminReadRelaysResponse{Relays: relays}
 	if err != nil {
 		core.Error("failed to read relays: %v", err)
@@ -1989,7 +2771,7 @@ func adminReadRelayHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	relay, err := controller.ReadRelay(relayId)
+	relay, err := requestController(r).ReadRelay(relayId)
 	response := AdminReadRelayResponse{Relay: relay}
 	if err != nil {
 		core.Error("failed to read relay: %v", err)
@@ -2016,7 +2798,7 @@ func adminUpdateRelayHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := AdminUpdateRelayResponse{Relay: relay}
-	err = controller.UpdateRelay(&relay)
+	err = requestController(r).UpdateRelay(&relay)
 	if err != nil {
 		core.Error("failed to update relay: %v", err)
 		response.Error = err.Error()
@@ -2042,7 +2824,7 @@ func adminDeleteRelayHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	core.Debug("delete relay %d", relayId)
 	response := AdminDeleteRelayResponse{}
-	err = controller.DeleteRelay(relayId)
+	err = requestController(r).DeleteRelay(relayId)
 	if err != nil {
 		core.Error("failed to delete relay: %v", err)
 		response.Error = err.Error()
@@ -2068,7 +2850,7 @@ func adminCreateRouteShaderHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	routeShaderId, err := controller.CreateRouteShader(&routeShaderData)
+	routeShaderId, err := requestController(r).CreateRouteShader(&routeShaderData)
 	if err != nil {
 		core.Error("failed to create route shader: %v", err)
 		response.Error = err.Error()
@@ -2088,7 +2870,7 @@ type AdminReadRouteShadersResponse struct {
 }
 
 func adminReadRouteShadersHandler(w http.ResponseWriter, r *http.Request) {
-	routeShaders, err := controller.ReadRouteShaders()
+	routeShaders, err := requestController(r).ReadRouteShaders()
 	response := AdminReadRouteShadersResponse{RouteShaders: routeShaders}
 	if err != nil {
 		core.Error("failed to read route shaders: %v", err)
@@ -2114,7 +2896,7 @@ func adminReadRouteShaderHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	routeShader, err := controller.ReadRouteShader(routeShaderId)
+	routeShader, err := requestController(r).ReadRouteShader(routeShaderId)
 	response := AdminReadRouteShaderResponse{RouteShader: routeShader}
 	if err != nil {
 		core.Error("failed to read route shader: %v", err)
@@ -2141,7 +2923,7 @@ func adminUpdateRouteShaderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := AdminUpdateRouteShaderResponse{RouteShader: routeShader}
-	err = controller.UpdateRouteShader(&routeShader)
+	err = requestController(r).UpdateRouteShader(&routeShader)
 	if err != nil {
 		core.Error("failed to update route shader: %v", err)
 		response.Error = err.Error()
@@ -2167,7 +2949,7 @@ func adminDeleteRouteShaderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	core.Debug("delete route shader %d", routeShaderId)
 	response := AdminDeleteRouteShaderResponse{}
-	err = controller.DeleteRouteShader(routeShaderId)
+	err = requestController(r).DeleteRouteShader(routeShaderId)
 	if err != nil {
 		core.Error("failed to delete route shader: %v", err)
 		response.Error = err.Error()
@@ -2193,7 +2975,7 @@ func adminCreateBuyerDatacenterSettingsHandler(w http.ResponseWriter, r *http.Re
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = controller.CreateBuyerDatacenterSettings(&settings)
+	err = requestController(r).CreateBuyerDatacenterSettings(&settings)
 	if err != nil {
 		core.Error("failed to create buyer datacenter settings: %v", err)
 		response.Error = err.Error()
@@ -2214,7 +2996,7 @@ type AdminReadBuyerDatacenterSettingsListResponse struct {
 }
 
 func adminReadBuyerDatacenterSettingsListHandler(w http.ResponseWriter, r *http.Request) {
-	buyerDatacenterSettings, err := controller.ReadBuyerDatacenterSettingsList()
+	buyerDatacenterSettings, err := requestController(r).ReadBuyerDatacenterSettingsList()
 	response := AdminReadBuyerDatacenterSettingsListResponse{Settings: buyerDatacenterSettings}
 	if err != nil {
 		response.Error = err.Error()
@@ -2242,7 +3024,7 @@ func adminReadBuyerDatacenterSettingsHandler(w http.ResponseWriter, r *http.Requ
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	settings, err := controller.ReadBuyerDatacenterSettings(buyerId, datacenterId)
+	settings, err := requestController(r).ReadBuyerDatacenterSettings(buyerId, datacenterId)
 	response := AdminReadBuyerDatacenterSettingsResponse{Settings: settings}
 	if err != nil {
 		core.Error("failed to read buyer datacenter settings: %v", err)
@@ -2269,7 +3051,7 @@ func adminUpdateBuyerDatacenterSettingsHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	response := AdminUpdateBuyerDatacenterSettingsResponse{Settings: settings}
-	err = controller.UpdateBuyerDatacenterSettings(&settings)
+	err = requestController(r).UpdateBuyerDatacenterSettings(&settings)
 	if err != nil {
 		core.Error("failed to update buyer datacenter settings: %v", err)
 		response.Error = err.Error()
@@ -2299,7 +3081,7 @@ func adminDeleteBuyerDatacenterSettingsHandler(w http.ResponseWriter, r *http.Re
 	}
 	core.Debug("delete buyer datacenter settings %d.%d", buyerId, datacenterId)
 	response := AdminDeleteBuyerDatacenterSettingsResponse{}
-	err = controller.DeleteBuyerDatacenterSettings(buyerId, datacenterId)
+	err = requestController(r).DeleteBuyerDatacenterSettings(buyerId, datacenterId)
 	if err != nil {
 		core.Error("failed to delete buyer datacenter settings: %v", err)
 		response.Error = err.Error()
@@ -2311,6 +3093,124 @@ func adminDeleteBuyerDatacenterSettingsHandler(w http.ResponseWriter, r *http.Re
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+type AdminCreateBuyerKeyResponse struct {
+	BuyerKey admin.BuyerKeyData `json:"buyer_key"`
+	Error    string             `json:"error"`
+}
+
+func adminCreateBuyerKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var response AdminCreateBuyerKeyResponse
+	var buyerKeyData admin.BuyerKeyData
+	err := json.NewDecoder(r.Body).Decode(&buyerKeyData)
+	if err != nil {
+		core.Error("failed to read buyer key data in create buyer key request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	buyerKeyId, err := requestController(r).CreateBuyerKey(&buyerKeyData)
+	if err != nil {
+		core.Error("failed to create buyer key: %v", err)
+		response.Error = err.Error()
+	} else {
+		buyerKeyData.BuyerKeyId = buyerKeyId
+		core.Debug("create buyer key %d -> %+v", buyerKeyId, buyerKeyData)
+		response.BuyerKey = buyerKeyData
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminReadBuyerKeysResponse struct {
+	BuyerKeys []admin.BuyerKeyData `json:"buyer_keys"`
+	Error     string               `json:"error"`
+}
+
+func adminReadBuyerKeysHandler(w http.ResponseWriter, r *http.Request) {
+	buyerKeys, err := requestController(r).ReadBuyerKeys()
+	response := AdminReadBuyerKeysResponse{BuyerKeys: buyerKeys}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminReadBuyerKeyResponse struct {
+	BuyerKey admin.BuyerKeyData `json:"buyer_key"`
+	Error    string             `json:"error"`
+}
+
+func adminReadBuyerKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	buyerKeyId, err := strconv.ParseUint(vars["buyerKeyId"], 10, 64)
+	if err != nil {
+		core.Error("read buyer key could not parse buyer key id")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	buyerKey, err := requestController(r).ReadBuyerKey(buyerKeyId)
+	response := AdminReadBuyerKeyResponse{BuyerKey: buyerKey}
+	if err != nil {
+		core.Error("failed to read buyer key: %v", err)
+		response.Error = err.Error()
+	}
+	core.Debug("read buyer key %d -> %+v", buyerKeyId, buyerKey)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminUpdateBuyerKeyResponse struct {
+	BuyerKey admin.BuyerKeyData `json:"buyer_key"`
+	Error    string             `json:"error"`
+}
+
+func adminUpdateBuyerKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var buyerKey admin.BuyerKeyData
+	err := json.NewDecoder(r.Body).Decode(&buyerKey)
+	if err != nil {
+		core.Error("failed to decode update buyer key request json: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	response := AdminUpdateBuyerKeyResponse{BuyerKey: buyerKey}
+	err = requestController(r).UpdateBuyerKey(&buyerKey)
+	if err != nil {
+		core.Error("failed to update buyer key: %v", err)
+		response.Error = err.Error()
+	} else {
+		core.Debug("update buyer key %d -> %+v", buyerKey.BuyerKeyId, buyerKey)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminDeleteBuyerKeyResponse struct {
+	Error string `json:"error"`
+}
+
+func adminDeleteBuyerKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	buyerKeyId, err := strconv.ParseUint(vars["buyerKeyId"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	core.Debug("delete buyer key %d", buyerKeyId)
+	response := AdminDeleteBuyerKeyResponse{}
+	err = requestController(r).DeleteBuyerKey(buyerKeyId)
+	if err != nil {
+		core.Error("failed to delete buyer key: %v", err)
+		response.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
 type AdminCreateRelayKeypairResponse struct {
 	RelayKeypair admin.RelayKeypairData `json:"relay_keypair"`
 	Error        string                 `json:"error"`
@@ -2318,7 +3218,7 @@ type AdminCreateRelayKeypairResponse struct {
 
 func adminCreateRelayKeypairHandler(w http.ResponseWriter, r *http.Request) {
 	var response AdminCreateRelayKeypairResponse
-	relayKeypairData, err := controller.CreateRelayKeypair()
+	relayKeypairData, err := requestController(r).CreateRelayKeypair()
 	if err != nil {
 		core.Error("failed to create relay keypair: %v", err)
 		response.Error = err.Error()
@@ -2337,7 +3237,7 @@ type AdminReadRelayKeypairsResponse struct {
 }
 
 func adminReadRelayKeypairsHandler(w http.ResponseWriter, r *http.Request) {
-	relayKeypairs, err := controller.ReadRelayKeypairs()
+	relayKeypairs, err := requestController(r).ReadRelayKeypairs()
 	response := AdminReadRelayKeypairsResponse{RelayKeypairs: relayKeypairs}
 	if err != nil {
 		core.Error("failed to read relay keypairs: %v", err)
@@ -2363,7 +3263,7 @@ func adminReadRelayKeypairHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	relayKeypair, err := controller.ReadRelayKeypair(relayKeypairId)
+	relayKeypair, err := requestController(r).ReadRelayKeypair(relayKeypairId)
 	response := AdminReadRelayKeypairResponse{RelayKeypair: relayKeypair}
 	if err != nil {
 		core.Error("failed to read relay keypair: %v", err)
@@ -2390,7 +3290,7 @@ func adminUpdateRelayKeypairHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := AdminUpdateRelayKeypairResponse{RelayKeypair: relayKeypair}
-	err = controller.UpdateRelayKeypair(&relayKeypair)
+	err = requestController(r).UpdateRelayKeypair(&relayKeypair)
 	if err != nil {
 		core.Error("failed to update relay keypair: %v", err)
 		response.Error = err.Error()
@@ -2416,7 +3316,7 @@ func adminDeleteRelayKeypairHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	core.Debug("delete relay keypair %d", relayKeypairId)
 	response := AdminDeleteRelayKeypairResponse{}
-	err = controller.DeleteRelayKeypair(relayKeypairId)
+	err = requestController(r).DeleteRelayKeypair(relayKeypairId)
 	if err != nil {
 		core.Error("failed to delete relay keypair: %v", err)
 		response.Error = err.Error()
@@ -2428,10 +3328,559 @@ func adminDeleteRelayKeypairHandler(w http.ResponseWriter, r *http.Request) {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+func rolloutController(ctx context.Context) {
+
+	ticker := time.NewTicker(10 * time.Second)
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+
+			database := service.Database()
+			if database == nil {
+				break
+			}
+
+			health, err := common.LoadRolloutRelayHealth(ctx, redisRelayBackendClient)
+			if err != nil {
+				core.Warn("could not load rollout health: %v", err)
+				break
+			}
+
+			var completed *common.Rollout
+
+			err = common.ModifyRollout(ctx, redisRelayBackendClient, func(rollout *common.Rollout) (*common.Rollout, error) {
+				if rollout == nil {
+					return nil, nil
+				}
+				previousStage := rollout.Stage
+				previousStageString := rollout.StageString()
+				if rollout.Update(time.Now().Unix(), database.Relays, health) {
+					if rollout.Paused {
+						core.Warn("rollout to %s paused: %s", rollout.TargetVersion, rollout.PauseReason)
+					} else if rollout.StageString() != previousStageString {
+						core.Log("rollout to %s advanced to stage %s", rollout.TargetVersion, rollout.StageString())
+					}
+					if rollout.Stage == common.RolloutStage_Complete && previousStage != common.RolloutStage_Complete {
+						completed = rollout
+					}
+				}
+				return rollout, nil
+			})
+			if err != nil {
+				core.Warn("could not update rollout: %v", err)
+				break
+			}
+
+			// once the rollout is complete, write the target version back to postgres for the relays that were seen running it,
+			// so the next database commit keeps it. a new rollout can't start until that commit is made
+
+			if completed != nil {
+				updateRelayVersions(completed)
+			}
+		}
+	}
+}
+
+func updateRelayVersions(rollout *common.Rollout) {
+	relays, err := controller.ReadRelays()
+	if err != nil {
+		core.Error("could not read relays to update versions: %v", err)
+		return
+	}
+	numUpdated := 0
+	for i := range relays {
+		if !rollout.Upgraded[relays[i].RelayName] || relays[i].Version == rollout.TargetVersion {
+			continue
+		}
+		if err := updateRelayVersion(&relays[i], rollout.TargetVersion); err != nil {
+			core.Error("could not update version for relay %s: %v", relays[i].RelayName, err)
+			continue
+		}
+		numUpdated++
+	}
+	core.Log("rollout to %s complete. updated %d relay versions in postgres. commit the database to finish", rollout.TargetVersion, numUpdated)
+}
+
+// each relay version is written with its audit entry in one transaction, the same as an audited admin mutation
+
+func updateRelayVersion(relay *admin.RelayData, version string) error {
+	before, _ := json.Marshal(relay)
+	relay.Version = version
+	after, _ := json.Marshal(relay)
+	transaction, err := controller.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	if err := transaction.UpdateRelay(relay); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	entry := admin.AuditEntry{
+		Timestamp: time.Now().Unix(),
+		Actor:     RolloutAuditActor,
+		Endpoint:  "rollout",
+		Entity:    "relay",
+		Before:    redactAuditJSON(before),
+		After:     redactAuditJSON(after),
+	}
+	if _, err := transaction.CreateAuditEntry(&entry); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+type AdminRolloutRelay struct {
+	RelayName     string  `json:"relay_name"`
+	SellerCode    string  `json:"seller_code"`
+	Upgraded      bool    `json:"upgraded"`
+	TargetVersion string  `json:"target_version"`
+	RelayVersion  string  `json:"relay_version"`
+	Online        bool    `json:"online"`
+	RTT           float32 `json:"rtt"`
+	ErrorRate     float32 `json:"error_rate"`
+}
+
+type AdminRolloutsResponse struct {
+	Rollout          *common.Rollout     `json:"rollout"`
+	Stage            string              `json:"stage"`
+	NumRelays        int                 `json:"num_relays"`
+	NumUpgraded      int                 `json:"num_upgraded"`
+	NumRunningTarget int                 `json:"num_running_target"`
+	NumOffline       int                 `json:"num_offline"`
+	Relays           []AdminRolloutRelay `json:"relays"`
+	Error            string              `json:"error"`
+}
+
+func adminRolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	response := AdminRolloutsResponse{}
+	rollout, err := common.LoadRollout(r.Context(), redisRelayBackendClient)
+	if err != nil {
+		core.Error("failed to load rollout: %v", err)
+		response.Error = err.Error()
+	}
+	database := service.Database()
+	if rollout != nil && database != nil {
+		health, err := common.LoadRolloutRelayHealth(r.Context(), redisRelayBackendClient)
+		if err != nil {
+			core.Error("failed to load rollout health: %v", err)
+			response.Error = err.Error()
+		}
+		currentTime := time.Now().Unix()
+		response.Rollout = rollout
+		response.Stage = rollout.StageString()
+		response.NumRelays = len(database.Relays)
+		response.Relays = make([]AdminRolloutRelay, len(database.Relays))
+		for i := range database.Relays {
+			relay := &database.Relays[i]
+			relayHealth, ok := health[relay.Name]
+			online := ok && int64(relayHealth.Timestamp) >= currentTime-common.RolloutHealthTimeout
+			response.Relays[i] = AdminRolloutRelay{
+				RelayName:     relay.Name,
+				Upgraded:      rollout.IsRelayUpgraded(relay),
+				TargetVersion: rollout.GetTargetVersion(relay),
+				Online:        online,
+			}
+			if relay.Seller != nil {
+				response.Relays[i].SellerCode = relay.Seller.Code
+			}
+			if response.Relays[i].Upgraded {
+				response.NumUpgraded++
+			}
+			if online {
+				response.Relays[i].RelayVersion = relayHealth.RelayVersion
+				response.Relays[i].RTT = relayHealth.RTT
+				response.Relays[i].ErrorRate = relayHealth.ErrorRate
+				if relayHealth.RelayVersion == rollout.TargetVersion {
+					response.NumRunningTarget++
+				}
+			} else {
+				response.NumOffline++
+			}
+		}
+		sort.SliceStable(response.Relays, func(i, j int) bool { return response.Relays[i].RelayName < response.Relays[j].RelayName })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminStartRolloutRequest struct {
+	TargetVersion        string   `json:"target_version"`
+	CanaryRelays         []string `json:"canary_relays"`
+	Percent              int      `json:"percent"`
+	Sellers              []string `json:"sellers"`
+	SoakTime             int64    `json:"soak_time"`
+	MaxRTTIncrease       float32  `json:"max_rtt_increase"`
+	MaxErrorRateIncrease float32  `json:"max_error_rate_increase"`
+}
+
+type AdminRolloutResponse struct {
+	Rollout *common.Rollout `json:"rollout"`
+	Error   string          `json:"error"`
+}
+
+func writeAdminRolloutResponse(w http.ResponseWriter, rollout *common.Rollout, err error) {
+	response := AdminRolloutResponse{}
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Rollout = rollout
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// modifyRolloutAudited checks a rollout change against the current rollout, but only writes it to redis once the audit entry has
+// committed. The rollout controller may have moved the rollout on in between, so the change is applied again to the rollout as
+// it is then. Returns the rollout after the change, for the audit log.
+
+func modifyRolloutAudited(r *http.Request, description string, modify func(rollout *common.Rollout) (*common.Rollout, error)) (*common.Rollout, error) {
+	rollout, err := common.LoadRollout(r.Context(), redisRelayBackendClient)
+	if err != nil {
+		return nil, err
+	}
+	rollout, err = modify(rollout)
+	if err != nil {
+		return nil, err
+	}
+	afterCommit(r, func() {
+		if err := common.ModifyRollout(r.Context(), redisRelayBackendClient, modify); err != nil {
+			core.Error("failed to modify rollout after audit: %v", err)
+			return
+		}
+		core.Log("%s", description)
+	})
+	return rollout, nil
+}
+
+func adminStartRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	var request AdminStartRolloutRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		core.Error("failed to read start rollout request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	database := service.Database()
+	if database == nil {
+		writeAdminRolloutResponse(w, nil, fmt.Errorf("database is not loaded"))
+		return
+	}
+	if request.TargetVersion == "" {
+		writeAdminRolloutResponse(w, nil, fmt.Errorf("rollout must have a target version"))
+		return
+	}
+	if request.Percent < 0 || request.Percent > 100 {
+		writeAdminRolloutResponse(w, nil, fmt.Errorf("rollout percent must be in [0,100]"))
+		return
+	}
+	for _, relayName := range request.CanaryRelays {
+		if !slices.ContainsFunc(database.Relays, func(relay db.Relay) bool { return relay.Name == relayName }) {
+			writeAdminRolloutResponse(w, nil, fmt.Errorf("unknown canary relay '%s'", relayName))
+			return
+		}
+	}
+	for _, sellerCode := range request.Sellers {
+		if _, exists := database.SellerCodeMap[sellerCode]; !exists {
+			writeAdminRolloutResponse(w, nil, fmt.Errorf("unknown seller '%s'", sellerCode))
+			return
+		}
+	}
+	health, err := common.LoadRolloutRelayHealth(r.Context(), redisRelayBackendClient)
+	if err != nil {
+		writeAdminRolloutResponse(w, nil, err)
+		return
+	}
+	rollout, err := modifyRolloutAudited(r, fmt.Sprintf("started rollout to %s", request.TargetVersion), func(rollout *common.Rollout) (*common.Rollout, error) {
+		if rollout != nil && rollout.Stage != common.RolloutStage_Complete {
+			return nil, fmt.Errorf("rollout to %s is already in progress", rollout.TargetVersion)
+		}
+		if rollout != nil && !rollout.IsCommitted(database.Relays) {
+			return nil, fmt.Errorf("rollout to %s is complete, but its relay versions are not in the committed database yet. commit the database first", rollout.TargetVersion)
+		}
+		currentTime := time.Now().Unix()
+		rollout = &common.Rollout{
+			TargetVersion:        request.TargetVersion,
+			CanaryRelays:         request.CanaryRelays,
+			Percent:              request.Percent,
+			Sellers:              request.Sellers,
+			SoakTime:             request.SoakTime,
+			MaxRTTIncrease:       request.MaxRTTIncrease,
+			MaxErrorRateIncrease: request.MaxErrorRateIncrease,
+			StartTime:            currentTime,
+			StageStartTime:       currentTime,
+		}
+		rollout.TakeBaselines(currentTime, database.Relays, health)
+		return rollout, nil
+	})
+	writeAdminRolloutResponse(w, rollout, err)
+}
+
+func adminPauseRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	rollout, err := modifyRolloutAudited(r, "paused rollout", func(rollout *common.Rollout) (*common.Rollout, error) {
+		if rollout == nil || rollout.Stage == common.RolloutStage_Complete {
+			return rollout, fmt.Errorf("no rollout in progress")
+		}
+		rollout.Paused = true
+		rollout.PauseReason = "paused by operator"
+		return rollout, nil
+	})
+	writeAdminRolloutResponse(w, rollout, err)
+}
+
+func adminResumeRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	health, err := common.LoadRolloutRelayHealth(r.Context(), redisRelayBackendClient)
+	if err != nil {
+		writeAdminRolloutResponse(w, nil, err)
+		return
+	}
+	rollout, err := modifyRolloutAudited(r, "resumed rollout", func(rollout *common.Rollout) (*common.Rollout, error) {
+		if rollout == nil || !rollout.Paused {
+			return rollout, fmt.Errorf("no paused rollout")
+		}
+		rollout.Resume(time.Now().Unix(), health)
+		return rollout, nil
+	})
+	writeAdminRolloutResponse(w, rollout, err)
+}
+
+func adminAbortRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	rollout, err := modifyRolloutAudited(r, "aborted rollout", func(rollout *common.Rollout) (*common.Rollout, error) {
+		if rollout == nil {
+			return nil, fmt.Errorf("no rollout")
+		}
+		return nil, nil
+	})
+	writeAdminRolloutResponse(w, rollout, err)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type AdminCreateRelayBootstrapTokenRequest struct {
+	SellerId     uint64 `json:"seller_id,string"`
+	DatacenterId uint64 `json:"datacenter_id,string"`
+	ExpiryHours  int    `json:"expiry_hours"`
+}
+
+type AdminCreateRelayBootstrapTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+	Error     string `json:"error"`
+}
+
+func adminCreateRelayBootstrapTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var request AdminCreateRelayBootstrapTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		core.Error("failed to read create relay bootstrap token request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	response := AdminCreateRelayBootstrapTokenResponse{}
+	datacenter, err := requestController(r).ReadDatacenter(request.DatacenterId)
+	if err != nil {
+		response.Error = err.Error()
+	} else if request.SellerId != 0 && request.SellerId != datacenter.SellerId {
+		response.Error = fmt.Sprintf("datacenter %s does not belong to seller %d", datacenter.DatacenterName, request.SellerId)
+	} else {
+		if request.ExpiryHours <= 0 {
+			request.ExpiryHours = 24
+		}
+		expiry := time.Duration(request.ExpiryHours) * time.Hour
+		response.Token, err = common.CreateRelayBootstrapToken(r.Context(), redisRelayBackendClient, datacenter.SellerId, datacenter.DatacenterId, expiry)
+		if err != nil {
+			core.Error("failed to create relay bootstrap token: %v", err)
+			response.Error = err.Error()
+		} else {
+			token := response.Token
+			onRollback(r, func() {
+				if err := common.DeleteRelayBootstrapToken(service.Context, redisRelayBackendClient, token); err != nil {
+					core.Error("failed to delete relay bootstrap token: %v", err)
+				}
+			})
+			response.ExpiresAt = time.Now().Add(expiry).Unix()
+			core.Log("created relay bootstrap token for datacenter %s", datacenter.DatacenterName)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminPendingRelaysResponse struct {
+	PendingRelays []common.PendingRelay `json:"pending_relays"`
+	Error         string                `json:"error"`
+}
+
+func adminPendingRelaysHandler(w http.ResponseWriter, r *http.Request) {
+	response := AdminPendingRelaysResponse{}
+	pendingRelays, err := common.LoadPendingRelays(r.Context(), redisRelayBackendClient)
+	if err != nil {
+		core.Error("failed to load pending relays: %v", err)
+		response.Error = err.Error()
+	} else {
+		for i := range pendingRelays {
+			pendingRelays[i].PrivateKeyBase64 = ""
+		}
+		sort.Slice(pendingRelays, func(i, j int) bool { return pendingRelays[i].RequestedAt < pendingRelays[j].RequestedAt })
+		response.PendingRelays = pendingRelays
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminPendingRelayResponse struct {
+	RelayId   uint64 `json:"relay_id,string"`
+	RelayName string `json:"relay_name"`
+	Error     string `json:"error"`
+}
+
+// approving a pending relay creates it in the database. commit the database as usual for the relay to go live
+
+func approvePendingRelay(r *http.Request, registrationId string) (uint64, string, error) {
+	pendingRelay, err := common.ClaimPendingRelay(r.Context(), redisRelayBackendClient, registrationId)
+	if err != nil {
+		return 0, "", err
+	}
+	relayId, relayName, err := createPendingRelay(r, pendingRelay)
+	if err != nil {
+		if releaseErr := common.ReleasePendingRelay(r.Context(), redisRelayBackendClient, pendingRelay); releaseErr != nil {
+			core.Error("failed to release pending relay %s: %v", registrationId, releaseErr)
+		}
+		return 0, "", err
+	}
+	claimedRelay := *pendingRelay
+	onRollback(r, func() { releaseClaimedRelay(&claimedRelay) })
+	pendingRelay.Status = common.PendingRelayStatus_Approved
+	pendingRelay.RelayId = relayId
+	pendingRelay.RelayName = relayName
+	afterCommit(r, func() { finishClaimedRelay(pendingRelay) })
+	core.Log("approved pending relay %s -> %s", registrationId, relayName)
+	return relayId, relayName, nil
+}
+
+// the claim stops the relay being approved twice while the transaction is open. it is released if the transaction
+// rolls back, and the relay is only marked approved or rejected in redis once the transaction has committed
+
+func releaseClaimedRelay(pendingRelay *common.PendingRelay) {
+	if err := common.ReleasePendingRelay(service.Context, redisRelayBackendClient, pendingRelay); err != nil {
+		core.Error("failed to release pending relay %s: %v", pendingRelay.RegistrationId, err)
+	}
+}
+
+func finishClaimedRelay(pendingRelay *common.PendingRelay) {
+	if err := common.FinishPendingRelay(service.Context, redisRelayBackendClient, pendingRelay); err != nil {
+		core.Error("failed to finish pending relay %s: %v", pendingRelay.RegistrationId, err)
+	}
+}
+
+func createPendingRelay(r *http.Request, pendingRelay *common.PendingRelay) (uint64, string, error) {
+	datacenter, err := requestController(r).ReadDatacenter(pendingRelay.DatacenterId)
+	if err != nil {
+		return 0, "", err
+	}
+	publicAddress, err := net.ResolveUDPAddr("udp", pendingRelay.PublicAddress)
+	if err != nil {
+		return 0, "", err
+	}
+	privateKey, err := envvar.DecryptSecret(pendingRelay.PrivateKeyBase64, envvar.GetSecretsMasterKey())
+	if err != nil {
+		return 0, "", fmt.Errorf("could not decrypt relay private key: %v", err)
+	}
+	relayData := admin.RelayData{
+		RelayName:        pendingRelay.RelayName,
+		DatacenterId:     pendingRelay.DatacenterId,
+		PublicIP:         publicAddress.IP.String(),
+		PublicPort:       publicAddress.Port,
+		InternalIP:       "0.0.0.0",
+		SSH_IP:           "0.0.0.0",
+		SSH_Port:         22,
+		SSH_User:         "root",
+		PublicKeyBase64:  pendingRelay.PublicKeyBase64,
+		PrivateKeyBase64: privateKey,
+		PortSpeed:        1000,
+		Notes:            fmt.Sprintf("bootstrapped %s", time.Now().UTC().Format(time.RFC3339)),
+	}
+	if relayData.RelayName == "" {
+		relayData.RelayName = fmt.Sprintf("%s.%s", datacenter.DatacenterName, pendingRelay.RegistrationId[:8])
+	}
+	if pendingRelay.InternalAddress != "" {
+		internalAddress, err := net.ResolveUDPAddr("udp", pendingRelay.InternalAddress)
+		if err != nil {
+			return 0, "", err
+		}
+		relayData.InternalIP = internalAddress.IP.String()
+		relayData.InternalPort = internalAddress.Port
+	}
+	relayId, err := requestController(r).CreateRelay(&relayData)
+	if err != nil {
+		return 0, "", err
+	}
+	return relayId, relayData.RelayName, nil
+}
+
+func adminApprovePendingRelayHandler(w http.ResponseWriter, r *http.Request) {
+	response := AdminPendingRelayResponse{}
+	relayId, relayName, err := approvePendingRelay(r, mux.Vars(r)["registrationId"])
+	if err != nil {
+		core.Error("failed to approve pending relay: %v", err)
+		response.Error = err.Error()
+	} else {
+		response.RelayId = relayId
+		response.RelayName = relayName
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+type AdminRejectPendingRelayRequest struct {
+	Reason string `json:"reason"`
+}
+
+func adminRejectPendingRelayHandler(w http.ResponseWriter, r *http.Request) {
+	var request AdminRejectPendingRelayRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	response := AdminPendingRelayResponse{}
+	registrationId := mux.Vars(r)["registrationId"]
+	pendingRelay, err := common.ClaimPendingRelay(r.Context(), redisRelayBackendClient, registrationId)
+	if err == nil {
+		claimedRelay := *pendingRelay
+		onRollback(r, func() { releaseClaimedRelay(&claimedRelay) })
+		pendingRelay.Status = common.PendingRelayStatus_Rejected
+		pendingRelay.Reason = request.Reason
+		afterCommit(r, func() { finishClaimedRelay(pendingRelay) })
+	}
+	if err != nil {
+		core.Error("failed to reject pending relay: %v", err)
+		response.Error = err.Error()
+	} else {
+		core.Log("rejected pending relay %s", registrationId)
+		response.RelayName = pendingRelay.RelayName
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
 func databaseJSONHandler(w http.ResponseWriter, r *http.Request) {
 	database := service.Database()
+	if database == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(database)
+	json.NewEncoder(w).Encode(database.Redacted())
 }
 
 func databaseBinaryHandler(w http.ResponseWriter, r *http.Request) {
@@ -2495,7 +3944,7 @@ func databaseRelaysHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	response := database.GetRelays()
+	response := database.Redacted().GetRelays()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -2554,6 +4003,16 @@ func debugRelayHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	proxy(fmt.Sprintf("%s/relay_history/%s/%s", relayBackendURL, vars["src"], vars["dest"]), w, r)
 }
 
+func adminMatrixSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxy(fmt.Sprintf("%s/matrix_snapshots/%s", relayBackendURL, vars["name"]), w, r)
+}
+
+func adminMatrixSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxy(fmt.Sprintf("%s/matrix_snapshot/%s/%s", relayBackendURL, vars["name"], vars["timestamp"]), w, r)
+}
+
 func debugRoutesHandler(w http.ResponseWriter, r *http.Request) {
 
 	routeMatrix, _ := service.RouteMatrixAndDatabase()
@@ -2726,3 +4185,33 @@ func debugSessionsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type AdminAuditResponse struct {
+	Entries []admin.AuditEntry `json:"entries"`
+	Error   string             `json:"error"`
+}
+
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since := int64(0)
+	if value := query.Get("since"); value != "" {
+		var err error
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			core.Error("audit could not parse since: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := controller.ReadAuditLog(since, query.Get("actor"), query.Get("entity"))
+	response := AdminAuditResponse{Entries: entries}
+	if err != nil {
+		core.Error("failed to read audit log: %v", err)
+		response.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -321,7 +321,9 @@ func RelayUpdateHandler(writer http.ResponseWriter, request *http.Request) {
 		requestPacket.SampleRTT[:numSamples],
 		requestPacket.SampleJitter[:numSamples],
 		requestPacket.SamplePacketLoss[:numSamples],
+		requestPacket.GetSampleMTU(),
 		requestPacket.RelayCounters[:],
+		requestPacket.GetHostMetrics(),
 	)
 
 	if backend.mode == BACKEND_MODE_ZERO_MAGIC {
@@ -481,6 +483,7 @@ func CostMatrixHandler(writer http.ResponseWriter, request *http.Request) {
 		DestRelays:         destRelays,
 		Costs:              costs,
 		RelayPrice:         relayPrice,
+		RelayMTU:           backend.relayManager.GetMTUs(currentTime, relayIds),
 	}
 
 	costMatrixData, err := costMatrix.Write()
@@ -648,6 +651,7 @@ func ProcessServerInitRequestPacket(conn *net.UDPConn, from *net.UDPAddr, reques
 	fmt.Printf("server init request from %s\n", from.String())
 
 	responsePacket := &packets.SDK_ServerInitResponsePacket{
+		Version:   requestPacket.Version,
 		RequestId: requestPacket.RequestId,
 		Response:  packets.SDK_ServerInitResponseOK,
 	}
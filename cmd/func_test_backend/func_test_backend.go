@@ -479,7 +479,7 @@ func test_relay_manager() {
 				case <-ticker.C:
 					currentTime := time.Now().Unix()
 					fmt.Printf("relay update\n")
-					relayManager.ProcessRelayUpdate(currentTime, relayIds[index], relayNames[index], relayAddresses[index], 0, "test", 0, numSamples, sampleRelayId, sampleRTT, sampleJitter, samplePacketLoss, counters)
+					relayManager.ProcessRelayUpdate(currentTime, relayIds[index], relayNames[index], relayAddresses[index], 0, "test", 0, numSamples, sampleRelayId, sampleRTT, sampleJitter, samplePacketLoss, nil, counters, nil)
 				}
 			}
 
@@ -634,7 +634,7 @@ func test_optimize() {
 					return
 				case <-ticker.C:
 					currentTime := time.Now().Unix()
-					relayManager.ProcessRelayUpdate(currentTime, relayIds[index], relayNames[index], relayAddresses[index], 0, "test", 0, numSamples, sampleRelayId, sampleRTT, sampleJitter, samplePacketLoss, counters)
+					relayManager.ProcessRelayUpdate(currentTime, relayIds[index], relayNames[index], relayAddresses[index], 0, "test", 0, numSamples, sampleRelayId, sampleRTT, sampleJitter, samplePacketLoss, nil, counters, nil)
 				}
 			}
 
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
@@ -21,6 +22,7 @@ var relayBackendHostname string
 var relayBackendPublicKey []byte
 var relayPrivateKey []byte
 var numRelays int
+var relayGatewayUDPAddress string
 
 func main() {
 
@@ -32,6 +34,8 @@ func main() {
 
 	relayBackendHostname = envvar.GetString("RELAY_BACKEND_URL", "http://127.0.0.1:30000")
 
+	relayGatewayUDPAddress = envvar.GetString("RELAY_GATEWAY_UDP_ADDRESS", "")
+
 	relayBackendPublicKey = envvar.GetBase64("RELAY_BACKEND_PUBLIC_KEY", []byte{})
 
 	if len(relayBackendPublicKey) == 0 {
@@ -106,6 +110,11 @@ func RunRelay(service *common.Service, index int) {
 					NumRelayCounters:          constants.NumRelayCounters,
 				}
 
+				packet.HostMetrics.CPUPercent = float32(common.RandomInt(10, 50))
+				packet.HostMetrics.SoftIRQPercent = float32(common.RandomInt(1, 10))
+				packet.HostMetrics.MemoryUsedBytes = uint64(common.RandomInt(1000, 2000)) * 1024 * 1024
+				packet.HostMetrics.MemoryTotalBytes = 8 * 1024 * 1024 * 1024
+
 				copy(packet.SampleRelayId[:], sampleRelayIds)
 
 				for i := 0; i < int(packet.NumSamples); i++ {
@@ -136,7 +145,15 @@ func RunRelay(service *common.Service, index int) {
 
 				copy(packetData[8+encryptedBytes:], nonce)
 
-				// post to relay backend
+				// send to relay backend over UDP if configured, otherwise post it
+
+				if relayGatewayUDPAddress != "" {
+					err := SendDatagrams(relayGatewayUDPAddress, address, packetData)
+					if err != nil {
+						core.Error("failed to send relay update datagrams to relay gateway: %v", err)
+					}
+					continue
+				}
 
 				err := PostBinary(fmt.Sprintf("%s/relay_update", relayBackendHostname), packetData)
 				if err != nil {
@@ -176,3 +193,59 @@ func PostBinary(url string, data []byte) error {
 
 	return nil
 }
+
+func SendDatagrams(gatewayAddress string, relayAddress net.UDPAddr, data []byte) error {
+
+	to, err := net.ResolveUDPAddr("udp", gatewayAddress)
+	if err != nil {
+		return err
+	}
+
+	// IMPORTANT: the relay gateway only responds to datagrams from the relay's own public address
+
+	conn, err := net.ListenUDP("udp", &relayAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	messageId := common.RandomUint64()
+
+	datagrams, err := packets.WriteRelayUpdateDatagrams(messageId, data)
+	if err != nil {
+		return err
+	}
+
+	for i := range datagrams {
+		if _, err := conn.WriteToUDP(datagrams[i], to); err != nil {
+			return err
+		}
+	}
+
+	// wait for the whole response
+
+	reassembler := packets.CreateRelayUpdateReassembler(5, 1)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		// the reassembler keeps each fragment without copying it, so every read needs its own buffer
+
+		buffer := make([]byte, packets.RelayUpdateDatagram_HeaderBytes+packets.RelayUpdateDatagram_MaxFragmentBytes)
+
+		bytes, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		responseMessageId, _, complete, err := reassembler.AddDatagram(from.String(), buffer[:bytes:bytes], time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		if complete {
+			if responseMessageId != messageId {
+				return fmt.Errorf("response message id mismatch")
+			}
+			return nil
+		}
+	}
+}
@@ -40,7 +40,7 @@ func main() {
 	// allowed: local dev and functional tests run without a key and fall back to the
 	// constants-only derivation.
 
-	magicKey = envvar.GetBase64("MAGIC_KEY", nil)
+	magicKey = envvar.GetSecretBase64("MAGIC_KEY", nil)
 
 	if len(magicKey) > 0 {
 		core.Log("magic key is set")
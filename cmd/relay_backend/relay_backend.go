@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
@@ -66,6 +67,9 @@ var routeMatrixData []byte
 var delayMutex sync.RWMutex
 var delayCompleted bool
 
+var enableRelayManagerReplication bool
+var relayManagerReplicationInterval time.Duration
+
 var startTime int64
 
 var counterNames [constants.NumRelayCounters]string
@@ -91,6 +95,7 @@ var postRelayUpdateRequestChannel chan *packets.RelayUpdateRequestPacket
 
 var relayBackendPublicKey []byte
 var relayBackendPrivateKey []byte
+var relayForwardKey []byte
 
 //go:embed relay_update.json
 var relayUpdateSchemaData string
@@ -108,6 +113,18 @@ var relayToRelayPingSchema avro.Schema
 var lastTimeSeriesUpdateTime map[uint64]int64 // IMPORTANT: per-relay, we only send time series stats once per-minute, otherwise we overload the time series redis @ 1000 relays
 
 var enableRelayHistory bool
+var enablePingSets bool
+
+var matrixSnapshotStore common.MatrixSnapshotStore
+var matrixSnapshotInterval time.Duration
+var matrixSnapshotRetention time.Duration
+var matrixSnapshotChannel chan matrixSnapshot
+
+type matrixSnapshot struct {
+	timestamp       int64
+	costMatrixData  []byte
+	routeMatrixData []byte
+}
 
 func main() {
 
@@ -135,19 +152,42 @@ func main() {
 
 	enableRelayHistory = envvar.GetBool("ENABLE_RELAY_HISTORY", false)
 
+	// IMPORTANT: must match ENABLE_PING_SETS in the relay gateway, so relay pairs pinged in only one direction are routable
+	enablePingSets = envvar.GetBool("ENABLE_PING_SETS", false)
+
 	enableGooglePubsub = envvar.GetBool("ENABLE_GOOGLE_PUBSUB", false)
 
 	enableRelayToRelayPingAnalytics = envvar.GetBool("ENABLE_RELAY_TO_RELAY_PING_ANALYTICS", false)
 
 	initialDelay = envvar.GetInt("INITIAL_DELAY", 15)
 
+	enableRelayManagerReplication = envvar.GetBool("ENABLE_RELAY_MANAGER_REPLICATION", true)
+	relayManagerReplicationInterval = envvar.GetDuration("RELAY_MANAGER_REPLICATION_INTERVAL", 5*time.Second)
+
 	relayInserterBatchSize = envvar.GetInt("RELAY_INSERTER_BATCH_SIZE", 1024)
 
+	matrixSnapshotURL := envvar.GetString("MATRIX_SNAPSHOT_URL", "")
+	matrixSnapshotInterval = envvar.GetDuration("MATRIX_SNAPSHOT_INTERVAL", time.Minute)
+	matrixSnapshotRetention = envvar.GetDuration("MATRIX_SNAPSHOT_RETENTION", 7*24*time.Hour)
+
+	if matrixSnapshotURL != "" {
+		var err error
+		matrixSnapshotStore, err = common.CreateMatrixSnapshotStore(matrixSnapshotURL)
+		if err != nil {
+			core.Error("could not create matrix snapshot store: %v", err)
+			os.Exit(1)
+		}
+		core.Debug("matrix snapshot url: %s", matrixSnapshotURL)
+		core.Debug("matrix snapshot interval: %s", matrixSnapshotInterval.String())
+		core.Debug("matrix snapshot retention: %s", matrixSnapshotRetention.String())
+	}
+
 	startTime = time.Now().Unix()
 
 	lastTimeSeriesUpdateTime = make(map[uint64]int64, constants.MaxRelays)
 
 	enableRedisTimeSeries = envvar.GetBool("ENABLE_REDIS_TIME_SERIES", false)
+	enablePortalEvents := envvar.GetBool("ENABLE_PORTAL_EVENTS", false)
 	redisTimeSeriesCluster = envvar.GetStringArray("REDIS_TIME_SERIES_CLUSTER", []string{})
 	redisTimeSeriesHostname = envvar.GetString("REDIS_TIME_SERIES_HOSTNAME", "127.0.0.1:6379")
 
@@ -160,7 +200,7 @@ func main() {
 	redisPortalHostname = envvar.GetString("REDIS_PORTAL_HOSTNAME", "127.0.0.1:6379")
 
 	relayBackendPublicKey = envvar.GetBase64("RELAY_BACKEND_PUBLIC_KEY", []byte{})
-	relayBackendPrivateKey = envvar.GetBase64("RELAY_BACKEND_PRIVATE_KEY", []byte{})
+	relayBackendPrivateKey = envvar.GetSecretBase64("RELAY_BACKEND_PRIVATE_KEY", []byte{})
 
 	if len(relayBackendPublicKey) == 0 {
 		core.Error("You must supply RELAY_BACKEND_PUBLIC_KEY")
@@ -172,6 +212,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	relayForwardKey = packets.GetRelayForwardKey(relayBackendPrivateKey)
+
 	if len(redisCluster) > 0 {
 		core.Debug("redis cluster: %v", redisCluster)
 	} else {
@@ -207,6 +249,11 @@ func main() {
 
 	core.Debug("initial delay: %d", initialDelay)
 
+	core.Debug("enable relay manager replication: %v", enableRelayManagerReplication)
+	core.Debug("relay manager replication interval: %s", relayManagerReplicationInterval.String())
+
+	core.Debug("enable portal events: %v", enablePortalEvents)
+
 	var redisClient redis.Cmdable
 	if len(redisPortalCluster) > 0 {
 		redisClient = common.CreateRedisClusterClient(redisPortalCluster)
@@ -215,6 +262,7 @@ func main() {
 	}
 
 	relayInserter = portal.CreateRelayInserter(redisClient, relayInserterBatchSize)
+	relayInserter.PublishEvents = enablePortalEvents
 
 	if enableRedisTimeSeries {
 
@@ -302,8 +350,10 @@ func main() {
 	initCounterNames()
 
 	relayManager := common.CreateRelayManager(enableRelayHistory)
+	relayManager.EnablePingSets = enablePingSets
 
 	service.Router.HandleFunc("/relay_update", relayUpdateHandler(service, relayManager)).Methods("POST")
+	service.Router.HandleFunc("/relay_updates", relayUpdatesHandler(service, relayManager)).Methods("POST")
 	service.Router.HandleFunc("/relays", relaysHandler)
 	service.Router.HandleFunc("/relay_data", relayDataHandler(service))
 	service.Router.HandleFunc("/cost_matrix", costMatrixHandler)
@@ -313,6 +363,8 @@ func main() {
 	service.Router.HandleFunc("/relay_manager", relayManagerHandler(service, relayManager))
 	service.Router.HandleFunc("/costs", costsHandler(service, relayManager))
 	service.Router.HandleFunc("/active_relays", activeRelaysHandler(service, relayManager))
+	service.Router.HandleFunc("/matrix_snapshots/{name}", matrixSnapshotsHandler)
+	service.Router.HandleFunc("/matrix_snapshot/{name}/{timestamp}", matrixSnapshotHandler)
 
 	service.SetHealthFunctions(sendTrafficToMe(service), machineIsHealthy, ready(service))
 
@@ -322,10 +374,18 @@ func main() {
 
 	UpdateRelayBackendInstance(service)
 
+	if matrixSnapshotStore != nil {
+		SaveMatrixSnapshots(service)
+	}
+
 	UpdateRouteMatrix(service, relayManager)
 
 	UpdateInitialDelayState(service)
 
+	if enableRelayManagerReplication {
+		ReplicateRelayManager(service, relayManager)
+	}
+
 	go PostRelayUpdateRequest(service)
 
 	service.WaitForShutdown()
@@ -351,70 +411,127 @@ func relayUpdateHandler(service *common.Service, relayManager *common.RelayManag
 		}
 		defer r.Body.Close()
 
-		// discard if the body is too small to possibly be valid
+		body, err = packets.VerifyRelayForward(relayForwardKey, packets.RelayForwardMessage_RelayUpdate, uint64(time.Now().Unix()), body)
+		if err != nil {
+			core.Error("rejected relay update: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 
-		if len(body) < 64 {
-			core.Error("relay update is too small to be valid")
+		processRelayUpdate(service, relayManager, body)
+	}
+}
+
+// relayUpdatesHandler receives a batch of relay updates forwarded by the relay gateway in one request
+
+func relayUpdatesHandler(service *common.Service, relayManager *common.RelayManager) func(w http.ResponseWriter, r *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		startTime := time.Now()
+
+		defer func() {
+			duration := time.Since(startTime)
+			if duration.Milliseconds() > 1000 {
+				core.Warn("long relay updates: %s", duration.String())
+			}
+		}()
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			core.Error("could not read request body: %v", err)
 			return
 		}
+		defer r.Body.Close()
 
-		// read the relay update request packet
+		body, err = packets.VerifyRelayForward(relayForwardKey, packets.RelayForwardMessage_RelayUpdates, uint64(time.Now().Unix()), body)
+		if err != nil {
+			core.Error("rejected relay updates: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 
-		var relayUpdateRequest packets.RelayUpdateRequestPacket
-		err = relayUpdateRequest.Read(body)
+		updates, err := packets.ReadRelayUpdateBatch(body)
 		if err != nil {
-			core.Error("could not read relay update: %v", err)
+			core.Error("could not read relay update batch: %v", err)
 			return
 		}
 
-		go func() {
+		core.Debug("received batch of %d relay updates", len(updates))
 
-			// check if we are overloaded
+		for i := range updates {
+			processRelayUpdate(service, relayManager, updates[i])
+		}
+	}
+}
 
-			currentTime := uint64(time.Now().Unix())
+func processRelayUpdate(service *common.Service, relayManager *common.RelayManager, body []byte) {
 
-			if relayUpdateRequest.CurrentTime < currentTime-5 {
-				core.Error("relay update is old. relay gateway -> relay backend is overloaded!")
-			}
+	// discard if the body is too small to possibly be valid
 
-			// look up the relay in the database
+	if len(body) < 64 {
+		core.Error("relay update is too small to be valid")
+		return
+	}
 
-			relayData := service.RelayData()
+	// read the relay update request packet
 
-			relayId := common.RelayId(relayUpdateRequest.Address.String())
-			relayIndex, ok := relayData.RelayIdToIndex[relayId]
-			if !ok {
-				core.Error("unknown relay id %016x", relayId)
-				return
-			}
+	var relayUpdateRequest packets.RelayUpdateRequestPacket
+	err := relayUpdateRequest.Read(body)
+	if err != nil {
+		core.Error("could not read relay update: %v", err)
+		return
+	}
 
-			relayName := relayData.RelayNames[relayIndex]
-			relayAddress := relayData.RelayAddresses[relayIndex]
+	go func() {
 
-			// process samples in the relay update (this drives the cost matrix...)
+		// check if we are overloaded
 
-			core.Debug("[%s] received update for %s [%016x]", relayAddress.String(), relayName, relayId)
+		currentTime := uint64(time.Now().Unix())
 
-			numSamples := int(relayUpdateRequest.NumSamples)
+		if relayUpdateRequest.CurrentTime < currentTime-5 {
+			core.Error("relay update is old. relay gateway -> relay backend is overloaded!")
+		}
 
-			relayManager.ProcessRelayUpdate(int64(currentTime),
-				relayId,
-				relayName,
-				relayUpdateRequest.Address,
-				int(relayUpdateRequest.SessionCount),
-				relayUpdateRequest.RelayVersion,
-				relayUpdateRequest.RelayFlags,
-				numSamples,
-				relayUpdateRequest.SampleRelayId[:numSamples],
-				relayUpdateRequest.SampleRTT[:numSamples],
-				relayUpdateRequest.SampleJitter[:numSamples],
-				relayUpdateRequest.SamplePacketLoss[:numSamples],
-				relayUpdateRequest.RelayCounters[:],
-			)
+		// look up the relay in the database
 
-			postRelayUpdateRequestChannel <- &relayUpdateRequest
-		}()
-	}
+		relayData := service.RelayData()
+
+		relayId := common.RelayId(relayUpdateRequest.Address.String())
+		relayIndex, ok := relayData.RelayIdToIndex[relayId]
+		if !ok {
+			core.Error("unknown relay id %016x", relayId)
+			return
+		}
+
+		relayName := relayData.RelayNames[relayIndex]
+		relayAddress := relayData.RelayAddresses[relayIndex]
+
+		// process samples in the relay update (this drives the cost matrix...)
+
+		core.Debug("[%s] received update for %s [%016x]", relayAddress.String(), relayName, relayId)
+
+		numSamples := int(relayUpdateRequest.NumSamples)
+
+		relayManager.ProcessRelayUpdate(int64(currentTime),
+			relayId,
+			relayName,
+			relayUpdateRequest.Address,
+			int(relayUpdateRequest.SessionCount),
+			relayUpdateRequest.RelayVersion,
+			relayUpdateRequest.RelayFlags,
+			numSamples,
+			relayUpdateRequest.SampleRelayId[:numSamples],
+			relayUpdateRequest.SampleRTT[:numSamples],
+			relayUpdateRequest.SampleJitter[:numSamples],
+			relayUpdateRequest.SamplePacketLoss[:numSamples],
+			relayUpdateRequest.GetSampleMTU(),
+			relayUpdateRequest.RelayCounters[:],
+			relayUpdateRequest.GetHostMetrics(),
+		)
+
+		postRelayUpdateRequestChannel <- &relayUpdateRequest
+	}()
 }
 
 func UpdateRelayBackendInstance(service *common.Service) {
@@ -444,7 +561,11 @@ func UpdateRelayBackendInstance(service *common.Service) {
 
 				minutes := time.Now().Unix() / 60
 
-				err := redisClient.HSet(ctx, fmt.Sprintf("relay-backends-%d", minutes), fmt.Sprintf("%s:%s", internalAddress, internalPort), "1").Err()
+				address := fmt.Sprintf("%s:%s", internalAddress, internalPort)
+
+				signature := packets.SignRelayBackendRegistration(relayForwardKey, address, minutes)
+
+				err := redisClient.HSet(ctx, fmt.Sprintf("relay-backends-%d", minutes), address, signature).Err()
 				if err != nil {
 					core.Warn("failed to update relay backend field in redis: %v", err)
 				}
@@ -455,8 +576,21 @@ func UpdateRelayBackendInstance(service *common.Service) {
 
 func PostRelayUpdateRequest(service *common.Service) {
 
+	// tick the relay inserter even when no relay updates arrive, so relays are still published as offline when they all stop updating
+
+	ticker := time.NewTicker(time.Second)
+
 	for {
-		relayUpdateRequest := <-postRelayUpdateRequestChannel
+		var relayUpdateRequest *packets.RelayUpdateRequestPacket
+
+		select {
+		case <-ticker.C:
+			if service.IsLeader() {
+				relayInserter.CheckForFlush(service.Context, time.Now())
+			}
+			continue
+		case relayUpdateRequest = <-postRelayUpdateRequestChannel:
+		}
 
 		// build relay to relay ping messages for analytics
 
@@ -539,13 +673,19 @@ func PostRelayUpdateRequest(service *common.Service) {
 			if service.IsLeader() {
 
 				relayData := portal.RelayData{
-					RelayId:      message.RelayId,
-					RelayName:    message.RelayName,
-					NumSessions:  message.SessionCount,
-					MaxSessions:  message.MaxSessions,
-					StartTime:    message.StartTime,
-					RelayFlags:   message.RelayFlags,
-					RelayVersion: message.RelayVersion,
+					RelayId:             message.RelayId,
+					RelayName:           message.RelayName,
+					NumSessions:         message.SessionCount,
+					MaxSessions:         message.MaxSessions,
+					StartTime:           message.StartTime,
+					RelayFlags:          message.RelayFlags,
+					RelayVersion:        message.RelayVersion,
+					CPUPercent:          relayUpdateRequest.HostMetrics.CPUPercent,
+					SoftIRQPercent:      relayUpdateRequest.HostMetrics.SoftIRQPercent,
+					MemoryUsedMB:        uint32(relayUpdateRequest.HostMetrics.MemoryUsedBytes / (1024 * 1024)),
+					MemoryTotalMB:       uint32(relayUpdateRequest.HostMetrics.MemoryTotalBytes / (1024 * 1024)),
+					NICRxDropsPerSecond: relayUpdateRequest.HostMetrics.NICRxDropsPerSecond,
+					NICTxDropsPerSecond: relayUpdateRequest.HostMetrics.NICTxDropsPerSecond,
 				}
 
 				relayInserter.Insert(service.Context, &relayData)
@@ -602,6 +742,12 @@ func PostRelayUpdateRequest(service *common.Service) {
 				relayCounters[i] = int64(relayUpdateRequest.RelayCounters[i])
 			}
 
+			xdpDrops := make([]int64, constants.NumRelayXDPDropReasons)
+
+			for i := range xdpDrops {
+				xdpDrops[i] = int64(relayUpdateRequest.HostMetrics.XDPDrops[i])
+			}
+
 			message := messages.AnalyticsRelayUpdateMessage{
 				Timestamp:                 timestamp,
 				RelayId:                   int64(relayId),
@@ -622,6 +768,13 @@ func PostRelayUpdateRequest(service *common.Service) {
 				NumUnroutable:             int32(numUnroutable),
 				StartTime:                 int64(relayUpdateRequest.StartTime),
 				CurrentTime:               int64(relayUpdateRequest.CurrentTime),
+				CPUPercent:                relayUpdateRequest.HostMetrics.CPUPercent,
+				SoftIRQPercent:            relayUpdateRequest.HostMetrics.SoftIRQPercent,
+				MemoryUsedBytes:           int64(relayUpdateRequest.HostMetrics.MemoryUsedBytes),
+				MemoryTotalBytes:          int64(relayUpdateRequest.HostMetrics.MemoryTotalBytes),
+				NICRxDropsPerSecond:       relayUpdateRequest.HostMetrics.NICRxDropsPerSecond,
+				NICTxDropsPerSecond:       relayUpdateRequest.HostMetrics.NICTxDropsPerSecond,
+				XDPDrops:                  xdpDrops,
 			}
 
 			if service.IsLeader() && relayUpdateSchema != nil {
@@ -939,7 +1092,7 @@ func sendTrafficToMe(service *common.Service) func() bool {
 		routeMatrixMutex.RLock()
 		hasRouteMatrix := routeMatrixData != nil
 		routeMatrixMutex.RUnlock()
-		return hasRouteMatrix && time.Now().Unix() > startTime+int64(initialDelay)
+		return hasRouteMatrix && initialDelayCompleted()
 	}
 }
 
@@ -960,15 +1113,22 @@ func initialDelayCompleted() bool {
 	return result
 }
 
+func completeInitialDelay() {
+	delayMutex.Lock()
+	delayCompleted = true
+	delayMutex.Unlock()
+}
+
 func UpdateInitialDelayState(service *common.Service) {
 	go func() {
 		for {
+			if initialDelayCompleted() {
+				return
+			}
 			currentTime := int64(time.Now().Unix())
 			if currentTime-startTime >= int64(initialDelay) {
 				core.Debug("initial delay completed")
-				delayMutex.Lock()
-				delayCompleted = true
-				delayMutex.Unlock()
+				completeInitialDelay()
 				return
 			}
 			time.Sleep(time.Second)
@@ -976,6 +1136,77 @@ func UpdateInitialDelayState(service *common.Service) {
 	}()
 }
 
+// ReplicateRelayManager keeps follower relay managers in sync with the leader's link history and counters, so that when leadership changes
+// the new leader produces the same cost matrix immediately, instead of rebuilding it from fresh relay updates. A follower
+// that has the leader's state doesn't need to wait for the initial delay. The state expires if the leader stops writing it.
+
+func ReplicateRelayManager(service *common.Service, relayManager *common.RelayManager) {
+
+	ticker := time.NewTicker(relayManagerReplicationInterval)
+
+	lastStateTimestamp := int64(0)
+
+	go func() {
+		for {
+			select {
+
+			case <-service.Context.Done():
+				return
+
+			case <-ticker.C:
+
+				if !service.IsReady() {
+					continue
+				}
+
+				currentTime := time.Now().Unix()
+
+				if service.IsLeader() {
+					data, err := relayManager.WriteState(currentTime)
+					if err != nil {
+						core.Error("could not write relay manager state: %v", err)
+						continue
+					}
+					service.StoreWithExpiration("relay_manager", data, 3*relayManagerReplicationInterval)
+					lastStateTimestamp = currentTime
+					continue
+				}
+
+				data := service.Load("relay_manager")
+				if data == nil {
+					continue
+				}
+
+				// IMPORTANT: ignore state from a leader that has stopped writing it
+
+				after := lastStateTimestamp
+				if after < currentTime-constants.RelayTimeout {
+					after = currentTime - constants.RelayTimeout
+				}
+
+				timestamp, updated, err := relayManager.ReadState(data, after)
+				if err != nil {
+					core.Error("could not read relay manager state: %v", err)
+					continue
+				}
+
+				if !updated {
+					continue
+				}
+
+				lastStateTimestamp = timestamp
+
+				core.Debug("replicated relay manager state from leader (%d bytes)", len(data))
+
+				if !initialDelayCompleted() {
+					core.Log("initial delay completed early with relay manager state from leader")
+					completeInitialDelay()
+				}
+			}
+		}
+	}()
+}
+
 func relaysHandler(w http.ResponseWriter, r *http.Request) {
 	relaysMutex.RLock()
 	responseData := relaysCSVData
@@ -1048,10 +1279,97 @@ func routeMatrixHandler(w http.ResponseWriter, r *http.Request) {
 	buffer.WriteTo(w)
 }
 
+// SaveMatrixSnapshots writes cost and route matrix snapshots to the store off the route matrix goroutine, since uploads can be slow
+
+func SaveMatrixSnapshots(service *common.Service) {
+
+	matrixSnapshotChannel = make(chan matrixSnapshot, 16)
+
+	go func() {
+		for {
+			select {
+
+			case <-service.Context.Done():
+				return
+
+			case snapshot := <-matrixSnapshotChannel:
+
+				err := matrixSnapshotStore.Put(common.MatrixSnapshot_CostMatrix, snapshot.timestamp, snapshot.costMatrixData)
+				if err != nil {
+					core.Error("could not save cost matrix snapshot: %v", err)
+				}
+
+				err = matrixSnapshotStore.Put(common.MatrixSnapshot_RouteMatrix, snapshot.timestamp, snapshot.routeMatrixData)
+				if err != nil {
+					core.Error("could not save route matrix snapshot: %v", err)
+				}
+
+				core.Debug("saved matrix snapshot %d", snapshot.timestamp)
+
+				before := snapshot.timestamp - int64(matrixSnapshotRetention.Seconds())
+
+				for _, name := range []string{common.MatrixSnapshot_CostMatrix, common.MatrixSnapshot_RouteMatrix} {
+					deleted, err := common.PruneMatrixSnapshots(matrixSnapshotStore, name, before)
+					if err != nil {
+						core.Error("could not prune %s snapshots: %v", name, err)
+					} else if deleted > 0 {
+						core.Debug("pruned %d %s snapshots", deleted, name)
+					}
+				}
+			}
+		}
+	}()
+}
+
+type MatrixSnapshotsResponse struct {
+	Timestamps []int64 `json:"timestamps"`
+	Error      string  `json:"error"`
+}
+
+func matrixSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	response := MatrixSnapshotsResponse{}
+	if matrixSnapshotStore == nil {
+		response.Error = "matrix snapshots are not enabled"
+	} else if !common.IsValidMatrixSnapshotName(name) {
+		response.Error = fmt.Sprintf("invalid matrix snapshot name: %s", name)
+	} else {
+		timestamps, err := matrixSnapshotStore.List(name)
+		if err != nil {
+			response.Error = err.Error()
+		}
+		response.Timestamps = timestamps
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func matrixSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	at, err := strconv.ParseInt(vars["timestamp"], 10, 64)
+	if matrixSnapshotStore == nil || !common.IsValidMatrixSnapshotName(name) || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	timestamp, data, err := common.GetMatrixSnapshotAt(matrixSnapshotStore, name, at)
+	if err != nil {
+		core.Warn("could not get %s snapshot at %d: %v", name, at, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Snapshot-Timestamp", fmt.Sprintf("%d", timestamp))
+	buffer := bytes.NewBuffer(data)
+	buffer.WriteTo(w)
+}
+
 func UpdateRouteMatrix(service *common.Service, relayManager *common.RelayManager) {
 
 	ticker := time.NewTicker(routeMatrixInterval)
 
+	lastMatrixSnapshotTime := int64(0)
+
 	go func() {
 
 		for {
@@ -1080,6 +1398,8 @@ func UpdateRouteMatrix(service *common.Service, relayManager *common.RelayManage
 
 				costs := relayManager.GetCosts(currentTime, relayData.RelayIds, float32(maxJitter), maxPacketLoss)
 
+				relayMTU := relayManager.GetMTUs(currentTime, relayData.RelayIds)
+
 				relayPrice := make([]byte, numRelays)
 
 				copy(relayPrice, relayData.RelayPrice)
@@ -1095,6 +1415,7 @@ func UpdateRouteMatrix(service *common.Service, relayManager *common.RelayManage
 					DestRelays:         relayData.DestRelays,
 					Costs:              costs,
 					RelayPrice:         relayPrice,
+					RelayMTU:           relayMTU,
 				}
 
 				// optimize cost matrix -> route matrix
@@ -1150,6 +1471,8 @@ func UpdateRouteMatrix(service *common.Service, relayManager *common.RelayManage
 					OptimizeTime:       uint32(optimizeDuration.Milliseconds()),
 					Costs:              costs,
 					RelayPrice:         relayPrice,
+					FencingToken:       service.FencingToken(),
+					RelayMTU:           relayMTU,
 				}
 
 				// write route matrix data
@@ -1197,6 +1520,17 @@ func UpdateRouteMatrix(service *common.Service, relayManager *common.RelayManage
 				routeMatrixData = routeMatrixDataNew
 				routeMatrixMutex.Unlock()
 
+				// snapshot the matrices we are serving (leader only)
+
+				if matrixSnapshotChannel != nil && service.IsLeader() && currentTime-lastMatrixSnapshotTime >= int64(matrixSnapshotInterval.Seconds()) {
+					lastMatrixSnapshotTime = currentTime
+					select {
+					case matrixSnapshotChannel <- matrixSnapshot{timestamp: currentTime, costMatrixData: costMatrixDataNew, routeMatrixData: routeMatrixDataNew}:
+					default:
+						core.Warn("matrix snapshot channel is full. skipping snapshot")
+					}
+				}
+
 				// analyze route matrix
 
 				analysis := routeMatrixNew.Analyze()
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"maps"
@@ -11,9 +13,11 @@ import (
 	"os"
 	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/networknext/next/modules/common"
@@ -30,21 +34,49 @@ var redisCluster []string
 var pingKey []byte
 var relayBackendPublicKey []byte
 var relayBackendPrivateKey []byte
+var relayForwardKey []byte
 
 var mutex sync.Mutex
 var relayBackendAddresses []string
 
 var httpClient *http.Client
 
+var enablePingSets bool
+var pingSetConfig common.PingSetConfig
+
+var enableUDPRelayUpdates bool
+
+var forwardBatchSize int
+var forwardBatchInterval time.Duration
+var forwardChannel chan []byte
+
+var rolloutMutex sync.RWMutex
+var rollout *common.Rollout
+var rolloutRedisClient redis.Cmdable
+var rolloutHealthTime map[uint64]int64
+var rolloutHealthCounters map[uint64]common.RolloutRelayCounters
+
+var enableRelayBootstrap bool
+var relayBootstrapMasterKey []byte
+var relayBootstrapRedisClient redis.Cmdable
+
 func main() {
 
 	service := common.CreateService("relay_gateway")
 
 	redisHostname = envvar.GetString("REDIS_HOSTNAME", "127.0.0.1:6379")
 	redisCluster = envvar.GetStringArray("REDIS_CLUSTER", []string{})
-	pingKey = envvar.GetBase64("PING_KEY", []byte{})
+	pingKey = envvar.GetSecretBase64("PING_KEY", []byte{})
 	relayBackendPublicKey = envvar.GetBase64("RELAY_BACKEND_PUBLIC_KEY", []byte{})
-	relayBackendPrivateKey = envvar.GetBase64("RELAY_BACKEND_PRIVATE_KEY", []byte{})
+	relayBackendPrivateKey = envvar.GetSecretBase64("RELAY_BACKEND_PRIVATE_KEY", []byte{})
+	enableUDPRelayUpdates = envvar.GetBool("ENABLE_UDP_RELAY_UPDATES", false)
+	forwardBatchSize = envvar.GetInt("FORWARD_BATCH_SIZE", 100)
+	forwardBatchInterval = envvar.GetDuration("FORWARD_BATCH_INTERVAL", 100*time.Millisecond)
+	enablePingSets = envvar.GetBool("ENABLE_PING_SETS", false)
+	pingSetConfig.RadiusKilometers = envvar.GetFloat("PING_SET_RADIUS_KM", 2500.0)
+	pingSetConfig.LongHaulPeers = envvar.GetInt("PING_SET_LONG_HAUL_PEERS", 16)
+	pingSetConfig.RotationPeriod = int64(envvar.GetInt("PING_SET_ROTATION_PERIOD", 300))
+	enableRelayBootstrap = envvar.GetBool("ENABLE_RELAY_BOOTSTRAP", false)
 
 	if len(redisCluster) > 0 {
 		core.Debug("redis cluster: %v", redisCluster)
@@ -67,16 +99,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	relayForwardKey = packets.GetRelayForwardKey(relayBackendPrivateKey)
+
 	// IMPORTANT: don't log the ping key itself, it's a secret. log a fingerprint so
 	// mismatched keys between services can still be diagnosed from debug logs
 	core.Debug("ping key fingerprint: %016x", common.HashString(string(pingKey)))
 
+	core.Debug("forward batch size: %d", forwardBatchSize)
+	core.Debug("forward batch interval: %s", forwardBatchInterval.String())
+
+	if enablePingSets {
+		core.Debug("ping sets enabled: radius %.0fkm, %d long-haul peers, rotation period %ds", pingSetConfig.RadiusKilometers, pingSetConfig.LongHaulPeers, pingSetConfig.RotationPeriod)
+	}
+
 	httpClient = &http.Client{
 		Timeout: 5 * time.Second,
 	}
 
 	TrackRelayBackendInstances(service)
 
+	TrackRollout(service)
+
+	if forwardBatchSize > 1 {
+		ForwardRelayUpdates(service)
+	}
+
 	service.UpdateMagic()
 
 	service.LoadDatabase(relayBackendPublicKey, relayBackendPrivateKey)
@@ -87,6 +134,26 @@ func main() {
 
 	service.Router.HandleFunc("/relay_backends", RelayBackendsHandler)
 
+	if enableRelayBootstrap {
+		core.Debug("relay bootstrap enabled")
+		relayBootstrapMasterKey = envvar.GetSecretsMasterKey()
+		if len(relayBootstrapMasterKey) == 0 {
+			core.Error("relay bootstrap needs the secrets master key, so pending relay private keys are not stored in plaintext")
+			os.Exit(1)
+		}
+		if len(redisCluster) > 0 {
+			relayBootstrapRedisClient = common.CreateRedisClusterClient(redisCluster)
+		} else {
+			relayBootstrapRedisClient = common.CreateRedisClient(redisHostname)
+		}
+		service.Router.HandleFunc("/relay_bootstrap", RelayBootstrapHandler).Methods("POST")
+		service.Router.HandleFunc("/relay_bootstrap/{registration_id}", RelayBootstrapStatusHandler).Methods("GET")
+	}
+
+	if enableUDPRelayUpdates {
+		service.StartUDPServer(RelayUpdatePacketHandler(GetRelayData(service), GetMagicValues(service)))
+	}
+
 	service.WaitForShutdown()
 }
 
@@ -117,197 +184,352 @@ func RelayUpdateHandler(getRelayData func() *common.RelayData, getMagicValues fu
 		}
 		defer request.Body.Close()
 
-		// ignore the relay update if it's too small to be valid
-
-		packetBytes := len(body)
-
-		if packetBytes < 1+1+4+2+crypto.Box_MacSize+crypto.Box_NonceSize {
-			core.Error("[%s] relay update packet is too small to be valid", request.RemoteAddr)
-			writer.WriteHeader(http.StatusBadRequest) // 400
+		responseData, forwardData, status := processRelayUpdate(request.RemoteAddr, nil, body, startTime, getRelayData(), getMagicValues)
+		if status != http.StatusOK {
+			writer.WriteHeader(status)
 			return
 		}
 
-		// read the version and decide if we can handle it
+		// send the response packet back to the relay
 
-		index := 0
-		packetData := body
-		var packetVersion uint8
-		encoding.ReadUint8(packetData, &index, &packetVersion)
+		writer.Header().Set("Content-Type", request.Header.Get("Content-Type"))
 
-		if packetVersion < packets.RelayUpdateRequestPacket_VersionMin || packetVersion > packets.RelayUpdateRequestPacket_VersionMax {
-			core.Error("[%s] invalid relay update packet version: %d", request.RemoteAddr, packetVersion)
-			writer.WriteHeader(http.StatusBadRequest) // 400
+		writer.Write(responseData)
+
+		forwardRelayUpdate(forwardData)
+	}
+}
+
+// RelayUpdatePacketHandler receives relay updates sent as UDP datagrams. Once all fragments of a relay update have
+// arrived, it's processed exactly like an HTTP relay update, and the response is sent back as datagrams.
+
+func RelayUpdatePacketHandler(getRelayData func() *common.RelayData, getMagicValues func() ([constants.MagicBytes]byte, [constants.MagicBytes]byte, [constants.MagicBytes]byte)) func(conn *net.UDPConn, from *net.UDPAddr, packet []byte) {
+
+	reassembler := packets.CreateRelayUpdateReassembler(5, 4096)
+
+	return func(conn *net.UDPConn, from *net.UDPAddr, packet []byte) {
+
+		startTime := time.Now()
+
+		messageId, body, complete, err := reassembler.AddDatagram(from.String(), packet, startTime.Unix())
+		if err != nil {
+			core.Debug("[%s] dropped relay update datagram: %v", from.String(), err)
 			return
 		}
 
-		// read the relay address
+		if !complete {
+			return
+		}
 
-		var relayAddress net.UDPAddr
-		if !encoding.ReadAddress(packetData, &index, &relayAddress) {
-			core.Error("[%s] could not read relay address", request.RemoteAddr)
-			writer.WriteHeader(http.StatusBadRequest) // 400
+		responseData, forwardData, status := processRelayUpdate(from.String(), from, body, startTime, getRelayData(), getMagicValues)
+		if status != http.StatusOK {
 			return
 		}
 
-		// check if the relay exists via relay id derived from relay address
+		// send the response packet back to the relay
 
-		relayData := getRelayData()
+		datagrams, err := packets.WriteRelayUpdateDatagrams(messageId, responseData)
+		if err != nil {
+			core.Error("[%s] could not write relay update response datagrams: %v", from.String(), err)
+			return
+		}
 
-		relayId := common.RelayId(relayAddress.String())
+		for i := range datagrams {
+			if _, err := conn.WriteToUDP(datagrams[i], from); err != nil {
+				core.Error("[%s] could not send relay update response datagram: %v", from.String(), err)
+				return
+			}
+		}
 
-		relay, ok := relayData.RelayHash[relayId]
-		if !ok {
-			core.Error("[%s] unknown relay %s [%x]", request.RemoteAddr, relayAddress.String(), relayId)
-			writer.WriteHeader(http.StatusBadRequest) // 400
-			return
+		forwardRelayUpdate(forwardData)
+
+		duration := time.Since(startTime)
+		if duration.Milliseconds() > 1000 {
+			core.Warn("long relay update: %s", duration.String())
 		}
+	}
+}
 
-		// decrypt the relay update
+// processRelayUpdate validates and decrypts a relay update, and builds the response for the relay. It returns the response,
+// the decrypted relay update to forward to the relay backends, and the http status. Shared by the HTTP and UDP transports.
 
-		nonce := packetData[packetBytes-crypto.Box_NonceSize:]
+func processRelayUpdate(from string, fromAddress *net.UDPAddr, body []byte, startTime time.Time, relayData *common.RelayData, getMagicValues func() ([constants.MagicBytes]byte, [constants.MagicBytes]byte, [constants.MagicBytes]byte)) ([]byte, []byte, int) {
 
-		encryptedData := packetData[index : packetBytes-crypto.Box_NonceSize]
-		encryptedBytes := len(encryptedData)
+	// ignore the relay update if it's too small to be valid
 
-		relayPublicKey := relay.PublicKey[:]
+	packetBytes := len(body)
 
-		if len(relayPublicKey) == 0 {
-			core.Error("[%s] relay public key of length 0", request.RemoteAddr)
-			writer.WriteHeader(http.StatusBadRequest) // 400
-			return
-		}
+	if packetBytes < 1+1+4+2+crypto.Box_MacSize+crypto.Box_NonceSize {
+		core.Error("[%s] relay update packet is too small to be valid", from)
+		return nil, nil, http.StatusBadRequest
+	}
 
-		err = crypto.Box_Decrypt(relayPublicKey, relayBackendPrivateKey, nonce, encryptedData, encryptedBytes)
-		if err != nil {
-			core.Error("[%s] failed to decrypt relay update (%d bytes)", request.RemoteAddr, encryptedBytes)
-			writer.WriteHeader(http.StatusBadRequest) // 400
-			return
-		}
+	// read the version and decide if we can handle it
 
-		// read the timestamp in the packet
+	index := 0
+	packetData := body
+	var packetVersion uint8
+	encoding.ReadUint8(packetData, &index, &packetVersion)
 
-		var packetTimestamp uint64
+	if packetVersion < packets.RelayUpdateRequestPacket_VersionMin || packetVersion > packets.RelayUpdateRequestPacket_VersionMax {
+		core.Error("[%s] invalid relay update packet version: %d", from, packetVersion)
+		return nil, nil, http.StatusBadRequest
+	}
 
-		timestampIndex := index
+	// read the relay address
 
-		encoding.ReadUint64(packetData, &index, &packetTimestamp)
+	var relayAddress net.UDPAddr
+	if !encoding.ReadAddress(packetData, &index, &relayAddress) {
+		core.Error("[%s] could not read relay address", from)
+		return nil, nil, http.StatusBadRequest
+	}
 
-		currentTimestamp := uint64(startTime.Unix())
+	// check if the relay exists via relay id derived from relay address
 
-		if packetTimestamp < currentTimestamp-10 {
-			core.Error("[%s] relay update request is too old", request.RemoteAddr)
-			writer.WriteHeader(http.StatusBadRequest) // 400
-			return
-		}
+	relayId := common.RelayId(relayAddress.String())
 
-		if packetTimestamp > currentTimestamp+10 {
-			core.Error("[%s] relay update request is in the future", request.RemoteAddr)
-			writer.WriteHeader(http.StatusBadRequest) // 400
-			return
-		}
+	relay, ok := relayData.RelayHash[relayId]
+	if !ok {
+		core.Error("[%s] unknown relay %s [%x]", from, relayAddress.String(), relayId)
+		return nil, nil, http.StatusBadRequest
+	}
 
-		// relay update accepted
+	// IMPORTANT: over UDP, only respond to the relay's own public address. otherwise a captured
+	// relay update could be replayed from a spoofed address to amplify traffic towards it
 
-		relayName := relay.Name
+	if fromAddress != nil && !fromAddress.IP.Equal(relay.PublicAddress.IP) {
+		core.Error("[%s] relay update datagram for %s did not come from its public address", from, relay.Name)
+		return nil, nil, http.StatusBadRequest
+	}
 
-		core.Log("[%s] received update for %s [%016x] (%d bytes)", request.RemoteAddr, relayName, relayId, encryptedBytes)
+	// decrypt the relay update
 
-		var responsePacket packets.RelayUpdateResponsePacket
+	nonce := packetData[packetBytes-crypto.Box_NonceSize:]
 
-		responsePacket.Version = packets.RelayUpdateResponsePacket_VersionWrite
-		responsePacket.Timestamp = uint64(time.Now().Unix())
-		responsePacket.TargetVersion = relay.Version
+	encryptedData := packetData[index : packetBytes-crypto.Box_NonceSize]
+	encryptedBytes := len(encryptedData)
 
-		relayIndex := 0
+	relayPublicKey := relay.PublicKey[:]
 
-		for i := range relayData.RelayIds {
+	if len(relayPublicKey) == 0 {
+		core.Error("[%s] relay public key of length 0", from)
+		return nil, nil, http.StatusBadRequest
+	}
 
-			if relayData.RelayIds[i] == relayId {
-				continue
-			}
+	err := crypto.Box_Decrypt(relayPublicKey, relayBackendPrivateKey, nonce, encryptedData, encryptedBytes)
+	if err != nil {
+		core.Error("[%s] failed to decrypt relay update (%d bytes)", from, encryptedBytes)
+		return nil, nil, http.StatusBadRequest
+	}
+
+	// read the timestamp in the packet
+
+	var packetTimestamp uint64
+
+	timestampIndex := index
+
+	encoding.ReadUint64(packetData, &index, &packetTimestamp)
 
-			address := relayData.RelayArray[i].PublicAddress
+	currentTimestamp := uint64(startTime.Unix())
 
-			internal := uint8(0)
-			if relay.Seller.Id == relayData.RelaySellerIds[i] &&
-				relayData.RelayArray[i].HasInternalAddress && relay.HasInternalAddress &&
-				relayData.RelayArray[i].InternalGroup == relay.InternalGroup {
-				address = relayData.RelayArray[i].InternalAddress
-				internal = 1
+	if packetTimestamp < currentTimestamp-10 {
+		core.Error("[%s] relay update request is too old", from)
+		return nil, nil, http.StatusBadRequest
+	}
+
+	if packetTimestamp > currentTimestamp+10 {
+		core.Error("[%s] relay update request is in the future", from)
+		return nil, nil, http.StatusBadRequest
+	}
+
+	// relay update accepted
+
+	relayName := relay.Name
+
+	core.Log("[%s] received update for %s [%016x] (%d bytes)", from, relayName, relayId, encryptedBytes)
+
+	var responsePacket packets.RelayUpdateResponsePacket
+
+	responsePacket.Version = packets.RelayUpdateResponsePacket_VersionWrite
+	responsePacket.Timestamp = uint64(time.Now().Unix())
+
+	rolloutMutex.RLock()
+	currentRollout := rollout
+	rolloutMutex.RUnlock()
+
+	responsePacket.TargetVersion = currentRollout.GetTargetVersion(&relay)
+
+	// by default each relay pings every other relay. with ping sets enabled, each relay only
+	// pings nearby relays plus a rotating sample of long-haul peers
+
+	var pingRelays []int
+	if enablePingSets {
+		pingRelays = common.GetRelayPingSet(relayData, relayData.RelayIdToIndex[relayId], &pingSetConfig, startTime.Unix())
+	} else {
+		pingRelays = make([]int, 0, len(relayData.RelayIds))
+		for i := range relayData.RelayIds {
+			if relayData.RelayIds[i] != relayId {
+				pingRelays = append(pingRelays, i)
 			}
+		}
+	}
+
+	relayIndex := 0
 
-			responsePacket.RelayId[relayIndex] = relayData.RelayIds[i]
-			responsePacket.RelayAddress[relayIndex] = address
-			responsePacket.RelayInternal[relayIndex] = internal
+	for _, i := range pingRelays {
 
-			relayIndex++
+		address := relayData.RelayArray[i].PublicAddress
+
+		internal := uint8(0)
+		if relay.Seller.Id == relayData.RelaySellerIds[i] &&
+			relayData.RelayArray[i].HasInternalAddress && relay.HasInternalAddress &&
+			relayData.RelayArray[i].InternalGroup == relay.InternalGroup {
+			address = relayData.RelayArray[i].InternalAddress
+			internal = 1
 		}
 
-		responsePacket.NumRelays = uint32(relayIndex)
+		responsePacket.RelayId[relayIndex] = relayData.RelayIds[i]
+		responsePacket.RelayAddress[relayIndex] = address
+		responsePacket.RelayInternal[relayIndex] = internal
+
+		relayIndex++
+	}
+
+	responsePacket.NumRelays = uint32(relayIndex)
+
+	responsePacket.UpcomingMagic, responsePacket.CurrentMagic, responsePacket.PreviousMagic = getMagicValues()
+
+	responsePacket.ExpectedPublicAddress = relay.PublicAddress
+
+	if relay.HasInternalAddress {
+		responsePacket.ExpectedHasInternalAddress = 1
+		responsePacket.ExpectedInternalAddress = relay.InternalAddress
+	}
+
+	copy(responsePacket.ExpectedRelayPublicKey[:], relay.PublicKey)
+	copy(responsePacket.ExpectedRelayBackendPublicKey[:], relayBackendPublicKey)
+
+	relaySecretKey, ok := relayData.RelaySecretKeys[relay.Id]
+	if !ok {
+		core.Error("[%s] could not find relay secret key", from)
+		return nil, nil, http.StatusBadRequest
+	}
+
+	token := core.RouteToken{}
+	token.NextAddress = net.UDPAddr{IP: net.IPv4(0, 0, 0, 0), Port: 10000}
+	token.PrevAddress = net.UDPAddr{IP: net.IPv4(0, 0, 0, 0), Port: 20000}
+	core.WriteEncryptedRouteToken(&token, responsePacket.TestToken[:], relaySecretKey)
+
+	copy(responsePacket.PingKey[:], pingKey)
 
-		responsePacket.UpcomingMagic, responsePacket.CurrentMagic, responsePacket.PreviousMagic = getMagicValues()
+	responseData := make([]byte, responsePacket.GetMaxSize())
 
-		responsePacket.ExpectedPublicAddress = relay.PublicAddress
+	responseData = responsePacket.Write(responseData)
 
-		if relay.HasInternalAddress {
-			responsePacket.ExpectedHasInternalAddress = 1
-			responsePacket.ExpectedInternalAddress = relay.InternalAddress
+	forwardData := body[:packetBytes-(crypto.Box_MacSize+crypto.Box_NonceSize)]
+
+	// report relay health to the rollout controller while a rollout is in progress
+
+	if currentRollout != nil && currentRollout.Stage != common.RolloutStage_Complete {
+		updateRolloutHealth(relayId, relay.Name, forwardData, startTime.Unix())
+	}
+
+	// adjust the packet to current time so we can detect when redis is overloaded in the relay backend
+
+	encoding.WriteUint64(packetData, &timestampIndex, currentTimestamp)
+
+	return responseData, forwardData, http.StatusOK
+}
+
+// forwardRelayUpdate sends a decrypted relay update to all relay backends. Updates are batched so that one request to
+// each relay backend carries many relay updates, unless batching is disabled with FORWARD_BATCH_SIZE <= 1.
+
+func forwardRelayUpdate(data []byte) {
+
+	if forwardBatchSize > 1 {
+		select {
+		case forwardChannel <- data:
+		default:
+			core.Warn("forward channel is full. dropping relay update")
 		}
+		return
+	}
 
-		copy(responsePacket.ExpectedRelayPublicKey[:], relay.PublicKey)
-		copy(responsePacket.ExpectedRelayBackendPublicKey[:], relayBackendPublicKey)
+	mutex.Lock()
+	addresses := make([]string, len(relayBackendAddresses))
+	copy(addresses, relayBackendAddresses)
+	mutex.Unlock()
 
-		relaySecretKey, ok := relayData.RelaySecretKeys[relay.Id]
-		if !ok {
-			core.Error("[%s] could not find relay secret key", request.RemoteAddr)
-			writer.WriteHeader(http.StatusBadRequest) // 400
-			return
+	message := packets.SignRelayForward(relayForwardKey, packets.RelayForwardMessage_RelayUpdate, uint64(time.Now().Unix()), data)
+
+	for i := range addresses {
+		core.Debug("forwarding relay update to %s", addresses[i])
+		go postRelayBackend(fmt.Sprintf("http://%s/relay_update", addresses[i]), message)
+	}
+}
+
+func postRelayBackend(url string, data []byte) {
+	forward_request, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err == nil {
+		response, err := httpClient.Do(forward_request)
+		if err == nil {
+			if response.StatusCode != http.StatusOK {
+				core.Warn("relay backend %s responded with %d", url, response.StatusCode)
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
 		}
+	}
+}
 
-		token := core.RouteToken{}
-		token.NextAddress = net.UDPAddr{IP: net.IPv4(0, 0, 0, 0), Port: 10000}
-		token.PrevAddress = net.UDPAddr{IP: net.IPv4(0, 0, 0, 0), Port: 20000}
-		core.WriteEncryptedRouteToken(&token, responsePacket.TestToken[:], relaySecretKey)
+func ForwardRelayUpdates(service *common.Service) {
 
-		copy(responsePacket.PingKey[:], pingKey)
+	forwardChannel = make(chan []byte, 1024*1024)
 
-		// send the response packet back to the relay
+	go func() {
 
-		responseData := make([]byte, responsePacket.GetMaxSize())
+		ticker := time.NewTicker(forwardBatchInterval)
 
-		responseData = responsePacket.Write(responseData)
+		batch := make([][]byte, 0, forwardBatchSize)
 
-		writer.Header().Set("Content-Type", request.Header.Get("Content-Type"))
+		flush := func() {
 
-		writer.Write(responseData)
+			if len(batch) == 0 {
+				return
+			}
+
+			data := packets.SignRelayForward(relayForwardKey, packets.RelayForwardMessage_RelayUpdates, uint64(time.Now().Unix()), packets.WriteRelayUpdateBatch(batch))
+
+			mutex.Lock()
+			addresses := make([]string, len(relayBackendAddresses))
+			copy(addresses, relayBackendAddresses)
+			mutex.Unlock()
 
-		// adjust the packet to current time so we can detect when redis is overloaded in the relay backend
-
-		encoding.WriteUint64(packetData, &timestampIndex, currentTimestamp)
-
-		// forward the decrypted relay update to the relay backends
-
-		mutex.Lock()
-		addresses := make([]string, len(relayBackendAddresses))
-		copy(addresses, relayBackendAddresses)
-		mutex.Unlock()
-
-		for i := range addresses {
-			core.Debug("forwarding relay update to %s", addresses[i])
-			go func(index int) {
-				url := fmt.Sprintf("http://%s/relay_update", addresses[index])
-				buffer := bytes.NewBuffer(body[:packetBytes-(crypto.Box_MacSize+crypto.Box_NonceSize)])
-				forward_request, err := http.NewRequest("POST", url, buffer)
-				if err == nil {
-					response, err := httpClient.Do(forward_request)
-					if err == nil {
-						io.Copy(io.Discard, response.Body)
-						response.Body.Close()
-					}
+			for i := range addresses {
+				core.Debug("forwarding %d relay updates to %s", len(batch), addresses[i])
+				go postRelayBackend(fmt.Sprintf("http://%s/relay_updates", addresses[i]), data)
+			}
+
+			batch = make([][]byte, 0, forwardBatchSize)
+		}
+
+		for {
+			select {
+
+			case <-service.Context.Done():
+				return
+
+			case data := <-forwardChannel:
+				batch = append(batch, data)
+				if len(batch) >= forwardBatchSize {
+					flush()
 				}
-			}(i)
+
+			case <-ticker.C:
+				flush()
+			}
 		}
-	}
+	}()
 }
 
 func RelayBackendsHandler(w http.ResponseWriter, r *http.Request) {
@@ -319,6 +541,109 @@ func RelayBackendsHandler(w http.ResponseWriter, r *http.Request) {
 	mutex.Unlock()
 }
 
+// -------------------------------------------------------------------------------------
+
+type RelayBootstrapRequest struct {
+	Token            string `json:"token"`
+	RelayName        string `json:"relay_name"`
+	PublicAddress    string `json:"public_address"`
+	InternalAddress  string `json:"internal_address"`
+	PublicKeyBase64  string `json:"public_key_base64"`
+	PrivateKeyBase64 string `json:"private_key_base64"`
+}
+
+type RelayBootstrapResponse struct {
+	RegistrationId        string `json:"registration_id"`
+	Status                string `json:"status"`
+	RelayId               uint64 `json:"relay_id,string"`
+	RelayName             string `json:"relay_name"`
+	PublicAddress         string `json:"public_address"`
+	RelayBackendPublicKey string `json:"relay_backend_public_key"`
+	Reason                string `json:"reason"`
+	Error                 string `json:"error"`
+}
+
+func writeRelayBootstrapResponse(w http.ResponseWriter, statusCode int, response *RelayBootstrapResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func RelayBootstrapHandler(w http.ResponseWriter, r *http.Request) {
+
+	request := RelayBootstrapRequest{}
+	err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&request)
+	if err != nil {
+		writeRelayBootstrapResponse(w, http.StatusBadRequest, &RelayBootstrapResponse{Error: "could not read request"})
+		return
+	}
+
+	// if the relay doesn't know its public address, use the address it came from on the default relay port.
+	// the admin sees this address before approving the relay, so it's fine to trust the load balancer header here
+
+	publicAddress := request.PublicAddress
+	if publicAddress == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			host, err = strings.TrimSpace(strings.Split(forwardedFor, ",")[0]), nil
+		}
+		if err != nil {
+			writeRelayBootstrapResponse(w, http.StatusBadRequest, &RelayBootstrapResponse{Error: "invalid public address"})
+			return
+		}
+		publicAddress = net.JoinHostPort(host, "40000")
+	}
+
+	pendingRelay := common.PendingRelay{
+		RelayName:        request.RelayName,
+		PublicAddress:    publicAddress,
+		InternalAddress:  request.InternalAddress,
+		PublicKeyBase64:  request.PublicKeyBase64,
+		PrivateKeyBase64: request.PrivateKeyBase64,
+	}
+
+	err = common.RegisterPendingRelay(r.Context(), relayBootstrapRedisClient, request.Token, &pendingRelay, relayBootstrapMasterKey)
+	if err != nil {
+		core.Warn("relay bootstrap from %s failed: %v", r.RemoteAddr, err)
+		writeRelayBootstrapResponse(w, http.StatusUnauthorized, &RelayBootstrapResponse{Error: err.Error()})
+		return
+	}
+
+	core.Log("relay %s registered pending approval (%s)", pendingRelay.PublicAddress, pendingRelay.RegistrationId)
+
+	writeRelayBootstrapResponse(w, http.StatusOK, &RelayBootstrapResponse{
+		RegistrationId: pendingRelay.RegistrationId,
+		Status:         pendingRelay.Status,
+		RelayName:      pendingRelay.RelayName,
+		PublicAddress:  pendingRelay.PublicAddress,
+	})
+}
+
+// RelayBootstrapStatusHandler lets a bootstrapping relay poll until it is approved. IMPORTANT: never return the private key!
+
+func RelayBootstrapStatusHandler(w http.ResponseWriter, r *http.Request) {
+	registrationId := mux.Vars(r)["registration_id"]
+	pendingRelay, err := common.LoadPendingRelay(r.Context(), relayBootstrapRedisClient, registrationId)
+	if err != nil {
+		writeRelayBootstrapResponse(w, http.StatusNotFound, &RelayBootstrapResponse{Error: "not found"})
+		return
+	}
+	response := RelayBootstrapResponse{
+		RegistrationId: pendingRelay.RegistrationId,
+		Status:         pendingRelay.Status,
+		RelayId:        pendingRelay.RelayId,
+		RelayName:      pendingRelay.RelayName,
+		PublicAddress:  pendingRelay.PublicAddress,
+		Reason:         pendingRelay.Reason,
+	}
+	if pendingRelay.Status == common.PendingRelayStatus_Approved {
+		response.RelayBackendPublicKey = base64.StdEncoding.EncodeToString(relayBackendPublicKey)
+	}
+	writeRelayBootstrapResponse(w, http.StatusOK, &response)
+}
+
+// -------------------------------------------------------------------------------------
+
 func TrackRelayBackendInstances(service *common.Service) {
 
 	var redisClient redis.Cmdable
@@ -356,26 +681,36 @@ func updateRelayBackendInstances(service *common.Service, redisClient redis.Cmda
 	currentMinutes := time.Now().Unix() / 60
 	previousMinutes := currentMinutes - 1
 
-	currentKeys, err := redisClient.HKeys(ctx, fmt.Sprintf("relay-backends-%d", currentMinutes)).Result()
+	currentValues, err := redisClient.HGetAll(ctx, fmt.Sprintf("relay-backends-%d", currentMinutes)).Result()
 	if err != nil {
 		core.Warn("could not get current relay backends from redis: %v", err)
 		return
 	}
 
-	previousKeys, err := redisClient.HKeys(ctx, fmt.Sprintf("relay-backends-%d", previousMinutes)).Result()
+	previousValues, err := redisClient.HGetAll(ctx, fmt.Sprintf("relay-backends-%d", previousMinutes)).Result()
 	if err != nil {
 		core.Warn("could not get previous relay backends from redis: %v", err)
 		return
 	}
 
+	// IMPORTANT: only forward relay updates to relay backends that signed their registration
+
 	addressMap := map[string]int{}
 
-	for i := range currentKeys {
-		addressMap[currentKeys[i]] = 1
+	for address, value := range currentValues {
+		if !packets.VerifyRelayBackendRegistration(relayForwardKey, address, currentMinutes, value) {
+			core.Warn("ignoring relay backend %s with invalid registration", address)
+			continue
+		}
+		addressMap[address] = 1
 	}
 
-	for i := range previousKeys {
-		addressMap[previousKeys[i]] = 1
+	for address, value := range previousValues {
+		if !packets.VerifyRelayBackendRegistration(relayForwardKey, address, previousMinutes, value) {
+			core.Warn("ignoring relay backend %s with invalid registration", address)
+			continue
+		}
+		addressMap[address] = 1
 	}
 
 	addresses := slices.Collect(maps.Keys(addressMap))
@@ -393,6 +728,94 @@ func updateRelayBackendInstances(service *common.Service, redisClient redis.Cmda
 	mutex.Unlock()
 }
 
+func TrackRollout(service *common.Service) {
+
+	if len(redisCluster) > 0 {
+		rolloutRedisClient = common.CreateRedisClusterClient(redisCluster)
+	} else {
+		rolloutRedisClient = common.CreateRedisClient(redisHostname)
+	}
+
+	rolloutHealthTime = make(map[uint64]int64)
+	rolloutHealthCounters = make(map[uint64]common.RolloutRelayCounters)
+
+	go func() {
+
+		ticker := time.NewTicker(10 * time.Second)
+
+		updateRollout(service)
+
+		for {
+			select {
+
+			case <-service.Context.Done():
+				return
+
+			case <-ticker.C:
+				updateRollout(service)
+			}
+		}
+	}()
+}
+
+func updateRollout(service *common.Service) {
+
+	newRollout, err := common.LoadRollout(service.Context, rolloutRedisClient)
+	if err != nil {
+		core.Warn("could not load rollout from redis: %v", err)
+		return
+	}
+
+	if newRollout != nil {
+		core.Debug("rollout to %s: %s", newRollout.TargetVersion, newRollout.StageString())
+	}
+
+	rolloutMutex.Lock()
+	rollout = newRollout
+	rolloutMutex.Unlock()
+}
+
+func updateRolloutHealth(relayId uint64, relayName string, packetData []byte, currentTime int64) {
+
+	rolloutMutex.Lock()
+	lastTime := rolloutHealthTime[relayId]
+	if lastTime+common.RolloutHealthUpdateInterval > currentTime {
+		rolloutMutex.Unlock()
+		return
+	}
+	rolloutHealthTime[relayId] = currentTime
+	rolloutMutex.Unlock()
+
+	var packet packets.RelayUpdateRequestPacket
+	if err := packet.Read(packetData); err != nil {
+		core.Warn("could not read relay update for rollout health: %v", err)
+		return
+	}
+
+	counters := common.GetRolloutRelayCounters(packet.StartTime, packet.RelayCounters[:packet.NumRelayCounters])
+
+	rolloutMutex.Lock()
+	previous, exists := rolloutHealthCounters[relayId]
+	rolloutHealthCounters[relayId] = counters
+	rolloutMutex.Unlock()
+
+	var previousCounters *common.RolloutRelayCounters
+	if exists {
+		previousCounters = &previous
+	}
+
+	health, ok := common.GetRolloutRelayHealth(uint64(currentTime), packet.RelayVersion, packet.SampleRTT[:packet.NumSamples], counters, previousCounters)
+	if !ok {
+		return
+	}
+
+	go func() {
+		if err := common.StoreRolloutRelayHealth(context.Background(), rolloutRedisClient, relayName, &health); err != nil {
+			core.Warn("could not store rollout health for %s: %v", relayName, err)
+		}
+	}()
+}
+
 func GetRelayData(service *common.Service) func() *common.RelayData {
 	return func() *common.RelayData {
 		return service.RelayData()
@@ -6,6 +6,8 @@ package main
 // every entry runs against) is serialized into the file ahead of the entries, so the
 // C driver loads it into the relay's maps rather than hardcoding anything. All of it
 // is seed-derived and reproducible. See modules/relaycorpus.
+// The ipv6 known-answer vectors for the same world are written next to it, to <output-path>.ipv6,
+// and the token binding vectors to <output-path>.binding
 
 import (
 	"fmt"
@@ -37,4 +39,20 @@ func main() {
 		os.Exit(1)
 	}
 	fmt.Printf("wrote %d corpus entries to %s\n", len(entries), os.Args[1])
+
+	vectors := relaycorpus.GenerateIPv6Vectors(seed, world)
+	vectorsPath := os.Args[1] + ".ipv6"
+	if err := os.WriteFile(vectorsPath, relaycorpus.MarshalIPv6Vectors(vectors), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "write failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d ipv6 vectors to %s\n", len(vectors), vectorsPath)
+
+	bindingVectors := relaycorpus.GenerateBindingVectors(seed, world)
+	bindingPath := os.Args[1] + ".binding"
+	if err := os.WriteFile(bindingPath, relaycorpus.MarshalBindingVectors(bindingVectors), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "write failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d token binding vectors to %s\n", len(bindingVectors), bindingPath)
 }
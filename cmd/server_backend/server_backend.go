@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"encoding/base64"
 	"fmt"
 	"net"
 	"os"
@@ -12,6 +14,7 @@ import (
 	"github.com/networknext/next/modules/common"
 	"github.com/networknext/next/modules/constants"
 	"github.com/networknext/next/modules/core"
+	"github.com/networknext/next/modules/crypto"
 	"github.com/networknext/next/modules/envvar"
 	"github.com/networknext/next/modules/handlers"
 	"github.com/networknext/next/modules/messages"
@@ -32,6 +35,7 @@ var serverBackendPublicKey []byte
 var serverBackendPrivateKey []byte
 var relayBackendPublicKey []byte
 var relayBackendPrivateKey []byte
+var sessionDataKeyring *crypto.Sign_Keyring
 
 var fallbackToDirectChannel chan uint64
 
@@ -39,6 +43,7 @@ var portalSessionUpdateMessageChannel chan *messages.PortalSessionUpdateMessage
 var portalServerUpdateMessageChannel chan *messages.PortalServerUpdateMessage
 var portalClientRelayUpdateMessageChannel chan *messages.PortalClientRelayUpdateMessage
 var portalServerRelayUpdateMessageChannel chan *messages.PortalServerRelayUpdateMessage
+var portalSessionReportMessageChannel chan *messages.PortalSessionReportMessage
 
 var analyticsServerInitMessageChannel chan *messages.AnalyticsServerInitMessage
 var analyticsServerUpdateMessageChannel chan *messages.AnalyticsServerUpdateMessage
@@ -46,6 +51,7 @@ var analyticsSessionUpdateMessageChannel chan *messages.AnalyticsSessionUpdateMe
 var analyticsSessionSummaryMessageChannel chan *messages.AnalyticsSessionSummaryMessage
 var analyticsClientRelayPingMessageChannel chan *messages.AnalyticsClientRelayPingMessage
 var analyticsServerRelayPingMessageChannel chan *messages.AnalyticsServerRelayPingMessage
+var analyticsSessionReportMessageChannel chan *messages.AnalyticsSessionReportMessage
 
 var enableGooglePubsub bool
 
@@ -54,6 +60,12 @@ var shuttingDown bool
 
 var portalNextSessionsOnly bool
 
+var matchTracker *common.MatchTracker
+var matchUpdateChannel chan common.MatchUpdate
+var rateLimiter *common.RateLimiter
+
+var sessionReportRedisClient redis.Cmdable
+
 var sessionInserter *portal.SessionInserter
 var sessionCruncherURL string
 var serverCruncherURL string
@@ -61,8 +73,10 @@ var sessionInsertBatchSize int
 var serverInsertBatchSize int
 var clientRelayInsertBatchSize int
 var serverRelayInsertBatchSize int
+var sessionReportInsertBatchSize int
 
 var enableRedisTimeSeries bool
+var enablePortalEvents bool
 var redisTimeSeriesHostname string
 var redisTimeSeriesCluster []string
 
@@ -93,12 +107,16 @@ var sessionUpdateSchemaData string
 //go:embed session_summary.json
 var sessionSummarySchemaData string
 
+//go:embed session_report.json
+var sessionReportSchemaData string
+
 var clientRelayPingSchema avro.Schema
 var serverRelayPingSchema avro.Schema
 var serverUpdateSchema avro.Schema
 var serverInitSchema avro.Schema
 var sessionUpdateSchema avro.Schema
 var sessionSummarySchema avro.Schema
+var sessionReportSchema avro.Schema
 
 var enableIP2Location bool
 
@@ -110,14 +128,14 @@ func main() {
 
 	service.ConnectionDrain = true
 
-	pingKey = envvar.GetBase64("PING_KEY", []byte{})
+	pingKey = envvar.GetSecretBase64("PING_KEY", []byte{})
 
 	channelSize = envvar.GetInt("CHANNEL_SIZE", 10*1024*1024)
 	maxPacketSize = envvar.GetInt("UDP_MAX_PACKET_SIZE", 1384)
 	serverBackendAddress = envvar.GetAddress("SERVER_BACKEND_ADDRESS", core.ParseAddress("127.0.0.1:40000"))
 	serverBackendPublicKey = envvar.GetBase64("SERVER_BACKEND_PUBLIC_KEY", []byte{})
-	serverBackendPrivateKey = envvar.GetBase64("SERVER_BACKEND_PRIVATE_KEY", []byte{})
-	relayBackendPrivateKey = envvar.GetBase64("RELAY_BACKEND_PRIVATE_KEY", []byte{})
+	serverBackendPrivateKey = envvar.GetSecretBase64("SERVER_BACKEND_PRIVATE_KEY", []byte{})
+	relayBackendPrivateKey = envvar.GetSecretBase64("RELAY_BACKEND_PRIVATE_KEY", []byte{})
 	relayBackendPublicKey = envvar.GetBase64("RELAY_BACKEND_PUBLIC_KEY", []byte{})
 	enableGooglePubsub = envvar.GetBool("ENABLE_GOOGLE_PUBSUB", false)
 	portalNextSessionsOnly = envvar.GetBool("PORTAL_NEXT_SESSIONS_ONLY", false)
@@ -127,7 +145,9 @@ func main() {
 	serverInsertBatchSize = envvar.GetInt("SERVER_INSERT_BATCH_SIZE", 10000)
 	clientRelayInsertBatchSize = envvar.GetInt("CLIENT_RELAY_INSERT_BATCH_SIZE", 10000)
 	serverRelayInsertBatchSize = envvar.GetInt("SERVER_RELAY_INSERT_BATCH_SIZE", 10000)
+	sessionReportInsertBatchSize = envvar.GetInt("SESSION_REPORT_INSERT_BATCH_SIZE", 1000)
 	enableRedisTimeSeries = envvar.GetBool("ENABLE_REDIS_TIME_SERIES", false)
+	enablePortalEvents = envvar.GetBool("ENABLE_PORTAL_EVENTS", false)
 	redisTimeSeriesCluster = envvar.GetStringArray("REDIS_TIME_SERIES_CLUSTER", []string{})
 	redisTimeSeriesHostname = envvar.GetString("REDIS_TIME_SERIES_HOSTNAME", "127.0.0.1:6379")
 	redisPortalCluster = envvar.GetStringArray("REDIS_PORTAL_CLUSTER", []string{})
@@ -154,7 +174,9 @@ func main() {
 	core.Debug("server insert batch size: %d", serverInsertBatchSize)
 	core.Debug("client relay insert batch size: %d", clientRelayInsertBatchSize)
 	core.Debug("server relay insert batch size: %d", serverRelayInsertBatchSize)
+	core.Debug("session report insert batch size: %d", sessionReportInsertBatchSize)
 	core.Debug("enable ip2location: %v", enableIP2Location)
+	core.Debug("enable portal events: %v", enablePortalEvents)
 
 	if len(pingKey) == 0 {
 		core.Error("You must supply PING_KEY")
@@ -177,6 +199,34 @@ func main() {
 		panic("RELAY_BACKEND_PRIVATE_KEY must be specified")
 	}
 
+	// session data keys. the first key signs, all keys verify. defaults to the server backend keypair
+	//
+	// staged rotation: 1. append the new key on every server backend, 2. move it to the front, 3. once in-flight sessions
+	// are done with the old key (longest match length), remove it. see "next keygen session_data"
+
+	sessionDataPrivateKeys := [][]byte{serverBackendPrivateKey}
+
+	if value := envvar.GetSecretString("SESSION_DATA_PRIVATE_KEYS", ""); value != "" {
+		sessionDataPrivateKeys = sessionDataPrivateKeys[:0]
+		for _, key := range strings.Split(value, ",") {
+			privateKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+			if err != nil {
+				panic(fmt.Sprintf("invalid SESSION_DATA_PRIVATE_KEYS: %v", err))
+			}
+			sessionDataPrivateKeys = append(sessionDataPrivateKeys, privateKey)
+		}
+	}
+
+	var err error
+	sessionDataKeyring, err = crypto.Sign_CreateKeyring(sessionDataPrivateKeys)
+	if err != nil {
+		panic(fmt.Sprintf("invalid session data keys: %v", err))
+	}
+
+	for i := range sessionDataKeyring.KeyIds {
+		core.Debug("session data key %d: %08x", i, sessionDataKeyring.KeyIds[i])
+	}
+
 	// IMPORTANT: don't log the ping key itself, it's a secret. log a fingerprint so
 	// mismatched keys between services can still be diagnosed from debug logs
 	core.Debug("ping key fingerprint: %016x", common.HashString(string(pingKey)))
@@ -215,6 +265,11 @@ func main() {
 		if err != nil {
 			panic(fmt.Sprintf("invalid server init schema: %v", err))
 		}
+
+		sessionReportSchema, err = avro.Parse(sessionReportSchemaData)
+		if err != nil {
+			panic(fmt.Sprintf("invalid session report schema: %v", err))
+		}
 	}
 
 	// initialize fallback to direct channel
@@ -223,12 +278,42 @@ func main() {
 
 	processFallbackToDirect(service, fallbackToDirectChannel)
 
+	// initialize match tracker for route shader match policy
+
+	var matchRedisClient redis.Cmdable
+	if len(redisPortalCluster) > 0 {
+		matchRedisClient = common.CreateRedisClusterClient(redisPortalCluster)
+	} else {
+		matchRedisClient = common.CreateRedisClient(redisPortalHostname)
+	}
+
+	matchTracker = common.CreateMatchTracker(service.Context, common.CreateRedisMatchStore(matchRedisClient))
+
+	matchUpdateChannel = make(chan common.MatchUpdate, channelSize)
+
+	processMatchUpdates(service, matchTracker, matchUpdateChannel)
+
+	// initialize rate limiter for per buyer and per source address packet limits
+
+	rateLimiter = common.NewRateLimiter()
+
+	expireRateLimits(service, rateLimiter)
+
+	// initialize session report dedupe, so SDK resends of a session report are only published once
+
+	if len(redisPortalCluster) > 0 {
+		sessionReportRedisClient = common.CreateRedisClusterClient(redisPortalCluster)
+	} else {
+		sessionReportRedisClient = common.CreateRedisClient(redisPortalHostname)
+	}
+
 	// initialize portal message channels
 
 	portalSessionUpdateMessageChannel = make(chan *messages.PortalSessionUpdateMessage, channelSize)
 	portalServerUpdateMessageChannel = make(chan *messages.PortalServerUpdateMessage, channelSize)
 	portalClientRelayUpdateMessageChannel = make(chan *messages.PortalClientRelayUpdateMessage, channelSize)
 	portalServerRelayUpdateMessageChannel = make(chan *messages.PortalServerRelayUpdateMessage, channelSize)
+	portalSessionReportMessageChannel = make(chan *messages.PortalSessionReportMessage, channelSize)
 
 	if enableRedisTimeSeries {
 
@@ -248,6 +333,7 @@ func main() {
 	processPortalServerUpdateMessages(service, portalServerUpdateMessageChannel)
 	processPortalClientRelayUpdateMessages(service, portalClientRelayUpdateMessageChannel)
 	processPortalServerRelayUpdateMessages(service, portalServerRelayUpdateMessageChannel)
+	processPortalSessionReportMessages(service, portalSessionReportMessageChannel)
 
 	// initialize analytics message channels
 
@@ -257,6 +343,7 @@ func main() {
 	analyticsSessionSummaryMessageChannel = make(chan *messages.AnalyticsSessionSummaryMessage, channelSize)
 	analyticsClientRelayPingMessageChannel = make(chan *messages.AnalyticsClientRelayPingMessage, channelSize)
 	analyticsServerRelayPingMessageChannel = make(chan *messages.AnalyticsServerRelayPingMessage, channelSize)
+	analyticsSessionReportMessageChannel = make(chan *messages.AnalyticsSessionReportMessage, channelSize)
 
 	processAnalyticsMessages_GooglePubsub[*messages.AnalyticsServerInitMessage]("server init", analyticsServerInitMessageChannel, serverInitSchema)
 	processAnalyticsMessages_GooglePubsub[*messages.AnalyticsServerUpdateMessage]("server update", analyticsServerUpdateMessageChannel, serverUpdateSchema)
@@ -264,6 +351,7 @@ func main() {
 	processAnalyticsMessages_GooglePubsub[*messages.AnalyticsServerRelayPingMessage]("server relay ping", analyticsServerRelayPingMessageChannel, serverRelayPingSchema)
 	processAnalyticsMessages_GooglePubsub[*messages.AnalyticsSessionUpdateMessage]("session update", analyticsSessionUpdateMessageChannel, sessionUpdateSchema)
 	processAnalyticsMessages_GooglePubsub[*messages.AnalyticsSessionSummaryMessage]("session summary", analyticsSessionSummaryMessageChannel, sessionSummarySchema)
+	processAnalyticsMessages_GooglePubsub[*messages.AnalyticsSessionReportMessage]("session report", analyticsSessionReportMessageChannel, sessionReportSchema)
 
 	// start the service
 
@@ -387,10 +475,15 @@ func packetHandler(conn *net.UDPConn, from *net.UDPAddr, packetData []byte) {
 
 	handler.PortalNextSessionsOnly = portalNextSessionsOnly
 
+	handler.MatchTracker = matchTracker
+	handler.MatchUpdateChannel = matchUpdateChannel
+	handler.RateLimiter = rateLimiter
+
 	handler.PingKey = pingKey
 	handler.ServerBackendAddress = serverBackendAddress
 	handler.ServerBackendPublicKey = serverBackendPublicKey
 	handler.ServerBackendPrivateKey = serverBackendPrivateKey
+	handler.SessionDataKeyring = sessionDataKeyring
 	handler.RelayBackendPublicKey = relayBackendPublicKey
 	handler.RelayBackendPrivateKey = relayBackendPrivateKey
 	handler.RouteMatrix, handler.Database = service.RouteMatrixAndDatabase()
@@ -405,6 +498,7 @@ func packetHandler(conn *net.UDPConn, from *net.UDPAddr, packetData []byte) {
 	handler.PortalServerUpdateMessageChannel = portalServerUpdateMessageChannel
 	handler.PortalClientRelayUpdateMessageChannel = portalClientRelayUpdateMessageChannel
 	handler.PortalServerRelayUpdateMessageChannel = portalServerRelayUpdateMessageChannel
+	handler.PortalSessionReportMessageChannel = portalSessionReportMessageChannel
 
 	handler.AnalyticsServerInitMessageChannel = analyticsServerInitMessageChannel
 	handler.AnalyticsServerUpdateMessageChannel = analyticsServerUpdateMessageChannel
@@ -412,6 +506,9 @@ func packetHandler(conn *net.UDPConn, from *net.UDPAddr, packetData []byte) {
 	handler.AnalyticsSessionSummaryMessageChannel = analyticsSessionSummaryMessageChannel
 	handler.AnalyticsClientRelayPingMessageChannel = analyticsClientRelayPingMessageChannel
 	handler.AnalyticsServerRelayPingMessageChannel = analyticsServerRelayPingMessageChannel
+	handler.AnalyticsSessionReportMessageChannel = analyticsSessionReportMessageChannel
+
+	handler.FirstSessionReport = firstSessionReport
 
 	handler.LocateIP = locateIP_Real
 	handler.GetISPAndCountry = getISPAndCountry_Real
@@ -425,6 +522,24 @@ func packetHandler(conn *net.UDPConn, from *net.UDPAddr, packetData []byte) {
 	}
 
 	handlers.SDK_PacketHandler(&handler, conn, from, packetData)
+
+	if enableRedisTimeSeries {
+		if handler.Events[handlers.SDK_HandlerEvent_RateLimitedAddress] {
+			publishCounter("rate_limited_address")
+		}
+		if handler.Events[handlers.SDK_HandlerEvent_RateLimitedBuyer] {
+			publishCounter("rate_limited_buyer")
+		}
+	}
+}
+
+// publishCounter never blocks the packet handler. if the counters publisher falls behind during a flood, drop the count instead
+
+func publishCounter(name string) {
+	select {
+	case countersPublisher.MessageChannel <- name:
+	default:
+	}
 }
 
 func locateIP_Local(ip net.IP) (float32, float32) {
@@ -533,6 +648,56 @@ func processFallbackToDirect(service *common.Service, channel chan uint64) {
 	}()
 }
 
+// processMatchUpdates batches the match updates sent by session update handlers, and writes them to the match store once a second
+
+func processMatchUpdates(service *common.Service, matchTracker *common.MatchTracker, channel chan common.MatchUpdate) {
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		for {
+			select {
+			case <-service.Context.Done():
+				return
+			case update := <-channel:
+				matchTracker.UpdateSession(update)
+			case <-ticker.C:
+			}
+			matchTracker.CheckForFlush(time.Now())
+		}
+	}()
+}
+
+func expireRateLimits(service *common.Service, rateLimiter *common.RateLimiter) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		for {
+			select {
+			case <-service.Context.Done():
+				return
+			case <-ticker.C:
+				rateLimiter.Expire(time.Now().UnixNano())
+				core.Debug("rate limiter is tracking %d addresses", rateLimiter.NumAddresses())
+			}
+		}
+	}()
+}
+
+// firstSessionReport claims a (session id, request id) pair in redis. it returns false for resends of a report that was
+// already claimed. if redis can't be reached the report is treated as new, since a duplicate beats a lost report.
+
+const SessionReportDedupeSeconds = 60
+
+func firstSessionReport(sessionId uint64, requestId uint64) bool {
+	ctx, cancel := context.WithTimeout(service.Context, time.Second)
+	defer cancel()
+	key := fmt.Sprintf("srq-%016x-%016x", sessionId, requestId)
+	first, err := sessionReportRedisClient.SetNX(ctx, key, 1, time.Second*SessionReportDedupeSeconds).Result()
+	if err != nil {
+		core.Error("could not check session report %s: %v", key, err)
+		return true
+	}
+	return first
+}
+
 // ------------------------------------------------------------------------------------
 
 func processPortalSessionUpdateMessages(service *common.Service, inputChannel chan *messages.PortalSessionUpdateMessage) {
@@ -545,6 +710,7 @@ func processPortalSessionUpdateMessages(service *common.Service, inputChannel ch
 	}
 
 	sessionInserter = portal.CreateSessionInserter(service.Context, redisClient, sessionCruncherURL, sessionInsertBatchSize)
+	sessionInserter.PublishEvents = enablePortalEvents
 
 	go func() {
 		for {
@@ -608,6 +774,17 @@ func processPortalSessionUpdateMessages(service *common.Service, inputChannel ch
 				GameRTT:           message.GameRTT,
 				GameJitter:        message.GameJitter,
 				GamePacketLoss:    message.GamePacketLoss,
+				RouteChanged:      message.RouteChanged,
+			}
+
+			if message.NumServerRelays > 0 {
+				numFailed := 0
+				for i := 0; i < int(message.NumServerRelays); i++ {
+					if !message.ServerRelayRoutable[i] || message.ServerRelayPacketLoss[i] >= 100.0 {
+						numFailed++
+					}
+				}
+				sliceData.ServerRelayPingFailures = float32(numFailed) / float32(message.NumServerRelays) * 100.0
 			}
 
 			if message.SendToPortal {
@@ -749,6 +926,41 @@ func processPortalServerRelayUpdateMessages(service *common.Service, inputChanne
 	}()
 }
 
+func processPortalSessionReportMessages(service *common.Service, inputChannel chan *messages.PortalSessionReportMessage) {
+
+	var redisClient redis.Cmdable
+	if len(redisPortalCluster) > 0 {
+		redisClient = common.CreateRedisClusterClient(redisPortalCluster)
+	} else {
+		redisClient = common.CreateRedisClient(redisPortalHostname)
+	}
+
+	sessionReportInserter := portal.CreateSessionReportInserter(redisClient, sessionReportInsertBatchSize)
+
+	go func() {
+		for {
+			message := <-inputChannel
+
+			core.Debug("processing session report message")
+
+			sessionReportData := portal.SessionReportData{
+				Timestamp: message.Timestamp,
+				EventCode: message.EventCode,
+				Tags:      message.Tags,
+			}
+
+			sessionReportInserter.Insert(service.Context, message.SessionId, &sessionReportData)
+
+			if enableRedisTimeSeries {
+
+				countersPublisher.MessageChannel <- "session_report"
+
+				countersPublisher.MessageChannel <- fmt.Sprintf("session_report_%016x", message.BuyerId)
+			}
+		}
+	}()
+}
+
 // ------------------------------------------------------------------------------------
 
 func processAnalyticsMessages_GooglePubsub[T any](name string, inputChannel chan T, schema avro.Schema) {
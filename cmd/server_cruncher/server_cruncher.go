@@ -5,6 +5,8 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -13,18 +15,33 @@ import (
 	"github.com/networknext/next/modules/core"
 	"github.com/networknext/next/modules/encoding"
 	"github.com/networknext/next/modules/envvar"
+
+	"github.com/gorilla/mux"
 )
 
 const MaxServerAddressLength = 64 // IMPORTANT: Enough for IPv4 and IPv6 + port number
 
 const TopServersCount = 10000
 
-const ServerBatchVersion = uint64(1)
+const ServerBatchVersion = uint64(2)
 
 const TopServersVersion = uint64(1)
 
+const TopServersByMetricVersion = uint64(0)
+
 type ServerUpdate struct {
-	serverId uint64
+	serverId     uint64
+	buyerId      uint64
+	datacenterId uint64
+	numSessions  uint32
+	uptime       uint64
+}
+
+type ServerEntry struct {
+	buyerId      uint64
+	datacenterId uint64
+	numSessions  uint32
+	uptime       uint64
 }
 
 type TopServers struct {
@@ -37,6 +54,7 @@ type Bucket struct {
 	mutex               sync.Mutex
 	serverUpdateChannel chan []ServerUpdate
 	servers             *common.SortedSet
+	serverEntries       map[uint64]ServerEntry
 }
 
 var buckets []Bucket
@@ -44,6 +62,24 @@ var buckets []Bucket
 var topServersMutex sync.Mutex
 var topServersData []byte
 
+// Server rankings order every server seen in the last minute by one metric each, biggest first.
+// IMPORTANT: metric names must match portal.ServerMetrics
+
+type RankedServer struct {
+	serverId     uint64
+	buyerId      uint64
+	datacenterId uint64
+	value        float32
+}
+
+var serverMetrics = map[string]func(entry *ServerEntry) float32{
+	"sessions": func(entry *ServerEntry) float32 { return float32(entry.numSessions) },
+	"uptime":   func(entry *ServerEntry) float32 { return float32(entry.uptime) },
+}
+
+var serverRankingsMutex sync.Mutex
+var serverRankings map[string][]RankedServer
+
 var service *common.Service
 
 var channelSize int
@@ -56,17 +92,21 @@ func main() {
 
 	service.Router.HandleFunc("/server_batch", serverBatchHandler).Methods("POST")
 	service.Router.HandleFunc("/top_servers", topServersHandler).Methods("GET")
+	service.Router.HandleFunc("/top_servers/{metric}", topServersByMetricHandler).Methods("GET")
 
 	buckets = make([]Bucket, constants.NumBuckets)
 	for i := range buckets {
 		buckets[i].index = i
 		buckets[i].serverUpdateChannel = make(chan []ServerUpdate, channelSize)
 		buckets[i].servers = common.NewSortedSet()
+		buckets[i].serverEntries = make(map[uint64]ServerEntry)
 		StartProcessThread(&buckets[i])
 	}
 
 	UpdateTopServers(&TopServers{})
 
+	UpdateServerRankings(map[string][]RankedServer{})
+
 	// go TestThread()
 
 	go TopSessionsThread()
@@ -97,6 +137,12 @@ func StartProcessThread(bucket *Bucket) {
 				bucket.mutex.Lock()
 				for i := range batch {
 					bucket.servers.Insert(batch[i].serverId, uint32(bucket.index))
+					bucket.serverEntries[batch[i].serverId] = ServerEntry{
+						buyerId:      batch[i].buyerId,
+						datacenterId: batch[i].datacenterId,
+						numSessions:  batch[i].numSessions,
+						uptime:       batch[i].uptime,
+					}
 				}
 				bucket.mutex.Unlock()
 			}
@@ -122,6 +168,41 @@ func UpdateTopServers(newTopServers *TopServers) {
 	topServersMutex.Unlock()
 }
 
+func BuildServerRankings(serverEntries []map[uint64]ServerEntry) map[string][]RankedServer {
+	rankings := make(map[string][]RankedServer, len(serverMetrics))
+	for metric, getValue := range serverMetrics {
+		seen := make(map[uint64]bool)
+		ranking := make([]RankedServer, 0)
+		for i := range serverEntries {
+			for serverId, entry := range serverEntries[i] {
+				if seen[serverId] {
+					continue
+				}
+				seen[serverId] = true
+				value := getValue(&entry)
+				if value <= 0 {
+					continue
+				}
+				ranking = append(ranking, RankedServer{serverId: serverId, buyerId: entry.buyerId, datacenterId: entry.datacenterId, value: value})
+			}
+		}
+		sort.Slice(ranking, func(i, j int) bool {
+			if ranking[i].value != ranking[j].value {
+				return ranking[i].value > ranking[j].value
+			}
+			return ranking[i].serverId < ranking[j].serverId
+		})
+		rankings[metric] = ranking
+	}
+	return rankings
+}
+
+func UpdateServerRankings(rankings map[string][]RankedServer) {
+	serverRankingsMutex.Lock()
+	serverRankings = rankings
+	serverRankingsMutex.Unlock()
+}
+
 func TopSessionsThread() {
 	minuteTicker := common.NewMinuteTicker()
 	minuteTicker.Run(service.Context, func() {
@@ -129,6 +210,7 @@ func TopSessionsThread() {
 		core.Debug("-------------------------------------------------------------------")
 
 		servers := make([]*common.SortedSet, constants.NumBuckets)
+		serverEntries := make([]map[uint64]ServerEntry, constants.NumBuckets)
 
 		for i := range constants.NumBuckets {
 			buckets[i].mutex.Lock()
@@ -136,7 +218,9 @@ func TopSessionsThread() {
 
 		for i := range constants.NumBuckets {
 			servers[i] = buckets[i].servers
+			serverEntries[i] = buckets[i].serverEntries
 			buckets[i].servers = common.NewSortedSet()
+			buckets[i].serverEntries = make(map[uint64]ServerEntry)
 		}
 
 		for i := range constants.NumBuckets {
@@ -182,6 +266,18 @@ func TopSessionsThread() {
 		duration := time.Since(start)
 
 		core.Debug("top %d servers (%.6fms)", len(topServers), float64(duration.Nanoseconds())/1000000.0)
+
+		// rank servers by each metric
+
+		start = time.Now()
+
+		rankings := BuildServerRankings(serverEntries)
+
+		UpdateServerRankings(rankings)
+
+		duration = time.Since(start)
+
+		core.Debug("%d server rankings (%.6fms)", len(rankings), float64(duration.Nanoseconds())/1000000.0)
 	})
 }
 
@@ -212,7 +308,7 @@ func serverBatchHandler(w http.ResponseWriter, r *http.Request) {
 
 	body = body[8:]
 
-	const bytesPerUpdate = 8 // serverId
+	const bytesPerUpdate = 8 + 8 + 8 + 4 + 8 // serverId + buyerId + datacenterId + numSessions + uptime
 
 	index := 0
 	for j := range constants.NumBuckets {
@@ -233,6 +329,10 @@ func serverBatchHandler(w http.ResponseWriter, r *http.Request) {
 			batch := make([]ServerUpdate, numUpdates)
 			for i := 0; i < int(numUpdates); i++ {
 				encoding.ReadUint64(body, &index, &batch[i].serverId)
+				encoding.ReadUint64(body, &index, &batch[i].buyerId)
+				encoding.ReadUint64(body, &index, &batch[i].datacenterId)
+				encoding.ReadUint32(body, &index, &batch[i].numSessions)
+				encoding.ReadUint64(body, &index, &batch[i].uptime)
 			}
 			buckets[j].serverUpdateChannel <- batch
 		}
@@ -246,4 +346,82 @@ func topServersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// parseFilter reads an optional hex id filter from the query string. zero means no filter.
+
+func parseFilter(r *http.Request, name string) (uint64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func topServersByMetricHandler(w http.ResponseWriter, r *http.Request) {
+
+	metric := mux.Vars(r)["metric"]
+	if _, exists := serverMetrics[metric]; !exists {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	buyerId, ok := parseFilter(r, "buyer")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	datacenterId, ok := parseFilter(r, "datacenter")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	count := TopServersCount
+	countString := r.URL.Query().Get("count")
+	if countString != "" {
+		value, err := strconv.Atoi(countString)
+		if err != nil || value <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		count = min(value, TopServersCount)
+	}
+
+	serverRankingsMutex.Lock()
+	ranking := serverRankings[metric]
+	serverRankingsMutex.Unlock()
+
+	servers := make([]RankedServer, 0, count)
+	for i := range ranking {
+		if buyerId != 0 && ranking[i].buyerId != buyerId {
+			continue
+		}
+		if datacenterId != 0 && ranking[i].datacenterId != datacenterId {
+			continue
+		}
+		servers = append(servers, ranking[i])
+		if len(servers) >= count {
+			break
+		}
+	}
+
+	data := make([]byte, 8+4+len(servers)*(8+4))
+
+	index := 0
+
+	encoding.WriteUint64(data[:], &index, TopServersByMetricVersion)
+	encoding.WriteUint32(data[:], &index, uint32(len(servers)))
+
+	for i := range servers {
+		encoding.WriteUint64(data[:], &index, servers[i].serverId)
+		encoding.WriteFloat32(data[:], &index, servers[i].value)
+	}
+
+	w.Write(data)
+}
+
 // ---------------------------------------------------------------------------------------
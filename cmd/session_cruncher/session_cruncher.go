@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,11 +17,13 @@ import (
 	"github.com/networknext/next/modules/core"
 	"github.com/networknext/next/modules/encoding"
 	"github.com/networknext/next/modules/envvar"
+
+	"github.com/gorilla/mux"
 )
 
 const TopSessionsCount = 10000
 
-const SessionBatchVersion = uint64(0)
+const SessionBatchVersion = uint64(2)
 
 const TopSessionsVersion = uint64(0)
 
@@ -28,11 +31,26 @@ const BuyerStatsVersion = uint64(0)
 
 const MapPointsVersion = uint64(0)
 
+const HeatmapVersion = uint64(0)
+
+const TopSessionsByMetricVersion = uint64(0)
+
+const MinHeatmapPrecision = 1
+const MaxHeatmapPrecision = 4
+
 type SessionUpdate struct {
-	sessionId uint64
-	next      uint8
-	latitude  float32
-	longitude float32
+	sessionId    uint64
+	next         uint8
+	latitude     float32
+	longitude    float32
+	buyerId      uint64
+	datacenterId uint64
+	directRTT    uint32
+	nextRTT      uint32
+	packetLoss   float32
+	jitter       uint32
+	startTime    uint64
+	routeChanged uint8
 }
 
 type TopSessions struct {
@@ -54,9 +72,17 @@ var topSessionsMutex sync.Mutex
 var topSessionsData []byte
 
 type MapEntry struct {
-	latitude  float32
-	longitude float32
-	next      uint8
+	latitude     float32
+	longitude    float32
+	next         uint8
+	buyerId      uint64
+	datacenterId uint64
+	directRTT    uint32
+	nextRTT      uint32
+	packetLoss   float32
+	jitter       uint32
+	startTime    uint64
+	routeChanges uint32
 }
 
 type MapPoint struct {
@@ -74,6 +100,58 @@ type MapPoints struct {
 var mapDataMutex sync.Mutex
 var mapData []byte
 
+// Heatmaps aggregate every session seen in the last minute (not just the top sessions) into geohash
+// cells, globally, per-buyer and per-datacenter, at each precision from MinHeatmapPrecision up to
+// MaxHeatmapPrecision. They are encoded once per-minute and served as-is.
+
+type HeatmapCell struct {
+	numSessions     uint32
+	numNextSessions uint32
+	totalDirectRTT  uint64
+	totalNextRTT    uint64
+}
+
+type Heatmap struct {
+	precision int
+	cells     map[string]*HeatmapCell
+}
+
+var heatmapMutex sync.Mutex
+var heatmapData map[string][]byte
+
+// Session rankings order every session seen in the last minute by one metric each, worst (or biggest)
+// first, so the top sessions list can answer more than "which sessions does next improve the most".
+// Packet loss and jitter are the worst seen during the minute, route changes are summed over the minute.
+// IMPORTANT: metric names must match portal.SessionMetrics
+
+type RankedSession struct {
+	sessionId    uint64
+	buyerId      uint64
+	datacenterId uint64
+	value        float32
+}
+
+var sessionMetrics = map[string]func(entry *MapEntry, currentTime uint64) float32{
+	"packet_loss": func(entry *MapEntry, currentTime uint64) float32 { return entry.packetLoss },
+	"jitter":      func(entry *MapEntry, currentTime uint64) float32 { return float32(entry.jitter) },
+	"duration": func(entry *MapEntry, currentTime uint64) float32 {
+		if entry.startTime == 0 || entry.startTime > currentTime {
+			return 0
+		}
+		return float32(currentTime - entry.startTime)
+	},
+	"latency_reduction": func(entry *MapEntry, currentTime uint64) float32 {
+		if entry.next == 0 || entry.nextRTT == 0 || entry.nextRTT >= entry.directRTT {
+			return 0
+		}
+		return float32(entry.directRTT - entry.nextRTT)
+	},
+	"route_changes": func(entry *MapEntry, currentTime uint64) float32 { return float32(entry.routeChanges) },
+}
+
+var sessionRankingsMutex sync.Mutex
+var sessionRankings map[string][]RankedSession
+
 var channelSize int
 
 var enableRedisTimeSeries bool
@@ -101,7 +179,11 @@ func main() {
 
 	service.Router.HandleFunc("/session_batch", sessionBatchHandler).Methods("POST")
 	service.Router.HandleFunc("/top_sessions", topSessionsHandler).Methods("GET")
+	service.Router.HandleFunc("/top_sessions/{metric}", topSessionsByMetricHandler).Methods("GET")
 	service.Router.HandleFunc("/map_data", mapDataHandler).Methods("GET")
+	service.Router.HandleFunc("/heatmap/{precision}", heatmapHandler).Methods("GET")
+	service.Router.HandleFunc("/heatmap/buyer/{buyer_id}/{precision}", heatmapHandler).Methods("GET")
+	service.Router.HandleFunc("/heatmap/datacenter/{datacenter_id}/{precision}", heatmapHandler).Methods("GET")
 
 	buckets = make([]Bucket, constants.NumBuckets)
 	for i := range buckets {
@@ -116,6 +198,10 @@ func main() {
 
 	UpdateMapData(&MapPoints{})
 
+	UpdateHeatmaps(map[string]*Heatmap{})
+
+	UpdateSessionRankings(map[string][]RankedSession{})
+
 	//go TestThread()
 
 	go TopSessionsThread()
@@ -247,7 +333,20 @@ func StartProcessThread(bucket *Bucket) {
 				bucket.mutex.Lock()
 				for i := range batch {
 					bucket.totalSessions.Insert(batch[i].sessionId, uint32(bucket.index))
-					bucket.mapEntries[batch[i].sessionId] = MapEntry{next: batch[i].next, latitude: batch[i].latitude, longitude: batch[i].longitude}
+					previous := bucket.mapEntries[batch[i].sessionId]
+					bucket.mapEntries[batch[i].sessionId] = MapEntry{
+						next:         batch[i].next,
+						latitude:     batch[i].latitude,
+						longitude:    batch[i].longitude,
+						buyerId:      batch[i].buyerId,
+						datacenterId: batch[i].datacenterId,
+						directRTT:    batch[i].directRTT,
+						nextRTT:      batch[i].nextRTT,
+						packetLoss:   max(previous.packetLoss, batch[i].packetLoss),
+						jitter:       max(previous.jitter, batch[i].jitter),
+						startTime:    batch[i].startTime,
+						routeChanges: previous.routeChanges + uint32(batch[i].routeChanged),
+					}
 				}
 				bucket.mutex.Unlock()
 			}
@@ -293,6 +392,140 @@ func UpdateMapData(newMapPoints *MapPoints) {
 	mapDataMutex.Unlock()
 }
 
+func heatmapKey(scope string, id uint64, precision int) string {
+	if scope == "" {
+		return fmt.Sprintf("%d", precision)
+	}
+	return fmt.Sprintf("%s/%016x/%d", scope, id, precision)
+}
+
+// MergeMapEntries combines the per-bucket map entries into one entry per-session. A session shows up in
+// more than one bucket if its score changed during the minute, so take the worst of each metric and sum
+// route changes across buckets.
+
+func MergeMapEntries(mapEntries []map[uint64]MapEntry) map[uint64]MapEntry {
+	merged := make(map[uint64]MapEntry)
+	for i := range mapEntries {
+		for sessionId, entry := range mapEntries[i] {
+			previous, exists := merged[sessionId]
+			if exists {
+				entry.packetLoss = max(previous.packetLoss, entry.packetLoss)
+				entry.jitter = max(previous.jitter, entry.jitter)
+				entry.routeChanges += previous.routeChanges
+			}
+			merged[sessionId] = entry
+		}
+	}
+	return merged
+}
+
+func BuildHeatmaps(sessionEntries map[uint64]MapEntry) map[string]*Heatmap {
+
+	heatmaps := make(map[string]*Heatmap)
+
+	addSession := func(key string, precision int, geohash string, entry *MapEntry) {
+		heatmap, exists := heatmaps[key]
+		if !exists {
+			heatmap = &Heatmap{precision: precision, cells: make(map[string]*HeatmapCell)}
+			heatmaps[key] = heatmap
+		}
+		cell, exists := heatmap.cells[geohash]
+		if !exists {
+			cell = &HeatmapCell{}
+			heatmap.cells[geohash] = cell
+		}
+		cell.numSessions++
+		cell.totalDirectRTT += uint64(entry.directRTT)
+		if entry.next != 0 {
+			cell.numNextSessions++
+			cell.totalNextRTT += uint64(entry.nextRTT)
+		}
+	}
+
+	for _, entry := range sessionEntries {
+		geohash := common.Geohash(entry.latitude, entry.longitude, MaxHeatmapPrecision)
+		for precision := MinHeatmapPrecision; precision <= MaxHeatmapPrecision; precision++ {
+			cell := geohash[:precision]
+			addSession(heatmapKey("", 0, precision), precision, cell, &entry)
+			addSession(heatmapKey("buyer", entry.buyerId, precision), precision, cell, &entry)
+			addSession(heatmapKey("datacenter", entry.datacenterId, precision), precision, cell, &entry)
+		}
+	}
+
+	return heatmaps
+}
+
+func EncodeHeatmap(heatmap *Heatmap) []byte {
+
+	numCells := len(heatmap.cells)
+
+	precision := heatmap.precision
+
+	data := make([]byte, 8+1+4+numCells*(precision+4+4+4+4))
+
+	index := 0
+
+	encoding.WriteUint64(data[:], &index, HeatmapVersion)
+	encoding.WriteUint8(data[:], &index, uint8(precision))
+	encoding.WriteUint32(data[:], &index, uint32(numCells))
+
+	for geohash, cell := range heatmap.cells {
+		acceleratedPercent := float32(cell.numNextSessions) / float32(cell.numSessions) * 100.0
+		directRTT := float32(cell.totalDirectRTT) / float32(cell.numSessions)
+		nextRTT := float32(0.0)
+		if cell.numNextSessions > 0 {
+			nextRTT = float32(cell.totalNextRTT) / float32(cell.numNextSessions)
+		}
+		encoding.WriteBytes(data[:], &index, []byte(geohash), precision)
+		encoding.WriteUint32(data[:], &index, cell.numSessions)
+		encoding.WriteFloat32(data[:], &index, acceleratedPercent)
+		encoding.WriteFloat32(data[:], &index, directRTT)
+		encoding.WriteFloat32(data[:], &index, nextRTT)
+	}
+
+	return data
+}
+
+func UpdateHeatmaps(heatmaps map[string]*Heatmap) {
+
+	data := make(map[string][]byte, len(heatmaps))
+	for key, heatmap := range heatmaps {
+		data[key] = EncodeHeatmap(heatmap)
+	}
+
+	heatmapMutex.Lock()
+	heatmapData = data
+	heatmapMutex.Unlock()
+}
+
+func BuildSessionRankings(sessionEntries map[uint64]MapEntry, currentTime uint64) map[string][]RankedSession {
+	rankings := make(map[string][]RankedSession, len(sessionMetrics))
+	for metric, getValue := range sessionMetrics {
+		ranking := make([]RankedSession, 0)
+		for sessionId, entry := range sessionEntries {
+			value := getValue(&entry, currentTime)
+			if value <= 0 {
+				continue
+			}
+			ranking = append(ranking, RankedSession{sessionId: sessionId, buyerId: entry.buyerId, datacenterId: entry.datacenterId, value: value})
+		}
+		sort.Slice(ranking, func(i, j int) bool {
+			if ranking[i].value != ranking[j].value {
+				return ranking[i].value > ranking[j].value
+			}
+			return ranking[i].sessionId < ranking[j].sessionId
+		})
+		rankings[metric] = ranking
+	}
+	return rankings
+}
+
+func UpdateSessionRankings(rankings map[string][]RankedSession) {
+	sessionRankingsMutex.Lock()
+	sessionRankings = rankings
+	sessionRankingsMutex.Unlock()
+}
+
 func TopSessionsThread() {
 	minuteTicker := common.NewMinuteTicker()
 	minuteTicker.Run(service.Context, func() {
@@ -374,6 +607,24 @@ func TopSessionsThread() {
 		duration := time.Since(start)
 
 		core.Debug("top %d sessions (%.6fms)", len(sessions), float64(duration.Nanoseconds())/1000000.0)
+
+		// build heatmaps and session rankings from all sessions seen in the last minute
+
+		start = time.Now()
+
+		sessionEntries := MergeMapEntries(mapEntries)
+
+		heatmaps := BuildHeatmaps(sessionEntries)
+
+		UpdateHeatmaps(heatmaps)
+
+		rankings := BuildSessionRankings(sessionEntries, uint64(time.Now().Unix()))
+
+		UpdateSessionRankings(rankings)
+
+		duration = time.Since(start)
+
+		core.Debug("%d heatmaps, %d session rankings (%.6fms)", len(heatmaps), len(rankings), float64(duration.Nanoseconds())/1000000.0)
 	})
 }
 
@@ -404,7 +655,7 @@ func sessionBatchHandler(w http.ResponseWriter, r *http.Request) {
 
 	body = body[8:]
 
-	const bytesPerUpdate = 8 + 1 + 4 + 4 // sessionId + next + latitude + longitude
+	const bytesPerUpdate = 8 + 1 + 4 + 4 + 8 + 8 + 4 + 4 + 4 + 4 + 8 + 1 // sessionId + next + latitude + longitude + buyerId + datacenterId + directRTT + nextRTT + packetLoss + jitter + startTime + routeChanged
 
 	index := 0
 	for j := range constants.NumBuckets {
@@ -428,6 +679,14 @@ func sessionBatchHandler(w http.ResponseWriter, r *http.Request) {
 				encoding.ReadUint8(body[:], &index, &batch[i].next)
 				encoding.ReadFloat32(body[:], &index, &batch[i].latitude)
 				encoding.ReadFloat32(body[:], &index, &batch[i].longitude)
+				encoding.ReadUint64(body[:], &index, &batch[i].buyerId)
+				encoding.ReadUint64(body[:], &index, &batch[i].datacenterId)
+				encoding.ReadUint32(body[:], &index, &batch[i].directRTT)
+				encoding.ReadUint32(body[:], &index, &batch[i].nextRTT)
+				encoding.ReadFloat32(body[:], &index, &batch[i].packetLoss)
+				encoding.ReadUint32(body[:], &index, &batch[i].jitter)
+				encoding.ReadUint64(body[:], &index, &batch[i].startTime)
+				encoding.ReadUint8(body[:], &index, &batch[i].routeChanged)
 			}
 			buckets[j].sessionUpdateChannel <- batch
 		}
@@ -448,4 +707,118 @@ func mapDataHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// parseFilter reads an optional hex id filter from the query string. zero means no filter.
+
+func parseFilter(r *http.Request, name string) (uint64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func topSessionsByMetricHandler(w http.ResponseWriter, r *http.Request) {
+
+	metric := mux.Vars(r)["metric"]
+	if _, exists := sessionMetrics[metric]; !exists {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	buyerId, ok := parseFilter(r, "buyer")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	datacenterId, ok := parseFilter(r, "datacenter")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	count := TopSessionsCount
+	countString := r.URL.Query().Get("count")
+	if countString != "" {
+		value, err := strconv.Atoi(countString)
+		if err != nil || value <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		count = min(value, TopSessionsCount)
+	}
+
+	sessionRankingsMutex.Lock()
+	ranking := sessionRankings[metric]
+	sessionRankingsMutex.Unlock()
+
+	sessions := make([]RankedSession, 0, count)
+	for i := range ranking {
+		if buyerId != 0 && ranking[i].buyerId != buyerId {
+			continue
+		}
+		if datacenterId != 0 && ranking[i].datacenterId != datacenterId {
+			continue
+		}
+		sessions = append(sessions, ranking[i])
+		if len(sessions) >= count {
+			break
+		}
+	}
+
+	data := make([]byte, 8+4+len(sessions)*(8+4))
+
+	index := 0
+
+	encoding.WriteUint64(data[:], &index, TopSessionsByMetricVersion)
+	encoding.WriteUint32(data[:], &index, uint32(len(sessions)))
+
+	for i := range sessions {
+		encoding.WriteUint64(data[:], &index, sessions[i].sessionId)
+		encoding.WriteFloat32(data[:], &index, sessions[i].value)
+	}
+
+	w.Write(data)
+}
+
+func heatmapHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+
+	precision, err := strconv.Atoi(vars["precision"])
+	if err != nil || precision < MinHeatmapPrecision || precision > MaxHeatmapPrecision {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key := heatmapKey("", 0, precision)
+
+	for _, scope := range []string{"buyer", "datacenter"} {
+		idString, exists := vars[scope+"_id"]
+		if !exists {
+			continue
+		}
+		id, err := strconv.ParseUint(idString, 16, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		key = heatmapKey(scope, id, precision)
+	}
+
+	heatmapMutex.Lock()
+	data, exists := heatmapData[key]
+	heatmapMutex.Unlock()
+
+	if !exists {
+		data = EncodeHeatmap(&Heatmap{precision: precision})
+	}
+
+	w.Write(data)
+}
+
 // ---------------------------------------------------------------------------------------
@@ -1,17 +1,32 @@
 package admin
 
 import (
+	"bytes"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 
+	"github.com/networknext/next/modules/core"
+	"github.com/networknext/next/modules/packets"
+
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/nacl/box"
 )
 
+// pgsqlConn is implemented by both *sql.DB and *sql.Tx, so every controller method also works inside a transaction
+
+type pgsqlConn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 type Controller struct {
-	pgsql *sql.DB
+	pgsql pgsqlConn
+	db    *sql.DB
+	tx    *sql.Tx
 }
 
 func CreateController(config string) *Controller {
@@ -24,29 +39,65 @@ func CreateController(config string) *Controller {
 		panic(fmt.Sprintf("could not ping postgres: %v", err))
 	}
 	fmt.Printf("successfully connected to postgres\n")
-	return &Controller{pgsql: pgsql}
+	return &Controller{pgsql: pgsql, db: pgsql}
+}
+
+// BeginTransaction returns a controller that runs every call in one postgres transaction, until Commit or Rollback.
+
+func (controller *Controller) BeginTransaction() (*Controller, error) {
+	if controller.tx != nil {
+		return nil, fmt.Errorf("already in a transaction\n")
+	}
+	tx, err := controller.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v\n", err)
+	}
+	return &Controller{pgsql: tx, db: controller.db, tx: tx}, nil
+}
+
+func (controller *Controller) Commit() error {
+	if controller.tx == nil {
+		return fmt.Errorf("not in a transaction\n")
+	}
+	return controller.tx.Commit()
+}
+
+func (controller *Controller) Rollback() error {
+	if controller.tx == nil {
+		return fmt.Errorf("not in a transaction\n")
+	}
+	return controller.tx.Rollback()
 }
 
 // -----------------------------------------------------------------------
 
 type RouteShaderData struct {
-	RouteShaderId             uint64  `json:"route_shader_id"`
-	RouteShaderName           string  `json:"route_shader_name"`
-	ABTest                    bool    `json:"ab_test"`
-	AcceptableLatency         int     `json:"acceptable_latency"`
-	AcceptablePacketLoss      float64 `json:"acceptable_packet_loss"`
-	BandwidthEnvelopeUpKbps   int     `json:"bandwidth_envelope_up_kbps"`
-	BandwidthEnvelopeDownKbps int     `json:"bandwidth_envelope_down_kbps"`
-	DisableNetworkNext        bool    `json:"disable_network_next"`
-	LatencyReductionThreshold int     `json:"latency_reduction_threshold"`
-	SelectionPercent          int     `json:"selection_percent"`
-	MaxLatencyTradeOff        int     `json:"max_latency_trade_off"`
-	RouteSwitchThreshold      int     `json:"route_switch_threshold"`
-	RouteSelectThreshold      int     `json:"route_select_threshold"`
-	ForceNext                 bool    `json:"force_next"`
+	RouteShaderId                    uint64  `json:"route_shader_id"`
+	RouteShaderName                  string  `json:"route_shader_name"`
+	ABTest                           bool    `json:"ab_test"`
+	AcceptableLatency                int     `json:"acceptable_latency"`
+	AcceptablePacketLoss             float64 `json:"acceptable_packet_loss"`
+	BandwidthEnvelopeUpKbps          int     `json:"bandwidth_envelope_up_kbps"`
+	BandwidthEnvelopeDownKbps        int     `json:"bandwidth_envelope_down_kbps"`
+	DisableNetworkNext               bool    `json:"disable_network_next"`
+	LatencyReductionThreshold        int     `json:"latency_reduction_threshold"`
+	SelectionPercent                 int     `json:"selection_percent"`
+	MaxLatencyTradeOff               int     `json:"max_latency_trade_off"`
+	RouteSwitchThreshold             int     `json:"route_switch_threshold"`
+	RouteSelectThreshold             int     `json:"route_select_threshold"`
+	ForceNext                        bool    `json:"force_next"`
+	RequiredPacketBytes              int     `json:"required_packet_bytes"`
+	MatchPolicy                      int     `json:"match_policy"`
+	MaxMatchLatencyDisparity         int     `json:"max_match_latency_disparity"`
+	BandwidthEnvelopeMaxUpKbps       int     `json:"bandwidth_envelope_max_up_kbps"`
+	BandwidthEnvelopeMaxDownKbps     int     `json:"bandwidth_envelope_max_down_kbps"`
+	BandwidthEnvelopeHeadroomPercent int     `json:"bandwidth_envelope_headroom_percent"`
 }
 
 func (controller *Controller) CreateRouteShader(routeShaderData *RouteShaderData) (uint64, error) {
+	if err := validateMatchPolicy(routeShaderData); err != nil {
+		return 0, fmt.Errorf("could not create route shader: %v\n", err)
+	}
 	sql := `
 INSERT INTO route_shaders 
 (
@@ -62,7 +113,13 @@ INSERT INTO route_shaders
 	max_latency_trade_off,
 	route_switch_threshold,
 	route_select_threshold,
-	force_next
+	force_next,
+	required_packet_bytes,
+	match_policy,
+	max_match_latency_disparity,
+	bandwidth_envelope_max_up_kbps,
+	bandwidth_envelope_max_down_kbps,
+	bandwidth_envelope_headroom_percent
 )
 VALUES
 (
@@ -78,7 +135,13 @@ VALUES
 	$10,
 	$11,
 	$12,
-	$13
+	$13,
+	$14,
+	$15,
+	$16,
+	$17,
+	$18,
+	$19
 )
 RETURNING route_shader_id;`
 	result := controller.pgsql.QueryRow(sql,
@@ -95,6 +158,12 @@ RETURNING route_shader_id;`
 		routeShaderData.RouteSwitchThreshold,
 		routeShaderData.RouteSelectThreshold,
 		routeShaderData.ForceNext,
+		routeShaderData.RequiredPacketBytes,
+		routeShaderData.MatchPolicy,
+		routeShaderData.MaxMatchLatencyDisparity,
+		routeShaderData.BandwidthEnvelopeMaxUpKbps,
+		routeShaderData.BandwidthEnvelopeMaxDownKbps,
+		routeShaderData.BandwidthEnvelopeHeadroomPercent,
 	)
 	routeShaderId := uint64(0)
 	if err := result.Scan(&routeShaderId); err != nil {
@@ -120,7 +189,13 @@ SELECT
 	max_latency_trade_off,
 	route_switch_threshold,
 	route_select_threshold,
-	force_next
+	force_next,
+	required_packet_bytes,
+	match_policy,
+	max_match_latency_disparity,
+	bandwidth_envelope_max_up_kbps,
+	bandwidth_envelope_max_down_kbps,
+	bandwidth_envelope_headroom_percent
 FROM
 	route_shaders;`
 	rows, err := controller.pgsql.Query(sql)
@@ -145,6 +220,12 @@ FROM
 			&row.RouteSwitchThreshold,
 			&row.RouteSelectThreshold,
 			&row.ForceNext,
+			&row.RequiredPacketBytes,
+			&row.MatchPolicy,
+			&row.MaxMatchLatencyDisparity,
+			&row.BandwidthEnvelopeMaxUpKbps,
+			&row.BandwidthEnvelopeMaxDownKbps,
+			&row.BandwidthEnvelopeHeadroomPercent,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("could not scan route shader row: %v\n", err)
@@ -174,7 +255,13 @@ SELECT
 	max_latency_trade_off,
 	route_switch_threshold,
 	route_select_threshold,
-	force_next
+	force_next,
+	required_packet_bytes,
+	match_policy,
+	max_match_latency_disparity,
+	bandwidth_envelope_max_up_kbps,
+	bandwidth_envelope_max_down_kbps,
+	bandwidth_envelope_headroom_percent
 FROM
 	route_shaders
 WHERE
@@ -200,6 +287,12 @@ WHERE
 			&routeShader.RouteSwitchThreshold,
 			&routeShader.RouteSelectThreshold,
 			&routeShader.ForceNext,
+			&routeShader.RequiredPacketBytes,
+			&routeShader.MatchPolicy,
+			&routeShader.MaxMatchLatencyDisparity,
+			&routeShader.BandwidthEnvelopeMaxUpKbps,
+			&routeShader.BandwidthEnvelopeMaxDownKbps,
+			&routeShader.BandwidthEnvelopeHeadroomPercent,
 		)
 		if err != nil {
 			return routeShader, fmt.Errorf("could not scan route shader row: %v\n", err)
@@ -214,6 +307,9 @@ WHERE
 }
 
 func (controller *Controller) UpdateRouteShader(routeShaderData *RouteShaderData) error {
+	if err := validateMatchPolicy(routeShaderData); err != nil {
+		return fmt.Errorf("could not update route shader: %v\n", err)
+	}
 	// IMPORTANT: Cannot change route shader id once created
 	sql := `
 UPDATE route_shaders 
@@ -230,9 +326,15 @@ SET
 	max_latency_trade_off = $10,	
 	route_switch_threshold = $11,
 	route_select_threshold = $12,
-	force_next = $13
+	force_next = $13,
+	required_packet_bytes = $14,
+	match_policy = $15,
+	max_match_latency_disparity = $16,
+	bandwidth_envelope_max_up_kbps = $17,
+	bandwidth_envelope_max_down_kbps = $18,
+	bandwidth_envelope_headroom_percent = $19
 WHERE
-	route_shader_id = $14;`
+	route_shader_id = $20;`
 	_, err := controller.pgsql.Exec(sql,
 		routeShaderData.RouteShaderName,
 		routeShaderData.ABTest,
@@ -247,6 +349,12 @@ WHERE
 		routeShaderData.RouteSwitchThreshold,
 		routeShaderData.RouteSelectThreshold,
 		routeShaderData.ForceNext,
+		routeShaderData.RequiredPacketBytes,
+		routeShaderData.MatchPolicy,
+		routeShaderData.MaxMatchLatencyDisparity,
+		routeShaderData.BandwidthEnvelopeMaxUpKbps,
+		routeShaderData.BandwidthEnvelopeMaxDownKbps,
+		routeShaderData.BandwidthEnvelopeHeadroomPercent,
 		routeShaderData.RouteShaderId,
 	)
 	return err
@@ -258,6 +366,17 @@ func (controller *Controller) DeleteRouteShader(routeShaderId uint64) error {
 	return err
 }
 
+// validateMatchPolicy checks the route shader's match policy is one the server backend knows how to apply.
+func validateMatchPolicy(routeShaderData *RouteShaderData) error {
+	if !core.IsValidMatchPolicy(int32(routeShaderData.MatchPolicy)) {
+		return fmt.Errorf("invalid match policy: %d", routeShaderData.MatchPolicy)
+	}
+	if routeShaderData.MaxMatchLatencyDisparity < 0 {
+		return fmt.Errorf("invalid max match latency disparity: %d", routeShaderData.MaxMatchLatencyDisparity)
+	}
+	return nil
+}
+
 // -----------------------------------------------------------------------
 
 type BuyerData struct {
@@ -268,6 +387,12 @@ type BuyerData struct {
 	RouteShaderId   uint64 `json:"route_shader_id"`
 	Live            bool   `json:"live"`
 	Debug           bool   `json:"debug"`
+
+	RateLimitPacketsPerSecond        int `json:"rate_limit_packets_per_second"`
+	RateLimitAddressPacketsPerSecond int `json:"rate_limit_address_packets_per_second"`
+
+	MinSDKVersion         string `json:"min_sdk_version"`
+	RecommendedSDKVersion string `json:"recommended_sdk_version"`
 }
 
 func (controller *Controller) CreateBuyer(buyerData *BuyerData) (uint64, error) {
@@ -278,8 +403,11 @@ func (controller *Controller) CreateBuyer(buyerData *BuyerData) (uint64, error)
 			return 0, fmt.Errorf("could not create buyer: invalid public key\n")
 		}
 	}
-	sql := "INSERT INTO buyers (buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug) VALUES ($1, $2, $3, $4, $5, $6) RETURNING buyer_id;"
-	result := controller.pgsql.QueryRow(sql, buyerData.BuyerName, buyerData.BuyerCode, buyerData.PublicKeyBase64, buyerData.RouteShaderId, buyerData.Live, buyerData.Debug)
+	if err := validateSDKVersions(buyerData); err != nil {
+		return 0, fmt.Errorf("could not create buyer: %v\n", err)
+	}
+	sql := "INSERT INTO buyers (buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug, rate_limit_packets_per_second, rate_limit_address_packets_per_second, min_sdk_version, recommended_sdk_version) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING buyer_id;"
+	result := controller.pgsql.QueryRow(sql, buyerData.BuyerName, buyerData.BuyerCode, buyerData.PublicKeyBase64, buyerData.RouteShaderId, buyerData.Live, buyerData.Debug, buyerData.RateLimitPacketsPerSecond, buyerData.RateLimitAddressPacketsPerSecond, buyerData.MinSDKVersion, buyerData.RecommendedSDKVersion)
 	buyerId := uint64(0)
 	if err := result.Scan(&buyerId); err != nil {
 		return 0, fmt.Errorf("could not insert buyer: %v\n", err)
@@ -289,14 +417,14 @@ func (controller *Controller) CreateBuyer(buyerData *BuyerData) (uint64, error)
 
 func (controller *Controller) ReadBuyers() ([]BuyerData, error) {
 	buyers := make([]BuyerData, 0)
-	rows, err := controller.pgsql.Query("SELECT buyer_id, buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug FROM buyers;")
+	rows, err := controller.pgsql.Query("SELECT buyer_id, buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug, rate_limit_packets_per_second, rate_limit_address_packets_per_second, min_sdk_version, recommended_sdk_version FROM buyers;")
 	if err != nil {
 		return nil, fmt.Errorf("could not read buyers: %v\n", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		row := BuyerData{}
-		if err := rows.Scan(&row.BuyerId, &row.BuyerName, &row.BuyerCode, &row.PublicKeyBase64, &row.RouteShaderId, &row.Live, &row.Debug); err != nil {
+		if err := rows.Scan(&row.BuyerId, &row.BuyerName, &row.BuyerCode, &row.PublicKeyBase64, &row.RouteShaderId, &row.Live, &row.Debug, &row.RateLimitPacketsPerSecond, &row.RateLimitAddressPacketsPerSecond, &row.MinSDKVersion, &row.RecommendedSDKVersion); err != nil {
 			return nil, fmt.Errorf("could not scan buyer row: %v\n", err)
 		}
 		buyers = append(buyers, row)
@@ -309,13 +437,13 @@ func (controller *Controller) ReadBuyers() ([]BuyerData, error) {
 
 func (controller *Controller) ReadBuyer(buyerId uint64) (BuyerData, error) {
 	buyer := BuyerData{}
-	rows, err := controller.pgsql.Query("SELECT buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug FROM buyers WHERE buyer_id = $1;", buyerId)
+	rows, err := controller.pgsql.Query("SELECT buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug, rate_limit_packets_per_second, rate_limit_address_packets_per_second, min_sdk_version, recommended_sdk_version FROM buyers WHERE buyer_id = $1;", buyerId)
 	if err != nil {
 		return buyer, fmt.Errorf("could not read buyer: %v\n", err)
 	}
 	defer rows.Close()
 	if rows.Next() {
-		if err := rows.Scan(&buyer.BuyerName, &buyer.BuyerCode, &buyer.PublicKeyBase64, &buyer.RouteShaderId, &buyer.Live, &buyer.Debug); err != nil {
+		if err := rows.Scan(&buyer.BuyerName, &buyer.BuyerCode, &buyer.PublicKeyBase64, &buyer.RouteShaderId, &buyer.Live, &buyer.Debug, &buyer.RateLimitPacketsPerSecond, &buyer.RateLimitAddressPacketsPerSecond, &buyer.MinSDKVersion, &buyer.RecommendedSDKVersion); err != nil {
 			return buyer, fmt.Errorf("could not scan buyer row: %v\n", err)
 		}
 		buyer.BuyerId = buyerId
@@ -336,8 +464,11 @@ func (controller *Controller) UpdateBuyer(buyerData *BuyerData) error {
 		}
 	}
 	// IMPORTANT: Cannot change buyer id once created
-	sql := "UPDATE buyers SET buyer_name = $1, buyer_code = $2, public_key_base64 = $3, route_shader_id = $4, live = $5, debug = $6 WHERE buyer_id = $7;"
-	_, err := controller.pgsql.Exec(sql, buyerData.BuyerName, buyerData.BuyerCode, buyerData.PublicKeyBase64, buyerData.RouteShaderId, buyerData.Live, buyerData.Debug, buyerData.BuyerId)
+	if err := validateSDKVersions(buyerData); err != nil {
+		return fmt.Errorf("could not update buyer: %v\n", err)
+	}
+	sql := "UPDATE buyers SET buyer_name = $1, buyer_code = $2, public_key_base64 = $3, route_shader_id = $4, live = $5, debug = $6, rate_limit_packets_per_second = $7, rate_limit_address_packets_per_second = $8, min_sdk_version = $9, recommended_sdk_version = $10 WHERE buyer_id = $11;"
+	_, err := controller.pgsql.Exec(sql, buyerData.BuyerName, buyerData.BuyerCode, buyerData.PublicKeyBase64, buyerData.RouteShaderId, buyerData.Live, buyerData.Debug, buyerData.RateLimitPacketsPerSecond, buyerData.RateLimitAddressPacketsPerSecond, buyerData.MinSDKVersion, buyerData.RecommendedSDKVersion, buyerData.BuyerId)
 	return err
 }
 
@@ -347,6 +478,27 @@ func (controller *Controller) DeleteBuyer(buyerId uint64) error {
 	return err
 }
 
+// validateSDKVersions checks the buyer's sdk versions are empty or "major.minor.patch", and that the recommended
+// version is not below the minimum.
+func validateSDKVersions(buyerData *BuyerData) error {
+	var minVersion, recommendedVersion packets.SDKVersion
+	var err error
+	if buyerData.MinSDKVersion != "" {
+		if minVersion, err = packets.ParseSDKVersion(buyerData.MinSDKVersion); err != nil {
+			return fmt.Errorf("invalid min sdk version: %v", err)
+		}
+	}
+	if buyerData.RecommendedSDKVersion != "" {
+		if recommendedVersion, err = packets.ParseSDKVersion(buyerData.RecommendedSDKVersion); err != nil {
+			return fmt.Errorf("invalid recommended sdk version: %v", err)
+		}
+		if !recommendedVersion.AtLeast(minVersion) {
+			return fmt.Errorf("recommended sdk version %s is below the min sdk version %s", buyerData.RecommendedSDKVersion, buyerData.MinSDKVersion)
+		}
+	}
+	return nil
+}
+
 // -----------------------------------------------------------------------
 
 type SellerData struct {
@@ -879,6 +1031,93 @@ func (controller *Controller) DeleteBuyerDatacenterSettings(buyerId uint64, data
 
 // -----------------------------------------------------------------------
 
+type BuyerKeyData struct {
+	BuyerKeyId      uint64 `json:"buyer_key_id"`
+	BuyerId         uint64 `json:"buyer_id"`
+	PublicKeyBase64 string `json:"public_key_base64"`
+	NotBefore       int64  `json:"not_before"`
+	NotAfter        int64  `json:"not_after"`
+}
+
+func (controller *Controller) CreateBuyerKey(buyerKeyData *BuyerKeyData) (uint64, error) {
+	// IMPORTANT: The buyer id prefix of a rotation key must match the buyer's primary public key, or it can never verify!!!
+	{
+		buyer, err := controller.ReadBuyer(buyerKeyData.BuyerId)
+		if err != nil {
+			return 0, fmt.Errorf("could not create buyer key: %v\n", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(buyerKeyData.PublicKeyBase64)
+		if err != nil || len(data) != 40 {
+			return 0, fmt.Errorf("could not create buyer key: invalid public key\n")
+		}
+		buyerData, err := base64.StdEncoding.DecodeString(buyer.PublicKeyBase64)
+		if err != nil || len(buyerData) != 40 || !bytes.Equal(data[:8], buyerData[:8]) {
+			return 0, fmt.Errorf("could not create buyer key: public key does not match buyer id\n")
+		}
+	}
+	sql := "INSERT INTO buyer_keys (buyer_id, public_key_base64, not_before, not_after) VALUES ($1, $2, $3, $4) RETURNING buyer_key_id;"
+	result := controller.pgsql.QueryRow(sql, buyerKeyData.BuyerId, buyerKeyData.PublicKeyBase64, buyerKeyData.NotBefore, buyerKeyData.NotAfter)
+	buyerKeyId := uint64(0)
+	if err := result.Scan(&buyerKeyId); err != nil {
+		return 0, fmt.Errorf("could not insert buyer key: %v\n", err)
+	}
+	return buyerKeyId, nil
+}
+
+func (controller *Controller) ReadBuyerKeys() ([]BuyerKeyData, error) {
+	buyerKeys := make([]BuyerKeyData, 0)
+	rows, err := controller.pgsql.Query("SELECT buyer_key_id, buyer_id, public_key_base64, not_before, not_after FROM buyer_keys;")
+	if err != nil {
+		return nil, fmt.Errorf("could not read buyer keys: %v\n", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := BuyerKeyData{}
+		if err := rows.Scan(&row.BuyerKeyId, &row.BuyerId, &row.PublicKeyBase64, &row.NotBefore, &row.NotAfter); err != nil {
+			return nil, fmt.Errorf("could not scan buyer key row: %v\n", err)
+		}
+		buyerKeys = append(buyerKeys, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("buyer key rows error: %v\n", err)
+	}
+	return buyerKeys, nil
+}
+
+func (controller *Controller) ReadBuyerKey(buyerKeyId uint64) (BuyerKeyData, error) {
+	buyerKey := BuyerKeyData{}
+	rows, err := controller.pgsql.Query("SELECT buyer_key_id, buyer_id, public_key_base64, not_before, not_after FROM buyer_keys WHERE buyer_key_id = $1;", buyerKeyId)
+	if err != nil {
+		return buyerKey, fmt.Errorf("could not read buyer key: %v\n", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&buyerKey.BuyerKeyId, &buyerKey.BuyerId, &buyerKey.PublicKeyBase64, &buyerKey.NotBefore, &buyerKey.NotAfter); err != nil {
+			return buyerKey, fmt.Errorf("could not scan buyer key row: %v\n", err)
+		}
+		return buyerKey, nil
+	}
+	if err := rows.Err(); err != nil {
+		return buyerKey, fmt.Errorf("rows error: %v\n", err)
+	}
+	return buyerKey, fmt.Errorf("buyer key %x not found", buyerKeyId)
+}
+
+func (controller *Controller) UpdateBuyerKey(buyerKeyData *BuyerKeyData) error {
+	// IMPORTANT: Only the validity window can change. Create a new buyer key for a new public key
+	sql := "UPDATE buyer_keys SET not_before = $1, not_after = $2 WHERE buyer_key_id = $3;"
+	_, err := controller.pgsql.Exec(sql, buyerKeyData.NotBefore, buyerKeyData.NotAfter, buyerKeyData.BuyerKeyId)
+	return err
+}
+
+func (controller *Controller) DeleteBuyerKey(buyerKeyId uint64) error {
+	sql := "DELETE FROM buyer_keys WHERE buyer_key_id = $1;"
+	_, err := controller.pgsql.Exec(sql, buyerKeyId)
+	return err
+}
+
+// -----------------------------------------------------------------------
+
 type RelayKeypairData struct {
 	RelayKeypairId   uint64 `json:"relay_keypair_id"`
 	PublicKeyBase64  string `json:"public_key_base64"`
@@ -951,3 +1190,65 @@ func (controller *Controller) DeleteRelayKeypair(relayKeypairId uint64) error {
 }
 
 // -----------------------------------------------------------------------
+
+// The audit log is append-only. There is no update or delete here, and the table has rules that turn both into no-ops.
+
+type AuditEntry struct {
+	AuditId   uint64          `json:"audit_id"`
+	Timestamp int64           `json:"timestamp"`
+	Actor     string          `json:"actor"`
+	Endpoint  string          `json:"endpoint"`
+	Entity    string          `json:"entity"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+func (controller *Controller) CreateAuditEntry(entry *AuditEntry) (uint64, error) {
+	sql := "INSERT INTO audit_log (timestamp, actor, endpoint, entity, before_json, after_json) VALUES ($1, $2, $3, $4, $5, $6) RETURNING audit_id;"
+	result := controller.pgsql.QueryRow(sql, entry.Timestamp, entry.Actor, entry.Endpoint, entry.Entity, auditJSON(entry.Before), auditJSON(entry.After))
+	auditId := uint64(0)
+	if err := result.Scan(&auditId); err != nil {
+		return 0, fmt.Errorf("could not insert audit entry: %v\n", err)
+	}
+	return auditId, nil
+}
+
+// ReadAuditLog returns audit entries at or after since (unix seconds), oldest first. Empty actor or entity matches all.
+
+func (controller *Controller) ReadAuditLog(since int64, actor string, entity string) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	sql := "SELECT audit_id, timestamp, actor, endpoint, entity, before_json, after_json FROM audit_log WHERE timestamp >= $1 AND ($2::varchar = '' OR actor = $2::varchar) AND ($3::varchar = '' OR entity = $3::varchar) ORDER BY audit_id;"
+	rows, err := controller.pgsql.Query(sql, since, actor, entity)
+	if err != nil {
+		return nil, fmt.Errorf("could not read audit log: %v\n", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := AuditEntry{}
+		var before, after []byte
+		if err := rows.Scan(&row.AuditId, &row.Timestamp, &row.Actor, &row.Endpoint, &row.Entity, &before, &after); err != nil {
+			return nil, fmt.Errorf("could not scan audit log row: %v\n", err)
+		}
+		row.Before = nullJSON(before)
+		row.After = nullJSON(after)
+		entries = append(entries, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("audit log rows error: %v\n", err)
+	}
+	return entries, nil
+}
+
+func auditJSON(data json.RawMessage) any {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	return string(data)
+}
+
+func nullJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(data)
+}
@@ -13,8 +13,8 @@ import (
 
 const (
 	CostMatrixVersion_Min   = 1 // the minimum version we can read
-	CostMatrixVersion_Max   = 2 // the maximum version we can read
-	CostMatrixVersion_Write = 2 // the version we write
+	CostMatrixVersion_Max   = 3 // the maximum version we can read
+	CostMatrixVersion_Write = 3 // the version we write
 )
 
 type CostMatrix struct {
@@ -28,6 +28,7 @@ type CostMatrix struct {
 	DestRelays         []bool
 	Costs              []uint8
 	RelayPrice         []uint8
+	RelayMTU           []uint16 // path mtu per relay pair, same layout as costs. 0 if not known
 }
 
 func (m *CostMatrix) GetMaxSize() int {
@@ -36,6 +37,7 @@ func (m *CostMatrix) GetMaxSize() int {
 	numRelays := len(m.RelayIds)
 	size := 256 + numRelays*(8+19+constants.MaxRelayNameLength+4+4+8+1) + core.TriMatrixLength(numRelays) + numRelays + 4
 	size += 4
+	size += core.TriMatrixLength(numRelays) * 2
 	if size%8 != 0 {
 		size += 8 - size%8
 	}
@@ -96,6 +98,17 @@ func (m *CostMatrix) Serialize(stream serialize.Stream) error {
 		stream.SerializeBool(&m.DestRelays[i])
 	}
 
+	// IMPORTANT: relay mtu is optional when writing. nil means no relay pair mtu is known
+
+	if stream.IsReading() || len(m.RelayMTU) == 0 {
+		m.RelayMTU = make([]uint16, core.TriMatrixLength(int(numRelays)))
+	}
+	if m.Version >= 3 {
+		for i := range m.RelayMTU {
+			stream.SerializeUint16(&m.RelayMTU[i])
+		}
+	}
+
 	return stream.Err()
 }
 
@@ -153,5 +166,10 @@ func GenerateRandomCostMatrix(numRelays int) CostMatrix {
 		costMatrix.RelayPrice[i] = uint8(RandomInt(0, 255))
 	}
 
+	costMatrix.RelayMTU = make([]uint16, costSize)
+	for i := range costSize {
+		costMatrix.RelayMTU[i] = uint16(RandomInt(0, constants.MaxPacketBytes))
+	}
+
 	return costMatrix
 }
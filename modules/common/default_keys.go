@@ -84,6 +84,20 @@ func DatabaseHasCommittedBuyerKey(database *db.Database) (string, bool) {
 	return "", false
 }
 
+// CommittedKeyEnvVars returns the key env vars set to a key committed to the source repo. Keys encrypted with the
+// secrets master key are decrypted first, so sealing a committed key doesn't hide it from the check.
+
+func CommittedKeyEnvVars() []string {
+	names := []string{}
+	for i := range keyEnvVars {
+		value := envvar.GetSecretString(keyEnvVars[i], "")
+		if value != "" && IsCommittedKey(value) {
+			names = append(names, keyEnvVars[i])
+		}
+	}
+	return names
+}
+
 // checkForCommittedKeys refuses to run in prod on keys committed to the source repo,
 // and warns in dev/staging. local is exempt: local dev and the functional tests use
 // the committed keys by design.
@@ -94,16 +108,12 @@ func (service *Service) checkForCommittedKeys() {
 		return
 	}
 
-	for i := range keyEnvVars {
-		value := envvar.GetString(keyEnvVars[i], "")
-		if value == "" || !IsCommittedKey(value) {
-			continue
-		}
+	for _, name := range CommittedKeyEnvVars() {
 		if service.Env == "prod" {
-			core.Error("%s is a well-known key committed to the network next source repository and cannot be used in prod. run 'next keygen' to generate keys for this install", keyEnvVars[i])
+			core.Error("%s is a well-known key committed to the network next source repository and cannot be used in prod. run 'next keygen' to generate keys for this install", name)
 			os.Exit(1)
 		}
-		core.Warn("%s is a well-known key committed to the network next source repository. run 'next keygen' before going to prod", keyEnvVars[i])
+		core.Warn("%s is a well-known key committed to the network next source repository. run 'next keygen' before going to prod", name)
 	}
 }
 
@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/networknext/next/modules/common"
+	"github.com/networknext/next/modules/crypto"
 	db "github.com/networknext/next/modules/database"
+	"github.com/networknext/next/modules/envvar"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -54,3 +56,27 @@ func TestDatabaseHasCommittedBuyerKey(t *testing.T) {
 	_, found = common.DatabaseHasCommittedBuyerKey(database)
 	assert.False(t, found)
 }
+
+func TestCommittedKeyEnvVars(t *testing.T) {
+
+	// not parallel: sets env vars
+
+	masterKey := crypto.Secrets_MasterKey()
+
+	t.Setenv("SECRETS_MASTER_KEY", base64.StdEncoding.EncodeToString(masterKey))
+	t.Setenv("SECRETS_MASTER_KEY_FILE", "")
+
+	// a committed key is found in plaintext, and when sealed with the secrets master key
+
+	sealed, err := envvar.EncryptSecret("xsBL4b6PO4ESADcc69kERzLXxs9ESOrX1kSHJH0m9D0=", masterKey)
+	assert.NoError(t, err)
+
+	t.Setenv("PING_KEY", sealed)
+	t.Setenv("RELAY_BACKEND_PUBLIC_KEY", "IsjRpWEz9H7qslhWWupW4A9LIpVh+PzWoLleuXL1NUE=")
+	t.Setenv("RELAY_BACKEND_PRIVATE_KEY", "aGVsbG8gd29ybGQ=")
+
+	names := common.CommittedKeyEnvVars()
+	assert.Contains(t, names, "PING_KEY")
+	assert.Contains(t, names, "RELAY_BACKEND_PUBLIC_KEY")
+	assert.NotContains(t, names, "RELAY_BACKEND_PRIVATE_KEY")
+}
@@ -0,0 +1,224 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EtcdLeaseStore implements LeaseStore on etcd v3 through its JSON gateway, so we don't need the etcd client library.
+// The leader key is attached to an etcd lease and created only if it doesn't exist, so it disappears when the holder
+// stops renewing. The term is the create revision of the leader key, which etcd guarantees increases every time the
+// key is created. Use one store per election. Only the leader key goes in etcd, instance data goes in a LeaseDataStore.
+
+const EtcdLeaseStoreKeyPrefix = "/networknext/"
+
+type EtcdLeaseStore struct {
+	Endpoint string
+
+	httpClient *http.Client
+
+	mutex   sync.Mutex
+	leaseId int64
+}
+
+func CreateEtcdLeaseStore(endpoint string) *EtcdLeaseStore {
+	return &EtcdLeaseStore{
+		Endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type etcdKeyValue struct {
+	Key            string `json:"key"`
+	Value          string `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	Lease          int64  `json:"lease,string"`
+}
+
+type etcdRangeRequest struct {
+	Key string `json:"key"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdPutRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+type etcdCompare struct {
+	Target         string `json:"target"`
+	Key            string `json:"key"`
+	CreateRevision int64  `json:"create_revision,string"`
+}
+
+type etcdRequestOp struct {
+	RequestRange *etcdRangeRequest `json:"request_range,omitempty"`
+	RequestPut   *etcdPutRequest   `json:"request_put,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+	Failure []etcdRequestOp `json:"failure"`
+}
+
+type etcdResponseHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type etcdResponseOp struct {
+	ResponseRange *etcdRangeResponse `json:"response_range"`
+}
+
+type etcdTxnResponse struct {
+	Header    etcdResponseHeader `json:"header"`
+	Succeeded bool               `json:"succeeded"`
+	Responses []etcdResponseOp   `json:"responses"`
+}
+
+type etcdLeaseGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type etcdLeaseGrantResponse struct {
+	ID  int64 `json:"ID,string"`
+	TTL int64 `json:"TTL,string"`
+}
+
+type etcdLeaseKeepAliveRequest struct {
+	ID int64 `json:"ID,string"`
+}
+
+type etcdLeaseKeepAliveResponse struct {
+	Result etcdLeaseGrantResponse `json:"result"`
+}
+
+func etcdEncode(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func (store *EtcdLeaseStore) post(ctx context.Context, path string, request any, response any) error {
+	requestData, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", store.Endpoint+path, bytes.NewReader(requestData))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpResponse, err := store.httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	responseData, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s returned %d: %s", path, httpResponse.StatusCode, string(responseData))
+	}
+	return json.Unmarshal(responseData, response)
+}
+
+func (store *EtcdLeaseStore) leaderKey(name string) string {
+	return EtcdLeaseStoreKeyPrefix + name + "/leader"
+}
+
+func (store *EtcdLeaseStore) Acquire(ctx context.Context, name string, holderId string, duration time.Duration) (Lease, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	start := time.Now()
+
+	ttl := int64(duration.Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	// renew our etcd lease, or grant a new one if it has expired
+
+	leaseId := store.leaseId
+
+	if leaseId != 0 {
+		keepAliveResponse := etcdLeaseKeepAliveResponse{}
+		err := store.post(ctx, "/v3/lease/keepalive", etcdLeaseKeepAliveRequest{ID: leaseId}, &keepAliveResponse)
+		if err != nil {
+			return Lease{}, err
+		}
+		if keepAliveResponse.Result.TTL <= 0 {
+			leaseId = 0
+		}
+	}
+
+	if leaseId == 0 {
+		grantResponse := etcdLeaseGrantResponse{}
+		err := store.post(ctx, "/v3/lease/grant", etcdLeaseGrantRequest{TTL: ttl}, &grantResponse)
+		if err != nil {
+			return Lease{}, err
+		}
+		leaseId = grantResponse.ID
+	}
+
+	store.leaseId = leaseId
+
+	// create the leader key if it doesn't exist, otherwise read who holds it
+
+	key := etcdEncode(store.leaderKey(name))
+
+	txnRequest := etcdTxnRequest{
+		Compare: []etcdCompare{{Target: "CREATE", Key: key, CreateRevision: 0}},
+		Success: []etcdRequestOp{{RequestPut: &etcdPutRequest{Key: key, Value: etcdEncode(holderId), Lease: leaseId}}},
+		Failure: []etcdRequestOp{{RequestRange: &etcdRangeRequest{Key: key}}},
+	}
+
+	txnResponse := etcdTxnResponse{}
+	err := store.post(ctx, "/v3/kv/txn", txnRequest, &txnResponse)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	if txnResponse.Succeeded {
+		return Lease{HolderId: holderId, Term: uint64(txnResponse.Header.Revision), ExpireTime: start.Add(duration)}, nil
+	}
+
+	if len(txnResponse.Responses) == 0 || txnResponse.Responses[0].ResponseRange == nil || len(txnResponse.Responses[0].ResponseRange.Kvs) == 0 {
+		// the leader key expired between the compare and the range. try again next time
+		return Lease{}, nil
+	}
+
+	return store.leaseFromKeyValue(txnResponse.Responses[0].ResponseRange.Kvs[0], start, duration)
+}
+
+func (store *EtcdLeaseStore) leaseFromKeyValue(kv etcdKeyValue, start time.Time, duration time.Duration) (Lease, error) {
+	holderId, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return Lease{}, fmt.Errorf("could not decode leader key: %v", err)
+	}
+	return Lease{HolderId: string(holderId), Term: uint64(kv.CreateRevision), ExpireTime: start.Add(duration)}, nil
+}
+
+func (store *EtcdLeaseStore) Holder(ctx context.Context, name string) (Lease, error) {
+	rangeResponse := etcdRangeResponse{}
+	err := store.post(ctx, "/v3/kv/range", etcdRangeRequest{Key: etcdEncode(store.leaderKey(name))}, &rangeResponse)
+	if err != nil {
+		return Lease{}, err
+	}
+	if len(rangeResponse.Kvs) == 0 {
+		return Lease{}, nil
+	}
+	return store.leaseFromKeyValue(rangeResponse.Kvs[0], time.Now(), 0)
+}
@@ -0,0 +1,96 @@
+package common
+
+// Geohash encoding, used to bucket sessions into grid cells for the portal heatmap. Each character
+// adds five bits of precision, alternating longitude and latitude, so precision 1 cells are roughly
+// 5000km across, precision 2 ~1250km, precision 3 ~156km and precision 4 ~39km.
+
+const MinGeohashPrecision = 1
+const MaxGeohashPrecision = 12
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func Geohash(latitude float32, longitude float32, precision int) string {
+
+	precision = max(MinGeohashPrecision, min(precision, MaxGeohashPrecision))
+
+	minLatitude, maxLatitude := -90.0, 90.0
+	minLongitude, maxLongitude := -180.0, 180.0
+
+	lat := max(-90.0, min(float64(latitude), 90.0))
+	long := max(-180.0, min(float64(longitude), 180.0))
+
+	hash := make([]byte, precision)
+
+	even := true
+	for i := range precision {
+		value := 0
+		for range 5 {
+			value <<= 1
+			if even {
+				mid := (minLongitude + maxLongitude) / 2
+				if long >= mid {
+					value |= 1
+					minLongitude = mid
+				} else {
+					maxLongitude = mid
+				}
+			} else {
+				mid := (minLatitude + maxLatitude) / 2
+				if lat >= mid {
+					value |= 1
+					minLatitude = mid
+				} else {
+					maxLatitude = mid
+				}
+			}
+			even = !even
+		}
+		hash[i] = geohashAlphabet[value]
+	}
+
+	return string(hash)
+}
+
+// GeohashCenter returns the latitude and longitude at the center of a geohash cell. ok is false if the
+// geohash contains characters outside the geohash alphabet.
+
+func GeohashCenter(hash string) (latitude float32, longitude float32, ok bool) {
+
+	minLatitude, maxLatitude := -90.0, 90.0
+	minLongitude, maxLongitude := -180.0, 180.0
+
+	even := true
+	for i := range len(hash) {
+		value := -1
+		for j := range len(geohashAlphabet) {
+			if geohashAlphabet[j] == hash[i] {
+				value = j
+				break
+			}
+		}
+		if value < 0 {
+			return 0, 0, false
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := (value>>bit)&1 != 0
+			if even {
+				mid := (minLongitude + maxLongitude) / 2
+				if set {
+					minLongitude = mid
+				} else {
+					maxLongitude = mid
+				}
+			} else {
+				mid := (minLatitude + maxLatitude) / 2
+				if set {
+					minLatitude = mid
+				} else {
+					maxLatitude = mid
+				}
+			}
+			even = !even
+		}
+	}
+
+	return float32((minLatitude + maxLatitude) / 2), float32((minLongitude + maxLongitude) / 2), true
+}
@@ -0,0 +1,50 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeohash(t *testing.T) {
+
+	t.Parallel()
+
+	// reference values from the original geohash.org implementation
+
+	assert.Equal(t, "ezs42", common.Geohash(42.6, -5.6, 5))
+	assert.Equal(t, "u4pruyd", common.Geohash(57.64911, 10.40744, 7))
+	assert.Equal(t, "9q8y", common.Geohash(37.7749, -122.4194, 4))
+
+	// precision is clamped
+
+	assert.Equal(t, 1, len(common.Geohash(0, 0, 0)))
+	assert.Equal(t, common.MaxGeohashPrecision, len(common.Geohash(0, 0, 100)))
+
+	// out of range coordinates are clamped rather than producing garbage
+
+	assert.Equal(t, common.Geohash(90, 180, 4), common.Geohash(1000, 1000, 4))
+}
+
+func TestGeohashCenter(t *testing.T) {
+
+	t.Parallel()
+
+	latitude, longitude, ok := common.GeohashCenter("ezs42")
+	assert.True(t, ok)
+	assert.InDelta(t, 42.605, latitude, 0.01)
+	assert.InDelta(t, -5.603, longitude, 0.01)
+
+	// the center of a cell encodes back to the same cell
+
+	for _, hash := range []string{"9q8y", "u4pr", "r3gx", "0", "zzzz"} {
+		latitude, longitude, ok := common.GeohashCenter(hash)
+		assert.True(t, ok)
+		assert.Equal(t, hash, common.Geohash(latitude, longitude, len(hash)))
+	}
+
+	_, _, ok = common.GeohashCenter("abc")
+	assert.False(t, ok)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/networknext/next/modules/core"
+)
+
+// LeaderElection picks one instance of a service to be leader. Every leadership term has a fencing token that
+// increases each time leadership changes hands, so consumers of leader data (eg. route matrices) can reject data
+// written by a stale leader that still believes it is leader, for example during a network partition.
+// IMPORTANT: fencing tokens from different backends are not comparable. restart consumers when switching backends!
+
+type LeaderElection interface {
+	Start(ctx context.Context)
+	Update(ctx context.Context)
+	Store(ctx context.Context, name string, data []byte, expiration time.Duration) // zero expiration means no expiration
+	Load(ctx context.Context, name string) []byte
+	IsLeader() bool
+	IsReady() bool
+	FencingToken() uint64
+}
+
+const (
+	LeaderElectionBackend_Redis = "redis"
+	LeaderElectionBackend_Etcd  = "etcd"
+)
+
+// FencingTokenGuard accepts leader data with a fencing token at least as new as the newest token it has seen.
+// If nothing has been accepted for StaleTimeout, the newest token is forgotten and a lower token is accepted. This
+// recovers when the fencing token counter is reset (eg. the redis term key is lost), which otherwise would reject
+// every new leader forever. Data without a fencing token (zero) is from an older leader and is always accepted.
+
+type FencingTokenGuard struct {
+	StaleTimeout time.Duration
+
+	token      uint64
+	acceptTime time.Time
+}
+
+func (guard *FencingTokenGuard) Accept(token uint64, currentTime time.Time) bool {
+	if token == 0 {
+		return true
+	}
+	if token < guard.token {
+		if currentTime.Sub(guard.acceptTime) <= guard.StaleTimeout {
+			return false
+		}
+		core.Warn("fencing token %d is stale, accepting fencing token %d", guard.token, token)
+	}
+	guard.token = token
+	guard.acceptTime = currentTime
+	return true
+}
+
+func (guard *FencingTokenGuard) Token() uint64 {
+	return guard.token
+}
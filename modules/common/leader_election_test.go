@@ -0,0 +1,308 @@
+package common_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseLeaderElection(t *testing.T) {
+
+	t.Parallel()
+
+	ctx := context.Background()
+
+	currentTime := time.Now()
+
+	store := common.CreateMemoryLeaseStore()
+	store.Now = func() time.Time { return currentTime }
+
+	// negative initial delay so we don't have to wait for it
+
+	config := common.LeaseLeaderElectionConfig{ServiceName: "test", InitialDelay: -1, LeaseDuration: 10 * time.Second}
+
+	a, err := common.CreateLeaseLeaderElection(store, store, config)
+	assert.Nil(t, err)
+
+	b, err := common.CreateLeaseLeaderElection(store, store, config)
+	assert.Nil(t, err)
+
+	assert.False(t, a.IsReady())
+	assert.False(t, b.IsReady())
+
+	// the first instance to acquire the lease is leader
+
+	a.Update(ctx)
+	b.Update(ctx)
+
+	assert.True(t, a.IsReady())
+	assert.True(t, b.IsReady())
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	firstToken := a.FencingToken()
+	assert.NotEqual(t, uint64(0), firstToken)
+
+	a.Store(ctx, "data", []byte("leader a"), 0)
+	b.Store(ctx, "data", []byte("follower b"), 0)
+
+	assert.Equal(t, []byte("leader a"), a.Load(ctx, "data"))
+	assert.Equal(t, []byte("leader a"), b.Load(ctx, "data"))
+	assert.Equal(t, []byte("leader a"), common.LoadLeaseLeaderServiceData(ctx, store, store, "test", "data"))
+
+	// renewing the lease keeps the same term
+
+	currentTime = currentTime.Add(5 * time.Second)
+
+	a.Update(ctx)
+	b.Update(ctx)
+
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+	assert.Equal(t, firstToken, a.FencingToken())
+
+	// when the leader stops renewing, the lease expires and the other instance takes over with a newer fencing token
+
+	currentTime = currentTime.Add(11 * time.Second)
+
+	b.Update(ctx)
+
+	assert.True(t, b.IsLeader())
+	assert.Greater(t, b.FencingToken(), firstToken)
+
+	// the stale leader still believes it is leader until it next updates, but its fencing token is older
+
+	assert.True(t, a.IsLeader())
+	assert.Less(t, a.FencingToken(), b.FencingToken())
+
+	a.Update(ctx)
+
+	assert.False(t, a.IsLeader())
+	assert.Equal(t, []byte("follower b"), a.Load(ctx, "data"))
+}
+
+// fakeEtcd is just enough of the etcd v3 JSON gateway to exercise EtcdLeaseStore: leases, range and the
+// create-if-missing txn. Expiring a lease deletes the keys attached to it, like etcd does.
+
+type fakeEtcdKeyValue struct {
+	value          string
+	createRevision int64
+	lease          int64
+}
+
+type fakeEtcd struct {
+	mutex       sync.Mutex
+	revision    int64
+	nextLeaseId int64
+	leases      map[int64]bool
+	kvs         map[string]fakeEtcdKeyValue
+}
+
+func (etcd *fakeEtcd) put(key string, value string, lease int64) {
+	etcd.revision++
+	kv, exists := etcd.kvs[key]
+	if !exists {
+		kv.createRevision = etcd.revision
+	}
+	kv.value = value
+	kv.lease = lease
+	etcd.kvs[key] = kv
+}
+
+func (etcd *fakeEtcd) rangeResponse(key string) map[string]any {
+	kv, exists := etcd.kvs[key]
+	if !exists {
+		return map[string]any{}
+	}
+	return map[string]any{"kvs": []any{map[string]any{"key": key, "value": kv.value, "create_revision": fmt.Sprintf("%d", kv.createRevision), "lease": fmt.Sprintf("%d", kv.lease)}}}
+}
+
+func (etcd *fakeEtcd) expireLease(leaseId int64) {
+	etcd.mutex.Lock()
+	defer etcd.mutex.Unlock()
+	delete(etcd.leases, leaseId)
+	for key, kv := range etcd.kvs {
+		if kv.lease == leaseId {
+			delete(etcd.kvs, key)
+		}
+	}
+}
+
+func (etcd *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	etcd.mutex.Lock()
+	defer etcd.mutex.Unlock()
+
+	var request map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	str := func(m map[string]any, key string) string {
+		value, _ := m[key].(string)
+		return value
+	}
+
+	num := func(m map[string]any, key string) int64 {
+		value, _ := strconv.ParseInt(str(m, key), 10, 64)
+		return value
+	}
+
+	var response any
+
+	switch r.URL.Path {
+
+	case "/v3/lease/grant":
+		etcd.nextLeaseId++
+		etcd.leases[etcd.nextLeaseId] = true
+		response = map[string]any{"ID": fmt.Sprintf("%d", etcd.nextLeaseId), "TTL": str(request, "TTL")}
+
+	case "/v3/lease/keepalive":
+		ttl := "0"
+		if etcd.leases[num(request, "ID")] {
+			ttl = "10"
+		}
+		response = map[string]any{"result": map[string]any{"ID": str(request, "ID"), "TTL": ttl}}
+
+	case "/v3/kv/range":
+		response = etcd.rangeResponse(str(request, "key"))
+
+	case "/v3/kv/txn":
+		compare := request["compare"].([]any)[0].(map[string]any)
+		key := str(compare, "key")
+		_, exists := etcd.kvs[key]
+		if !exists {
+			put := request["success"].([]any)[0].(map[string]any)["request_put"].(map[string]any)
+			etcd.put(key, str(put, "value"), num(put, "lease"))
+			response = map[string]any{"header": map[string]any{"revision": fmt.Sprintf("%d", etcd.revision)}, "succeeded": true}
+		} else {
+			response = map[string]any{"header": map[string]any{"revision": fmt.Sprintf("%d", etcd.revision)}, "responses": []any{map[string]any{"response_range": etcd.rangeResponse(key)}}}
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestEtcdLeaseStore(t *testing.T) {
+
+	t.Parallel()
+
+	ctx := context.Background()
+
+	etcd := &fakeEtcd{leases: make(map[int64]bool), kvs: make(map[string]fakeEtcdKeyValue)}
+
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	a := common.CreateEtcdLeaseStore(server.URL)
+	b := common.CreateEtcdLeaseStore(server.URL)
+
+	// nobody holds the lease to start with
+
+	holder, err := a.Holder(ctx, "test")
+	assert.Nil(t, err)
+	assert.Equal(t, "", holder.HolderId)
+
+	// acquire: the first store to acquire the lease holds it, and the other store sees it held
+
+	leaseA, err := a.Acquire(ctx, "test", "a", 10*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", leaseA.HolderId)
+	assert.NotEqual(t, uint64(0), leaseA.Term)
+
+	leaseB, err := b.Acquire(ctx, "test", "b", 10*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", leaseB.HolderId)
+	assert.Equal(t, leaseA.Term, leaseB.Term)
+
+	holder, err = b.Holder(ctx, "test")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", holder.HolderId)
+
+	// renew: acquiring again while we hold the lease keeps the same term
+
+	renewed, err := a.Acquire(ctx, "test", "a", 10*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", renewed.HolderId)
+	assert.Equal(t, leaseA.Term, renewed.Term)
+
+	// lose: when the holder's lease expires, the next store to acquire takes over with a newer term
+
+	etcd.expireLease(1)
+
+	leaseB, err = b.Acquire(ctx, "test", "b", 10*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", leaseB.HolderId)
+	assert.Greater(t, leaseB.Term, leaseA.Term)
+
+	// the old holder sees that it lost the lease, and can't take it back while the new holder renews it
+
+	lost, err := a.Acquire(ctx, "test", "a", 10*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", lost.HolderId)
+	assert.Equal(t, leaseB.Term, lost.Term)
+}
+
+func TestEtcdLeaseStore_Error(t *testing.T) {
+
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store := common.CreateEtcdLeaseStore(server.URL)
+
+	_, err := store.Acquire(context.Background(), "test", "a", 10*time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestFencingTokenGuard(t *testing.T) {
+
+	t.Parallel()
+
+	currentTime := time.Now()
+
+	guard := common.FencingTokenGuard{StaleTimeout: 30 * time.Second}
+
+	// newer and equal fencing tokens are accepted, and data without a fencing token is always accepted
+
+	assert.True(t, guard.Accept(5, currentTime))
+	assert.True(t, guard.Accept(5, currentTime))
+	assert.True(t, guard.Accept(6, currentTime))
+	assert.True(t, guard.Accept(0, currentTime))
+	assert.Equal(t, uint64(6), guard.Token())
+
+	// older fencing tokens are from a stale leader and are rejected
+
+	currentTime = currentTime.Add(10 * time.Second)
+
+	assert.False(t, guard.Accept(5, currentTime))
+	assert.Equal(t, uint64(6), guard.Token())
+
+	// rejecting doesn't extend the stale timeout. once nothing newer has been accepted for the stale timeout, the fencing token was reset
+
+	currentTime = currentTime.Add(25 * time.Second)
+
+	assert.True(t, guard.Accept(1, currentTime))
+	assert.Equal(t, uint64(1), guard.Token())
+
+	assert.True(t, guard.Accept(2, currentTime))
+	assert.False(t, guard.Accept(1, currentTime))
+}
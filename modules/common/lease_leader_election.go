@@ -0,0 +1,287 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/networknext/next/modules/core"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Lease based leader election, in the style of etcd and kubernetes leases. The leader is whoever holds the lease,
+// and holds it only as long as it keeps renewing it. Each time the lease changes hands the term increases, and the
+// term is the fencing token. The lease itself lives in a LeaseStore, so the election logic can be tested against a
+// local stand-in (MemoryLeaseStore) and run in production against etcd (EtcdLeaseStore).
+//
+// Instance data lives in a separate LeaseDataStore (redis in production), keyed by instance id. The relay backend stores
+// megabytes every second, which etcd is not built for: every put is a new revision, and puts larger than etcd's request
+// limit are rejected. The holder id in the lease is all etcd needs to point followers at the leader's data.
+
+type Lease struct {
+	HolderId   string
+	Term       uint64
+	ExpireTime time.Time
+}
+
+type LeaseStore interface {
+	// Acquire takes the lease if it is free or expired, or renews it if we already hold it, and returns the lease after the attempt
+	Acquire(ctx context.Context, name string, holderId string, duration time.Duration) (Lease, error)
+	// Holder returns the current lease, with an empty holder id if nobody holds it
+	Holder(ctx context.Context, name string) (Lease, error)
+}
+
+type LeaseDataStore interface {
+	// Put stores data under the key. zero expiration means no expiration
+	Put(ctx context.Context, key string, data []byte, expiration time.Duration) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+type LeaseLeaderElectionConfig struct {
+	ServiceName   string
+	InitialDelay  int
+	LeaseDuration time.Duration
+}
+
+type LeaseLeaderElection struct {
+	config     LeaseLeaderElectionConfig
+	leaseStore LeaseStore
+	dataStore  LeaseDataStore
+	startTime  time.Time
+	instanceId string
+
+	leaderMutex      sync.RWMutex
+	leaderInstanceId string
+	isLeader         bool
+	isReady          bool
+	fencingToken     uint64
+}
+
+func CreateLeaseLeaderElection(leaseStore LeaseStore, dataStore LeaseDataStore, config LeaseLeaderElectionConfig) (*LeaseLeaderElection, error) {
+
+	leaderElection := &LeaseLeaderElection{}
+
+	if config.InitialDelay == 0 {
+		config.InitialDelay = 15
+	}
+
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = 10 * time.Second
+	}
+
+	leaderElection.config = config
+	leaderElection.leaseStore = leaseStore
+	leaderElection.dataStore = dataStore
+	leaderElection.startTime = time.Now()
+	leaderElection.instanceId = uuid.New().String()
+
+	core.Debug("lease leader election start time: %s", leaderElection.startTime)
+	core.Debug("lease leader election instance id: %s", leaderElection.instanceId)
+	core.Debug("lease leader election lease duration: %s", config.LeaseDuration.String())
+
+	return leaderElection, nil
+}
+
+func (leaderElection *LeaseLeaderElection) Start(ctx context.Context) {
+
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				leaderElection.Update(ctx)
+			}
+		}
+	}()
+}
+
+func (leaderElection *LeaseLeaderElection) Update(ctx context.Context) {
+
+	// wait for leader election initial delay
+
+	if int(time.Since(leaderElection.startTime).Seconds()) < leaderElection.config.InitialDelay {
+		core.Debug("waiting for leader election initial delay (%d)", leaderElection.config.InitialDelay)
+		return
+	}
+
+	// try to acquire or renew the lease
+
+	lease, err := leaderElection.leaseStore.Acquire(ctx, leaderElection.config.ServiceName, leaderElection.instanceId, leaderElection.config.LeaseDuration)
+
+	leaderElection.leaderMutex.Lock()
+
+	previousValue := leaderElection.isLeader
+
+	if err != nil {
+		// IMPORTANT: if we can't renew the lease we can't know we are still leader, so step down
+		core.Error("failed to acquire lease: %v", err)
+		leaderElection.isLeader = false
+	} else {
+		leaderElection.leaderInstanceId = lease.HolderId
+		leaderElection.isLeader = lease.HolderId == leaderElection.instanceId
+		if leaderElection.isLeader {
+			leaderElection.fencingToken = lease.Term
+		}
+		leaderElection.isReady = true
+	}
+
+	currentValue := leaderElection.isLeader
+	fencingToken := leaderElection.fencingToken
+
+	leaderElection.leaderMutex.Unlock()
+
+	if !previousValue && currentValue {
+		core.Log("we became the leader (fencing token %d)", fencingToken)
+	} else if previousValue && !currentValue {
+		core.Log("we are no longer the leader")
+	}
+}
+
+func leaseInstanceDataKey(service string, instanceId string, name string) string {
+	return fmt.Sprintf("%s-instance-data-%s-%s", service, instanceId, name)
+}
+
+func (leaderElection *LeaseLeaderElection) Store(ctx context.Context, name string, data []byte, expiration time.Duration) {
+	err := leaderElection.dataStore.Put(ctx, leaseInstanceDataKey(leaderElection.config.ServiceName, leaderElection.instanceId, name), data, expiration)
+	if err != nil {
+		core.Error("failed to store %s (%d bytes). followers will not see it: %v", name, len(data), err)
+	}
+}
+
+func (leaderElection *LeaseLeaderElection) Load(ctx context.Context, name string) []byte {
+	leaderElection.leaderMutex.RLock()
+	leaderInstanceId := leaderElection.leaderInstanceId
+	leaderElection.leaderMutex.RUnlock()
+	if leaderInstanceId == "" {
+		return nil
+	}
+	data, err := leaderElection.dataStore.Get(ctx, leaseInstanceDataKey(leaderElection.config.ServiceName, leaderInstanceId, name))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (leaderElection *LeaseLeaderElection) IsLeader() bool {
+	leaderElection.leaderMutex.RLock()
+	value := leaderElection.isLeader
+	leaderElection.leaderMutex.RUnlock()
+	return value
+}
+
+func (leaderElection *LeaseLeaderElection) IsReady() bool {
+	leaderElection.leaderMutex.RLock()
+	value := leaderElection.isReady
+	leaderElection.leaderMutex.RUnlock()
+	return value
+}
+
+func (leaderElection *LeaseLeaderElection) FencingToken() uint64 {
+	leaderElection.leaderMutex.RLock()
+	value := leaderElection.fencingToken
+	leaderElection.leaderMutex.RUnlock()
+	return value
+}
+
+func LoadLeaseLeaderServiceData(ctx context.Context, leaseStore LeaseStore, dataStore LeaseDataStore, service string, name string) []byte {
+	lease, err := leaseStore.Holder(ctx, service)
+	if err != nil || lease.HolderId == "" {
+		return nil
+	}
+	data, err := dataStore.Get(ctx, leaseInstanceDataKey(service, lease.HolderId, name))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// ----------------------------------------------------------------------------------------------
+
+// MemoryLeaseStore is a local stand-in for etcd and redis. It only elects between instances in the same process,
+// so it is for tests and local development, not for running multiple instances of a service.
+
+type MemoryLeaseStore struct {
+	Now func() time.Time
+
+	mutex  sync.Mutex
+	leases map[string]Lease
+	terms  map[string]uint64
+	data   map[string][]byte
+}
+
+func CreateMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{
+		Now:    time.Now,
+		leases: make(map[string]Lease),
+		terms:  make(map[string]uint64),
+		data:   make(map[string][]byte),
+	}
+}
+
+func (store *MemoryLeaseStore) Acquire(ctx context.Context, name string, holderId string, duration time.Duration) (Lease, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := store.Now()
+	lease, exists := store.leases[name]
+	if exists && lease.HolderId != holderId && now.Before(lease.ExpireTime) {
+		return lease, nil
+	}
+	if !exists || lease.HolderId != holderId || !now.Before(lease.ExpireTime) {
+		store.terms[name]++
+		lease.HolderId = holderId
+		lease.Term = store.terms[name]
+	}
+	lease.ExpireTime = now.Add(duration)
+	store.leases[name] = lease
+	return lease, nil
+}
+
+func (store *MemoryLeaseStore) Holder(ctx context.Context, name string) (Lease, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	lease, exists := store.leases[name]
+	if !exists || !store.Now().Before(lease.ExpireTime) {
+		return Lease{}, nil
+	}
+	return lease, nil
+}
+
+func (store *MemoryLeaseStore) Put(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	store.mutex.Lock()
+	store.data[key] = data
+	store.mutex.Unlock()
+	return nil
+}
+
+func (store *MemoryLeaseStore) Get(ctx context.Context, key string) ([]byte, error) {
+	store.mutex.Lock()
+	data, exists := store.data[key]
+	store.mutex.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+	return data, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type RedisLeaseDataStore struct {
+	RedisClient redis.Cmdable
+}
+
+func CreateRedisLeaseDataStore(redisClient redis.Cmdable) *RedisLeaseDataStore {
+	return &RedisLeaseDataStore{RedisClient: redisClient}
+}
+
+func (store *RedisLeaseDataStore) Put(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	return store.RedisClient.Set(ctx, key, data, expiration).Err()
+}
+
+func (store *RedisLeaseDataStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return store.RedisClient.Get(ctx, key).Bytes()
+}
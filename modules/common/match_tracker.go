@@ -0,0 +1,245 @@
+package common
+
+// The match tracker keeps the latest latency of each session in a match, so route decisions for one session can take the rest
+// of the match into account. Sessions in the same match can land on any server backend, so match state lives in a MatchStore
+// keyed by buyer and match id, shared by every server backend (RedisMatchStore), rather than in server backend memory.
+// MemoryMatchStore is a local stand-in for tests.
+//
+// Reads are on the session update path. Writes are not: the session update handler sends a MatchUpdate down a channel, and
+// the goroutine draining it batches updates with UpdateSession and writes them to the match store with CheckForFlush.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/networknext/next/modules/core"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const MatchSessionTimeout = 30 // seconds. three slices without an update and the session is no longer counted in its match
+
+const MatchStoreTimeout = 100 * time.Millisecond // match state is read on the session update path, so don't wait long for it
+
+const MatchFlushTimeout = time.Second
+
+const MatchUpdateBatchSize = 10000
+
+type MatchKey struct {
+	BuyerId uint64
+	MatchId uint64
+}
+
+type MatchSession struct {
+	Latency        int32 // direct rtt, or predicted next rtt when the session is on network next
+	LastUpdateTime int64
+}
+
+type MatchUpdate struct {
+	Key       MatchKey
+	SessionId uint64
+	Session   MatchSession
+}
+
+type MatchStore interface {
+	// Put stores each session in its match, and keeps the match for at least MatchSessionTimeout after its last update
+	Put(ctx context.Context, updates []MatchUpdate) error
+	// Get returns every session stored in the match, including timed out sessions that haven't been removed yet
+	Get(ctx context.Context, key MatchKey) (map[uint64]MatchSession, error)
+	Remove(ctx context.Context, key MatchKey, sessionIds []uint64) error
+}
+
+// UpdateSession, CheckForFlush and Flush batch writes and must only be called from one goroutine. GetMatchLatencies is safe to
+// call from any goroutine.
+
+type MatchTracker struct {
+	ctx           context.Context
+	matchStore    MatchStore
+	pending       []MatchUpdate
+	lastFlushTime time.Time
+}
+
+func CreateMatchTracker(ctx context.Context, matchStore MatchStore) *MatchTracker {
+	return &MatchTracker{ctx: ctx, matchStore: matchStore, lastFlushTime: time.Now()}
+}
+
+func (tracker *MatchTracker) UpdateSession(update MatchUpdate) {
+	tracker.pending = append(tracker.pending, update)
+}
+
+func (tracker *MatchTracker) CheckForFlush(currentTime time.Time) {
+	if len(tracker.pending) >= MatchUpdateBatchSize || currentTime.Sub(tracker.lastFlushTime) >= time.Second {
+		tracker.Flush()
+	}
+}
+
+func (tracker *MatchTracker) Flush() {
+	if len(tracker.pending) > 0 {
+		ctx, cancel := context.WithTimeout(tracker.ctx, MatchFlushTimeout)
+		err := tracker.matchStore.Put(ctx, tracker.pending)
+		cancel()
+		if err != nil {
+			core.Error("failed to update %d match sessions: %v", len(tracker.pending), err)
+		}
+	}
+	tracker.pending = tracker.pending[:0]
+	tracker.lastFlushTime = time.Now()
+}
+
+// GetMatchLatencies returns the current latency of every other active session in the match. If the match can't be read, the
+// session is treated as alone in its match
+
+func (tracker *MatchTracker) GetMatchLatencies(currentTime int64, buyerId uint64, matchId uint64, sessionId uint64) []int32 {
+	ctx, cancel := context.WithTimeout(tracker.ctx, MatchStoreTimeout)
+	defer cancel()
+	key := MatchKey{BuyerId: buyerId, MatchId: matchId}
+	sessions, err := tracker.matchStore.Get(ctx, key)
+	if err != nil {
+		core.Error("failed to get match %016x: %v", matchId, err)
+		return nil
+	}
+	latencies := make([]int32, 0, len(sessions))
+	var timedOut []uint64
+	for id, session := range sessions {
+		if session.LastUpdateTime+MatchSessionTimeout < currentTime {
+			timedOut = append(timedOut, id)
+			continue
+		}
+		if id == sessionId {
+			continue
+		}
+		latencies = append(latencies, session.Latency)
+	}
+	if len(timedOut) > 0 {
+		if err := tracker.matchStore.Remove(ctx, key, timedOut); err != nil {
+			core.Error("failed to remove timed out sessions from match %016x: %v", matchId, err)
+		}
+	}
+	return latencies
+}
+
+// ----------------------------------------------------------------------------------------------
+
+// RedisMatchStore keeps each match in a redis hash of session id -> "latency|last update time". The hash expires once no session
+// in the match has been updated for MatchSessionTimeout
+
+const MatchRedisKeyPrefix = "match-"
+
+type RedisMatchStore struct {
+	redisClient redis.Cmdable
+}
+
+func CreateRedisMatchStore(redisClient redis.Cmdable) *RedisMatchStore {
+	return &RedisMatchStore{redisClient: redisClient}
+}
+
+func matchRedisKey(key MatchKey) string {
+	return fmt.Sprintf("%s%016x-%016x", MatchRedisKeyPrefix, key.BuyerId, key.MatchId)
+}
+
+func (store *RedisMatchStore) Put(ctx context.Context, updates []MatchUpdate) error {
+	_, err := store.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range updates {
+			redisKey := matchRedisKey(updates[i].Key)
+			pipe.HSet(ctx, redisKey, fmt.Sprintf("%016x", updates[i].SessionId), fmt.Sprintf("%d|%d", updates[i].Session.Latency, updates[i].Session.LastUpdateTime))
+			pipe.Expire(ctx, redisKey, MatchSessionTimeout*time.Second)
+		}
+		return nil
+	})
+	return err
+}
+
+func (store *RedisMatchStore) Get(ctx context.Context, key MatchKey) (map[uint64]MatchSession, error) {
+	values, err := store.redisClient.HGetAll(ctx, matchRedisKey(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[uint64]MatchSession, len(values))
+	for field, value := range values {
+		sessionId, err := strconv.ParseUint(field, 16, 64)
+		if err != nil {
+			continue
+		}
+		latencyString, lastUpdateTimeString, found := strings.Cut(value, "|")
+		if !found {
+			continue
+		}
+		latency, err := strconv.ParseInt(latencyString, 10, 32)
+		if err != nil {
+			continue
+		}
+		lastUpdateTime, err := strconv.ParseInt(lastUpdateTimeString, 10, 64)
+		if err != nil {
+			continue
+		}
+		sessions[sessionId] = MatchSession{Latency: int32(latency), LastUpdateTime: lastUpdateTime}
+	}
+	return sessions, nil
+}
+
+func (store *RedisMatchStore) Remove(ctx context.Context, key MatchKey, sessionIds []uint64) error {
+	fields := make([]string, len(sessionIds))
+	for i := range sessionIds {
+		fields[i] = fmt.Sprintf("%016x", sessionIds[i])
+	}
+	return store.redisClient.HDel(ctx, matchRedisKey(key), fields...).Err()
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type MemoryMatchStore struct {
+	mutex   sync.Mutex
+	matches map[MatchKey]map[uint64]MatchSession
+}
+
+func CreateMemoryMatchStore() *MemoryMatchStore {
+	return &MemoryMatchStore{matches: make(map[MatchKey]map[uint64]MatchSession)}
+}
+
+func (store *MemoryMatchStore) Put(ctx context.Context, updates []MatchUpdate) error {
+	store.mutex.Lock()
+	for i := range updates {
+		sessions, exists := store.matches[updates[i].Key]
+		if !exists {
+			sessions = make(map[uint64]MatchSession)
+			store.matches[updates[i].Key] = sessions
+		}
+		sessions[updates[i].SessionId] = updates[i].Session
+	}
+	store.mutex.Unlock()
+	return nil
+}
+
+func (store *MemoryMatchStore) Get(ctx context.Context, key MatchKey) (map[uint64]MatchSession, error) {
+	store.mutex.Lock()
+	sessions := make(map[uint64]MatchSession, len(store.matches[key]))
+	for id, session := range store.matches[key] {
+		sessions[id] = session
+	}
+	store.mutex.Unlock()
+	return sessions, nil
+}
+
+func (store *MemoryMatchStore) Remove(ctx context.Context, key MatchKey, sessionIds []uint64) error {
+	store.mutex.Lock()
+	sessions := store.matches[key]
+	for _, id := range sessionIds {
+		delete(sessions, id)
+	}
+	if len(sessions) == 0 {
+		delete(store.matches, key)
+	}
+	store.mutex.Unlock()
+	return nil
+}
+
+func (store *MemoryMatchStore) NumMatches() int {
+	store.mutex.Lock()
+	numMatches := len(store.matches)
+	store.mutex.Unlock()
+	return numMatches
+}
@@ -0,0 +1,141 @@
+package common_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func matchUpdate(currentTime int64, buyerId uint64, matchId uint64, sessionId uint64, latency int32) common.MatchUpdate {
+	return common.MatchUpdate{Key: common.MatchKey{BuyerId: buyerId, MatchId: matchId}, SessionId: sessionId, Session: common.MatchSession{Latency: latency, LastUpdateTime: currentTime}}
+}
+
+func TestMatchTracker_Latencies(t *testing.T) {
+
+	t.Parallel()
+
+	matchStore := common.CreateMemoryMatchStore()
+	tracker := common.CreateMatchTracker(context.Background(), matchStore)
+
+	const buyerId = 1
+	const matchId = 100
+
+	tracker.UpdateSession(matchUpdate(1000, buyerId, matchId, 1, 40))
+	tracker.UpdateSession(matchUpdate(1000, buyerId, matchId, 2, 60))
+	tracker.UpdateSession(matchUpdate(1000, buyerId, matchId, 3, 100))
+
+	// same match id for another buyer is a different match
+
+	tracker.UpdateSession(matchUpdate(1000, 2, matchId, 4, 10))
+
+	tracker.Flush()
+
+	assert.Equal(t, 2, matchStore.NumMatches())
+
+	// latencies exclude the session asking
+
+	latencies := tracker.GetMatchLatencies(1000, buyerId, matchId, 1)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	assert.Equal(t, []int32{60, 100}, latencies)
+
+	// updating a session replaces its latency
+
+	tracker.UpdateSession(matchUpdate(1010, buyerId, matchId, 3, 50))
+	tracker.Flush()
+
+	latencies = tracker.GetMatchLatencies(1010, buyerId, matchId, 1)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	assert.Equal(t, []int32{50, 60}, latencies)
+
+	// unknown matches have no latencies
+
+	assert.Equal(t, 0, len(tracker.GetMatchLatencies(1010, buyerId, 101, 1)))
+}
+
+func TestMatchTracker_Timeout(t *testing.T) {
+
+	t.Parallel()
+
+	matchStore := common.CreateMemoryMatchStore()
+	tracker := common.CreateMatchTracker(context.Background(), matchStore)
+
+	tracker.UpdateSession(matchUpdate(1000, 1, 100, 1, 80))
+	tracker.UpdateSession(matchUpdate(1020, 1, 100, 2, 60))
+	tracker.UpdateSession(matchUpdate(1000, 1, 200, 3, 50))
+	tracker.Flush()
+
+	// timed out sessions are not counted, and are removed from the match store
+
+	currentTime := int64(1000 + common.MatchSessionTimeout + 1)
+
+	assert.Equal(t, []int32{60}, tracker.GetMatchLatencies(currentTime, 1, 100, 3))
+	assert.Equal(t, 0, len(tracker.GetMatchLatencies(currentTime, 1, 200, 0)))
+	assert.Equal(t, 1, matchStore.NumMatches())
+
+	sessions, err := matchStore.Get(context.Background(), common.MatchKey{BuyerId: 1, MatchId: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sessions))
+}
+
+// sessions in one match can be on different server backends, so each server backend has its own tracker over the shared store
+
+func TestMatchTracker_SharedStore(t *testing.T) {
+
+	t.Parallel()
+
+	matchStore := common.CreateMemoryMatchStore()
+	trackerA := common.CreateMatchTracker(context.Background(), matchStore)
+	trackerB := common.CreateMatchTracker(context.Background(), matchStore)
+
+	trackerA.UpdateSession(matchUpdate(1000, 1, 100, 1, 40))
+	trackerB.UpdateSession(matchUpdate(1000, 1, 100, 2, 90))
+
+	trackerA.Flush()
+	trackerB.Flush()
+
+	assert.Equal(t, []int32{90}, trackerA.GetMatchLatencies(1000, 1, 100, 1))
+	assert.Equal(t, []int32{40}, trackerB.GetMatchLatencies(1000, 1, 100, 2))
+}
+
+// updates are batched off the session update path, and only written to the match store when the tracker flushes
+
+func TestMatchTracker_Batching(t *testing.T) {
+
+	t.Parallel()
+
+	matchStore := common.CreateMemoryMatchStore()
+	tracker := common.CreateMatchTracker(context.Background(), matchStore)
+
+	tracker.Flush()
+
+	currentTime := time.Now()
+
+	tracker.UpdateSession(matchUpdate(1000, 1, 100, 1, 40))
+	tracker.UpdateSession(matchUpdate(1000, 1, 100, 2, 60))
+
+	tracker.CheckForFlush(currentTime)
+
+	assert.Equal(t, 0, matchStore.NumMatches())
+
+	// a second after the last flush, pending updates are written
+
+	tracker.CheckForFlush(currentTime.Add(time.Second))
+
+	assert.Equal(t, 1, matchStore.NumMatches())
+	assert.Equal(t, []int32{60}, tracker.GetMatchLatencies(1000, 1, 100, 1))
+
+	// a full batch is written without waiting
+
+	for i := 0; i < common.MatchUpdateBatchSize; i++ {
+		tracker.UpdateSession(matchUpdate(1000, 1, uint64(1000+i), 1, 40))
+	}
+
+	tracker.CheckForFlush(time.Now())
+
+	assert.Equal(t, 1+common.MatchUpdateBatchSize, matchStore.NumMatches())
+}
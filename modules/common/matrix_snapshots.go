@@ -0,0 +1,206 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The leader relay backend periodically persists the cost matrix and route matrix it used, so post-incident
+// analysis of a routing event can look at the exact matrix the backend had at the time. Snapshots are stored
+// as "<name>-<timestamp>.bin" in a local directory, or in a google cloud storage bucket when the url is gs://
+// Local directories are for dev only: with more than one relay backend, the snapshots stay on whichever VM was leader.
+
+const (
+	MatrixSnapshot_CostMatrix  = "cost_matrix"
+	MatrixSnapshot_RouteMatrix = "route_matrix"
+)
+
+func IsValidMatrixSnapshotName(name string) bool {
+	return name == MatrixSnapshot_CostMatrix || name == MatrixSnapshot_RouteMatrix
+}
+
+type MatrixSnapshotStore interface {
+	Put(name string, timestamp int64, data []byte) error
+	List(name string) ([]int64, error)
+	Get(name string, timestamp int64) ([]byte, error)
+	Delete(name string, timestamp int64) error
+}
+
+func CreateMatrixSnapshotStore(url string) (MatrixSnapshotStore, error) {
+	if strings.HasPrefix(url, "gs://") {
+		return &GoogleCloudMatrixSnapshotStore{BucketURL: strings.TrimSuffix(url, "/")}, nil
+	}
+	err := os.MkdirAll(url, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalMatrixSnapshotStore{Directory: url}, nil
+}
+
+func matrixSnapshotFilename(name string, timestamp int64) string {
+	return fmt.Sprintf("%s-%d.bin", name, timestamp)
+}
+
+func parseMatrixSnapshotTimestamps(name string, filenames []string) []int64 {
+	prefix := name + "-"
+	timestamps := make([]int64, 0, len(filenames))
+	for i := range filenames {
+		filename := filepath.Base(filenames[i])
+		if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ".bin") {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(filename, prefix), ".bin"), 10, 64)
+		if err != nil {
+			continue
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps
+}
+
+// GetMatrixSnapshotAt returns the most recent snapshot taken at or before the given time
+
+func GetMatrixSnapshotAt(store MatrixSnapshotStore, name string, at int64) (int64, []byte, error) {
+	timestamps, err := store.List(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	index := sort.Search(len(timestamps), func(i int) bool { return timestamps[i] > at }) - 1
+	if index < 0 {
+		return 0, nil, fmt.Errorf("no %s snapshot at or before %d", name, at)
+	}
+	data, err := store.Get(name, timestamps[index])
+	if err != nil {
+		return 0, nil, err
+	}
+	return timestamps[index], data, nil
+}
+
+// PruneMatrixSnapshots deletes snapshots taken before the given time and returns how many were deleted
+
+func PruneMatrixSnapshots(store MatrixSnapshotStore, name string, before int64) (int, error) {
+	timestamps, err := store.List(name)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range timestamps {
+		if timestamps[i] >= before {
+			break
+		}
+		err := store.Delete(name, timestamps[i])
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type LocalMatrixSnapshotStore struct {
+	Directory string
+}
+
+func (store *LocalMatrixSnapshotStore) Put(name string, timestamp int64, data []byte) error {
+	if !IsValidMatrixSnapshotName(name) {
+		return fmt.Errorf("invalid matrix snapshot name: %s", name)
+	}
+	filename := filepath.Join(store.Directory, matrixSnapshotFilename(name, timestamp))
+	tempFilename := filename + ".tmp"
+	err := os.WriteFile(tempFilename, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFilename, filename)
+}
+
+func (store *LocalMatrixSnapshotStore) List(name string) ([]int64, error) {
+	filenames, err := filepath.Glob(filepath.Join(store.Directory, name+"-*.bin"))
+	if err != nil {
+		return nil, err
+	}
+	return parseMatrixSnapshotTimestamps(name, filenames), nil
+}
+
+func (store *LocalMatrixSnapshotStore) Get(name string, timestamp int64) ([]byte, error) {
+	if !IsValidMatrixSnapshotName(name) {
+		return nil, fmt.Errorf("invalid matrix snapshot name: %s", name)
+	}
+	return os.ReadFile(filepath.Join(store.Directory, matrixSnapshotFilename(name, timestamp)))
+}
+
+func (store *LocalMatrixSnapshotStore) Delete(name string, timestamp int64) error {
+	if !IsValidMatrixSnapshotName(name) {
+		return fmt.Errorf("invalid matrix snapshot name: %s", name)
+	}
+	return os.Remove(filepath.Join(store.Directory, matrixSnapshotFilename(name, timestamp)))
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type GoogleCloudMatrixSnapshotStore struct {
+	BucketURL string
+}
+
+func (store *GoogleCloudMatrixSnapshotStore) Put(name string, timestamp int64, data []byte) error {
+	if !IsValidMatrixSnapshotName(name) {
+		return fmt.Errorf("invalid matrix snapshot name: %s", name)
+	}
+	file, err := os.CreateTemp("", "matrix_snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if ok, _ := Bash(fmt.Sprintf("gsutil -q cp %s %s/%s", file.Name(), store.BucketURL, matrixSnapshotFilename(name, timestamp))); !ok {
+		return errors.New("failed to upload matrix snapshot")
+	}
+	return nil
+}
+
+func (store *GoogleCloudMatrixSnapshotStore) List(name string) ([]int64, error) {
+	ok, output := Bash(fmt.Sprintf("gsutil ls %s/%s-*.bin", store.BucketURL, name))
+	if !ok {
+		// gsutil fails when nothing matches
+		return []int64{}, nil
+	}
+	return parseMatrixSnapshotTimestamps(name, strings.Fields(output)), nil
+}
+
+func (store *GoogleCloudMatrixSnapshotStore) Get(name string, timestamp int64) ([]byte, error) {
+	if !IsValidMatrixSnapshotName(name) {
+		return nil, fmt.Errorf("invalid matrix snapshot name: %s", name)
+	}
+	file, err := os.CreateTemp("", "matrix_snapshot")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if ok, _ := Bash(fmt.Sprintf("gsutil -q cp %s/%s %s", store.BucketURL, matrixSnapshotFilename(name, timestamp), file.Name())); !ok {
+		return nil, errors.New("failed to download matrix snapshot")
+	}
+	return os.ReadFile(file.Name())
+}
+
+func (store *GoogleCloudMatrixSnapshotStore) Delete(name string, timestamp int64) error {
+	if !IsValidMatrixSnapshotName(name) {
+		return fmt.Errorf("invalid matrix snapshot name: %s", name)
+	}
+	if ok, _ := Bash(fmt.Sprintf("gsutil -q rm %s/%s", store.BucketURL, matrixSnapshotFilename(name, timestamp))); !ok {
+		return errors.New("failed to delete matrix snapshot")
+	}
+	return nil
+}
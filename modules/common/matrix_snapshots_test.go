@@ -0,0 +1,58 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrixSnapshots_Local(t *testing.T) {
+
+	t.Parallel()
+
+	store, err := common.CreateMatrixSnapshotStore(t.TempDir())
+	assert.Nil(t, err)
+
+	for i := range 5 {
+		timestamp := int64(1000 + i*60)
+		assert.Nil(t, store.Put(common.MatrixSnapshot_CostMatrix, timestamp, []byte{byte(i)}))
+		assert.Nil(t, store.Put(common.MatrixSnapshot_RouteMatrix, timestamp, []byte{byte(i + 100)}))
+	}
+
+	assert.NotNil(t, store.Put("../passwd", 1000, []byte{1}))
+
+	timestamps, err := store.List(common.MatrixSnapshot_CostMatrix)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1000, 1060, 1120, 1180, 1240}, timestamps)
+
+	// fetching by time returns the most recent snapshot at or before that time
+
+	timestamp, data, err := common.GetMatrixSnapshotAt(store, common.MatrixSnapshot_CostMatrix, 1100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1060), timestamp)
+	assert.Equal(t, []byte{1}, data)
+
+	timestamp, data, err = common.GetMatrixSnapshotAt(store, common.MatrixSnapshot_RouteMatrix, 1240)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1240), timestamp)
+	assert.Equal(t, []byte{104}, data)
+
+	_, _, err = common.GetMatrixSnapshotAt(store, common.MatrixSnapshot_CostMatrix, 999)
+	assert.NotNil(t, err)
+
+	// prune removes snapshots older than the retention
+
+	deleted, err := common.PruneMatrixSnapshots(store, common.MatrixSnapshot_CostMatrix, 1120)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+
+	timestamps, err = store.List(common.MatrixSnapshot_CostMatrix)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1120, 1180, 1240}, timestamps)
+
+	timestamps, err = store.List(common.MatrixSnapshot_RouteMatrix)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(timestamps))
+}
@@ -0,0 +1,83 @@
+package common
+
+// Ping sets limit which relays each relay pings, so ping traffic doesn't grow as N^2 across the fleet.
+// Each relay pings every relay within a distance radius, plus a sampled set of long-haul peers that
+// rotates every rotation period. Sampling is done on the relay pair, so both relays in a pair agree on
+// whether they ping each other. Pairs outside the ping set have no samples and are unknown in the
+// relay manager, so they get no direct link in the cost matrix and are routed via other relays.
+// Pairs rotated into the ping set start with an empty history and become routable once they have
+// RelayHistoryMinSamples samples. The relay backend must also enable ping sets, so that a pair with
+// samples in only one direction is routable.
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+
+	"github.com/networknext/next/modules/core"
+)
+
+type PingSetConfig struct {
+	RadiusKilometers float64
+	LongHaulPeers    int
+	RotationPeriod   int64 // seconds
+}
+
+func pingSetPairHash(a uint64, b uint64, epoch uint64) uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	var data [24]byte
+	binary.LittleEndian.PutUint64(data[0:], a)
+	binary.LittleEndian.PutUint64(data[8:], b)
+	binary.LittleEndian.PutUint64(data[16:], epoch)
+	hash := fnv.New64a()
+	hash.Write(data[:])
+	return hash.Sum64()
+}
+
+// GetRelayPingSet returns the indices into relayData of the relays that the relay at relayIndex should ping.
+
+func GetRelayPingSet(relayData *RelayData, relayIndex int, config *PingSetConfig, currentTime int64) []int {
+
+	numRelays := len(relayData.RelayIds)
+
+	sourceRelayId := relayData.RelayIds[relayIndex]
+	sourceLatitude := float64(relayData.RelayLatitudes[relayIndex])
+	sourceLongitude := float64(relayData.RelayLongitudes[relayIndex])
+
+	epoch := uint64(0)
+	if config.RotationPeriod > 0 {
+		epoch = uint64(currentTime / config.RotationPeriod)
+	}
+
+	// each long-haul pair is selected with the same probability, so on average each relay gets
+	// around LongHaulPeers long-haul peers, minus those that are already within the radius
+
+	threshold := uint64(0)
+	if numRelays > 1 && config.LongHaulPeers > 0 {
+		probability := float64(config.LongHaulPeers) / float64(numRelays-1)
+		if probability >= 1.0 {
+			threshold = math.MaxUint64
+		} else {
+			threshold = uint64(probability * float64(math.MaxUint64))
+		}
+	}
+
+	pingSet := make([]int, 0, numRelays)
+
+	for i := range numRelays {
+
+		if i == relayIndex {
+			continue
+		}
+
+		distance := core.HaversineDistance(sourceLatitude, sourceLongitude, float64(relayData.RelayLatitudes[i]), float64(relayData.RelayLongitudes[i]))
+
+		if distance <= config.RadiusKilometers || pingSetPairHash(sourceRelayId, relayData.RelayIds[i], epoch) < threshold {
+			pingSet = append(pingSet, i)
+		}
+	}
+
+	return pingSet
+}
@@ -0,0 +1,124 @@
+package common_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createPingSetRelayData(numRelays int) *common.RelayData {
+	relayData := &common.RelayData{}
+	relayData.NumRelays = numRelays
+	relayData.RelayIds = make([]uint64, numRelays)
+	relayData.RelayLatitudes = make([]float32, numRelays)
+	relayData.RelayLongitudes = make([]float32, numRelays)
+	relayData.RelayIdToIndex = make(map[uint64]int)
+	for i := range numRelays {
+		relayData.RelayIds[i] = common.RelayId(fmt.Sprintf("relay.%d", i))
+		relayData.RelayLatitudes[i] = float32(-60 + (i*7)%120)
+		relayData.RelayLongitudes[i] = float32(-180 + (i*37)%360)
+		relayData.RelayIdToIndex[relayData.RelayIds[i]] = i
+	}
+	return relayData
+}
+
+func pingSetContains(pingSet []int, index int) bool {
+	for i := range pingSet {
+		if pingSet[i] == index {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPingSet_Symmetric(t *testing.T) {
+
+	t.Parallel()
+
+	const NumRelays = 200
+
+	relayData := createPingSetRelayData(NumRelays)
+
+	config := common.PingSetConfig{RadiusKilometers: 2500, LongHaulPeers: 16, RotationPeriod: 300}
+
+	pingSets := make([][]int, NumRelays)
+	for i := range NumRelays {
+		pingSets[i] = common.GetRelayPingSet(relayData, i, &config, 1000)
+		assert.False(t, pingSetContains(pingSets[i], i))
+		assert.Less(t, len(pingSets[i]), NumRelays-1)
+	}
+
+	for i := range NumRelays {
+		for _, j := range pingSets[i] {
+			assert.True(t, pingSetContains(pingSets[j], i))
+		}
+	}
+}
+
+func TestPingSet_Radius(t *testing.T) {
+
+	t.Parallel()
+
+	relayData := createPingSetRelayData(3)
+
+	// relay 0 and 1 are in the same city, relay 2 is on the other side of the world
+
+	relayData.RelayLatitudes[0] = 40.7128
+	relayData.RelayLongitudes[0] = -74.0060
+	relayData.RelayLatitudes[1] = 40.7306
+	relayData.RelayLongitudes[1] = -73.9352
+	relayData.RelayLatitudes[2] = -33.8688
+	relayData.RelayLongitudes[2] = 151.2093
+
+	config := common.PingSetConfig{RadiusKilometers: 500, LongHaulPeers: 0, RotationPeriod: 300}
+
+	for currentTime := int64(0); currentTime < 3000; currentTime += 300 {
+		assert.Equal(t, []int{1}, common.GetRelayPingSet(relayData, 0, &config, currentTime))
+		assert.Equal(t, []int{0}, common.GetRelayPingSet(relayData, 1, &config, currentTime))
+		assert.Equal(t, []int{}, common.GetRelayPingSet(relayData, 2, &config, currentTime))
+	}
+
+	// with more long-haul peers than relays, every relay pings every other relay
+
+	config.LongHaulPeers = 16
+
+	assert.Equal(t, []int{1, 2}, common.GetRelayPingSet(relayData, 0, &config, 0))
+}
+
+func TestPingSet_Rotation(t *testing.T) {
+
+	t.Parallel()
+
+	const NumRelays = 500
+
+	relayData := createPingSetRelayData(NumRelays)
+
+	config := common.PingSetConfig{RadiusKilometers: 0, LongHaulPeers: 16, RotationPeriod: 300}
+
+	// within a rotation period the ping set is stable
+
+	a := common.GetRelayPingSet(relayData, 0, &config, 300)
+	b := common.GetRelayPingSet(relayData, 0, &config, 599)
+
+	assert.Equal(t, a, b)
+
+	// long-haul peers rotate across rotation periods
+
+	c := common.GetRelayPingSet(relayData, 0, &config, 600)
+
+	assert.NotEqual(t, a, c)
+
+	// on average, each relay gets around the requested number of long-haul peers
+
+	total := 0
+	for i := range NumRelays {
+		total += len(common.GetRelayPingSet(relayData, i, &config, 0))
+	}
+
+	average := float64(total) / NumRelays
+
+	assert.InDelta(t, 16.0, average, 4.0)
+}
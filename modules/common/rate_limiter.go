@@ -0,0 +1,150 @@
+package common
+
+// The rate limiter keeps a token bucket per buyer and per source address, so one buyer's misbehaving game servers can't consume
+// the whole server backend. It is in-memory state shared by all packet handlers in one server backend. Limits are per server backend.
+//
+// Source addresses are spoofable, so only packets with a valid signature are charged to the address bucket. Packets that fail the
+// signature check are charged to a separate, much smaller failure bucket for the address. Once that is empty, packets from the
+// address are dropped before the signature check until it refills, so a flood of junk from one address costs us almost nothing.
+//
+// The failure bucket is just as spoofable, so it never applies to an address that has sent a packet with a valid signature in
+// the last RateLimitTimeout. Junk spoofed from a game server's address costs us signature checks, but never drops its packets.
+
+import (
+	"net/netip"
+	"sync"
+)
+
+const (
+	DefaultBuyerPacketsPerSecond   = 10000
+	DefaultAddressPacketsPerSecond = 1000
+
+	AddressSignatureFailuresPerSecond = 10
+
+	RateLimitBurstSeconds = 2  // a full bucket holds this many seconds of packets
+	RateLimitTimeout      = 60 // seconds. buckets idle this long are full again, so they can be dropped
+)
+
+type TokenBucket struct {
+	Tokens         float64
+	LastUpdateTime int64 // nanoseconds
+}
+
+// Refill adds tokens at packetsPerSecond since the last update, up to the burst
+
+func (bucket *TokenBucket) Refill(currentTime int64, packetsPerSecond int32) {
+	burst := float64(packetsPerSecond) * RateLimitBurstSeconds
+	if currentTime > bucket.LastUpdateTime {
+		bucket.Tokens += float64(currentTime-bucket.LastUpdateTime) * float64(packetsPerSecond) / 1e9
+	}
+	if bucket.Tokens > burst {
+		bucket.Tokens = burst
+	}
+	bucket.LastUpdateTime = currentTime
+}
+
+// Take refills the bucket at packetsPerSecond, then takes one token. Returns false if the bucket is empty.
+
+func (bucket *TokenBucket) Take(currentTime int64, packetsPerSecond int32) bool {
+	bucket.Refill(currentTime, packetsPerSecond)
+	if bucket.Tokens < 1 {
+		return false
+	}
+	bucket.Tokens -= 1
+	return true
+}
+
+type RateLimiter struct {
+	mutex     sync.Mutex
+	buyers    map[uint64]*TokenBucket
+	addresses map[netip.AddrPort]*TokenBucket
+	failures  map[netip.AddrPort]*TokenBucket
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buyers:    make(map[uint64]*TokenBucket),
+		addresses: make(map[netip.AddrPort]*TokenBucket),
+		failures:  make(map[netip.AddrPort]*TokenBucket),
+	}
+}
+
+func (limiter *RateLimiter) AllowBuyer(currentTime int64, buyerId uint64, packetsPerSecond int32) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	bucket, exists := limiter.buyers[buyerId]
+	if !exists {
+		bucket = &TokenBucket{Tokens: float64(packetsPerSecond) * RateLimitBurstSeconds, LastUpdateTime: currentTime}
+		limiter.buyers[buyerId] = bucket
+	}
+	return bucket.Take(currentTime, packetsPerSecond)
+}
+
+func (limiter *RateLimiter) AllowAddress(currentTime int64, address netip.AddrPort, packetsPerSecond int32) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	bucket, exists := limiter.addresses[address]
+	if !exists {
+		bucket = &TokenBucket{Tokens: float64(packetsPerSecond) * RateLimitBurstSeconds, LastUpdateTime: currentTime}
+		limiter.addresses[address] = bucket
+	}
+	return bucket.Take(currentTime, packetsPerSecond)
+}
+
+// AddressFailing is true if the address has sent too many packets that failed the signature check, and hasn't sent any with a
+// valid signature recently. Only packets with a valid signature have an address bucket. It doesn't take a token.
+
+func (limiter *RateLimiter) AddressFailing(currentTime int64, address netip.AddrPort) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if _, verified := limiter.addresses[address]; verified {
+		return false
+	}
+	bucket, exists := limiter.failures[address]
+	if !exists {
+		return false
+	}
+	bucket.Refill(currentTime, AddressSignatureFailuresPerSecond)
+	return bucket.Tokens < 1
+}
+
+// FailAddress charges a packet that failed the signature check to the address failure bucket
+
+func (limiter *RateLimiter) FailAddress(currentTime int64, address netip.AddrPort) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	bucket, exists := limiter.failures[address]
+	if !exists {
+		bucket = &TokenBucket{Tokens: AddressSignatureFailuresPerSecond * RateLimitBurstSeconds, LastUpdateTime: currentTime}
+		limiter.failures[address] = bucket
+	}
+	bucket.Take(currentTime, AddressSignatureFailuresPerSecond)
+}
+
+func (limiter *RateLimiter) Expire(currentTime int64) {
+	timeout := int64(RateLimitTimeout) * 1000000000
+	limiter.mutex.Lock()
+	for buyerId, bucket := range limiter.buyers {
+		if bucket.LastUpdateTime+timeout < currentTime {
+			delete(limiter.buyers, buyerId)
+		}
+	}
+	for address, bucket := range limiter.addresses {
+		if bucket.LastUpdateTime+timeout < currentTime {
+			delete(limiter.addresses, address)
+		}
+	}
+	for address, bucket := range limiter.failures {
+		if bucket.LastUpdateTime+timeout < currentTime {
+			delete(limiter.failures, address)
+		}
+	}
+	limiter.mutex.Unlock()
+}
+
+func (limiter *RateLimiter) NumAddresses() int {
+	limiter.mutex.Lock()
+	numAddresses := len(limiter.addresses)
+	limiter.mutex.Unlock()
+	return numAddresses
+}
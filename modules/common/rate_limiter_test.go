@@ -0,0 +1,147 @@
+package common_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const second = int64(1000000000)
+
+func TestRateLimiter_Buyer(t *testing.T) {
+
+	t.Parallel()
+
+	limiter := common.NewRateLimiter()
+
+	const buyerId = 1
+
+	// a new bucket starts full, with two seconds of packets
+
+	for i := 0; i < 20; i++ {
+		assert.True(t, limiter.AllowBuyer(0, buyerId, 10))
+	}
+
+	assert.False(t, limiter.AllowBuyer(0, buyerId, 10))
+
+	// other buyers have their own bucket
+
+	assert.True(t, limiter.AllowBuyer(0, 2, 10))
+
+	// the bucket refills at the packets per second rate
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, limiter.AllowBuyer(second/2, buyerId, 10))
+	}
+
+	assert.False(t, limiter.AllowBuyer(second/2, buyerId, 10))
+
+	// but never past the burst
+
+	for i := 0; i < 20; i++ {
+		assert.True(t, limiter.AllowBuyer(100*second, buyerId, 10))
+	}
+
+	assert.False(t, limiter.AllowBuyer(100*second, buyerId, 10))
+}
+
+func TestRateLimiter_Address(t *testing.T) {
+
+	t.Parallel()
+
+	limiter := common.NewRateLimiter()
+
+	a := netip.MustParseAddrPort("127.0.0.1:40000")
+	b := netip.MustParseAddrPort("127.0.0.1:40001")
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, limiter.AllowAddress(0, a, 1))
+	}
+
+	assert.False(t, limiter.AllowAddress(0, a, 1))
+	assert.True(t, limiter.AllowAddress(0, b, 1))
+
+	assert.True(t, limiter.AllowAddress(second, a, 1))
+	assert.False(t, limiter.AllowAddress(second, a, 1))
+}
+
+func TestRateLimiter_AddressFailures(t *testing.T) {
+
+	t.Parallel()
+
+	limiter := common.NewRateLimiter()
+
+	a := netip.MustParseAddrPort("127.0.0.1:40000")
+
+	// failures have their own bucket, and checking it doesn't take a token
+
+	assert.False(t, limiter.AddressFailing(0, a))
+
+	for i := 0; i < common.AddressSignatureFailuresPerSecond*common.RateLimitBurstSeconds; i++ {
+		assert.False(t, limiter.AddressFailing(0, a))
+		limiter.FailAddress(0, a)
+	}
+
+	assert.True(t, limiter.AddressFailing(0, a))
+	assert.True(t, limiter.AddressFailing(0, a))
+
+	// failures don't use up the address bucket for packets with a valid signature
+
+	assert.True(t, limiter.AllowAddress(0, a, 1))
+
+	// the failure bucket refills
+
+	assert.False(t, limiter.AddressFailing(second, a))
+}
+
+func TestRateLimiter_AddressFailures_Verified(t *testing.T) {
+
+	t.Parallel()
+
+	limiter := common.NewRateLimiter()
+
+	a := netip.MustParseAddrPort("127.0.0.1:40000")
+
+	// once the address has sent a packet with a valid signature, spoofed failures can't stop its packets being checked
+
+	assert.True(t, limiter.AllowAddress(0, a, 1))
+
+	for i := 0; i < 100*common.AddressSignatureFailuresPerSecond; i++ {
+		limiter.FailAddress(0, a)
+	}
+
+	assert.False(t, limiter.AddressFailing(0, a))
+
+	// until the address bucket expires, and the address is unverified again
+
+	limiter.Expire(100 * second)
+
+	for i := 0; i < common.AddressSignatureFailuresPerSecond*common.RateLimitBurstSeconds; i++ {
+		limiter.FailAddress(100*second, a)
+	}
+
+	assert.True(t, limiter.AddressFailing(100*second, a))
+}
+
+func TestRateLimiter_Expire(t *testing.T) {
+
+	t.Parallel()
+
+	limiter := common.NewRateLimiter()
+
+	limiter.AllowAddress(0, netip.MustParseAddrPort("127.0.0.1:40000"), 1)
+	limiter.AllowAddress(50*second, netip.MustParseAddrPort("127.0.0.1:40001"), 1)
+
+	assert.Equal(t, 2, limiter.NumAddresses())
+
+	limiter.Expire(100 * second)
+
+	assert.Equal(t, 1, limiter.NumAddresses())
+
+	limiter.Expire(200 * second)
+
+	assert.Equal(t, 0, limiter.NumAddresses())
+}
@@ -31,9 +31,10 @@ type RedisLeaderElection struct {
 	instanceId       string
 	leaderInstanceId string
 
-	leaderMutex sync.RWMutex
-	isLeader    bool
-	isReady     bool
+	leaderMutex  sync.RWMutex
+	isLeader     bool
+	isReady      bool
+	fencingToken uint64
 }
 
 type InstanceEntry struct {
@@ -180,25 +181,43 @@ func (leaderElection *RedisLeaderElection) Update(ctx context.Context) {
 
 	leaderInstance := instanceEntries[0]
 
-	leaderElection.leaderMutex.Lock()
-	leaderElection.leaderInstanceId = leaderInstance.InstanceId
+	leaderElection.leaderMutex.RLock()
 	previousValue := leaderElection.isLeader
+	fencingToken := leaderElection.fencingToken
+	leaderElection.leaderMutex.RUnlock()
+
 	currentValue := leaderInstance.InstanceId == leaderElection.instanceId
-	leaderElection.isLeader = currentValue
 
+	// each time we become leader, we start a new term with a new fencing token. if we can't get one, we can't be leader
+
+	if currentValue && !previousValue {
+		termKey := fmt.Sprintf("%s-leader-term-%d", leaderElection.config.ServiceName, RedisLeaderElectionVersion)
+		term, err := leaderElection.redisClient.Incr(ctx, termKey).Result()
+		if err != nil {
+			core.Error("failed to get fencing token: %v", err)
+			currentValue = false
+		} else {
+			fencingToken = uint64(term)
+		}
+	}
+
+	leaderElection.leaderMutex.Lock()
+	leaderElection.leaderInstanceId = leaderInstance.InstanceId
+	leaderElection.isLeader = currentValue
+	leaderElection.fencingToken = fencingToken
 	leaderElection.isReady = true
 	leaderElection.leaderMutex.Unlock()
 
 	if !previousValue && currentValue {
-		core.Log("we became the leader")
+		core.Log("we became the leader (fencing token %d)", fencingToken)
 	} else if previousValue && !currentValue {
 		core.Log("we are no longer the leader")
 	}
 }
 
-func (leaderElection *RedisLeaderElection) Store(ctx context.Context, name string, data []byte) {
+func (leaderElection *RedisLeaderElection) Store(ctx context.Context, name string, data []byte, expiration time.Duration) {
 	key := fmt.Sprintf("%s-instance-data-%d-%s-%s", leaderElection.config.ServiceName, RedisLeaderElectionVersion, leaderElection.instanceId, name)
-	err := leaderElection.redisClient.Set(ctx, key, data, 0).Err()
+	err := leaderElection.redisClient.Set(ctx, key, data, expiration).Err()
 	if err != nil {
 		core.Error("failed to store data: %v", err)
 	}
@@ -231,6 +250,13 @@ func (leaderElection *RedisLeaderElection) IsReady() bool {
 	return value
 }
 
+func (leaderElection *RedisLeaderElection) FencingToken() uint64 {
+	leaderElection.leaderMutex.RLock()
+	value := leaderElection.fencingToken
+	leaderElection.leaderMutex.RUnlock()
+	return value
+}
+
 func LoadMasterServiceData(ctx context.Context, redisClient redis.Cmdable, service string, name string) []byte {
 	seconds := time.Now().Unix()
 	period := seconds / 3
@@ -0,0 +1,299 @@
+package common
+
+// Relay bootstrap lets a seller bring up a new relay without hand entering it. An operator mints a one-time token bound to
+// a seller and datacenter. The new relay presents the token with its generated keypair to the relay gateway, which registers
+// it as pending. An admin approves the pending relay with one call, which creates the relay in the database. Tokens and
+// pending relays live in the relay backend redis, next to rollouts.
+//
+// The relay's private key is encrypted with the secrets master key while it waits for approval. Approving or rejecting
+// a pending relay removes it from the pending hash, and leaves only its status (without the private key) for the relay
+// to poll until it expires.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"time"
+
+	"github.com/networknext/next/modules/envvar"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	PendingRelayStatus_Pending  = "pending"
+	PendingRelayStatus_Approved = "approved"
+	PendingRelayStatus_Rejected = "rejected"
+)
+
+const RelayBootstrapTokenRedisKeyPrefix = "relay-bootstrap-token-"
+const PendingRelaysRedisKey = "relay-bootstrap-pending"
+const PendingRelayStatusRedisKeyPrefix = "relay-bootstrap-status-"
+
+const RelayBootstrapTokenBytes = 32
+const PendingRelayTimeout = 7 * 24 * 60 * 60 // seconds. pending relays are forgotten after this long
+
+var relayNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+type RelayBootstrapToken struct {
+	SellerId     uint64 `json:"seller_id,string"`
+	DatacenterId uint64 `json:"datacenter_id,string"`
+	CreatedAt    int64  `json:"created_at"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type PendingRelay struct {
+	RegistrationId   string `json:"registration_id"`
+	Status           string `json:"status"`
+	SellerId         uint64 `json:"seller_id,string"`
+	DatacenterId     uint64 `json:"datacenter_id,string"`
+	RelayName        string `json:"relay_name"`
+	PublicAddress    string `json:"public_address"`
+	InternalAddress  string `json:"internal_address"`
+	PublicKeyBase64  string `json:"public_key_base64"`
+	PrivateKeyBase64 string `json:"private_key_base64"`
+	RequestedAt      int64  `json:"requested_at"`
+	RelayId          uint64 `json:"relay_id,string"`
+	Reason           string `json:"reason"`
+}
+
+// IMPORTANT: only the hash of the token is stored, so reading redis doesn't let you register relays
+
+func relayBootstrapTokenKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return RelayBootstrapTokenRedisKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+func CreateRelayBootstrapToken(ctx context.Context, redisClient redis.Cmdable, sellerId uint64, datacenterId uint64, expiry time.Duration) (string, error) {
+	tokenData := make([]byte, RelayBootstrapTokenBytes)
+	if _, err := rand.Read(tokenData); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenData)
+	currentTime := time.Now()
+	data, err := json.Marshal(&RelayBootstrapToken{
+		SellerId:     sellerId,
+		DatacenterId: datacenterId,
+		CreatedAt:    currentTime.Unix(),
+		ExpiresAt:    currentTime.Add(expiry).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	err = redisClient.Set(ctx, relayBootstrapTokenKey(token), data, expiry).Err()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// DeleteRelayBootstrapToken revokes a token that hasn't been redeemed yet
+
+func DeleteRelayBootstrapToken(ctx context.Context, redisClient redis.Cmdable, token string) error {
+	return redisClient.Del(ctx, relayBootstrapTokenKey(token)).Err()
+}
+
+// RedeemRelayBootstrapToken consumes the token, so it can only be used once
+
+func RedeemRelayBootstrapToken(ctx context.Context, redisClient redis.Cmdable, token string) (*RelayBootstrapToken, error) {
+	data, err := redisClient.GetDel(ctx, relayBootstrapTokenKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("invalid or expired bootstrap token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	bootstrapToken := &RelayBootstrapToken{}
+	if err := json.Unmarshal(data, bootstrapToken); err != nil {
+		return nil, err
+	}
+	if bootstrapToken.ExpiresAt < time.Now().Unix() {
+		return nil, fmt.Errorf("invalid or expired bootstrap token")
+	}
+	return bootstrapToken, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+
+// Validate checks what the relay sent us, before we burn the token on it
+
+func (pendingRelay *PendingRelay) Validate() error {
+	if pendingRelay.RelayName != "" && (len(pendingRelay.RelayName) > 63 || !relayNameRegex.MatchString(pendingRelay.RelayName)) {
+		return fmt.Errorf("invalid relay name")
+	}
+	publicAddress, err := netip.ParseAddrPort(pendingRelay.PublicAddress)
+	if err != nil || publicAddress.Addr().IsUnspecified() || publicAddress.Port() == 0 {
+		return fmt.Errorf("invalid public address")
+	}
+	if pendingRelay.InternalAddress != "" {
+		if _, err := netip.ParseAddrPort(pendingRelay.InternalAddress); err != nil {
+			return fmt.Errorf("invalid internal address")
+		}
+	}
+	if data, err := base64.StdEncoding.DecodeString(pendingRelay.PublicKeyBase64); err != nil || len(data) != 32 {
+		return fmt.Errorf("invalid public key")
+	}
+	if data, err := base64.StdEncoding.DecodeString(pendingRelay.PrivateKeyBase64); err != nil || len(data) != 32 {
+		return fmt.Errorf("invalid private key")
+	}
+	return nil
+}
+
+func pendingRelayStatusKey(registrationId string) string {
+	return PendingRelayStatusRedisKeyPrefix + registrationId
+}
+
+// pendingRelayStatus is what stays in redis once a pending relay is approved or rejected. IMPORTANT: never the private key!
+
+func pendingRelayStatus(pendingRelay *PendingRelay) ([]byte, error) {
+	status := *pendingRelay
+	status.PrivateKeyBase64 = ""
+	return json.Marshal(&status)
+}
+
+func StorePendingRelay(ctx context.Context, redisClient redis.Cmdable, pendingRelay *PendingRelay) error {
+	data, err := json.Marshal(pendingRelay)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(ctx, PendingRelaysRedisKey, pendingRelay.RegistrationId, data).Err()
+}
+
+// LoadPendingRelay returns the pending relay, or its status once it has been approved or rejected
+
+func LoadPendingRelay(ctx context.Context, redisClient redis.Cmdable, registrationId string) (*PendingRelay, error) {
+	data, err := redisClient.HGet(ctx, PendingRelaysRedisKey, registrationId).Bytes()
+	if err == redis.Nil {
+		data, err = redisClient.Get(ctx, pendingRelayStatusKey(registrationId)).Bytes()
+	}
+	if err == redis.Nil {
+		return nil, fmt.Errorf("pending relay %s not found", registrationId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pendingRelay := &PendingRelay{}
+	if err := json.Unmarshal(data, pendingRelay); err != nil {
+		return nil, err
+	}
+	return pendingRelay, nil
+}
+
+// ClaimPendingRelay atomically takes a pending relay out of the pending hash, so two admins approving or rejecting the same
+// relay at the same time can't both act on it. While claimed, the relay still reads as pending through LoadPendingRelay.
+// Call FinishPendingRelay once it is approved or rejected, or ReleasePendingRelay to put it back if that fails.
+
+func ClaimPendingRelay(ctx context.Context, redisClient redis.UniversalClient, registrationId string) (*PendingRelay, error) {
+	var pendingRelay *PendingRelay
+	err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, PendingRelaysRedisKey, registrationId).Bytes()
+		if err == redis.Nil {
+			return fmt.Errorf("relay %s is not pending", registrationId)
+		}
+		if err != nil {
+			return err
+		}
+		pendingRelay = &PendingRelay{}
+		if err := json.Unmarshal(data, pendingRelay); err != nil {
+			return err
+		}
+		if pendingRelay.Status != PendingRelayStatus_Pending {
+			return fmt.Errorf("relay is already %s", pendingRelay.Status)
+		}
+		statusData, err := pendingRelayStatus(pendingRelay)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, PendingRelaysRedisKey, registrationId)
+			pipe.Set(ctx, pendingRelayStatusKey(registrationId), statusData, PendingRelayTimeout*time.Second)
+			return nil
+		})
+		return err
+	}, PendingRelaysRedisKey)
+	if err == redis.TxFailedErr {
+		return nil, fmt.Errorf("pending relays changed while claiming %s, try again", registrationId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pendingRelay, nil
+}
+
+// FinishPendingRelay records that a claimed relay was approved or rejected, for the relay to poll
+
+func FinishPendingRelay(ctx context.Context, redisClient redis.Cmdable, pendingRelay *PendingRelay) error {
+	statusData, err := pendingRelayStatus(pendingRelay)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(ctx, pendingRelayStatusKey(pendingRelay.RegistrationId), statusData, PendingRelayTimeout*time.Second).Err()
+}
+
+// ReleasePendingRelay puts a claimed relay back in the pending hash, so it can be approved or rejected again
+
+func ReleasePendingRelay(ctx context.Context, redisClient redis.Cmdable, pendingRelay *PendingRelay) error {
+	if err := StorePendingRelay(ctx, redisClient, pendingRelay); err != nil {
+		return err
+	}
+	return redisClient.Del(ctx, pendingRelayStatusKey(pendingRelay.RegistrationId)).Err()
+}
+
+// LoadPendingRelays returns all pending relays, and forgets any that have timed out or were approved or rejected
+
+func LoadPendingRelays(ctx context.Context, redisClient redis.Cmdable) ([]PendingRelay, error) {
+	values, err := redisClient.HGetAll(ctx, PendingRelaysRedisKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	currentTime := time.Now().Unix()
+	pendingRelays := make([]PendingRelay, 0, len(values))
+	for registrationId, value := range values {
+		pendingRelay := PendingRelay{}
+		if err := json.Unmarshal([]byte(value), &pendingRelay); err != nil || pendingRelay.RequestedAt < currentTime-PendingRelayTimeout || pendingRelay.Status != PendingRelayStatus_Pending {
+			redisClient.HDel(ctx, PendingRelaysRedisKey, registrationId)
+			continue
+		}
+		pendingRelays = append(pendingRelays, pendingRelay)
+	}
+	return pendingRelays, nil
+}
+
+// RegisterPendingRelay redeems the bootstrap token and registers the relay as pending approval, with its private key
+// encrypted with the secrets master key. Decrypt it with envvar.DecryptSecret when the relay is approved.
+
+func RegisterPendingRelay(ctx context.Context, redisClient redis.Cmdable, token string, pendingRelay *PendingRelay, masterKey []byte) error {
+	if err := pendingRelay.Validate(); err != nil {
+		return err
+	}
+	if len(masterKey) == 0 {
+		return fmt.Errorf("relay bootstrap needs the secrets master key")
+	}
+	privateKey, err := envvar.EncryptSecret(pendingRelay.PrivateKeyBase64, masterKey)
+	if err != nil {
+		return err
+	}
+	bootstrapToken, err := RedeemRelayBootstrapToken(ctx, redisClient, token)
+	if err != nil {
+		return err
+	}
+	registrationId := make([]byte, 16)
+	if _, err := rand.Read(registrationId); err != nil {
+		return err
+	}
+	pendingRelay.RegistrationId = hex.EncodeToString(registrationId)
+	pendingRelay.Status = PendingRelayStatus_Pending
+	pendingRelay.SellerId = bootstrapToken.SellerId
+	pendingRelay.DatacenterId = bootstrapToken.DatacenterId
+	pendingRelay.RequestedAt = time.Now().Unix()
+	pendingRelay.RelayId = 0
+	pendingRelay.Reason = ""
+	pendingRelay.PrivateKeyBase64 = privateKey
+	return StorePendingRelay(ctx, redisClient, pendingRelay)
+}
@@ -0,0 +1,73 @@
+package common_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/networknext/next/modules/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingRelay_Validate(t *testing.T) {
+
+	t.Parallel()
+
+	valid := func() common.PendingRelay {
+		return common.PendingRelay{
+			RelayName:        "google.saopaulo.1",
+			PublicAddress:    "34.1.2.3:40000",
+			PublicKeyBase64:  "9SKtwe4Ear59iQyBOggxutzdtVLLc1YQ2qnArgiiz14=",
+			PrivateKeyBase64: "lypnDfozGRHepukundjYAF5fKY1Tw2g7Dxh0rAgMCt8=",
+		}
+	}
+
+	pendingRelay := valid()
+	assert.Nil(t, pendingRelay.Validate())
+
+	// relay name is optional, the admin api picks one on approval
+
+	pendingRelay = valid()
+	pendingRelay.RelayName = ""
+	assert.Nil(t, pendingRelay.Validate())
+
+	pendingRelay = valid()
+	pendingRelay.RelayName = "Bad Name"
+	assert.NotNil(t, pendingRelay.Validate())
+
+	pendingRelay = valid()
+	pendingRelay.PublicAddress = "0.0.0.0:40000"
+	assert.NotNil(t, pendingRelay.Validate())
+
+	pendingRelay = valid()
+	pendingRelay.PublicAddress = "34.1.2.3"
+	assert.NotNil(t, pendingRelay.Validate())
+
+	pendingRelay = valid()
+	pendingRelay.InternalAddress = "10.0.0.1:40000"
+	assert.Nil(t, pendingRelay.Validate())
+
+	pendingRelay = valid()
+	pendingRelay.PublicKeyBase64 = "AAAA"
+	assert.NotNil(t, pendingRelay.Validate())
+
+	pendingRelay = valid()
+	pendingRelay.PrivateKeyBase64 = ""
+	assert.NotNil(t, pendingRelay.Validate())
+}
+
+func TestRegisterPendingRelay_NeedsMasterKey(t *testing.T) {
+
+	t.Parallel()
+
+	// without the secrets master key the private key would be stored in plaintext, so registration fails before touching redis
+
+	pendingRelay := common.PendingRelay{
+		PublicAddress:    "34.1.2.3:40000",
+		PublicKeyBase64:  "9SKtwe4Ear59iQyBOggxutzdtVLLc1YQ2qnArgiiz14=",
+		PrivateKeyBase64: "lypnDfozGRHepukundjYAF5fKY1Tw2g7Dxh0rAgMCt8=",
+	}
+
+	assert.NotNil(t, common.RegisterPendingRelay(context.Background(), nil, "token", &pendingRelay, nil))
+	assert.Equal(t, "lypnDfozGRHepukundjYAF5fKY1Tw2g7Dxh0rAgMCt8=", pendingRelay.PrivateKeyBase64)
+}
@@ -1,6 +1,8 @@
 package common
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"math"
 	"net"
@@ -34,12 +36,25 @@ func historyMax(history []float32) float32 {
 	return max
 }
 
-func historyMean(history []float32) float32 {
+func historyMean(history []float32, numSamples int) float32 {
+	if numSamples == 0 {
+		return 0
+	}
 	var sum float64
-	for i := range history {
+	for i := range numSamples {
 		sum += float64(history[i])
 	}
-	return float32(sum / float64(constants.RelayHistorySize))
+	return float32(sum / float64(numSamples))
+}
+
+// packet loss samples arrive as [0,65535] and are kept as a percentage
+
+func packetLossPercent(sample uint16) float32 {
+	return float32(sample) / 65535.0 * 100.0
+}
+
+func packetLossSample(percent float32) uint16 {
+	return uint16(math.Round(float64(percent) / 100.0 * 65535.0))
 }
 
 type RelayManagerDestEntry struct {
@@ -47,12 +62,37 @@ type RelayManagerDestEntry struct {
 	RTT               float32
 	Jitter            float32
 	PacketLoss        float32
+	MTU               uint16 // path mtu in relay packet bytes. 0 if not known
 	HistoryIndex      int32
+	NumSamples        int32 // number of valid samples in the history, up to RelayHistorySize
 	HistoryRTT        [constants.RelayHistorySize]float32
 	HistoryJitter     [constants.RelayHistorySize]float32
 	HistoryPacketLoss [constants.RelayHistorySize]float32
 }
 
+// RelayHostMetrics describes the health of the machine a relay runs on, so a congested relay can be told apart from a broken one
+
+type RelayHostMetrics struct {
+	CPUPercent          float32
+	SoftIRQPercent      float32
+	MemoryUsedBytes     uint64
+	MemoryTotalBytes    uint64
+	NICRxDropsPerSecond float32
+	NICTxDropsPerSecond float32
+	XDPDrops            [constants.NumRelayXDPDropReasons]uint64
+}
+
+var RelayXDPDropReasonStrings = [constants.NumRelayXDPDropReasons]string{
+	"basic_packet_filter",
+	"advanced_packet_filter",
+	"fragment",
+	"large_ip_header",
+	"packet_too_small",
+	"packet_too_large",
+	"not_in_whitelist",
+	"whitelist_expired",
+}
+
 type RelayManagerSourceEntry struct {
 	LastUpdateTime int64
 	RelayId        uint64
@@ -63,12 +103,14 @@ type RelayManagerSourceEntry struct {
 	ShuttingDown   bool
 	DestEntries    map[uint64]*RelayManagerDestEntry
 	Counters       [constants.NumRelayCounters]uint64
+	HostMetrics    RelayHostMetrics
 }
 
 type RelayManager struct {
-	mutex         sync.RWMutex
-	EnableHistory bool
-	SourceEntries map[uint64]*RelayManagerSourceEntry
+	mutex          sync.RWMutex
+	EnableHistory  bool
+	EnablePingSets bool
+	SourceEntries  map[uint64]*RelayManagerSourceEntry
 }
 
 func CreateRelayManager(enableHistory bool) *RelayManager {
@@ -78,7 +120,7 @@ func CreateRelayManager(enableHistory bool) *RelayManager {
 	return relayManager
 }
 
-func (relayManager *RelayManager) ProcessRelayUpdate(currentTime int64, relayId uint64, relayName string, relayAddress net.UDPAddr, sessions int, relayVersion string, relayFlags uint64, numSamples int, sampleRelayId []uint64, sampleRTT []uint8, sampleJitter []uint8, samplePacketLoss []uint16, counters []uint64) {
+func (relayManager *RelayManager) ProcessRelayUpdate(currentTime int64, relayId uint64, relayName string, relayAddress net.UDPAddr, sessions int, relayVersion string, relayFlags uint64, numSamples int, sampleRelayId []uint64, sampleRTT []uint8, sampleJitter []uint8, samplePacketLoss []uint16, sampleMTU []uint16, counters []uint64, hostMetrics *RelayHostMetrics) {
 
 	// look up the entry corresponding to the source relay, or create it if it doesn't exist
 
@@ -118,28 +160,30 @@ func (relayManager *RelayManager) ProcessRelayUpdate(currentTime int64, relayId
 
 		destEntry, exists := sourceEntry.DestEntries[destRelayId]
 
+		// new dest entries start with an empty history. they are unknown until they have enough samples, see destEntryKnown
+
 		if !exists {
 			destEntry = &RelayManagerDestEntry{}
 			sourceEntry.DestEntries[destRelayId] = destEntry
-			for j := range constants.RelayHistorySize {
-				destEntry.HistoryRTT[j] = 1000000000.0
-				destEntry.HistoryJitter[j] = 1000000000.0
-				destEntry.HistoryPacketLoss[j] = 1000000000.0
-			}
 		}
 
 		rtt := float32(sampleRTT[i])
 		jitter := float32(sampleJitter[i])
-		packetLoss := float32(samplePacketLoss[i]) / 65535.0 * 100.0
+		packetLoss := packetLossPercent(samplePacketLoss[i])
 
 		destEntry.HistoryRTT[destEntry.HistoryIndex] = rtt
 		destEntry.HistoryJitter[destEntry.HistoryIndex] = jitter
 		destEntry.HistoryPacketLoss[destEntry.HistoryIndex] = packetLoss
 
+		if destEntry.NumSamples < constants.RelayHistorySize {
+			destEntry.NumSamples++
+		}
+
 		if relayManager.EnableHistory {
-			destEntry.RTT = historyMax(destEntry.HistoryRTT[:])
-			destEntry.Jitter = historyMean(destEntry.HistoryJitter[:])
-			destEntry.PacketLoss = historyMean(destEntry.HistoryPacketLoss[:])
+			numSamples := int(destEntry.NumSamples)
+			destEntry.RTT = historyMax(destEntry.HistoryRTT[:numSamples])
+			destEntry.Jitter = historyMean(destEntry.HistoryJitter[:], numSamples)
+			destEntry.PacketLoss = historyMean(destEntry.HistoryPacketLoss[:], numSamples)
 		} else {
 			destEntry.RTT = rtt
 			destEntry.Jitter = jitter
@@ -148,6 +192,14 @@ func (relayManager *RelayManager) ProcessRelayUpdate(currentTime int64, relayId
 
 		destEntry.HistoryIndex = (destEntry.HistoryIndex + 1) % constants.RelayHistorySize
 
+		// path mtu is already measured over a window by the relay. older relays don't probe it
+
+		if sampleMTU != nil {
+			destEntry.MTU = sampleMTU[i]
+		} else {
+			destEntry.MTU = 0
+		}
+
 		destEntry.LastUpdateTime = currentTime
 	}
 
@@ -157,6 +209,14 @@ func (relayManager *RelayManager) ProcessRelayUpdate(currentTime int64, relayId
 		sourceEntry.Counters[i] = counters[i]
 	}
 
+	// update host metrics. older relays don't send them
+
+	if hostMetrics != nil {
+		sourceEntry.HostMetrics = *hostMetrics
+	} else {
+		sourceEntry.HostMetrics = RelayHostMetrics{}
+	}
+
 	relayManager.mutex.Unlock()
 }
 
@@ -168,49 +228,92 @@ func Max(a float32, b float32) float32 {
 	}
 }
 
-func (relayManager *RelayManager) getSample(sourceRelayId uint64, destRelayId uint64) (float32, float32, float32) {
+// destEntryKnown returns true once a dest entry has enough samples to be routable. with history enabled a new
+// entry waits for a full history. pairs in a ping set only live for a rotation period, so with ping sets enabled
+// a new entry only waits for RelayHistoryMinSamples
 
-	sourceRTT := float32(200000.0)
-	sourceJitter := float32(200000.0)
-	sourcePacketLoss := float32(200000.0)
+func (relayManager *RelayManager) destEntryKnown(destEntry *RelayManagerDestEntry) bool {
+	if destEntry == nil {
+		return false
+	}
+	if !relayManager.EnableHistory {
+		return destEntry.NumSamples > 0
+	}
+	if relayManager.EnablePingSets {
+		return destEntry.NumSamples >= constants.RelayHistoryMinSamples
+	}
+	return destEntry.NumSamples >= constants.RelayHistorySize
+}
+
+// getSample returns the worst of the source -> dest and dest -> source samples for a relay pair.
+// by default both directions must be known. with ping sets enabled, relays only ping the relays in
+// their ping set, so a direction with no samples is unknown, not expensive, and one known direction
+// is enough. if the pair is unknown, known is returned false.
 
-	destRTT := float32(200000.0)
-	destJitter := float32(200000.0)
-	destPacketLoss := float32(200000.0)
+func (relayManager *RelayManager) getSample(sourceRelayId uint64, destRelayId uint64) (rtt float32, jitter float32, packetLoss float32, known bool) {
+
+	var sourceEntry, destEntry *RelayManagerDestEntry
 
 	// get source ping values
 	{
-		sourceEntry := relayManager.SourceEntries[sourceRelayId]
-		if sourceEntry != nil {
-			destEntry := sourceEntry.DestEntries[destRelayId]
-			if destEntry != nil {
-				sourceRTT = destEntry.RTT
-				sourceJitter = destEntry.Jitter
-				sourcePacketLoss = destEntry.PacketLoss
-			}
+		entry := relayManager.SourceEntries[sourceRelayId]
+		if entry != nil && relayManager.destEntryKnown(entry.DestEntries[destRelayId]) {
+			sourceEntry = entry.DestEntries[destRelayId]
 		}
 	}
 
 	// get dest ping values
 	{
-		sourceEntry := relayManager.SourceEntries[destRelayId]
-		if sourceEntry != nil {
-			destEntry := sourceEntry.DestEntries[sourceRelayId]
-			if destEntry != nil {
-				destRTT = destEntry.RTT
-				destJitter = destEntry.Jitter
-				destPacketLoss = destEntry.PacketLoss
-			}
+		entry := relayManager.SourceEntries[destRelayId]
+		if entry != nil && relayManager.destEntryKnown(entry.DestEntries[sourceRelayId]) {
+			destEntry = entry.DestEntries[sourceRelayId]
 		}
 	}
 
-	// take maximum of source and dest values
+	if !relayManager.EnablePingSets && (sourceEntry == nil || destEntry == nil) {
+		return 0, 0, 0, false
+	}
 
-	rtt := Max(sourceRTT, destRTT)
-	jitter := Max(sourceJitter, destJitter)
-	packetLoss := Max(sourcePacketLoss, destPacketLoss)
+	switch {
 
-	return rtt, jitter, packetLoss
+	case sourceEntry != nil && destEntry != nil:
+		// take maximum of source and dest values
+		return Max(sourceEntry.RTT, destEntry.RTT), Max(sourceEntry.Jitter, destEntry.Jitter), Max(sourceEntry.PacketLoss, destEntry.PacketLoss), true
+
+	case sourceEntry != nil:
+		return sourceEntry.RTT, sourceEntry.Jitter, sourceEntry.PacketLoss, true
+
+	case destEntry != nil:
+		return destEntry.RTT, destEntry.Jitter, destEntry.PacketLoss, true
+
+	default:
+		return 0, 0, 0, false
+	}
+}
+
+// getMTU returns the smaller of the source -> dest and dest -> source path mtu for a relay pair, or 0 if neither is known
+
+func (relayManager *RelayManager) getMTU(sourceRelayId uint64, destRelayId uint64) uint16 {
+
+	var sourceMTU, destMTU uint16
+
+	entry := relayManager.SourceEntries[sourceRelayId]
+	if entry != nil && entry.DestEntries[destRelayId] != nil {
+		sourceMTU = entry.DestEntries[destRelayId].MTU
+	}
+
+	entry = relayManager.SourceEntries[destRelayId]
+	if entry != nil && entry.DestEntries[sourceRelayId] != nil {
+		destMTU = entry.DestEntries[sourceRelayId].MTU
+	}
+
+	if sourceMTU == 0 {
+		return destMTU
+	}
+	if destMTU == 0 || sourceMTU < destMTU {
+		return sourceMTU
+	}
+	return destMTU
 }
 
 func (relayManager *RelayManager) GetHistory(sourceRelayId uint64, destRelayId uint64) ([]float32, []float32, []float32) {
@@ -258,8 +361,8 @@ func (relayManager *RelayManager) GetCosts(currentTime int64, relayIds []uint64,
 				destRelayId := uint64(relayIds[j])
 				_, destActive := activeRelayMap[destRelayId]
 				if destActive {
-					rtt, jitter, packetLoss := relayManager.getSample(sourceRelayId, destRelayId)
-					if rtt < 255 && jitter <= maxJitter && packetLoss <= maxPacketLoss {
+					rtt, jitter, packetLoss, known := relayManager.getSample(sourceRelayId, destRelayId)
+					if known && rtt < 255 && jitter <= maxJitter && packetLoss <= maxPacketLoss {
 						index := TriMatrixIndex(i, j)
 						costs[index] = uint8(math.Ceil(float64(rtt)))
 						if costs[index] == 0 {
@@ -276,15 +379,47 @@ func (relayManager *RelayManager) GetCosts(currentTime int64, relayIds []uint64,
 	return costs
 }
 
+// GetMTUs returns the path mtu for each relay pair in the same triangular layout as GetCosts. 0 means not known
+
+func (relayManager *RelayManager) GetMTUs(currentTime int64, relayIds []uint64) []uint16 {
+
+	numRelays := len(relayIds)
+
+	mtus := make([]uint16, TriMatrixLength(numRelays))
+
+	activeRelayMap := relayManager.GetActiveRelayMap(currentTime)
+
+	relayManager.mutex.RLock()
+
+	for i := range numRelays {
+		sourceRelayId := uint64(relayIds[i])
+		_, sourceActive := activeRelayMap[sourceRelayId]
+		if sourceActive {
+			for j := 0; j < i; j++ {
+				destRelayId := uint64(relayIds[j])
+				_, destActive := activeRelayMap[destRelayId]
+				if destActive {
+					mtus[TriMatrixIndex(i, j)] = relayManager.getMTU(sourceRelayId, destRelayId)
+				}
+			}
+		}
+	}
+
+	relayManager.mutex.RUnlock()
+
+	return mtus
+}
+
 var RelayStatusStrings = [3]string{"offline", "online", "shutting down"}
 
 type Relay struct {
-	Id       uint64
-	Name     string
-	Address  net.UDPAddr
-	Status   int
-	Sessions int
-	Version  string
+	Id          uint64
+	Name        string
+	Address     net.UDPAddr
+	Status      int
+	Sessions    int
+	Version     string
+	HostMetrics RelayHostMetrics
 }
 
 func (relayManager *RelayManager) GetRelays(currentTime int64, relayIds []uint64, relayNames []string, relayAddresses []net.UDPAddr) []Relay {
@@ -329,6 +464,7 @@ func (relayManager *RelayManager) GetRelays(currentTime int64, relayIds []uint64
 
 		if relay.Status == constants.RelayStatus_Online {
 			relay.Version = sourceEntry.RelayVersion
+			relay.HostMetrics = sourceEntry.HostMetrics
 		}
 
 		if relay.Status != constants.RelayStatus_Online {
@@ -396,6 +532,7 @@ func (relayManager *RelayManager) GetActiveRelays(currentTime int64) []Relay {
 		activeRelay.Id = sourceEntry.RelayId
 		activeRelay.Sessions = sourceEntry.Sessions
 		activeRelay.Version = sourceEntry.RelayVersion
+		activeRelay.HostMetrics = sourceEntry.HostMetrics
 
 		expired := currentTime-sourceEntry.LastUpdateTime > constants.RelayTimeout
 
@@ -430,19 +567,33 @@ func (relayManager *RelayManager) GetActiveRelayMap(currentTime int64) map[uint6
 func (relayManager *RelayManager) GetRelaysCSV(currentTime int64, relayIds []uint64, relayNames []string, relayAddresses []net.UDPAddr) []byte {
 
 	var relaysCSV strings.Builder
-	relaysCSV.WriteString("name,address,id,status,sessions,version\n")
+	relaysCSV.WriteString("name,address,id,status,sessions,version,cpu_percent,softirq_percent,memory_used_mb,memory_total_mb,nic_rx_drops_per_second,nic_tx_drops_per_second")
+	for i := range RelayXDPDropReasonStrings {
+		relaysCSV.WriteString(fmt.Sprintf(",xdp_drop_%s", RelayXDPDropReasonStrings[i]))
+	}
+	relaysCSV.WriteString("\n")
 
 	relays := relayManager.GetRelays(currentTime, relayIds, relayNames, relayAddresses)
 
 	for i := range relays {
 		relay := relays[i]
-		relaysCSV.WriteString(fmt.Sprintf("%s,%s,%016x,%s,%d,%s\n",
+		relaysCSV.WriteString(fmt.Sprintf("%s,%s,%016x,%s,%d,%s,%.1f,%.1f,%d,%d,%.1f,%.1f",
 			relay.Name,
 			relay.Address.String(),
 			relay.Id,
 			RelayStatusStrings[relay.Status],
 			relay.Sessions,
-			relay.Version))
+			relay.Version,
+			relay.HostMetrics.CPUPercent,
+			relay.HostMetrics.SoftIRQPercent,
+			relay.HostMetrics.MemoryUsedBytes/(1024*1024),
+			relay.HostMetrics.MemoryTotalBytes/(1024*1024),
+			relay.HostMetrics.NICRxDropsPerSecond,
+			relay.HostMetrics.NICTxDropsPerSecond))
+		for j := range relay.HostMetrics.XDPDrops {
+			relaysCSV.WriteString(fmt.Sprintf(",%d", relay.HostMetrics.XDPDrops[j]))
+		}
+		relaysCSV.WriteString("\n")
 	}
 
 	return []byte(relaysCSV.String())
@@ -466,3 +617,183 @@ func (relayManager *RelayManager) Copy() *RelayManager {
 	relayManager.mutex.Unlock()
 	return copy
 }
+
+// Relay manager state is replicated from the leader relay backend to followers, so when leadership changes the new
+// leader already has the same link stats, history and counters, and keeps producing the same cost matrix as the old
+// leader would have. Each link's history is sent as the raw samples the relays reported, which is lossless and a quarter
+// of the size of the float history. Host metrics are not replicated. Followers get those from their own relay updates
+
+const RelayManagerStateVersion = 4 // IMPORTANT: bump this anytime you change the relay manager state structures below!
+
+type relayManagerStateHeader struct {
+	Version   int
+	Timestamp int64
+}
+
+type relayManagerStateSourceEntry struct {
+	LastUpdateTime int64
+	RelayId        uint64
+	RelayName      string
+	RelayAddress   net.UDPAddr
+	Sessions       int
+	RelayVersion   string
+	ShuttingDown   bool
+	Counters       [constants.NumRelayCounters]uint64
+	DestEntries    []relayManagerStateDestEntry
+}
+
+type relayManagerStateDestEntry struct {
+	RelayId           uint64
+	LastUpdateTime    int64
+	RTT               float32
+	Jitter            float32
+	PacketLoss        float32
+	MTU               uint16
+	HistoryIndex      int32
+	HistoryRTT        []uint8 // the first NumSamples entries of the history ring buffer
+	HistoryJitter     []uint8
+	HistoryPacketLoss []uint16
+}
+
+func (relayManager *RelayManager) WriteState(timestamp int64) ([]byte, error) {
+
+	// copy the summary under the lock, then serialize it outside the lock, so relay updates aren't held up
+
+	relayManager.mutex.RLock()
+	sourceEntries := make([]relayManagerStateSourceEntry, 0, len(relayManager.SourceEntries))
+	for _, sourceEntry := range relayManager.SourceEntries {
+		state := relayManagerStateSourceEntry{
+			LastUpdateTime: sourceEntry.LastUpdateTime,
+			RelayId:        sourceEntry.RelayId,
+			RelayName:      sourceEntry.RelayName,
+			RelayAddress:   sourceEntry.RelayAddress,
+			Sessions:       sourceEntry.Sessions,
+			RelayVersion:   sourceEntry.RelayVersion,
+			ShuttingDown:   sourceEntry.ShuttingDown,
+			Counters:       sourceEntry.Counters,
+			DestEntries:    make([]relayManagerStateDestEntry, 0, len(sourceEntry.DestEntries)),
+		}
+		for destRelayId, destEntry := range sourceEntry.DestEntries {
+			if !relayManager.destEntryKnown(destEntry) {
+				continue
+			}
+			numSamples := int(destEntry.NumSamples)
+			destState := relayManagerStateDestEntry{
+				RelayId:           destRelayId,
+				LastUpdateTime:    destEntry.LastUpdateTime,
+				RTT:               destEntry.RTT,
+				Jitter:            destEntry.Jitter,
+				PacketLoss:        destEntry.PacketLoss,
+				MTU:               destEntry.MTU,
+				HistoryIndex:      destEntry.HistoryIndex,
+				HistoryRTT:        make([]uint8, numSamples),
+				HistoryJitter:     make([]uint8, numSamples),
+				HistoryPacketLoss: make([]uint16, numSamples),
+			}
+			for j := range numSamples {
+				destState.HistoryRTT[j] = uint8(destEntry.HistoryRTT[j])
+				destState.HistoryJitter[j] = uint8(destEntry.HistoryJitter[j])
+				destState.HistoryPacketLoss[j] = packetLossSample(destEntry.HistoryPacketLoss[j])
+			}
+			state.DestEntries = append(state.DestEntries, destState)
+		}
+		sourceEntries = append(sourceEntries, state)
+	}
+	relayManager.mutex.RUnlock()
+
+	header := relayManagerStateHeader{
+		Version:   RelayManagerStateVersion,
+		Timestamp: timestamp,
+	}
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(&header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write relay manager state header: %v", err)
+	}
+	err = encoder.Encode(sourceEntries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write relay manager state: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// ReadState applies state written by WriteState, but only if it was written after the given timestamp. This way a follower
+// doesn't throw away its own relay updates by reading the same leader state over and over
+
+func (relayManager *RelayManager) ReadState(data []byte, after int64) (int64, bool, error) {
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	header := relayManagerStateHeader{}
+	err := decoder.Decode(&header)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read relay manager state header: %v", err)
+	}
+	if header.Version != RelayManagerStateVersion {
+		return 0, false, fmt.Errorf("relay manager state version mismatch: expected %d, got %d", RelayManagerStateVersion, header.Version)
+	}
+	if header.Timestamp <= after {
+		return header.Timestamp, false, nil
+	}
+	sourceEntries := []relayManagerStateSourceEntry{}
+	err = decoder.Decode(&sourceEntries)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read relay manager state: %v", err)
+	}
+	for i := range sourceEntries {
+		for _, destState := range sourceEntries[i].DestEntries {
+			if destState.HistoryIndex < 0 || destState.HistoryIndex >= constants.RelayHistorySize {
+				return 0, false, fmt.Errorf("invalid history index %d in relay manager state", destState.HistoryIndex)
+			}
+		}
+	}
+	relayManager.mutex.Lock()
+	for i := range sourceEntries {
+		state := &sourceEntries[i]
+		sourceEntry := relayManager.SourceEntries[state.RelayId]
+		if sourceEntry == nil {
+			sourceEntry = &RelayManagerSourceEntry{}
+			sourceEntry.DestEntries = make(map[uint64]*RelayManagerDestEntry)
+			relayManager.SourceEntries[state.RelayId] = sourceEntry
+		}
+		sourceEntry.LastUpdateTime = state.LastUpdateTime
+		sourceEntry.RelayId = state.RelayId
+		sourceEntry.RelayName = state.RelayName
+		sourceEntry.RelayAddress = state.RelayAddress
+		sourceEntry.Sessions = state.Sessions
+		sourceEntry.RelayVersion = state.RelayVersion
+		sourceEntry.ShuttingDown = state.ShuttingDown
+		sourceEntry.Counters = state.Counters
+		replicated := make(map[uint64]bool, len(state.DestEntries))
+		for _, destState := range state.DestEntries {
+			destEntry := sourceEntry.DestEntries[destState.RelayId]
+			if destEntry == nil {
+				destEntry = &RelayManagerDestEntry{}
+				sourceEntry.DestEntries[destState.RelayId] = destEntry
+			}
+			destEntry.LastUpdateTime = destState.LastUpdateTime
+			destEntry.RTT = destState.RTT
+			destEntry.Jitter = destState.Jitter
+			destEntry.PacketLoss = destState.PacketLoss
+			destEntry.MTU = destState.MTU
+			numSamples := min(len(destState.HistoryRTT), len(destState.HistoryJitter), len(destState.HistoryPacketLoss), constants.RelayHistorySize)
+			destEntry.HistoryIndex = destState.HistoryIndex
+			destEntry.NumSamples = int32(numSamples)
+			destEntry.HistoryRTT = [constants.RelayHistorySize]float32{}
+			destEntry.HistoryJitter = [constants.RelayHistorySize]float32{}
+			destEntry.HistoryPacketLoss = [constants.RelayHistorySize]float32{}
+			for j := range numSamples {
+				destEntry.HistoryRTT[j] = float32(destState.HistoryRTT[j])
+				destEntry.HistoryJitter[j] = float32(destState.HistoryJitter[j])
+				destEntry.HistoryPacketLoss[j] = packetLossPercent(destState.HistoryPacketLoss[j])
+			}
+			replicated[destState.RelayId] = true
+		}
+		for destRelayId := range sourceEntry.DestEntries {
+			if !replicated[destRelayId] {
+				delete(sourceEntry.DestEntries, destRelayId)
+			}
+		}
+	}
+	relayManager.mutex.Unlock()
+	return header.Timestamp, true, nil
+}
@@ -76,9 +76,9 @@ func TestRelayManager_Local(t *testing.T) {
 
 	counters := [constants.NumRelayCounters]uint64{}
 
-	relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 0, nil, nil, nil, nil, counters[:])
+	relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], nil)
 
-	relayManager.ProcessRelayUpdate(currentTime, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 0, nil, nil, nil, nil, counters[:])
+	relayManager.ProcessRelayUpdate(currentTime, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], nil)
 
 	// we should see both relay A and B in the active relays
 
@@ -187,7 +187,7 @@ func TestRelayManager_Real(t *testing.T) {
 			sampleRTT := [1]uint8{10}
 			sampleJitter := [1]uint8{0}
 			samplePacketLoss := [1]uint16{0}
-			relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], counters[:])
+			relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], nil, counters[:], nil)
 		}
 
 		// add some samples from relay B -> A
@@ -196,7 +196,7 @@ func TestRelayManager_Real(t *testing.T) {
 			sampleRTT := [1]uint8{10}
 			sampleJitter := [1]uint8{0}
 			samplePacketLoss := [1]uint16{0}
-			relayManager.ProcessRelayUpdate(currentTime, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], counters[:])
+			relayManager.ProcessRelayUpdate(currentTime, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], nil, counters[:], nil)
 		}
 
 		costs := relayManager.GetCosts(currentTime, relayIds, MaxJitter, MaxPacketLoss)
@@ -263,7 +263,7 @@ func TestRelayManager_Real(t *testing.T) {
 
 	// apply a relay update that says relay A is shutting down. routes between relay A and B should instantly go away.
 
-	relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", constants.RelayFlags_ShuttingDown, 0, nil, nil, nil, nil, counters[:])
+	relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", constants.RelayFlags_ShuttingDown, 0, nil, nil, nil, nil, nil, counters[:], nil)
 
 	costs = relayManager.GetCosts(currentTime, relayIds, MaxJitter, MaxPacketLoss)
 
@@ -321,7 +321,7 @@ func TestRelayManager_Real(t *testing.T) {
 			sampleRTT := [1]uint8{10}
 			sampleJitter := [1]uint8{0}
 			samplePacketLoss := [1]uint16{0}
-			relayManager.ProcessRelayUpdate(currentTime+60, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], counters[:])
+			relayManager.ProcessRelayUpdate(currentTime+60, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], nil, counters[:], nil)
 		}
 
 		// add some samples from relay B -> A
@@ -330,7 +330,7 @@ func TestRelayManager_Real(t *testing.T) {
 			sampleRTT := [1]uint8{1}
 			sampleJitter := [1]uint8{0}
 			samplePacketLoss := [1]uint16{0}
-			relayManager.ProcessRelayUpdate(currentTime+60, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], counters[:])
+			relayManager.ProcessRelayUpdate(currentTime+60, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 1, sampleRelayId[:], sampleRTT[:], sampleJitter[:], samplePacketLoss[:], nil, counters[:], nil)
 		}
 
 		costs := relayManager.GetCosts(currentTime, relayIds, MaxJitter, MaxPacketLoss)
@@ -392,3 +392,255 @@ func TestRelayManager_Real(t *testing.T) {
 		assert.Equal(t, numActive, 2)
 	}
 }
+
+func TestRelayManager_OneSidedSamples(t *testing.T) {
+
+	t.Parallel()
+
+	relayManager := common.CreateRelayManager(false)
+	relayManager.EnablePingSets = true
+
+	relayNames := []string{"A", "B", "C"}
+
+	numRelays := len(relayNames)
+
+	relayIds := make([]uint64, numRelays)
+
+	relayAddresses := make([]net.UDPAddr, numRelays)
+
+	for i := range relayIds {
+		relayIds[i] = common.RelayId(relayNames[i])
+		relayAddresses[i] = core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))
+	}
+
+	const MaxJitter = 100
+	const MaxPacketLoss = 1
+
+	currentTime := time.Now().Unix()
+
+	counters := [constants.NumRelayCounters]uint64{}
+
+	// A pings B, but B doesn't ping A. C pings nobody and nobody pings C
+
+	relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 1, []uint64{relayIds[1]}, []uint8{50}, []uint8{0}, []uint16{0}, nil, counters[:], nil)
+	relayManager.ProcessRelayUpdate(currentTime, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], nil)
+	relayManager.ProcessRelayUpdate(currentTime, relayIds[2], relayNames[2], relayAddresses[2], 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], nil)
+
+	costs := relayManager.GetCosts(currentTime, relayIds, MaxJitter, MaxPacketLoss)
+
+	// the A <-> B pair is known from one side only, so it should be routable
+
+	assert.Equal(t, uint8(50), costs[common.TriMatrixIndex(1, 0)])
+
+	// pairs with C have no samples in either direction, so they are unknown and have no direct link
+
+	assert.Equal(t, uint8(255), costs[common.TriMatrixIndex(2, 0)])
+	assert.Equal(t, uint8(255), costs[common.TriMatrixIndex(2, 1)])
+
+	// without ping sets, every relay pings every other relay, so a pair known from one side only is not routable
+
+	relayManager.EnablePingSets = false
+
+	costs = relayManager.GetCosts(currentTime, relayIds, MaxJitter, MaxPacketLoss)
+
+	assert.Equal(t, uint8(255), costs[common.TriMatrixIndex(1, 0)])
+}
+
+func TestRelayManager_PingSetMinSamples(t *testing.T) {
+
+	t.Parallel()
+
+	relayManager := common.CreateRelayManager(true)
+	relayManager.EnablePingSets = true
+
+	relayNames := []string{"A", "B"}
+
+	numRelays := len(relayNames)
+
+	relayIds := make([]uint64, numRelays)
+
+	relayAddresses := make([]net.UDPAddr, numRelays)
+
+	for i := range relayIds {
+		relayIds[i] = common.RelayId(relayNames[i])
+		relayAddresses[i] = core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))
+	}
+
+	const MaxJitter = 100
+	const MaxPacketLoss = 1
+
+	currentTime := time.Now().Unix()
+
+	counters := [constants.NumRelayCounters]uint64{}
+
+	// the A <-> B pair was just rotated into the ping set. it should be unknown until it has the minimum number of samples
+
+	for i := range constants.RelayHistoryMinSamples * 2 {
+
+		relayManager.ProcessRelayUpdate(currentTime, relayIds[0], relayNames[0], relayAddresses[0], 0, "test", 0, 1, []uint64{relayIds[1]}, []uint8{20}, []uint8{5}, []uint16{0}, nil, counters[:], nil)
+		relayManager.ProcessRelayUpdate(currentTime, relayIds[1], relayNames[1], relayAddresses[1], 0, "test", 0, 1, []uint64{relayIds[0]}, []uint8{10}, []uint8{5}, []uint16{0}, nil, counters[:], nil)
+
+		costs := relayManager.GetCosts(currentTime, relayIds, MaxJitter, MaxPacketLoss)
+
+		if i < constants.RelayHistoryMinSamples-1 {
+			assert.Equal(t, uint8(255), costs[common.TriMatrixIndex(1, 0)])
+		} else {
+			assert.Equal(t, uint8(20), costs[common.TriMatrixIndex(1, 0)])
+		}
+	}
+
+	// jitter is averaged over the samples in the history only, not the empty slots, so it is 5 and over this max jitter
+
+	costs := relayManager.GetCosts(currentTime, relayIds, 4, MaxPacketLoss)
+
+	assert.Equal(t, uint8(255), costs[common.TriMatrixIndex(1, 0)])
+}
+
+func TestRelayManager_HostMetrics(t *testing.T) {
+
+	t.Parallel()
+
+	relayManager := common.CreateRelayManager(false)
+
+	relayName := "a"
+	relayId := common.RelayId(relayName)
+	relayAddress := core.ParseAddress("127.0.0.1:2000")
+
+	currentTime := time.Now().Unix()
+
+	counters := [constants.NumRelayCounters]uint64{}
+
+	hostMetrics := common.RelayHostMetrics{CPUPercent: 50, SoftIRQPercent: 10, MemoryUsedBytes: 1000, MemoryTotalBytes: 2000, NICRxDropsPerSecond: 5}
+	hostMetrics.XDPDrops[constants.RelayXDPDrop_NotInWhitelist] = 100
+
+	relayManager.ProcessRelayUpdate(currentTime, relayId, relayName, relayAddress, 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], &hostMetrics)
+
+	relays := relayManager.GetRelays(currentTime, []uint64{relayId}, []string{relayName}, []net.UDPAddr{relayAddress})
+	assert.Equal(t, 1, len(relays))
+	assert.Equal(t, hostMetrics, relays[0].HostMetrics)
+
+	// older relays don't send host metrics, so they are cleared
+
+	relayManager.ProcessRelayUpdate(currentTime, relayId, relayName, relayAddress, 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], nil)
+
+	relays = relayManager.GetRelays(currentTime, []uint64{relayId}, []string{relayName}, []net.UDPAddr{relayAddress})
+	assert.Equal(t, common.RelayHostMetrics{}, relays[0].HostMetrics)
+
+	// offline relays don't report host metrics
+
+	relayManager.ProcessRelayUpdate(currentTime, relayId, relayName, relayAddress, 0, "test", 0, 0, nil, nil, nil, nil, nil, counters[:], &hostMetrics)
+
+	relays = relayManager.GetRelays(currentTime+60, []uint64{relayId}, []string{relayName}, []net.UDPAddr{relayAddress})
+	assert.Equal(t, common.RelayHostMetrics{}, relays[0].HostMetrics)
+}
+
+func TestRelayManager_ReplicateState(t *testing.T) {
+
+	t.Parallel()
+
+	leader := common.CreateRelayManager(true)
+	follower := common.CreateRelayManager(true)
+
+	relayNames := []string{"a", "b", "c"}
+	relayIds := make([]uint64, len(relayNames))
+	relayAddresses := make([]net.UDPAddr, len(relayNames))
+	for i := range relayNames {
+		relayIds[i] = common.RelayId(relayNames[i])
+		relayAddresses[i] = core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))
+	}
+
+	currentTime := time.Now().Unix()
+
+	counters := [constants.NumRelayCounters]uint64{}
+
+	// the leader has been running a while and has history for every relay pair
+
+	for update := range constants.RelayHistorySize + 50 {
+		for i := range relayIds {
+			sampleRelayIds := []uint64{}
+			sampleRTT := []uint8{}
+			sampleJitter := []uint8{}
+			samplePacketLoss := []uint16{}
+			for j := range relayIds {
+				if i != j {
+					sampleRelayIds = append(sampleRelayIds, relayIds[j])
+					sampleRTT = append(sampleRTT, uint8(10+update%20+i+j))
+					sampleJitter = append(sampleJitter, uint8(update%5))
+					samplePacketLoss = append(samplePacketLoss, 0)
+				}
+			}
+			counters[0] = uint64(update)
+			leader.ProcessRelayUpdate(currentTime, relayIds[i], relayNames[i], relayAddresses[i], 0, "test", 0, len(sampleRelayIds), sampleRelayIds, sampleRTT, sampleJitter, samplePacketLoss, nil, counters[:], nil)
+		}
+	}
+
+	// after replication the follower produces the identical cost matrix
+
+	data, err := leader.WriteState(currentTime)
+	assert.Nil(t, err)
+
+	timestamp, updated, err := follower.ReadState(data, 0)
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, currentTime, timestamp)
+
+	leaderCosts := leader.GetCosts(currentTime, relayIds, 100, 1)
+	assert.NotEqual(t, uint8(255), leaderCosts[common.TriMatrixIndex(1, 0)])
+	assert.Equal(t, leaderCosts, follower.GetCosts(currentTime, relayIds, 100, 1))
+	assert.Equal(t, len(leader.GetActiveRelays(currentTime)), len(follower.GetActiveRelays(currentTime)))
+
+	// the history ring buffers, mtu and counters are replicated exactly, not rebuilt from the aggregated stats
+
+	for i := range relayIds {
+		for j := range relayIds {
+			if i != j {
+				assert.Equal(t, *leader.SourceEntries[relayIds[i]].DestEntries[relayIds[j]], *follower.SourceEntries[relayIds[i]].DestEntries[relayIds[j]])
+			}
+		}
+	}
+
+	assert.Equal(t, leader.GetRelayCounters(relayIds[0]), follower.GetRelayCounters(relayIds[0]))
+
+	// reading the same state again does nothing
+
+	_, updated, err = follower.ReadState(data, timestamp)
+	assert.Nil(t, err)
+	assert.False(t, updated)
+
+	// the summary doesn't depend on the history setting
+
+	noHistory := common.CreateRelayManager(false)
+
+	_, updated, err = noHistory.ReadState(data, 0)
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, leaderCosts, noHistory.GetCosts(currentTime, relayIds, 100, 1))
+
+	// garbage state is rejected
+
+	_, _, err = follower.ReadState([]byte("garbage"), 0)
+	assert.NotNil(t, err)
+
+	// after failover the follower keeps producing the same costs as the old leader would have, even once a spike
+	// in the replicated history has aged out
+
+	for update := range constants.RelayHistorySize {
+		for i := range relayIds {
+			sampleRelayIds := []uint64{}
+			sampleRTT := []uint8{}
+			sampleJitter := []uint8{}
+			samplePacketLoss := []uint16{}
+			for j := range relayIds {
+				if i != j {
+					sampleRelayIds = append(sampleRelayIds, relayIds[j])
+					sampleRTT = append(sampleRTT, uint8(10+i+j))
+					sampleJitter = append(sampleJitter, 1)
+					samplePacketLoss = append(samplePacketLoss, uint16(update%7))
+				}
+			}
+			leader.ProcessRelayUpdate(currentTime, relayIds[i], relayNames[i], relayAddresses[i], 0, "test", 0, len(sampleRelayIds), sampleRelayIds, sampleRTT, sampleJitter, samplePacketLoss, nil, counters[:], nil)
+			follower.ProcessRelayUpdate(currentTime, relayIds[i], relayNames[i], relayAddresses[i], 0, "test", 0, len(sampleRelayIds), sampleRelayIds, sampleRTT, sampleJitter, samplePacketLoss, nil, counters[:], nil)
+		}
+		assert.Equal(t, leader.GetCosts(currentTime, relayIds, 100, 1), follower.GetCosts(currentTime, relayIds, 100, 1))
+	}
+}
@@ -0,0 +1,436 @@
+package common
+
+// Rollouts move the relay fleet to a new relay version in stages, instead of editing the version on every relay row.
+// The rollout lives in the relay backend redis. The relay gateway reads it to fill TargetVersion in relay update responses,
+// and reports the health of each relay back while a rollout is in progress. The API runs the controller that advances
+// stages once they have soaked, and pauses the rollout if upgraded relays regress against their baseline.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	db "github.com/networknext/next/modules/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RolloutStage_Canary   = 0
+	RolloutStage_Percent  = 1
+	RolloutStage_Sellers  = 2
+	RolloutStage_All      = 3
+	RolloutStage_Complete = 4
+)
+
+var RolloutStageStrings = [...]string{"canary", "percent", "sellers", "all", "complete"}
+
+const RolloutRedisKey = "rollout"
+const RolloutHealthRedisKey = "rollout-health"
+
+const RolloutHealthUpdateInterval = 10 // seconds
+const RolloutHealthTimeout = 60        // seconds. relays without health this recent are considered offline
+
+// IMPORTANT: these must match RELAY_COUNTER_* in relay/xdp/relay_constants.h
+
+const RolloutCounter_PacketsReceived = 1
+
+var RolloutErrorCounters = []int{
+	12, // RELAY_COUNTER_RELAY_PING_PACKET_DID_NOT_VERIFY
+	32, // RELAY_COUNTER_ROUTE_REQUEST_PACKET_COULD_NOT_DECRYPT_ROUTE_TOKEN
+	45, // RELAY_COUNTER_ROUTE_RESPONSE_PACKET_HEADER_DID_NOT_VERIFY
+	52, // RELAY_COUNTER_CONTINUE_REQUEST_PACKET_COULD_NOT_DECRYPT_CONTINUE_TOKEN
+	65, // RELAY_COUNTER_CONTINUE_RESPONSE_PACKET_HEADER_DID_NOT_VERIFY
+	76, // RELAY_COUNTER_CLIENT_TO_SERVER_PACKET_HEADER_DID_NOT_VERIFY
+	86, // RELAY_COUNTER_SERVER_TO_CLIENT_PACKET_HEADER_DID_NOT_VERIFY
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type RolloutBaseline struct {
+	RTT       float32 `json:"rtt"`
+	ErrorRate float32 `json:"error_rate"`
+}
+
+type Rollout struct {
+	TargetVersion        string   `json:"target_version"`
+	CanaryRelays         []string `json:"canary_relays"`
+	Percent              int      `json:"percent"`
+	Sellers              []string `json:"sellers"`
+	SoakTime             int64    `json:"soak_time"`
+	MaxRTTIncrease       float32  `json:"max_rtt_increase"`
+	MaxErrorRateIncrease float32  `json:"max_error_rate_increase"`
+
+	Stage          int                        `json:"stage"`
+	SellerIndex    int                        `json:"seller_index"`
+	StartTime      int64                      `json:"start_time"`
+	StageStartTime int64                      `json:"stage_start_time"`
+	Paused         bool                       `json:"paused"`
+	PauseReason    string                     `json:"pause_reason"`
+	Baselines      map[string]RolloutBaseline `json:"baselines"` // relay name -> health before upgrade
+	Upgraded       map[string]bool            `json:"upgraded"`  // relay name -> seen running the target version
+}
+
+func (rollout *Rollout) StageString() string {
+	if rollout.Stage < 0 || rollout.Stage >= len(RolloutStageStrings) {
+		return "unknown"
+	}
+	if rollout.Stage == RolloutStage_Sellers && rollout.SellerIndex < len(rollout.Sellers) {
+		return fmt.Sprintf("sellers (%s)", strings.Join(rollout.Sellers[:rollout.SellerIndex+1], ","))
+	}
+	return RolloutStageStrings[rollout.Stage]
+}
+
+// rolloutPercentile maps each relay to [0,99] for the percent stage. the target version is mixed in,
+// so the same relays don't always go first.
+
+func rolloutPercentile(relayId uint64, targetVersion string) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(targetVersion))
+	hash.Write([]byte(strconv.FormatUint(relayId, 16)))
+	return int(hash.Sum64() % 100)
+}
+
+func (rollout *Rollout) IsRelayUpgraded(relay *db.Relay) bool {
+
+	if rollout.Stage >= RolloutStage_All {
+		return true
+	}
+
+	for i := range rollout.CanaryRelays {
+		if rollout.CanaryRelays[i] == relay.Name {
+			return true
+		}
+	}
+
+	if rollout.Stage >= RolloutStage_Percent && rolloutPercentile(relay.Id, rollout.TargetVersion) < rollout.Percent {
+		return true
+	}
+
+	if rollout.Stage >= RolloutStage_Sellers && relay.Seller != nil {
+		for i := 0; i <= rollout.SellerIndex && i < len(rollout.Sellers); i++ {
+			if rollout.Sellers[i] == relay.Seller.Code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetTargetVersion returns the version a relay should run. relays not yet reached by the rollout stay on their database version.
+
+func (rollout *Rollout) GetTargetVersion(relay *db.Relay) string {
+	if rollout != nil && rollout.IsRelayUpgraded(relay) {
+		return rollout.TargetVersion
+	}
+	return relay.Version
+}
+
+func (rollout *Rollout) advance(currentTime int64) {
+	switch rollout.Stage {
+	case RolloutStage_Canary:
+		rollout.Stage = RolloutStage_Percent
+	case RolloutStage_Percent:
+		if len(rollout.Sellers) > 0 {
+			rollout.Stage = RolloutStage_Sellers
+			rollout.SellerIndex = 0
+		} else {
+			rollout.Stage = RolloutStage_All
+		}
+	case RolloutStage_Sellers:
+		rollout.SellerIndex++
+		if rollout.SellerIndex >= len(rollout.Sellers) {
+			rollout.Stage = RolloutStage_All
+		}
+	case RolloutStage_All:
+		rollout.Stage = RolloutStage_Complete
+	}
+	rollout.StageStartTime = currentTime
+}
+
+func rolloutRelayOnline(currentTime int64, relayHealth RolloutRelayHealth, ok bool) bool {
+	return ok && int64(relayHealth.Timestamp) >= currentTime-RolloutHealthTimeout
+}
+
+// TakeBaselines records the health of online relays that don't run the target version yet. The API takes baselines
+// for the whole fleet when the rollout starts, so a relay that upgrades between controller updates still has one.
+
+func (rollout *Rollout) TakeBaselines(currentTime int64, relays []db.Relay, health map[string]RolloutRelayHealth) bool {
+	if rollout.Baselines == nil {
+		rollout.Baselines = make(map[string]RolloutBaseline)
+	}
+	changed := false
+	for i := range relays {
+		relayHealth, ok := health[relays[i].Name]
+		if !rolloutRelayOnline(currentTime, relayHealth, ok) || relayHealth.RelayVersion == rollout.TargetVersion {
+			continue
+		}
+		if _, exists := rollout.Baselines[relays[i].Name]; !exists {
+			rollout.Baselines[relays[i].Name] = RolloutBaseline{RTT: relayHealth.RTT, ErrorRate: relayHealth.ErrorRate}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Update runs one step of the rollout controller. It records baselines for relays that came online since the rollout
+// started, pauses the rollout if an upgraded relay regresses or stops reporting after it was seen on the target version,
+// and advances to the next stage once the current stage has soaked and all online upgraded relays run the target version.
+// Relays that were offline all along don't hold the rollout back. Returns true if the rollout changed.
+
+func (rollout *Rollout) Update(currentTime int64, relays []db.Relay, health map[string]RolloutRelayHealth) bool {
+
+	if rollout.Paused || rollout.Stage == RolloutStage_Complete {
+		return false
+	}
+
+	if rollout.Upgraded == nil {
+		rollout.Upgraded = make(map[string]bool)
+	}
+
+	changed := rollout.TakeBaselines(currentTime, relays, health)
+
+	stageDone := true
+
+	for i := range relays {
+
+		relay := &relays[i]
+
+		if !rollout.IsRelayUpgraded(relay) {
+			continue
+		}
+
+		relayHealth, ok := health[relay.Name]
+
+		baseline, hasBaseline := rollout.Baselines[relay.Name]
+
+		if !rolloutRelayOnline(currentTime, relayHealth, ok) {
+			if rollout.Upgraded[relay.Name] && hasBaseline {
+				rollout.Paused = true
+				rollout.PauseReason = fmt.Sprintf("relay %s stopped reporting after upgrading to %s", relay.Name, rollout.TargetVersion)
+				return true
+			}
+			continue // offline
+		}
+
+		if relayHealth.RelayVersion != rollout.TargetVersion {
+			stageDone = false
+			continue
+		}
+
+		if !rollout.Upgraded[relay.Name] {
+			rollout.Upgraded[relay.Name] = true
+			changed = true
+		}
+
+		if !hasBaseline {
+			continue
+		}
+
+		if relayHealth.RTT > baseline.RTT+rollout.MaxRTTIncrease {
+			rollout.Paused = true
+			rollout.PauseReason = fmt.Sprintf("relay %s rtt regressed from %.1fms to %.1fms", relay.Name, baseline.RTT, relayHealth.RTT)
+			return true
+		}
+
+		if relayHealth.ErrorRate > baseline.ErrorRate+rollout.MaxErrorRateIncrease {
+			rollout.Paused = true
+			rollout.PauseReason = fmt.Sprintf("relay %s error rate regressed from %.4f%% to %.4f%%", relay.Name, baseline.ErrorRate, relayHealth.ErrorRate)
+			return true
+		}
+	}
+
+	if stageDone && currentTime-rollout.StageStartTime >= rollout.SoakTime {
+		rollout.advance(currentTime)
+		changed = true
+	}
+
+	return changed
+}
+
+// Resume clears the pause. Relays already running the target version, and upgraded relays that are offline, are accepted
+// as they are and no longer compared to their baseline.
+
+func (rollout *Rollout) Resume(currentTime int64, health map[string]RolloutRelayHealth) {
+	rollout.Paused = false
+	rollout.PauseReason = ""
+	for relayName := range rollout.Baselines {
+		relayHealth, ok := health[relayName]
+		if relayHealth.RelayVersion == rollout.TargetVersion || (rollout.Upgraded[relayName] && !rolloutRelayOnline(currentTime, relayHealth, ok)) {
+			delete(rollout.Baselines, relayName)
+		}
+	}
+}
+
+// IsCommitted is true once every relay seen on the target version has it in the database. A new rollout can't start
+// over a complete one until then, or the relays would be sent back to the old version still in the database.
+
+func (rollout *Rollout) IsCommitted(relays []db.Relay) bool {
+	for i := range relays {
+		if rollout.Upgraded[relays[i].Name] && relays[i].Version != rollout.TargetVersion {
+			return false
+		}
+	}
+	return true
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type RolloutRelayHealth struct {
+	Timestamp    uint64
+	RelayVersion string
+	RTT          float32
+	ErrorRate    float32
+}
+
+func (health *RolloutRelayHealth) Value() string {
+	return fmt.Sprintf("%x|%s|%.2f|%.6f", health.Timestamp, health.RelayVersion, health.RTT, health.ErrorRate)
+}
+
+func (health *RolloutRelayHealth) Parse(value string) {
+	values := strings.Split(value, "|")
+	if len(values) != 4 {
+		return
+	}
+	timestamp, err := strconv.ParseUint(values[0], 16, 64)
+	if err != nil {
+		return
+	}
+	rtt, err := strconv.ParseFloat(values[2], 32)
+	if err != nil {
+		return
+	}
+	errorRate, err := strconv.ParseFloat(values[3], 32)
+	if err != nil {
+		return
+	}
+	health.Timestamp = timestamp
+	health.RelayVersion = values[1]
+	health.RTT = float32(rtt)
+	health.ErrorRate = float32(errorRate)
+}
+
+// RolloutRelayCounters are the counters a relay reported in its last health update, so the next update measures the
+// error rate over the interval between them, rather than over the lifetime of the relay process.
+
+type RolloutRelayCounters struct {
+	StartTime       uint64
+	PacketsReceived uint64
+	Errors          uint64
+}
+
+func GetRolloutRelayCounters(startTime uint64, counters []uint64) RolloutRelayCounters {
+	relayCounters := RolloutRelayCounters{StartTime: startTime}
+	if len(counters) > RolloutCounter_PacketsReceived {
+		relayCounters.PacketsReceived = counters[RolloutCounter_PacketsReceived]
+	}
+	for _, counter := range RolloutErrorCounters {
+		if counter < len(counters) {
+			relayCounters.Errors += counters[counter]
+		}
+	}
+	return relayCounters
+}
+
+// GetRolloutRelayHealth summarizes a relay update for the rollout controller: mean rtt to the relays it pings, and the
+// percentage of packets received since the previous update that hit an error counter. If the relay restarted since then,
+// its counters were reset, so the rate covers the time since it started. Without previous counters there is no interval
+// to measure yet, and it returns false.
+
+func GetRolloutRelayHealth(timestamp uint64, relayVersion string, sampleRTT []uint8, counters RolloutRelayCounters, previous *RolloutRelayCounters) (RolloutRelayHealth, bool) {
+
+	health := RolloutRelayHealth{Timestamp: timestamp, RelayVersion: relayVersion}
+
+	if previous == nil {
+		return health, false
+	}
+
+	if len(sampleRTT) > 0 {
+		sum := 0.0
+		for i := range sampleRTT {
+			sum += float64(sampleRTT[i])
+		}
+		health.RTT = float32(sum / float64(len(sampleRTT)))
+	}
+
+	packetsReceived := counters.PacketsReceived
+	errors := counters.Errors
+	if counters.StartTime == previous.StartTime && counters.PacketsReceived >= previous.PacketsReceived && counters.Errors >= previous.Errors {
+		packetsReceived -= previous.PacketsReceived
+		errors -= previous.Errors
+	}
+
+	if packetsReceived > 0 {
+		health.ErrorRate = float32(float64(errors) / float64(packetsReceived) * 100.0)
+	}
+
+	return health, true
+}
+
+// ----------------------------------------------------------------------------------------------
+
+func LoadRollout(ctx context.Context, redisClient redis.Cmdable) (*Rollout, error) {
+	data, err := redisClient.Get(ctx, RolloutRedisKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rollout := &Rollout{}
+	if err := json.Unmarshal(data, rollout); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+// ModifyRollout applies a change to the rollout atomically, so the controller and admin requests from different
+// API instances can't overwrite each other. The modify function returns the new rollout, or nil to delete it.
+
+func ModifyRollout(ctx context.Context, redisClient redis.UniversalClient, modify func(rollout *Rollout) (*Rollout, error)) error {
+	return redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		rollout, err := LoadRollout(ctx, tx)
+		if err != nil {
+			return err
+		}
+		rollout, err = modify(rollout)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if rollout == nil {
+				pipe.Del(ctx, RolloutRedisKey)
+				return nil
+			}
+			data, err := json.Marshal(rollout)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, RolloutRedisKey, data, 0)
+			return nil
+		})
+		return err
+	}, RolloutRedisKey)
+}
+
+func StoreRolloutRelayHealth(ctx context.Context, redisClient redis.Cmdable, relayName string, health *RolloutRelayHealth) error {
+	return redisClient.HSet(ctx, RolloutHealthRedisKey, relayName, health.Value()).Err()
+}
+
+func LoadRolloutRelayHealth(ctx context.Context, redisClient redis.Cmdable) (map[string]RolloutRelayHealth, error) {
+	values, err := redisClient.HGetAll(ctx, RolloutHealthRedisKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	health := make(map[string]RolloutRelayHealth, len(values))
+	for relayName, value := range values {
+		relayHealth := RolloutRelayHealth{}
+		relayHealth.Parse(value)
+		health[relayName] = relayHealth
+	}
+	return health, nil
+}
@@ -0,0 +1,277 @@
+package common_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/networknext/next/modules/common"
+	db "github.com/networknext/next/modules/database"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createRolloutRelays() []db.Relay {
+	sellers := []*db.Seller{{Id: 1, Code: "a"}, {Id: 2, Code: "b"}, {Id: 3, Code: "c"}}
+	relays := make([]db.Relay, 30)
+	for i := range relays {
+		relays[i].Name = fmt.Sprintf("%s.%d", sellers[i%3].Code, i)
+		relays[i].Id = common.RelayId(relays[i].Name)
+		relays[i].Version = "relay-old"
+		relays[i].Seller = sellers[i%3]
+	}
+	return relays
+}
+
+func countUpgraded(rollout *common.Rollout, relays []db.Relay) int {
+	count := 0
+	for i := range relays {
+		if rollout.IsRelayUpgraded(&relays[i]) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRollout_Stages(t *testing.T) {
+
+	t.Parallel()
+
+	relays := createRolloutRelays()
+
+	rollout := common.Rollout{
+		TargetVersion: "relay-new",
+		CanaryRelays:  []string{relays[0].Name},
+		Percent:       0,
+		Sellers:       []string{"b", "c"},
+	}
+
+	// canary stage only upgrades the canary relays
+
+	assert.Equal(t, 1, countUpgraded(&rollout, relays))
+	assert.Equal(t, "relay-new", rollout.GetTargetVersion(&relays[0]))
+	assert.Equal(t, "relay-old", rollout.GetTargetVersion(&relays[1]))
+
+	// percent stage at 100% upgrades everything
+
+	rollout.Stage = common.RolloutStage_Percent
+	rollout.Percent = 100
+	assert.Equal(t, len(relays), countUpgraded(&rollout, relays))
+
+	// seller stages upgrade sellers in order, on top of the canary relays
+
+	rollout.Percent = 0
+	rollout.Stage = common.RolloutStage_Sellers
+	rollout.SellerIndex = 0
+	assert.Equal(t, 11, countUpgraded(&rollout, relays))
+
+	rollout.SellerIndex = 1
+	assert.Equal(t, 21, countUpgraded(&rollout, relays))
+
+	rollout.Stage = common.RolloutStage_All
+	assert.Equal(t, len(relays), countUpgraded(&rollout, relays))
+
+	// no rollout means the database version
+
+	var noRollout *common.Rollout
+	assert.Equal(t, "relay-old", noRollout.GetTargetVersion(&relays[0]))
+}
+
+func TestRollout_Update(t *testing.T) {
+
+	t.Parallel()
+
+	relays := createRolloutRelays()
+
+	rollout := common.Rollout{
+		TargetVersion:        "relay-new",
+		CanaryRelays:         []string{relays[0].Name},
+		Percent:              0,
+		SoakTime:             100,
+		MaxRTTIncrease:       5,
+		MaxErrorRateIncrease: 0.1,
+		StageStartTime:       1000,
+	}
+
+	health := make(map[string]common.RolloutRelayHealth)
+	for i := range relays {
+		health[relays[i].Name] = common.RolloutRelayHealth{Timestamp: 1000, RelayVersion: "relay-old", RTT: 50}
+	}
+
+	// the canary baseline is recorded, but the stage can't advance until the canary runs the target version
+
+	assert.True(t, rollout.Update(1000, relays, health))
+	assert.Equal(t, float32(50), rollout.Baselines[relays[0].Name].RTT)
+	assert.False(t, rollout.Update(1040, relays, health))
+	assert.Equal(t, common.RolloutStage_Canary, rollout.Stage)
+
+	// once the canary is upgraded and the stage has soaked, advance
+
+	health[relays[0].Name] = common.RolloutRelayHealth{Timestamp: 1050, RelayVersion: "relay-new", RTT: 52}
+	assert.True(t, rollout.Update(1050, relays, health))
+	assert.True(t, rollout.Upgraded[relays[0].Name])
+	assert.Equal(t, common.RolloutStage_Canary, rollout.Stage)
+	assert.True(t, rollout.Update(1100, relays, health))
+	assert.Equal(t, common.RolloutStage_Percent, rollout.Stage)
+	assert.False(t, rollout.Paused)
+
+	// a regression on the canary pauses the rollout
+
+	health[relays[0].Name] = common.RolloutRelayHealth{Timestamp: 1110, RelayVersion: "relay-new", RTT: 80}
+	assert.True(t, rollout.Update(1110, relays, health))
+	assert.True(t, rollout.Paused)
+	assert.NotEqual(t, "", rollout.PauseReason)
+	assert.False(t, rollout.Update(1120, relays, health))
+
+	// resume accepts the canary as it is
+
+	rollout.Resume(1120, health)
+	assert.False(t, rollout.Paused)
+	assert.Equal(t, "", rollout.PauseReason)
+	for i := range relays {
+		relayHealth := health[relays[i].Name]
+		relayHealth.Timestamp = 1300
+		health[relays[i].Name] = relayHealth
+	}
+	rollout.Update(1300, relays, health)
+	assert.False(t, rollout.Paused)
+	assert.Equal(t, common.RolloutStage_All, rollout.Stage)
+
+	// the even relays upgrade, the odd relays are still on the old version
+
+	for i := range relays {
+		if i%2 == 0 {
+			health[relays[i].Name] = common.RolloutRelayHealth{Timestamp: 1310, RelayVersion: "relay-new", RTT: 50}
+		} else {
+			health[relays[i].Name] = common.RolloutRelayHealth{Timestamp: 1310, RelayVersion: "relay-old", RTT: 50}
+		}
+	}
+
+	assert.True(t, rollout.Update(1310, relays, health))
+	assert.Equal(t, common.RolloutStage_All, rollout.Stage)
+	assert.True(t, rollout.Upgraded[relays[2].Name])
+	assert.False(t, rollout.Upgraded[relays[1].Name])
+
+	// an upgraded relay that stops reporting is a regression, it may have crashed on the new version
+
+	delete(health, relays[2].Name)
+
+	assert.True(t, rollout.Update(1400, relays, health))
+	assert.True(t, rollout.Paused)
+	assert.Contains(t, rollout.PauseReason, relays[2].Name)
+
+	// once the operator resumes, it is accepted as it is
+
+	rollout.Resume(1400, health)
+	assert.False(t, rollout.Paused)
+
+	// relays that go offline before they were seen on the target version don't block the rollout from completing
+
+	for i := range relays {
+		if i%2 == 0 {
+			if i != 2 {
+				health[relays[i].Name] = common.RolloutRelayHealth{Timestamp: 1400, RelayVersion: "relay-new", RTT: 50}
+			}
+		} else {
+			delete(health, relays[i].Name)
+		}
+	}
+
+	assert.True(t, rollout.Update(1410, relays, health))
+	assert.Equal(t, common.RolloutStage_Complete, rollout.Stage)
+	assert.False(t, rollout.Update(1500, relays, health))
+
+	// only the relays seen on the target version are upgraded in the database
+
+	assert.Equal(t, len(relays)/2, len(rollout.Upgraded))
+	for i := range relays {
+		assert.Equal(t, i%2 == 0, rollout.Upgraded[relays[i].Name])
+	}
+
+	assert.False(t, rollout.IsCommitted(relays))
+	for i := range relays {
+		if rollout.Upgraded[relays[i].Name] {
+			relays[i].Version = rollout.TargetVersion
+		}
+	}
+	assert.True(t, rollout.IsCommitted(relays))
+}
+
+func TestRollout_StartBaselines(t *testing.T) {
+
+	t.Parallel()
+
+	relays := createRolloutRelays()
+
+	rollout := common.Rollout{
+		TargetVersion:  "relay-new",
+		CanaryRelays:   []string{relays[0].Name},
+		SoakTime:       100,
+		MaxRTTIncrease: 5,
+		StageStartTime: 1000,
+	}
+
+	health := make(map[string]common.RolloutRelayHealth)
+	for i := range relays {
+		health[relays[i].Name] = common.RolloutRelayHealth{Timestamp: 1000, RelayVersion: "relay-old", RTT: 50}
+	}
+	delete(health, relays[1].Name)
+
+	// baselines are taken for every online relay when the rollout starts
+
+	assert.True(t, rollout.TakeBaselines(1000, relays, health))
+	assert.Equal(t, len(relays)-1, len(rollout.Baselines))
+	assert.False(t, rollout.TakeBaselines(1000, relays, health))
+
+	// the canary upgrades before the controller first looks at it, and its regression is still caught
+
+	health[relays[0].Name] = common.RolloutRelayHealth{Timestamp: 1010, RelayVersion: "relay-new", RTT: 80}
+
+	assert.True(t, rollout.Update(1010, relays, health))
+	assert.True(t, rollout.Paused)
+	assert.Contains(t, rollout.PauseReason, relays[0].Name)
+}
+
+func TestRolloutRelayHealth(t *testing.T) {
+
+	t.Parallel()
+
+	counters := make([]uint64, 150)
+	counters[common.RolloutCounter_PacketsReceived] = 1000
+	counters[common.RolloutErrorCounters[0]] = 5
+	counters[common.RolloutErrorCounters[1]] = 5
+
+	// without previous counters there is no interval to measure
+
+	previous := common.GetRolloutRelayCounters(1000, counters)
+
+	_, ok := common.GetRolloutRelayHealth(12345, "relay-new", []uint8{10, 20, 30}, previous, nil)
+	assert.False(t, ok)
+
+	// the error rate only covers packets since the previous update, so a relay that regresses after running for a long time is caught
+
+	counters[common.RolloutCounter_PacketsReceived] = 2000
+	counters[common.RolloutErrorCounters[0]] = 55
+
+	health, ok := common.GetRolloutRelayHealth(12345, "relay-new", []uint8{10, 20, 30}, common.GetRolloutRelayCounters(1000, counters), &previous)
+	assert.True(t, ok)
+	assert.Equal(t, float32(20), health.RTT)
+	assert.InDelta(t, 5.0, health.ErrorRate, 0.0001)
+
+	// a restarted relay resets its counters, so the rate covers the time since it started
+
+	counters[common.RolloutCounter_PacketsReceived] = 100
+	counters[common.RolloutErrorCounters[0]] = 1
+	counters[common.RolloutErrorCounters[1]] = 0
+
+	health, ok = common.GetRolloutRelayHealth(12345, "relay-new", []uint8{10, 20, 30}, common.GetRolloutRelayCounters(2000, counters), &previous)
+	assert.True(t, ok)
+	assert.InDelta(t, 1.0, health.ErrorRate, 0.0001)
+
+	readHealth := common.RolloutRelayHealth{}
+	readHealth.Parse(health.Value())
+
+	assert.Equal(t, health.Timestamp, readHealth.Timestamp)
+	assert.Equal(t, health.RelayVersion, readHealth.RelayVersion)
+	assert.InDelta(t, health.RTT, readHealth.RTT, 0.01)
+	assert.InDelta(t, health.ErrorRate, readHealth.ErrorRate, 0.0001)
+}
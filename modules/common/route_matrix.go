@@ -14,8 +14,8 @@ import (
 
 const (
 	RouteMatrixVersion_Min   = 3
-	RouteMatrixVersion_Max   = 5
-	RouteMatrixVersion_Write = 5
+	RouteMatrixVersion_Max   = 7
+	RouteMatrixVersion_Write = 7
 )
 
 type RouteMatrix struct {
@@ -41,6 +41,10 @@ type RouteMatrix struct {
 	Costs []byte
 
 	RelayPrice []byte
+
+	FencingToken uint64
+
+	RelayMTU []uint16
 }
 
 func (m *RouteMatrix) GetCostMatrix() *CostMatrix {
@@ -54,6 +58,7 @@ func (m *RouteMatrix) GetCostMatrix() *CostMatrix {
 	costMatrix.RelayDatacenterIds = m.RelayDatacenterIds
 	costMatrix.DestRelays = m.DestRelays
 	costMatrix.Costs = m.Costs
+	costMatrix.RelayMTU = m.RelayMTU
 	return costMatrix
 }
 
@@ -67,6 +72,7 @@ func (m *RouteMatrix) GetMaxSize() int {
 	size += int(m.BinFileBytes)
 	size += core.TriMatrixLength(numRelays)
 	size += 4 + numRelays
+	size += core.TriMatrixLength(numRelays) * 2
 	if size%8 != 0 {
 		size += 8 - size%8
 	}
@@ -177,6 +183,21 @@ func (m *RouteMatrix) Serialize(stream serialize.Stream) error {
 		stream.SerializeBytes(m.RelayPrice)
 	}
 
+	if m.Version >= 6 {
+		stream.SerializeUint64(&m.FencingToken)
+	}
+
+	// IMPORTANT: relay mtu is optional when writing. nil means no relay pair mtu is known
+
+	if stream.IsReading() || len(m.RelayMTU) == 0 {
+		m.RelayMTU = make([]uint16, core.TriMatrixLength(int(numRelays)))
+	}
+	if m.Version >= 7 {
+		for i := range m.RelayMTU {
+			stream.SerializeUint16(&m.RelayMTU[i])
+		}
+	}
+
 	return stream.Err()
 }
 
@@ -426,5 +447,12 @@ func GenerateRandomRouteMatrix(numRelays int) RouteMatrix {
 	routeMatrix.RelayPrice = make([]byte, numRelays)
 	RandomBytes(routeMatrix.RelayPrice)
 
+	routeMatrix.FencingToken = RandomUint64()
+
+	routeMatrix.RelayMTU = make([]uint16, core.TriMatrixLength(numRelays))
+	for i := range routeMatrix.RelayMTU {
+		routeMatrix.RelayMTU[i] = uint16(RandomInt(0, constants.MaxPacketBytes))
+	}
+
 	return routeMatrix
 }
@@ -96,7 +96,7 @@ type Service struct {
 	currentMagic  []byte
 	previousMagic []byte
 
-	leaderElection *RedisLeaderElection
+	leaderElection LeaderElection
 
 	sendTrafficToMe  func() bool
 	machineIsHealthy func() bool
@@ -107,6 +107,7 @@ type Service struct {
 	routeMatrixMutex    sync.RWMutex
 	routeMatrix         *RouteMatrix
 	routeMatrixDatabase *db.Database
+	routeMatrixFence    FencingTokenGuard
 
 	ip2location_mutex   sync.RWMutex
 	ip2location_isp_db  *maxminddb.Reader
@@ -484,20 +485,57 @@ func CreateRedisClusterClient(redisNodes []string) *redis.ClusterClient {
 
 func (service *Service) LeaderElection(initialDelay int) {
 
-	core.Log("started leader election")
+	backend := envvar.GetString("LEADER_ELECTION_BACKEND", LeaderElectionBackend_Redis)
 
-	redisHostname := envvar.GetString("REDIS_HOSTNAME", "127.0.0.1:6379")
+	core.Log("started leader election (%s)", backend)
 
-	redisClient := CreateRedisClient(redisHostname)
+	switch backend {
 
-	config := RedisLeaderElectionConfig{}
-	config.InitialDelay = initialDelay
-	config.ServiceName = service.ServiceName
+	case LeaderElectionBackend_Redis:
 
-	var err error
-	service.leaderElection, err = CreateRedisLeaderElection(redisClient, config)
-	if err != nil {
-		core.Error("could not create redis leader election: %v", err)
+		redisHostname := envvar.GetString("REDIS_HOSTNAME", "127.0.0.1:6379")
+
+		redisClient := CreateRedisClient(redisHostname)
+
+		config := RedisLeaderElectionConfig{}
+		config.InitialDelay = initialDelay
+		config.ServiceName = service.ServiceName
+
+		leaderElection, err := CreateRedisLeaderElection(redisClient, config)
+		if err != nil {
+			core.Error("could not create redis leader election: %v", err)
+			os.Exit(1)
+		}
+
+		service.leaderElection = leaderElection
+
+	case LeaderElectionBackend_Etcd:
+
+		etcdURL := envvar.GetString("ETCD_URL", "http://127.0.0.1:2379")
+
+		core.Log("etcd url: %s", etcdURL)
+
+		// etcd only holds the lease. instance data goes in redis
+
+		redisHostname := envvar.GetString("REDIS_HOSTNAME", "127.0.0.1:6379")
+
+		redisClient := CreateRedisClient(redisHostname)
+
+		config := LeaseLeaderElectionConfig{}
+		config.InitialDelay = initialDelay
+		config.ServiceName = service.ServiceName
+		config.LeaseDuration = envvar.GetDuration("LEADER_ELECTION_LEASE_DURATION", 10*time.Second)
+
+		leaderElection, err := CreateLeaseLeaderElection(CreateEtcdLeaseStore(etcdURL), CreateRedisLeaseDataStore(redisClient), config)
+		if err != nil {
+			core.Error("could not create lease leader election: %v", err)
+			os.Exit(1)
+		}
+
+		service.leaderElection = leaderElection
+
+	default:
+		core.Error("unknown leader election backend: %s", backend)
 		os.Exit(1)
 	}
 
@@ -505,11 +543,15 @@ func (service *Service) LeaderElection(initialDelay int) {
 }
 
 func (service *Service) Store(name string, data []byte) {
+	service.StoreWithExpiration(name, data, 0)
+}
+
+func (service *Service) StoreWithExpiration(name string, data []byte, expiration time.Duration) {
 	core.Debug("store %s (%d bytes)", name, len(data))
 	if service.leaderElection == nil {
 		panic("leader election must be enabled to call store")
 	}
-	service.leaderElection.Store(service.Context, name, data)
+	service.leaderElection.Store(service.Context, name, data, expiration)
 }
 
 func (service *Service) Load(name string) []byte {
@@ -533,6 +575,10 @@ func (service *Service) UpdateRouteMatrix(relayBackendPublicKey []byte, relayBac
 		Timeout: routeMatrixInterval,
 	}
 
+	// a route matrix is stale after 30 seconds, so if we have rejected every route matrix for that long, the fencing token was reset
+
+	service.routeMatrixFence.StaleTimeout = 30 * time.Second
+
 	ticker := time.NewTicker(routeMatrixInterval)
 
 	go func() {
@@ -594,6 +640,13 @@ func (service *Service) UpdateRouteMatrix(relayBackendPublicKey []byte, relayBac
 					continue
 				}
 
+				// IMPORTANT: reject route matrices from a stale leader. a route matrix without a fencing token is from an older relay backend
+
+				if !service.routeMatrixFence.Accept(newRouteMatrix.FencingToken, time.Now()) {
+					core.Warn("rejected route matrix from stale leader: fencing token %d is older than %d", newRouteMatrix.FencingToken, service.routeMatrixFence.Token())
+					continue
+				}
+
 				var newDatabase db.Database
 
 				err = newDatabase.LoadBinary(newRouteMatrix.BinFileData)
@@ -638,6 +691,13 @@ func (service *Service) IsLeader() bool {
 	return false
 }
 
+func (service *Service) FencingToken() uint64 {
+	if service.leaderElection != nil {
+		return service.leaderElection.FencingToken()
+	}
+	return 0
+}
+
 func (service *Service) IsReady() bool {
 	if service.leaderElection != nil {
 		return service.leaderElection.IsReady()
@@ -888,6 +948,7 @@ type ServiceStatus struct {
 	Goroutines      int     `json:"goroutines"`
 	MemoryAllocated float64 `json:"mb_allocated"`
 	IsLeader        bool    `json:"is_leader"`
+	FencingToken    uint64  `json:"fencing_token"`
 }
 
 func (service *Service) updateStatus(startTime time.Time) {
@@ -909,6 +970,7 @@ func (service *Service) updateStatus(startTime time.Time) {
 	newStatusData.Goroutines = int(runtime.NumGoroutine())
 	newStatusData.MemoryAllocated = memoryAllocatedMB()
 	newStatusData.IsLeader = service.IsLeader()
+	newStatusData.FencingToken = service.FencingToken()
 
 	service.statusMutex.Lock()
 	service.statusData = newStatusData
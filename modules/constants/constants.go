@@ -8,6 +8,8 @@ const (
 	RelayTimeout     = 30
 	RelayHistorySize = 300
 
+	RelayHistoryMinSamples = 10
+
 	MaxRouteRelays  = 5
 	MaxClientRelays = 16
 	MaxServerRelays = 8
@@ -38,9 +40,19 @@ const (
 	RouteTokenBytes          = 71
 	EncryptedRouteTokenBytes = 111
 
+	// IMPORTANT: ipv6 route tokens carry 16 byte next and prev addresses. ipv4 addresses are written ipv4-mapped
+	RouteTokenBytes_IPv6          = 95
+	EncryptedRouteTokenBytes_IPv6 = 135
+
 	ContinueTokenBytes          = 17
 	EncryptedContinueTokenBytes = 57
 
+	// IMPORTANT: bound tokens append the 8 byte client address hash to the ipv4 route token and the continue token
+	RouteTokenBytes_Bound             = 79
+	EncryptedRouteTokenBytes_Bound    = 119
+	ContinueTokenBytes_Bound          = 25
+	EncryptedContinueTokenBytes_Bound = 65
+
 	SessionError_FallbackToDirect                = (1 << 0)
 	SessionError_NoRoute                         = (1 << 1)
 	SessionError_UnknownDatacenter               = (1 << 2)
@@ -58,6 +70,8 @@ const (
 	SessionError_RouteNoLongerExists             = (1 << 14)
 	SessionError_FailedToWriteResponsePacket     = (1 << 15)
 	SessionError_FailedToWriteSessionData        = (1 << 16)
+	SessionError_IPv6NotSupported                = (1 << 17)
+	SessionError_UnknownSessionDataKey           = (1 << 18)
 
 	RelayFlags_ShuttingDown = uint64(1)
 
@@ -65,6 +79,19 @@ const (
 	RelayStatus_Online       = 1
 	RelayStatus_ShuttingDown = 2
 
+	// IMPORTANT: must match RELAY_XDP_DROP_* in relay_constants.h
+
+	RelayXDPDrop_BasicPacketFilter    = 0
+	RelayXDPDrop_AdvancedPacketFilter = 1
+	RelayXDPDrop_Fragment             = 2
+	RelayXDPDrop_LargeIPHeader        = 3
+	RelayXDPDrop_PacketTooSmall       = 4
+	RelayXDPDrop_PacketTooLarge       = 5
+	RelayXDPDrop_NotInWhitelist       = 6
+	RelayXDPDrop_WhitelistExpired     = 7
+
+	NumRelayXDPDropReasons = 8
+
 	PingKeyBytes = 32
 
 	PingTokenBytes = 32
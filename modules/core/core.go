@@ -655,12 +655,14 @@ type RouteToken struct {
 	NextInternal      uint8
 	PrevInternal      uint8
 	SessionPrivateKey [crypto.Box_PrivateKeySize]byte
+	ClientAddressHash uint64 // bound tokens only
 }
 
 type ContinueToken struct {
-	ExpireTimestamp uint64
-	SessionId       uint64
-	SessionVersion  uint8
+	ExpireTimestamp   uint64
+	SessionId         uint64
+	SessionVersion    uint8
+	ClientAddressHash uint64 // bound tokens only
 }
 
 // -----------------------------------------------------------------------------
@@ -750,6 +752,107 @@ func ReadRouteToken(token *RouteToken, buffer []byte) {
 	token.PrevAddress = net.UDPAddr{IP: net.IPv4(uint8(prevAddress&0xFF), uint8((prevAddress>>8)&0xFF), uint8((prevAddress>>16)&0xFF), uint8((prevAddress>>24)&0xFF)), Port: int(prevPort)}
 }
 
+/*
+	IPv6 route token version.
+
+	The layout matches the route token, except next and prev addresses are 16 bytes each. IPv4 addresses are written
+	ipv4-mapped, so one token version covers routes that mix ipv4 and ipv6 relays.
+
+	Packet filters and ping tokens over ipv6 addresses use 16 bytes of address data instead of 4 (see GetAddressData_IPv6
+	and GeneratePingToken_IPv6). IPv4 and ipv4-mapped addresses derive exactly as they do today.
+
+	IMPORTANT: The session update handler does not write ipv6 route tokens yet. No relay parses them and no SDK version
+	carries the larger tokens, so routes that need ipv6 are vetoed (see RouteNeedsIPv6) and WriteRouteTokens_IPv6,
+	GetAddressData_IPv6 and GeneratePingToken_IPv6 are only used by the relay corpus.
+*/
+
+func WriteRouteToken_IPv6(data *RouteToken, buffer []byte) {
+
+	index := 0
+
+	copy(buffer[index:], data.SessionPrivateKey[:])
+	index += 32
+
+	binary.LittleEndian.PutUint64(buffer[index:], data.ExpireTimestamp)
+	index += 8
+
+	binary.LittleEndian.PutUint64(buffer[index:], data.SessionId)
+	index += 8
+
+	binary.LittleEndian.PutUint32(buffer[index:], data.EnvelopeKbpsUp)
+	index += 4
+
+	binary.LittleEndian.PutUint32(buffer[index:], data.EnvelopeKbpsDown)
+	index += 4
+
+	copy(buffer[index:index+16], data.NextAddress.IP.To16())
+	index += 16
+
+	copy(buffer[index:index+16], data.PrevAddress.IP.To16())
+	index += 16
+
+	binary.BigEndian.PutUint16(buffer[index:], uint16(data.NextAddress.Port))
+	index += 2
+
+	binary.BigEndian.PutUint16(buffer[index:], uint16(data.PrevAddress.Port))
+	index += 2
+
+	buffer[index] = data.SessionVersion
+	index += 1
+
+	buffer[index] = data.NextInternal
+	index += 1
+
+	buffer[index] = data.PrevInternal
+	index += 1
+}
+
+func ReadRouteToken_IPv6(token *RouteToken, buffer []byte) {
+
+	index := 0
+
+	copy(token.SessionPrivateKey[:], buffer[index:])
+	index += 32
+
+	token.ExpireTimestamp = binary.LittleEndian.Uint64(buffer[index:])
+	index += 8
+
+	token.SessionId = binary.LittleEndian.Uint64(buffer[index:])
+	index += 8
+
+	token.EnvelopeKbpsUp = binary.LittleEndian.Uint32(buffer[index:])
+	index += 4
+
+	token.EnvelopeKbpsDown = binary.LittleEndian.Uint32(buffer[index:])
+	index += 4
+
+	nextAddress := make(net.IP, 16)
+	copy(nextAddress, buffer[index:index+16])
+	index += 16
+
+	prevAddress := make(net.IP, 16)
+	copy(prevAddress, buffer[index:index+16])
+	index += 16
+
+	nextPort := binary.BigEndian.Uint16(buffer[index:])
+	index += 2
+
+	prevPort := binary.BigEndian.Uint16(buffer[index:])
+	index += 2
+
+	token.SessionVersion = buffer[index]
+	index += 1
+
+	token.NextInternal = buffer[index]
+	index += 1
+
+	token.PrevInternal = buffer[index]
+	index += 1
+
+	token.NextAddress = net.UDPAddr{IP: nextAddress, Port: int(nextPort)}
+	token.PrevAddress = net.UDPAddr{IP: prevAddress, Port: int(prevPort)}
+}
+
 func WriteEncryptedRouteToken(token *RouteToken, tokenData []byte, secretKey []byte) bool {
 
 	data := make([]byte, constants.RouteTokenBytes)
@@ -800,7 +903,164 @@ func ReadEncryptedRouteToken(token *RouteToken, tokenData []byte, secretKey []by
 	return true
 }
 
-func WriteRouteTokens(tokenData []byte, expireTimestamp uint64, sessionId uint64, sessionVersion uint8, kbpsUp uint32, kbpsDown uint32, numNodes int, publicAddresses []net.UDPAddr, hasInternalAddress []bool, internalAddresses []net.UDPAddr, internalGroups []uint64, sellers []int, secretKeys [][]byte) {
+func WriteEncryptedRouteToken_IPv6(token *RouteToken, tokenData []byte, secretKey []byte) bool {
+
+	data := make([]byte, constants.RouteTokenBytes_IPv6)
+
+	WriteRouteToken_IPv6(token, data)
+
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return false
+	}
+
+	nonce := make([]byte, aead.NonceSize(), constants.EncryptedRouteTokenBytes_IPv6)
+	if _, err := crypto_rand.Read(nonce[:aead.NonceSize()]); err != nil {
+		return false
+	}
+
+	dest := nonce
+
+	encryptedRouteToken := aead.Seal(dest, nonce, data, nil)
+
+	copy(tokenData, encryptedRouteToken)
+
+	return true
+}
+
+func ReadEncryptedRouteToken_IPv6(token *RouteToken, tokenData []byte, secretKey []byte) bool {
+
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return false
+	}
+
+	nonceSize := aead.NonceSize()
+
+	tokenData = tokenData[:constants.EncryptedRouteTokenBytes_IPv6]
+
+	nonce, encrypted := tokenData[:nonceSize], tokenData[nonceSize:]
+
+	output := make([]byte, 0, constants.RouteTokenBytes_IPv6)
+
+	decrypted, err := aead.Open(output, nonce, encrypted, nil)
+	if err != nil {
+		return false
+	}
+
+	ReadRouteToken_IPv6(token, decrypted)
+
+	return true
+}
+
+/*
+	Token binding extension.
+
+	A route or continue token can be replayed by anyone who has a copy until it expires. Bound tokens carry a hash of the
+	client's public address, so the first relay on the route can drop a token sent from any other address. Only the first
+	hop checks it: every later relay receives the packet from the previous relay, not the client.
+
+	The port is not part of the hash. NATs may give the client a different port for each relay, and the server backend only
+	sees the port the game server sees.
+
+	The first hop is the relay whose route token has the client address with port 0 as its previous address (see
+	RouteTokenIsFirstHop). The client's own token has a zero previous address, and every later relay's token has the
+	previous relay's address.
+
+	IMPORTANT: The session update handler does not write bound tokens yet. No relay checks the binding and no SDK version
+	carries the larger tokens, so WriteRouteTokens_Bound and WriteContinueTokens_Bound are only used by the relay corpus.
+*/
+
+func ClientAddressHash(address *net.UDPAddr) uint64 {
+	hash := sha256.Sum256(GetAddressData(address))
+	return binary.LittleEndian.Uint64(hash[:8])
+}
+
+func RouteTokenIsFirstHop(token *RouteToken) bool {
+	return token.PrevAddress.Port == 0 && token.PrevAddress.IP != nil && !token.PrevAddress.IP.IsUnspecified()
+}
+
+// TokenBindingAllows is the check a relay makes on a bound token: only the first hop compares the hash against the packet source.
+
+func TokenBindingAllows(clientAddressHash uint64, firstHop bool, from *net.UDPAddr) bool {
+	return !firstHop || ClientAddressHash(from) == clientAddressHash
+}
+
+func WriteRouteToken_Bound(data *RouteToken, buffer []byte) {
+	WriteRouteToken(data, buffer)
+	binary.LittleEndian.PutUint64(buffer[constants.RouteTokenBytes:], data.ClientAddressHash)
+}
+
+func ReadRouteToken_Bound(token *RouteToken, buffer []byte) {
+	ReadRouteToken(token, buffer)
+	token.ClientAddressHash = binary.LittleEndian.Uint64(buffer[constants.RouteTokenBytes:])
+}
+
+func WriteEncryptedRouteToken_Bound(token *RouteToken, tokenData []byte, secretKey []byte) bool {
+
+	data := make([]byte, constants.RouteTokenBytes_Bound)
+
+	WriteRouteToken_Bound(token, data)
+
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return false
+	}
+
+	nonce := make([]byte, aead.NonceSize(), constants.EncryptedRouteTokenBytes_Bound)
+	if _, err := crypto_rand.Read(nonce[:aead.NonceSize()]); err != nil {
+		return false
+	}
+
+	dest := nonce
+
+	encryptedRouteToken := aead.Seal(dest, nonce, data, nil)
+
+	copy(tokenData, encryptedRouteToken)
+
+	return true
+}
+
+func ReadEncryptedRouteToken_Bound(token *RouteToken, tokenData []byte, secretKey []byte) bool {
+
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return false
+	}
+
+	nonceSize := aead.NonceSize()
+
+	tokenData = tokenData[:constants.EncryptedRouteTokenBytes_Bound]
+
+	nonce, encrypted := tokenData[:nonceSize], tokenData[nonceSize:]
+
+	output := make([]byte, 0, constants.RouteTokenBytes_Bound)
+
+	decrypted, err := aead.Open(output, nonce, encrypted, nil)
+	if err != nil {
+		return false
+	}
+
+	ReadRouteToken_Bound(token, decrypted)
+
+	return true
+}
+
+// RouteNeedsIPv6 is true if any address a route token would carry is ipv6, and the route must use ipv6 route tokens.
+
+func RouteNeedsIPv6(numNodes int, publicAddresses []net.UDPAddr, hasInternalAddress []bool, internalAddresses []net.UDPAddr) bool {
+	for i := range numNodes {
+		if IsIPv6Address(&publicAddresses[i]) {
+			return true
+		}
+		if hasInternalAddress[i] && IsIPv6Address(&internalAddresses[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeRouteTokens(ipv6 bool, bound bool, clientAddressHash uint64, tokenData []byte, expireTimestamp uint64, sessionId uint64, sessionVersion uint8, kbpsUp uint32, kbpsDown uint32, numNodes int, publicAddresses []net.UDPAddr, hasInternalAddress []bool, internalAddresses []net.UDPAddr, internalGroups []uint64, sellers []int, secretKeys [][]byte) {
 	privateKey := [crypto.Box_PrivateKeySize]byte{}
 	RandomBytes(privateKey[:])
 	for i := range numNodes {
@@ -810,6 +1070,7 @@ func WriteRouteTokens(tokenData []byte, expireTimestamp uint64, sessionId uint64
 		token.SessionVersion = sessionVersion
 		token.EnvelopeKbpsUp = kbpsUp
 		token.EnvelopeKbpsDown = kbpsDown
+		token.ClientAddressHash = clientAddressHash
 		if i != 0 {
 			if hasInternalAddress[i] && hasInternalAddress[i-1] && sellers[i] == sellers[i-1] && internalGroups[i] == internalGroups[i-1] {
 				token.PrevAddress = internalAddresses[i-1]
@@ -831,10 +1092,28 @@ func WriteRouteTokens(tokenData []byte, expireTimestamp uint64, sessionId uint64
 			token.NextAddress = net.UDPAddr{IP: net.IPv4(0, 0, 0, 0), Port: 0}
 		}
 		copy(token.SessionPrivateKey[:], privateKey[:])
-		WriteEncryptedRouteToken(&token, tokenData[i*constants.EncryptedRouteTokenBytes:(i+1)*constants.EncryptedRouteTokenBytes], secretKeys[i])
+		if ipv6 {
+			WriteEncryptedRouteToken_IPv6(&token, tokenData[i*constants.EncryptedRouteTokenBytes_IPv6:(i+1)*constants.EncryptedRouteTokenBytes_IPv6], secretKeys[i])
+		} else if bound {
+			WriteEncryptedRouteToken_Bound(&token, tokenData[i*constants.EncryptedRouteTokenBytes_Bound:(i+1)*constants.EncryptedRouteTokenBytes_Bound], secretKeys[i])
+		} else {
+			WriteEncryptedRouteToken(&token, tokenData[i*constants.EncryptedRouteTokenBytes:(i+1)*constants.EncryptedRouteTokenBytes], secretKeys[i])
+		}
 	}
 }
 
+func WriteRouteTokens(tokenData []byte, expireTimestamp uint64, sessionId uint64, sessionVersion uint8, kbpsUp uint32, kbpsDown uint32, numNodes int, publicAddresses []net.UDPAddr, hasInternalAddress []bool, internalAddresses []net.UDPAddr, internalGroups []uint64, sellers []int, secretKeys [][]byte) {
+	writeRouteTokens(false, false, 0, tokenData, expireTimestamp, sessionId, sessionVersion, kbpsUp, kbpsDown, numNodes, publicAddresses, hasInternalAddress, internalAddresses, internalGroups, sellers, secretKeys)
+}
+
+func WriteRouteTokens_IPv6(tokenData []byte, expireTimestamp uint64, sessionId uint64, sessionVersion uint8, kbpsUp uint32, kbpsDown uint32, numNodes int, publicAddresses []net.UDPAddr, hasInternalAddress []bool, internalAddresses []net.UDPAddr, internalGroups []uint64, sellers []int, secretKeys [][]byte) {
+	writeRouteTokens(true, false, 0, tokenData, expireTimestamp, sessionId, sessionVersion, kbpsUp, kbpsDown, numNodes, publicAddresses, hasInternalAddress, internalAddresses, internalGroups, sellers, secretKeys)
+}
+
+func WriteRouteTokens_Bound(tokenData []byte, clientAddress *net.UDPAddr, expireTimestamp uint64, sessionId uint64, sessionVersion uint8, kbpsUp uint32, kbpsDown uint32, numNodes int, publicAddresses []net.UDPAddr, hasInternalAddress []bool, internalAddresses []net.UDPAddr, internalGroups []uint64, sellers []int, secretKeys [][]byte) {
+	writeRouteTokens(false, true, ClientAddressHash(clientAddress), tokenData, expireTimestamp, sessionId, sessionVersion, kbpsUp, kbpsDown, numNodes, publicAddresses, hasInternalAddress, internalAddresses, internalGroups, sellers, secretKeys)
+}
+
 // -----------------------------------------------------------------------------
 
 func WriteContinueToken(token *ContinueToken, buffer []byte) {
@@ -909,9 +1188,106 @@ func WriteContinueTokens(tokenData []byte, expireTimestamp uint64, sessionId uin
 	}
 }
 
+func WriteContinueToken_Bound(token *ContinueToken, buffer []byte) {
+	WriteContinueToken(token, buffer)
+	binary.LittleEndian.PutUint64(buffer[constants.ContinueTokenBytes:], token.ClientAddressHash)
+}
+
+func ReadContinueToken_Bound(token *ContinueToken, buffer []byte) {
+	ReadContinueToken(token, buffer)
+	token.ClientAddressHash = binary.LittleEndian.Uint64(buffer[constants.ContinueTokenBytes:])
+}
+
+func WriteEncryptedContinueToken_Bound(token *ContinueToken, tokenData []byte, secretKey []byte) bool {
+
+	data := make([]byte, constants.ContinueTokenBytes_Bound)
+
+	WriteContinueToken_Bound(token, data)
+
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return false
+	}
+
+	nonce := make([]byte, aead.NonceSize(), constants.EncryptedContinueTokenBytes_Bound)
+	if _, err := crypto_rand.Read(nonce[:aead.NonceSize()]); err != nil {
+		return false
+	}
+
+	dest := nonce
+
+	encryptedContinueToken := aead.Seal(dest, nonce, data, nil)
+
+	copy(tokenData, encryptedContinueToken)
+
+	return true
+}
+
+func ReadEncryptedContinueToken_Bound(token *ContinueToken, tokenData []byte, secretKey []byte) bool {
+
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return false
+	}
+
+	nonceSize := aead.NonceSize()
+
+	tokenData = tokenData[:constants.EncryptedContinueTokenBytes_Bound]
+
+	nonce, encrypted := tokenData[:nonceSize], tokenData[nonceSize:]
+
+	output := make([]byte, 0, constants.ContinueTokenBytes_Bound)
+
+	decrypted, err := aead.Open(output, nonce, encrypted, nil)
+	if err != nil {
+		return false
+	}
+
+	ReadContinueToken_Bound(token, decrypted)
+
+	return true
+}
+
+func WriteContinueTokens_Bound(tokenData []byte, clientAddress *net.UDPAddr, expireTimestamp uint64, sessionId uint64, sessionVersion uint8, numNodes int, secretKeys [][]byte) {
+	clientAddressHash := ClientAddressHash(clientAddress)
+	for i := range numNodes {
+		var token ContinueToken
+		token.ExpireTimestamp = expireTimestamp
+		token.SessionId = sessionId
+		token.SessionVersion = sessionVersion
+		token.ClientAddressHash = clientAddressHash
+		WriteEncryptedContinueToken_Bound(&token, tokenData[i*constants.EncryptedContinueTokenBytes_Bound:], secretKeys[i])
+	}
+}
+
 // -----------------------------------------------------------------------------
 
-func GetBestRouteCost(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32) int32 {
+// MTUFilter excludes routes that cross a relay to relay link with path mtu below the packet size a buyer needs.
+// A nil filter excludes nothing. Links with unknown mtu (0) are never excluded, so relays too old to probe mtu still carry traffic.
+
+type MTUFilter struct {
+	RelayMTU    []uint16 // path mtu per relay pair, same triangular layout as costs
+	RequiredMTU int32
+}
+
+func (filter *MTUFilter) RouteAllowed(routeNumRelays int32, routeRelays []int32) bool {
+	if filter == nil || filter.RequiredMTU <= 0 {
+		return true
+	}
+	for i := 0; i < int(routeNumRelays)-1; i++ {
+		index := TriMatrixIndex(int(routeRelays[i]), int(routeRelays[i+1]))
+		if index >= len(filter.RelayMTU) {
+			continue
+		}
+		mtu := int32(filter.RelayMTU[index])
+		if mtu != 0 && mtu < filter.RequiredMTU {
+			return false
+		}
+	}
+	return true
+}
+
+func GetBestRouteCost(routeMatrix []RouteEntry, mtuFilter *MTUFilter, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32) int32 {
 
 	bestRouteCost := int32(math.MaxInt32)
 
@@ -939,11 +1315,17 @@ func GetBestRouteCost(routeMatrix []RouteEntry, sourceRelays []int32, sourceRela
 
 			entry := &routeMatrix[index]
 
-			if entry.NumRoutes > 0 {
-				cost := sourceRelayCost[i] + entry.RouteCost[0] // first entry is always lowest cost
+			// routes are sorted by cost, so the first allowed route is lowest cost
+
+			for k := 0; k < int(entry.NumRoutes); k++ {
+				if !mtuFilter.RouteAllowed(entry.RouteNumRelays[k], entry.RouteRelays[k][:]) {
+					continue
+				}
+				cost := sourceRelayCost[i] + entry.RouteCost[k]
 				if cost < bestRouteCost {
 					bestRouteCost = cost
 				}
+				break
 			}
 		}
 	}
@@ -989,7 +1371,7 @@ func RouteExists(routeMatrix []RouteEntry, routeNumRelays int32, routeRelays [co
 	return false
 }
 
-func GetCurrentRouteCost(routeMatrix []RouteEntry, routeNumRelays int32, routeRelays [constants.MaxRouteRelays]int32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, debug *string) int32 {
+func GetCurrentRouteCost(routeMatrix []RouteEntry, mtuFilter *MTUFilter, routeNumRelays int32, routeRelays [constants.MaxRouteRelays]int32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, debug *string) int32 {
 
 	// IMPORTANT: This shouldn't happen (callers guard it), but a zero relay count would
 	// index routeRelays[-1] below. NOTE: routeRelays is a fixed size array, so checking
@@ -1052,6 +1434,12 @@ func GetCurrentRouteCost(routeMatrix []RouteEntry, routeNumRelays int32, routeRe
 		if entry.RouteNumRelays[i] != routeNumRelays {
 			continue
 		}
+		if !mtuFilter.RouteAllowed(routeNumRelays, routeRelays[:]) {
+			if debug != nil {
+				*debug += "route crosses a link with mtu below the required packet size\n"
+			}
+			return -1
+		}
 		return sourceCost + entry.RouteCost[i] + constants.CostBias
 	}
 
@@ -1070,7 +1458,7 @@ type BestRoute struct {
 	NeedToReverse bool
 }
 
-func GetBestRoutes(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, bestRoutes []BestRoute, numBestRoutes *int) {
+func GetBestRoutes(routeMatrix []RouteEntry, mtuFilter *MTUFilter, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, bestRoutes []BestRoute, numBestRoutes *int) {
 
 	if len(routeMatrix) == 0 {
 		*numBestRoutes = 0
@@ -1112,6 +1500,10 @@ func GetBestRoutes(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCo
 					break
 				}
 
+				if !mtuFilter.RouteAllowed(entry.RouteNumRelays[k], entry.RouteRelays[k][:]) {
+					continue
+				}
+
 				bestRoutes[numRoutes].Cost = cost
 				bestRoutes[numRoutes].Price = entry.RoutePrice[k]
 				bestRoutes[numRoutes].NumRelays = entry.RouteNumRelays[k]
@@ -1293,13 +1685,13 @@ func ReframeDestRelays(relayIdToIndex map[uint64]int32, destRelayId []uint64, ex
 
 // ----------------------------------------------
 
-func GetRandomBestRoute(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, threshold int32, out_bestRouteCost *int32, out_bestRouteNumRelays *int32, out_bestRouteRelays *[constants.MaxRouteRelays]int32, debug *string) bool {
+func GetRandomBestRoute(routeMatrix []RouteEntry, mtuFilter *MTUFilter, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, threshold int32, out_bestRouteCost *int32, out_bestRouteNumRelays *int32, out_bestRouteRelays *[constants.MaxRouteRelays]int32, debug *string) bool {
 
 	if maxCost == -1 {
 		return false
 	}
 
-	bestRouteCost := GetBestRouteCost(routeMatrix, sourceRelays, sourceRelayCost, destRelays)
+	bestRouteCost := GetBestRouteCost(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays)
 	if debug != nil {
 		*debug += fmt.Sprintf("best route cost is %d\n", bestRouteCost)
 	}
@@ -1314,7 +1706,7 @@ func GetRandomBestRoute(routeMatrix []RouteEntry, sourceRelays []int32, sourceRe
 
 	numBestRoutes := 0
 	bestRoutes := make([]BestRoute, 1024)
-	GetBestRoutes(routeMatrix, sourceRelays, sourceRelayCost, destRelays, bestRouteCost+threshold, bestRoutes, &numBestRoutes)
+	GetBestRoutes(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, bestRouteCost+threshold, bestRoutes, &numBestRoutes)
 	if numBestRoutes == 0 {
 		if debug != nil {
 			*debug += "could not find any next routes\n"
@@ -1349,13 +1741,13 @@ func GetRandomBestRoute(routeMatrix []RouteEntry, sourceRelays []int32, sourceRe
 	return true
 }
 
-func GetRandomBestRoute_LowestPrice(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, threshold int32, out_bestRouteCost *int32, out_bestRouteNumRelays *int32, out_bestRouteRelays *[constants.MaxRouteRelays]int32, debug *string) bool {
+func GetRandomBestRoute_LowestPrice(routeMatrix []RouteEntry, mtuFilter *MTUFilter, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, threshold int32, out_bestRouteCost *int32, out_bestRouteNumRelays *int32, out_bestRouteRelays *[constants.MaxRouteRelays]int32, debug *string) bool {
 
 	if maxCost == -1 {
 		return false
 	}
 
-	bestRouteCost := GetBestRouteCost(routeMatrix, sourceRelays, sourceRelayCost, destRelays)
+	bestRouteCost := GetBestRouteCost(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays)
 	if debug != nil {
 		*debug += fmt.Sprintf("best route cost is %d\n", bestRouteCost)
 	}
@@ -1370,7 +1762,7 @@ func GetRandomBestRoute_LowestPrice(routeMatrix []RouteEntry, sourceRelays []int
 
 	numBestRoutes := 0
 	bestRoutes := make([]BestRoute, 1024)
-	GetBestRoutes(routeMatrix, sourceRelays, sourceRelayCost, destRelays, bestRouteCost+threshold, bestRoutes, &numBestRoutes)
+	GetBestRoutes(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, bestRouteCost+threshold, bestRoutes, &numBestRoutes)
 	if numBestRoutes == 0 {
 		if debug != nil {
 			*debug += "could not find any next routes\n"
@@ -1438,22 +1830,22 @@ func GetRandomBestRoute_LowestPrice(routeMatrix []RouteEntry, sourceRelays []int
 
 // --------------------------------------------------------------------------------------------------------------------
 
-func GetBestRoute_Initial(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, selectThreshold int32, out_bestRouteCost *int32, out_bestRouteNumRelays *int32, out_bestRouteRelays *[constants.MaxRouteRelays]int32, debug *string) bool {
+func GetBestRoute_Initial(routeMatrix []RouteEntry, mtuFilter *MTUFilter, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, selectThreshold int32, out_bestRouteCost *int32, out_bestRouteNumRelays *int32, out_bestRouteRelays *[constants.MaxRouteRelays]int32, debug *string) bool {
 
-	return GetRandomBestRoute_LowestPrice(routeMatrix, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, out_bestRouteCost, out_bestRouteNumRelays, out_bestRouteRelays, debug)
+	return GetRandomBestRoute_LowestPrice(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, out_bestRouteCost, out_bestRouteNumRelays, out_bestRouteRelays, debug)
 }
 
-func GetBestRoute_Update(routeMatrix []RouteEntry, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, selectThreshold int32, switchThreshold int32, currentRouteNumRelays int32, currentRouteRelays [constants.MaxRouteRelays]int32, out_updatedRouteCost *int32, out_updatedRouteNumRelays *int32, out_updatedRouteRelays *[constants.MaxRouteRelays]int32, debug *string) (routeChanged bool, routeLost bool) {
+func GetBestRoute_Update(routeMatrix []RouteEntry, mtuFilter *MTUFilter, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, maxCost int32, selectThreshold int32, switchThreshold int32, currentRouteNumRelays int32, currentRouteRelays [constants.MaxRouteRelays]int32, out_updatedRouteCost *int32, out_updatedRouteNumRelays *int32, out_updatedRouteRelays *[constants.MaxRouteRelays]int32, debug *string) (routeChanged bool, routeLost bool) {
 
 	// if the current route no longer exists, pick a new route
 
-	currentRouteCost := GetCurrentRouteCost(routeMatrix, currentRouteNumRelays, currentRouteRelays, sourceRelays, sourceRelayCost, destRelays, debug)
+	currentRouteCost := GetCurrentRouteCost(routeMatrix, mtuFilter, currentRouteNumRelays, currentRouteRelays, sourceRelays, sourceRelayCost, destRelays, debug)
 
 	if currentRouteCost < 0 {
 		if debug != nil {
 			*debug += "current route no longer exists. picking a new random route\n"
 		}
-		GetRandomBestRoute(routeMatrix, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, out_updatedRouteCost, out_updatedRouteNumRelays, out_updatedRouteRelays, debug)
+		GetRandomBestRoute(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, out_updatedRouteCost, out_updatedRouteNumRelays, out_updatedRouteRelays, debug)
 		routeChanged = true
 		routeLost = true
 		return
@@ -1461,13 +1853,13 @@ func GetBestRoute_Update(routeMatrix []RouteEntry, sourceRelays []int32, sourceR
 
 	// if the current route is no longer within threshold of the best route, pick a new the route
 
-	bestRouteCost := GetBestRouteCost(routeMatrix, sourceRelays, sourceRelayCost, destRelays)
+	bestRouteCost := GetBestRouteCost(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays)
 
 	if int64(currentRouteCost) > int64(bestRouteCost)+int64(switchThreshold) {
 		if debug != nil {
 			*debug += fmt.Sprintf("current route no longer within switch threshold of best route. picking a new random route.\ncurrent route cost = %d, best route cost = %d, route switch threshold = %d\n", currentRouteCost, bestRouteCost, switchThreshold)
 		}
-		GetRandomBestRoute(routeMatrix, sourceRelays, sourceRelayCost, destRelays, bestRouteCost, selectThreshold, out_updatedRouteCost, out_updatedRouteNumRelays, out_updatedRouteRelays, debug)
+		GetRandomBestRoute(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, bestRouteCost, selectThreshold, out_updatedRouteCost, out_updatedRouteNumRelays, out_updatedRouteRelays, debug)
 		routeChanged = true
 		return
 	}
@@ -1481,37 +1873,82 @@ func GetBestRoute_Update(routeMatrix []RouteEntry, sourceRelays []int32, sourceR
 }
 
 type RouteShader struct {
-	DisableNetworkNext        bool    `json:"disable_network_next"`
-	SelectionPercent          int     `json:"selection_percentage"`
-	ABTest                    bool    `json:"ab_test"`
-	AcceptableLatency         int32   `json:"acceptable_latency"`
-	LatencyReductionThreshold int32   `json:"latency_reduction_threshold"`
-	AcceptablePacketLoss      float32 `json:"acceptable_packet_loss"`
-	BandwidthEnvelopeUpKbps   int32   `json:"bandwidth_envelope_up_kbps"`
-	BandwidthEnvelopeDownKbps int32   `json:"bandwidth_envelope_down_kbps"`
-	RouteSelectThreshold      int32   `json:"route_select_threshold"`
-	RouteSwitchThreshold      int32   `json:"route_switch_threshold"`
-	MaxLatencyTradeOff        int32   `json:"max_latency_trade_off"`
-	ForceNext                 bool    `json:"force_next"`
+	DisableNetworkNext               bool    `json:"disable_network_next"`
+	SelectionPercent                 int     `json:"selection_percentage"`
+	ABTest                           bool    `json:"ab_test"`
+	AcceptableLatency                int32   `json:"acceptable_latency"`
+	LatencyReductionThreshold        int32   `json:"latency_reduction_threshold"`
+	AcceptablePacketLoss             float32 `json:"acceptable_packet_loss"`
+	BandwidthEnvelopeUpKbps          int32   `json:"bandwidth_envelope_up_kbps"`
+	BandwidthEnvelopeDownKbps        int32   `json:"bandwidth_envelope_down_kbps"`
+	BandwidthEnvelopeMaxUpKbps       int32   `json:"bandwidth_envelope_max_up_kbps"`
+	BandwidthEnvelopeMaxDownKbps     int32   `json:"bandwidth_envelope_max_down_kbps"`
+	BandwidthEnvelopeHeadroomPercent int32   `json:"bandwidth_envelope_headroom_percent"`
+	RouteSelectThreshold             int32   `json:"route_select_threshold"`
+	RouteSwitchThreshold             int32   `json:"route_switch_threshold"`
+	MaxLatencyTradeOff               int32   `json:"max_latency_trade_off"`
+	ForceNext                        bool    `json:"force_next"`
+	RequiredPacketBytes              int32   `json:"required_packet_bytes"`
+	MatchPolicy                      int32   `json:"match_policy"`
+	MaxMatchLatencyDisparity         int32   `json:"max_match_latency_disparity"`
 }
 
 func NewRouteShader() RouteShader {
 	return RouteShader{
-		DisableNetworkNext:        false,
-		SelectionPercent:          100,
-		ABTest:                    false,
-		AcceptableLatency:         0,
-		LatencyReductionThreshold: 10,
-		AcceptablePacketLoss:      0.1,
-		BandwidthEnvelopeUpKbps:   1024,
-		BandwidthEnvelopeDownKbps: 1024,
-		RouteSelectThreshold:      5,
-		RouteSwitchThreshold:      10,
-		MaxLatencyTradeOff:        20,
-		ForceNext:                 false,
+		DisableNetworkNext:               false,
+		SelectionPercent:                 100,
+		ABTest:                           false,
+		AcceptableLatency:                0,
+		LatencyReductionThreshold:        10,
+		AcceptablePacketLoss:             0.1,
+		BandwidthEnvelopeUpKbps:          1024,
+		BandwidthEnvelopeDownKbps:        1024,
+		BandwidthEnvelopeMaxUpKbps:       0,
+		BandwidthEnvelopeMaxDownKbps:     0,
+		BandwidthEnvelopeHeadroomPercent: 25,
+		RouteSelectThreshold:             5,
+		RouteSwitchThreshold:             10,
+		MaxLatencyTradeOff:               20,
+		ForceNext:                        false,
+		RequiredPacketBytes:              0,
+		MatchPolicy:                      MatchPolicy_None,
+		MaxMatchLatencyDisparity:         50,
 	}
 }
 
+// Bandwidth envelope adaptation. The route shader envelope is the floor. When the route shader sets a ceiling above it, the envelope
+// follows the peak bandwidth the session reports plus headroom, so sessions that burst (eg. voice chat) don't get dropped by relays.
+
+func UpdateBandwidthPeak(peakKbps uint32, bandwidthKbps uint32) uint32 {
+	peakKbps -= (peakKbps + 7) / 8 // decays to half in ~5 slices, and all the way to zero
+	if bandwidthKbps > peakKbps {
+		return bandwidthKbps
+	}
+	return peakKbps
+}
+
+func BandwidthEnvelope(envelopeKbps int32, maxEnvelopeKbps int32, headroomPercent int32, peakKbps uint32) uint32 {
+	if maxEnvelopeKbps <= envelopeKbps {
+		return uint32(envelopeKbps)
+	}
+	if headroomPercent < 0 {
+		headroomPercent = 0
+	}
+	targetKbps := uint64(peakKbps) * uint64(100+headroomPercent) / 100
+	if targetKbps < uint64(envelopeKbps) {
+		return uint32(envelopeKbps)
+	}
+	if targetKbps > uint64(maxEnvelopeKbps) {
+		return uint32(maxEnvelopeKbps)
+	}
+	return uint32(targetKbps)
+}
+
+func BandwidthEnvelopeChanged(currentKbps uint32, targetKbps uint32) bool {
+	// grow right away, but only shrink once the target is well below the current envelope, so we don't re-issue tokens every slice
+	return targetKbps > currentKbps || targetKbps < currentKbps-currentKbps/4
+}
+
 type RouteState struct {
 	Next             bool
 	Veto             bool
@@ -1601,7 +2038,57 @@ func EarlyOutDirect(userId uint64, routeShader *RouteShader, routeState *RouteSt
 	return false
 }
 
-func MakeRouteDecision_TakeNetworkNext(userId uint64, routeMatrix []RouteEntry, routeShader *RouteShader, routeState *RouteState, directLatency int32, directPacketLoss float32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, out_routeCost *int32, out_routeNumRelays *int32, out_routeRelays []int32, debug *string, sliceNumber int32) bool {
+// Match policy decides whether a session may take network next, given the latencies of the other sessions in its match.
+// Competitive titles care about fairness across the match, not only about raw latency.
+
+const (
+	MatchPolicy_None            = 0 // route each session on its own
+	MatchPolicy_CapDisparity    = 1 // don't accelerate a session more than MaxMatchLatencyDisparity below the worst latency in the match
+	MatchPolicy_PrioritizeWorst = 2 // only accelerate sessions within MaxMatchLatencyDisparity of the worst latency in the match
+
+	NumMatchPolicies = 3
+)
+
+func IsValidMatchPolicy(matchPolicy int32) bool {
+	return matchPolicy >= 0 && matchPolicy < NumMatchPolicies
+}
+
+func MatchPolicyAllowsNetworkNext(routeShader *RouteShader, directLatency int32, predictedLatency int32, matchLatencies []int32, debug *string) bool {
+
+	if routeShader.MatchPolicy == MatchPolicy_None || routeShader.ForceNext || len(matchLatencies) == 0 {
+		return true
+	}
+
+	worstLatency := int32(0)
+	for _, latency := range matchLatencies {
+		if latency > worstLatency {
+			worstLatency = latency
+		}
+	}
+
+	switch routeShader.MatchPolicy {
+
+	case MatchPolicy_CapDisparity:
+		if predictedLatency < worstLatency-routeShader.MaxMatchLatencyDisparity {
+			if debug != nil {
+				*debug += fmt.Sprintf("match policy: next latency %dms would be more than %dms below the worst latency in the match %dms\n", predictedLatency, routeShader.MaxMatchLatencyDisparity, worstLatency)
+			}
+			return false
+		}
+
+	case MatchPolicy_PrioritizeWorst:
+		if directLatency < worstLatency-routeShader.MaxMatchLatencyDisparity {
+			if debug != nil {
+				*debug += fmt.Sprintf("match policy: direct latency %dms is more than %dms below the worst latency in the match %dms\n", directLatency, routeShader.MaxMatchLatencyDisparity, worstLatency)
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+func MakeRouteDecision_TakeNetworkNext(userId uint64, routeMatrix []RouteEntry, relayMTU []uint16, routeShader *RouteShader, routeState *RouteState, directLatency int32, directPacketLoss float32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, out_routeCost *int32, out_routeNumRelays *int32, out_routeRelays []int32, debug *string, sliceNumber int32) bool {
 
 	if EarlyOutDirect(userId, routeShader, routeState, debug) {
 		if debug != nil {
@@ -1700,7 +2187,9 @@ func MakeRouteDecision_TakeNetworkNext(userId uint64, routeMatrix []RouteEntry,
 
 	selectThreshold := routeShader.RouteSelectThreshold
 
-	hasRoute := GetBestRoute_Initial(routeMatrix, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, debug)
+	mtuFilter := &MTUFilter{RelayMTU: relayMTU, RequiredMTU: routeShader.RequiredPacketBytes}
+
+	hasRoute := GetBestRoute_Initial(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, debug)
 
 	*out_routeCost = bestRouteCost
 	*out_routeNumRelays = bestRouteNumRelays
@@ -1724,7 +2213,7 @@ func MakeRouteDecision_TakeNetworkNext(userId uint64, routeMatrix []RouteEntry,
 	return true
 }
 
-func MakeRouteDecision_StayOnNetworkNext_Internal(userId uint64, routeMatrix []RouteEntry, relayNames []string, routeShader *RouteShader, routeState *RouteState, directLatency int32, nextLatency int32, predictedLatency int32, directPacketLoss float32, nextPacketLoss float32, currentRouteNumRelays int32, currentRouteRelays [constants.MaxRouteRelays]int32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, out_updatedRouteCost *int32, out_updatedRouteNumRelays *int32, out_updatedRouteRelays []int32, debug *string) (bool, bool) {
+func MakeRouteDecision_StayOnNetworkNext_Internal(userId uint64, routeMatrix []RouteEntry, relayMTU []uint16, relayNames []string, routeShader *RouteShader, routeState *RouteState, directLatency int32, nextLatency int32, predictedLatency int32, directPacketLoss float32, nextPacketLoss float32, currentRouteNumRelays int32, currentRouteRelays [constants.MaxRouteRelays]int32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, out_updatedRouteCost *int32, out_updatedRouteNumRelays *int32, out_updatedRouteRelays []int32, debug *string) (bool, bool) {
 
 	Debug("direct latency = %d", directLatency)
 	Debug("next latency = %d", nextLatency)
@@ -1781,7 +2270,9 @@ func MakeRouteDecision_StayOnNetworkNext_Internal(userId uint64, routeMatrix []R
 	bestRouteNumRelays := int32(0)
 	bestRouteRelays := [constants.MaxRouteRelays]int32{}
 
-	routeSwitched, routeLost := GetBestRoute_Update(routeMatrix, sourceRelays, sourceRelayCost, destRelays, maxCost, routeShader.RouteSelectThreshold, routeShader.RouteSwitchThreshold, currentRouteNumRelays, currentRouteRelays, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, debug)
+	mtuFilter := &MTUFilter{RelayMTU: relayMTU, RequiredMTU: routeShader.RequiredPacketBytes}
+
+	routeSwitched, routeLost := GetBestRoute_Update(routeMatrix, mtuFilter, sourceRelays, sourceRelayCost, destRelays, maxCost, routeShader.RouteSelectThreshold, routeShader.RouteSwitchThreshold, currentRouteNumRelays, currentRouteRelays, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, debug)
 
 	routeState.RouteLost = routeLost
 
@@ -1816,9 +2307,9 @@ func MakeRouteDecision_StayOnNetworkNext_Internal(userId uint64, routeMatrix []R
 	return true, routeSwitched
 }
 
-func MakeRouteDecision_StayOnNetworkNext(userId uint64, routeMatrix []RouteEntry, relayNames []string, routeShader *RouteShader, routeState *RouteState, directLatency int32, nextLatency int32, predictedLatency int32, directPacketLoss float32, nextPacketLoss float32, currentRouteNumRelays int32, currentRouteRelays [constants.MaxRouteRelays]int32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, out_updatedRouteCost *int32, out_updatedRouteNumRelays *int32, out_updatedRouteRelays []int32, debug *string) (bool, bool) {
+func MakeRouteDecision_StayOnNetworkNext(userId uint64, routeMatrix []RouteEntry, relayMTU []uint16, relayNames []string, routeShader *RouteShader, routeState *RouteState, directLatency int32, nextLatency int32, predictedLatency int32, directPacketLoss float32, nextPacketLoss float32, currentRouteNumRelays int32, currentRouteRelays [constants.MaxRouteRelays]int32, sourceRelays []int32, sourceRelayCost []int32, destRelays []int32, out_updatedRouteCost *int32, out_updatedRouteNumRelays *int32, out_updatedRouteRelays []int32, debug *string) (bool, bool) {
 
-	stayOnNetworkNext, nextRouteSwitched := MakeRouteDecision_StayOnNetworkNext_Internal(userId, routeMatrix, relayNames, routeShader, routeState, directLatency, nextLatency, predictedLatency, directPacketLoss, nextPacketLoss, currentRouteNumRelays, currentRouteRelays, sourceRelays, sourceRelayCost, destRelays, out_updatedRouteCost, out_updatedRouteNumRelays, out_updatedRouteRelays, debug)
+	stayOnNetworkNext, nextRouteSwitched := MakeRouteDecision_StayOnNetworkNext_Internal(userId, routeMatrix, relayMTU, relayNames, routeShader, routeState, directLatency, nextLatency, predictedLatency, directPacketLoss, nextPacketLoss, currentRouteNumRelays, currentRouteRelays, sourceRelays, sourceRelayCost, destRelays, out_updatedRouteCost, out_updatedRouteNumRelays, out_updatedRouteRelays, debug)
 
 	if routeState.Next && !stayOnNetworkNext {
 		routeState.Next = false
@@ -1994,10 +2485,28 @@ func AdvancedPacketFilter(data []byte, magic []byte, fromAddress []byte, toAddre
 	return true
 }
 
+// GetAddressData returns the address bytes that packet filters and ping tokens are derived from.
+// IMPORTANT: This must match next_address_data in the SDK and the relay, which only support ipv4 right now.
+
 func GetAddressData(address *net.UDPAddr) []byte {
 	return address.IP.To4()
 }
 
+// GetAddressData_IPv6 is the proposed address data for ipv6 packet filters: 4 bytes for ipv4 (and ipv4-mapped ipv6), 16 bytes for ipv6.
+// Nothing in the SDK or relay reads it yet, so it is only used by the relay corpus vectors. Do not use it on the wire.
+
+func GetAddressData_IPv6(address *net.UDPAddr) []byte {
+	ipv4 := address.IP.To4()
+	if ipv4 != nil {
+		return ipv4
+	}
+	return address.IP.To16()
+}
+
+func IsIPv6Address(address *net.UDPAddr) bool {
+	return address.IP.To4() == nil && address.IP.To16() != nil
+}
+
 func GeneratePingToken(expireTimestamp uint64, from *net.UDPAddr, to *net.UDPAddr, key []byte, output []byte) {
 	data := [32 + 20]byte{}
 	index := 0
@@ -2017,6 +2526,30 @@ func GeneratePingToken(expireTimestamp uint64, from *net.UDPAddr, to *net.UDPAdd
 	copy(output, hash[:])
 }
 
+// GeneratePingToken_IPv6 is the proposed ping token for ipv6 relays. It matches GeneratePingToken for ipv4 addresses.
+// Like GetAddressData_IPv6, it is only used by the relay corpus vectors until relays support ipv6.
+
+func GeneratePingToken_IPv6(expireTimestamp uint64, from *net.UDPAddr, to *net.UDPAddr, key []byte, output []byte) {
+	data := [32 + 8 + 16 + 16 + 2 + 2]byte{}
+	index := 0
+	copy(data[index:], key)
+	index += 32
+	binary.LittleEndian.PutUint64(data[index:], expireTimestamp)
+	index += 8
+	fromAddressData := GetAddressData_IPv6(from)
+	copy(data[index:], fromAddressData)
+	index += len(fromAddressData)
+	toAddressData := GetAddressData_IPv6(to)
+	copy(data[index:], toAddressData)
+	index += len(toAddressData)
+	binary.BigEndian.PutUint16(data[index:], uint16(from.Port))
+	index += 2
+	binary.BigEndian.PutUint16(data[index:], uint16(to.Port))
+	index += 2
+	hash := sha256.Sum256(data[:index])
+	copy(output, hash[:])
+}
+
 // ------------------------------------------------------
 
 func GetSessionScore(directRTT int32, nextRTT int32) uint32 {
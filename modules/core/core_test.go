@@ -2,6 +2,8 @@ package core_test
 
 import (
 	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"math"
@@ -646,7 +648,7 @@ func (env *TestEnvironment) GetBestRouteCost(routeMatrix []core.RouteEntry, sour
 			panic("bad dest relay name")
 		}
 	}
-	return core.GetBestRouteCost(routeMatrix, sourceRelayIndex, sourceRelayCost, destRelayIndex)
+	return core.GetBestRouteCost(routeMatrix, nil, sourceRelayIndex, sourceRelayCost, destRelayIndex)
 }
 
 func (env *TestEnvironment) RouteExists(routeMatrix []core.RouteEntry, routeRelays []string) bool {
@@ -684,7 +686,7 @@ func (env *TestEnvironment) GetCurrentRouteCost(routeMatrix []core.RouteEntry, r
 		}
 	}
 	debug := ""
-	return core.GetCurrentRouteCost(routeMatrix, int32(len(routeRelays)), routeRelayIndex, sourceRelayIndex, sourceRelayCost, destRelayIndex, &debug)
+	return core.GetCurrentRouteCost(routeMatrix, nil, int32(len(routeRelays)), routeRelayIndex, sourceRelayIndex, sourceRelayCost, destRelayIndex, &debug)
 }
 
 func (env *TestEnvironment) GetBestRoutes(routeMatrix []core.RouteEntry, sourceRelays []string, sourceRelayCost []int32, destRelays []string, maxCost int32) []TestRouteData {
@@ -704,7 +706,7 @@ func (env *TestEnvironment) GetBestRoutes(routeMatrix []core.RouteEntry, sourceR
 	}
 	numBestRoutes := 0
 	bestRoutes := make([]core.BestRoute, 1024)
-	core.GetBestRoutes(routeMatrix, sourceRelayIndex, sourceRelayCost, destRelayIndex, maxCost, bestRoutes, &numBestRoutes)
+	core.GetBestRoutes(routeMatrix, nil, sourceRelayIndex, sourceRelayCost, destRelayIndex, maxCost, bestRoutes, &numBestRoutes)
 	routes := make([]TestRouteData, numBestRoutes)
 	for i := 0; i < numBestRoutes; i++ {
 		routes[i].cost = bestRoutes[i].Cost
@@ -744,7 +746,7 @@ func (env *TestEnvironment) GetRandomBestRoute(routeMatrix []core.RouteEntry, so
 	var bestRouteRelays [constants.MaxRouteRelays]int32
 	debug := ""
 	selectThreshold := int32(2)
-	core.GetRandomBestRoute(routeMatrix, sourceRelayIndex, sourceRelayCost, destRelayIndex, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
+	core.GetRandomBestRoute(routeMatrix, nil, sourceRelayIndex, sourceRelayCost, destRelayIndex, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
 	if bestRouteNumRelays == 0 {
 		return nil
 	}
@@ -778,7 +780,7 @@ func (env *TestEnvironment) GetRandomBestRoute_LowestPrice(routeMatrix []core.Ro
 	var bestRouteRelays [constants.MaxRouteRelays]int32
 	debug := ""
 	selectThreshold := int32(2)
-	core.GetRandomBestRoute_LowestPrice(routeMatrix, sourceRelayIndex, sourceRelayCost, destRelayIndex, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
+	core.GetRandomBestRoute_LowestPrice(routeMatrix, nil, sourceRelayIndex, sourceRelayCost, destRelayIndex, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
 	if bestRouteNumRelays == 0 {
 		return nil
 	}
@@ -843,7 +845,7 @@ func (env *TestEnvironment) GetBestRoute_Initial(routeMatrix []core.RouteEntry,
 
 	debug := ""
 	selectThreshold := int32(2)
-	hasRoute := core.GetBestRoute_Initial(routeMatrix, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
+	hasRoute := core.GetBestRoute_Initial(routeMatrix, nil, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
 	if !hasRoute {
 		return 0, []string{}
 	}
@@ -872,7 +874,7 @@ func (env *TestEnvironment) GetBestRoute_Update(routeMatrix []core.RouteEntry, s
 	bestRouteRelays := [constants.MaxRouteRelays]int32{}
 
 	debug := ""
-	core.GetBestRoute_Update(routeMatrix, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, switchThreshold, currentRouteNumRelays, currentRouteRelays, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
+	core.GetBestRoute_Update(routeMatrix, nil, sourceRelays, sourceRelayCost, destRelays, maxCost, selectThreshold, switchThreshold, currentRouteNumRelays, currentRouteRelays, &bestRouteCost, &bestRouteNumRelays, &bestRouteRelays, &debug)
 
 	if bestRouteNumRelays == 0 {
 		return 0, []string{}
@@ -1320,6 +1322,128 @@ func TestRouteToken(t *testing.T) {
 	assert.False(t, result)
 }
 
+func TestRouteToken_IPv6(t *testing.T) {
+
+	t.Parallel()
+
+	routeToken := core.RouteToken{}
+	routeToken.ExpireTimestamp = uint64(time.Now().Unix() + 10)
+	routeToken.SessionId = 0x123131231313131
+	routeToken.SessionVersion = 100
+	routeToken.EnvelopeKbpsUp = 256
+	routeToken.EnvelopeKbpsDown = 512
+	routeToken.NextAddress = core.ParseAddress("[2001:db8::1]:40000")
+	routeToken.PrevAddress = core.ParseAddress("127.0.0.1:50000")
+	routeToken.NextInternal = 1
+	routeToken.PrevInternal = 0
+	core.RandomBytes(routeToken.SessionPrivateKey[:])
+
+	// write an encrypted ipv6 route token and read it back
+
+	buffer := make([]byte, constants.EncryptedRouteTokenBytes_IPv6)
+
+	secretKey := make([]byte, constants.SecretKeyBytes)
+	common.RandomBytes(secretKey)
+
+	assert.True(t, core.WriteEncryptedRouteToken_IPv6(&routeToken, buffer, secretKey))
+
+	readRouteToken := core.RouteToken{}
+	result := core.ReadEncryptedRouteToken_IPv6(&readRouteToken, buffer, secretKey)
+
+	assert.True(t, result)
+	if !result {
+		return
+	}
+
+	assert.Equal(t, routeToken.ExpireTimestamp, readRouteToken.ExpireTimestamp)
+	assert.Equal(t, routeToken.SessionId, readRouteToken.SessionId)
+	assert.Equal(t, routeToken.SessionVersion, readRouteToken.SessionVersion)
+	assert.Equal(t, routeToken.EnvelopeKbpsUp, readRouteToken.EnvelopeKbpsUp)
+	assert.Equal(t, routeToken.EnvelopeKbpsDown, readRouteToken.EnvelopeKbpsDown)
+	assert.Equal(t, "[2001:db8::1]:40000", readRouteToken.NextAddress.String())
+	assert.Equal(t, "127.0.0.1:50000", readRouteToken.PrevAddress.String())
+	assert.Equal(t, routeToken.NextInternal, readRouteToken.NextInternal)
+	assert.Equal(t, routeToken.PrevInternal, readRouteToken.PrevInternal)
+	assert.Equal(t, routeToken.SessionPrivateKey, readRouteToken.SessionPrivateKey)
+
+	// an ipv6 route token is not readable as an ipv4 route token
+
+	result = core.ReadEncryptedRouteToken(&readRouteToken, buffer, secretKey)
+
+	assert.False(t, result)
+}
+
+func TestRouteTokens_IPv6(t *testing.T) {
+
+	t.Parallel()
+
+	publicAddresses := make([]net.UDPAddr, constants.NextMaxNodes)
+	for i := range publicAddresses {
+		publicAddresses[i] = core.ParseAddress(fmt.Sprintf("[2001:db8::%x]:%d", i+1, 40000+i))
+	}
+
+	hasInternalAddresses := make([]bool, constants.NextMaxNodes)
+	internalAddresses := make([]net.UDPAddr, constants.NextMaxNodes)
+	internalGroups := make([]uint64, constants.NextMaxNodes)
+	sellers := make([]int, constants.NextMaxNodes)
+
+	assert.True(t, core.RouteNeedsIPv6(constants.NextMaxNodes, publicAddresses, hasInternalAddresses, internalAddresses))
+
+	sessionId := uint64(0x123131231313131)
+	sessionVersion := byte(100)
+	kbpsUp := uint32(256)
+	kbpsDown := uint32(256)
+	expireTimestamp := uint64(time.Now().Unix() + 10)
+
+	tokenData := make([]byte, constants.NextMaxNodes*constants.EncryptedRouteTokenBytes_IPv6)
+
+	secretKeys := make([][]byte, constants.NextMaxNodes)
+	for i := range secretKeys {
+		secretKeys[i] = make([]byte, constants.SecretKeyBytes)
+		core.RandomBytes(secretKeys[i])
+	}
+
+	core.WriteRouteTokens_IPv6(tokenData, expireTimestamp, sessionId, sessionVersion, kbpsUp, kbpsDown, constants.NextMaxNodes, publicAddresses, hasInternalAddresses, internalAddresses, internalGroups, sellers, secretKeys)
+
+	for i := range constants.NextMaxNodes {
+		var routeToken core.RouteToken
+		result := core.ReadEncryptedRouteToken_IPv6(&routeToken, tokenData[i*constants.EncryptedRouteTokenBytes_IPv6:(i+1)*constants.EncryptedRouteTokenBytes_IPv6], secretKeys[i])
+		assert.True(t, result)
+		if !result {
+			return
+		}
+		assert.Equal(t, sessionId, routeToken.SessionId)
+		assert.Equal(t, sessionVersion, routeToken.SessionVersion)
+		assert.Equal(t, expireTimestamp, routeToken.ExpireTimestamp)
+		if i != 0 {
+			assert.Equal(t, publicAddresses[i-1].String(), routeToken.PrevAddress.String())
+		}
+		if i != constants.NextMaxNodes-1 {
+			assert.Equal(t, publicAddresses[i+1].String(), routeToken.NextAddress.String())
+		}
+	}
+}
+
+func TestRouteNeedsIPv6(t *testing.T) {
+
+	t.Parallel()
+
+	publicAddresses := []net.UDPAddr{core.ParseAddress("10.0.0.1:0"), core.ParseAddress("10.0.0.2:40000"), core.ParseAddress("10.0.0.3:50000")}
+	hasInternalAddresses := []bool{false, true, false}
+	internalAddresses := []net.UDPAddr{{}, core.ParseAddress("10.1.0.2:40000"), {}}
+
+	assert.False(t, core.RouteNeedsIPv6(3, publicAddresses, hasInternalAddresses, internalAddresses))
+
+	internalAddresses[1] = core.ParseAddress("[fd00::2]:40000")
+
+	assert.True(t, core.RouteNeedsIPv6(3, publicAddresses, hasInternalAddresses, internalAddresses))
+
+	internalAddresses[1] = core.ParseAddress("10.1.0.2:40000")
+	publicAddresses[0] = core.ParseAddress("2001:db8::1")
+
+	assert.True(t, core.RouteNeedsIPv6(3, publicAddresses, hasInternalAddresses, internalAddresses))
+}
+
 func TestRouteTokens_PublicAddresses(t *testing.T) {
 
 	t.Parallel()
@@ -1662,6 +1786,137 @@ func TestContinueTokens(t *testing.T) {
 	}
 }
 
+func TestClientAddressHash(t *testing.T) {
+
+	t.Parallel()
+
+	a := core.ParseAddress("203.0.113.10:50000")
+
+	// the port is not part of the hash, so a client keeps its binding across nat port changes
+
+	b := core.ParseAddress("203.0.113.10:50001")
+	assert.Equal(t, core.ClientAddressHash(&a), core.ClientAddressHash(&b))
+
+	c := core.ParseAddress("203.0.113.11:50000")
+	assert.NotEqual(t, core.ClientAddressHash(&a), core.ClientAddressHash(&c))
+
+	// ipv4-mapped addresses hash like ipv4
+
+	d := core.ParseAddress("[::ffff:203.0.113.10]:50000")
+	assert.Equal(t, core.ClientAddressHash(&a), core.ClientAddressHash(&d))
+
+	e := core.ParseAddress("[2001:db8::1]:50000")
+	assert.NotEqual(t, core.ClientAddressHash(&a), core.ClientAddressHash(&e))
+}
+
+func TestTokenBindingAllows(t *testing.T) {
+
+	t.Parallel()
+
+	client := core.ParseAddress("203.0.113.10:50000")
+	attacker := core.ParseAddress("198.51.100.20:50000")
+
+	hash := core.ClientAddressHash(&client)
+
+	assert.True(t, core.TokenBindingAllows(hash, true, &client))
+	assert.False(t, core.TokenBindingAllows(hash, true, &attacker))
+
+	// later hops receive the packet from the previous relay, so they don't check
+
+	assert.True(t, core.TokenBindingAllows(hash, false, &attacker))
+}
+
+func TestRouteTokens_Bound(t *testing.T) {
+
+	t.Parallel()
+
+	publicAddresses := make([]net.UDPAddr, constants.NextMaxNodes)
+	for i := range publicAddresses {
+		publicAddresses[i] = core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", 40000+i))
+	}
+
+	hasInternalAddresses := make([]bool, constants.NextMaxNodes)
+	internalAddresses := make([]net.UDPAddr, constants.NextMaxNodes)
+	internalGroups := make([]uint64, constants.NextMaxNodes)
+	sellers := make([]int, constants.NextMaxNodes)
+
+	clientAddress := core.ParseAddress("203.0.113.10:50000")
+
+	// the client node has the client address with port 0, the same as the session update handler
+
+	publicAddresses[0] = clientAddress
+	publicAddresses[0].Port = 0
+
+	sessionId := uint64(0x123131231313131)
+	sessionVersion := byte(100)
+	kbpsUp := uint32(256)
+	kbpsDown := uint32(256)
+	expireTimestamp := uint64(time.Now().Unix() + 10)
+
+	tokenData := make([]byte, constants.NextMaxNodes*constants.EncryptedRouteTokenBytes_Bound)
+
+	secretKeys := make([][]byte, constants.NextMaxNodes)
+	for i := range secretKeys {
+		secretKeys[i] = make([]byte, constants.SecretKeyBytes)
+		core.RandomBytes(secretKeys[i])
+	}
+
+	core.WriteRouteTokens_Bound(tokenData, &clientAddress, expireTimestamp, sessionId, sessionVersion, kbpsUp, kbpsDown, constants.NextMaxNodes, publicAddresses, hasInternalAddresses, internalAddresses, internalGroups, sellers, secretKeys)
+
+	for i := range constants.NextMaxNodes {
+		token := tokenData[i*constants.EncryptedRouteTokenBytes_Bound : (i+1)*constants.EncryptedRouteTokenBytes_Bound]
+		var routeToken core.RouteToken
+		result := core.ReadEncryptedRouteToken_Bound(&routeToken, token, secretKeys[i])
+		assert.True(t, result)
+		if !result {
+			return
+		}
+		assert.Equal(t, sessionId, routeToken.SessionId)
+		assert.Equal(t, sessionVersion, routeToken.SessionVersion)
+		assert.Equal(t, expireTimestamp, routeToken.ExpireTimestamp)
+		assert.Equal(t, core.ClientAddressHash(&clientAddress), routeToken.ClientAddressHash)
+
+		// only the first relay after the client is the first hop
+
+		assert.Equal(t, i == 1, core.RouteTokenIsFirstHop(&routeToken))
+
+		// a bound route token is not readable as an unbound route token
+
+		assert.False(t, core.ReadEncryptedRouteToken(&routeToken, token, secretKeys[i]))
+	}
+}
+
+func TestContinueTokens_Bound(t *testing.T) {
+
+	t.Parallel()
+
+	clientAddress := core.ParseAddress("203.0.113.10:50000")
+
+	sessionId := uint64(0x123131231313131)
+	sessionVersion := byte(100)
+	expireTimestamp := uint64(time.Now().Unix() + 10)
+
+	tokenData := make([]byte, constants.NextMaxNodes*constants.EncryptedContinueTokenBytes_Bound)
+
+	secretKeys := make([][]byte, constants.NextMaxNodes)
+	for i := range secretKeys {
+		secretKeys[i] = make([]byte, constants.SecretKeyBytes)
+		core.RandomBytes(secretKeys[i])
+	}
+
+	core.WriteContinueTokens_Bound(tokenData, &clientAddress, expireTimestamp, sessionId, sessionVersion, constants.NextMaxNodes, secretKeys)
+
+	for i := range constants.NextMaxNodes {
+		var continueToken core.ContinueToken
+		result := core.ReadEncryptedContinueToken_Bound(&continueToken, tokenData[i*constants.EncryptedContinueTokenBytes_Bound:], secretKeys[i])
+		assert.True(t, result)
+		assert.Equal(t, sessionId, continueToken.SessionId)
+		assert.Equal(t, sessionVersion, continueToken.SessionVersion)
+		assert.Equal(t, expireTimestamp, continueToken.ExpireTimestamp)
+		assert.Equal(t, core.ClientAddressHash(&clientAddress), continueToken.ClientAddressHash)
+	}
+}
+
 func TestBestRouteCostReallySimple(t *testing.T) {
 
 	t.Parallel()
@@ -2899,6 +3154,7 @@ type TestData struct {
 	relayDatacenters []uint64
 	costMatrix       []uint8
 	routeMatrix      []core.RouteEntry
+	relayMTU         []uint16
 
 	directLatency    int32
 	directPacketLoss float32
@@ -2952,6 +3208,7 @@ func NewTestData(env *TestEnvironment) *TestData {
 func (test *TestData) TakeNetworkNext() bool {
 	return core.MakeRouteDecision_TakeNetworkNext(test.userId,
 		test.routeMatrix,
+		test.relayMTU,
 		&test.routeShader,
 		&test.routeState,
 		test.directLatency,
@@ -2970,6 +3227,7 @@ func (test *TestData) TakeNetworkNext() bool {
 func (test *TestData) StayOnNetworkNext() (bool, bool) {
 	return core.MakeRouteDecision_StayOnNetworkNext(test.userId,
 		test.routeMatrix,
+		test.relayMTU,
 		test.relayNames,
 		&test.routeShader,
 		&test.routeState,
@@ -3257,6 +3515,230 @@ func TestTakeNetworkNext_ReduceLatency_NotEnoughReduction(t *testing.T) {
 	assert.Equal(t, expectedRouteState, test.routeState)
 }
 
+func TestTakeNetworkNext_ReduceLatency_AvoidLowMTU(t *testing.T) {
+
+	t.Parallel()
+
+	env := NewTestEnvironment()
+
+	env.AddRelay("losangeles", "10.0.0.1")
+	env.AddRelay("chicago", "10.0.0.2")
+	env.AddRelay("a", "10.0.0.3")
+
+	env.SetCost("losangeles", "chicago", 20)
+	env.SetCost("losangeles", "a", 5)
+	env.SetCost("a", "chicago", 6)
+
+	test := NewTestData(env)
+
+	test.relayMTU = make([]uint16, core.TriMatrixLength(test.numRelays))
+	test.relayMTU[core.TriMatrixIndex(0, 1)] = 1384
+	test.relayMTU[core.TriMatrixIndex(0, 2)] = 1384
+	test.relayMTU[core.TriMatrixIndex(1, 2)] = 1000
+
+	test.directLatency = 50
+
+	test.sourceRelays = []int32{0}
+	test.sourceRelayCosts = []int32{10}
+
+	test.destRelays = []int32{1}
+
+	test.routeShader.RequiredPacketBytes = 1200
+
+	test.sliceNumber = 1
+
+	result := test.TakeNetworkNext()
+
+	assert.True(t, result)
+	assert.Equal(t, int32(2), test.routeNumRelays)
+	assert.Equal(t, []int32{0, 1}, test.routeRelays[:test.routeNumRelays])
+}
+
+func TestTakeNetworkNext_ReduceLatency_AllRoutesLowMTU(t *testing.T) {
+
+	t.Parallel()
+
+	env := NewTestEnvironment()
+
+	env.AddRelay("losangeles", "10.0.0.1")
+	env.AddRelay("chicago", "10.0.0.2")
+
+	env.SetCost("losangeles", "chicago", 10)
+
+	test := NewTestData(env)
+
+	test.relayMTU = []uint16{1000}
+
+	test.directLatency = 50
+
+	test.sourceRelays = []int32{0}
+	test.sourceRelayCosts = []int32{10}
+
+	test.destRelays = []int32{1}
+
+	test.routeShader.RequiredPacketBytes = 1200
+
+	test.sliceNumber = 1
+
+	result := test.TakeNetworkNext()
+
+	assert.False(t, result)
+
+	expectedRouteState := core.RouteState{}
+
+	assert.Equal(t, expectedRouteState, test.routeState)
+}
+
+// -----------------------------------------------------------------------------
+
+func TestMatchPolicy_None(t *testing.T) {
+
+	t.Parallel()
+
+	routeShader := core.NewRouteShader()
+
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 20, 10, []int32{200, 300}, nil))
+}
+
+func TestMatchPolicy_NoOtherSessions(t *testing.T) {
+
+	t.Parallel()
+
+	routeShader := core.NewRouteShader()
+	routeShader.MatchPolicy = core.MatchPolicy_CapDisparity
+
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 200, 10, []int32{}, nil))
+}
+
+func TestMatchPolicy_CapDisparity(t *testing.T) {
+
+	t.Parallel()
+
+	routeShader := core.NewRouteShader()
+	routeShader.MatchPolicy = core.MatchPolicy_CapDisparity
+	routeShader.MaxMatchLatencyDisparity = 50
+
+	debug := ""
+
+	// next route would bring this session more than 50ms below the worst session in the match
+
+	assert.False(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 150, 40, []int32{60, 100}, &debug))
+	assert.NotEqual(t, "", debug)
+
+	// next route keeps this session within 50ms of the worst session
+
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 150, 50, []int32{60, 100}, nil))
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 150, 120, []int32{60, 100}, nil))
+}
+
+func TestMatchPolicy_PrioritizeWorst(t *testing.T) {
+
+	t.Parallel()
+
+	routeShader := core.NewRouteShader()
+	routeShader.MatchPolicy = core.MatchPolicy_PrioritizeWorst
+	routeShader.MaxMatchLatencyDisparity = 50
+
+	debug := ""
+
+	// this session is already well ahead of the worst session in the match
+
+	assert.False(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 40, 30, []int32{60, 100}, &debug))
+	assert.NotEqual(t, "", debug)
+
+	// this session is close to (or is) the worst session in the match
+
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 60, 30, []int32{60, 100}, nil))
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 150, 30, []int32{60, 100}, nil))
+}
+
+func TestMatchPolicy_ForceNext(t *testing.T) {
+
+	t.Parallel()
+
+	routeShader := core.NewRouteShader()
+	routeShader.MatchPolicy = core.MatchPolicy_PrioritizeWorst
+	routeShader.ForceNext = true
+
+	assert.True(t, core.MatchPolicyAllowsNetworkNext(&routeShader, 10, 5, []int32{200}, nil))
+}
+
+func TestMatchPolicy_Valid(t *testing.T) {
+
+	t.Parallel()
+
+	assert.True(t, core.IsValidMatchPolicy(core.MatchPolicy_None))
+	assert.True(t, core.IsValidMatchPolicy(core.MatchPolicy_CapDisparity))
+	assert.True(t, core.IsValidMatchPolicy(core.MatchPolicy_PrioritizeWorst))
+	assert.False(t, core.IsValidMatchPolicy(-1))
+	assert.False(t, core.IsValidMatchPolicy(core.NumMatchPolicies))
+}
+
+// -----------------------------------------------------------------------------
+
+func TestBandwidthPeak(t *testing.T) {
+
+	t.Parallel()
+
+	// peak jumps up right away
+
+	assert.Equal(t, uint32(800), core.UpdateBandwidthPeak(100, 800))
+
+	// and decays slowly
+
+	peak := uint32(800)
+	for range 5 {
+		peak = core.UpdateBandwidthPeak(peak, 0)
+	}
+	assert.True(t, peak > 350 && peak < 450)
+
+	for range 100 {
+		peak = core.UpdateBandwidthPeak(peak, 0)
+	}
+	assert.Equal(t, uint32(0), peak)
+}
+
+func TestBandwidthEnvelope(t *testing.T) {
+
+	t.Parallel()
+
+	// no ceiling above the route shader envelope means no adaptation
+
+	assert.Equal(t, uint32(256), core.BandwidthEnvelope(256, 0, 25, 1000))
+	assert.Equal(t, uint32(256), core.BandwidthEnvelope(256, 256, 25, 1000))
+
+	// the route shader envelope is the floor
+
+	assert.Equal(t, uint32(256), core.BandwidthEnvelope(256, 2048, 25, 100))
+
+	// the envelope follows the peak plus headroom
+
+	assert.Equal(t, uint32(500), core.BandwidthEnvelope(256, 2048, 25, 400))
+	assert.Equal(t, uint32(400), core.BandwidthEnvelope(256, 2048, 0, 400))
+	assert.Equal(t, uint32(400), core.BandwidthEnvelope(256, 2048, -10, 400))
+
+	// up to the ceiling
+
+	assert.Equal(t, uint32(2048), core.BandwidthEnvelope(256, 2048, 25, 1800))
+	assert.Equal(t, uint32(2048), core.BandwidthEnvelope(256, 2048, 25, 0xFFFFFFFF))
+}
+
+func TestBandwidthEnvelopeChanged(t *testing.T) {
+
+	t.Parallel()
+
+	assert.False(t, core.BandwidthEnvelopeChanged(1000, 1000))
+
+	// grow right away
+
+	assert.True(t, core.BandwidthEnvelopeChanged(1000, 1001))
+
+	// shrink only when well below
+
+	assert.False(t, core.BandwidthEnvelopeChanged(1000, 800))
+	assert.True(t, core.BandwidthEnvelopeChanged(1000, 700))
+}
+
 // -----------------------------------------------------------------------------
 
 func TestTakeNetworkNext_ReducePacketLoss_Simple(t *testing.T) {
@@ -3746,6 +4228,7 @@ func TestStayOnNetworkNext_ReduceLatency_SwitchToNewRoute(t *testing.T) {
 
 	assert.Equal(t, expectedRouteState, test.routeState)
 	assert.Equal(t, int32(12+constants.CostBias), test.routeCost)
+	t.Log(test.debug)
 	assert.Equal(t, int32(3), test.routeNumRelays)
 }
 
@@ -3799,6 +4282,7 @@ func TestStayOnNetworkNext_ReduceLatency_SwitchToBetterRoute(t *testing.T) {
 
 	assert.Equal(t, expectedRouteState, test.routeState)
 	assert.Equal(t, int32(12+constants.CostBias), test.routeCost)
+	t.Log(test.debug)
 	assert.Equal(t, int32(3), test.routeNumRelays)
 }
 
@@ -4152,6 +4636,7 @@ func TestStayOnNetworkNext_ForceNext_RouteSwitched(t *testing.T) {
 
 	assert.Equal(t, expectedRouteState, test.routeState)
 	assert.Equal(t, int32(3+constants.CostBias), test.routeCost)
+	t.Log(test.debug)
 	assert.Equal(t, int32(3), test.routeNumRelays)
 }
 
@@ -4209,6 +4694,94 @@ func TestPittleAndChonkle(t *testing.T) {
 	}
 }
 
+func TestPittleAndChonkle_IPv6(t *testing.T) {
+	rand.Seed(42)
+	var output [constants.MaxPacketBytes]byte
+	output[0] = 0x32
+	iterations := 10000
+	for i := range iterations {
+		var magic [8]byte
+		var fromAddress [16]byte
+		var toAddress [16]byte
+		randomBytes(magic[:])
+		randomBytes(fromAddress[:])
+		randomBytes(toAddress[:])
+		packetLength := 18 + (i % (len(output) - 18))
+		core.GeneratePittle(output[1:3], fromAddress[:], toAddress[:], packetLength)
+		core.GenerateChonkle(output[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+		assert.Equal(t, true, core.BasicPacketFilter(output[:], packetLength))
+		assert.Equal(t, true, core.AdvancedPacketFilter(output[:], magic[:], fromAddress[:], toAddress[:], packetLength))
+	}
+}
+
+func TestGetAddressData(t *testing.T) {
+
+	t.Parallel()
+
+	ipv4 := core.ParseAddress("10.0.0.1:40000")
+	assert.Equal(t, []byte{10, 0, 0, 1}, []byte(core.GetAddressData(&ipv4)))
+	assert.Equal(t, []byte{10, 0, 0, 1}, []byte(core.GetAddressData_IPv6(&ipv4)))
+	assert.False(t, core.IsIPv6Address(&ipv4))
+
+	mapped := core.ParseAddress("[::ffff:10.0.0.1]:40000")
+	assert.Equal(t, []byte{10, 0, 0, 1}, []byte(core.GetAddressData(&mapped)))
+	assert.Equal(t, []byte{10, 0, 0, 1}, []byte(core.GetAddressData_IPv6(&mapped)))
+	assert.False(t, core.IsIPv6Address(&mapped))
+
+	// the sdk and relay only support ipv4 address data, so ipv6 addresses have none until they do
+
+	ipv6 := core.ParseAddress("[2001:db8::1]:40000")
+	assert.Equal(t, 0, len(core.GetAddressData(&ipv6)))
+	assert.Equal(t, 16, len(core.GetAddressData_IPv6(&ipv6)))
+	assert.True(t, core.IsIPv6Address(&ipv6))
+}
+
+func TestPingToken(t *testing.T) {
+
+	t.Parallel()
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	expireTimestamp := uint64(1700000000)
+
+	// ipv4 ping tokens must not change, relays already verify them
+
+	from := core.ParseAddress("10.0.0.1:40000")
+	to := core.ParseAddress("10.0.0.2:50000")
+
+	data := make([]byte, 0, 32+8+4+4+2+2)
+	data = append(data, key...)
+	data = binary.LittleEndian.AppendUint64(data, expireTimestamp)
+	data = append(data, 10, 0, 0, 1)
+	data = append(data, 10, 0, 0, 2)
+	data = binary.BigEndian.AppendUint16(data, 40000)
+	data = binary.BigEndian.AppendUint16(data, 50000)
+	expected := sha256.Sum256(data)
+
+	var pingToken [constants.PingTokenBytes]byte
+	core.GeneratePingToken(expireTimestamp, &from, &to, key, pingToken[:])
+	assert.Equal(t, expected[:], pingToken[:])
+
+	// the proposed ipv6 ping token matches for ipv4, and covers the full 16 byte addresses for ipv6
+
+	var pingTokenIPv6 [constants.PingTokenBytes]byte
+	core.GeneratePingToken_IPv6(expireTimestamp, &from, &to, key, pingTokenIPv6[:])
+	assert.Equal(t, pingToken, pingTokenIPv6)
+
+	from6 := core.ParseAddress("[2001:db8::1]:40000")
+	to6 := core.ParseAddress("[2001:db8::2]:50000")
+	from6b := core.ParseAddress("[2001:db8:1::1]:40000")
+
+	var pingToken6 [constants.PingTokenBytes]byte
+	var pingToken6b [constants.PingTokenBytes]byte
+	core.GeneratePingToken_IPv6(expireTimestamp, &from6, &to6, key, pingToken6[:])
+	core.GeneratePingToken_IPv6(expireTimestamp, &from6b, &to6, key, pingToken6b[:])
+	assert.NotEqual(t, pingToken6, pingToken6b)
+}
+
 func TestBasicPacketFilter(t *testing.T) {
 	rand.Seed(42)
 	var output [256]byte
@@ -7,6 +7,8 @@ import "C"
 import (
 	"crypto/ed25519"
 	crypto_rand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 
 	"golang.org/x/crypto/chacha20poly1305"
@@ -30,6 +32,11 @@ const (
 	SecretKey_PublicKeySize  = 32
 	SecretKey_PrivateKeySize = 32
 	SecretKey_KeySize        = 32
+
+	Secrets_MasterKeySize = chacha20poly1305.KeySize
+	Secrets_NonceSize     = chacha20poly1305.NonceSizeX
+	Secrets_MacSize       = poly1305.TagSize
+	Secrets_Version       = 1
 )
 
 // ----------------------------------------------------
@@ -85,6 +92,107 @@ func Verify(data []byte, publicKey []byte, signature []byte) bool {
 	return ed25519.Verify(ed25519.PublicKey(publicKey), data, signature)
 }
 
+func Sign_KeyId(publicKey []byte) uint32 {
+	hash := sha256.Sum256(publicKey)
+	return binary.LittleEndian.Uint32(hash[:4])
+}
+
+// Sign_Keyring holds the keys for data we sign, hand out, and verify when it comes back later, eg. session data.
+// The first key signs. Every key verifies, so keys can be rotated without invalidating data signed with older keys.
+
+type Sign_Keyring struct {
+	KeyIds      []uint32
+	PublicKeys  [][]byte
+	PrivateKeys [][]byte
+}
+
+func Sign_CreateKeyring(privateKeys [][]byte) (*Sign_Keyring, error) {
+	if len(privateKeys) == 0 {
+		return nil, fmt.Errorf("keyring needs at least one key")
+	}
+	keyring := &Sign_Keyring{}
+	for i := range privateKeys {
+		if len(privateKeys[i]) != Sign_PrivateKeySize {
+			return nil, fmt.Errorf("key %d is %d bytes, expected %d", i, len(privateKeys[i]), Sign_PrivateKeySize)
+		}
+		publicKey := []byte(ed25519.PrivateKey(privateKeys[i]).Public().(ed25519.PublicKey))
+		keyId := Sign_KeyId(publicKey)
+		for j := range keyring.KeyIds {
+			if keyring.KeyIds[j] == keyId {
+				return nil, fmt.Errorf("key %d is a duplicate of key %d", i, j)
+			}
+		}
+		keyring.KeyIds = append(keyring.KeyIds, keyId)
+		keyring.PublicKeys = append(keyring.PublicKeys, publicKey)
+		keyring.PrivateKeys = append(keyring.PrivateKeys, privateKeys[i])
+	}
+	return keyring, nil
+}
+
+func (keyring *Sign_Keyring) Sign(data []byte) (uint32, []byte) {
+	return keyring.KeyIds[0], Sign(data, keyring.PrivateKeys[0])
+}
+
+// Verify checks the signature against the key with the given key id. Key id zero means the data predates key ids, so try every key
+
+func (keyring *Sign_Keyring) Verify(keyId uint32, data []byte, signature []byte) bool {
+	for i := range keyring.KeyIds {
+		if (keyId == 0 || keyring.KeyIds[i] == keyId) && Verify(data, keyring.PublicKeys[i], signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func (keyring *Sign_Keyring) HasKey(keyId uint32) bool {
+	for i := range keyring.KeyIds {
+		if keyring.KeyIds[i] == keyId {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------
+
+// The secrets envelope seals sensitive values that are stored at rest (database binary, env files) under a master key.
+// The envelope is [version][nonce][ciphertext + mac], so the format can change without breaking existing secrets.
+
+func Secrets_MasterKey() []byte {
+	key := make([]byte, Secrets_MasterKeySize)
+	crypto_rand.Read(key)
+	return key
+}
+
+func Secrets_Encrypt(masterKey []byte, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets master key: %v", err)
+	}
+	envelope := make([]byte, 1+Secrets_NonceSize, 1+Secrets_NonceSize+len(plaintext)+Secrets_MacSize)
+	envelope[0] = Secrets_Version
+	crypto_rand.Read(envelope[1:])
+	return aead.Seal(envelope, envelope[1:], plaintext, envelope[:1]), nil
+}
+
+func Secrets_Decrypt(masterKey []byte, envelope []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets master key: %v", err)
+	}
+	if len(envelope) < 1+Secrets_NonceSize+Secrets_MacSize {
+		return nil, fmt.Errorf("secrets envelope is too short")
+	}
+	if envelope[0] != Secrets_Version {
+		return nil, fmt.Errorf("unknown secrets envelope version %d", envelope[0])
+	}
+	plaintext, err := aead.Open(nil, envelope[1:1+Secrets_NonceSize], envelope[1+Secrets_NonceSize:], envelope[:1])
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secrets envelope")
+	}
+	return plaintext, nil
+}
+
 // ----------------------------------------------------
 
 func Auth_Key() []byte {
@@ -21,6 +21,60 @@ func Test_Sign(t *testing.T) {
 	assert.True(t, crypto.Verify(data, publicKey, signature))
 }
 
+func Test_SignKeyring(t *testing.T) {
+
+	_, oldPrivateKey := crypto.Sign_KeyPair()
+	newPublicKey, newPrivateKey := crypto.Sign_KeyPair()
+
+	data := make([]byte, 256)
+	common.RandomBytes(data)
+
+	// data signed before the rotation
+
+	oldKeyring, err := crypto.Sign_CreateKeyring([][]byte{oldPrivateKey})
+	assert.Nil(t, err)
+
+	oldKeyId, oldSignature := oldKeyring.Sign(data)
+
+	// the new key signs, the old key still verifies
+
+	keyring, err := crypto.Sign_CreateKeyring([][]byte{newPrivateKey, oldPrivateKey})
+	assert.Nil(t, err)
+
+	keyId, signature := keyring.Sign(data)
+	assert.Equal(t, crypto.Sign_KeyId(newPublicKey), keyId)
+	assert.True(t, crypto.Verify(data, newPublicKey, signature))
+
+	assert.True(t, keyring.HasKey(oldKeyId))
+	assert.True(t, keyring.Verify(oldKeyId, data, oldSignature))
+	assert.True(t, keyring.Verify(keyId, data, signature))
+	assert.True(t, keyring.Verify(0, data, oldSignature))
+
+	// signature must match the key id
+
+	assert.False(t, keyring.Verify(keyId, data, oldSignature))
+
+	// once the old key is retired, data signed with it is no longer valid
+
+	newKeyring, err := crypto.Sign_CreateKeyring([][]byte{newPrivateKey})
+	assert.Nil(t, err)
+
+	assert.False(t, newKeyring.HasKey(oldKeyId))
+	assert.False(t, newKeyring.Verify(oldKeyId, data, oldSignature))
+	assert.False(t, newKeyring.Verify(0, data, oldSignature))
+
+	// bad keyrings
+
+	_, err = crypto.Sign_CreateKeyring([][]byte{})
+	assert.NotNil(t, err)
+
+	_, err = crypto.Sign_CreateKeyring([][]byte{newPrivateKey[:32]})
+	assert.NotNil(t, err)
+
+	_, err = crypto.Sign_CreateKeyring([][]byte{newPrivateKey, newPrivateKey})
+	assert.NotNil(t, err)
+}
+
 func Test_Auth(t *testing.T) {
 
 	senderPublicKey, senderPrivateKey := crypto.Box_KeyPair()
@@ -100,3 +154,38 @@ func Test_SecretKey(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, localSecretKey, remoteSecretKey)
 }
+
+func Test_Secrets(t *testing.T) {
+
+	masterKey := crypto.Secrets_MasterKey()
+
+	plaintext := []byte("relay private key")
+
+	envelope, err := crypto.Secrets_Encrypt(masterKey, plaintext)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(envelope), string(plaintext))
+
+	decrypted, err := crypto.Secrets_Decrypt(masterKey, envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// the wrong master key fails
+
+	_, err = crypto.Secrets_Decrypt(crypto.Secrets_MasterKey(), envelope)
+	assert.Error(t, err)
+
+	// any modification fails
+
+	for i := range envelope {
+		modified := make([]byte, len(envelope))
+		copy(modified, envelope)
+		modified[i] ^= 1
+		_, err = crypto.Secrets_Decrypt(masterKey, modified)
+		assert.Error(t, err)
+	}
+
+	// invalid master key fails
+
+	_, err = crypto.Secrets_Encrypt([]byte{1, 2, 3}, plaintext)
+	assert.Error(t, err)
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/networknext/next/modules/constants"
 	"github.com/networknext/next/modules/core"
 	"github.com/networknext/next/modules/crypto"
+	"github.com/networknext/next/modules/envvar"
 
 	_ "github.com/lib/pq"
 	"github.com/modood/table"
@@ -60,6 +61,34 @@ type Buyer struct {
 	Debug       bool             `json:"debug"`
 	PublicKey   []byte           `json:"public_key"`
 	RouteShader core.RouteShader `json:"route_shader"`
+
+	// zero means the default limit
+	RateLimitPacketsPerSecond        int32 `json:"rate_limit_packets_per_second"`
+	RateLimitAddressPacketsPerSecond int32 `json:"rate_limit_address_packets_per_second"`
+
+	// sdk versions as "major.minor.patch". servers and sessions below the minimum are rejected, servers below the recommended
+	// version are told to upgrade. empty means no per-buyer minimum or recommendation
+	MinSDKVersion         string `json:"min_sdk_version"`
+	RecommendedSDKVersion string `json:"recommended_sdk_version"`
+
+	// keys accepted for the buyer during key rotation. the primary public key above is always accepted, unless it is listed here too
+	RotationKeys []BuyerKey `json:"rotation_keys"`
+}
+
+type BuyerKey struct {
+	PublicKey []byte `json:"public_key"`
+	NotBefore int64  `json:"not_before"` // unix seconds. zero means no limit
+	NotAfter  int64  `json:"not_after"`  // unix seconds. zero means no limit
+}
+
+func (key *BuyerKey) ValidAt(currentTime int64) bool {
+	if key.NotBefore != 0 && currentTime < key.NotBefore {
+		return false
+	}
+	if key.NotAfter != 0 && currentTime >= key.NotAfter {
+		return false
+	}
+	return true
 }
 
 type Seller struct {
@@ -98,6 +127,16 @@ type Database struct {
 	SellerCodeMap           map[string]*Seller
 	DatacenterNameMap       map[string]*Datacenter
 	RelaySecretKeys         map[uint64][]byte
+	Secrets                 []byte `json:"-"` // DatabaseSecrets encrypted with the secrets master key. empty when secrets are stored in plaintext
+}
+
+// Relay private keys and relay secret keys are sealed with the secrets master key when the database binary is written,
+// and opened again when it is loaded. Services without the master key load the database without them.
+// The master key is read from the environment each time, not at init, so it can be set after the package loads.
+
+type DatabaseSecrets struct {
+	RelayPrivateKeys map[uint64][]byte
+	RelaySecretKeys  map[uint64][]byte
 }
 
 func CreateDatabase() *Database {
@@ -192,31 +231,86 @@ func (database *Database) GenerateRelaySecretKeys(relayBackendPublicKey []byte,
 
 func (database *Database) Save(filename string) error {
 
-	var buffer bytes.Buffer
-
-	err := gob.NewEncoder(&buffer).Encode(database)
+	data, err := database.GetBinaryWithMasterKey(envvar.GetSecretsMasterKey())
 	if err != nil {
 		return err
 	}
 
-	var compressed_buffer bytes.Buffer
-	gz, err := gzip.NewWriterLevel(&compressed_buffer, gzip.BestCompression)
-	if err != nil {
+	if err := os.WriteFile(filename, data, 0644); err != nil {
 		return err
 	}
 
-	if _, err := gz.Write(buffer.Bytes()); err != nil {
-		return err
+	return nil
+}
+
+// Redacted returns a copy of the database without secrets, for anything that is shown rather than loaded.
+
+func (database *Database) Redacted() *Database {
+	redacted := *database
+	redacted.Relays = make([]Relay, len(database.Relays))
+	copy(redacted.Relays, database.Relays)
+	redacted.RelayMap = make(map[uint64]*Relay, len(redacted.Relays))
+	redacted.RelayNameMap = make(map[string]*Relay, len(redacted.Relays))
+	for i := range redacted.Relays {
+		redacted.Relays[i].PrivateKey = nil
+		redacted.RelayMap[redacted.Relays[i].Id] = &redacted.Relays[i]
+		redacted.RelayNameMap[redacted.Relays[i].Name] = &redacted.Relays[i]
+	}
+	redacted.RelaySecretKeys = make(map[uint64][]byte)
+	redacted.Secrets = nil
+	return &redacted
+}
+
+func (database *Database) sealSecrets(masterKey []byte) (*Database, error) {
+
+	secrets := DatabaseSecrets{
+		RelayPrivateKeys: make(map[uint64][]byte, len(database.Relays)),
+		RelaySecretKeys:  database.RelaySecretKeys,
 	}
 
-	if err := gz.Close(); err != nil {
-		return err
+	for i := range database.Relays {
+		secrets.RelayPrivateKeys[database.Relays[i].Id] = database.Relays[i].PrivateKey
 	}
 
-	if err := os.WriteFile(filename, compressed_buffer.Bytes(), 0644); err != nil {
-		return err
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(&secrets); err != nil {
+		return nil, err
 	}
 
+	sealed := database.Redacted()
+
+	var err error
+	sealed.Secrets, err = crypto.Secrets_Encrypt(masterKey, buffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+func (database *Database) openSecrets(masterKey []byte) error {
+
+	data, err := crypto.Secrets_Decrypt(masterKey, database.Secrets)
+	if err != nil {
+		return fmt.Errorf("could not decrypt database secrets: %v", err)
+	}
+
+	secrets := DatabaseSecrets{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&secrets); err != nil {
+		return fmt.Errorf("could not decode database secrets: %v", err)
+	}
+
+	for i := range database.Relays {
+		database.Relays[i].PrivateKey = secrets.RelayPrivateKeys[database.Relays[i].Id]
+	}
+
+	// the relay maps decode as separate copies of each relay. clear them so fixup rebuilds them with the private keys
+	database.RelayMap = nil
+	database.RelayNameMap = nil
+
+	database.RelaySecretKeys = secrets.RelaySecretKeys
+	database.Secrets = nil
+
 	return nil
 }
 
@@ -300,22 +394,22 @@ func (database *Database) Validate() error {
 		if !datacenterExists {
 			return fmt.Errorf("relay %s datacenter does not exist", relay.Name)
 		}
-		if relay.PublicAddress.IP.String() == "0.0.0.0" {
-			return fmt.Errorf("relay %s public address is 0.0.0.0", relay.Name)
+		if relay.PublicAddress.IP.IsUnspecified() {
+			return fmt.Errorf("relay %s public address is unspecified", relay.Name)
 		}
 		if relay.PublicAddress.Port == 0 {
 			return fmt.Errorf("relay %s public address port is zero", relay.Name)
 		}
 		if relay.HasInternalAddress {
-			if relay.InternalAddress.IP.String() == "0.0.0.0" {
-				return fmt.Errorf("relay %s internal address is 0.0.0.0", relay.Name)
+			if relay.InternalAddress.IP.IsUnspecified() {
+				return fmt.Errorf("relay %s internal address is unspecified", relay.Name)
 			}
 			if relay.InternalAddress.Port == 0 {
 				return fmt.Errorf("relay %s internal address port is zero", relay.Name)
 			}
 		}
-		if relay.SSHAddress.IP.String() == "0.0.0.0" {
-			return fmt.Errorf("relay %s ssh address is 0.0.0.0", relay.Name)
+		if relay.SSHAddress.IP.IsUnspecified() {
+			return fmt.Errorf("relay %s ssh address is unspecified", relay.Name)
 		}
 		if relay.SSHAddress.Port == 0 {
 			return fmt.Errorf("relay %s ssh address port is zero: '%s'", relay.Name, relay.SSHAddress.String())
@@ -469,8 +563,12 @@ func (database *Database) GetDatacenterRelays(datacenterId uint64) []uint64 {
 	return database.DatacenterRelays[datacenterId]
 }
 
+// IMPORTANT: String and WriteHTML show a redacted copy of the database, so they never print relay private keys
+
 func (database *Database) String() string {
 
+	database = database.Redacted()
+
 	var output strings.Builder
 	output.WriteString("Headers:\n\n")
 
@@ -593,7 +691,6 @@ func (database *Database) String() string {
 		BandwidthPrice  string
 		Version         string
 		PublicKey       string
-		PrivateKey      string
 	}
 
 	relays := []RelayRow{}
@@ -607,7 +704,6 @@ func (database *Database) String() string {
 			PublicAddress:  v.PublicAddress.String(),
 			BandwidthPrice: fmt.Sprintf("%d", v.BandwidthPrice),
 			PublicKey:      base64.StdEncoding.EncodeToString(v.PublicKey),
-			PrivateKey:     base64.StdEncoding.EncodeToString(v.PrivateKey),
 		}
 
 		if v.HasInternalAddress {
@@ -648,9 +744,15 @@ func (database *Database) String() string {
 		properties = append(properties, PropertyRow{"Acceptable Packet Loss", fmt.Sprintf("%.1f%%", routeShader.AcceptablePacketLoss)})
 		properties = append(properties, PropertyRow{"Bandwidth Envelope Up", fmt.Sprintf("%dkbps", routeShader.BandwidthEnvelopeUpKbps)})
 		properties = append(properties, PropertyRow{"Bandwidth Envelope Down", fmt.Sprintf("%dkbps", routeShader.BandwidthEnvelopeDownKbps)})
+		properties = append(properties, PropertyRow{"Bandwidth Envelope Max Up", fmt.Sprintf("%dkbps", routeShader.BandwidthEnvelopeMaxUpKbps)})
+		properties = append(properties, PropertyRow{"Bandwidth Envelope Max Down", fmt.Sprintf("%dkbps", routeShader.BandwidthEnvelopeMaxDownKbps)})
+		properties = append(properties, PropertyRow{"Bandwidth Envelope Headroom", fmt.Sprintf("%d%%", routeShader.BandwidthEnvelopeHeadroomPercent)})
 		properties = append(properties, PropertyRow{"Route Select Threshold", fmt.Sprintf("%dms", routeShader.RouteSelectThreshold)})
 		properties = append(properties, PropertyRow{"Route Switch Threshold", fmt.Sprintf("%dms", routeShader.RouteSwitchThreshold)})
 		properties = append(properties, PropertyRow{"Max Latency Trade Off", fmt.Sprintf("%dms", routeShader.MaxLatencyTradeOff)})
+		properties = append(properties, PropertyRow{"Required Packet Bytes", fmt.Sprintf("%d", routeShader.RequiredPacketBytes)})
+		properties = append(properties, PropertyRow{"Match Policy", fmt.Sprintf("%d", routeShader.MatchPolicy)})
+		properties = append(properties, PropertyRow{"Max Match Latency Disparity", fmt.Sprintf("%dms", routeShader.MaxMatchLatencyDisparity)})
 
 		output.WriteString(table.Table(properties))
 	}
@@ -703,6 +805,8 @@ func (database *Database) String() string {
 
 func (database *Database) WriteHTML(w io.Writer) {
 
+	database = database.Redacted()
+
 	const htmlHeader = `<!DOCTYPE html>
 	<html lang="en">
 	<head>
@@ -842,7 +946,6 @@ func (database *Database) WriteHTML(w io.Writer) {
 		InternalAddress string
 		InternalGroup   string
 		PublicKey       string
-		PrivateKey      string
 		Price           string
 	}
 
@@ -855,7 +958,6 @@ func (database *Database) WriteHTML(w io.Writer) {
 			Name:          v.Name,
 			PublicAddress: v.PublicAddress.String(),
 			PublicKey:     base64.StdEncoding.EncodeToString(v.PublicKey),
-			PrivateKey:    base64.StdEncoding.EncodeToString(v.PrivateKey),
 			Price:         fmt.Sprintf("%d", v.BandwidthPrice),
 		}
 
@@ -897,9 +999,15 @@ func (database *Database) WriteHTML(w io.Writer) {
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%.1f%%</td>\n", "Acceptable Packet Loss", routeShader.AcceptablePacketLoss)
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%dkbps</td>\n", "Bandwidth Envelope Up", routeShader.BandwidthEnvelopeUpKbps)
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%dkbps</td>\n", "Bandwidth Envelope Down", routeShader.BandwidthEnvelopeDownKbps)
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%dkbps</td>\n", "Bandwidth Envelope Max Up", routeShader.BandwidthEnvelopeMaxUpKbps)
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%dkbps</td>\n", "Bandwidth Envelope Max Down", routeShader.BandwidthEnvelopeMaxDownKbps)
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%d%%</td>\n", "Bandwidth Envelope Headroom", routeShader.BandwidthEnvelopeHeadroomPercent)
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%dms</td>\n", "Route Select Threshold", routeShader.RouteSelectThreshold)
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%dms</td>\n", "Route Switch Threshold", routeShader.RouteSwitchThreshold)
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%dms</td>\n", "Max Latency Trade Off", routeShader.MaxLatencyTradeOff)
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%d</td>\n", "Required Packet Bytes", routeShader.RequiredPacketBytes)
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%d</td>\n", "Match Policy", routeShader.MatchPolicy)
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%dms</td>\n", "Max Match Latency Disparity", routeShader.MaxMatchLatencyDisparity)
 		fmt.Fprintf(w, "</table>\n")
 	}
 
@@ -1072,11 +1180,17 @@ func ExtractDatabase(config string) (*Database, error) {
 		route_shader_id   uint64
 		live              bool
 		debug             bool
+
+		rate_limit_packets_per_second         int
+		rate_limit_address_packets_per_second int
+
+		min_sdk_version         string
+		recommended_sdk_version string
 	}
 
 	buyerRows := make([]BuyerRow, 0)
 	{
-		rows, err := tx.Query("SELECT buyer_id, buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug FROM buyers")
+		rows, err := tx.Query("SELECT buyer_id, buyer_name, buyer_code, public_key_base64, route_shader_id, live, debug, rate_limit_packets_per_second, rate_limit_address_packets_per_second, min_sdk_version, recommended_sdk_version FROM buyers")
 		if err != nil {
 			return nil, fmt.Errorf("could not extract buyers: %v\n", err)
 		}
@@ -1085,7 +1199,7 @@ func ExtractDatabase(config string) (*Database, error) {
 
 		for rows.Next() {
 			row := BuyerRow{}
-			if err := rows.Scan(&row.buyer_id, &row.buyer_name, &row.buyer_code, &row.public_key_base64, &row.route_shader_id, &row.live, &row.debug); err != nil {
+			if err := rows.Scan(&row.buyer_id, &row.buyer_name, &row.buyer_code, &row.public_key_base64, &row.route_shader_id, &row.live, &row.debug, &row.rate_limit_packets_per_second, &row.rate_limit_address_packets_per_second, &row.min_sdk_version, &row.recommended_sdk_version); err != nil {
 				return nil, fmt.Errorf("failed to scan buyer row: %v\n", err)
 			}
 			buyerRows = append(buyerRows, row)
@@ -1129,24 +1243,30 @@ func ExtractDatabase(config string) (*Database, error) {
 	// route shaders
 
 	type RouteShaderRow struct {
-		route_shader_id              uint64
-		ab_test                      bool
-		acceptable_latency           int
-		acceptable_packet_loss       float32
-		bandwidth_envelope_down_kbps int
-		bandwidth_envelope_up_kbps   int
-		disable_network_next         bool
-		latency_reduction_threshold  int
-		selection_percent            int
-		max_latency_trade_off        int
-		route_switch_threshold       int
-		route_select_threshold       int
-		force_next                   bool
+		route_shader_id                     uint64
+		ab_test                             bool
+		acceptable_latency                  int
+		acceptable_packet_loss              float32
+		bandwidth_envelope_down_kbps        int
+		bandwidth_envelope_up_kbps          int
+		disable_network_next                bool
+		latency_reduction_threshold         int
+		selection_percent                   int
+		max_latency_trade_off               int
+		route_switch_threshold              int
+		route_select_threshold              int
+		force_next                          bool
+		required_packet_bytes               int
+		match_policy                        int
+		max_match_latency_disparity         int
+		bandwidth_envelope_max_up_kbps      int
+		bandwidth_envelope_max_down_kbps    int
+		bandwidth_envelope_headroom_percent int
 	}
 
 	routeShaderRows := make([]RouteShaderRow, 0)
 	{
-		rows, err := tx.Query("SELECT route_shader_id, ab_test, acceptable_latency, acceptable_packet_loss, bandwidth_envelope_down_kbps, bandwidth_envelope_up_kbps, disable_network_next, latency_reduction_threshold, selection_percent, max_latency_trade_off, route_switch_threshold, route_select_threshold, force_next FROM route_shaders")
+		rows, err := tx.Query("SELECT route_shader_id, ab_test, acceptable_latency, acceptable_packet_loss, bandwidth_envelope_down_kbps, bandwidth_envelope_up_kbps, disable_network_next, latency_reduction_threshold, selection_percent, max_latency_trade_off, route_switch_threshold, route_select_threshold, force_next, required_packet_bytes, match_policy, max_match_latency_disparity, bandwidth_envelope_max_up_kbps, bandwidth_envelope_max_down_kbps, bandwidth_envelope_headroom_percent FROM route_shaders")
 		if err != nil {
 			return nil, fmt.Errorf("could not extract route shaders: %v\n", err)
 		}
@@ -1155,7 +1275,7 @@ func ExtractDatabase(config string) (*Database, error) {
 
 		for rows.Next() {
 			row := RouteShaderRow{}
-			if err := rows.Scan(&row.route_shader_id, &row.ab_test, &row.acceptable_latency, &row.acceptable_packet_loss, &row.bandwidth_envelope_down_kbps, &row.bandwidth_envelope_up_kbps, &row.disable_network_next, &row.latency_reduction_threshold, &row.selection_percent, &row.max_latency_trade_off, &row.route_switch_threshold, &row.route_select_threshold, &row.force_next); err != nil {
+			if err := rows.Scan(&row.route_shader_id, &row.ab_test, &row.acceptable_latency, &row.acceptable_packet_loss, &row.bandwidth_envelope_down_kbps, &row.bandwidth_envelope_up_kbps, &row.disable_network_next, &row.latency_reduction_threshold, &row.selection_percent, &row.max_latency_trade_off, &row.route_switch_threshold, &row.route_select_threshold, &row.force_next, &row.required_packet_bytes, &row.match_policy, &row.max_match_latency_disparity, &row.bandwidth_envelope_max_up_kbps, &row.bandwidth_envelope_max_down_kbps, &row.bandwidth_envelope_headroom_percent); err != nil {
 				return nil, fmt.Errorf("failed to scan route shader row: %v\n", err)
 			}
 			routeShaderRows = append(routeShaderRows, row)
@@ -1196,6 +1316,38 @@ func ExtractDatabase(config string) (*Database, error) {
 		}
 	}
 
+	// buyer keys
+
+	type BuyerKeyRow struct {
+		buyer_key_id      uint64
+		buyer_id          uint64
+		public_key_base64 string
+		not_before        int64
+		not_after         int64
+	}
+
+	buyerKeyRows := make([]BuyerKeyRow, 0)
+	{
+		rows, err := tx.Query("SELECT buyer_key_id, buyer_id, public_key_base64, not_before, not_after FROM buyer_keys")
+		if err != nil {
+			return nil, fmt.Errorf("could not extract buyer keys: %v\n", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			row := BuyerKeyRow{}
+			if err := rows.Scan(&row.buyer_key_id, &row.buyer_id, &row.public_key_base64, &row.not_before, &row.not_after); err != nil {
+				return nil, fmt.Errorf("failed to scan buyer key row: %v\n", err)
+			}
+			buyerKeyRows = append(buyerKeyRows, row)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("buyer key rows error: %v\n", err)
+		}
+	}
+
 	// print out rows
 
 	fmt.Printf("\nrelays:\n")
@@ -1220,7 +1372,7 @@ func ExtractDatabase(config string) (*Database, error) {
 
 	fmt.Printf("\nroute shaders:\n")
 	for _, row := range routeShaderRows {
-		fmt.Printf("%d: %v, %d, %.1f, %d, %d, %v, %d, %d, %d, %d, %d, %v\n",
+		fmt.Printf("%d: %v, %d, %.1f, %d, %d, %v, %d, %d, %d, %d, %d, %v, %d, %d, %d, %d, %d, %d\n",
 			row.route_shader_id,
 			row.ab_test,
 			row.acceptable_latency,
@@ -1233,7 +1385,13 @@ func ExtractDatabase(config string) (*Database, error) {
 			row.max_latency_trade_off,
 			row.route_switch_threshold,
 			row.route_select_threshold,
-			row.force_next)
+			row.force_next,
+			row.required_packet_bytes,
+			row.match_policy,
+			row.max_match_latency_disparity,
+			row.bandwidth_envelope_max_up_kbps,
+			row.bandwidth_envelope_max_down_kbps,
+			row.bandwidth_envelope_headroom_percent)
 	}
 
 	fmt.Printf("\nbuyer datacenter settings:\n")
@@ -1241,6 +1399,11 @@ func ExtractDatabase(config string) (*Database, error) {
 		fmt.Printf("(%d,%d): %v\n", row.buyer_id, row.datacenter_id, row.enable_acceleration)
 	}
 
+	fmt.Printf("\nbuyer keys:\n")
+	for _, row := range buyerKeyRows {
+		fmt.Printf("%d: %d, %s, %d, %d\n", row.buyer_key_id, row.buyer_id, row.public_key_base64, row.not_before, row.not_after)
+	}
+
 	// index datacenters by postgres id
 
 	datacenterIndex := make(map[uint64]DatacenterRow)
@@ -1255,6 +1418,13 @@ func ExtractDatabase(config string) (*Database, error) {
 		buyerIndex[row.buyer_id] = row
 	}
 
+	// index buyer keys by postgres buyer id
+
+	buyerKeyIndex := make(map[uint64][]BuyerKeyRow)
+	for _, row := range buyerKeyRows {
+		buyerKeyIndex[row.buyer_id] = append(buyerKeyIndex[row.buyer_id], row)
+	}
+
 	// index sellers by postgres id
 
 	sellerIndex := make(map[uint64]SellerRow)
@@ -1317,6 +1487,22 @@ func ExtractDatabase(config string) (*Database, error) {
 		buyer.Live = row.live
 		buyer.Debug = row.debug
 
+		buyer.RateLimitPacketsPerSecond = int32(row.rate_limit_packets_per_second)
+		buyer.RateLimitAddressPacketsPerSecond = int32(row.rate_limit_address_packets_per_second)
+
+		buyer.MinSDKVersion = row.min_sdk_version
+		buyer.RecommendedSDKVersion = row.recommended_sdk_version
+
+		for _, key_row := range buyerKeyIndex[row.buyer_id] {
+			keyData, err := base64.StdEncoding.DecodeString(key_row.public_key_base64)
+			if err != nil || len(keyData) != 40 || binary.LittleEndian.Uint64(keyData[:8]) != buyer.Id {
+				// IMPORTANT: Downgrade to a warning otherwise the API service can get stuck in a broken state
+				fmt.Printf("warning: buyer '%s' key %d is invalid\n", buyer.Name, key_row.buyer_key_id)
+				continue
+			}
+			buyer.RotationKeys = append(buyer.RotationKeys, BuyerKey{PublicKey: keyData[8:40], NotBefore: key_row.not_before, NotAfter: key_row.not_after})
+		}
+
 		route_shader_row, route_shader_exists := routeShaderIndex[row.route_shader_id]
 		if !route_shader_exists {
 			return nil, fmt.Errorf("buyer %s does not have a route shader\n", buyer.Name)
@@ -1330,10 +1516,16 @@ func ExtractDatabase(config string) (*Database, error) {
 		buyer.RouteShader.AcceptablePacketLoss = route_shader_row.acceptable_packet_loss
 		buyer.RouteShader.BandwidthEnvelopeUpKbps = int32(route_shader_row.bandwidth_envelope_up_kbps)
 		buyer.RouteShader.BandwidthEnvelopeDownKbps = int32(route_shader_row.bandwidth_envelope_down_kbps)
+		buyer.RouteShader.BandwidthEnvelopeMaxUpKbps = int32(route_shader_row.bandwidth_envelope_max_up_kbps)
+		buyer.RouteShader.BandwidthEnvelopeMaxDownKbps = int32(route_shader_row.bandwidth_envelope_max_down_kbps)
+		buyer.RouteShader.BandwidthEnvelopeHeadroomPercent = int32(route_shader_row.bandwidth_envelope_headroom_percent)
 		buyer.RouteShader.RouteSelectThreshold = int32(route_shader_row.route_select_threshold)
 		buyer.RouteShader.RouteSwitchThreshold = int32(route_shader_row.route_switch_threshold)
 		buyer.RouteShader.MaxLatencyTradeOff = int32(route_shader_row.max_latency_trade_off)
 		buyer.RouteShader.ForceNext = route_shader_row.force_next
+		buyer.RouteShader.RequiredPacketBytes = int32(route_shader_row.required_packet_bytes)
+		buyer.RouteShader.MatchPolicy = int32(route_shader_row.match_policy)
+		buyer.RouteShader.MaxMatchLatencyDisparity = int32(route_shader_row.max_match_latency_disparity)
 
 		database.BuyerMap[buyer.Id] = &buyer
 
@@ -1375,7 +1567,8 @@ func ExtractDatabase(config string) (*Database, error) {
 		relay.InternalAddress = core.ParseAddress(row.internal_ip)
 		relay.InternalAddress.Port = row.internal_port
 
-		if relay.InternalAddress.String() != "0.0.0.0:0" {
+		// IMPORTANT: relay addresses may be ipv4 or ipv6. the unspecified address of either family means no internal address
+		if relay.InternalAddress.IP != nil && !relay.InternalAddress.IP.IsUnspecified() {
 			relay.HasInternalAddress = true
 			if relay.InternalAddress.Port == 0 {
 				relay.InternalAddress.Port = relay.PublicAddress.Port
@@ -1389,7 +1582,7 @@ func ExtractDatabase(config string) (*Database, error) {
 		}
 
 		relay.SSHAddress = core.ParseAddress(row.ssh_ip)
-		if relay.SSHAddress.String() == "0.0.0.0:0" {
+		if relay.SSHAddress.IP == nil || relay.SSHAddress.IP.IsUnspecified() {
 			relay.SSHAddress = relay.PublicAddress
 		}
 		relay.SSHAddress.Port = row.ssh_port
@@ -1497,6 +1690,10 @@ func ExtractDatabase(config string) (*Database, error) {
 // -----------------------------------------------------------------------------------------------------------
 
 func (database *Database) LoadBinary(data []byte) error {
+	return database.LoadBinaryWithMasterKey(data, envvar.GetSecretsMasterKey())
+}
+
+func (database *Database) LoadBinaryWithMasterKey(data []byte, masterKey []byte) error {
 
 	compressed_buffer := bytes.NewReader(data)
 
@@ -1506,38 +1703,64 @@ func (database *Database) LoadBinary(data []byte) error {
 	}
 
 	err = gob.NewDecoder(gz_reader).Decode(database)
+	if err != nil {
+		return err
+	}
 
-	if err == nil {
-		database.Fixup()
+	// IMPORTANT: Without the master key the database still loads, but relay private keys and relay secret keys are left sealed
+	if len(database.Secrets) > 0 && len(masterKey) > 0 {
+		if err := database.openSecrets(masterKey); err != nil {
+			return err
+		}
 	}
 
-	return err
+	database.Fixup()
+
+	return nil
 }
 
 func (database *Database) GetBinary() []byte {
+	data, err := database.GetBinaryWithMasterKey(envvar.GetSecretsMasterKey())
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (database *Database) GetBinaryWithMasterKey(masterKey []byte) ([]byte, error) {
+
+	encoded := database
+
+	if len(masterKey) > 0 {
+		var err error
+		encoded, err = database.sealSecrets(masterKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	var buffer bytes.Buffer
 
-	err := gob.NewEncoder(&buffer).Encode(database)
+	err := gob.NewEncoder(&buffer).Encode(encoded)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	var compressed_buffer bytes.Buffer
 	gz, err := gzip.NewWriterLevel(&compressed_buffer, gzip.BestCompression)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	if _, err := gz.Write(buffer.Bytes()); err != nil {
-		return nil
+		return nil, err
 	}
 
 	if err := gz.Close(); err != nil {
-		return nil
+		return nil, err
 	}
 
-	return compressed_buffer.Bytes()
+	return compressed_buffer.Bytes(), nil
 }
 
 type HeaderResponse struct {
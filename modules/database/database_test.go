@@ -0,0 +1,135 @@
+package database_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/networknext/next/modules/core"
+	"github.com/networknext/next/modules/crypto"
+	db "github.com/networknext/next/modules/database"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestDatabase() *db.Database {
+
+	database := db.CreateDatabase()
+
+	database.CreationTime = "now"
+	database.Creator = "test"
+	database.SellerMap[1] = &db.Seller{Id: 1, Name: "seller"}
+	database.DatacenterMap[1] = &db.Datacenter{Id: 1, Name: "local", SellerId: 1}
+
+	for i := range 4 {
+		relayId := uint64(1 + i)
+		publicKey, privateKey := crypto.Box_KeyPair()
+		relay := db.Relay{
+			Id:            relayId,
+			Name:          string(rune('a' + i)),
+			DatacenterId:  1,
+			PublicAddress: core.ParseAddress("127.0.0.1:2000"),
+			PublicKey:     publicKey,
+			PrivateKey:    privateKey,
+			Seller:        database.SellerMap[1],
+			Datacenter:    database.DatacenterMap[1],
+		}
+		database.Relays = append(database.Relays, relay)
+		database.DatacenterRelays[1] = append(database.DatacenterRelays[1], relayId)
+		database.RelaySecretKeys[relayId] = publicKey
+	}
+
+	database.Fixup()
+
+	return database
+}
+
+func TestDatabaseSecrets(t *testing.T) {
+
+	t.Parallel()
+
+	database := createTestDatabase()
+
+	masterKey := crypto.Secrets_MasterKey()
+
+	data, err := database.GetBinaryWithMasterKey(masterKey)
+	assert.Nil(t, err)
+
+	// the database passed in is not modified
+
+	assert.Equal(t, 32, len(database.Relays[0].PrivateKey))
+	assert.Equal(t, 0, len(database.Secrets))
+
+	// with the master key, the secrets come back
+
+	loaded := db.Database{}
+	assert.Nil(t, loaded.LoadBinaryWithMasterKey(data, masterKey))
+	assert.Equal(t, len(database.Relays), len(loaded.Relays))
+	for i := range database.Relays {
+		assert.Equal(t, database.Relays[i].PrivateKey, loaded.Relays[i].PrivateKey)
+		assert.Equal(t, database.Relays[i].PrivateKey, loaded.RelayMap[loaded.Relays[i].Id].PrivateKey)
+	}
+	assert.Equal(t, database.RelaySecretKeys, loaded.RelaySecretKeys)
+	assert.Equal(t, 0, len(loaded.Secrets))
+
+	// without it, the database loads without secrets, and writes them back out still sealed
+
+	sealed := db.Database{}
+	assert.Nil(t, sealed.LoadBinaryWithMasterKey(data, nil))
+	assert.Equal(t, len(database.Relays), len(sealed.Relays))
+	for i := range sealed.Relays {
+		assert.Equal(t, 0, len(sealed.Relays[i].PrivateKey))
+	}
+	assert.Equal(t, 0, len(sealed.RelaySecretKeys))
+	assert.NotEqual(t, 0, len(sealed.Secrets))
+
+	resaved, err := sealed.GetBinaryWithMasterKey(nil)
+	assert.Nil(t, err)
+
+	reloaded := db.Database{}
+	assert.Nil(t, reloaded.LoadBinaryWithMasterKey(resaved, masterKey))
+	for i := range database.Relays {
+		assert.Equal(t, database.Relays[i].PrivateKey, reloaded.Relays[i].PrivateKey)
+	}
+
+	// the wrong master key is an error
+
+	wrong := db.Database{}
+	assert.Error(t, wrong.LoadBinaryWithMasterKey(data, crypto.Secrets_MasterKey()))
+}
+
+func TestDatabaseRedacted(t *testing.T) {
+
+	t.Parallel()
+
+	database := createTestDatabase()
+
+	redacted := database.Redacted()
+
+	assert.Equal(t, len(database.Relays), len(redacted.Relays))
+	for i := range redacted.Relays {
+		assert.Equal(t, 0, len(redacted.Relays[i].PrivateKey))
+		assert.Equal(t, 0, len(redacted.RelayMap[redacted.Relays[i].Id].PrivateKey))
+		assert.Equal(t, 32, len(database.Relays[i].PrivateKey))
+	}
+	assert.Equal(t, 0, len(redacted.RelaySecretKeys))
+	assert.Equal(t, 4, len(database.RelaySecretKeys))
+
+	for i := range database.Relays {
+		assert.False(t, bytes.Contains(redacted.GetBinary(), database.Relays[i].PrivateKey))
+	}
+
+	// the database is shown on the unauthenticated /database page and by next database, so neither prints private keys
+
+	var html bytes.Buffer
+	database.WriteHTML(&html)
+	text := database.String()
+
+	for i := range database.Relays {
+		privateKey := base64.StdEncoding.EncodeToString(database.Relays[i].PrivateKey)
+		assert.False(t, strings.Contains(html.String(), privateKey))
+		assert.False(t, strings.Contains(text, privateKey))
+		assert.Equal(t, 32, len(database.Relays[i].PrivateKey))
+	}
+}
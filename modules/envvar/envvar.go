@@ -2,11 +2,15 @@ package envvar
 
 import (
 	"encoding/base64"
+	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/networknext/next/modules/core"
+	"github.com/networknext/next/modules/crypto"
 )
 
 func GetString(name string, defaultValue string) string {
@@ -96,3 +100,126 @@ func GetAddress(name string, defaultValue net.UDPAddr) net.UDPAddr {
 	}
 	return *value
 }
+
+// Secrets in env vars may be stored encrypted with the secrets master key, as "enc:" followed by the base64 envelope.
+// The master key is SECRETS_MASTER_KEY (base64), or read from the file at SECRETS_MASTER_KEY_FILE, which stands in for a KMS.
+// Plaintext values are still accepted, so envs can be moved over one at a time.
+
+const SecretPrefix = "enc:"
+
+// GetSecretsMasterKey returns nil if no master key is configured, and exits if one is configured but can't be read
+
+func GetSecretsMasterKey() []byte {
+	masterKey, err := LookupSecretsMasterKey()
+	if err != nil {
+		core.Error("%v", err)
+		os.Exit(1)
+	}
+	return masterKey
+}
+
+func LookupSecretsMasterKey() ([]byte, error) {
+	if masterKeyString := GetString("SECRETS_MASTER_KEY", ""); masterKeyString != "" {
+		masterKey, err := base64.StdEncoding.DecodeString(masterKeyString)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode SECRETS_MASTER_KEY: %v", err)
+		}
+		return masterKey, nil
+	}
+	filename := GetString("SECRETS_MASTER_KEY_FILE", "")
+	if filename == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(filename, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		filename = homeDir + filename[1:]
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read secrets master key file: %v", err)
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode secrets master key file: %v", err)
+	}
+	return masterKey, nil
+}
+
+func DecryptSecret(value string, masterKey []byte) (string, error) {
+	if !strings.HasPrefix(value, SecretPrefix) {
+		return value, nil
+	}
+	if len(masterKey) == 0 {
+		return "", fmt.Errorf("secret is encrypted, but there is no secrets master key")
+	}
+	envelope, err := base64.StdEncoding.DecodeString(value[len(SecretPrefix):])
+	if err != nil {
+		return "", fmt.Errorf("could not decode encrypted secret: %v", err)
+	}
+	plaintext, err := crypto.Secrets_Decrypt(masterKey, envelope)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func EncryptSecret(value string, masterKey []byte) (string, error) {
+	envelope, err := crypto.Secrets_Encrypt(masterKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// LookupSecret returns the decrypted value of a secret env var, false if it isn't set, or an error if it can't be decrypted
+
+func LookupSecret(name string) (string, bool, error) {
+	valueString, ok := os.LookupEnv(name)
+	if !ok {
+		return "", false, nil
+	}
+	masterKey, err := LookupSecretsMasterKey()
+	if err != nil {
+		return "", true, err
+	}
+	value, err := DecryptSecret(valueString, masterKey)
+	if err != nil {
+		return "", true, fmt.Errorf("could not decrypt %s: %v", name, err)
+	}
+	return value, true, nil
+}
+
+// IMPORTANT: GetSecretString and GetSecretBase64 exit if the secret is set but can't be decrypted or decoded.
+// Falling back to the default would quietly run a service with the wrong key.
+
+func GetSecretString(name string, defaultValue string) string {
+	value, ok, err := LookupSecret(name)
+	if err != nil {
+		core.Error("%v", err)
+		os.Exit(1)
+	}
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+func GetSecretBase64(name string, defaultValue []byte) []byte {
+	valueString, ok, err := LookupSecret(name)
+	if err != nil {
+		core.Error("%v", err)
+		os.Exit(1)
+	}
+	if !ok {
+		return defaultValue
+	}
+	value, err := base64.StdEncoding.DecodeString(valueString)
+	if err != nil {
+		core.Error("could not decode %s: %v", name, err)
+		os.Exit(1)
+	}
+	return value
+}
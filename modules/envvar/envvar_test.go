@@ -0,0 +1,60 @@
+package envvar_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/networknext/next/modules/crypto"
+	"github.com/networknext/next/modules/envvar"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupSecret(t *testing.T) {
+
+	// not parallel: sets env vars
+
+	masterKey := crypto.Secrets_MasterKey()
+
+	t.Setenv("SECRETS_MASTER_KEY", base64.StdEncoding.EncodeToString(masterKey))
+	t.Setenv("SECRETS_MASTER_KEY_FILE", "")
+
+	sealed, err := envvar.EncryptSecret("secret", masterKey)
+	assert.NoError(t, err)
+
+	// sealed and plaintext secrets both read back
+
+	t.Setenv("TEST_SECRET", sealed)
+	value, ok, err := envvar.LookupSecret("TEST_SECRET")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "secret", value)
+
+	t.Setenv("TEST_SECRET", "plaintext")
+	value, ok, err = envvar.LookupSecret("TEST_SECRET")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "plaintext", value)
+
+	_, ok, err = envvar.LookupSecret("TEST_SECRET_NOT_SET")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// a sealed secret that doesn't open with the master key is an error, never the default
+
+	t.Setenv("SECRETS_MASTER_KEY", base64.StdEncoding.EncodeToString(crypto.Secrets_MasterKey()))
+	t.Setenv("TEST_SECRET", sealed)
+	_, ok, err = envvar.LookupSecret("TEST_SECRET")
+	assert.Error(t, err)
+	assert.True(t, ok)
+
+	t.Setenv("SECRETS_MASTER_KEY", "")
+	_, _, err = envvar.LookupSecret("TEST_SECRET")
+	assert.Error(t, err)
+
+	// so is a master key file that can't be read
+
+	t.Setenv("SECRETS_MASTER_KEY_FILE", "/nonexistent/secrets-master-key.txt")
+	_, err = envvar.LookupSecretsMasterKey()
+	assert.Error(t, err)
+}
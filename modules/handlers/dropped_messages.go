@@ -43,14 +43,17 @@ func (counter *DroppedMessageCounter) NumDropped() uint64 {
 
 var (
 	DroppedFallbackToDirect                 = &DroppedMessageCounter{name: "fallback to direct"}
+	DroppedMatchUpdates                     = &DroppedMessageCounter{name: "match update"}
 	DroppedPortalSessionUpdateMessages      = &DroppedMessageCounter{name: "portal session update message"}
 	DroppedPortalClientRelayUpdateMessages  = &DroppedMessageCounter{name: "portal client relay update message"}
 	DroppedPortalServerRelayUpdateMessages  = &DroppedMessageCounter{name: "portal server relay update message"}
 	DroppedPortalServerUpdateMessages       = &DroppedMessageCounter{name: "portal server update message"}
+	DroppedPortalSessionReportMessages      = &DroppedMessageCounter{name: "portal session report message"}
 	DroppedAnalyticsClientRelayPingMessages = &DroppedMessageCounter{name: "analytics client relay ping message"}
 	DroppedAnalyticsServerRelayPingMessages = &DroppedMessageCounter{name: "analytics server relay ping message"}
 	DroppedAnalyticsSessionUpdateMessages   = &DroppedMessageCounter{name: "analytics session update message"}
 	DroppedAnalyticsSessionSummaryMessages  = &DroppedMessageCounter{name: "analytics session summary message"}
 	DroppedAnalyticsServerInitMessages      = &DroppedMessageCounter{name: "analytics server init message"}
 	DroppedAnalyticsServerUpdateMessages    = &DroppedMessageCounter{name: "analytics server update message"}
+	DroppedAnalyticsSessionReportMessages   = &DroppedMessageCounter{name: "analytics session report message"}
 )
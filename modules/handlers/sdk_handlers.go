@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"net"
 	"time"
 
@@ -52,7 +53,20 @@ const (
 
 	SDK_HandlerEvent_SentPortalServerUpdateMessage = 30
 
-	SDK_HandlerEvent_NumEvents = 31
+	SDK_HandlerEvent_CouldNotReadSessionReportRequestPacket = 31
+	SDK_HandlerEvent_ProcessSessionReportRequestPacket      = 32
+	SDK_HandlerEvent_SentSessionReportResponsePacket        = 33
+	SDK_HandlerEvent_SentAnalyticsSessionReportMessage      = 34
+	SDK_HandlerEvent_SentPortalSessionReportMessage         = 35
+
+	SDK_HandlerEvent_RateLimitedAddress = 36
+	SDK_HandlerEvent_RateLimitedBuyer   = 37
+
+	SDK_HandlerEvent_SDKUpgradeRecommended = 38
+
+	SDK_HandlerEvent_DuplicateSessionReport = 39
+
+	SDK_HandlerEvent_NumEvents = 40
 )
 
 type SDK_Handler struct {
@@ -63,21 +77,29 @@ type SDK_Handler struct {
 	PingKey                 []byte
 	ServerBackendPublicKey  []byte
 	ServerBackendPrivateKey []byte
+	SessionDataKeyring      *crypto.Sign_Keyring
 	RelayBackendPublicKey   []byte
 	RelayBackendPrivateKey  []byte
 	GetMagicValues          func() ([constants.MagicBytes]byte, [constants.MagicBytes]byte, [constants.MagicBytes]byte)
 	Events                  [SDK_HandlerEvent_NumEvents]bool
 	LocateIP                func(ip net.IP) (float32, float32)
 	GetISPAndCountry        func(ip net.IP) (string, string)
+	FirstSessionReport      func(sessionId uint64, requestId uint64) bool
 
 	PortalNextSessionsOnly bool
 
+	MatchTracker       *common.MatchTracker
+	MatchUpdateChannel chan<- common.MatchUpdate
+
+	RateLimiter *common.RateLimiter
+
 	FallbackToDirectChannel chan<- uint64
 
 	PortalServerUpdateMessageChannel      chan<- *messages.PortalServerUpdateMessage
 	PortalSessionUpdateMessageChannel     chan<- *messages.PortalSessionUpdateMessage
 	PortalClientRelayUpdateMessageChannel chan<- *messages.PortalClientRelayUpdateMessage
 	PortalServerRelayUpdateMessageChannel chan<- *messages.PortalServerRelayUpdateMessage
+	PortalSessionReportMessageChannel     chan<- *messages.PortalSessionReportMessage
 
 	AnalyticsServerInitMessageChannel      chan<- *messages.AnalyticsServerInitMessage
 	AnalyticsServerUpdateMessageChannel    chan<- *messages.AnalyticsServerUpdateMessage
@@ -85,6 +107,7 @@ type SDK_Handler struct {
 	AnalyticsServerRelayPingMessageChannel chan<- *messages.AnalyticsServerRelayPingMessage
 	AnalyticsSessionUpdateMessageChannel   chan<- *messages.AnalyticsSessionUpdateMessage
 	AnalyticsSessionSummaryMessageChannel  chan<- *messages.AnalyticsSessionSummaryMessage
+	AnalyticsSessionReportMessageChannel   chan<- *messages.AnalyticsSessionReportMessage
 }
 
 func SDK_PacketHandler(handler *SDK_Handler, conn *net.UDPConn, from *net.UDPAddr, packetData []byte) {
@@ -147,14 +170,56 @@ func SDK_PacketHandler(handler *SDK_Handler, conn *net.UDPConn, from *net.UDPAdd
 		return
 	}
 
-	publicKey := buyer.PublicKey
+	/*
+		Source addresses can be spoofed, so only packets with a valid signature count against the address and buyer limits.
+		Otherwise anybody could spoof a game server's address or the buyer id and use up its packets.
 
-	if !crypto.SDK_CheckPacketSignature(packetData, publicKey) {
+		Packets that fail the signature check count against a separate failure limit for the address. Once that runs out,
+		drop packets from the address before the signature check, so a flood from one address costs us almost nothing.
+		Addresses that recently sent a packet with a valid signature are always checked, so spoofed junk can't drop them.
+	*/
+
+	currentTime := time.Now().UnixNano()
+
+	if handler.RateLimiter != nil && handler.RateLimiter.AddressFailing(currentTime, from.AddrPort()) {
+		core.Debug("rate limited packet from %s after signature check failures", from.String())
+		handler.Events[SDK_HandlerEvent_RateLimitedAddress] = true
+		return
+	}
+
+	if !SDK_CheckBuyerPacketSignature(buyer, packetData, currentTime/1000000000) {
 		core.Debug("packet signature check failed")
 		handler.Events[SDK_HandlerEvent_SignatureCheckFailed] = true
+		if handler.RateLimiter != nil {
+			handler.RateLimiter.FailAddress(currentTime, from.AddrPort())
+		}
 		return
 	}
 
+	if handler.RateLimiter != nil {
+		packetsPerSecond := buyer.RateLimitAddressPacketsPerSecond
+		if packetsPerSecond <= 0 {
+			packetsPerSecond = common.DefaultAddressPacketsPerSecond
+		}
+		if !handler.RateLimiter.AllowAddress(currentTime, from.AddrPort(), packetsPerSecond) {
+			core.Debug("rate limited packet from %s", from.String())
+			handler.Events[SDK_HandlerEvent_RateLimitedAddress] = true
+			return
+		}
+	}
+
+	if handler.RateLimiter != nil {
+		packetsPerSecond := buyer.RateLimitPacketsPerSecond
+		if packetsPerSecond <= 0 {
+			packetsPerSecond = common.DefaultBuyerPacketsPerSecond
+		}
+		if !handler.RateLimiter.AllowBuyer(currentTime, buyerId, packetsPerSecond) {
+			core.Debug("rate limited packet for buyer %016x", buyerId)
+			handler.Events[SDK_HandlerEvent_RateLimitedBuyer] = true
+			return
+		}
+	}
+
 	// process the packet according to type
 
 	packetType := packetData[0]
@@ -213,11 +278,68 @@ func SDK_PacketHandler(handler *SDK_Handler, conn *net.UDPConn, from *net.UDPAdd
 		SDK_ProcessSessionUpdateRequestPacket(handler, conn, from, &packet)
 		break
 
+	case packets.SDK_SESSION_REPORT_REQUEST_PACKET:
+		packet := packets.SDK_SessionReportRequestPacket{}
+		if err := packets.ReadPacket(packetData, &packet); err != nil {
+			core.Error("could not read session report request packet from %s: %v", from.String(), err)
+			handler.Events[SDK_HandlerEvent_CouldNotReadSessionReportRequestPacket] = true
+			return
+		}
+		SDK_ProcessSessionReportRequestPacket(handler, conn, from, &packet)
+		break
+
 	default:
 		core.Debug("ignoring packet with unknown type %d from %s", packetType, from.String())
 	}
 }
 
+/*
+	While a key is being rotated, the buyer has rotation keys that are only accepted inside their not before / not after
+	window, so old and new keys overlap until every server has switched over. The buyer's primary public key is accepted
+	with no window, unless it is also listed as a rotation key. Then its window applies to it like any other key, which
+	is how the primary key is retired once the buyer has moved to a new key.
+*/
+
+func SDK_CheckBuyerPacketSignature(buyer *database.Buyer, packetData []byte, currentTime int64) bool {
+	primaryKeyListed := false
+	for i := range buyer.RotationKeys {
+		if bytes.Equal(buyer.RotationKeys[i].PublicKey, buyer.PublicKey) {
+			primaryKeyListed = true
+		}
+		if buyer.RotationKeys[i].ValidAt(currentTime) && crypto.SDK_CheckPacketSignature(packetData, buyer.RotationKeys[i].PublicKey) {
+			return true
+		}
+	}
+	return !primaryKeyListed && crypto.SDK_CheckPacketSignature(packetData, buyer.PublicKey)
+}
+
+/*
+	Every buyer must be on at least SDK_MinVersion. On top of that a buyer can have its own minimum sdk version,
+	which lets us drop old code paths for one customer at a time, and a recommended version, below which servers
+	are told to upgrade in the server init response. Invalid per-buyer versions are ignored.
+*/
+
+var SDK_MinVersion = packets.SDKVersion{1, 0, 0}
+
+func SDK_VersionTooOld(buyer *database.Buyer, version packets.SDKVersion) bool {
+	if !version.AtLeast(SDK_MinVersion) {
+		return true
+	}
+	if buyer.MinSDKVersion == "" {
+		return false
+	}
+	minVersion, err := packets.ParseSDKVersion(buyer.MinSDKVersion)
+	return err == nil && !version.AtLeast(minVersion)
+}
+
+func SDK_VersionUpgradeRecommended(buyer *database.Buyer, version packets.SDKVersion) bool {
+	if buyer.RecommendedSDKVersion == "" {
+		return false
+	}
+	recommendedVersion, err := packets.ParseSDKVersion(buyer.RecommendedSDKVersion)
+	return err == nil && !version.AtLeast(recommendedVersion)
+}
+
 func SDK_SendResponsePacket[P packets.Packet](handler *SDK_Handler, conn *net.UDPConn, to *net.UDPAddr, packetType int, packet P) {
 
 	packetData, err := packets.SDK_WritePacket(packet, packetType, handler.MaxPacketSize, &handler.ServerBackendAddress, to, handler.ServerBackendPrivateKey)
@@ -255,6 +377,10 @@ func SDK_SendResponsePacket[P packets.Packet](handler *SDK_Handler, conn *net.UD
 		handler.Events[SDK_HandlerEvent_SentServerRelayResponsePacket] = true
 		break
 
+	case packets.SDK_SESSION_REPORT_RESPONSE_PACKET:
+		handler.Events[SDK_HandlerEvent_SentSessionReportResponsePacket] = true
+		break
+
 	default:
 		core.Error("tried to send response packet with unknown type %d", packetType)
 	}
@@ -280,6 +406,7 @@ func SDK_ProcessServerInitRequestPacket(handler *SDK_Handler, conn *net.UDPConn,
 	upcomingMagic, currentMagic, previousMagic := handler.GetMagicValues()
 
 	responsePacket := &packets.SDK_ServerInitResponsePacket{}
+	responsePacket.Version = requestPacket.Version
 	responsePacket.RequestId = requestPacket.RequestId
 	responsePacket.Response = packets.SDK_ServerInitResponseOK
 	copy(responsePacket.UpcomingMagic[:], upcomingMagic[:])
@@ -301,12 +428,19 @@ func SDK_ProcessServerInitRequestPacket(handler *SDK_Handler, conn *net.UDPConn,
 		handler.Events[SDK_HandlerEvent_BuyerNotLive] = true
 	}
 
-	if !requestPacket.Version.AtLeast(packets.SDKVersion{1, 0, 0}) {
+	sdkTooOld := SDK_VersionTooOld(buyer, requestPacket.Version)
+	if sdkTooOld {
 		core.Warn("sdk version is too old: %s", requestPacket.Version.String())
 		responsePacket.Response = packets.SDK_ServerInitResponseSDKVersionTooOld
 		handler.Events[SDK_HandlerEvent_SDKTooOld] = true
 	}
 
+	if SDK_VersionUpgradeRecommended(buyer, requestPacket.Version) {
+		core.Debug("sdk version %s is below the recommended version %s for buyer %016x", requestPacket.Version.String(), buyer.RecommendedSDKVersion, requestPacket.BuyerId)
+		responsePacket.UpgradeRecommended = true
+		handler.Events[SDK_HandlerEvent_SDKUpgradeRecommended] = true
+	}
+
 	buyerSettings, exists := handler.Database.BuyerDatacenterSettings[requestPacket.BuyerId]
 
 	if !exists {
@@ -342,6 +476,8 @@ func SDK_ProcessServerInitRequestPacket(handler *SDK_Handler, conn *net.UDPConn,
 		message.DatacenterName = requestPacket.DatacenterName
 		message.ServerAddress = from.String()
 		message.ServerId = int64(common.HashString(from.String()))
+		message.SDKTooOld = sdkTooOld
+		message.SDKUpgradeRecommended = responsePacket.UpgradeRecommended
 
 		select {
 		case handler.AnalyticsServerInitMessageChannel <- &message:
@@ -437,7 +573,7 @@ func SDK_ProcessServerUpdateRequestPacket(handler *SDK_Handler, conn *net.UDPCon
 		return
 	}
 
-	if !requestPacket.Version.AtLeast(packets.SDKVersion{1, 0, 0}) {
+	if SDK_VersionTooOld(buyer, requestPacket.Version) {
 		core.Debug("sdk version is too old: %s", requestPacket.Version.String())
 		handler.Events[SDK_HandlerEvent_SDKTooOld] = true
 		return
@@ -471,6 +607,15 @@ func SDK_ProcessSessionUpdateRequestPacket(handler *SDK_Handler, conn *net.UDPCo
 		core.Debug("---------------------------------------------------------------------------")
 	}
 
+	// IMPORTANT: the minimum sdk version applies to sessions in progress too. Raising it cuts off their session updates,
+	// so those sessions fall back to direct, the same as servers below the minimum can't init
+
+	if SDK_VersionTooOld(handler.Database.BuyerMap[requestPacket.BuyerId], requestPacket.Version) {
+		core.Debug("sdk version is too old: %s", requestPacket.Version.String())
+		handler.Events[SDK_HandlerEvent_SDKTooOld] = true
+		return
+	}
+
 	/*
 	   Build session handler state. Putting everything in a struct makes calling subroutines much easier.
 	*/
@@ -482,6 +627,7 @@ func SDK_ProcessSessionUpdateRequestPacket(handler *SDK_Handler, conn *net.UDPCo
 	state.RelayBackendPrivateKey = handler.RelayBackendPrivateKey
 	state.ServerBackendPublicKey = handler.ServerBackendPublicKey
 	state.ServerBackendPrivateKey = handler.ServerBackendPrivateKey
+	state.SessionDataKeyring = handler.SessionDataKeyring
 	state.ServerBackendAddress = &handler.ServerBackendAddress
 	state.From = from
 	state.Buyer = handler.Database.BuyerMap[requestPacket.BuyerId]
@@ -498,6 +644,9 @@ func SDK_ProcessSessionUpdateRequestPacket(handler *SDK_Handler, conn *net.UDPCo
 
 	state.PortalNextSessionsOnly = handler.PortalNextSessionsOnly
 
+	state.MatchTracker = handler.MatchTracker
+	state.MatchUpdateChannel = handler.MatchUpdateChannel
+
 	state.FallbackToDirectChannel = handler.FallbackToDirectChannel
 
 	state.PortalSessionUpdateMessageChannel = handler.PortalSessionUpdateMessageChannel
@@ -625,7 +774,7 @@ func SDK_ProcessClientRelayRequestPacket(handler *SDK_Handler, conn *net.UDPConn
 		return
 	}
 
-	if !requestPacket.Version.AtLeast(packets.SDKVersion{1, 0, 0}) {
+	if SDK_VersionTooOld(buyer, requestPacket.Version) {
 		core.Debug("sdk version is too old: %s", requestPacket.Version.String())
 		handler.Events[SDK_HandlerEvent_SDKTooOld] = true
 		return
@@ -712,7 +861,7 @@ func SDK_ProcessServerRelayRequestPacket(handler *SDK_Handler, conn *net.UDPConn
 		return
 	}
 
-	if !requestPacket.Version.AtLeast(packets.SDKVersion{1, 0, 0}) {
+	if SDK_VersionTooOld(buyer, requestPacket.Version) {
 		core.Debug("sdk version is too old: %s", requestPacket.Version.String())
 		handler.Events[SDK_HandlerEvent_SDKTooOld] = true
 		return
@@ -756,3 +905,106 @@ func SDK_ProcessServerRelayRequestPacket(handler *SDK_Handler, conn *net.UDPConn
 
 	SDK_SendResponsePacket(handler, conn, from, packets.SDK_SERVER_RELAY_RESPONSE_PACKET, responsePacket)
 }
+
+func SDK_ProcessSessionReportRequestPacket(handler *SDK_Handler, conn *net.UDPConn, from *net.UDPAddr, requestPacket *packets.SDK_SessionReportRequestPacket) {
+
+	startTimestamp := time.Now().UnixNano()
+
+	handler.Events[SDK_HandlerEvent_ProcessSessionReportRequestPacket] = true
+
+	if core.DebugLogs {
+		core.Debug("---------------------------------------------------------------------------")
+		core.Debug("received session report request packet from %s", from.String())
+		core.Debug("version: %d.%d.%d", requestPacket.Version.Major, requestPacket.Version.Minor, requestPacket.Version.Patch)
+		core.Debug("buyer id: %016x", requestPacket.BuyerId)
+		core.Debug("datacenter id: %016x", requestPacket.DatacenterId)
+		core.Debug("session id: %016x", requestPacket.SessionId)
+		core.Debug("request id: %016x", requestPacket.RequestId)
+		core.Debug("event code: %d", requestPacket.EventCode)
+		for i := 0; i < int(requestPacket.NumTags); i++ {
+			core.Debug("tag %d: %s", i, requestPacket.Tags[i])
+		}
+		core.Debug("---------------------------------------------------------------------------")
+	}
+
+	buyer, exists := handler.Database.BuyerMap[requestPacket.BuyerId]
+	if !exists {
+		core.Debug("unknown buyer: %016x", requestPacket.BuyerId)
+		handler.Events[SDK_HandlerEvent_UnknownBuyer] = true
+		return
+	}
+
+	if !buyer.Live {
+		core.Debug("buyer not live: %016x", requestPacket.BuyerId)
+		handler.Events[SDK_HandlerEvent_BuyerNotLive] = true
+		return
+	}
+
+	if SDK_VersionTooOld(buyer, requestPacket.Version) {
+		core.Debug("sdk version is too old: %s", requestPacket.Version.String())
+		handler.Events[SDK_HandlerEvent_SDKTooOld] = true
+		return
+	}
+
+	// the SDK resends a report until it gets a response. resends of a report we already published are answered, but not published again
+
+	if handler.FirstSessionReport != nil && !handler.FirstSessionReport(requestPacket.SessionId, requestPacket.RequestId) {
+		core.Debug("duplicate session report: %016x/%016x", requestPacket.SessionId, requestPacket.RequestId)
+		handler.Events[SDK_HandlerEvent_DuplicateSessionReport] = true
+		SDK_SendSessionReportResponsePacket(handler, conn, from, requestPacket)
+		return
+	}
+
+	tags := make([]string, requestPacket.NumTags)
+	copy(tags, requestPacket.Tags[:requestPacket.NumTags])
+
+	if handler.AnalyticsSessionReportMessageChannel != nil {
+
+		message := messages.AnalyticsSessionReportMessage{}
+
+		message.Timestamp = startTimestamp / 1000 // nano -> milliseconds
+		message.BuyerId = int64(requestPacket.BuyerId)
+		message.SessionId = int64(requestPacket.SessionId)
+		message.DatacenterId = int64(requestPacket.DatacenterId)
+		message.ServerAddress = from.String()
+		message.EventCode = int64(requestPacket.EventCode)
+		message.Tags = tags
+
+		select {
+		case handler.AnalyticsSessionReportMessageChannel <- &message:
+			handler.Events[SDK_HandlerEvent_SentAnalyticsSessionReportMessage] = true
+		default:
+			DroppedAnalyticsSessionReportMessages.MessageDropped()
+		}
+	}
+
+	if handler.PortalSessionReportMessageChannel != nil {
+
+		message := messages.PortalSessionReportMessage{}
+
+		message.Timestamp = uint64(startTimestamp / 1000000000) // nanoseconds -> seconds
+		message.BuyerId = requestPacket.BuyerId
+		message.SessionId = requestPacket.SessionId
+		message.DatacenterId = requestPacket.DatacenterId
+		message.EventCode = requestPacket.EventCode
+		message.Tags = tags
+
+		select {
+		case handler.PortalSessionReportMessageChannel <- &message:
+			handler.Events[SDK_HandlerEvent_SentPortalSessionReportMessage] = true
+		default:
+			DroppedPortalSessionReportMessages.MessageDropped()
+		}
+	}
+
+	// IMPORTANT: respond even if the report could not be forwarded, so the SDK stops resending it
+
+	SDK_SendSessionReportResponsePacket(handler, conn, from, requestPacket)
+}
+
+func SDK_SendSessionReportResponsePacket(handler *SDK_Handler, conn *net.UDPConn, from *net.UDPAddr, requestPacket *packets.SDK_SessionReportRequestPacket) {
+	responsePacket := &packets.SDK_SessionReportResponsePacket{}
+	responsePacket.SessionId = requestPacket.SessionId
+	responsePacket.RequestId = requestPacket.RequestId
+	SDK_SendResponsePacket(handler, conn, from, packets.SDK_SESSION_REPORT_RESPONSE_PACKET, responsePacket)
+}
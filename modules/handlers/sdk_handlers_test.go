@@ -288,6 +288,258 @@ func TestSignatureCheckFailed_SDK(t *testing.T) {
 	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
 }
 
+func TestRateLimitedAddress_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer that allows one packet per second from each address
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+	harness.handler.RateLimiter = common.NewRateLimiter()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = buyerPublicKey[:]
+	buyer.RateLimitAddressPacketsPerSecond = 1
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	crypto.SDK_SignPacket(packetData[:], buyerPrivateKey[:])
+
+	// the first two packets fill the burst and are processed. the third is rate limited after the signature check
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedBuyer])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+}
+
+func TestRateLimitedSignatureFailures_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 100)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer, but don't sign the packet
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+	harness.handler.RateLimiter = common.NewRateLimiter()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = buyerPublicKey[:]
+	buyer.RateLimitAddressPacketsPerSecond = 1
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	// packets that fail the signature check don't use up the address limit for signed packets. they have their own, and once
+	// that is used up, packets from the address are rate limited before the signature check
+
+	for range common.AddressSignatureFailuresPerSecond * common.RateLimitBurstSeconds {
+		SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+	}
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedBuyer])
+}
+
+func TestSignatureFailuresDontRateLimitVerifiedAddress_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+	harness.handler.RateLimiter = common.NewRateLimiter()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = buyerPublicKey[:]
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	crypto.SDK_SignPacket(packetData[:], buyerPrivateKey[:])
+
+	badPacketData := make([]byte, len(packetData))
+	copy(badPacketData, packetData)
+	badPacketData[len(badPacketData)-1] ^= 0xFF
+
+	// the game server sends a signed packet, then junk spoofed from its address empties the failure bucket
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+
+	for range 10 * common.AddressSignatureFailuresPerSecond * common.RateLimitBurstSeconds {
+		SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, badPacketData)
+	}
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+
+	// the game server's next signed packet is still checked and processed
+
+	harness.handler.Events = [SDK_HandlerEvent_NumEvents]bool{}
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+}
+
+func TestRateLimitedBuyer_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer that allows one packet per second in total
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+	harness.handler.RateLimiter = common.NewRateLimiter()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = buyerPublicKey[:]
+	buyer.RateLimitPacketsPerSecond = 1
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	crypto.SDK_SignPacket(packetData[:], buyerPrivateKey[:])
+
+	// the first two packets fill the burst and are processed. the third is rate limited after the signature check
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedBuyer])
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedBuyer])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_RateLimitedAddress])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+}
+
 // ---------------------------------------------------------------------------------------
 
 // tests for the server init handler
@@ -437,7 +689,7 @@ func Test_ServerInitHandler_SDKTooOld_SDK(t *testing.T) {
 	}
 }
 
-func Test_ServerInitHandler_DatacenterNotEnabled_SDK(t *testing.T) {
+func Test_ServerInitHandler_BuyerMinSDKVersion_SDK(t *testing.T) {
 
 	t.Parallel()
 
@@ -476,6 +728,7 @@ func Test_ServerInitHandler_DatacenterNotEnabled_SDK(t *testing.T) {
 	buyer := &database.Buyer{}
 	buyer.Live = true
 	buyer.PublicKey = buyerPublicKey[:]
+	buyer.MinSDKVersion = "1.2.12"
 	_ = buyerPrivateKey
 
 	harness.handler.Database.BuyerMap[buyerId] = buyer
@@ -485,15 +738,22 @@ func Test_ServerInitHandler_DatacenterNotEnabled_SDK(t *testing.T) {
 	index := 18 + 3
 	encoding.WriteUint64(packetData[:], &index, buyerId)
 
+	// modify the packet so it has SDK version 1.2.11
+
+	packetData[18] = 1
+	packetData[19] = 2
+	packetData[20] = 11
+
 	// actually sign the packet, so it passes the signature check
 
 	crypto.SDK_SignPacket(packetData[:], buyerPrivateKey[:])
 
-	// run the packet through the handler, we should see the datacenter is not enabled for the buyer
+	// run the packet through the handler, we should see that the SDK is below the buyer minimum
 
 	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
 
-	assert.True(t, harness.handler.Events[SDK_HandlerEvent_DatacenterNotEnabled])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SDKTooOld])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SDKUpgradeRecommended])
 	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
 	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentServerInitResponsePacket])
 	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsServerInitMessage])
@@ -501,36 +761,38 @@ func Test_ServerInitHandler_DatacenterNotEnabled_SDK(t *testing.T) {
 	// verify that we get a server init message sent over the channel
 
 	select {
-	case _ = <-harness.analyticsServerInitMessageChannel:
+	case message := <-harness.analyticsServerInitMessageChannel:
+		assert.True(t, message.SDKTooOld)
+		assert.False(t, message.SDKUpgradeRecommended)
 	default:
 		panic("no server init message found on channel")
 	}
 }
 
-func Test_ServerInitHandler_ServerInitResponse_SDK(t *testing.T) {
+func Test_ServerInitHandler_SDKUpgradeRecommended_SDK(t *testing.T) {
 
 	t.Parallel()
 
 	harness := CreateTestHarness()
 
-	// setup a UDP socket to listen on so we can get the response packet
-
-	ctx := context.Background()
-
-	lc := net.ListenConfig{}
+	// setup a dummy packet that will get through the packet type check
 
-	lp, err := lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
-	if err != nil {
-		panic("could not bind client socket")
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
 	}
 
-	clientConn := lp.(*net.UDPConn)
+	// generate pittle and chonkle so the packet gets through the basic and advanced packet filters
 
-	clientPort := clientConn.LocalAddr().(*net.UDPAddr).Port
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
 
-	clientAddress := core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", clientPort))
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
 
-	fmt.Printf("client address is %s\n", clientAddress.String())
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
 
 	// setup a buyer in the database with keypair
 
@@ -546,23 +808,206 @@ func Test_ServerInitHandler_ServerInitResponse_SDK(t *testing.T) {
 	buyer := &database.Buyer{}
 	buyer.Live = true
 	buyer.PublicKey = buyerPublicKey[:]
+	buyer.MinSDKVersion = "1.2.9"
+	buyer.RecommendedSDKVersion = "1.2.12"
+	_ = buyerPrivateKey
 
 	harness.handler.Database.BuyerMap[buyerId] = buyer
 
-	// setup "local" datacenter in the database
+	// modify the packet so it has the buyer id of the new buyer, so it passes the unknown buyer check
 
-	localDatacenterId := common.DatacenterId("local")
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
 
-	localDatacenter := &database.Datacenter{
-		Id:        localDatacenterId,
-		Name:      "local",
-		Latitude:  10,
-		Longitude: 20,
-	}
+	// modify the packet so it has SDK version 1.2.11
 
-	harness.handler.Database.DatacenterMap[localDatacenterId] = localDatacenter
+	packetData[18] = 1
+	packetData[19] = 2
+	packetData[20] = 11
 
-	// make sure the "local" datacenter is enabled for the buyer
+	// actually sign the packet, so it passes the signature check
+
+	crypto.SDK_SignPacket(packetData[:], buyerPrivateKey[:])
+
+	// run the packet through the handler, we should see that an upgrade is recommended
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SDKTooOld])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SDKUpgradeRecommended])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentServerInitResponsePacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsServerInitMessage])
+
+	// verify that we get a server init message sent over the channel
+
+	select {
+	case message := <-harness.analyticsServerInitMessageChannel:
+		assert.False(t, message.SDKTooOld)
+		assert.True(t, message.SDKUpgradeRecommended)
+	default:
+		panic("no server init message found on channel")
+	}
+}
+
+func Test_SDKVersionChecks(t *testing.T) {
+
+	t.Parallel()
+
+	buyer := &database.Buyer{}
+
+	assert.True(t, SDK_VersionTooOld(buyer, packets.SDKVersion{0, 1, 2}))
+	assert.False(t, SDK_VersionTooOld(buyer, packets.SDKVersion{1, 0, 0}))
+	assert.False(t, SDK_VersionUpgradeRecommended(buyer, packets.SDKVersion{1, 0, 0}))
+
+	buyer.MinSDKVersion = "1.2.9"
+	buyer.RecommendedSDKVersion = "1.2.11"
+
+	assert.True(t, SDK_VersionTooOld(buyer, packets.SDKVersion{1, 2, 8}))
+	assert.False(t, SDK_VersionTooOld(buyer, packets.SDKVersion{1, 2, 9}))
+	assert.True(t, SDK_VersionUpgradeRecommended(buyer, packets.SDKVersion{1, 2, 10}))
+	assert.False(t, SDK_VersionUpgradeRecommended(buyer, packets.SDKVersion{1, 2, 11}))
+
+	// internal builds are never too old
+
+	assert.False(t, SDK_VersionTooOld(buyer, packets.SDKVersion{255, 255, 255}))
+	assert.False(t, SDK_VersionUpgradeRecommended(buyer, packets.SDKVersion{255, 255, 255}))
+
+	// invalid per-buyer versions are ignored
+
+	buyer.MinSDKVersion = "bogus"
+	buyer.RecommendedSDKVersion = "bogus"
+
+	assert.False(t, SDK_VersionTooOld(buyer, packets.SDKVersion{1, 0, 0}))
+	assert.False(t, SDK_VersionUpgradeRecommended(buyer, packets.SDKVersion{1, 0, 0}))
+}
+
+func Test_ServerInitHandler_DatacenterNotEnabled_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet type check
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	// generate pittle and chonkle so the packet gets through the basic and advanced packet filters
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer in the database with keypair
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.Live = true
+	buyer.PublicKey = buyerPublicKey[:]
+	_ = buyerPrivateKey
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	// modify the packet so it has the buyer id of the new buyer, so it passes the unknown buyer check
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	// actually sign the packet, so it passes the signature check
+
+	crypto.SDK_SignPacket(packetData[:], buyerPrivateKey[:])
+
+	// run the packet through the handler, we should see the datacenter is not enabled for the buyer
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_DatacenterNotEnabled])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentServerInitResponsePacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsServerInitMessage])
+
+	// verify that we get a server init message sent over the channel
+
+	select {
+	case _ = <-harness.analyticsServerInitMessageChannel:
+	default:
+		panic("no server init message found on channel")
+	}
+}
+
+func Test_ServerInitHandler_ServerInitResponse_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a UDP socket to listen on so we can get the response packet
+
+	ctx := context.Background()
+
+	lc := net.ListenConfig{}
+
+	lp, err := lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
+	if err != nil {
+		panic("could not bind client socket")
+	}
+
+	clientConn := lp.(*net.UDPConn)
+
+	clientPort := clientConn.LocalAddr().(*net.UDPAddr).Port
+
+	clientAddress := core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", clientPort))
+
+	fmt.Printf("client address is %s\n", clientAddress.String())
+
+	// setup a buyer in the database with keypair
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.Live = true
+	buyer.PublicKey = buyerPublicKey[:]
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	// setup "local" datacenter in the database
+
+	localDatacenterId := common.DatacenterId("local")
+
+	localDatacenter := &database.Datacenter{
+		Id:        localDatacenterId,
+		Name:      "local",
+		Latitude:  10,
+		Longitude: 20,
+	}
+
+	harness.handler.Database.DatacenterMap[localDatacenterId] = localDatacenter
+
+	// make sure the "local" datacenter is enabled for the buyer
 
 	harness.handler.Database.BuyerDatacenterSettings[buyerId] = make(map[uint64]*database.BuyerDatacenterSettings)
 
@@ -1997,3 +2442,470 @@ func Test_ServerRelayRequestResponse_SDK(t *testing.T) {
 }
 
 // ---------------------------------------------------------------------------------------
+
+// tests for the session report handler
+
+func Test_SessionUpdateHandler_BuyerMinSDKVersion_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a live buyer in the database with keypair and a minimum sdk version of 1.2.9
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.Live = true
+	buyer.PublicKey = buyerPublicKey[:]
+	buyer.MinSDKVersion = "1.2.9"
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	// a session in progress on sdk 1.2.8 gets no session update response once the minimum is raised above it
+
+	packet := packets.SDK_SessionUpdateRequestPacket{
+		Version:      packets.SDKVersion{1, 2, 8},
+		BuyerId:      buyerId,
+		DatacenterId: common.DatacenterId("local"),
+		SessionId:    0x12345,
+		SliceNumber:  10,
+	}
+
+	packetData, err := packets.SDK_WritePacket(&packet, packets.SDK_SESSION_UPDATE_REQUEST_PACKET, constants.MaxPacketBytes, &harness.from, &harness.handler.ServerBackendAddress, buyerPrivateKey[:])
+	assert.NoError(t, err)
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessSessionUpdateRequestPacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SDKTooOld])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SentSessionUpdateResponsePacket])
+
+	// sessions at the minimum version are updated as usual
+
+	harness.handler.Events = [SDK_HandlerEvent_NumEvents]bool{}
+
+	packet.Version = packets.SDKVersion{1, 2, 9}
+	packet.SliceNumber = 0
+
+	packetData, err = packets.SDK_WritePacket(&packet, packets.SDK_SESSION_UPDATE_REQUEST_PACKET, constants.MaxPacketBytes, &harness.from, &harness.handler.ServerBackendAddress, buyerPrivateKey[:])
+	assert.NoError(t, err)
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessSessionUpdateRequestPacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SDKTooOld])
+}
+
+func Test_SessionReportHandler_BuyerNotLive_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a buyer in the database with keypair, but don't set it live
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = buyerPublicKey[:]
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	// construct a valid, signed session report request packet
+
+	packet := packets.SDK_SessionReportRequestPacket{
+		Version:   packets.SDKVersion{1, 0, 0},
+		BuyerId:   buyerId,
+		SessionId: 0x12345,
+		RequestId: 0x54321,
+		EventCode: 100,
+	}
+
+	packetData, err := packets.SDK_WritePacket(&packet, packets.SDK_SESSION_REPORT_REQUEST_PACKET, constants.MaxPacketBytes, &harness.from, &harness.handler.ServerBackendAddress, buyerPrivateKey[:])
+	assert.Nil(t, err)
+
+	// run the packet through the handler, it should pass the signature check then fail on buyer not live
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessSessionReportRequestPacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_BuyerNotLive])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SentSessionReportResponsePacket])
+}
+
+func Test_SessionReportHandler_SessionReportResponse_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	analyticsSessionReportMessageChannel := make(chan *messages.AnalyticsSessionReportMessage, 1)
+	portalSessionReportMessageChannel := make(chan *messages.PortalSessionReportMessage, 1)
+
+	harness.handler.AnalyticsSessionReportMessageChannel = analyticsSessionReportMessageChannel
+	harness.handler.PortalSessionReportMessageChannel = portalSessionReportMessageChannel
+
+	// setup a live buyer in the database with keypair
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.Live = true
+	buyer.PublicKey = buyerPublicKey[:]
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	// construct a valid, signed session report request packet with some tags
+
+	packet := packets.SDK_SessionReportRequestPacket{
+		Version:      packets.SDKVersion{1, 0, 0},
+		BuyerId:      buyerId,
+		DatacenterId: 0x1000,
+		SessionId:    0x12345,
+		RequestId:    0x54321,
+		EventCode:    100,
+		NumTags:      2,
+	}
+
+	packet.Tags[0] = "rubber banding"
+	packet.Tags[1] = "map=dust"
+
+	packetData, err := packets.SDK_WritePacket(&packet, packets.SDK_SESSION_REPORT_REQUEST_PACKET, constants.MaxPacketBytes, &harness.from, &harness.handler.ServerBackendAddress, buyerPrivateKey[:])
+	assert.Nil(t, err)
+
+	// run the packet through the handler, it should forward the report and respond
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessSessionReportRequestPacket])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsSessionReportMessage])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentPortalSessionReportMessage])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentSessionReportResponsePacket])
+
+	analyticsMessage := <-analyticsSessionReportMessageChannel
+
+	assert.Equal(t, int64(packet.BuyerId), analyticsMessage.BuyerId)
+	assert.Equal(t, int64(packet.SessionId), analyticsMessage.SessionId)
+	assert.Equal(t, int64(packet.DatacenterId), analyticsMessage.DatacenterId)
+	assert.Equal(t, int64(packet.EventCode), analyticsMessage.EventCode)
+	assert.Equal(t, []string{"rubber banding", "map=dust"}, analyticsMessage.Tags)
+
+	portalMessage := <-portalSessionReportMessageChannel
+
+	assert.Equal(t, packet.BuyerId, portalMessage.BuyerId)
+	assert.Equal(t, packet.SessionId, portalMessage.SessionId)
+	assert.Equal(t, packet.EventCode, portalMessage.EventCode)
+	assert.Equal(t, []string{"rubber banding", "map=dust"}, portalMessage.Tags)
+}
+
+func Test_SessionReportHandler_DuplicateSessionReport_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	analyticsSessionReportMessageChannel := make(chan *messages.AnalyticsSessionReportMessage, 2)
+	portalSessionReportMessageChannel := make(chan *messages.PortalSessionReportMessage, 2)
+
+	harness.handler.AnalyticsSessionReportMessageChannel = analyticsSessionReportMessageChannel
+	harness.handler.PortalSessionReportMessageChannel = portalSessionReportMessageChannel
+
+	// stand in for the redis SETNX the server backend uses to claim each (session id, request id) pair
+
+	seen := make(map[[2]uint64]bool)
+	harness.handler.FirstSessionReport = func(sessionId uint64, requestId uint64) bool {
+		key := [2]uint64{sessionId, requestId}
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		return true
+	}
+
+	// setup a live buyer in the database with keypair
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.Live = true
+	buyer.PublicKey = buyerPublicKey[:]
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	packet := packets.SDK_SessionReportRequestPacket{
+		Version:      packets.SDKVersion{1, 0, 0},
+		BuyerId:      buyerId,
+		DatacenterId: 0x1000,
+		SessionId:    0x12345,
+		RequestId:    0x54321,
+		EventCode:    100,
+	}
+
+	packetData, err := packets.SDK_WritePacket(&packet, packets.SDK_SESSION_REPORT_REQUEST_PACKET, constants.MaxPacketBytes, &harness.from, &harness.handler.ServerBackendAddress, buyerPrivateKey[:])
+	assert.Nil(t, err)
+
+	// the first report is published and answered
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsSessionReportMessage])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentSessionReportResponsePacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_DuplicateSessionReport])
+
+	// the SDK resends the same report because it missed the response. it is answered again, but not published again
+
+	harness.handler.Events = [SDK_HandlerEvent_NumEvents]bool{}
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_DuplicateSessionReport])
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentSessionReportResponsePacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsSessionReportMessage])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SentPortalSessionReportMessage])
+
+	assert.Equal(t, 1, len(analyticsSessionReportMessageChannel))
+	assert.Equal(t, 1, len(portalSessionReportMessageChannel))
+
+	// a new report from the same session is published
+
+	harness.handler.Events = [SDK_HandlerEvent_NumEvents]bool{}
+
+	packet.RequestId++
+
+	packetData, err = packets.SDK_WritePacket(&packet, packets.SDK_SESSION_REPORT_REQUEST_PACKET, constants.MaxPacketBytes, &harness.from, &harness.handler.ServerBackendAddress, buyerPrivateKey[:])
+	assert.Nil(t, err)
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SentAnalyticsSessionReportMessage])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_DuplicateSessionReport])
+
+	assert.Equal(t, 2, len(analyticsSessionReportMessageChannel))
+	assert.Equal(t, 2, len(portalSessionReportMessageChannel))
+}
+
+// ---------------------------------------------------------------------------------------
+
+func TestBuyerKeyRotation_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer with a new primary key, and the old key kept as a rotation key for a while
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var newPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var newPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(newPublicKey[:], newPrivateKey[:])
+
+	var oldPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var oldPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(oldPublicKey[:], oldPrivateKey[:])
+
+	currentTime := time.Now().Unix()
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = newPublicKey[:]
+	buyer.RotationKeys = []database.BuyerKey{{PublicKey: oldPublicKey[:], NotAfter: currentTime + 3600}}
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	// packets signed with the old key are accepted while it is still valid
+
+	crypto.SDK_SignPacket(packetData[:], oldPrivateKey[:])
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+
+	assert.True(t, SDK_CheckBuyerPacketSignature(buyer, packetData, currentTime))
+	assert.False(t, SDK_CheckBuyerPacketSignature(buyer, packetData, currentTime+3600))
+
+	// packets signed with the new key are always accepted
+
+	crypto.SDK_SignPacket(packetData[:], newPrivateKey[:])
+
+	assert.True(t, SDK_CheckBuyerPacketSignature(buyer, packetData, currentTime+3600))
+}
+
+func TestBuyerKeyExpired_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer whose rotation key has expired
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var buyerPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var buyerPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(buyerPublicKey[:], buyerPrivateKey[:])
+
+	var expiredPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var expiredPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(expiredPublicKey[:], expiredPrivateKey[:])
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = buyerPublicKey[:]
+	buyer.RotationKeys = []database.BuyerKey{{PublicKey: expiredPublicKey[:], NotBefore: 1, NotAfter: time.Now().Unix() - 60}}
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	crypto.SDK_SignPacket(packetData[:], expiredPrivateKey[:])
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+}
+
+func TestBuyerKeyRetiredPrimary_SDK(t *testing.T) {
+
+	t.Parallel()
+
+	harness := CreateTestHarness()
+
+	// setup a dummy packet that will get through the packet filters
+
+	packetData := make([]byte, 256)
+	packetData[0] = packets.SDK_SERVER_INIT_REQUEST_PACKET
+	for i := 1; i < len(packetData); i++ {
+		packetData[i] = byte(i)
+	}
+
+	magic := [8]byte{}
+	fromAddress := [4]byte{127, 0, 0, 1}
+	toAddress := [4]byte{127, 0, 0, 1}
+	packetLength := len(packetData)
+
+	core.GeneratePittle(packetData[1:3], fromAddress[:], toAddress[:], packetLength)
+
+	core.GenerateChonkle(packetData[3:18], magic[:], fromAddress[:], toAddress[:], packetLength)
+
+	// setup a buyer that has moved to a new key, and whose primary key is listed with a window that has closed
+
+	harness.handler.RouteMatrix = &common.RouteMatrix{}
+	harness.handler.Database = database.CreateDatabase()
+
+	buyerId := uint64(0x1111111122222222)
+
+	var primaryPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var primaryPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(primaryPublicKey[:], primaryPrivateKey[:])
+
+	var newPublicKey [crypto.SDK_CRYPTO_SIGN_PUBLIC_KEY_BYTES]byte
+	var newPrivateKey [crypto.SDK_CRYPTO_SIGN_PRIVATE_KEY_BYTES]byte
+	crypto.SDK_SignKeypair(newPublicKey[:], newPrivateKey[:])
+
+	currentTime := time.Now().Unix()
+
+	buyer := &database.Buyer{}
+	buyer.PublicKey = primaryPublicKey[:]
+	buyer.RotationKeys = []database.BuyerKey{
+		{PublicKey: primaryPublicKey[:], NotAfter: currentTime - 60},
+		{PublicKey: newPublicKey[:], NotBefore: currentTime - 3600},
+	}
+
+	harness.handler.Database.BuyerMap[buyerId] = buyer
+
+	index := 18 + 3
+	encoding.WriteUint64(packetData[:], &index, buyerId)
+
+	// packets signed with the retired primary key are rejected
+
+	crypto.SDK_SignPacket(packetData[:], primaryPrivateKey[:])
+
+	SDK_PacketHandler(&harness.handler, harness.conn, &harness.from, packetData)
+
+	assert.True(t, harness.handler.Events[SDK_HandlerEvent_SignatureCheckFailed])
+	assert.False(t, harness.handler.Events[SDK_HandlerEvent_ProcessServerInitRequestPacket])
+
+	// it was accepted until its window closed
+
+	assert.True(t, SDK_CheckBuyerPacketSignature(buyer, packetData, currentTime-120))
+
+	// packets signed with the new key are accepted
+
+	crypto.SDK_SignPacket(packetData[:], newPrivateKey[:])
+
+	assert.True(t, SDK_CheckBuyerPacketSignature(buyer, packetData, currentTime))
+}
@@ -34,6 +34,9 @@ type SessionUpdateState struct {
 	ServerBackendPrivateKey []byte
 	ServerBackendPublicKey  []byte
 
+	// signs and verifies session data. nil means the server backend keypair
+	SessionDataKeyring *crypto.Sign_Keyring
+
 	From *net.UDPAddr
 
 	Input packets.SDK_SessionData // sent up from the SDK. previous slice.
@@ -75,12 +78,23 @@ type SessionUpdateState struct {
 	// if true, only network next sessions are sent to portal
 	PortalNextSessionsOnly bool
 
+	// latencies of the other sessions in the same match. nil disables match policy
+	MatchTracker *common.MatchTracker
+
+	// this session's latency in its match is sent here, and written to the match tracker off the session update path
+	MatchUpdateChannel chan<- common.MatchUpdate
+
 	// codepath flags (for unit testing etc...)
 	ClientPingTimedOut                          bool
 	RouteChanged                                bool
 	RouteContinued                              bool
 	TakeNetworkNext                             bool
 	StayDirect                                  bool
+	MatchPolicyStayDirect                       bool
+	MatchPolicyLeaveNetworkNext                 bool
+	EnvelopeChanged                             bool
+	EnvelopeExceededUp                          bool
+	EnvelopeExceededDown                        bool
 	ReadSessionData                             bool
 	NotUpdatingClientRelaysDatacenterNotEnabled bool
 	NotUpdatingServerRelaysDatacenterNotEnabled bool
@@ -112,7 +126,7 @@ func SessionUpdate_ReadSessionData(state *SessionUpdateState) bool {
 		return true
 	}
 
-	if !crypto.Verify(state.Request.SessionData[:state.Request.SessionDataBytes], state.ServerBackendPublicKey[:], state.Request.SessionDataSignature[:]) {
+	if !SessionUpdate_VerifySessionData(state) {
 		core.Error("session data signature check failed")
 		state.Error |= constants.SessionError_SessionDataSignatureCheckFailed
 		return false
@@ -132,6 +146,36 @@ func SessionUpdate_ReadSessionData(state *SessionUpdateState) bool {
 	return true
 }
 
+func SessionUpdate_VerifySessionData(state *SessionUpdateState) bool {
+
+	sessionData := state.Request.SessionData[:state.Request.SessionDataBytes]
+
+	if state.SessionDataKeyring == nil {
+		return crypto.Verify(sessionData, state.ServerBackendPublicKey[:], state.Request.SessionDataSignature[:])
+	}
+
+	/*
+		Session data signed during a key rotation may be signed with an older key. The key id says which one.
+
+		If we don't have the key, either it was retired while the session was still running, or this server backend
+		hasn't been given the new key yet. Stage new keys on every server backend before signing with them!
+	*/
+
+	keyId, err := packets.SDK_ReadSessionDataKeyId(sessionData)
+	if err != nil {
+		core.Debug("failed to read session data key id: %v", err)
+		return false
+	}
+
+	if keyId != 0 && !state.SessionDataKeyring.HasKey(keyId) {
+		core.Error("unknown session data key id: %08x", keyId)
+		state.Error |= constants.SessionError_UnknownSessionDataKey
+		return false
+	}
+
+	return state.SessionDataKeyring.Verify(keyId, sessionData, state.Request.SessionDataSignature[:])
+}
+
 func SessionUpdate_Pre(state *SessionUpdateState) bool {
 
 	state.StartTimestampNano = uint64(time.Now().UnixNano())
@@ -308,14 +352,36 @@ func SessionUpdate_ExistingSession(state *SessionUpdateState) {
 	state.Output.ExpireTimestamp += packets.SDK_SliceSeconds
 
 	/*
-		Track total next envelope bandwidth sent up and down
+		Track total next envelope bandwidth sent up and down.
+
+		Relays drop packets over the envelope in the route tokens, so flag slices where the session sent more than that.
 	*/
 
 	if state.Request.Next {
-		state.Output.NextEnvelopeBytesUpSum += uint64(state.Buyer.RouteShader.BandwidthEnvelopeUpKbps) * 1000 * packets.SDK_SliceSeconds / 8
-		state.Output.NextEnvelopeBytesDownSum += uint64(state.Buyer.RouteShader.BandwidthEnvelopeDownKbps) * 1000 * packets.SDK_SliceSeconds / 8
+
+		envelopeUpKbps, envelopeDownKbps := SessionUpdate_CurrentEnvelope(state, &state.Input)
+
+		state.Output.NextEnvelopeBytesUpSum += uint64(envelopeUpKbps) * 1000 * packets.SDK_SliceSeconds / 8
+		state.Output.NextEnvelopeBytesDownSum += uint64(envelopeDownKbps) * 1000 * packets.SDK_SliceSeconds / 8
+
+		if state.Request.BandwidthKbpsUp > envelopeUpKbps {
+			core.Debug("client bandwidth over envelope: %d > %d kbps", state.Request.BandwidthKbpsUp, envelopeUpKbps)
+			state.EnvelopeExceededUp = true
+		}
+
+		if state.Request.BandwidthKbpsDown > envelopeDownKbps {
+			core.Debug("server bandwidth over envelope: %d > %d kbps", state.Request.BandwidthKbpsDown, envelopeDownKbps)
+			state.EnvelopeExceededDown = true
+		}
 	}
 
+	/*
+		Track peak bandwidth, so we can adapt the envelope to it.
+	*/
+
+	state.Output.BandwidthPeakKbpsUp = core.UpdateBandwidthPeak(state.Output.BandwidthPeakKbpsUp, state.Request.BandwidthKbpsUp)
+	state.Output.BandwidthPeakKbpsDown = core.UpdateBandwidthPeak(state.Output.BandwidthPeakKbpsDown, state.Request.BandwidthKbpsDown)
+
 	/*
 		Calculate real packet loss %
 
@@ -581,9 +647,7 @@ func SessionUpdate_UpdateServerRelays(state *SessionUpdateState) bool {
 	return true
 }
 
-func SessionUpdate_BuildNextTokens(state *SessionUpdateState, routeNumRelays int32, routeRelays []int32) {
-
-	state.Output.SessionVersion++
+func SessionUpdate_BuildNextTokens(state *SessionUpdateState, routeNumRelays int32, routeRelays []int32) bool {
 
 	numTokens := routeNumRelays + 2
 
@@ -634,21 +698,73 @@ func SessionUpdate_BuildNextTokens(state *SessionUpdateState, routeNumRelays int
 	routePublicAddresses[numTokens-1] = *state.From
 	routeSecretKeys[numTokens-1], _ = crypto.SecretKey_GenerateRemote(state.RelayBackendPublicKey, state.RelayBackendPrivateKey, state.Request.ServerRoutePublicKey[:])
 
+	/*
+		Route tokens only carry ipv6 addresses in the ipv6 route token version, and no SDK or relay can read it yet.
+
+		If the route needs ipv6, veto the session and go direct. Writing ipv4 route tokens would just send the
+		session down a broken route. Once the SDK and relay support ipv6 route tokens, gate them on that SDK version here.
+	*/
+
+	if core.RouteNeedsIPv6(int(numTokens), routePublicAddresses[:], routeHasInternalAddresses[:], routeInternalAddresses[:]) {
+		core.Debug("route needs ipv6, but ipv6 route tokens are not supported")
+		state.Output.RouteState.Next = false
+		state.Output.RouteState.Veto = true
+		state.Error |= constants.SessionError_IPv6NotSupported
+		if state.Debug != nil {
+			*state.Debug += "ipv6 not supported\n"
+		}
+		return false
+	}
+
 	// write the tokens
 
-	tokenData := make([]byte, numTokens*packets.SDK_EncryptedNextRouteTokenSize)
+	state.Output.SessionVersion++
 
 	sessionId := state.Output.SessionId
 	sessionVersion := uint8(state.Output.SessionVersion)
 	expireTimestamp := state.Output.ExpireTimestamp
-	envelopeUpKbps := uint32(state.Buyer.RouteShader.BandwidthEnvelopeUpKbps)
-	envelopeDownKbps := uint32(state.Buyer.RouteShader.BandwidthEnvelopeDownKbps)
+	envelopeUpKbps, envelopeDownKbps := SessionUpdate_TargetEnvelope(state)
 
-	core.WriteRouteTokens(tokenData, expireTimestamp, sessionId, sessionVersion, envelopeUpKbps, envelopeDownKbps, int(numTokens), routePublicAddresses[:], routeHasInternalAddresses[:], routeInternalAddresses[:], routeInternalGroups[:], routeSellers[:], routeSecretKeys[:])
+	state.Output.EnvelopeKbpsUp = envelopeUpKbps
+	state.Output.EnvelopeKbpsDown = envelopeDownKbps
 
+	tokenData := make([]byte, numTokens*packets.SDK_EncryptedNextRouteTokenSize)
+	core.WriteRouteTokens(tokenData, expireTimestamp, sessionId, sessionVersion, envelopeUpKbps, envelopeDownKbps, int(numTokens), routePublicAddresses[:], routeHasInternalAddresses[:], routeInternalAddresses[:], routeInternalGroups[:], routeSellers[:], routeSecretKeys[:])
 	state.Response.RouteType = packets.SDK_RouteTypeNew
+
 	state.Response.NumTokens = numTokens
 	state.Response.Tokens = tokenData
+
+	return true
+}
+
+// SessionUpdate_CurrentEnvelope returns the envelope in the session's current route tokens. Session data from before envelope adaptation has none, so it's the route shader envelope
+
+func SessionUpdate_CurrentEnvelope(state *SessionUpdateState, sessionData *packets.SDK_SessionData) (uint32, uint32) {
+	envelopeUpKbps := sessionData.EnvelopeKbpsUp
+	if envelopeUpKbps == 0 {
+		envelopeUpKbps = uint32(state.Buyer.RouteShader.BandwidthEnvelopeUpKbps)
+	}
+	envelopeDownKbps := sessionData.EnvelopeKbpsDown
+	if envelopeDownKbps == 0 {
+		envelopeDownKbps = uint32(state.Buyer.RouteShader.BandwidthEnvelopeDownKbps)
+	}
+	return envelopeUpKbps, envelopeDownKbps
+}
+
+// SessionUpdate_TargetEnvelope returns the envelope the session should have, given its recent peak bandwidth
+
+func SessionUpdate_TargetEnvelope(state *SessionUpdateState) (uint32, uint32) {
+	routeShader := &state.Buyer.RouteShader
+	envelopeUpKbps := core.BandwidthEnvelope(routeShader.BandwidthEnvelopeUpKbps, routeShader.BandwidthEnvelopeMaxUpKbps, routeShader.BandwidthEnvelopeHeadroomPercent, state.Output.BandwidthPeakKbpsUp)
+	envelopeDownKbps := core.BandwidthEnvelope(routeShader.BandwidthEnvelopeDownKbps, routeShader.BandwidthEnvelopeMaxDownKbps, routeShader.BandwidthEnvelopeHeadroomPercent, state.Output.BandwidthPeakKbpsDown)
+	return envelopeUpKbps, envelopeDownKbps
+}
+
+func SessionUpdate_EnvelopeChanged(state *SessionUpdateState) bool {
+	currentUpKbps, currentDownKbps := SessionUpdate_CurrentEnvelope(state, &state.Output)
+	targetUpKbps, targetDownKbps := SessionUpdate_TargetEnvelope(state)
+	return core.BandwidthEnvelopeChanged(currentUpKbps, targetUpKbps) || core.BandwidthEnvelopeChanged(currentDownKbps, targetDownKbps)
 }
 
 func SessionUpdate_BuildContinueTokens(state *SessionUpdateState, routeNumRelays int32, routeRelays []int32) {
@@ -695,6 +811,12 @@ func SessionUpdate_BuildContinueTokens(state *SessionUpdateState, routeNumRelays
 
 func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 
+	/*
+		Whatever route decision we make, including vetoes and early outs, track this session's latency in its match.
+	*/
+
+	defer SessionUpdate_UpdateMatchTracker(state)
+
 	/*
 		If we are on on network next but don't have any relays in our route, something is WRONG.
 		Veto the session and go direct.
@@ -724,8 +846,11 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 
 		// currently going direct. should we take network next?
 
-		if core.MakeRouteDecision_TakeNetworkNext(state.Request.UserHash,
+		routeState := state.Output.RouteState
+
+		takeNetworkNext := core.MakeRouteDecision_TakeNetworkNext(state.Request.UserHash,
 			state.RouteMatrix.RouteEntries,
+			state.RouteMatrix.RelayMTU,
 			&state.Buyer.RouteShader,
 			&state.Output.RouteState,
 			int32(state.Request.DirectRTT),
@@ -737,11 +862,26 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 			&routeNumRelays,
 			routeRelays[:],
 			state.Debug,
-			sliceNumber) {
+			sliceNumber)
 
-			state.TakeNetworkNext = true
+		if takeNetworkNext && !SessionUpdate_MatchPolicyAllowsNetworkNext(state, routeCost) {
 
-			SessionUpdate_BuildNextTokens(state, routeNumRelays, routeRelays[:routeNumRelays])
+			// network next would help this session, but the match policy says no. undo the route decision
+
+			state.Output.RouteState = routeState
+			state.MatchPolicyStayDirect = true
+			takeNetworkNext = false
+			routeCost = 0
+			routeNumRelays = 0
+		}
+
+		if takeNetworkNext {
+
+			if !SessionUpdate_BuildNextTokens(state, routeNumRelays, routeRelays[:routeNumRelays]) {
+				return
+			}
+
+			state.TakeNetworkNext = true
 
 			if state.Debug != nil {
 
@@ -803,6 +943,7 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 
 		stayOnNext, routeChanged = core.MakeRouteDecision_StayOnNetworkNext(state.Request.UserHash,
 			state.RouteMatrix.RouteEntries,
+			state.RouteMatrix.RelayMTU,
 			state.RouteMatrix.RelayNames,
 			&state.Buyer.RouteShader,
 			&state.Output.RouteState,
@@ -821,6 +962,19 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 			routeRelays[:],
 			state.Debug)
 
+		if stayOnNext && !SessionUpdate_MatchPolicyAllowsNetworkNext(state, routeCost) {
+
+			// the match changed, and the match policy no longer allows this session on network next. leave without
+			// a veto, so the session can take network next again if the match policy allows it later
+
+			state.Output.RouteState.Next = false
+			state.MatchPolicyLeaveNetworkNext = true
+			stayOnNext = false
+			routeChanged = false
+			routeCost = 0
+			routeNumRelays = 0
+		}
+
 		if stayOnNext {
 
 			// stay on network next
@@ -829,9 +983,11 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 
 				core.Debug("route changed")
 
-				state.RouteChanged = true
+				if !SessionUpdate_BuildNextTokens(state, routeNumRelays, routeRelays[:routeNumRelays]) {
+					return
+				}
 
-				SessionUpdate_BuildNextTokens(state, routeNumRelays, routeRelays[:routeNumRelays])
+				state.RouteChanged = true
 
 				if state.Debug != nil {
 
@@ -846,6 +1002,25 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 					}
 				}
 
+			} else if SessionUpdate_EnvelopeChanged(state) {
+
+				/*
+					Continue tokens don't carry the envelope. When the envelope needs to change,
+					re-issue route tokens for the same route with the new envelope.
+				*/
+
+				core.Debug("envelope changed")
+
+				if !SessionUpdate_BuildNextTokens(state, routeNumRelays, routeRelays[:routeNumRelays]) {
+					return
+				}
+
+				state.EnvelopeChanged = true
+
+				if state.Debug != nil {
+					*state.Debug += fmt.Sprintf("envelope changed: %d/%d kbps\n", state.Output.EnvelopeKbpsUp, state.Output.EnvelopeKbpsDown)
+				}
+
 			} else {
 
 				core.Debug("route continued")
@@ -907,6 +1082,41 @@ func SessionUpdate_MakeRouteDecision(state *SessionUpdateState) {
 	}
 }
 
+func SessionUpdate_MatchPolicyAllowsNetworkNext(state *SessionUpdateState, predictedLatency int32) bool {
+
+	if state.Buyer.RouteShader.MatchPolicy == core.MatchPolicy_None || state.MatchTracker == nil || state.Request.MatchId == 0 {
+		return true
+	}
+
+	matchLatencies := state.MatchTracker.GetMatchLatencies(int64(state.StartTimestamp), state.BuyerId, state.Request.MatchId, state.Request.SessionId)
+
+	return core.MatchPolicyAllowsNetworkNext(&state.Buyer.RouteShader, int32(state.Request.DirectRTT), predictedLatency, matchLatencies, state.Debug)
+}
+
+func SessionUpdate_UpdateMatchTracker(state *SessionUpdateState) {
+
+	if state.Buyer.RouteShader.MatchPolicy == core.MatchPolicy_None || state.MatchUpdateChannel == nil || state.Request.MatchId == 0 {
+		return
+	}
+
+	latency := int32(state.Request.DirectRTT)
+	if state.Output.RouteState.Next {
+		latency = state.Output.RouteCost
+	}
+
+	update := common.MatchUpdate{
+		Key:       common.MatchKey{BuyerId: state.BuyerId, MatchId: state.Request.MatchId},
+		SessionId: state.Request.SessionId,
+		Session:   common.MatchSession{Latency: latency, LastUpdateTime: int64(state.StartTimestamp)},
+	}
+
+	select {
+	case state.MatchUpdateChannel <- update:
+	default:
+		DroppedMatchUpdates.MessageDropped()
+	}
+}
+
 func SessionUpdate_Post(state *SessionUpdateState) {
 
 	/*
@@ -1029,6 +1239,12 @@ func SessionUpdate_Post(state *SessionUpdateState) {
 
 	state.Output.Version = packets.SDK_SessionDataVersion_Write
 
+	if state.SessionDataKeyring != nil {
+		state.Output.KeyId = state.SessionDataKeyring.KeyIds[0]
+	} else {
+		state.Output.KeyId = crypto.Sign_KeyId(state.ServerBackendPublicKey)
+	}
+
 	err := state.Output.Serialize(writeStream)
 	if err != nil {
 		core.Error("failed to write session data: %v", err)
@@ -1040,7 +1256,14 @@ func SessionUpdate_Post(state *SessionUpdateState) {
 
 	state.Response.SessionDataBytes = int32(int(writeStream.BytesProcessed()))
 
-	copy(state.Response.SessionDataSignature[:], crypto.Sign(state.Response.SessionData[:state.Response.SessionDataBytes], state.ServerBackendPrivateKey))
+	var sessionDataSignature []byte
+	if state.SessionDataKeyring != nil {
+		_, sessionDataSignature = state.SessionDataKeyring.Sign(state.Response.SessionData[:state.Response.SessionDataBytes])
+	} else {
+		sessionDataSignature = crypto.Sign(state.Response.SessionData[:state.Response.SessionDataBytes], state.ServerBackendPrivateKey)
+	}
+
+	copy(state.Response.SessionDataSignature[:], sessionDataSignature)
 
 	/*
 		Write the session update response packet.
@@ -1164,6 +1387,7 @@ func sendPortalSessionUpdateMessage(state *SessionUpdateState) {
 	message.Retry = state.Request.RetryNumber != 0
 	message.FallbackToDirect = state.Request.FallbackToDirect
 	message.SendToPortal = !state.PortalNextSessionsOnly || (state.PortalNextSessionsOnly && state.Output.DurationOnNext > 0)
+	message.RouteChanged = state.RouteChanged
 
 	if state.PortalSessionUpdateMessageChannel != nil {
 		select {
@@ -1346,6 +1570,11 @@ func sendAnalyticsSessionUpdateMessage(state *SessionUpdateState) {
 		for i := int32(0); i < state.Input.RouteNumRelays; i++ {
 			message.NextRouteRelays[i] = int64(state.Input.RouteRelayIds[i])
 		}
+		envelopeUpKbps, envelopeDownKbps := SessionUpdate_CurrentEnvelope(state, &state.Input)
+		message.EnvelopeKbpsUp = int32(envelopeUpKbps)
+		message.EnvelopeKbpsDown = int32(envelopeDownKbps)
+		message.EnvelopeExceededUp = state.EnvelopeExceededUp
+		message.EnvelopeExceededDown = state.EnvelopeExceededDown
 	}
 
 	// flags
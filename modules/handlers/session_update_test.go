@@ -1,8 +1,10 @@
 package handlers_test
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sort"
 	"testing"
 	"time"
 
@@ -359,6 +361,94 @@ func Test_SessionUpdate_Pre_ReadSessionData(t *testing.T) {
 	assert.False(t, (state.Error&constants.SessionError_FailedToReadSessionData) != 0)
 }
 
+func Test_SessionUpdate_Pre_ReadSessionData_RotatedKey(t *testing.T) {
+
+	t.Parallel()
+
+	state := CreateState()
+
+	serverBackendPublicKey, serverBackendPrivateKey := crypto.Sign_KeyPair()
+
+	state.ServerBackendPublicKey = serverBackendPublicKey
+	state.ServerBackendPrivateKey = serverBackendPrivateKey
+
+	_, oldPrivateKey := crypto.Sign_KeyPair()
+	_, newPrivateKey := crypto.Sign_KeyPair()
+
+	oldKeyring, err := crypto.Sign_CreateKeyring([][]byte{oldPrivateKey})
+	assert.NoError(t, err)
+
+	state.SessionDataKeyring, err = crypto.Sign_CreateKeyring([][]byte{newPrivateKey, oldPrivateKey})
+	assert.NoError(t, err)
+
+	sessionData := packets.GenerateRandomSessionData()
+	sessionData.Version = packets.SDK_SessionDataVersion_Write
+	sessionData.SliceNumber = 10
+	sessionData.KeyId = oldKeyring.KeyIds[0]
+
+	writeSessionData := WriteSessionData(sessionData)
+
+	_, signature := oldKeyring.Sign(writeSessionData)
+
+	state.Request.SessionDataBytes = int32(len(writeSessionData))
+	copy(state.Request.SessionData[:], writeSessionData)
+	copy(state.Request.SessionDataSignature[:], signature)
+
+	state.Request.SliceNumber = 10
+
+	handlers.SessionUpdate_Pre(state)
+
+	handlers.SessionUpdate_ExistingSession(state)
+
+	assert.True(t, state.ReadSessionData)
+	assert.False(t, (state.Error&constants.SessionError_SessionDataSignatureCheckFailed) != 0)
+	assert.False(t, (state.Error&constants.SessionError_UnknownSessionDataKey) != 0)
+}
+
+func Test_SessionUpdate_Pre_ReadSessionData_UnknownKey(t *testing.T) {
+
+	t.Parallel()
+
+	state := CreateState()
+
+	serverBackendPublicKey, serverBackendPrivateKey := crypto.Sign_KeyPair()
+
+	state.ServerBackendPublicKey = serverBackendPublicKey
+	state.ServerBackendPrivateKey = serverBackendPrivateKey
+
+	_, retiredPrivateKey := crypto.Sign_KeyPair()
+	_, newPrivateKey := crypto.Sign_KeyPair()
+
+	retiredKeyring, err := crypto.Sign_CreateKeyring([][]byte{retiredPrivateKey})
+	assert.NoError(t, err)
+
+	state.SessionDataKeyring, err = crypto.Sign_CreateKeyring([][]byte{newPrivateKey})
+	assert.NoError(t, err)
+
+	sessionData := packets.GenerateRandomSessionData()
+	sessionData.Version = packets.SDK_SessionDataVersion_Write
+	sessionData.SliceNumber = 10
+	sessionData.KeyId = retiredKeyring.KeyIds[0]
+
+	writeSessionData := WriteSessionData(sessionData)
+
+	_, signature := retiredKeyring.Sign(writeSessionData)
+
+	state.Request.SessionDataBytes = int32(len(writeSessionData))
+	copy(state.Request.SessionData[:], writeSessionData)
+	copy(state.Request.SessionDataSignature[:], signature)
+
+	state.Request.SliceNumber = 10
+
+	handlers.SessionUpdate_Pre(state)
+
+	handlers.SessionUpdate_ExistingSession(state)
+
+	assert.False(t, state.ReadSessionData)
+	assert.True(t, (state.Error&constants.SessionError_UnknownSessionDataKey) != 0)
+	assert.True(t, (state.Error&constants.SessionError_SessionDataSignatureCheckFailed) != 0)
+}
+
 func Test_SessionUpdate_ExistingSession_BadSessionId(t *testing.T) {
 
 	t.Parallel()
@@ -680,6 +770,10 @@ func Test_SessionUpdate_ExistingSession_EnvelopeBandwidth(t *testing.T) {
 	sessionData.NextEnvelopeBytesUpSum = 1000
 	sessionData.NextEnvelopeBytesDownSum = 1000
 
+	// IMPORTANT: zero envelope in session data means the route shader envelope. random session data at version 9+ has a random envelope
+	sessionData.EnvelopeKbpsUp = 0
+	sessionData.EnvelopeKbpsDown = 0
+
 	writeSessionData := WriteSessionData(sessionData)
 
 	state.Request.SessionDataBytes = int32(len(writeSessionData))
@@ -743,6 +837,145 @@ func Test_SessionUpdate_ExistingSession_EnvelopeBandwidthOnlyOnNext(t *testing.T
 	assert.Equal(t, state.Output.NextEnvelopeBytesDownSum, uint64(0))
 }
 
+func Test_SessionUpdate_ExistingSession_EnvelopeExceeded(t *testing.T) {
+
+	t.Parallel()
+
+	state := CreateState()
+
+	state.Buyer.RouteShader.BandwidthEnvelopeUpKbps = 256
+	state.Buyer.RouteShader.BandwidthEnvelopeDownKbps = 1024
+
+	serverBackendPublicKey, serverBackendPrivateKey := crypto.Sign_KeyPair()
+
+	state.ServerBackendPublicKey = serverBackendPublicKey
+	state.ServerBackendPrivateKey = serverBackendPrivateKey
+
+	sessionId := uint64(0x1234556134512)
+	sliceNumber := uint32(100)
+
+	sessionData := packets.GenerateRandomSessionData()
+	sessionData.Version = packets.SDK_SessionDataVersion_Write
+	sessionData.SessionId = sessionId
+	sessionData.SliceNumber = sliceNumber
+	sessionData.RouteState.Next = true
+	sessionData.NextEnvelopeBytesUpSum = 0
+	sessionData.NextEnvelopeBytesDownSum = 0
+	sessionData.EnvelopeKbpsUp = 512
+	sessionData.EnvelopeKbpsDown = 2048
+	sessionData.BandwidthPeakKbpsUp = 0
+	sessionData.BandwidthPeakKbpsDown = 4000
+
+	writeSessionData := WriteSessionData(sessionData)
+
+	state.Request.SessionDataBytes = int32(len(writeSessionData))
+	copy(state.Request.SessionData[:], writeSessionData)
+	copy(state.Request.SessionDataSignature[:], crypto.Sign(writeSessionData, state.ServerBackendPrivateKey))
+
+	state.Request.Next = true
+	state.Request.SessionId = sessionId
+	state.Request.SliceNumber = sliceNumber
+	state.Request.BandwidthKbpsUp = 600
+	state.Request.BandwidthKbpsDown = 1000
+
+	handlers.SessionUpdate_Pre(state)
+
+	handlers.SessionUpdate_ExistingSession(state)
+
+	// the envelope in the route tokens is tracked, not the route shader envelope
+
+	assert.Equal(t, uint64(512*1250), state.Output.NextEnvelopeBytesUpSum)
+	assert.Equal(t, uint64(2048*1250), state.Output.NextEnvelopeBytesDownSum)
+
+	assert.True(t, state.EnvelopeExceededUp)
+	assert.False(t, state.EnvelopeExceededDown)
+
+	// peak follows bandwidth up right away, and decays down
+
+	assert.Equal(t, uint32(600), state.Output.BandwidthPeakKbpsUp)
+	assert.Equal(t, uint32(3500), state.Output.BandwidthPeakKbpsDown)
+}
+
+// --------------------------------------------------------------
+
+func Test_SessionUpdate_EnvelopeChanged(t *testing.T) {
+
+	t.Parallel()
+
+	state := CreateState()
+
+	state.Buyer.RouteShader = core.NewRouteShader()
+	state.Buyer.RouteShader.BandwidthEnvelopeUpKbps = 256
+	state.Buyer.RouteShader.BandwidthEnvelopeDownKbps = 1024
+
+	// no adaptation, and no envelope in session data: the route shader envelope is current
+
+	assert.False(t, handlers.SessionUpdate_EnvelopeChanged(state))
+
+	// adaptation on, but the session is quiet
+
+	state.Buyer.RouteShader.BandwidthEnvelopeMaxUpKbps = 2048
+	state.Buyer.RouteShader.BandwidthEnvelopeMaxDownKbps = 4096
+	state.Output.BandwidthPeakKbpsUp = 100
+	state.Output.BandwidthPeakKbpsDown = 100
+
+	assert.False(t, handlers.SessionUpdate_EnvelopeChanged(state))
+
+	// voice chat kicks in
+
+	state.Output.BandwidthPeakKbpsUp = 400
+
+	assert.True(t, handlers.SessionUpdate_EnvelopeChanged(state))
+
+	upKbps, downKbps := handlers.SessionUpdate_TargetEnvelope(state)
+	assert.Equal(t, uint32(500), upKbps)
+	assert.Equal(t, uint32(1024), downKbps)
+
+	// once the tokens carry the new envelope, small drops in the peak don't re-issue tokens
+
+	state.Output.EnvelopeKbpsUp = 500
+	state.Output.EnvelopeKbpsDown = 1024
+	state.Output.BandwidthPeakKbpsUp = 350
+
+	assert.False(t, handlers.SessionUpdate_EnvelopeChanged(state))
+}
+
+func Test_SessionUpdate_BuildNextTokens_AdaptedEnvelope(t *testing.T) {
+
+	t.Parallel()
+
+	state, secretKeys := createIPv6TokensState("127.0.0.1:5000", "127.0.0.1:40000")
+
+	state.Buyer.RouteShader = core.NewRouteShader()
+	state.Buyer.RouteShader.BandwidthEnvelopeUpKbps = 256
+	state.Buyer.RouteShader.BandwidthEnvelopeDownKbps = 1024
+	state.Buyer.RouteShader.BandwidthEnvelopeMaxUpKbps = 2048
+	state.Buyer.RouteShader.BandwidthEnvelopeMaxDownKbps = 4096
+	state.Buyer.RouteShader.BandwidthEnvelopeHeadroomPercent = 25
+
+	state.Output.BandwidthPeakKbpsUp = 800
+	state.Output.BandwidthPeakKbpsDown = 100
+
+	assert.True(t, handlers.SessionUpdate_BuildNextTokens(state, 1, []int32{0}))
+
+	assert.Equal(t, uint32(1000), state.Output.EnvelopeKbpsUp)
+	assert.Equal(t, uint32(1024), state.Output.EnvelopeKbpsDown)
+
+	const NumTokens = 3
+
+	for i := range NumTokens {
+		index := packets.SDK_EncryptedNextRouteTokenSize * i
+		token := core.RouteToken{}
+		result := core.ReadEncryptedRouteToken(&token, state.Response.Tokens[index:index+packets.SDK_EncryptedNextRouteTokenSize], secretKeys[i])
+		assert.True(t, result)
+		if !result {
+			return
+		}
+		assert.Equal(t, uint32(1000), token.EnvelopeKbpsUp)
+		assert.Equal(t, uint32(1024), token.EnvelopeKbpsDown)
+	}
+}
+
 // --------------------------------------------------------------
 
 func Test_SessionUpdate_BuildNextTokens_PublicAddresses(t *testing.T) {
@@ -1064,6 +1297,106 @@ func Test_SessionUpdate_BuildNextTokens_InternalAddresses(t *testing.T) {
 
 // --------------------------------------------------------------
 
+func createIPv6TokensState(clientAddress string, relayAddress string) (*handlers.SessionUpdateState, [][]byte) {
+
+	state := CreateState()
+
+	routingPublicKey, routingPrivateKey := crypto.Box_KeyPair()
+
+	clientPublicKey, _ := crypto.Box_KeyPair()
+
+	serverPublicKey, _ := crypto.Box_KeyPair()
+
+	state.RelayBackendPublicKey = routingPublicKey
+	state.RelayBackendPrivateKey = routingPrivateKey
+	copy(state.Request.ClientRoutePublicKey[:], clientPublicKey)
+	copy(state.Request.ServerRoutePublicKey[:], serverPublicKey)
+
+	state.Request.ClientAddress = core.ParseAddress(clientAddress)
+
+	serverAddress := core.ParseAddress("127.0.0.1:50000")
+
+	state.From = &serverAddress
+
+	state.Output.SessionId = 0x123457
+	state.Output.SessionVersion = 100
+
+	seller := &db.Seller{Id: 1, Name: "a"}
+
+	relayPublicKey, _ := crypto.Box_KeyPair()
+
+	state.Database.Relays = make([]db.Relay, 1)
+	state.Database.Relays[0] = db.Relay{Id: 1, Name: "a", PublicAddress: core.ParseAddress(relayAddress), Seller: seller, PublicKey: relayPublicKey}
+	state.Database.SellerMap[1] = seller
+	state.Database.RelayMap[1] = &state.Database.Relays[0]
+	state.Database.GenerateRelaySecretKeys(routingPublicKey, routingPrivateKey)
+
+	state.RouteMatrix.RelayIds = []uint64{1}
+
+	secretKeys := make([][]byte, 3)
+	secretKeys[0], _ = crypto.SecretKey_GenerateRemote(routingPublicKey, routingPrivateKey, clientPublicKey)
+	secretKeys[1] = state.Database.RelaySecretKeys[1]
+	secretKeys[2], _ = crypto.SecretKey_GenerateRemote(routingPublicKey, routingPrivateKey, serverPublicKey)
+
+	return state, secretKeys
+}
+
+func Test_SessionUpdate_BuildNextTokens_IPv4(t *testing.T) {
+
+	t.Parallel()
+
+	state, _ := createIPv6TokensState("10.0.0.1:5000", "127.0.0.1:40000")
+
+	state.Request.Version = packets.SDKVersion{Major: 1, Minor: 2, Patch: 13}
+
+	assert.True(t, handlers.SessionUpdate_BuildNextTokens(state, 1, []int32{0}))
+
+	assert.Equal(t, int32(packets.SDK_RouteTypeNew), state.Response.RouteType)
+	assert.Equal(t, 3*packets.SDK_EncryptedNextRouteTokenSize, len(state.Response.Tokens))
+}
+
+func Test_SessionUpdate_BuildNextTokens_IPv6NotSupported(t *testing.T) {
+
+	t.Parallel()
+
+	// no sdk can read ipv6 route tokens yet, including dev builds, so routes that need ipv6 are always vetoed
+
+	addresses := []struct {
+		client string
+		relay  string
+	}{
+		{"10.0.0.1:5000", "[2001:db8::100]:40000"},
+		{"[2001:db8::1]:5000", "[2001:db8::100]:40000"},
+		{"[2001:db8::1]:5000", "127.0.0.1:40000"},
+	}
+
+	versions := []packets.SDKVersion{
+		{Major: 1, Minor: 2, Patch: 12},
+		{Major: 1, Minor: 2, Patch: 13},
+		{Major: 255, Minor: 255, Patch: 255},
+	}
+
+	for _, address := range addresses {
+		for _, version := range versions {
+
+			state, _ := createIPv6TokensState(address.client, address.relay)
+
+			state.Request.Version = version
+
+			state.Output.RouteState.Next = true
+
+			assert.False(t, handlers.SessionUpdate_BuildNextTokens(state, 1, []int32{0}))
+
+			assert.Equal(t, int32(packets.SDK_RouteTypeDirect), state.Response.RouteType)
+			assert.Equal(t, int32(0), state.Response.NumTokens)
+			assert.Equal(t, uint32(100), state.Output.SessionVersion)
+			assert.False(t, state.Output.RouteState.Next)
+			assert.True(t, state.Output.RouteState.Veto)
+			assert.True(t, (state.Error&constants.SessionError_IPv6NotSupported) != 0)
+		}
+	}
+}
+
 func Test_SessionUpdate_BuildContinueTokens(t *testing.T) {
 
 	t.Parallel()
@@ -1463,6 +1796,263 @@ func Test_SessionUpdate_MakeRouteDecision_TakeNetworkNext(t *testing.T) {
 	assert.NotEqual(t, *state.Debug, "")
 }
 
+func createMatchPolicyState() (*handlers.SessionUpdateState, chan common.MatchUpdate) {
+
+	state := CreateState()
+
+	state.Input.RouteState.Next = false
+	state.Request.DirectRTT = 100
+	state.Request.SliceNumber = 100
+	state.Request.SessionId = 0x123457
+	state.Request.MatchId = 0x1000
+	state.BuyerId = 1
+	state.StartTimestamp = 1000
+	state.Debug = new(string)
+
+	routingPublicKey, routingPrivateKey := crypto.Box_KeyPair()
+
+	clientPublicKey, _ := crypto.Box_KeyPair()
+
+	serverPublicKey, _ := crypto.Box_KeyPair()
+
+	state.RelayBackendPublicKey = routingPublicKey
+	state.RelayBackendPrivateKey = routingPrivateKey
+	copy(state.Request.ClientRoutePublicKey[:], clientPublicKey)
+	copy(state.Request.ServerRoutePublicKey[:], serverPublicKey)
+
+	serverAddress := core.ParseAddress("127.0.0.1:50000")
+
+	state.From = &serverAddress
+
+	state.Output.SessionId = 0x123457
+	state.Output.SessionVersion = 100
+
+	// route through relays a -> b -> c costs 24ms
+
+	seller := &db.Seller{Id: 1, Name: "a"}
+
+	state.Database.Relays = make([]db.Relay, 3)
+	for i := range state.Database.Relays {
+		relayPublicKey, _ := crypto.Box_KeyPair()
+		state.Database.Relays[i] = db.Relay{Id: uint64(i + 1), Name: fmt.Sprintf("%c", 'a'+i), PublicAddress: core.ParseAddress(fmt.Sprintf("127.0.0.1:%d", 40000+i)), Seller: seller, PublicKey: relayPublicKey}
+		state.Database.DatacenterMap[uint64(i+1)] = &db.Datacenter{Id: uint64(i + 1)}
+		state.Database.RelayMap[uint64(i+1)] = &state.Database.Relays[i]
+	}
+	state.Database.SellerMap[1] = seller
+	state.Database.GenerateRelaySecretKeys(routingPublicKey, routingPrivateKey)
+
+	costMatrix := make([]uint8, core.TriMatrixLength(3))
+	for i := range costMatrix {
+		costMatrix[i] = 255
+	}
+	costMatrix[core.TriMatrixIndex(0, 1)] = 10
+	costMatrix[core.TriMatrixIndex(1, 2)] = 10
+	costMatrix[core.TriMatrixIndex(0, 2)] = 100
+
+	state.RouteMatrix = generateRouteMatrix([]uint64{1, 2, 3}, costMatrix, []uint64{1, 2, 3}, state.Database)
+
+	state.Buyer.RouteShader = core.NewRouteShader()
+	state.Buyer.RouteShader.MatchPolicy = core.MatchPolicy_CapDisparity
+	state.Buyer.RouteShader.MaxMatchLatencyDisparity = 50
+
+	state.SourceRelays = []int32{0, 1, 2}
+	state.SourceRelayRTT = []int32{1, 100, 100}
+
+	state.DestRelays = []int32{2}
+
+	state.MatchTracker = common.CreateMatchTracker(context.Background(), common.CreateMemoryMatchStore())
+
+	matchUpdates := make(chan common.MatchUpdate, 16)
+	state.MatchUpdateChannel = matchUpdates
+
+	return state, matchUpdates
+}
+
+func updateMatchSession(state *handlers.SessionUpdateState, sessionId uint64, latency int32) {
+	state.MatchTracker.UpdateSession(common.MatchUpdate{Key: common.MatchKey{BuyerId: 1, MatchId: 0x1000}, SessionId: sessionId, Session: common.MatchSession{Latency: latency, LastUpdateTime: 1000}})
+	state.MatchTracker.Flush()
+}
+
+// the session update handler only sends match updates. server_backend batches them into the match tracker
+
+func flushMatchUpdates(state *handlers.SessionUpdateState, matchUpdates chan common.MatchUpdate) {
+	for len(matchUpdates) > 0 {
+		state.MatchTracker.UpdateSession(<-matchUpdates)
+	}
+	state.MatchTracker.Flush()
+}
+
+func Test_SessionUpdate_MakeRouteDecision_MatchPolicyStayDirect(t *testing.T) {
+
+	t.Parallel()
+
+	state, matchUpdates := createMatchPolicyState()
+
+	// the worst session in the match is at 100ms, so a 24ms route is too much of an advantage
+
+	updateMatchSession(state, 1, 40)
+	updateMatchSession(state, 2, 100)
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.True(t, state.MatchPolicyStayDirect)
+	assert.True(t, state.StayDirect)
+	assert.False(t, state.TakeNetworkNext)
+	assert.False(t, state.Output.RouteState.Next)
+	assert.Equal(t, int32(0), state.Output.RouteCost)
+	assert.Equal(t, int32(0), state.Output.RouteNumRelays)
+	assert.Equal(t, int32(0), state.Response.NumTokens)
+
+	// this session is tracked in the match at its direct latency
+
+	flushMatchUpdates(state, matchUpdates)
+
+	latencies := state.MatchTracker.GetMatchLatencies(1000, 1, 0x1000, 2)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	assert.Equal(t, []int32{40, 100}, latencies)
+}
+
+func Test_SessionUpdate_MakeRouteDecision_MatchPolicyTakeNetworkNext(t *testing.T) {
+
+	t.Parallel()
+
+	state, matchUpdates := createMatchPolicyState()
+
+	// the worst session in the match is at 60ms, so a 24ms route is within the allowed disparity
+
+	updateMatchSession(state, 1, 40)
+	updateMatchSession(state, 2, 60)
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.False(t, state.MatchPolicyStayDirect)
+	assert.True(t, state.TakeNetworkNext)
+	assert.True(t, state.Output.RouteState.Next)
+	assert.Equal(t, int32(24), state.Output.RouteCost)
+	assert.Equal(t, int32(3), state.Output.RouteNumRelays)
+
+	// this session is tracked in the match at its predicted next latency
+
+	flushMatchUpdates(state, matchUpdates)
+
+	latencies := state.MatchTracker.GetMatchLatencies(1000, 1, 0x1000, 2)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	assert.Equal(t, []int32{24, 40}, latencies)
+}
+
+func Test_SessionUpdate_MakeRouteDecision_MatchPolicyLeaveNetworkNext(t *testing.T) {
+
+	t.Parallel()
+
+	state, matchUpdates := createMatchPolicyState()
+
+	// the worst session in the match is at 60ms, so this session takes network next
+
+	updateMatchSession(state, 1, 40)
+	updateMatchSession(state, 2, 60)
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.True(t, state.TakeNetworkNext)
+
+	// while it is on network next, a player with 100ms joins the match
+
+	updateMatchSession(state, 3, 100)
+
+	state.Input = state.Output
+	state.Request.Next = true
+	state.Request.NextRTT = 24
+	state.TakeNetworkNext = false
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	// the session leaves network next without a veto, so it can come back if the match changes again
+
+	assert.True(t, state.MatchPolicyLeaveNetworkNext)
+	assert.False(t, state.RouteContinued)
+	assert.False(t, state.Output.RouteState.Next)
+	assert.False(t, state.Output.RouteState.Veto)
+	assert.Equal(t, int32(0), state.Output.RouteCost)
+	assert.Equal(t, int32(0), state.Output.RouteNumRelays)
+
+	flushMatchUpdates(state, matchUpdates)
+
+	latencies := state.MatchTracker.GetMatchLatencies(1000, 1, 0x1000, 3)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	assert.Equal(t, []int32{40, 60, 100}, latencies)
+}
+
+func Test_SessionUpdate_MakeRouteDecision_MatchPolicyStayOnNetworkNext(t *testing.T) {
+
+	t.Parallel()
+
+	state, _ := createMatchPolicyState()
+
+	updateMatchSession(state, 1, 40)
+	updateMatchSession(state, 2, 60)
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.True(t, state.TakeNetworkNext)
+
+	// the match hasn't changed, so the session stays on network next
+
+	state.Input = state.Output
+	state.Request.Next = true
+	state.Request.NextRTT = 24
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.False(t, state.MatchPolicyLeaveNetworkNext)
+	assert.True(t, state.RouteContinued)
+	assert.True(t, state.Output.RouteState.Next)
+	assert.Equal(t, int32(24), state.Output.RouteCost)
+}
+
+func Test_SessionUpdate_MakeRouteDecision_MatchTrackerOnVeto(t *testing.T) {
+
+	t.Parallel()
+
+	state, matchUpdates := createMatchPolicyState()
+
+	// on network next without route relays is vetoed early, but the session is still tracked in its match
+
+	state.Input.RouteState.Next = true
+	state.Input.RouteNumRelays = 0
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.True(t, state.Output.RouteState.Veto)
+
+	flushMatchUpdates(state, matchUpdates)
+
+	latencies := state.MatchTracker.GetMatchLatencies(1000, 1, 0x1000, 0)
+	assert.Equal(t, []int32{100}, latencies)
+}
+
+func Test_SessionUpdate_MakeRouteDecision_MatchPolicyNone(t *testing.T) {
+
+	t.Parallel()
+
+	state, matchUpdates := createMatchPolicyState()
+
+	state.Buyer.RouteShader.MatchPolicy = core.MatchPolicy_None
+
+	// without a match policy the match is never read, so the 24ms route is taken even though the worst session is at 100ms
+
+	updateMatchSession(state, 1, 40)
+	updateMatchSession(state, 2, 100)
+
+	handlers.SessionUpdate_MakeRouteDecision(state)
+
+	assert.False(t, state.MatchPolicyStayDirect)
+	assert.True(t, state.TakeNetworkNext)
+
+	// and the session is not tracked in its match
+
+	assert.Equal(t, 0, len(matchUpdates))
+}
+
 func Test_SessionUpdate_MakeRouteDecision_Aborted(t *testing.T) {
 
 	t.Parallel()
@@ -2957,6 +3547,45 @@ func Test_SessionUpdate_Post_SliceZero(t *testing.T) {
 	handlers.SessionUpdate_Post(state)
 }
 
+func Test_SessionUpdate_Post_SessionDataKeyId(t *testing.T) {
+
+	t.Parallel()
+
+	state := CreateState()
+
+	routingPublicKey, routingPrivateKey := crypto.Box_KeyPair()
+
+	serverBackendPublicKey, serverBackendPrivateKey := crypto.Sign_KeyPair()
+
+	state.RelayBackendPublicKey = routingPublicKey
+	state.RelayBackendPrivateKey = routingPrivateKey
+	state.ServerBackendPublicKey = serverBackendPublicKey
+	state.ServerBackendPrivateKey = serverBackendPrivateKey
+
+	_, oldPrivateKey := crypto.Sign_KeyPair()
+	_, newPrivateKey := crypto.Sign_KeyPair()
+
+	var err error
+	state.SessionDataKeyring, err = crypto.Sign_CreateKeyring([][]byte{newPrivateKey, oldPrivateKey})
+	assert.NoError(t, err)
+
+	from := core.ParseAddress("127.0.0.1:40000")
+	state.From = &from
+	serverBackendAddress := core.ParseAddress("127.0.0.1:50000")
+	state.ServerBackendAddress = &serverBackendAddress
+
+	state.Request.SliceNumber = 0
+
+	handlers.SessionUpdate_Post(state)
+
+	sessionData := state.Response.SessionData[:state.Response.SessionDataBytes]
+
+	keyId, err := packets.SDK_ReadSessionDataKeyId(sessionData)
+	assert.NoError(t, err)
+	assert.Equal(t, state.SessionDataKeyring.KeyIds[0], keyId)
+	assert.True(t, state.SessionDataKeyring.Verify(keyId, sessionData, state.Response.SessionDataSignature[:]))
+}
+
 func Test_SessionUpdate_Post_DurationOnNext(t *testing.T) {
 
 	t.Parallel()
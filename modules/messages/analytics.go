@@ -35,6 +35,11 @@ type AnalyticsSessionUpdateMessage struct {
 	NextPredictedRTT float32 `avro:"next_predicted_rtt"`
 	NextRouteRelays  []int64 `avro:"next_route_relays"`
 
+	EnvelopeKbpsUp       int32 `avro:"envelope_kbps_up"`
+	EnvelopeKbpsDown     int32 `avro:"envelope_kbps_down"`
+	EnvelopeExceededUp   bool  `avro:"envelope_exceeded_up"`
+	EnvelopeExceededDown bool  `avro:"envelope_exceeded_down"`
+
 	// flags
 
 	Next                bool  `avro:"next"`
@@ -111,16 +116,18 @@ type AnalyticsSessionSummaryMessage struct {
 // ----------------------------------------------------------------------------------------
 
 type AnalyticsServerInitMessage struct {
-	Timestamp        int64  `avro:"timestamp"`
-	SDKVersion_Major int32  `avro:"sdk_version_major"`
-	SDKVersion_Minor int32  `avro:"sdk_version_minor"`
-	SDKVersion_Patch int32  `avro:"sdk_version_patch"`
-	BuyerId          int64  `avro:"buyer_id"`
-	MatchId          int64  `avro:"match_id"`
-	DatacenterId     int64  `avro:"datacenter_id"`
-	DatacenterName   string `avro:"datacenter_name"`
-	ServerId         int64  `avro:"server_id"`
-	ServerAddress    string `avro:"server_address"`
+	Timestamp             int64  `avro:"timestamp"`
+	SDKVersion_Major      int32  `avro:"sdk_version_major"`
+	SDKVersion_Minor      int32  `avro:"sdk_version_minor"`
+	SDKVersion_Patch      int32  `avro:"sdk_version_patch"`
+	BuyerId               int64  `avro:"buyer_id"`
+	MatchId               int64  `avro:"match_id"`
+	DatacenterId          int64  `avro:"datacenter_id"`
+	DatacenterName        string `avro:"datacenter_name"`
+	ServerId              int64  `avro:"server_id"`
+	ServerAddress         string `avro:"server_address"`
+	SDKTooOld             bool   `avro:"sdk_too_old"`
+	SDKUpgradeRecommended bool   `avro:"sdk_upgrade_recommended"`
 }
 
 // ----------------------------------------------------------------------------------------
@@ -163,6 +170,13 @@ type AnalyticsRelayUpdateMessage struct {
 	StartTime                 int64   `avro:"start_time"`
 	CurrentTime               int64   `avro:"current_time"`
 	RelayCounters             []int64 `avro:"relay_counters"`
+	CPUPercent                float32 `avro:"cpu_percent"`
+	SoftIRQPercent            float32 `avro:"softirq_percent"`
+	MemoryUsedBytes           int64   `avro:"memory_used_bytes"`
+	MemoryTotalBytes          int64   `avro:"memory_total_bytes"`
+	NICRxDropsPerSecond       float32 `avro:"nic_rx_drops_per_second"`
+	NICTxDropsPerSecond       float32 `avro:"nic_tx_drops_per_second"`
+	XDPDrops                  []int64 `avro:"xdp_drops"`
 }
 
 // ----------------------------------------------------------------------------------------
@@ -231,6 +245,18 @@ type AnalyticsServerRelayPingMessage struct {
 
 // ----------------------------------------------------------------------------------------
 
+type AnalyticsSessionReportMessage struct {
+	Timestamp     int64    `avro:"timestamp"`
+	BuyerId       int64    `avro:"buyer_id"`
+	SessionId     int64    `avro:"session_id"`
+	DatacenterId  int64    `avro:"datacenter_id"`
+	ServerAddress string   `avro:"server_address"`
+	EventCode     int64    `avro:"event_code"`
+	Tags          []string `avro:"tags"`
+}
+
+// ----------------------------------------------------------------------------------------
+
 type AnalyticsRelayToRelayPingMessage struct {
 	Timestamp          int64   `avro:"timestamp"`
 	SourceRelayId      int64   `avro:"source_relay_id"`
@@ -257,3 +257,31 @@ func TestRelayToRelayPingMessage(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestSessionReportMessage(t *testing.T) {
+
+	t.Parallel()
+
+	schemaData, err := os.ReadFile("../../schemas/pubsub/session_report.json")
+	if err != nil {
+		panic(err)
+	}
+
+	schema, err := avro.Parse(string(schemaData))
+	if err != nil {
+		panic(err)
+	}
+
+	in := AnalyticsSessionReportMessage{}
+
+	data, err := avro.Marshal(schema, in)
+	if err != nil {
+		panic(err)
+	}
+
+	out := AnalyticsSessionReportMessage{}
+	err = avro.Unmarshal(schema, data, &out)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -73,6 +73,17 @@ type PortalServerUpdateMessage struct {
 
 // ----------------------------------------------------------------------------------------
 
+type PortalSessionReportMessage struct {
+	Timestamp    uint64
+	BuyerId      uint64
+	SessionId    uint64
+	DatacenterId uint64
+	EventCode    uint32
+	Tags         []string
+}
+
+// ----------------------------------------------------------------------------------------
+
 type PortalSessionUpdateMessage struct {
 	Timestamp uint64
 
@@ -146,6 +157,7 @@ type PortalSessionUpdateMessage struct {
 	Retry            bool
 	FallbackToDirect bool
 	SendToPortal     bool
+	RouteChanged     bool
 }
 
 // ----------------------------------------------------------------------------------------
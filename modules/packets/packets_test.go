@@ -88,6 +88,24 @@ func TestVersionAtLeast(t *testing.T) {
 	})
 }
 
+func TestParseSDKVersion(t *testing.T) {
+
+	t.Parallel()
+
+	version, err := packets.ParseSDKVersion("1.2.11")
+	assert.Nil(t, err)
+	assert.Equal(t, packets.SDKVersion{1, 2, 11}, version)
+
+	_, err = packets.ParseSDKVersion("1.2")
+	assert.NotNil(t, err)
+
+	_, err = packets.ParseSDKVersion("1.2.x")
+	assert.NotNil(t, err)
+
+	_, err = packets.ParseSDKVersion("1.2.256")
+	assert.NotNil(t, err)
+}
+
 // -------------------------------------------------------------------------
 
 func PacketSerializationTest[P packets.Packet](writePacket P, readPacket P, t *testing.T) {
@@ -125,8 +143,10 @@ func GenerateRandomServerInitRequestPacket() packets.SDK_ServerInitRequestPacket
 func GenerateRandomServerInitResponsePacket() packets.SDK_ServerInitResponsePacket {
 
 	packet := packets.SDK_ServerInitResponsePacket{
-		RequestId: rand.Uint64(),
-		Response:  uint32(common.RandomInt(0, 255)),
+		Version:            packets.SDKVersion{1, 2, 13},
+		RequestId:          rand.Uint64(),
+		Response:           uint32(common.RandomInt(0, 255)),
+		UpgradeRecommended: common.RandomBool(),
 	}
 
 	common.RandomBytes(packet.UpcomingMagic[:])
@@ -223,6 +243,33 @@ func GenerateRandomServerRelayResponsePacket() packets.SDK_ServerRelayResponsePa
 	return packet
 }
 
+func GenerateRandomSessionReportRequestPacket() packets.SDK_SessionReportRequestPacket {
+
+	packet := packets.SDK_SessionReportRequestPacket{
+		Version:      packets.SDKVersion{1, 0, 0},
+		BuyerId:      rand.Uint64(),
+		DatacenterId: rand.Uint64(),
+		SessionId:    rand.Uint64(),
+		RequestId:    rand.Uint64(),
+		EventCode:    rand.Uint32(),
+		NumTags:      int32(common.RandomInt(0, packets.SDK_MaxSessionReportTags)),
+	}
+
+	for i := 0; i < int(packet.NumTags); i++ {
+		packet.Tags[i] = common.RandomString(packets.SDK_MaxSessionReportTagSize)
+	}
+
+	return packet
+}
+
+func GenerateRandomSessionReportResponsePacket() packets.SDK_SessionReportResponsePacket {
+
+	return packets.SDK_SessionReportResponsePacket{
+		SessionId: rand.Uint64(),
+		RequestId: rand.Uint64(),
+	}
+}
+
 func GenerateRandomSessionUpdateRequestPacket() packets.SDK_SessionUpdateRequestPacket {
 
 	packet := packets.SDK_SessionUpdateRequestPacket{
@@ -372,7 +419,7 @@ func Test_SDK_ServerInitResponsePacket(t *testing.T) {
 
 		writePacket := GenerateRandomServerInitResponsePacket()
 
-		readPacket := packets.SDK_ServerInitResponsePacket{}
+		readPacket := packets.SDK_ServerInitResponsePacket{Version: writePacket.Version}
 
 		PacketSerializationTest[*packets.SDK_ServerInitResponsePacket](&writePacket, &readPacket, t)
 	}
@@ -462,6 +509,34 @@ func Test_SDK_ServerRelayResponsePacket(t *testing.T) {
 	}
 }
 
+func Test_SDK_SessionReportRequestPacket(t *testing.T) {
+
+	t.Parallel()
+
+	for range NumIterations {
+
+		writePacket := GenerateRandomSessionReportRequestPacket()
+
+		readPacket := packets.SDK_SessionReportRequestPacket{}
+
+		PacketSerializationTest[*packets.SDK_SessionReportRequestPacket](&writePacket, &readPacket, t)
+	}
+}
+
+func Test_SDK_SessionReportResponsePacket(t *testing.T) {
+
+	t.Parallel()
+
+	for range NumIterations {
+
+		writePacket := GenerateRandomSessionReportResponsePacket()
+
+		readPacket := packets.SDK_SessionReportResponsePacket{}
+
+		PacketSerializationTest[*packets.SDK_SessionReportResponsePacket](&writePacket, &readPacket, t)
+	}
+}
+
 func Test_SDK_SessionUpdateRequestPacket(t *testing.T) {
 
 	t.Parallel()
@@ -523,6 +598,9 @@ func GenerateRandomRelayUpdateRequestPacket() packets.RelayUpdateRequestPacket {
 		packet.SampleRTT[i] = uint8(common.RandomInt(0, 255))
 		packet.SampleJitter[i] = uint8(common.RandomInt(0, 255))
 		packet.SamplePacketLoss[i] = uint16(common.RandomInt(0, 65535))
+		if packet.Version >= packets.RelayUpdateRequestPacket_VersionMTU {
+			packet.SampleMTU[i] = uint16(common.RandomInt(0, constants.MaxPacketBytes))
+		}
 	}
 
 	packet.SessionCount = rand.Uint32()
@@ -544,6 +622,18 @@ func GenerateRandomRelayUpdateRequestPacket() packets.RelayUpdateRequestPacket {
 		packet.RelayCounters[i] = rand.Uint64()
 	}
 
+	if packet.Version >= packets.RelayUpdateRequestPacket_VersionHostMetrics {
+		packet.HostMetrics.CPUPercent = float32(common.RandomInt(0, 100))
+		packet.HostMetrics.SoftIRQPercent = float32(common.RandomInt(0, 100))
+		packet.HostMetrics.MemoryUsedBytes = rand.Uint64()
+		packet.HostMetrics.MemoryTotalBytes = rand.Uint64()
+		packet.HostMetrics.NICRxDropsPerSecond = float32(common.RandomInt(0, 1000))
+		packet.HostMetrics.NICTxDropsPerSecond = float32(common.RandomInt(0, 1000))
+		for i := range constants.NumRelayXDPDropReasons {
+			packet.HostMetrics.XDPDrops[i] = rand.Uint64()
+		}
+	}
+
 	return packet
 }
 
@@ -600,6 +690,139 @@ func TestRelayUpdateResponsePacket(t *testing.T) {
 	}
 }
 
+func TestRelayUpdateDatagrams(t *testing.T) {
+	t.Parallel()
+	for range NumRelayPacketIterations {
+
+		data := make([]byte, rand.Intn(packets.RelayUpdateDatagram_MaxBytes)+1)
+		common.RandomBytes(data)
+
+		messageId := rand.Uint64()
+
+		datagrams, err := packets.WriteRelayUpdateDatagrams(messageId, data)
+		assert.Nil(t, err)
+
+		// fragments can arrive in any order, and duplicated
+
+		rand.Shuffle(len(datagrams), func(i, j int) { datagrams[i], datagrams[j] = datagrams[j], datagrams[i] })
+
+		reassembler := packets.CreateRelayUpdateReassembler(5, 16)
+
+		for i := range datagrams {
+			assert.LessOrEqual(t, len(datagrams[i]), packets.RelayUpdateDatagram_HeaderBytes+packets.RelayUpdateDatagram_MaxFragmentBytes)
+			readMessageId, readData, complete, err := reassembler.AddDatagram("127.0.0.1:40000", datagrams[i], 0)
+			assert.Nil(t, err)
+			if i > 0 {
+				_, _, duplicateComplete, err := reassembler.AddDatagram("127.0.0.1:40000", datagrams[i-1], 0)
+				assert.Nil(t, err)
+				assert.False(t, duplicateComplete)
+			}
+			assert.Equal(t, i == len(datagrams)-1, complete)
+			if complete {
+				assert.Equal(t, messageId, readMessageId)
+				assert.Equal(t, data, readData)
+			}
+		}
+	}
+}
+
+func TestRelayUpdateDatagrams_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := packets.WriteRelayUpdateDatagrams(0, []byte{})
+	assert.NotNil(t, err)
+
+	_, err = packets.WriteRelayUpdateDatagrams(0, make([]byte, packets.RelayUpdateDatagram_MaxBytes+1))
+	assert.NotNil(t, err)
+
+	datagrams, err := packets.WriteRelayUpdateDatagrams(0, make([]byte, 5000))
+	assert.Nil(t, err)
+
+	reassembler := packets.CreateRelayUpdateReassembler(5, 1)
+
+	_, _, _, err = reassembler.AddDatagram("a", datagrams[0][:packets.RelayUpdateDatagram_HeaderBytes], 0)
+	assert.NotNil(t, err)
+
+	// only one message may be pending, and it times out
+
+	_, _, _, err = reassembler.AddDatagram("a", datagrams[0], 0)
+	assert.Nil(t, err)
+
+	_, _, _, err = reassembler.AddDatagram("b", datagrams[0], 0)
+	assert.NotNil(t, err)
+
+	_, _, _, err = reassembler.AddDatagram("b", datagrams[0], 10)
+	assert.Nil(t, err)
+}
+
+func TestRelayUpdateBatch(t *testing.T) {
+	t.Parallel()
+	for range NumRelayPacketIterations {
+
+		updates := make([][]byte, rand.Intn(100))
+		for i := range updates {
+			updates[i] = make([]byte, rand.Intn(1000))
+			common.RandomBytes(updates[i])
+		}
+
+		readUpdates, err := packets.ReadRelayUpdateBatch(packets.WriteRelayUpdateBatch(updates))
+		assert.Nil(t, err)
+		assert.Equal(t, len(updates), len(readUpdates))
+		for i := range updates {
+			assert.Equal(t, updates[i], readUpdates[i])
+		}
+
+		if len(updates) > 0 {
+			data := packets.WriteRelayUpdateBatch(updates)
+			_, err = packets.ReadRelayUpdateBatch(data[:len(data)-1-len(updates[len(updates)-1])])
+			assert.NotNil(t, err)
+		}
+	}
+}
+
+func TestRelayForward(t *testing.T) {
+	t.Parallel()
+
+	key := packets.GetRelayForwardKey([]byte("relay backend private key"))
+	otherKey := packets.GetRelayForwardKey([]byte("some other key"))
+
+	data := make([]byte, 1000)
+	common.RandomBytes(data)
+
+	message := packets.SignRelayForward(key, packets.RelayForwardMessage_RelayUpdate, 1000, data)
+
+	readData, err := packets.VerifyRelayForward(key, packets.RelayForwardMessage_RelayUpdate, 1005, message)
+	assert.Nil(t, err)
+	assert.Equal(t, data, readData)
+
+	// wrong key, wrong message type, stale, and tampered messages are all rejected
+
+	_, err = packets.VerifyRelayForward(otherKey, packets.RelayForwardMessage_RelayUpdate, 1005, message)
+	assert.NotNil(t, err)
+
+	_, err = packets.VerifyRelayForward(key, packets.RelayForwardMessage_RelayUpdates, 1005, message)
+	assert.NotNil(t, err)
+
+	_, err = packets.VerifyRelayForward(key, packets.RelayForwardMessage_RelayUpdate, 1000+packets.RelayForward_MaxAge+1, message)
+	assert.NotNil(t, err)
+
+	_, err = packets.VerifyRelayForward(key, packets.RelayForwardMessage_RelayUpdate, 1005, data)
+	assert.NotNil(t, err)
+
+	message[100] ^= 1
+	_, err = packets.VerifyRelayForward(key, packets.RelayForwardMessage_RelayUpdate, 1005, message)
+	assert.NotNil(t, err)
+
+	// relay backend registrations
+
+	value := packets.SignRelayBackendRegistration(key, "10.0.0.1:80", 100)
+	assert.True(t, packets.VerifyRelayBackendRegistration(key, "10.0.0.1:80", 100, value))
+	assert.False(t, packets.VerifyRelayBackendRegistration(key, "10.0.0.2:80", 100, value))
+	assert.False(t, packets.VerifyRelayBackendRegistration(key, "10.0.0.1:80", 101, value))
+	assert.False(t, packets.VerifyRelayBackendRegistration(otherKey, "10.0.0.1:80", 100, value))
+	assert.False(t, packets.VerifyRelayBackendRegistration(key, "10.0.0.1:80", 100, "1"))
+}
+
 // ------------------------------------------------------------------
 
 const NumSessionDataIterations = 1000
@@ -613,4 +836,41 @@ func TestSessionUpdate(t *testing.T) {
 	}
 }
 
+func TestSessionDataKeyId(t *testing.T) {
+
+	t.Parallel()
+
+	writeSessionData := func(sessionData *packets.SDK_SessionData) []byte {
+		buffer := make([]byte, packets.SDK_MaxSessionDataSize)
+		writeStream := serialize.NewWriteStream(buffer)
+		assert.Nil(t, sessionData.Serialize(writeStream))
+		writeStream.Flush()
+		return buffer[:writeStream.BytesProcessed()]
+	}
+
+	sessionData := packets.GenerateRandomSessionData()
+	sessionData.Version = packets.SDK_SessionDataVersion_Write
+	sessionData.KeyId = 0x12345678
+
+	keyId, err := packets.SDK_ReadSessionDataKeyId(writeSessionData(&sessionData))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x12345678), keyId)
+
+	// session data from before key ids
+
+	sessionData.Version = 9
+
+	keyId, err = packets.SDK_ReadSessionDataKeyId(writeSessionData(&sessionData))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), keyId)
+
+	// garbage
+
+	_, err = packets.SDK_ReadSessionDataKeyId([]byte{})
+	assert.NotNil(t, err)
+
+	_, err = packets.SDK_ReadSessionDataKeyId([]byte{255, 0, 0, 0, 0, 0, 0, 0})
+	assert.NotNil(t, err)
+}
+
 // ------------------------------------------------------------------
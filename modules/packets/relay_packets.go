@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/networknext/next/modules/common"
 	"github.com/networknext/next/modules/constants"
 	"github.com/networknext/next/modules/crypto"
 	"github.com/networknext/next/modules/encoding"
@@ -12,8 +13,11 @@ import (
 
 const (
 	RelayUpdateRequestPacket_VersionMin   = 1
-	RelayUpdateRequestPacket_VersionMax   = 1
-	RelayUpdateRequestPacket_VersionWrite = 1
+	RelayUpdateRequestPacket_VersionMax   = 3
+	RelayUpdateRequestPacket_VersionWrite = 3
+
+	RelayUpdateRequestPacket_VersionHostMetrics = 2
+	RelayUpdateRequestPacket_VersionMTU         = 3
 
 	RelayUpdateResponsePacket_VersionMin   = 1
 	RelayUpdateResponsePacket_VersionMax   = 1
@@ -39,6 +43,7 @@ type RelayUpdateRequestPacket struct {
 	SampleRTT                 [constants.MaxRelays]uint8  // [0,255] milliseconds
 	SampleJitter              [constants.MaxRelays]uint8  // [0,255] milliseconds
 	SamplePacketLoss          [constants.MaxRelays]uint16 // [0,65535] -> [0%,100%]
+	SampleMTU                 [constants.MaxRelays]uint16 // version 3+. path mtu in relay packet bytes, 0 if not known yet
 	SessionCount              uint32
 	EnvelopeBandwidthUpKbps   uint32
 	EnvelopeBandwidthDownKbps uint32
@@ -53,6 +58,25 @@ type RelayUpdateRequestPacket struct {
 	RelayVersion              string
 	NumRelayCounters          uint32
 	RelayCounters             [constants.NumRelayCounters]uint64
+	HostMetrics               common.RelayHostMetrics // version 2+
+}
+
+// GetHostMetrics returns nil for relays that are too old to send host metrics
+
+func (packet *RelayUpdateRequestPacket) GetHostMetrics() *common.RelayHostMetrics {
+	if packet.Version < RelayUpdateRequestPacket_VersionHostMetrics {
+		return nil
+	}
+	return &packet.HostMetrics
+}
+
+// GetSampleMTU returns nil for relays that are too old to probe path mtu
+
+func (packet *RelayUpdateRequestPacket) GetSampleMTU() []uint16 {
+	if packet.Version < RelayUpdateRequestPacket_VersionMTU {
+		return nil
+	}
+	return packet.SampleMTU[:packet.NumSamples]
 }
 
 func (packet *RelayUpdateRequestPacket) Write(buffer []byte) []byte {
@@ -74,6 +98,9 @@ func (packet *RelayUpdateRequestPacket) Write(buffer []byte) []byte {
 		encoding.WriteUint8(buffer, &index, packet.SampleRTT[i])
 		encoding.WriteUint8(buffer, &index, packet.SampleJitter[i])
 		encoding.WriteUint16(buffer, &index, packet.SamplePacketLoss[i])
+		if packet.Version >= RelayUpdateRequestPacket_VersionMTU {
+			encoding.WriteUint16(buffer, &index, packet.SampleMTU[i])
+		}
 	}
 
 	encoding.WriteUint32(buffer, &index, packet.SessionCount)
@@ -95,6 +122,19 @@ func (packet *RelayUpdateRequestPacket) Write(buffer []byte) []byte {
 		encoding.WriteUint64(buffer, &index, packet.RelayCounters[i])
 	}
 
+	if packet.Version >= RelayUpdateRequestPacket_VersionHostMetrics {
+		encoding.WriteFloat32(buffer, &index, packet.HostMetrics.CPUPercent)
+		encoding.WriteFloat32(buffer, &index, packet.HostMetrics.SoftIRQPercent)
+		encoding.WriteUint64(buffer, &index, packet.HostMetrics.MemoryUsedBytes)
+		encoding.WriteUint64(buffer, &index, packet.HostMetrics.MemoryTotalBytes)
+		encoding.WriteFloat32(buffer, &index, packet.HostMetrics.NICRxDropsPerSecond)
+		encoding.WriteFloat32(buffer, &index, packet.HostMetrics.NICTxDropsPerSecond)
+		encoding.WriteUint32(buffer, &index, constants.NumRelayXDPDropReasons)
+		for i := range constants.NumRelayXDPDropReasons {
+			encoding.WriteUint64(buffer, &index, packet.HostMetrics.XDPDrops[i])
+		}
+	}
+
 	return buffer[:index]
 }
 
@@ -145,6 +185,12 @@ func (packet *RelayUpdateRequestPacket) Read(buffer []byte) error {
 		if !encoding.ReadUint16(buffer, &index, &packet.SamplePacketLoss[i]) {
 			return errors.New("could not read sample packet loss")
 		}
+
+		if packet.Version >= RelayUpdateRequestPacket_VersionMTU {
+			if !encoding.ReadUint16(buffer, &index, &packet.SampleMTU[i]) {
+				return errors.New("could not read sample mtu")
+			}
+		}
 	}
 
 	if !encoding.ReadUint32(buffer, &index, &packet.SessionCount) {
@@ -209,6 +255,48 @@ func (packet *RelayUpdateRequestPacket) Read(buffer []byte) error {
 		}
 	}
 
+	if packet.Version >= RelayUpdateRequestPacket_VersionHostMetrics {
+
+		if !encoding.ReadFloat32(buffer, &index, &packet.HostMetrics.CPUPercent) {
+			return errors.New("could not read cpu percent")
+		}
+
+		if !encoding.ReadFloat32(buffer, &index, &packet.HostMetrics.SoftIRQPercent) {
+			return errors.New("could not read softirq percent")
+		}
+
+		if !encoding.ReadUint64(buffer, &index, &packet.HostMetrics.MemoryUsedBytes) {
+			return errors.New("could not read memory used bytes")
+		}
+
+		if !encoding.ReadUint64(buffer, &index, &packet.HostMetrics.MemoryTotalBytes) {
+			return errors.New("could not read memory total bytes")
+		}
+
+		if !encoding.ReadFloat32(buffer, &index, &packet.HostMetrics.NICRxDropsPerSecond) {
+			return errors.New("could not read nic rx drops per-second")
+		}
+
+		if !encoding.ReadFloat32(buffer, &index, &packet.HostMetrics.NICTxDropsPerSecond) {
+			return errors.New("could not read nic tx drops per-second")
+		}
+
+		var numXDPDropReasons uint32
+		if !encoding.ReadUint32(buffer, &index, &numXDPDropReasons) {
+			return errors.New("could not read num xdp drop reasons")
+		}
+
+		if numXDPDropReasons != constants.NumRelayXDPDropReasons {
+			return fmt.Errorf("wrong number of xdp drop reasons. expected %d, got %d", constants.NumRelayXDPDropReasons, numXDPDropReasons)
+		}
+
+		for i := range constants.NumRelayXDPDropReasons {
+			if !encoding.ReadUint64(buffer, &index, &packet.HostMetrics.XDPDrops[i]) {
+				return errors.New("could not read xdp drops")
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,300 @@
+package packets
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/networknext/next/modules/crypto"
+	"github.com/networknext/next/modules/encoding"
+)
+
+// Relay updates may be sent to the relay gateway as UDP datagrams instead of HTTP POST. The datagrams carry exactly
+// the same bytes as the HTTP body, split into fragments so each datagram stays below the MTU:
+//
+//	[version uint8] [message id uint64] [fragment index uint8] [num fragments uint8] [fragment data]
+//
+// The relay gateway sends the relay update response back the same way, with the same message id.
+
+const (
+	RelayUpdateDatagram_Version          = 1
+	RelayUpdateDatagram_HeaderBytes      = 1 + 8 + 1 + 1
+	RelayUpdateDatagram_MaxFragmentBytes = 1200
+	RelayUpdateDatagram_MaxFragments     = 64
+	RelayUpdateDatagram_MaxBytes         = RelayUpdateDatagram_MaxFragmentBytes * RelayUpdateDatagram_MaxFragments
+)
+
+func WriteRelayUpdateDatagrams(messageId uint64, data []byte) ([][]byte, error) {
+
+	numFragments := (len(data) + RelayUpdateDatagram_MaxFragmentBytes - 1) / RelayUpdateDatagram_MaxFragmentBytes
+
+	if numFragments == 0 || numFragments > RelayUpdateDatagram_MaxFragments {
+		return nil, fmt.Errorf("relay update is %d bytes, which can't be sent as datagrams", len(data))
+	}
+
+	datagrams := make([][]byte, numFragments)
+
+	for i := range numFragments {
+		begin := i * RelayUpdateDatagram_MaxFragmentBytes
+		end := min(begin+RelayUpdateDatagram_MaxFragmentBytes, len(data))
+		datagram := make([]byte, RelayUpdateDatagram_HeaderBytes+end-begin)
+		index := 0
+		encoding.WriteUint8(datagram, &index, RelayUpdateDatagram_Version)
+		encoding.WriteUint64(datagram, &index, messageId)
+		encoding.WriteUint8(datagram, &index, uint8(i))
+		encoding.WriteUint8(datagram, &index, uint8(numFragments))
+		copy(datagram[index:], data[begin:end])
+		datagrams[i] = datagram
+	}
+
+	return datagrams, nil
+}
+
+func ReadRelayUpdateDatagram(datagram []byte) (messageId uint64, fragmentIndex int, numFragments int, fragmentData []byte, err error) {
+
+	if len(datagram) <= RelayUpdateDatagram_HeaderBytes || len(datagram) > RelayUpdateDatagram_HeaderBytes+RelayUpdateDatagram_MaxFragmentBytes {
+		return 0, 0, 0, nil, errors.New("relay update datagram has invalid size")
+	}
+
+	index := 0
+
+	var version, fragmentIndexValue, numFragmentsValue uint8
+
+	encoding.ReadUint8(datagram, &index, &version)
+	encoding.ReadUint64(datagram, &index, &messageId)
+	encoding.ReadUint8(datagram, &index, &fragmentIndexValue)
+	encoding.ReadUint8(datagram, &index, &numFragmentsValue)
+
+	if version != RelayUpdateDatagram_Version {
+		return 0, 0, 0, nil, fmt.Errorf("invalid relay update datagram version: %d", version)
+	}
+
+	if numFragmentsValue == 0 || numFragmentsValue > RelayUpdateDatagram_MaxFragments || fragmentIndexValue >= numFragmentsValue {
+		return 0, 0, 0, nil, fmt.Errorf("invalid relay update datagram fragment %d/%d", fragmentIndexValue, numFragmentsValue)
+	}
+
+	return messageId, int(fragmentIndexValue), int(numFragmentsValue), datagram[index:], nil
+}
+
+// ----------------------------------------------------------------------------------------------
+
+type relayUpdateMessage struct {
+	createTime   int64
+	numReceived  int
+	fragments    [][]byte
+	numFragments int
+}
+
+// RelayUpdateReassembler collects relay update datagram fragments until a whole message has arrived.
+// Incomplete messages are dropped after the timeout, and the number of pending messages is capped.
+
+type RelayUpdateReassembler struct {
+	mutex       sync.Mutex
+	timeout     int64
+	maxMessages int
+	lastCleanup int64
+	messages    map[string]*relayUpdateMessage
+}
+
+func CreateRelayUpdateReassembler(timeout int64, maxMessages int) *RelayUpdateReassembler {
+	return &RelayUpdateReassembler{
+		timeout:     timeout,
+		maxMessages: maxMessages,
+		messages:    make(map[string]*relayUpdateMessage),
+	}
+}
+
+// AddDatagram returns the whole message and true once its last fragment has arrived. The reassembler keeps a reference
+// to the datagram until then, so callers must not reuse its buffer.
+
+func (reassembler *RelayUpdateReassembler) AddDatagram(from string, datagram []byte, currentTime int64) (uint64, []byte, bool, error) {
+
+	messageId, fragmentIndex, numFragments, fragmentData, err := ReadRelayUpdateDatagram(datagram)
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	if numFragments == 1 {
+		return messageId, fragmentData, true, nil
+	}
+
+	key := fmt.Sprintf("%s-%016x", from, messageId)
+
+	reassembler.mutex.Lock()
+	defer reassembler.mutex.Unlock()
+
+	if reassembler.lastCleanup != currentTime {
+		for k, v := range reassembler.messages {
+			if v.createTime+reassembler.timeout < currentTime {
+				delete(reassembler.messages, k)
+			}
+		}
+		reassembler.lastCleanup = currentTime
+	}
+
+	message, exists := reassembler.messages[key]
+	if !exists {
+		if len(reassembler.messages) >= reassembler.maxMessages {
+			return 0, nil, false, errors.New("too many pending relay update messages")
+		}
+		message = &relayUpdateMessage{createTime: currentTime, fragments: make([][]byte, numFragments), numFragments: numFragments}
+		reassembler.messages[key] = message
+	}
+
+	if message.numFragments != numFragments {
+		return 0, nil, false, errors.New("relay update datagram fragment count mismatch")
+	}
+
+	if message.fragments[fragmentIndex] != nil {
+		return 0, nil, false, nil // duplicate
+	}
+
+	message.fragments[fragmentIndex] = fragmentData
+	message.numReceived++
+
+	if message.numReceived < message.numFragments {
+		return 0, nil, false, nil
+	}
+
+	delete(reassembler.messages, key)
+
+	size := 0
+	for i := range message.fragments {
+		size += len(message.fragments[i])
+	}
+
+	data := make([]byte, 0, size)
+	for i := range message.fragments {
+		data = append(data, message.fragments[i]...)
+	}
+
+	return messageId, data, true, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+
+// The relay gateway forwards decrypted relay updates to the relay backends in batches, so one request carries many updates:
+//
+//	[num updates uint32] ([update bytes uint32] [update data])...
+
+func WriteRelayUpdateBatch(updates [][]byte) []byte {
+	size := 4
+	for i := range updates {
+		size += 4 + len(updates[i])
+	}
+	data := make([]byte, size)
+	index := 0
+	encoding.WriteUint32(data, &index, uint32(len(updates)))
+	for i := range updates {
+		encoding.WriteUint32(data, &index, uint32(len(updates[i])))
+		encoding.WriteBytes(data, &index, updates[i], len(updates[i]))
+	}
+	return data
+}
+
+func ReadRelayUpdateBatch(data []byte) ([][]byte, error) {
+	index := 0
+	var numUpdates uint32
+	if !encoding.ReadUint32(data, &index, &numUpdates) {
+		return nil, errors.New("could not read num relay updates")
+	}
+	if int(numUpdates) > len(data)/4 {
+		return nil, fmt.Errorf("invalid num relay updates: %d", numUpdates)
+	}
+	updates := make([][]byte, numUpdates)
+	for i := range updates {
+		var updateBytes uint32
+		if !encoding.ReadUint32(data, &index, &updateBytes) {
+			return nil, errors.New("could not read relay update size")
+		}
+		if int(updateBytes) > len(data)-index {
+			return nil, errors.New("relay update is truncated")
+		}
+		updates[i] = data[index : index+int(updateBytes)]
+		index += int(updateBytes)
+	}
+	return updates, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+
+// Everything the relay gateway forwards to the relay backends is MAC'd with a key derived from the relay backend private key,
+// which only the relay gateway and relay backend have. The relay backend rejects anything that isn't signed, so nothing else
+// inside the VPC can inject relay updates:
+//
+//	[message type uint8] [timestamp uint64] [data] [signature]
+//
+// Relay backends also sign their registration in redis with the same key, so the relay gateway only forwards to real relay backends.
+
+const (
+	RelayForwardMessage_RelayUpdate  = 1
+	RelayForwardMessage_RelayUpdates = 2
+
+	RelayForward_HeaderBytes = 1 + 8
+	RelayForward_MaxAge      = 10
+)
+
+func GetRelayForwardKey(relayBackendPrivateKey []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("relay forward key"))
+	hash.Write(relayBackendPrivateKey)
+	return hash.Sum(nil)[:crypto.Auth_KeySize]
+}
+
+func SignRelayForward(key []byte, messageType uint8, timestamp uint64, data []byte) []byte {
+	message := make([]byte, RelayForward_HeaderBytes+len(data)+crypto.Auth_SignatureSize)
+	index := 0
+	encoding.WriteUint8(message, &index, messageType)
+	encoding.WriteUint64(message, &index, timestamp)
+	copy(message[index:], data)
+	signedBytes := len(message) - crypto.Auth_SignatureSize
+	crypto.Auth_Sign(message[:signedBytes], key, message[signedBytes:])
+	return message
+}
+
+func VerifyRelayForward(key []byte, messageType uint8, currentTime uint64, message []byte) ([]byte, error) {
+
+	if len(message) < RelayForward_HeaderBytes+crypto.Auth_SignatureSize {
+		return nil, errors.New("relay forward message is too small")
+	}
+
+	signedBytes := len(message) - crypto.Auth_SignatureSize
+
+	if !crypto.Auth_Verify(message[:signedBytes], key, message[signedBytes:]) {
+		return nil, errors.New("relay forward message signature did not verify")
+	}
+
+	index := 0
+	var messageTypeValue uint8
+	var timestamp uint64
+	encoding.ReadUint8(message, &index, &messageTypeValue)
+	encoding.ReadUint64(message, &index, &timestamp)
+
+	if messageTypeValue != messageType {
+		return nil, fmt.Errorf("unexpected relay forward message type: %d", messageTypeValue)
+	}
+
+	if timestamp+RelayForward_MaxAge < currentTime || timestamp > currentTime+RelayForward_MaxAge {
+		return nil, fmt.Errorf("relay forward message timestamp is out of range: %d", timestamp)
+	}
+
+	return message[index:signedBytes], nil
+}
+
+// The relay backend registration in redis is "address" -> base64 signature of "address-minutes"
+
+func SignRelayBackendRegistration(key []byte, address string, minutes int64) string {
+	signature := make([]byte, crypto.Auth_SignatureSize)
+	crypto.Auth_Sign([]byte(fmt.Sprintf("%s-%d", address, minutes)), key, signature)
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func VerifyRelayBackendRegistration(key []byte, address string, minutes int64, value string) bool {
+	signature, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(signature) != crypto.Auth_SignatureSize {
+		return false
+	}
+	return crypto.Auth_Verify([]byte(fmt.Sprintf("%s-%d", address, minutes)), key, signature)
+}
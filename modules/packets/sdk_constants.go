@@ -11,8 +11,8 @@ const (
 	SDK_MaxPacketBytes = constants.MaxPacketBytes
 
 	SDK_SessionDataVersion_Min   = 1
-	SDK_SessionDataVersion_Max   = 8
-	SDK_SessionDataVersion_Write = 8
+	SDK_SessionDataVersion_Max   = 10
+	SDK_SessionDataVersion_Write = 10
 
 	SDK_SERVER_INIT_REQUEST_PACKET     = 50
 	SDK_SERVER_INIT_RESPONSE_PACKET    = 51
@@ -24,6 +24,8 @@ const (
 	SDK_CLIENT_RELAY_RESPONSE_PACKET   = 57
 	SDK_SERVER_RELAY_REQUEST_PACKET    = 58
 	SDK_SERVER_RELAY_RESPONSE_PACKET   = 59
+	SDK_SESSION_REPORT_REQUEST_PACKET  = 60
+	SDK_SESSION_REPORT_RESPONSE_PACKET = 61
 
 	SDK_MaxDatacenterNameLength = 256
 	SDK_MaxSessionDataSize      = 256
@@ -33,6 +35,8 @@ const (
 	SDK_MaxServerRelays         = int(constants.MaxServerRelays)
 	SDK_MaxDestRelays           = int(constants.MaxDestRelays)
 	SDK_MaxSessionUpdateRetries = 10
+	SDK_MaxSessionReportTags    = 8
+	SDK_MaxSessionReportTagSize = 64
 
 	SDK_ServerInitResponseOK                   = 0
 	SDK_ServerInitResponseUnknownBuyer         = 1
@@ -82,11 +82,13 @@ func (packet *SDK_ServerInitRequestPacket) Serialize(stream serialize.Stream) er
 // ------------------------------------------------------------
 
 type SDK_ServerInitResponsePacket struct {
-	RequestId     uint64
-	Response      uint32
-	UpcomingMagic [8]byte
-	CurrentMagic  [8]byte
-	PreviousMagic [8]byte
+	Version            SDKVersion // version of the SDK the response is for. not serialized
+	RequestId          uint64
+	Response           uint32
+	UpcomingMagic      [8]byte
+	CurrentMagic       [8]byte
+	PreviousMagic      [8]byte
+	UpgradeRecommended bool
 }
 
 func (packet *SDK_ServerInitResponsePacket) Serialize(stream serialize.Stream) error {
@@ -95,6 +97,9 @@ func (packet *SDK_ServerInitResponsePacket) Serialize(stream serialize.Stream) e
 	stream.SerializeBytes(packet.UpcomingMagic[:])
 	stream.SerializeBytes(packet.CurrentMagic[:])
 	stream.SerializeBytes(packet.PreviousMagic[:])
+	if core.ProtocolVersionAtLeast(uint32(packet.Version.Major), uint32(packet.Version.Minor), uint32(packet.Version.Patch), 1, 2, 13) {
+		stream.SerializeBool(&packet.UpgradeRecommended)
+	}
 	return stream.Err()
 }
 
@@ -246,6 +251,46 @@ func (packet *SDK_ServerRelayResponsePacket) Serialize(stream serialize.Stream)
 
 // ------------------------------------------------------------
 
+type SDK_SessionReportRequestPacket struct {
+	Version      SDKVersion
+	BuyerId      uint64
+	DatacenterId uint64
+	SessionId    uint64
+	RequestId    uint64
+	EventCode    uint32
+	NumTags      int32
+	Tags         [SDK_MaxSessionReportTags]string
+}
+
+func (packet *SDK_SessionReportRequestPacket) Serialize(stream serialize.Stream) error {
+	packet.Version.Serialize(stream)
+	stream.SerializeUint64(&packet.BuyerId)
+	stream.SerializeUint64(&packet.DatacenterId)
+	stream.SerializeUint64(&packet.SessionId)
+	stream.SerializeUint64(&packet.RequestId)
+	stream.SerializeUint32(&packet.EventCode)
+	stream.SerializeInt(&packet.NumTags, 0, SDK_MaxSessionReportTags)
+	for i := 0; i < int(packet.NumTags); i++ {
+		stream.SerializeString(&packet.Tags[i], SDK_MaxSessionReportTagSize)
+	}
+	return stream.Err()
+}
+
+// ------------------------------------------------------------
+
+type SDK_SessionReportResponsePacket struct {
+	SessionId uint64
+	RequestId uint64
+}
+
+func (packet *SDK_SessionReportResponsePacket) Serialize(stream serialize.Stream) error {
+	stream.SerializeUint64(&packet.SessionId)
+	stream.SerializeUint64(&packet.RequestId)
+	return stream.Err()
+}
+
+// ------------------------------------------------------------
+
 type SDK_SessionUpdateRequestPacket struct {
 	Version                         SDKVersion
 	BuyerId                         uint64
@@ -511,6 +556,17 @@ func GenerateRandomSessionData() SDK_SessionData {
 		sessionData.PrevPacketsOutOfOrderServerToClient = common.RandomUint64()
 	}
 
+	if sessionData.Version >= 10 {
+		sessionData.KeyId = uint32(common.RandomUint64())
+	}
+
+	if sessionData.Version >= 9 {
+		sessionData.EnvelopeKbpsUp = uint32(common.RandomUint64())
+		sessionData.EnvelopeKbpsDown = uint32(common.RandomUint64())
+		sessionData.BandwidthPeakKbpsUp = uint32(common.RandomUint64())
+		sessionData.BandwidthPeakKbpsDown = uint32(common.RandomUint64())
+	}
+
 	sessionData.Latitude = common.RandomFloat32()
 	sessionData.Longitude = common.RandomFloat32()
 
@@ -593,6 +649,7 @@ func (packet *SDK_SessionUpdateResponsePacket) Serialize(stream serialize.Stream
 
 type SDK_SessionData struct {
 	Version                             uint32
+	KeyId                               uint32
 	SessionId                           uint64
 	SessionVersion                      uint32
 	SliceNumber                         uint32
@@ -630,6 +687,10 @@ type SDK_SessionData struct {
 	NoClientRelays                      bool
 	NoServerRelays                      bool
 	AllClientRelaysAreZero              bool
+	EnvelopeKbpsUp                      uint32
+	EnvelopeKbpsDown                    uint32
+	BandwidthPeakKbpsUp                 uint32
+	BandwidthPeakKbpsDown               uint32
 }
 
 func (sessionData *SDK_SessionData) Serialize(stream serialize.Stream) error {
@@ -648,6 +709,11 @@ func (sessionData *SDK_SessionData) Serialize(stream serialize.Stream) error {
 		}
 	}
 
+	if sessionData.Version >= 10 {
+		// IMPORTANT: the key id must stay right after the version. it is read before the signature is checked, see SDK_ReadSessionDataKeyId
+		stream.SerializeUint32(&sessionData.KeyId)
+	}
+
 	stream.SerializeUint64(&sessionData.SessionId)
 	stream.SerializeBits(&sessionData.SessionVersion, 8)
 
@@ -769,7 +835,35 @@ func (sessionData *SDK_SessionData) Serialize(stream serialize.Stream) error {
 		stream.SerializeBool(&sessionData.AllClientRelaysAreZero)
 	}
 
+	if sessionData.Version >= 9 {
+		// envelope in the current route tokens, and the recent peak bandwidth it adapts to. zero envelope means the route shader envelope
+		stream.SerializeUint32(&sessionData.EnvelopeKbpsUp)
+		stream.SerializeUint32(&sessionData.EnvelopeKbpsDown)
+		stream.SerializeUint32(&sessionData.BandwidthPeakKbpsUp)
+		stream.SerializeUint32(&sessionData.BandwidthPeakKbpsDown)
+	}
+
 	return stream.Err()
 }
 
+// SDK_ReadSessionDataKeyId reads just the key id from signed session data, so we know which key to check the signature with.
+// Session data older than version 10 has no key id, and returns zero.
+
+func SDK_ReadSessionDataKeyId(data []byte) (uint32, error) {
+	readStream := serialize.NewReadStream(data)
+	version := uint32(0)
+	readStream.SerializeBits(&version, 8)
+	if err := readStream.Err(); err != nil {
+		return 0, err
+	}
+	if version < SDK_SessionDataVersion_Min || version > SDK_SessionDataVersion_Max {
+		return 0, errors.New(fmt.Sprintf("invalid session data version: %d", version))
+	}
+	keyId := uint32(0)
+	if version >= 10 {
+		readStream.SerializeUint32(&keyId)
+	}
+	return keyId, readStream.Err()
+}
+
 // ------------------------------------------------------------
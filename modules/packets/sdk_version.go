@@ -2,6 +2,8 @@ package packets
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	serialize "github.com/mas-bandwidth/serialize.go"
 )
@@ -59,3 +61,21 @@ func (v SDKVersion) String() string {
 		return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
 	}
 }
+
+// ParseSDKVersion parses a version string of the form "major.minor.patch", eg. "1.2.11"
+
+func ParseSDKVersion(value string) (SDKVersion, error) {
+	values := strings.Split(value, ".")
+	if len(values) != 3 {
+		return SDKVersion{}, fmt.Errorf("sdk version '%s' is not of the form major.minor.patch", value)
+	}
+	var version [3]int32
+	for i := range values {
+		v, err := strconv.ParseUint(values[i], 10, 8)
+		if err != nil {
+			return SDKVersion{}, fmt.Errorf("sdk version '%s' is not valid: %v", value, err)
+		}
+		version[i] = int32(v)
+	}
+	return SDKVersion{version[0], version[1], version[2]}, nil
+}
@@ -3,10 +3,12 @@ package portal
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
@@ -172,33 +174,35 @@ func GenerateRandomSessionData() *SessionData {
 // --------------------------------------------------------------------------------------------------
 
 type SliceData struct {
-	Timestamp         uint64  `json:"timestamp,string"`
-	SliceNumber       uint32  `json:"slice_number"`
-	DirectRTT         uint32  `json:"direct_rtt"`
-	NextRTT           uint32  `json:"next_rtt"`
-	PredictedRTT      uint32  `json:"predicted_rtt"`
-	DirectJitter      uint32  `json:"direct_jitter"`
-	NextJitter        uint32  `json:"next_jitter"`
-	RealJitter        uint32  `json:"real_jitter"`
-	DirectPacketLoss  float32 `json:"direct_packet_loss"`
-	NextPacketLoss    float32 `json:"next_packet_loss"`
-	RealPacketLoss    float32 `json:"real_packet_loss"`
-	RealOutOfOrder    float32 `json:"real_out_of_order"`
-	DeltaTimeMin      float32 `json:"delta_time_min"`
-	DeltaTimeMax      float32 `json:"delta_time_max"`
-	DeltaTimeAvg      float32 `json:"delta_time_avg"`
-	InternalEvents    uint64  `json:"internal_events,string"`
-	SessionEvents     uint64  `json:"session_events,string"`
-	BandwidthKbpsUp   uint32  `json:"bandwidth_kbps_up"`
-	BandwidthKbpsDown uint32  `json:"bandwidth_kbps_down"`
-	Next              bool    `json:"next"`
-	GameRTT           float32 `json:"game_rtt"`
-	GameJitter        float32 `json:"game_jitter"`
-	GamePacketLoss    float32 `json:"game_packet_loss"`
+	Timestamp               uint64  `json:"timestamp,string"`
+	SliceNumber             uint32  `json:"slice_number"`
+	DirectRTT               uint32  `json:"direct_rtt"`
+	NextRTT                 uint32  `json:"next_rtt"`
+	PredictedRTT            uint32  `json:"predicted_rtt"`
+	DirectJitter            uint32  `json:"direct_jitter"`
+	NextJitter              uint32  `json:"next_jitter"`
+	RealJitter              uint32  `json:"real_jitter"`
+	DirectPacketLoss        float32 `json:"direct_packet_loss"`
+	NextPacketLoss          float32 `json:"next_packet_loss"`
+	RealPacketLoss          float32 `json:"real_packet_loss"`
+	RealOutOfOrder          float32 `json:"real_out_of_order"`
+	DeltaTimeMin            float32 `json:"delta_time_min"`
+	DeltaTimeMax            float32 `json:"delta_time_max"`
+	DeltaTimeAvg            float32 `json:"delta_time_avg"`
+	InternalEvents          uint64  `json:"internal_events,string"`
+	SessionEvents           uint64  `json:"session_events,string"`
+	BandwidthKbpsUp         uint32  `json:"bandwidth_kbps_up"`
+	BandwidthKbpsDown       uint32  `json:"bandwidth_kbps_down"`
+	Next                    bool    `json:"next"`
+	GameRTT                 float32 `json:"game_rtt"`
+	GameJitter              float32 `json:"game_jitter"`
+	GamePacketLoss          float32 `json:"game_packet_loss"`
+	ServerRelayPingFailures float32 `json:"server_relay_ping_failures"`
+	RouteChanged            bool    `json:"route_changed"`
 }
 
 func (data *SliceData) Value() string {
-	return fmt.Sprintf("%x|%d|%d|%d|%d|%d|%d|%d|%.2f|%.2f|%.2f|%.2f|%x|%x|%d|%d|%v|%.3f|%.3f|%.3f|%.3f|%.3f|%.3f",
+	return fmt.Sprintf("%x|%d|%d|%d|%d|%d|%d|%d|%.2f|%.2f|%.2f|%.2f|%x|%x|%d|%d|%v|%.3f|%.3f|%.3f|%.3f|%.3f|%.3f|%.2f|%v",
 		data.Timestamp,
 		data.SliceNumber,
 		data.DirectRTT,
@@ -222,12 +226,14 @@ func (data *SliceData) Value() string {
 		data.GameRTT,
 		data.GameJitter,
 		data.GamePacketLoss,
+		data.ServerRelayPingFailures,
+		data.RouteChanged,
 	)
 }
 
 func (data *SliceData) Parse(value string) {
 	values := strings.Split(value, "|")
-	if len(values) != 23 {
+	if len(values) != 25 {
 		return
 	}
 	timestamp, err := strconv.ParseUint(values[0], 16, 64)
@@ -319,6 +325,11 @@ func (data *SliceData) Parse(value string) {
 	if err != nil {
 		return
 	}
+	serverRelayPingFailures, err := strconv.ParseFloat(values[23], 32)
+	if err != nil {
+		return
+	}
+	routeChanged := values[24] == "true"
 
 	data.Timestamp = timestamp
 	data.SliceNumber = uint32(sliceNumber)
@@ -343,6 +354,8 @@ func (data *SliceData) Parse(value string) {
 	data.GameRTT = float32(gameRTT)
 	data.GameJitter = float32(gameJitter)
 	data.GamePacketLoss = float32(gamePacketLoss)
+	data.ServerRelayPingFailures = float32(serverRelayPingFailures)
+	data.RouteChanged = routeChanged
 }
 
 func GenerateRandomSliceData() *SliceData {
@@ -370,6 +383,8 @@ func GenerateRandomSliceData() *SliceData {
 	data.GameRTT = 10.0
 	data.GameJitter = 5.0
 	data.GamePacketLoss = 1.0
+	data.ServerRelayPingFailures = float32(common.RandomInt(0, 10000)) / 100.0
+	data.RouteChanged = common.RandomBool()
 	return &data
 }
 
@@ -539,6 +554,69 @@ func GenerateRandomServerRelayData() *ServerRelayData {
 
 // --------------------------------------------------------------------------------------------------
 
+const MaxSessionReportTags = 8 // IMPORTANT: must match packets.SDK_MaxSessionReportTags
+const MaxSessionReports = 100
+
+type SessionReportData struct {
+	Timestamp uint64   `json:"timestamp,string"`
+	EventCode uint32   `json:"event_code"`
+	Tags      []string `json:"tags"`
+}
+
+func (data *SessionReportData) Value() string {
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("%x|%d|%d", data.Timestamp, data.EventCode, len(data.Tags)))
+	for i := range data.Tags {
+		output.WriteString("|" + url.QueryEscape(data.Tags[i]))
+	}
+	return output.String()
+}
+
+func (data *SessionReportData) Parse(value string) {
+	values := strings.Split(value, "|")
+	if len(values) < 3 {
+		return
+	}
+	timestamp, err := strconv.ParseUint(values[0], 16, 64)
+	if err != nil {
+		return
+	}
+	eventCode, err := strconv.ParseUint(values[1], 10, 32)
+	if err != nil {
+		return
+	}
+	numTags, err := strconv.ParseInt(values[2], 10, 32)
+	if err != nil || numTags < 0 || numTags > MaxSessionReportTags {
+		return
+	}
+	if len(values) != 3+int(numTags) {
+		return
+	}
+	tags := make([]string, numTags)
+	for i := range tags {
+		tags[i], err = url.QueryUnescape(values[3+i])
+		if err != nil {
+			return
+		}
+	}
+	data.Timestamp = timestamp
+	data.EventCode = uint32(eventCode)
+	data.Tags = tags
+}
+
+func GenerateRandomSessionReportData() *SessionReportData {
+	data := SessionReportData{}
+	data.Timestamp = uint64(time.Now().Unix())
+	data.EventCode = rand.Uint32()
+	data.Tags = make([]string, common.RandomInt(0, MaxSessionReportTags))
+	for i := range data.Tags {
+		data.Tags[i] = common.RandomString(64) + "| %&"
+	}
+	return &data
+}
+
+// --------------------------------------------------------------------------------------------------
+
 type ServerData struct {
 	SDKVersion_Major uint8  `json:"sdk_version_major"`
 	SDKVersion_Minor uint8  `json:"sdk_version_minor"`
@@ -627,17 +705,23 @@ func GenerateRandomServerData() *ServerData {
 // --------------------------------------------------------------------------------------------------
 
 type RelayData struct {
-	RelayName    string `json:"relay_name"`
-	RelayId      uint64 `json:"relay_id,string"`
-	NumSessions  uint32 `json:"num_sessions"`
-	MaxSessions  uint32 `json:"max_sessions"`
-	StartTime    uint64 `json:"start_time,string"`
-	RelayFlags   uint64 `json:"relay_flags,string"`
-	RelayVersion string `json:"relay_version"`
+	RelayName           string  `json:"relay_name"`
+	RelayId             uint64  `json:"relay_id,string"`
+	NumSessions         uint32  `json:"num_sessions"`
+	MaxSessions         uint32  `json:"max_sessions"`
+	StartTime           uint64  `json:"start_time,string"`
+	RelayFlags          uint64  `json:"relay_flags,string"`
+	RelayVersion        string  `json:"relay_version"`
+	CPUPercent          float32 `json:"cpu_percent"`
+	SoftIRQPercent      float32 `json:"softirq_percent"`
+	MemoryUsedMB        uint32  `json:"memory_used_mb"`
+	MemoryTotalMB       uint32  `json:"memory_total_mb"`
+	NICRxDropsPerSecond float32 `json:"nic_rx_drops_per_second"`
+	NICTxDropsPerSecond float32 `json:"nic_tx_drops_per_second"`
 }
 
 func (data *RelayData) Value() string {
-	return fmt.Sprintf("%s|%x|%d|%d|%x|%x|%s",
+	return fmt.Sprintf("%s|%x|%d|%d|%x|%x|%s|%.2f|%.2f|%d|%d|%.2f|%.2f",
 		data.RelayName,
 		data.RelayId,
 		data.NumSessions,
@@ -645,13 +729,21 @@ func (data *RelayData) Value() string {
 		data.StartTime,
 		data.RelayFlags,
 		data.RelayVersion,
+		data.CPUPercent,
+		data.SoftIRQPercent,
+		data.MemoryUsedMB,
+		data.MemoryTotalMB,
+		data.NICRxDropsPerSecond,
+		data.NICTxDropsPerSecond,
 	)
 }
 
 func (data *RelayData) Parse(value string) {
 
+	// IMPORTANT: values written before host metrics were added have 7 fields
+
 	values := strings.Split(value, "|")
-	if len(values) != 7 {
+	if len(values) != 7 && len(values) != 13 {
 		return
 	}
 	relayName := values[0]
@@ -677,6 +769,35 @@ func (data *RelayData) Parse(value string) {
 	}
 	relayVersion := values[6]
 
+	var cpuPercent, softIRQPercent, nicRxDropsPerSecond, nicTxDropsPerSecond float64
+	var memoryUsedMB, memoryTotalMB uint64
+	if len(values) == 13 {
+		cpuPercent, err = strconv.ParseFloat(values[7], 32)
+		if err != nil {
+			return
+		}
+		softIRQPercent, err = strconv.ParseFloat(values[8], 32)
+		if err != nil {
+			return
+		}
+		memoryUsedMB, err = strconv.ParseUint(values[9], 10, 32)
+		if err != nil {
+			return
+		}
+		memoryTotalMB, err = strconv.ParseUint(values[10], 10, 32)
+		if err != nil {
+			return
+		}
+		nicRxDropsPerSecond, err = strconv.ParseFloat(values[11], 32)
+		if err != nil {
+			return
+		}
+		nicTxDropsPerSecond, err = strconv.ParseFloat(values[12], 32)
+		if err != nil {
+			return
+		}
+	}
+
 	data.RelayName = relayName
 	data.RelayId = relayId
 	data.NumSessions = uint32(numSessions)
@@ -684,6 +805,12 @@ func (data *RelayData) Parse(value string) {
 	data.StartTime = startTime
 	data.RelayFlags = relayFlags
 	data.RelayVersion = relayVersion
+	data.CPUPercent = float32(cpuPercent)
+	data.SoftIRQPercent = float32(softIRQPercent)
+	data.MemoryUsedMB = uint32(memoryUsedMB)
+	data.MemoryTotalMB = uint32(memoryTotalMB)
+	data.NICRxDropsPerSecond = float32(nicRxDropsPerSecond)
+	data.NICTxDropsPerSecond = float32(nicTxDropsPerSecond)
 }
 
 func GenerateRandomRelayData() *RelayData {
@@ -695,17 +822,31 @@ func GenerateRandomRelayData() *RelayData {
 	data.StartTime = rand.Uint64()
 	data.RelayFlags = rand.Uint64()
 	data.RelayVersion = common.RandomString(constants.MaxRelayVersionLength)
+	data.CPUPercent = float32(common.RandomInt(0, 100))
+	data.SoftIRQPercent = float32(common.RandomInt(0, 100))
+	data.MemoryUsedMB = rand.Uint32()
+	data.MemoryTotalMB = rand.Uint32()
+	data.NICRxDropsPerSecond = float32(common.RandomInt(0, 1000))
+	data.NICTxDropsPerSecond = float32(common.RandomInt(0, 1000))
 	return &data
 }
 
 // ------------------------------------------------------------------------------------------------------------
 
 type SessionCruncherEntry struct {
-	SessionId uint64
-	Score     uint32
-	Next      uint8
-	Latitude  float32
-	Longitude float32
+	SessionId    uint64
+	Score        uint32
+	Next         uint8
+	Latitude     float32
+	Longitude    float32
+	BuyerId      uint64
+	DatacenterId uint64
+	DirectRTT    uint32
+	NextRTT      uint32
+	PacketLoss   float32
+	Jitter       uint32
+	StartTime    uint64
+	RouteChanged uint8
 }
 
 type SessionCruncherPublisherConfig struct {
@@ -749,7 +890,7 @@ func CreateSessionCruncherPublisher(ctx context.Context, config SessionCruncherP
 	return publisher
 }
 
-const SessionBatchVersion_Write = uint64(0)
+const SessionBatchVersion_Write = uint64(2)
 
 func (publisher *SessionCruncherPublisher) updateMessageChannel(ctx context.Context) {
 
@@ -793,7 +934,7 @@ func (publisher *SessionCruncherPublisher) sendBatch() {
 
 	size := 8 + 4*constants.NumBuckets
 	for i := range batchSize {
-		size += int(batchSize[i]) * (8 + 1 + 4 + 4)
+		size += int(batchSize[i]) * (8 + 1 + 4 + 4 + 8 + 8 + 4 + 4 + 4 + 4 + 8 + 1)
 	}
 
 	data := make([]byte, size)
@@ -809,6 +950,14 @@ func (publisher *SessionCruncherPublisher) sendBatch() {
 			encoding.WriteUint8(data[:], &index, batch[i][j].Next)
 			encoding.WriteFloat32(data[:], &index, batch[i][j].Latitude)
 			encoding.WriteFloat32(data[:], &index, batch[i][j].Longitude)
+			encoding.WriteUint64(data[:], &index, batch[i][j].BuyerId)
+			encoding.WriteUint64(data[:], &index, batch[i][j].DatacenterId)
+			encoding.WriteUint32(data[:], &index, batch[i][j].DirectRTT)
+			encoding.WriteUint32(data[:], &index, batch[i][j].NextRTT)
+			encoding.WriteFloat32(data[:], &index, batch[i][j].PacketLoss)
+			encoding.WriteUint32(data[:], &index, batch[i][j].Jitter)
+			encoding.WriteUint64(data[:], &index, batch[i][j].StartTime)
+			encoding.WriteUint8(data[:], &index, batch[i][j].RouteChanged)
 		}
 	}
 
@@ -862,6 +1011,7 @@ type SessionInserter struct {
 	numPending    int
 	pipeline      redis.Pipeliner
 	publisher     *SessionCruncherPublisher
+	PublishEvents bool
 }
 
 func CreateSessionInserter(ctx context.Context, redisClient redis.Cmdable, sessionCruncherURL string, batchSize int) *SessionInserter {
@@ -881,16 +1031,27 @@ func (inserter *SessionInserter) Insert(ctx context.Context, sessionId uint64, n
 	minutes := currentTime.Unix() / 60
 
 	entry := SessionCruncherEntry{
-		SessionId: sessionId,
-		Score:     score,
-		Latitude:  sessionData.Latitude,
-		Longitude: sessionData.Longitude,
+		SessionId:    sessionId,
+		Score:        score,
+		Latitude:     sessionData.Latitude,
+		Longitude:    sessionData.Longitude,
+		BuyerId:      sessionData.BuyerId,
+		DatacenterId: sessionData.DatacenterId,
+		DirectRTT:    sessionData.DirectRTT,
+		NextRTT:      sessionData.NextRTT,
+		PacketLoss:   sliceData.RealPacketLoss,
+		Jitter:       sliceData.RealJitter,
+		StartTime:    sessionData.StartTime,
 	}
 
 	if next {
 		entry.Next = 1
 	}
 
+	if sliceData.RouteChanged {
+		entry.RouteChanged = 1
+	}
+
 	inserter.publisher.MessageChannel <- entry
 
 	sessionIdString := fmt.Sprintf("%016x", sessionId)
@@ -905,6 +1066,39 @@ func (inserter *SessionInserter) Insert(ctx context.Context, sessionId uint64, n
 	key = fmt.Sprintf("svs-%s-%d", serverIdString, minutes)
 	inserter.pipeline.HSet(ctx, key, sessionIdString, currentTime.Unix())
 
+	penalty := GetServerHealthPenalty(sliceData)
+
+	key = fmt.Sprintf("svh-%s-%d", serverIdString, minutes)
+	inserter.pipeline.HIncrBy(ctx, key, "slices", 1)
+	inserter.pipeline.HIncrByFloat(ctx, key, "penalty", float64(penalty))
+	inserter.pipeline.HIncrByFloat(ctx, key, "delta_time_max", float64(sliceData.DeltaTimeMax))
+	inserter.pipeline.HIncrByFloat(ctx, key, "delta_time_avg", float64(sliceData.DeltaTimeAvg))
+	inserter.pipeline.HIncrByFloat(ctx, key, "real_packet_loss", float64(sliceData.RealPacketLoss))
+	inserter.pipeline.HIncrByFloat(ctx, key, "server_relay_ping_failures", float64(sliceData.ServerRelayPingFailures))
+
+	key = fmt.Sprintf("bsvh-%016x-%d", sessionData.BuyerId, minutes)
+	inserter.pipeline.ZIncrBy(ctx, key, float64(penalty), serverIdString)
+
+	if inserter.PublishEvents {
+
+		publishEvent(ctx, inserter.pipeline, SessionEventChannel(sessionId), &SessionSliceEvent{SessionId: sessionId, SliceData: *sliceData})
+
+		routeDecision := RouteDecisionEvent{
+			Timestamp:      uint64(currentTime.Unix()),
+			SessionId:      sessionId,
+			ServerId:       sessionData.ServerId,
+			DatacenterId:   sessionData.DatacenterId,
+			Next:           next,
+			Score:          score,
+			DirectRTT:      sessionData.DirectRTT,
+			NextRTT:        sessionData.NextRTT,
+			NumRouteRelays: sessionData.NumRouteRelays,
+			RouteRelays:    sessionData.RouteRelays,
+		}
+
+		publishEvent(ctx, inserter.pipeline, BuyerEventChannel(sessionData.BuyerId), &routeDecision)
+	}
+
 	inserter.numPending++
 
 	inserter.CheckForFlush(ctx, currentTime)
@@ -1098,6 +1292,80 @@ func (watcher *MapDataWatcher) GetMapData() []byte {
 
 // --------------------------------------------------------------------------------------------------
 
+const HeatmapVersion = uint64(0)
+
+const MinHeatmapPrecision = 1
+const MaxHeatmapPrecision = 4
+
+type HeatmapCell struct {
+	Geohash            string  `json:"geohash"`
+	Latitude           float32 `json:"latitude"`
+	Longitude          float32 `json:"longitude"`
+	NumSessions        uint32  `json:"num_sessions"`
+	AcceleratedPercent float32 `json:"accelerated_percent"`
+	DirectRTT          float32 `json:"direct_rtt"`
+	NextRTT            float32 `json:"next_rtt"`
+}
+
+func ParseHeatmap(data []byte) []HeatmapCell {
+
+	index := 0
+
+	var version uint64
+	var precision uint8
+	var numCells uint32
+
+	if !encoding.ReadUint64(data, &index, &version) || version != HeatmapVersion {
+		core.Error("heatmap has unknown version %d, expected %d", version, HeatmapVersion)
+		return nil
+	}
+
+	if !encoding.ReadUint8(data, &index, &precision) || !encoding.ReadUint32(data, &index, &numCells) {
+		core.Error("heatmap header is truncated")
+		return nil
+	}
+
+	if int(numCells) > (len(data)-index)/(int(precision)+4+4+4+4) {
+		core.Error("heatmap claims %d cells but data is too small", numCells)
+		return nil
+	}
+
+	cells := make([]HeatmapCell, numCells)
+
+	geohash := make([]byte, precision)
+
+	for i := range cells {
+		encoding.ReadBytes(data, &index, geohash, uint32(precision))
+		encoding.ReadUint32(data, &index, &cells[i].NumSessions)
+		encoding.ReadFloat32(data, &index, &cells[i].AcceleratedPercent)
+		encoding.ReadFloat32(data, &index, &cells[i].DirectRTT)
+		encoding.ReadFloat32(data, &index, &cells[i].NextRTT)
+		cells[i].Geohash = string(geohash)
+		cells[i].Latitude, cells[i].Longitude, _ = common.GeohashCenter(cells[i].Geohash)
+	}
+
+	slices.SortFunc(cells, func(a, b HeatmapCell) int { return strings.Compare(a.Geohash, b.Geohash) })
+
+	return cells
+}
+
+// GetHeatmap reads a heatmap from the session cruncher. scope is "" for the global heatmap, otherwise
+// "buyer" or "datacenter" with the corresponding id.
+
+func GetHeatmap(sessionCruncherURL string, scope string, id uint64, precision int) []HeatmapCell {
+	url := fmt.Sprintf("%s/heatmap/%d", sessionCruncherURL, precision)
+	if scope != "" {
+		url = fmt.Sprintf("%s/heatmap/%s/%016x/%d", sessionCruncherURL, scope, id, precision)
+	}
+	data := getBinary(url)
+	if data == nil {
+		return nil
+	}
+	return ParseHeatmap(data)
+}
+
+// --------------------------------------------------------------------------------------------------
+
 func GetSessionData(ctx context.Context, redisClient redis.Cmdable, sessionId uint64) (*SessionData, []SliceData, []ClientRelayData, []ServerRelayData) {
 
 	pipeline := redisClient.Pipeline()
@@ -1139,6 +1407,22 @@ func GetSessionData(ctx context.Context, redisClient redis.Cmdable, sessionId ui
 	return &sessionData, sliceData, clientRelayData, serverRelayData
 }
 
+func GetSessionReports(ctx context.Context, redisClient redis.Cmdable, sessionId uint64) []SessionReportData {
+
+	redis_session_reports, err := redisClient.LRange(ctx, fmt.Sprintf("srp-%016x", sessionId), 0, -1).Result()
+	if err != nil {
+		core.Error("failed to get session reports: %v", err)
+		return nil
+	}
+
+	sessionReports := make([]SessionReportData, len(redis_session_reports))
+	for i := range redis_session_reports {
+		sessionReports[i].Parse(redis_session_reports[i])
+	}
+
+	return sessionReports
+}
+
 func GetSessionList(ctx context.Context, redisClient redis.Cmdable, sessionIds []uint64) []*SessionData {
 
 	pipeline := redisClient.Pipeline()
@@ -1268,9 +1552,61 @@ func (inserter *ServerRelayInserter) Flush(ctx context.Context) {
 
 // ------------------------------------------------------------------------------------------------------------
 
+type SessionReportInserter struct {
+	redisClient   redis.Cmdable
+	lastFlushTime time.Time
+	batchSize     int
+	numPending    int
+	pipeline      redis.Pipeliner
+}
+
+func CreateSessionReportInserter(redisClient redis.Cmdable, batchSize int) *SessionReportInserter {
+	inserter := SessionReportInserter{}
+	inserter.redisClient = redisClient
+	inserter.lastFlushTime = time.Now()
+	inserter.batchSize = batchSize
+	inserter.pipeline = redisClient.Pipeline()
+	return &inserter
+}
+
+func (inserter *SessionReportInserter) Insert(ctx context.Context, sessionId uint64, sessionReportData *SessionReportData) {
+
+	currentTime := time.Now()
+
+	key := fmt.Sprintf("srp-%016x", sessionId)
+	inserter.pipeline.RPush(ctx, key, sessionReportData.Value())
+	inserter.pipeline.LTrim(ctx, key, -MaxSessionReports, -1)
+
+	inserter.numPending++
+
+	inserter.CheckForFlush(ctx, currentTime)
+}
+
+func (inserter *SessionReportInserter) CheckForFlush(ctx context.Context, currentTime time.Time) {
+	if inserter.numPending > inserter.batchSize || currentTime.Sub(inserter.lastFlushTime) >= time.Second {
+		inserter.Flush(ctx)
+	}
+}
+
+func (inserter *SessionReportInserter) Flush(ctx context.Context) {
+	_, err := inserter.pipeline.Exec(ctx)
+	if err != nil {
+		core.Error("session report insert error: %v", err)
+	}
+	inserter.numPending = 0
+	inserter.lastFlushTime = time.Now()
+	inserter.pipeline = inserter.redisClient.Pipeline()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
 type ServerCruncherEntry struct {
-	ServerId uint64
-	Score    uint32
+	ServerId     uint64
+	Score        uint32
+	BuyerId      uint64
+	DatacenterId uint64
+	NumSessions  uint32
+	Uptime       uint64
 }
 
 type ServerCruncherPublisherConfig struct {
@@ -1314,7 +1650,7 @@ func CreateServerCruncherPublisher(ctx context.Context, config ServerCruncherPub
 	return publisher
 }
 
-const ServerBatchVersion_Write = uint64(1)
+const ServerBatchVersion_Write = uint64(2)
 
 func (publisher *ServerCruncherPublisher) updateMessageChannel(ctx context.Context) {
 
@@ -1356,7 +1692,7 @@ func (publisher *ServerCruncherPublisher) sendBatch() {
 		batch[batchIndex] = append(batch[batchIndex], publisher.batchMessages[i])
 	}
 
-	size := 8 + 4*constants.NumBuckets + 8*len(batchSize)
+	size := 8 + 4*constants.NumBuckets + len(publisher.batchMessages)*(8+8+8+4+8)
 
 	data := make([]byte, size)
 
@@ -1368,6 +1704,10 @@ func (publisher *ServerCruncherPublisher) sendBatch() {
 		encoding.WriteUint32(data[:], &index, uint32(batchSize[i]))
 		for j := range batch[i] {
 			encoding.WriteUint64(data, &index, batch[i][j].ServerId)
+			encoding.WriteUint64(data, &index, batch[i][j].BuyerId)
+			encoding.WriteUint64(data, &index, batch[i][j].DatacenterId)
+			encoding.WriteUint32(data, &index, batch[i][j].NumSessions)
+			encoding.WriteUint64(data, &index, batch[i][j].Uptime)
 		}
 	}
 
@@ -1414,8 +1754,12 @@ func (inserter *ServerInserter) Insert(ctx context.Context, serverData *ServerDa
 	score := (uint32(serverId) ^ uint32(serverId>>32)) % uint32(constants.MaxScore+1)
 
 	entry := ServerCruncherEntry{
-		ServerId: serverData.ServerId,
-		Score:    score,
+		ServerId:     serverData.ServerId,
+		Score:        score,
+		BuyerId:      serverData.BuyerId,
+		DatacenterId: serverData.DatacenterId,
+		NumSessions:  serverData.NumSessions,
+		Uptime:       serverData.Uptime,
 	}
 
 	inserter.publisher.MessageChannel <- entry
@@ -1547,6 +1891,250 @@ func GetServerList(ctx context.Context, redisClient redis.Cmdable, serverIds []u
 	return serverList
 }
 
+// CountSDKVersions breaks servers out by buyer and sdk version, so we can see which customers still run old sdks
+// and when old code paths can be removed. Sorted by buyer id, then newest version first.
+
+type SDKVersionCount struct {
+	BuyerId          uint64 `json:"buyer_id,string"`
+	SDKVersion_Major uint8  `json:"sdk_version_major"`
+	SDKVersion_Minor uint8  `json:"sdk_version_minor"`
+	SDKVersion_Patch uint8  `json:"sdk_version_patch"`
+	NumServers       int    `json:"num_servers"`
+	NumSessions      uint64 `json:"num_sessions"`
+}
+
+func CountSDKVersions(servers []*ServerData) []SDKVersionCount {
+
+	type key struct {
+		buyerId uint64
+		version [3]uint8
+	}
+
+	index := make(map[key]int)
+
+	counts := make([]SDKVersionCount, 0)
+
+	for _, server := range servers {
+		k := key{buyerId: server.BuyerId, version: [3]uint8{server.SDKVersion_Major, server.SDKVersion_Minor, server.SDKVersion_Patch}}
+		i, exists := index[k]
+		if !exists {
+			i = len(counts)
+			index[k] = i
+			counts = append(counts, SDKVersionCount{
+				BuyerId:          server.BuyerId,
+				SDKVersion_Major: server.SDKVersion_Major,
+				SDKVersion_Minor: server.SDKVersion_Minor,
+				SDKVersion_Patch: server.SDKVersion_Patch,
+			})
+		}
+		counts[i].NumServers++
+		counts[i].NumSessions += uint64(server.NumSessions)
+	}
+
+	slices.SortFunc(counts, func(a, b SDKVersionCount) int {
+		if a.BuyerId != b.BuyerId {
+			if a.BuyerId < b.BuyerId {
+				return -1
+			}
+			return 1
+		}
+		va := int(a.SDKVersion_Major)<<16 | int(a.SDKVersion_Minor)<<8 | int(a.SDKVersion_Patch)
+		vb := int(b.SDKVersion_Major)<<16 | int(b.SDKVersion_Minor)<<8 | int(b.SDKVersion_Patch)
+		return vb - va
+	})
+
+	return counts
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// Server health is scored from the slices of the sessions on each game server. A bad host shows up across
+// all of its sessions at once: the game loop hitches (high delta time), real packet loss goes up, and pings
+// from the server to nearby relays start failing. Each slice gets a penalty in [0,100] and the health score
+// of a server is 100 minus the average penalty across its slices over the last two minutes.
+
+const (
+	ServerHealth_DeltaTimeMaxThreshold            = 0.1  // seconds. a 100ms hitch is very noticeable
+	ServerHealth_DeltaTimeAvgThreshold            = 0.05 // seconds. below 20 ticks per-second on average
+	ServerHealth_RealPacketLossThreshold          = 5.0  // percent
+	ServerHealth_ServerRelayPingFailuresThreshold = 50.0 // percent of server relays not responding
+
+	ServerHealthMinSlices = 30 // don't judge a server on a handful of slices
+
+	MaxWorstServers = 100
+)
+
+type ServerHealth struct {
+	ServerId                uint64  `json:"server_id,string"`
+	Score                   uint32  `json:"score"`
+	NumSlices               uint32  `json:"num_slices"`
+	DeltaTimeMax            float32 `json:"delta_time_max"`
+	DeltaTimeAvg            float32 `json:"delta_time_avg"`
+	RealPacketLoss          float32 `json:"real_packet_loss"`
+	ServerRelayPingFailures float32 `json:"server_relay_ping_failures"`
+}
+
+func healthPenalty(value float32, threshold float32) float32 {
+	if value <= 0.0 {
+		return 0.0
+	}
+	return min(value/threshold, 1.0) * 100.0
+}
+
+func GetServerHealthPenalty(sliceData *SliceData) float32 {
+	penalty := 0.3 * healthPenalty(sliceData.DeltaTimeMax, ServerHealth_DeltaTimeMaxThreshold)
+	penalty += 0.2 * healthPenalty(sliceData.DeltaTimeAvg, ServerHealth_DeltaTimeAvgThreshold)
+	penalty += 0.3 * healthPenalty(sliceData.RealPacketLoss, ServerHealth_RealPacketLossThreshold)
+	penalty += 0.2 * healthPenalty(sliceData.ServerRelayPingFailures, ServerHealth_ServerRelayPingFailuresThreshold)
+	return penalty
+}
+
+func parseServerHealth(serverId uint64, values []map[string]string) *ServerHealth {
+	var numSlices uint64
+	var penalty, deltaTimeMax, deltaTimeAvg, realPacketLoss, serverRelayPingFailures float64
+	for i := range values {
+		n, _ := strconv.ParseUint(values[i]["slices"], 10, 64)
+		a, _ := strconv.ParseFloat(values[i]["penalty"], 64)
+		b, _ := strconv.ParseFloat(values[i]["delta_time_max"], 64)
+		c, _ := strconv.ParseFloat(values[i]["delta_time_avg"], 64)
+		d, _ := strconv.ParseFloat(values[i]["real_packet_loss"], 64)
+		e, _ := strconv.ParseFloat(values[i]["server_relay_ping_failures"], 64)
+		numSlices += n
+		penalty += a
+		deltaTimeMax += b
+		deltaTimeAvg += c
+		realPacketLoss += d
+		serverRelayPingFailures += e
+	}
+	health := ServerHealth{ServerId: serverId, Score: 100, NumSlices: uint32(numSlices)}
+	if numSlices == 0 {
+		return &health
+	}
+	n := float64(numSlices)
+	health.Score = uint32(max(100.0-penalty/n, 0.0))
+	health.DeltaTimeMax = float32(deltaTimeMax / n)
+	health.DeltaTimeAvg = float32(deltaTimeAvg / n)
+	health.RealPacketLoss = float32(realPacketLoss / n)
+	health.ServerRelayPingFailures = float32(serverRelayPingFailures / n)
+	return &health
+}
+
+func GetServerHealth(ctx context.Context, redisClient redis.Cmdable, serverId uint64, minutes int64) *ServerHealth {
+
+	pipeline := redisClient.Pipeline()
+
+	serverIdString := fmt.Sprintf("%016x", serverId)
+
+	pipeline.HGetAll(ctx, fmt.Sprintf("svh-%s-%d", serverIdString, minutes-1))
+	pipeline.HGetAll(ctx, fmt.Sprintf("svh-%s-%d", serverIdString, minutes))
+
+	cmds, err := pipeline.Exec(ctx)
+	if err != nil {
+		core.Error("failed to get server health for server id '%016x': %v", serverId, err)
+		return nil
+	}
+
+	values := []map[string]string{
+		cmds[0].(*redis.MapStringStringCmd).Val(),
+		cmds[1].(*redis.MapStringStringCmd).Val(),
+	}
+
+	return parseServerHealth(serverId, values)
+}
+
+// GetWorstServers returns the least healthy servers for a buyer, worst first. Candidates are the servers with the
+// highest total penalty over the last two minutes, then they are ranked by average penalty per-slice, so a busy
+// server with a few bad sessions doesn't push out a quiet server where every session is bad.
+
+func GetWorstServers(ctx context.Context, redisClient redis.Cmdable, buyerId uint64, minutes int64, count int) []*ServerHealth {
+
+	if count <= 0 {
+		return nil
+	}
+
+	count = min(count, MaxWorstServers)
+
+	const numCandidates = MaxWorstServers * 10
+
+	pipeline := redisClient.Pipeline()
+
+	pipeline.ZRevRange(ctx, fmt.Sprintf("bsvh-%016x-%d", buyerId, minutes-1), 0, numCandidates-1)
+	pipeline.ZRevRange(ctx, fmt.Sprintf("bsvh-%016x-%d", buyerId, minutes), 0, numCandidates-1)
+
+	cmds, err := pipeline.Exec(ctx)
+	if err != nil {
+		core.Error("failed to get worst servers for buyer %016x: %v", buyerId, err)
+		return nil
+	}
+
+	serverMap := make(map[uint64]bool)
+	for i := range cmds {
+		redis_server_ids := cmds[i].(*redis.StringSliceCmd).Val()
+		for j := range redis_server_ids {
+			serverId, err := strconv.ParseUint(redis_server_ids[j], 16, 64)
+			if err != nil {
+				continue
+			}
+			serverMap[serverId] = true
+		}
+	}
+
+	serverIds := make([]uint64, 0, len(serverMap))
+	for k := range serverMap {
+		serverIds = append(serverIds, k)
+	}
+
+	if len(serverIds) == 0 {
+		return nil
+	}
+
+	pipeline = redisClient.Pipeline()
+
+	for i := range serverIds {
+		serverIdString := fmt.Sprintf("%016x", serverIds[i])
+		pipeline.HGetAll(ctx, fmt.Sprintf("svh-%s-%d", serverIdString, minutes-1))
+		pipeline.HGetAll(ctx, fmt.Sprintf("svh-%s-%d", serverIdString, minutes))
+	}
+
+	cmds, err = pipeline.Exec(ctx)
+	if err != nil {
+		core.Error("failed to get server health for buyer %016x: %v", buyerId, err)
+		return nil
+	}
+
+	servers := make([]*ServerHealth, 0, len(serverIds))
+
+	for i := range serverIds {
+		values := []map[string]string{
+			cmds[i*2].(*redis.MapStringStringCmd).Val(),
+			cmds[i*2+1].(*redis.MapStringStringCmd).Val(),
+		}
+		health := parseServerHealth(serverIds[i], values)
+		if health.NumSlices < ServerHealthMinSlices {
+			continue
+		}
+		servers = append(servers, health)
+	}
+
+	slices.SortFunc(servers, func(a, b *ServerHealth) int {
+		if a.Score != b.Score {
+			return int(a.Score) - int(b.Score)
+		}
+		if a.ServerId < b.ServerId {
+			return -1
+		} else if a.ServerId > b.ServerId {
+			return +1
+		}
+		return 0
+	})
+
+	if len(servers) > count {
+		servers = servers[:count]
+	}
+
+	return servers
+}
+
 // ------------------------------------------------------------------------------------------------------------
 
 const TopServersVersion = uint64(1)
@@ -1632,12 +2220,83 @@ func (watcher *TopServersWatcher) GetTopServers() []uint64 {
 
 // ------------------------------------------------------------------------------------------------------------
 
+const TopSessionsByMetricVersion = uint64(0)
+const TopServersByMetricVersion = uint64(0)
+
+const MaxRankingCount = 10000
+
+// IMPORTANT: these must match the metrics supported by the session and server crunchers
+
+var SessionMetrics = []string{"packet_loss", "jitter", "duration", "latency_reduction", "route_changes"}
+var ServerMetrics = []string{"sessions", "uptime"}
+
+func parseRanking(data []byte, expectedVersion uint64) ([]uint64, []float32) {
+
+	index := 0
+
+	var version uint64
+	var count uint32
+
+	if !encoding.ReadUint64(data, &index, &version) || version != expectedVersion {
+		core.Error("bad ranking version. expected %d, got %d", expectedVersion, version)
+		return nil, nil
+	}
+
+	if !encoding.ReadUint32(data, &index, &count) || int(count) > (len(data)-index)/(8+4) {
+		core.Error("ranking is truncated")
+		return nil, nil
+	}
+
+	ids := make([]uint64, count)
+	values := make([]float32, count)
+	for i := range ids {
+		encoding.ReadUint64(data, &index, &ids[i])
+		encoding.ReadFloat32(data, &index, &values[i])
+	}
+
+	return ids, values
+}
+
+func rankingURL(baseURL string, metric string, buyerId uint64, datacenterId uint64, count int) string {
+	url := fmt.Sprintf("%s/%s?count=%d", baseURL, metric, count)
+	if buyerId != 0 {
+		url += fmt.Sprintf("&buyer=%016x", buyerId)
+	}
+	if datacenterId != 0 {
+		url += fmt.Sprintf("&datacenter=%016x", datacenterId)
+	}
+	return url
+}
+
+// GetTopSessionsByMetric returns up to count session ids ranked by metric over the last minute, worst first,
+// along with the metric value for each session. buyerId and datacenterId filter the ranking if non-zero.
+
+func GetTopSessionsByMetric(sessionCruncherURL string, metric string, buyerId uint64, datacenterId uint64, count int) ([]uint64, []float32) {
+	data := getBinary(rankingURL(sessionCruncherURL+"/top_sessions", metric, buyerId, datacenterId, count))
+	if data == nil {
+		return nil, nil
+	}
+	return parseRanking(data, TopSessionsByMetricVersion)
+}
+
+func GetTopServersByMetric(serverCruncherURL string, metric string, buyerId uint64, datacenterId uint64, count int) ([]uint64, []float32) {
+	data := getBinary(rankingURL(serverCruncherURL+"/top_servers", metric, buyerId, datacenterId, count))
+	if data == nil {
+		return nil, nil
+	}
+	return parseRanking(data, TopServersByMetricVersion)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
 type RelayInserter struct {
 	redisClient   redis.Cmdable
 	lastFlushTime time.Time
 	batchSize     int
 	numPending    int
 	pipeline      redis.Pipeliner
+	relayStatus   map[uint64]*RelayStatusEvent
+	PublishEvents bool
 }
 
 func CreateRelayInserter(redisClient redis.Cmdable, batchSize int) *RelayInserter {
@@ -1646,6 +2305,7 @@ func CreateRelayInserter(redisClient redis.Cmdable, batchSize int) *RelayInserte
 	inserter.lastFlushTime = time.Now()
 	inserter.batchSize = batchSize
 	inserter.pipeline = redisClient.Pipeline()
+	inserter.relayStatus = make(map[uint64]*RelayStatusEvent)
 	return &inserter
 }
 
@@ -1662,13 +2322,52 @@ func (inserter *RelayInserter) Insert(ctx context.Context, relayData *RelayData)
 
 	inserter.pipeline.Set(ctx, fmt.Sprintf("rd-%s", relayData.RelayName), relayData.Value(), 0)
 
+	if inserter.PublishEvents {
+		inserter.updateRelayStatus(ctx, currentTime, relayData)
+	}
+
 	inserter.numPending++
 
 	inserter.CheckForFlush(ctx, currentTime)
 }
 
+// updateRelayStatus publishes a relay status event only when a relay comes online, or its flags or version
+// change. relays that stop updating for longer than the relay timeout are published as offline on flush.
+// call CheckForFlush on a ticker too, so relays still time out when no relay is inserting at all.
+
+func (inserter *RelayInserter) updateRelayStatus(ctx context.Context, currentTime time.Time, relayData *RelayData) {
+	status, exists := inserter.relayStatus[relayData.RelayId]
+	if !exists {
+		status = &RelayStatusEvent{RelayId: relayData.RelayId, RelayStatus: constants.RelayStatus_Offline}
+		inserter.relayStatus[relayData.RelayId] = status
+	}
+	changed := status.RelayStatus != GetRelayStatus(relayData.RelayFlags) || status.RelayFlags != relayData.RelayFlags || status.RelayVersion != relayData.RelayVersion
+	status.Timestamp = uint64(currentTime.Unix())
+	status.RelayName = relayData.RelayName
+	status.RelayStatus = GetRelayStatus(relayData.RelayFlags)
+	status.RelayFlags = relayData.RelayFlags
+	status.RelayVersion = relayData.RelayVersion
+	if changed {
+		publishEvent(ctx, inserter.pipeline, EventChannel_Relays, status)
+	}
+}
+
+func (inserter *RelayInserter) timeoutRelays(ctx context.Context, currentTime time.Time) {
+	for relayId, status := range inserter.relayStatus {
+		if uint64(currentTime.Unix()) > status.Timestamp+constants.RelayTimeout {
+			status.Timestamp = uint64(currentTime.Unix())
+			status.RelayStatus = constants.RelayStatus_Offline
+			publishEvent(ctx, inserter.pipeline, EventChannel_Relays, status)
+			delete(inserter.relayStatus, relayId)
+		}
+	}
+}
+
 func (inserter *RelayInserter) CheckForFlush(ctx context.Context, currentTime time.Time) {
 	if inserter.numPending > inserter.batchSize || currentTime.Sub(inserter.lastFlushTime) >= time.Second {
+		if inserter.PublishEvents {
+			inserter.timeoutRelays(ctx, currentTime)
+		}
 		inserter.Flush(ctx)
 	}
 }
@@ -1851,3 +2550,184 @@ func GetRelayList(ctx context.Context, redisClient redis.Cmdable, relayNames []s
 }
 
 // ------------------------------------------------------------------------------------------------------------
+
+// Portal events are published over redis pub/sub alongside the regular inserts, so the api can stream
+// session slices, route decisions and relay status changes to live-ops tools without polling redis.
+// Publishing is off by default and enabled per-inserter. Events are best effort: a subscriber that
+// can't keep up has events dropped rather than slowing down the hub for everybody else.
+
+const (
+	EventChannel_Relays = "ev-relays"
+
+	EventHubChannelSize        = 1024
+	EventSubscriberChannelSize = 256
+)
+
+func SessionEventChannel(sessionId uint64) string {
+	return fmt.Sprintf("ev-s-%016x", sessionId)
+}
+
+func BuyerEventChannel(buyerId uint64) string {
+	return fmt.Sprintf("ev-b-%016x", buyerId)
+}
+
+type SessionSliceEvent struct {
+	SessionId uint64    `json:"session_id,string"`
+	SliceData SliceData `json:"slice_data"`
+}
+
+type RouteDecisionEvent struct {
+	Timestamp      uint64                           `json:"timestamp,string"`
+	SessionId      uint64                           `json:"session_id,string"`
+	ServerId       uint64                           `json:"server_id,string"`
+	DatacenterId   uint64                           `json:"datacenter_id,string"`
+	Next           bool                             `json:"next"`
+	Score          uint32                           `json:"score"`
+	DirectRTT      uint32                           `json:"direct_rtt"`
+	NextRTT        uint32                           `json:"next_rtt"`
+	NumRouteRelays int                              `json:"num_route_relays"`
+	RouteRelays    [constants.MaxRouteRelays]uint64 `json:"route_relays"`
+}
+
+type RelayStatusEvent struct {
+	Timestamp    uint64 `json:"timestamp,string"`
+	RelayId      uint64 `json:"relay_id,string"`
+	RelayName    string `json:"relay_name"`
+	RelayStatus  int    `json:"relay_status"`
+	RelayFlags   uint64 `json:"relay_flags,string"`
+	RelayVersion string `json:"relay_version"`
+}
+
+func GetRelayStatus(relayFlags uint64) int {
+	if (relayFlags & constants.RelayFlags_ShuttingDown) != 0 {
+		return constants.RelayStatus_ShuttingDown
+	}
+	return constants.RelayStatus_Online
+}
+
+func publishEvent(ctx context.Context, pipeline redis.Pipeliner, channel string, event any) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		core.Error("failed to marshal portal event: %v", err)
+		return
+	}
+	pipeline.Publish(ctx, channel, data)
+}
+
+type EventSubscriber struct {
+	Channel string
+	Events  chan []byte
+}
+
+// EventSource is the pub/sub connection behind an event hub. *redis.PubSub implements it
+
+type EventSource interface {
+	Subscribe(ctx context.Context, channels ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+	Channel(opts ...redis.ChannelOption) <-chan *redis.Message
+	Close() error
+}
+
+type EventHub struct {
+	mutex       sync.Mutex
+	pubsub      EventSource
+	subscribers map[string]map[*EventSubscriber]bool
+	numDropped  uint64
+}
+
+func CreateEventHub(ctx context.Context, redisClient redis.UniversalClient) *EventHub {
+	return CreateEventHubWithSource(ctx, redisClient.Subscribe(ctx))
+}
+
+func CreateEventHubWithSource(ctx context.Context, source EventSource) *EventHub {
+	hub := EventHub{}
+	hub.pubsub = source
+	hub.subscribers = make(map[string]map[*EventSubscriber]bool)
+	go hub.fanOut(ctx)
+	return &hub
+}
+
+func (hub *EventHub) fanOut(ctx context.Context) {
+	messages := hub.pubsub.Channel(redis.WithChannelSize(EventHubChannelSize))
+	for {
+		select {
+		case <-ctx.Done():
+			hub.pubsub.Close()
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			data := []byte(message.Payload)
+			hub.mutex.Lock()
+			for subscriber := range hub.subscribers[message.Channel] {
+				select {
+				case subscriber.Events <- data:
+				default:
+					hub.numDropped++
+				}
+			}
+			hub.mutex.Unlock()
+		}
+	}
+}
+
+func (hub *EventHub) Subscribe(ctx context.Context, channel string) (*EventSubscriber, error) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	subscribers, exists := hub.subscribers[channel]
+	if !exists {
+		if err := hub.pubsub.Subscribe(ctx, channel); err != nil {
+			return nil, err
+		}
+		subscribers = make(map[*EventSubscriber]bool)
+		hub.subscribers[channel] = subscribers
+	}
+	subscriber := &EventSubscriber{Channel: channel, Events: make(chan []byte, EventSubscriberChannelSize)}
+	subscribers[subscriber] = true
+	return subscriber, nil
+}
+
+func (hub *EventHub) Unsubscribe(ctx context.Context, subscriber *EventSubscriber) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	subscribers, exists := hub.subscribers[subscriber.Channel]
+	if !exists {
+		return
+	}
+	delete(subscribers, subscriber)
+	if len(subscribers) == 0 {
+		delete(hub.subscribers, subscriber.Channel)
+		if err := hub.pubsub.Unsubscribe(ctx, subscriber.Channel); err != nil {
+			core.Error("failed to unsubscribe from %s: %v", subscriber.Channel, err)
+		}
+	}
+}
+
+func (hub *EventHub) NumSubscribers() int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	count := 0
+	for _, subscribers := range hub.subscribers {
+		count += len(subscribers)
+	}
+	return count
+}
+
+func (hub *EventHub) NumDropped() uint64 {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return hub.numDropped
+}
+
+// WriteEvent writes one server-sent event. The data is json and never contains a newline, so it fits on one data line
+
+func WriteEvent(w io.Writer, eventName string, data []byte) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventName, data)
+}
+
+func WriteKeepAlive(w io.Writer) {
+	fmt.Fprintf(w, ": keepalive\n\n")
+}
+
+// ------------------------------------------------------------------------------------------------------------
@@ -1,10 +1,19 @@
 package portal_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/networknext/next/modules/common"
+	"github.com/networknext/next/modules/constants"
+	"github.com/networknext/next/modules/encoding"
 	"github.com/networknext/next/modules/portal"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -54,6 +63,17 @@ func TestServerRelayData(t *testing.T) {
 	}
 }
 
+func TestSessionReportData(t *testing.T) {
+	t.Parallel()
+	for range NumIterations {
+		writeData := portal.GenerateRandomSessionReportData()
+		value := writeData.Value()
+		readData := portal.SessionReportData{}
+		readData.Parse(value)
+		assert.Equal(t, *writeData, readData)
+	}
+}
+
 func TestServerData(t *testing.T) {
 	t.Parallel()
 	for range NumIterations {
@@ -65,6 +85,22 @@ func TestServerData(t *testing.T) {
 	}
 }
 
+func TestCountSDKVersions(t *testing.T) {
+	t.Parallel()
+	servers := []*portal.ServerData{
+		{SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 11, BuyerId: 2, NumSessions: 10},
+		{SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 9, BuyerId: 1, NumSessions: 5},
+		{SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 11, BuyerId: 1, NumSessions: 20},
+		{SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 9, BuyerId: 1, NumSessions: 7},
+	}
+	counts := portal.CountSDKVersions(servers)
+	assert.Equal(t, []portal.SDKVersionCount{
+		{BuyerId: 1, SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 11, NumServers: 1, NumSessions: 20},
+		{BuyerId: 1, SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 9, NumServers: 2, NumSessions: 12},
+		{BuyerId: 2, SDKVersion_Major: 1, SDKVersion_Minor: 2, SDKVersion_Patch: 11, NumServers: 1, NumSessions: 10},
+	}, counts)
+}
+
 func TestRelayData(t *testing.T) {
 	t.Parallel()
 	for range NumIterations {
@@ -75,3 +111,206 @@ func TestRelayData(t *testing.T) {
 		assert.Equal(t, *writeData, readData)
 	}
 }
+
+func TestServerHealthPenalty(t *testing.T) {
+	t.Parallel()
+
+	sliceData := portal.SliceData{}
+	assert.Equal(t, float32(0.0), portal.GetServerHealthPenalty(&sliceData))
+
+	sliceData.DeltaTimeMax = portal.ServerHealth_DeltaTimeMaxThreshold * 10
+	sliceData.DeltaTimeAvg = portal.ServerHealth_DeltaTimeAvgThreshold * 10
+	sliceData.RealPacketLoss = portal.ServerHealth_RealPacketLossThreshold * 10
+	sliceData.ServerRelayPingFailures = 100.0
+	assert.InDelta(t, 100.0, portal.GetServerHealthPenalty(&sliceData), 0.001)
+
+	sliceData = portal.SliceData{}
+	sliceData.RealPacketLoss = portal.ServerHealth_RealPacketLossThreshold / 2
+	assert.InDelta(t, 15.0, portal.GetServerHealthPenalty(&sliceData), 0.001)
+}
+
+func TestRelayStatus(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, constants.RelayStatus_Online, portal.GetRelayStatus(0))
+	assert.Equal(t, constants.RelayStatus_ShuttingDown, portal.GetRelayStatus(constants.RelayFlags_ShuttingDown))
+}
+
+func TestParseHeatmap(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 8+1+4+2*(2+4+4+4+4))
+	index := 0
+	encoding.WriteUint64(data, &index, portal.HeatmapVersion)
+	encoding.WriteUint8(data, &index, 2)
+	encoding.WriteUint32(data, &index, 2)
+	for _, geohash := range []string{"u4", "9q"} {
+		encoding.WriteBytes(data, &index, []byte(geohash), 2)
+		encoding.WriteUint32(data, &index, 100)
+		encoding.WriteFloat32(data, &index, 25.0)
+		encoding.WriteFloat32(data, &index, 80.0)
+		encoding.WriteFloat32(data, &index, 50.0)
+	}
+
+	cells := portal.ParseHeatmap(data)
+	assert.Equal(t, 2, len(cells))
+	assert.Equal(t, "9q", cells[0].Geohash)
+	assert.Equal(t, "u4", cells[1].Geohash)
+	assert.Equal(t, uint32(100), cells[0].NumSessions)
+	assert.Equal(t, float32(25.0), cells[0].AcceleratedPercent)
+	assert.Equal(t, "9q", common.Geohash(cells[0].Latitude, cells[0].Longitude, 2))
+
+	assert.Nil(t, portal.ParseHeatmap(data[:len(data)-1]))
+}
+
+// fakeEventSource stands in for redis pub/sub so the event hub can be tested without a redis server
+
+type fakeEventSource struct {
+	mutex    sync.Mutex
+	channels map[string]bool
+	messages chan *redis.Message
+}
+
+func newFakeEventSource() *fakeEventSource {
+	return &fakeEventSource{channels: make(map[string]bool), messages: make(chan *redis.Message)}
+}
+
+func (source *fakeEventSource) Subscribe(ctx context.Context, channels ...string) error {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	for _, channel := range channels {
+		source.channels[channel] = true
+	}
+	return nil
+}
+
+func (source *fakeEventSource) Unsubscribe(ctx context.Context, channels ...string) error {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	for _, channel := range channels {
+		delete(source.channels, channel)
+	}
+	return nil
+}
+
+func (source *fakeEventSource) Channel(opts ...redis.ChannelOption) <-chan *redis.Message {
+	return source.messages
+}
+
+func (source *fakeEventSource) Close() error {
+	return nil
+}
+
+func (source *fakeEventSource) isSubscribed(channel string) bool {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	return source.channels[channel]
+}
+
+// publish blocks until the hub has taken the message, and fails the test if the hub stops taking messages
+
+func (source *fakeEventSource) publish(t *testing.T, channel string, payload string) {
+	select {
+	case source.messages <- &redis.Message{Channel: channel, Payload: payload}:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("event hub blocked publishing to %s", channel)
+	}
+}
+
+func receiveEvent(t *testing.T, subscriber *portal.EventSubscriber) string {
+	select {
+	case data := <-subscriber.Events:
+		return string(data)
+	case <-time.After(time.Second * 5):
+		t.Fatalf("no event received on %s", subscriber.Channel)
+		return ""
+	}
+}
+
+func TestEventHubSubscribe(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := newFakeEventSource()
+	hub := portal.CreateEventHubWithSource(ctx, source)
+
+	channel := portal.SessionEventChannel(0x1234)
+
+	a, err := hub.Subscribe(ctx, channel)
+	assert.NoError(t, err)
+	b, err := hub.Subscribe(ctx, channel)
+	assert.NoError(t, err)
+	other, err := hub.Subscribe(ctx, portal.EventChannel_Relays)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, hub.NumSubscribers())
+	assert.True(t, source.isSubscribed(channel))
+	assert.True(t, source.isSubscribed(portal.EventChannel_Relays))
+
+	source.publish(t, channel, "hello")
+
+	assert.Equal(t, "hello", receiveEvent(t, a))
+	assert.Equal(t, "hello", receiveEvent(t, b))
+	assert.Equal(t, 0, len(other.Events))
+
+	// the hub stays subscribed to the redis channel until its last subscriber leaves
+
+	hub.Unsubscribe(ctx, a)
+	assert.Equal(t, 2, hub.NumSubscribers())
+	assert.True(t, source.isSubscribed(channel))
+
+	source.publish(t, channel, "again")
+	assert.Equal(t, "again", receiveEvent(t, b))
+	assert.Equal(t, 0, len(a.Events))
+
+	hub.Unsubscribe(ctx, b)
+	assert.Equal(t, 1, hub.NumSubscribers())
+	assert.False(t, source.isSubscribed(channel))
+	assert.True(t, source.isSubscribed(portal.EventChannel_Relays))
+
+	// unsubscribing twice is harmless
+
+	hub.Unsubscribe(ctx, b)
+	assert.Equal(t, 1, hub.NumSubscribers())
+}
+
+func TestEventHubSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := newFakeEventSource()
+	hub := portal.CreateEventHubWithSource(ctx, source)
+
+	slow, err := hub.Subscribe(ctx, portal.EventChannel_Relays)
+	assert.NoError(t, err)
+	fast, err := hub.Subscribe(ctx, portal.EventChannel_Relays)
+	assert.NoError(t, err)
+
+	const NumEvents = portal.EventSubscriberChannelSize * 2
+
+	// the slow subscriber never reads, so once its channel is full its events are dropped without blocking the hub
+
+	for i := range NumEvents {
+		source.publish(t, portal.EventChannel_Relays, fmt.Sprintf("%d", i))
+		assert.Equal(t, fmt.Sprintf("%d", i), receiveEvent(t, fast))
+	}
+
+	assert.Equal(t, portal.EventSubscriberChannelSize, len(slow.Events))
+	assert.Equal(t, uint64(NumEvents-portal.EventSubscriberChannelSize), hub.NumDropped())
+	assert.Equal(t, "0", receiveEvent(t, slow))
+}
+
+func TestWriteEvent(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	portal.WriteEvent(&buffer, "slice", []byte(`{"session_id":"1"}`))
+	portal.WriteKeepAlive(&buffer)
+	portal.WriteEvent(&buffer, "relay_status", []byte(`{}`))
+
+	assert.Equal(t, "event: slice\ndata: {\"session_id\":\"1\"}\n\n: keepalive\n\nevent: relay_status\ndata: {}\n\n", buffer.String())
+}
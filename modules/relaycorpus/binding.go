@@ -0,0 +1,243 @@
+package relaycorpus
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+
+	"github.com/networknext/next/modules/constants"
+	"github.com/networknext/next/modules/core"
+)
+
+// Token binding known-answer vectors. Bound route and continue tokens carry a hash of the
+// client's public address (core.ClientAddressHash), and the first relay on the route drops
+// a token presented from any other address. The relay datapath does not parse bound tokens
+// yet, so like the ipv6 vectors these are not corpus entries fired at relay_xdp.o. They pin
+// the token layout and the verdict the relay must reach for each replay case:
+//
+//	decrypt with the relay secret key, then check expiry, then (first hop only) compare
+//	the client address hash against the packet source address. The port is not hashed.
+//
+// The tokens come from core.WriteRouteTokens_Bound and core.WriteContinueTokens_Bound over
+// the same node layout the session update handler uses (client, relays, server), so the first
+// hop is the relay whose route token has the client address with port 0 as its previous
+// address (core.RouteTokenIsFirstHop). For continue tokens it is the session's first_hop flag.
+
+// token types in BindingVector.TokenType
+const (
+	BindingTokenRoute    = 0
+	BindingTokenContinue = 1
+)
+
+// verdicts in BindingVector.Expect
+const (
+	BindingAccept              = 0
+	BindingRejectExpired       = 1
+	BindingRejectClientAddress = 2
+)
+
+// BindingVector is one known answer: a bound token issued to ClientAddress, presented to a
+// relay (first hop or not) from FromAddress, and the verdict the relay must reach.
+type BindingVector struct {
+	Label           string
+	TokenType       uint8
+	FirstHop        uint8
+	ClientAddress   [4]byte
+	ClientPort      uint16
+	FromAddress     [4]byte
+	FromPort        uint16
+	ExpireTimestamp uint64
+	Token           []byte // plaintext bound token
+	EncryptedToken  []byte
+	Expect          uint8
+}
+
+// GenerateBindingVectors builds the deterministic token binding vectors for a world. Like
+// Generate, the seed drives every random choice, including the token nonces.
+func GenerateBindingVectors(seed int64, w World) []BindingVector {
+	rng := rand.New(rand.NewSource(seed))
+
+	randomAddress := func() [4]byte {
+		return [4]byte{byte(1 + rng.Intn(223)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(1 + rng.Intn(254))}
+	}
+
+	vectors := make([]BindingVector, 0, 160)
+
+	for range 16 {
+
+		client := randomAddress()
+		clientPort := uint16(1024 + rng.Intn(60000))
+
+		attacker := randomAddress()
+		for attacker == client {
+			attacker = randomAddress()
+		}
+		attackerPort := uint16(1024 + rng.Intn(60000))
+
+		natPort := clientPort + 1 + uint16(rng.Intn(100))
+
+		relay := w.Relays[rng.Intn(len(w.Relays))]
+
+		sessionId := rng.Uint64()
+		sessionVersion := uint8(rng.Intn(256))
+		fresh := w.Timestamp + 10 + uint64(rng.Intn(20))
+		expired := w.Timestamp - 1 - uint64(rng.Intn(20))
+
+		clientAddress := udpAddr(client, clientPort)
+
+		cases := []struct {
+			name     string
+			firstHop uint8
+			from     [4]byte
+			fromPort uint16
+			expire   uint64
+			expect   uint8
+		}{
+			{"same-address", 1, client, clientPort, fresh, BindingAccept},
+			{"nat-port-change", 1, client, natPort, fresh, BindingAccept},
+			{"replay-other-address", 1, attacker, attackerPort, fresh, BindingRejectClientAddress},
+			{"replay-later-hop", 0, relay.Address, relay.Port, fresh, BindingAccept},
+			{"replay-expired", 1, attacker, attackerPort, expired, BindingRejectExpired},
+		}
+
+		for _, c := range cases {
+
+			// the world relay is the first relay on the route for first hop cases, otherwise the second,
+			// after the relay the later hop case is sent from. every relay node shares the world secret key
+
+			other := udpAddr(relay.Address, relay.Port)
+
+			self := udpAddr(w.RelayPublicAddress, w.RelayPort)
+
+			relayIndex := 1
+			publicAddresses := []net.UDPAddr{clientAddress, self, other, udpAddr(randomAddress(), uint16(1024+rng.Intn(60000)))}
+			if c.firstHop == 0 {
+				relayIndex = 2
+				publicAddresses[1], publicAddresses[2] = other, self
+			}
+			publicAddresses[0].Port = 0
+
+			const numNodes = 4
+			hasInternalAddresses := make([]bool, numNodes)
+			internalAddresses := make([]net.UDPAddr, numNodes)
+			internalGroups := make([]uint64, numNodes)
+			sellers := make([]int, numNodes)
+			secretKeys := make([][]byte, numNodes)
+			for i := range secretKeys {
+				secretKeys[i] = w.SecretKey[:]
+			}
+
+			envelopeKbpsUp := uint32(rng.Intn(10000))
+			envelopeKbpsDown := uint32(rng.Intn(10000))
+
+			routeTokens := make([]byte, numNodes*constants.EncryptedRouteTokenBytes_Bound)
+			core.WriteRouteTokens_Bound(routeTokens, &clientAddress, c.expire, sessionId, sessionVersion, envelopeKbpsUp, envelopeKbpsDown, numNodes, publicAddresses, hasInternalAddresses, internalAddresses, internalGroups, sellers, secretKeys)
+
+			token := core.RouteToken{}
+			if !core.ReadEncryptedRouteToken_Bound(&token, routeTokens[relayIndex*constants.EncryptedRouteTokenBytes_Bound:], w.SecretKey[:]) {
+				panic("could not read bound route token")
+			}
+
+			// the writer draws the session private key and nonce from crypto/rand. replace them from the seeded rng, so the vectors are reproducible
+
+			rng.Read(token.SessionPrivateKey[:])
+
+			route := BindingVector{
+				Label:           "route-bound-" + c.name,
+				TokenType:       BindingTokenRoute,
+				ClientAddress:   client,
+				ClientPort:      clientPort,
+				FromAddress:     c.from,
+				FromPort:        c.fromPort,
+				ExpireTimestamp: c.expire,
+				Expect:          c.expect,
+			}
+
+			if core.RouteTokenIsFirstHop(&token) {
+				route.FirstHop = 1
+			}
+
+			route.Token = make([]byte, constants.RouteTokenBytes_Bound)
+			core.WriteRouteToken_Bound(&token, route.Token)
+
+			nonce := make([]byte, 24)
+			rng.Read(nonce)
+			route.EncryptedToken = encryptToken(route.Token, w.SecretKey[:], nonce)
+
+			vectors = append(vectors, route)
+
+			cont := route
+			cont.Label = "continue-bound-" + c.name
+			cont.TokenType = BindingTokenContinue
+
+			continueTokens := make([]byte, numNodes*constants.EncryptedContinueTokenBytes_Bound)
+			core.WriteContinueTokens_Bound(continueTokens, &clientAddress, c.expire, sessionId, sessionVersion, numNodes, secretKeys)
+
+			continueToken := core.ContinueToken{}
+			if !core.ReadEncryptedContinueToken_Bound(&continueToken, continueTokens[relayIndex*constants.EncryptedContinueTokenBytes_Bound:], w.SecretKey[:]) {
+				panic("could not read bound continue token")
+			}
+
+			cont.Token = make([]byte, constants.ContinueTokenBytes_Bound)
+			core.WriteContinueToken_Bound(&continueToken, cont.Token)
+
+			rng.Read(nonce)
+			cont.EncryptedToken = encryptToken(cont.Token, w.SecretKey[:], nonce)
+
+			vectors = append(vectors, cont)
+		}
+	}
+
+	return vectors
+}
+
+// Token binding vector file format (little endian):
+//
+//	char   magic[4] = "RLYB"
+//	uint32 version  = 1
+//	uint32 num_vectors
+//	per vector:
+//	  uint8  label_length, label bytes (diagnostic only)
+//	  uint8  token_type (0 = route, 1 = continue)
+//	  uint8  first_hop
+//	  uint8  client[4]; uint16 client_port
+//	  uint8  from[4];   uint16 from_port
+//	  uint64 expire_timestamp
+//	  uint8  expect (0 = accept, 1 = reject expired, 2 = reject client address)
+//	  uint16 token_length; token bytes (79 route, 25 continue)
+//	  uint16 encrypted_token_length; encrypted token bytes (119 route, 65 continue)
+//
+// The world the vectors derive from is the one in the corpus file generated with the same seed.
+const (
+	bindingFileMagic   = "RLYB"
+	bindingFileVersion = 1
+)
+
+// MarshalBindingVectors serializes the token binding vectors to the binary format above.
+func MarshalBindingVectors(vectors []BindingVector) []byte {
+	out := make([]byte, 0, 1<<16)
+	out = append(out, bindingFileMagic...)
+	out = binary.LittleEndian.AppendUint32(out, bindingFileVersion)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(vectors)))
+	for i := range vectors {
+		v := &vectors[i]
+		label := v.Label
+		if len(label) > 255 {
+			label = label[:255]
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+		out = append(out, v.TokenType, v.FirstHop)
+		out = append(out, v.ClientAddress[:]...)
+		out = binary.LittleEndian.AppendUint16(out, v.ClientPort)
+		out = append(out, v.FromAddress[:]...)
+		out = binary.LittleEndian.AppendUint16(out, v.FromPort)
+		out = binary.LittleEndian.AppendUint64(out, v.ExpireTimestamp)
+		out = append(out, v.Expect)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(v.Token)))
+		out = append(out, v.Token...)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(v.EncryptedToken)))
+		out = append(out, v.EncryptedToken...)
+	}
+	return out
+}
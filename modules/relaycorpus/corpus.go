@@ -648,7 +648,8 @@ func Generate(seed int64, w World) []Entry {
 			ActionTx, CounterRouteResponseForward)
 	}
 
-	// relay ping (type 11): exactly 18+8+8+1+32 bytes. source must be a known relay;
+	// relay ping (type 11): at least 18+8+8+1+32 bytes. longer pings are mtu probes, padded up
+	// to the probe size, and reflect as a regular pong. source must be a known relay;
 	// the token is sha256(ping key, expire, source addr:port, dest addr:port) and the
 	// handler accepts a token computed for either the public or the internal address.
 	relayPingPacket := func(src [4]byte, srcPort uint16, dst [4]byte, tokenDst [4]byte, expire uint64, flipToken bool) []byte {
@@ -678,12 +679,17 @@ func Generate(seed int64, w World) []Entry {
 		[4]byte{10, 9, 9, 8}, 40000, to, toPort, ActionDrop, CounterRelayPingUnknownRelay)
 	add("relay-ping-bad-token", relayPingPacket(r1, r1port, to, w.RelayPublicAddress, w.Timestamp+30, true),
 		r1, r1port, to, toPort, ActionDrop, CounterRelayPingDidNotVerify)
-	for _, size := range []int{18 + 48, 18 + 50} { // one short of 49, one long
+	for _, size := range []int{18 + 48} { // one short of 49
 		p := make([]byte, size)
 		randomBytes(p[18:])
 		p[0] = PacketRelayPing
 		add("relay-ping-wrong-size", p, r1, r1port, to, toPort, ActionDrop, CounterRelayPingWrongSize)
 	}
+	for _, size := range []int{1000, 1200, 1300, constants.MaxPacketBytes} {
+		p := make([]byte, size)
+		copy(p, relayPingPacket(r1, r1port, to, w.RelayPublicAddress, w.Timestamp+30, false))
+		add("relay-ping-mtu-probe", p, r1, r1port, to, toPort, ActionTx, CounterRelayPingReceived)
+	}
 
 	// client ping (type 9): exactly 18+8+8+8+32 bytes. the token source address has
 	// PORT ZERO (NATs rewrite client ports) and the token dest is always checked
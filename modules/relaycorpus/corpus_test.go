@@ -3,8 +3,10 @@ package relaycorpus_test
 import (
 	"bytes"
 	"encoding/binary"
+	"net"
 	"testing"
 
+	"github.com/networknext/next/modules/core"
 	"github.com/networknext/next/modules/relaycorpus"
 
 	"github.com/stretchr/testify/assert"
@@ -45,7 +47,7 @@ func TestCorpus_ExercisesEveryOutcome(t *testing.T) {
 	// every stateful family must be present -- a dropped family is a silent coverage hole
 	for _, label := range []string{
 		"whitelist-gate", "whitelist-expired-entry-still-admits",
-		"relay-ping-valid", "relay-ping-bad-token", "relay-ping-unknown-relay",
+		"relay-ping-valid", "relay-ping-bad-token", "relay-ping-unknown-relay", "relay-ping-mtu-probe",
 		"client-ping-valid", "client-ping-bad-token", "server-ping-valid",
 		"relay-pong-valid", "relay-pong-unknown-relay",
 		"route-request-valid", "route-request-bad-token", "route-request-next-hop-not-whitelisted",
@@ -155,3 +157,136 @@ func TestCorpus_MarshalRoundTrips(t *testing.T) {
 	}
 	assert.Equal(t, len(data), off, "consumed the whole buffer")
 }
+
+func TestIPv6Vectors_Deterministic(t *testing.T) {
+	t.Parallel()
+	a := relaycorpus.MarshalIPv6Vectors(relaycorpus.GenerateIPv6Vectors(42, relaycorpus.DefaultWorld(42)))
+	b := relaycorpus.MarshalIPv6Vectors(relaycorpus.GenerateIPv6Vectors(42, relaycorpus.DefaultWorld(42)))
+	c := relaycorpus.MarshalIPv6Vectors(relaycorpus.GenerateIPv6Vectors(43, relaycorpus.DefaultWorld(43)))
+	assert.Equal(t, a, b)
+	assert.False(t, bytes.Equal(a, c))
+}
+
+func TestIPv6Vectors_Verify(t *testing.T) {
+	t.Parallel()
+	world := relaycorpus.DefaultWorld(5)
+	vectors := relaycorpus.GenerateIPv6Vectors(5, world)
+
+	byLabel := map[string]int{}
+
+	for i := range vectors {
+		v := &vectors[i]
+		byLabel[v.Label]++
+
+		from := net.UDPAddr{IP: net.IP(v.FromAddress[:]), Port: int(v.FromPort)}
+		to := net.UDPAddr{IP: net.IP(v.ToAddress[:]), Port: int(v.ToPort)}
+
+		// ipv4-mapped addresses derive from 4 byte address data, everything else from 16
+		fromAddressData := core.GetAddressData_IPv6(&from)
+		if v.Label == "ipv4-mapped" {
+			assert.Equal(t, 4, len(fromAddressData), "vector %d", i)
+		} else {
+			assert.Equal(t, 16, len(fromAddressData), "vector %d", i)
+		}
+
+		// a packet stamped with the pittle and chonkle passes the advanced filter on the same 4-tuple
+		packet := make([]byte, v.PacketLength)
+		packet[0] = relaycorpus.PacketRelayPing
+		copy(packet[1:3], v.Pittle[:])
+		copy(packet[3:18], v.Chonkle[:])
+		assert.True(t, core.AdvancedPacketFilter(packet, world.CurrentMagic[:], fromAddressData, core.GetAddressData_IPv6(&to), len(packet)), "vector %d", i)
+
+		// the encrypted route token opens with the world secret key to the plaintext route token
+		token := core.RouteToken{}
+		assert.True(t, core.ReadEncryptedRouteToken_IPv6(&token, v.EncryptedRouteToken[:], world.SecretKey[:]), "vector %d", i)
+		assert.Equal(t, to.String(), token.NextAddress.String(), "vector %d", i)
+		assert.Equal(t, from.String(), token.PrevAddress.String(), "vector %d", i)
+		assert.Equal(t, v.ExpireTimestamp, token.ExpireTimestamp, "vector %d", i)
+	}
+
+	assert.Greater(t, byLabel["ipv6"], 0)
+	assert.Greater(t, byLabel["ipv4-mapped"], 0)
+
+	data := relaycorpus.MarshalIPv6Vectors(vectors)
+	assert.Equal(t, "RLY6", string(data[0:4]))
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(data[4:8]))
+	assert.Equal(t, uint32(len(vectors)), binary.LittleEndian.Uint32(data[8:12]))
+	size := 12
+	for i := range vectors {
+		size += 1 + len(vectors[i].Label) + 16 + 2 + 16 + 2 + 2 + 2 + 15 + 8 + 32 + 95 + 135
+	}
+	assert.Equal(t, size, len(data))
+}
+
+func TestBindingVectors_Deterministic(t *testing.T) {
+	t.Parallel()
+	a := relaycorpus.MarshalBindingVectors(relaycorpus.GenerateBindingVectors(42, relaycorpus.DefaultWorld(42)))
+	b := relaycorpus.MarshalBindingVectors(relaycorpus.GenerateBindingVectors(42, relaycorpus.DefaultWorld(42)))
+	c := relaycorpus.MarshalBindingVectors(relaycorpus.GenerateBindingVectors(43, relaycorpus.DefaultWorld(43)))
+	assert.Equal(t, a, b)
+	assert.False(t, bytes.Equal(a, c))
+}
+
+func TestBindingVectors_Verify(t *testing.T) {
+	t.Parallel()
+	world := relaycorpus.DefaultWorld(5)
+	vectors := relaycorpus.GenerateBindingVectors(5, world)
+
+	byLabel := map[string]int{}
+
+	for i := range vectors {
+		v := &vectors[i]
+		byLabel[v.Label]++
+
+		from := net.UDPAddr{IP: net.IPv4(v.FromAddress[0], v.FromAddress[1], v.FromAddress[2], v.FromAddress[3]), Port: int(v.FromPort)}
+
+		// the encrypted token opens with the world secret key, and the verdict follows from the relay's check order
+
+		var expireTimestamp, clientAddressHash uint64
+		if v.TokenType == relaycorpus.BindingTokenRoute {
+			token := core.RouteToken{}
+			assert.True(t, core.ReadEncryptedRouteToken_Bound(&token, v.EncryptedToken, world.SecretKey[:]), "vector %d", i)
+			assert.Equal(t, v.FirstHop == 1, core.RouteTokenIsFirstHop(&token), "vector %d", i)
+			if v.FirstHop == 1 {
+				assert.Equal(t, net.IPv4(v.ClientAddress[0], v.ClientAddress[1], v.ClientAddress[2], v.ClientAddress[3]).String(), token.PrevAddress.IP.String(), "vector %d", i)
+				assert.Equal(t, 0, token.PrevAddress.Port, "vector %d", i)
+			} else {
+				assert.Equal(t, from.String(), token.PrevAddress.String(), "vector %d", i)
+			}
+			expireTimestamp, clientAddressHash = token.ExpireTimestamp, token.ClientAddressHash
+		} else {
+			token := core.ContinueToken{}
+			assert.True(t, core.ReadEncryptedContinueToken_Bound(&token, v.EncryptedToken, world.SecretKey[:]), "vector %d", i)
+			expireTimestamp, clientAddressHash = token.ExpireTimestamp, token.ClientAddressHash
+		}
+
+		assert.Equal(t, v.ExpireTimestamp, expireTimestamp, "vector %d", i)
+
+		verdict := uint8(relaycorpus.BindingAccept)
+		if expireTimestamp < world.Timestamp {
+			verdict = relaycorpus.BindingRejectExpired
+		} else if !core.TokenBindingAllows(clientAddressHash, v.FirstHop == 1, &from) {
+			verdict = relaycorpus.BindingRejectClientAddress
+		}
+		assert.Equal(t, v.Expect, verdict, "vector %d %s", i, v.Label)
+	}
+
+	for _, label := range []string{
+		"route-bound-same-address", "route-bound-nat-port-change", "route-bound-replay-other-address",
+		"route-bound-replay-later-hop", "route-bound-replay-expired",
+		"continue-bound-same-address", "continue-bound-nat-port-change", "continue-bound-replay-other-address",
+		"continue-bound-replay-later-hop", "continue-bound-replay-expired",
+	} {
+		assert.Greater(t, byLabel[label], 0, "want case %q present", label)
+	}
+
+	data := relaycorpus.MarshalBindingVectors(vectors)
+	assert.Equal(t, "RLYB", string(data[0:4]))
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(data[4:8]))
+	assert.Equal(t, uint32(len(vectors)), binary.LittleEndian.Uint32(data[8:12]))
+	size := 12
+	for i := range vectors {
+		size += 1 + len(vectors[i].Label) + 1 + 1 + 4 + 2 + 4 + 2 + 8 + 1 + 2 + len(vectors[i].Token) + 2 + len(vectors[i].EncryptedToken)
+	}
+	assert.Equal(t, size, len(data))
+}
@@ -0,0 +1,154 @@
+package relaycorpus
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+
+	"github.com/networknext/next/modules/constants"
+	"github.com/networknext/next/modules/core"
+)
+
+// IPv6 known-answer vectors. Neither the SDK nor the relay datapath support ipv6 yet, and the
+// backend never sends ipv6 route tokens, so these are not corpus entries fired at relay_xdp.o.
+// They pin the proposed ipv6 derivations the SDK and relay must reproduce byte for byte as they
+// gain ipv6 support: the pittle and chonkle over 16 byte address data (core.GetAddressData_IPv6),
+// the ping token over 16 byte addresses (core.GeneratePingToken_IPv6), and the ipv6 route token.
+//
+// IPv4-mapped addresses are included on purpose. They derive exactly like ipv4 (4 byte
+// address data), so a dual stack relay must collapse them before deriving anything.
+
+// IPv6Vector is one known answer: the inputs (4-tuple, packet length, expiry) and every
+// value derived from them against the world's magic, ping key and secret key.
+type IPv6Vector struct {
+	Label               string
+	FromAddress         [16]byte
+	FromPort            uint16
+	ToAddress           [16]byte
+	ToPort              uint16
+	PacketLength        uint16
+	Pittle              [2]byte
+	Chonkle             [15]byte
+	ExpireTimestamp     uint64
+	PingToken           [PingTokenBytes]byte
+	RouteToken          [constants.RouteTokenBytes_IPv6]byte
+	EncryptedRouteToken [constants.EncryptedRouteTokenBytes_IPv6]byte
+}
+
+// GenerateIPv6Vectors builds the deterministic ipv6 vectors for a world. Like Generate,
+// the seed drives every random choice, including the route token nonces.
+func GenerateIPv6Vectors(seed int64, w World) []IPv6Vector {
+	rng := rand.New(rand.NewSource(seed))
+
+	randomAddress := func(mapped bool) [16]byte {
+		var a [16]byte
+		if mapped {
+			copy(a[:], net.IPv4(byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))).To16())
+			return a
+		}
+		a[0] = 0x20
+		a[1] = 0x01
+		for i := 2; i < 16; i++ {
+			a[i] = byte(rng.Intn(256))
+		}
+		return a
+	}
+
+	vectors := make([]IPv6Vector, 0, 64)
+
+	for i := range 64 {
+		v := IPv6Vector{Label: "ipv6"}
+		mapped := i%8 == 7
+		if mapped {
+			v.Label = "ipv4-mapped"
+		}
+		v.FromAddress = randomAddress(mapped)
+		v.FromPort = uint16(1024 + rng.Intn(60000))
+		v.ToAddress = randomAddress(mapped)
+		v.ToPort = uint16(1024 + rng.Intn(60000))
+		v.PacketLength = uint16(18 + rng.Intn(constants.MaxPacketBytes-18))
+		v.ExpireTimestamp = w.Timestamp + uint64(rng.Intn(100))
+
+		from := net.UDPAddr{IP: net.IP(v.FromAddress[:]), Port: int(v.FromPort)}
+		to := net.UDPAddr{IP: net.IP(v.ToAddress[:]), Port: int(v.ToPort)}
+
+		fromAddressData := core.GetAddressData_IPv6(&from)
+		toAddressData := core.GetAddressData_IPv6(&to)
+
+		core.GeneratePittle(v.Pittle[:], fromAddressData, toAddressData, int(v.PacketLength))
+		core.GenerateChonkle(v.Chonkle[:], w.CurrentMagic[:], fromAddressData, toAddressData, int(v.PacketLength))
+
+		core.GeneratePingToken_IPv6(v.ExpireTimestamp, &from, &to, w.PingKey[:], v.PingToken[:])
+
+		token := core.RouteToken{}
+		token.ExpireTimestamp = v.ExpireTimestamp
+		token.SessionId = rng.Uint64()
+		token.SessionVersion = uint8(rng.Intn(256))
+		token.EnvelopeKbpsUp = uint32(rng.Intn(10000))
+		token.EnvelopeKbpsDown = uint32(rng.Intn(10000))
+		token.NextAddress = to
+		token.PrevAddress = from
+		token.NextInternal = uint8(rng.Intn(2))
+		token.PrevInternal = uint8(rng.Intn(2))
+		rng.Read(token.SessionPrivateKey[:])
+		core.WriteRouteToken_IPv6(&token, v.RouteToken[:])
+
+		nonce := make([]byte, 24)
+		rng.Read(nonce)
+		copy(v.EncryptedRouteToken[:], encryptToken(v.RouteToken[:], w.SecretKey[:], nonce))
+
+		vectors = append(vectors, v)
+	}
+
+	return vectors
+}
+
+// IPv6 vector file format (little endian):
+//
+//	char   magic[4] = "RLY6"
+//	uint32 version  = 1
+//	uint32 num_vectors
+//	per vector:
+//	  uint8  label_length, label bytes (diagnostic only)
+//	  uint8  from[16]; uint16 from_port
+//	  uint8  to[16];   uint16 to_port
+//	  uint16 packet_length
+//	  uint8  pittle[2] chonkle[15]
+//	  uint64 expire_timestamp
+//	  uint8  ping_token[32]
+//	  uint8  route_token[95] encrypted_route_token[135]
+//
+// The world the vectors derive from is the one in the corpus file generated with the same seed.
+const (
+	ipv6FileMagic   = "RLY6"
+	ipv6FileVersion = 1
+)
+
+// MarshalIPv6Vectors serializes the ipv6 vectors to the binary format above.
+func MarshalIPv6Vectors(vectors []IPv6Vector) []byte {
+	out := make([]byte, 0, 1<<16)
+	out = append(out, ipv6FileMagic...)
+	out = binary.LittleEndian.AppendUint32(out, ipv6FileVersion)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(vectors)))
+	for i := range vectors {
+		v := &vectors[i]
+		label := v.Label
+		if len(label) > 255 {
+			label = label[:255]
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+		out = append(out, v.FromAddress[:]...)
+		out = binary.LittleEndian.AppendUint16(out, v.FromPort)
+		out = append(out, v.ToAddress[:]...)
+		out = binary.LittleEndian.AppendUint16(out, v.ToPort)
+		out = binary.LittleEndian.AppendUint16(out, v.PacketLength)
+		out = append(out, v.Pittle[:]...)
+		out = append(out, v.Chonkle[:]...)
+		out = binary.LittleEndian.AppendUint64(out, v.ExpireTimestamp)
+		out = append(out, v.PingToken[:]...)
+		out = append(out, v.RouteToken[:]...)
+		out = append(out, v.EncryptedRouteToken[:]...)
+	}
+	return out
+}
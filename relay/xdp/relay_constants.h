@@ -33,6 +33,13 @@
 #define RELAY_PING_SAFETY                                                                      1.0
 #define RELAY_PING_TIME                                                                        0.1
 
+#define RELAY_PING_PACKET_BYTES                                            ( 18 + 8 + 8 + 1 + 32 )
+
+#define RELAY_MTU_PROBE_TIME                                                                   1.0
+#define RELAY_MTU_PROBE_WINDOW                                                                10.0
+#define RELAY_NUM_MTU_PROBE_SIZES                                                                4
+#define RELAY_MTU_PROBE_SEQUENCE_BIT                                                ( 1ULL << 63 )
+
 #define RELAY_PING_TOKEN_BYTES                                                                  32
 #define RELAY_PING_KEY_BYTES                                                                    32
 #define RELAY_SESSION_PRIVATE_KEY_BYTES                                                         32
@@ -174,6 +181,19 @@
 
 #define RELAY_NUM_COUNTERS                                                                     150
 
+// IMPORTANT: must match RelayXDPDrop_* in constants.go
+
+#define RELAY_XDP_DROP_BASIC_PACKET_FILTER                                                       0
+#define RELAY_XDP_DROP_ADVANCED_PACKET_FILTER                                                    1
+#define RELAY_XDP_DROP_FRAGMENT                                                                  2
+#define RELAY_XDP_DROP_LARGE_IP_HEADER                                                           3
+#define RELAY_XDP_DROP_PACKET_TOO_SMALL                                                          4
+#define RELAY_XDP_DROP_PACKET_TOO_LARGE                                                          5
+#define RELAY_XDP_DROP_NOT_IN_WHITELIST                                                          6
+#define RELAY_XDP_DROP_WHITELIST_EXPIRED                                                         7
+
+#define RELAY_NUM_XDP_DROP_REASONS                                                               8
+
 #define RELAY_VERSION_LENGTH                                                                    32
 
 #define WHITELIST_TIMEOUT                                                                     1000
@@ -37,6 +37,8 @@
 
 #include <sodium.h>
 #include <time.h>
+#include <stdio.h>
+#include <string.h>
 #include <errno.h>
 #include <inttypes.h>
 #include <math.h>
@@ -612,6 +614,99 @@ struct session_stats main_update_timeouts( struct main_t * main )
 
 #endif // #ifdef RELAY_USERSPACE
 
+struct host_metrics_t
+{
+    float cpu_percent;
+    float softirq_percent;
+    uint64_t memory_used_bytes;
+    uint64_t memory_total_bytes;
+    float nic_rx_drops_per_second;
+    float nic_tx_drops_per_second;
+};
+
+// host metrics come from /proc so we can tell a congested relay from a broken one without ssh. they are zero on other platforms
+
+void main_get_host_metrics( struct main_t * main, double time_since_last_update, struct host_metrics_t * metrics )
+{
+    memset( metrics, 0, sizeof(struct host_metrics_t) );
+
+#if defined(__linux__)
+
+    FILE * file = fopen( "/proc/stat", "r" );
+    if ( file )
+    {
+        uint64_t user = 0, nice = 0, system = 0, idle = 0, iowait = 0, irq = 0, softirq = 0, steal = 0;
+        if ( fscanf( file, "cpu %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64, &user, &nice, &system, &idle, &iowait, &irq, &softirq, &steal ) == 8 )
+        {
+            const uint64_t total = user + nice + system + idle + iowait + irq + softirq + steal;
+            const uint64_t total_delta = total - main->last_host_cpu_total;
+            if ( main->last_host_cpu_total != 0 && total_delta > 0 )
+            {
+                const uint64_t idle_delta = ( idle + iowait ) - main->last_host_cpu_idle;
+                const uint64_t softirq_delta = softirq - main->last_host_cpu_softirq;
+                metrics->cpu_percent = 100.0f * (float) ( total_delta - idle_delta ) / (float) total_delta;
+                metrics->softirq_percent = 100.0f * (float) softirq_delta / (float) total_delta;
+            }
+            main->last_host_cpu_total = total;
+            main->last_host_cpu_idle = idle + iowait;
+            main->last_host_cpu_softirq = softirq;
+        }
+        fclose( file );
+    }
+
+    file = fopen( "/proc/meminfo", "r" );
+    if ( file )
+    {
+        char line[256];
+        uint64_t memory_total_kb = 0;
+        uint64_t memory_available_kb = 0;
+        while ( fgets( line, sizeof(line), file ) )
+        {
+            sscanf( line, "MemTotal: %" SCNu64 " kB", &memory_total_kb );
+            sscanf( line, "MemAvailable: %" SCNu64 " kB", &memory_available_kb );
+        }
+        metrics->memory_total_bytes = memory_total_kb * 1024;
+        metrics->memory_used_bytes = ( memory_total_kb > memory_available_kb ) ? ( memory_total_kb - memory_available_kb ) * 1024 : 0;
+        fclose( file );
+    }
+
+    file = fopen( "/proc/net/dev", "r" );
+    if ( file )
+    {
+        char line[512];
+        uint64_t rx_drops = 0;
+        uint64_t tx_drops = 0;
+        while ( fgets( line, sizeof(line), file ) )
+        {
+            char * colon = strchr( line, ':' );
+            if ( !colon || strstr( line, "lo:" ) )
+                continue;
+            uint64_t values[16];
+            if ( sscanf( colon + 1, "%" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64 " %" SCNu64,
+                         &values[0], &values[1], &values[2], &values[3], &values[4], &values[5], &values[6], &values[7], &values[8], &values[9], &values[10], &values[11] ) == 12 )
+            {
+                rx_drops += values[3];
+                tx_drops += values[11];
+            }
+        }
+        if ( main->last_host_nic_rx_drops != 0 && time_since_last_update > 0.0 )
+        {
+            metrics->nic_rx_drops_per_second = ( rx_drops > main->last_host_nic_rx_drops ) ? ( rx_drops - main->last_host_nic_rx_drops ) / time_since_last_update : 0.0f;
+            metrics->nic_tx_drops_per_second = ( tx_drops > main->last_host_nic_tx_drops ) ? ( tx_drops - main->last_host_nic_tx_drops ) / time_since_last_update : 0.0f;
+        }
+        main->last_host_nic_rx_drops = rx_drops;
+        main->last_host_nic_tx_drops = tx_drops;
+        fclose( file );
+    }
+
+#else // #if defined(__linux__)
+
+    (void) main;
+    (void) time_since_last_update;
+
+#endif // #if defined(__linux__)
+}
+
 int main_update( struct main_t * main )
 {
     // update timeouts
@@ -746,9 +841,22 @@ int main_update( struct main_t * main )
     uint64_t envelope_bandwidth_kbps_up = counters[RELAY_COUNTER_ENVELOPE_KBPS_UP];
     uint64_t envelope_bandwidth_kbps_down = counters[RELAY_COUNTER_ENVELOPE_KBPS_DOWN];
 
+    struct host_metrics_t host_metrics;
+    main_get_host_metrics( main, time_since_last_update, &host_metrics );
+
+    uint64_t xdp_drops[RELAY_NUM_XDP_DROP_REASONS];
+    xdp_drops[RELAY_XDP_DROP_BASIC_PACKET_FILTER] = counters[RELAY_COUNTER_BASIC_PACKET_FILTER_DROPPED_PACKET];
+    xdp_drops[RELAY_XDP_DROP_ADVANCED_PACKET_FILTER] = counters[RELAY_COUNTER_ADVANCED_PACKET_FILTER_DROPPED_PACKET];
+    xdp_drops[RELAY_XDP_DROP_FRAGMENT] = counters[RELAY_COUNTER_DROP_FRAGMENT];
+    xdp_drops[RELAY_XDP_DROP_LARGE_IP_HEADER] = counters[RELAY_COUNTER_DROP_LARGE_IP_HEADER];
+    xdp_drops[RELAY_XDP_DROP_PACKET_TOO_SMALL] = counters[RELAY_COUNTER_PACKET_TOO_SMALL];
+    xdp_drops[RELAY_XDP_DROP_PACKET_TOO_LARGE] = counters[RELAY_COUNTER_PACKET_TOO_LARGE];
+    xdp_drops[RELAY_XDP_DROP_NOT_IN_WHITELIST] = counters[RELAY_COUNTER_NOT_IN_WHITELIST];
+    xdp_drops[RELAY_XDP_DROP_WHITELIST_EXPIRED] = counters[RELAY_COUNTER_WHITELIST_ENTRY_EXPIRED];
+
     // build relay update data
 
-    uint8_t update_version = 1;
+    uint8_t update_version = 3;
 
     static uint8_t update_data[10*1024*1024];
 
@@ -787,6 +895,7 @@ int main_update( struct main_t * main )
         relay_write_uint8( &p, (uint8_t) integer_rtt );
         relay_write_uint8( &p, (uint8_t) integer_jitter );
         relay_write_uint16( &p, (uint16_t) integer_packet_loss );
+        relay_write_uint16( &p, main->ping_stats.relay_mtu[i] );
     }
 
     relay_write_uint32( &p, (uint32_t) session_count );
@@ -812,6 +921,19 @@ int main_update( struct main_t * main )
         relay_write_uint64( &p, counters[i] );
     }
 
+    relay_write_float32( &p, host_metrics.cpu_percent );
+    relay_write_float32( &p, host_metrics.softirq_percent );
+    relay_write_uint64( &p, host_metrics.memory_used_bytes );
+    relay_write_uint64( &p, host_metrics.memory_total_bytes );
+    relay_write_float32( &p, host_metrics.nic_rx_drops_per_second );
+    relay_write_float32( &p, host_metrics.nic_tx_drops_per_second );
+
+    relay_write_uint32( &p, RELAY_NUM_XDP_DROP_REASONS );
+    for ( int i = 0; i < RELAY_NUM_XDP_DROP_REASONS; ++i )
+    {
+        relay_write_uint64( &p, xdp_drops[i] );
+    }
+
     // encrypt data after relay address
 
     const int encrypt_buffer_length = (int) ( p - encrypt_buffer );
@@ -45,6 +45,11 @@ struct main_t
     uint64_t last_stats_server_pings_received;
     uint64_t last_stats_relay_pings_received;
     double last_stats_time;
+    uint64_t last_host_cpu_total;
+    uint64_t last_host_cpu_idle;
+    uint64_t last_host_cpu_softirq;
+    uint64_t last_host_nic_rx_drops;
+    uint64_t last_host_nic_tx_drops;
 };
 
 struct config_t;
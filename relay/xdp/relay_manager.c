@@ -9,6 +9,10 @@
 #include <stdlib.h>
 #include <string.h>
 
+// IMPORTANT: probe sizes are relay packet bytes (udp payload), from smallest to largest
+
+static const int relay_mtu_probe_bytes[RELAY_NUM_MTU_PROBE_SIZES] = { 1000, 1200, 1300, RELAY_MAX_PACKET_BYTES };
+
 struct relay_manager_t * relay_manager_create()
 {
     struct relay_manager_t * manager = (struct relay_manager_t*) malloc( sizeof(struct relay_manager_t) );
@@ -32,6 +36,8 @@ void relay_manager_reset( struct relay_manager_t * manager )
         free( manager->relay_ping_history[i] );
     }
     memset( manager->relay_ping_history, 0, sizeof(manager->relay_ping_history) );
+    memset( manager->relay_mtu_probe, 0, sizeof(manager->relay_mtu_probe) );
+    manager->mtu_probe_sequence = 0;
 }
 
 void relay_manager_update( struct relay_manager_t * manager, struct relay_set * new_relays, struct relay_set * delete_relays )
@@ -53,6 +59,9 @@ void relay_manager_update( struct relay_manager_t * manager, struct relay_set *
     uint16_t relay_ports[MAX_RELAYS];
     uint8_t relay_internal[MAX_RELAYS];
     struct relay_ping_history_t * relay_ping_history[MAX_RELAYS];
+    struct relay_mtu_probe_t relay_mtu_probe[MAX_RELAYS];
+
+    double current_time = relay_platform_time();
 
     for ( int i = 0; i < manager->num_relays; i++ )
     {
@@ -73,6 +82,7 @@ void relay_manager_update( struct relay_manager_t * manager, struct relay_set *
             relay_ports[num_relays] = manager->relay_ports[i];
             relay_internal[num_relays] = manager->relay_internal[i];
             relay_ping_history[num_relays] = manager->relay_ping_history[i];
+            relay_mtu_probe[num_relays] = manager->relay_mtu_probe[i];
             num_relays++;
         }
         else
@@ -90,6 +100,8 @@ void relay_manager_update( struct relay_manager_t * manager, struct relay_set *
         relay_ports[num_relays] = new_relays->port[i];
         relay_internal[num_relays] = new_relays->internal[i];
         relay_ping_history[num_relays] = (struct relay_ping_history_t*) malloc( MAX_RELAYS * sizeof(struct relay_ping_history_t) );
+        memset( &relay_mtu_probe[num_relays], 0, sizeof(struct relay_mtu_probe_t) );
+        relay_mtu_probe[num_relays].start_time = current_time;
         num_relays++;
     }
 
@@ -101,14 +113,14 @@ void relay_manager_update( struct relay_manager_t * manager, struct relay_set *
     memcpy( manager->relay_ports, relay_ports, 2 * num_relays );
     memcpy( manager->relay_internal, relay_internal, num_relays );
     memcpy( manager->relay_ping_history, relay_ping_history, sizeof(struct relay_ping_history_t*) * num_relays );
+    memcpy( manager->relay_mtu_probe, relay_mtu_probe, sizeof(struct relay_mtu_probe_t) * num_relays );
 
     // make sure all ping times are evenly distributed to avoid clusters of ping packets
 
-    double current_time = relay_platform_time();
-
     for ( int i = 0; i < manager->num_relays; ++i )
     {
         manager->relay_last_ping_time[i] = current_time - RELAY_PING_TIME + i * RELAY_PING_TIME / manager->num_relays;
+        manager->relay_mtu_probe[i].last_probe_time = current_time - RELAY_MTU_PROBE_TIME + i * RELAY_MTU_PROBE_TIME / manager->num_relays;
     }
 }
 
@@ -120,6 +132,22 @@ bool relay_manager_process_pong( struct relay_manager_t * manager, uint32_t from
     {
         if ( from_address == manager->relay_addresses[i] && from_port == manager->relay_ports[i] )
         {
+            if ( sequence & RELAY_MTU_PROBE_SEQUENCE_BIT )
+            {
+                // mtu probes have their own sequence space, so oversize probes that never arrive don't count as packet loss
+
+                struct relay_mtu_probe_t * probe = &manager->relay_mtu_probe[i];
+                for ( int j = 0; j < RELAY_NUM_MTU_PROBE_SIZES; j++ )
+                {
+                    if ( probe->sequence[j] == sequence )
+                    {
+                        probe->pong_time[j] = relay_platform_time();
+                        break;
+                    }
+                }
+                return true;
+            }
+
             relay_ping_history_pong_received( manager->relay_ping_history[i], sequence, relay_platform_time() );
             return true;
         }
@@ -128,6 +156,51 @@ bool relay_manager_process_pong( struct relay_manager_t * manager, uint32_t from
     return false;
 }
 
+int relay_manager_next_mtu_probe( struct relay_manager_t * manager, int relay_index, double current_time, uint64_t * sequence )
+{
+    assert( manager );
+    assert( relay_index >= 0 );
+    assert( relay_index < manager->num_relays );
+    assert( sequence );
+
+    struct relay_mtu_probe_t * probe = &manager->relay_mtu_probe[relay_index];
+
+    if ( probe->last_probe_time + RELAY_MTU_PROBE_TIME > current_time )
+        return 0;
+
+    probe->last_probe_time = current_time;
+
+    const int index = probe->probe_index;
+
+    probe->probe_index = ( probe->probe_index + 1 ) % RELAY_NUM_MTU_PROBE_SIZES;
+
+    manager->mtu_probe_sequence++;
+
+    probe->sequence[index] = RELAY_MTU_PROBE_SEQUENCE_BIT | manager->mtu_probe_sequence;
+
+    *sequence = probe->sequence[index];
+
+    return relay_mtu_probe_bytes[index];
+}
+
+static uint16_t relay_mtu_probe_get_mtu( const struct relay_mtu_probe_t * probe, double current_time )
+{
+    // the mtu is unknown until every probe size has had a full window to get through
+
+    if ( probe->start_time + RELAY_MTU_PROBE_WINDOW > current_time )
+        return 0;
+
+    for ( int i = RELAY_NUM_MTU_PROBE_SIZES - 1; i >= 0; i-- )
+    {
+        if ( probe->pong_time[i] >= current_time - RELAY_MTU_PROBE_WINDOW )
+            return (uint16_t) relay_mtu_probe_bytes[i];
+    }
+
+    // no probe got through, but regular pings do
+
+    return RELAY_PING_PACKET_BYTES;
+}
+
 void relay_manager_get_ping_stats( struct relay_manager_t * manager, struct relay_ping_stats_t * ping_stats )
 {
     assert( manager );
@@ -145,6 +218,7 @@ void relay_manager_get_ping_stats( struct relay_manager_t * manager, struct rela
         ping_stats->relay_rtt[i] = stats.rtt;
         ping_stats->relay_jitter[i] = stats.jitter;
         ping_stats->relay_packet_loss[i] = stats.packet_loss;
+        ping_stats->relay_mtu[i] = relay_mtu_probe_get_mtu( &manager->relay_mtu_probe[i], current_time );
     }
 }
 
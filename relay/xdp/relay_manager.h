@@ -9,6 +9,19 @@
 #include "relay_set.h"
 #include "relay_ping_stats.h"
 
+// path mtu discovery. some pings to each relay are padded up to a ladder of probe sizes, and the largest
+// size that gets a pong back is the path mtu. fragments are dropped by xdp, so a probe too large for
+// the path never arrives, which is exactly what happens to our real packets on that path.
+
+struct relay_mtu_probe_t
+{
+    double start_time;
+    double last_probe_time;
+    int probe_index;
+    uint64_t sequence[RELAY_NUM_MTU_PROBE_SIZES];
+    double pong_time[RELAY_NUM_MTU_PROBE_SIZES];
+};
+
 struct relay_manager_t
 {
     int num_relays;
@@ -18,6 +31,8 @@ struct relay_manager_t
     uint16_t relay_ports[MAX_RELAYS];
     uint8_t relay_internal[MAX_RELAYS];
     struct relay_ping_history_t * relay_ping_history[MAX_RELAYS];
+    struct relay_mtu_probe_t relay_mtu_probe[MAX_RELAYS];
+    uint64_t mtu_probe_sequence;
 };
 
 struct relay_manager_t * relay_manager_create();
@@ -28,6 +43,8 @@ void relay_manager_update( struct relay_manager_t * manager, struct relay_set *
 
 bool relay_manager_process_pong( struct relay_manager_t * manager, uint32_t from_address, uint16_t from_port, uint64_t sequence );
 
+int relay_manager_next_mtu_probe( struct relay_manager_t * manager, int relay_index, double current_time, uint64_t * sequence );
+
 void relay_manager_get_ping_stats( struct relay_manager_t * manager, struct relay_ping_stats_t * ping_stats );
 
 void relay_manager_destroy( struct relay_manager_t * manager );
@@ -331,6 +331,73 @@ static int userspace_datapath_packet( struct ping_t * ping, uint32_t from_addres
 
 // --------------------------------------------------------------------------------------------------------------------------------------------------
 
+static void relay_send_ping( struct ping_t * ping, int relay_index, uint64_t sequence, uint64_t expire_timestamp, int packet_bytes )
+{
+    assert( packet_bytes >= RELAY_PING_PACKET_BYTES );
+    assert( packet_bytes <= RELAY_MAX_PACKET_BYTES );
+
+    struct relay_manager_t * relay_manager = ping->relay_manager;
+
+    struct ping_token_data token_data;
+
+    token_data.source_address = relay_manager->relay_internal[relay_index] ? relay_htonl( ping->relay_internal_address ) : relay_htonl( ping->relay_public_address );
+    token_data.source_port = relay_htons( ping->relay_port );
+    token_data.dest_address = relay_htonl( relay_manager->relay_addresses[relay_index] );
+    token_data.dest_port = relay_htons( relay_manager->relay_ports[relay_index] );
+    token_data.expire_timestamp = expire_timestamp;
+
+    memcpy( token_data.ping_key, ping->ping_key, RELAY_PING_KEY_BYTES );
+
+    uint8_t ping_token[RELAY_PING_TOKEN_BYTES];
+
+    crypto_hash_sha256( ping_token, (const unsigned char*) &token_data, sizeof(struct ping_token_data) );
+
+    uint8_t packet_data[RELAY_MAX_PACKET_BYTES];
+
+    packet_data[0] = RELAY_PING_PACKET;
+
+    uint8_t * a = packet_data + 1;
+    uint8_t * b = packet_data + 3;
+    uint8_t * p = packet_data + 18;
+
+    relay_write_uint64( &p, sequence );
+    relay_write_uint64( &p, expire_timestamp );
+    relay_write_uint8( &p, relay_manager->relay_internal[relay_index] );
+    relay_write_bytes( &p, ping_token, RELAY_PING_TOKEN_BYTES );
+
+    // IMPORTANT: mtu probes are padded with zeros. the receiving relay ignores anything after the ping token
+
+    int packet_length = p - packet_data;
+
+    memset( p, 0, packet_bytes - packet_length );
+
+    packet_length = packet_bytes;
+
+    uint8_t to_address_data[4];
+    uint8_t from_address_data[4];
+
+    relay_address_data( relay_htonl( relay_manager->relay_addresses[relay_index] ), to_address_data );
+
+    if ( !relay_manager->relay_internal[relay_index] )
+    {
+        relay_address_data( relay_htonl( ping->relay_public_address ), from_address_data );
+    }
+    else
+    {
+        relay_address_data( relay_htonl( ping->relay_internal_address ), from_address_data );
+    }
+
+    relay_generate_pittle( a, from_address_data, to_address_data, packet_length );
+    relay_generate_chonkle( b, ping->current_magic, from_address_data, to_address_data, packet_length );
+
+    relay_platform_socket_send_packet( ping->socket, relay_manager->relay_addresses[relay_index], relay_manager->relay_ports[relay_index], packet_data, packet_length );
+
+    ping->bytes_sent += 8 + 20 + packet_length;
+    ping->pings_sent ++;
+}
+
+// --------------------------------------------------------------------------------------------------------------------------------------------------
+
 extern bool quit;
 
 void * ping_thread_function( void * context )
@@ -474,60 +541,20 @@ void * ping_thread_function( void * context )
                     {
                         // send relay ping packet
 
-                        struct ping_token_data token_data;
-
-                        token_data.source_address = ping->relay_manager->relay_internal[i] ? relay_htonl( ping->relay_internal_address ) : relay_htonl( ping->relay_public_address );
-                        token_data.source_port = relay_htons( ping->relay_port );
-                        token_data.dest_address = relay_htonl( ping->relay_manager->relay_addresses[i] );
-                        token_data.dest_port = relay_htons( ping->relay_manager->relay_ports[i] );
-                        token_data.expire_timestamp = expire_timestamp;
-
-                        memcpy( token_data.ping_key, ping->ping_key, RELAY_PING_KEY_BYTES );
-
-                        uint8_t ping_token[RELAY_PING_TOKEN_BYTES];
-
-                        crypto_hash_sha256( ping_token, (const unsigned char*) &token_data, sizeof(struct ping_token_data) );
-
-                        uint8_t packet_data[256];
-
-                        packet_data[0] = RELAY_PING_PACKET;
-
-                        uint8_t * a = packet_data + 1;
-                        uint8_t * b = packet_data + 3;
-                        uint8_t * p = packet_data + 18;
-
                         uint64_t sequence = relay_ping_history_ping_sent( ping->relay_manager->relay_ping_history[i], current_time );
 
-                        relay_write_uint64( &p, sequence );
-                        relay_write_uint64( &p, expire_timestamp );
-                        relay_write_uint8( &p, ping->relay_manager->relay_internal[i] );
-                        relay_write_bytes( &p, ping_token, RELAY_PING_TOKEN_BYTES );
-
-                        int packet_length = p - packet_data;
-
-                        uint8_t to_address_data[4];
-                        uint8_t from_address_data[4];
-
-                        relay_address_data( relay_htonl( ping->relay_manager->relay_addresses[i] ), to_address_data );
-
-                        if ( !ping->relay_manager->relay_internal[i] )
-                        {
-                            relay_address_data( relay_htonl( ping->relay_public_address ), from_address_data );
-                        }
-                        else
-                        {
-                            relay_address_data( relay_htonl( ping->relay_internal_address ), from_address_data );
-                        }
-
-                        relay_generate_pittle( a, from_address_data, to_address_data, packet_length );
-                        relay_generate_chonkle( b, ping->current_magic, from_address_data, to_address_data, packet_length );
-
-                        relay_platform_socket_send_packet( ping->socket, ping->relay_manager->relay_addresses[i], ping->relay_manager->relay_ports[i], packet_data, packet_length );
+                        relay_send_ping( ping, i, sequence, expire_timestamp, RELAY_PING_PACKET_BYTES );
 
                         ping->relay_manager->relay_last_ping_time[i] = current_time;
+                    }
+
+                    // send mtu probe. this is a ping padded up to the probe size
 
-                        ping->bytes_sent += 8 + 20 + 18 + 1 + 8 + 8 + RELAY_PING_TOKEN_BYTES;
-                        ping->pings_sent ++;
+                    uint64_t probe_sequence = 0;
+                    int probe_bytes = relay_manager_next_mtu_probe( ping->relay_manager, i, current_time, &probe_sequence );
+                    if ( probe_bytes > 0 )
+                    {
+                        relay_send_ping( ping, i, probe_sequence, expire_timestamp, probe_bytes );
                     }
                 }
             }
@@ -14,6 +14,7 @@ struct relay_ping_stats_t
     float relay_rtt[MAX_RELAYS];
     float relay_jitter[MAX_RELAYS];
     float relay_packet_loss[MAX_RELAYS];
+    uint16_t relay_mtu[MAX_RELAYS];
 };
 
 #endif // #ifndef RELAY_PING_STATS_H
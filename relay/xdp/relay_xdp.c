@@ -939,7 +939,9 @@ SEC("relay_xdp") int relay_xdp_filter( struct xdp_md *ctx )
                                     return XDP_DROP;
                                 }
 
-                                if ( (void*) packet_data + 18 + 8 + 8 + 1 + RELAY_PING_TOKEN_BYTES != data_end )
+                                // IMPORTANT: mtu probes are pings padded up to the probe size, so larger pings are fine
+
+                                if ( (void*) packet_data + RELAY_MAX_PACKET_BYTES < data_end )
                                 {
                                     relay_printf( "relay ping packet has wrong size" );
                                     INCREMENT_COUNTER( RELAY_COUNTER_RELAY_PING_PACKET_WRONG_SIZE );
@@ -1088,9 +1090,11 @@ SEC("relay_xdp") int relay_xdp_filter( struct xdp_md *ctx )
 
                                 const int payload_bytes = 18 + 8;
 
+                                const int trim_bytes = (int) ( data_end - (void*) packet_data ) - payload_bytes;
+
                                 relay_reflect_packet( data, payload_bytes, state->current_magic, config->use_gateway_ethernet_address ? config->gateway_ethernet_address : NULL );
 
-                                bpf_xdp_adjust_tail( ctx, -( 8 + 1 + RELAY_PING_TOKEN_BYTES ) );
+                                bpf_xdp_adjust_tail( ctx, -trim_bytes );
 
                                 INCREMENT_COUNTER( RELAY_COUNTER_PACKETS_SENT );
                                 INCREMENT_COUNTER( RELAY_COUNTER_RELAY_PONG_PACKET_SENT );
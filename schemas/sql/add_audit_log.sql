@@ -0,0 +1,15 @@
+CREATE TABLE audit_log (
+  audit_id bigint generated by default as identity,
+  timestamp bigint not null,
+  actor varchar not null,
+  endpoint varchar not null,
+  entity varchar not null,
+  before_json jsonb,
+  after_json jsonb,
+  primary key (audit_id)
+);
+
+CREATE INDEX audit_log_timestamp on audit_log (timestamp);
+
+CREATE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
@@ -0,0 +1,4 @@
+ALTER TABLE route_shaders
+ADD COLUMN bandwidth_envelope_max_up_kbps integer not null default 0,
+ADD COLUMN bandwidth_envelope_max_down_kbps integer not null default 0,
+ADD COLUMN bandwidth_envelope_headroom_percent integer not null default 25;
@@ -0,0 +1,9 @@
+CREATE TABLE buyer_keys (
+  buyer_key_id integer generated by default as identity,
+  buyer_id integer not null,
+  public_key_base64 varchar not null,
+  not_before bigint not null default 0,
+  not_after bigint not null default 0,
+  primary key (buyer_key_id),
+  constraint fk_buyer foreign key (buyer_id) references buyers(buyer_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE buyers
+ADD COLUMN rate_limit_packets_per_second integer not null default 10000,
+ADD COLUMN rate_limit_address_packets_per_second integer not null default 1000;
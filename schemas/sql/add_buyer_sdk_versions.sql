@@ -0,0 +1,3 @@
+ALTER TABLE buyers
+ADD COLUMN min_sdk_version varchar not null default '',
+ADD COLUMN recommended_sdk_version varchar not null default '';
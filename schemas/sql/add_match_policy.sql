@@ -0,0 +1,3 @@
+ALTER TABLE route_shaders
+ADD COLUMN match_policy integer not null default 0,
+ADD COLUMN max_match_latency_disparity integer not null default 50;
@@ -0,0 +1,2 @@
+ALTER TABLE route_shaders
+ADD COLUMN required_packet_bytes integer not null default 0;
@@ -14,6 +14,12 @@ CREATE TABLE route_shaders (
   route_switch_threshold integer not null default 10,
   route_select_threshold integer not null default 5,
   force_next boolean not null default false,
+  required_packet_bytes integer not null default 0,
+  match_policy integer not null default 0,
+  max_match_latency_disparity integer not null default 50,
+  bandwidth_envelope_max_up_kbps integer not null default 0,
+  bandwidth_envelope_max_down_kbps integer not null default 0,
+  bandwidth_envelope_headroom_percent integer not null default 25,
   primary key (route_shader_id),
   constraint route_shader_short_name_constraint unique(route_shader_name)
 );
@@ -26,6 +32,10 @@ CREATE TABLE buyers (
   debug boolean not null default false,
   public_key_base64 varchar not null,
   route_shader_id integer not null,
+  rate_limit_packets_per_second integer not null default 10000,
+  rate_limit_address_packets_per_second integer not null default 1000,
+  min_sdk_version varchar not null default '',
+  recommended_sdk_version varchar not null default '',
   primary key (buyer_id),
   constraint fk_route_shader_id foreign key (route_shader_id) references route_shaders(route_shader_id),
   constraint buyer_name_constraint unique(buyer_name),
@@ -90,6 +100,16 @@ create table buyer_datacenter_settings (
   constraint datacenter_map_unique_constraint unique(buyer_id, datacenter_id)
 );
 
+CREATE TABLE buyer_keys (
+  buyer_key_id integer generated by default as identity,
+  buyer_id integer not null,
+  public_key_base64 varchar not null,
+  not_before bigint not null default 0,
+  not_after bigint not null default 0,
+  primary key (buyer_key_id),
+  constraint fk_buyer foreign key (buyer_id) references buyers(buyer_id)
+);
+
 CREATE TABLE buyer_keypairs (
   buyer_keypair_id integer generated by default as identity,
   public_key_base64 varchar not null,
@@ -103,3 +123,19 @@ CREATE TABLE relay_keypairs (
   private_key_base64 varchar not null,
   primary key (relay_keypair_id)
 );
+
+CREATE TABLE audit_log (
+  audit_id bigint generated by default as identity,
+  timestamp bigint not null,
+  actor varchar not null,
+  endpoint varchar not null,
+  entity varchar not null,
+  before_json jsonb,
+  after_json jsonb,
+  primary key (audit_id)
+);
+
+CREATE INDEX audit_log_timestamp on audit_log (timestamp);
+
+CREATE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
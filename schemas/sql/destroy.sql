@@ -5,5 +5,7 @@ DROP TABLE IF EXISTS route_shaders CASCADE;
 DROP TABLE IF EXISTS datacenters CASCADE;
 DROP TABLE IF EXISTS relays CASCADE;
 DROP TABLE IF EXISTS buyer_datacenter_settings CASCADE;
+DROP TABLE IF EXISTS buyer_keys CASCADE;
 DROP TABLE IF EXISTS buyer_keypairs CASCADE;
 DROP TABLE IF EXISTS relay_keypairs CASCADE;
+DROP TABLE IF EXISTS audit_log CASCADE;
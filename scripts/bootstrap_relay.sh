@@ -0,0 +1,92 @@
+#!/bin/bash
+
+# register a new relay with a bootstrap token from "next bootstrap token <datacenter>", then wait for an admin to approve it.
+# once approved, writes relay.env for the relay service (see setup_relay.sh)
+#
+# usage: RELAY_GATEWAY_URL=https://relay.example.com BOOTSTRAP_TOKEN=<token> [RELAY_NAME=<name>] [RELAY_PUBLIC_ADDRESS=<ip:port>] ./bootstrap_relay.sh
+
+if [[ -z "$RELAY_GATEWAY_URL" || -z "$BOOTSTRAP_TOKEN" ]]; then
+  echo "you must set RELAY_GATEWAY_URL and BOOTSTRAP_TOKEN"
+  exit 1
+fi
+
+# generate the relay keypair. relay keys are curve25519, so openssl x25519 keys work as is
+
+echo generating relay keypair
+
+openssl genpkey -algorithm X25519 -out relay_key.pem
+
+if [ ! $? -eq 0 ]; then
+    echo "generate relay keypair failed"
+    exit 1
+fi
+
+RELAY_PRIVATE_KEY=$(openssl pkey -in relay_key.pem -outform DER | tail -c 32 | base64)
+RELAY_PUBLIC_KEY=$(openssl pkey -in relay_key.pem -pubout -outform DER | tail -c 32 | base64)
+
+rm -f relay_key.pem
+
+# register with the relay gateway
+
+echo registering relay
+
+response=$(curl -s -X POST "$RELAY_GATEWAY_URL/relay_bootstrap" -H "Content-Type: application/json" -d @- <<- EOM
+{
+  "token": "$BOOTSTRAP_TOKEN",
+  "relay_name": "$RELAY_NAME",
+  "public_address": "$RELAY_PUBLIC_ADDRESS",
+  "public_key_base64": "$RELAY_PUBLIC_KEY",
+  "private_key_base64": "$RELAY_PRIVATE_KEY"
+}
+EOM
+)
+
+json_value() {
+  python3 -c "import json,sys; print(json.load(sys.stdin).get('$1', ''))"
+}
+
+registration_id=$(echo "$response" | json_value registration_id)
+
+if [[ -z "$registration_id" ]]; then
+  echo "register relay failed: $(echo "$response" | json_value error)"
+  exit 1
+fi
+
+echo "registered relay. registration id is $registration_id"
+
+echo "waiting for approval (next bootstrap approve $registration_id)"
+
+# wait for an admin to approve the relay
+
+while true
+do
+  response=$(curl -s "$RELAY_GATEWAY_URL/relay_bootstrap/$registration_id")
+  status=$(echo "$response" | json_value status)
+  if [[ "$status" == "approved" ]]; then
+    break
+  fi
+  if [[ "$status" == "rejected" ]]; then
+    echo "relay was rejected: $(echo "$response" | json_value reason)"
+    exit 1
+  fi
+  sleep 10
+done
+
+RELAY_NAME=$(echo "$response" | json_value relay_name)
+RELAY_PUBLIC_ADDRESS=$(echo "$response" | json_value public_address)
+RELAY_BACKEND_PUBLIC_KEY=$(echo "$response" | json_value relay_backend_public_key)
+
+echo "relay $RELAY_NAME was approved"
+
+# setup the relay environment file
+
+cat > relay.env <<- EOM
+RELAY_NAME=$RELAY_NAME
+RELAY_PUBLIC_ADDRESS=$RELAY_PUBLIC_ADDRESS
+RELAY_PUBLIC_KEY=$RELAY_PUBLIC_KEY
+RELAY_PRIVATE_KEY=$RELAY_PRIVATE_KEY
+RELAY_BACKEND_URL=$RELAY_GATEWAY_URL
+RELAY_BACKEND_PUBLIC_KEY=$RELAY_BACKEND_PUBLIC_KEY
+EOM
+
+echo "wrote relay.env"
@@ -23,10 +23,10 @@
 
 #if !NEXT_DEVELOPMENT
 
-    #define NEXT_VERSION_FULL                              "1.2.12"
+    #define NEXT_VERSION_FULL                              "1.2.13"
     #define NEXT_VERSION_MAJOR_INT                                1
     #define NEXT_VERSION_MINOR_INT                                2
-    #define NEXT_VERSION_PATCH_INT                               12
+    #define NEXT_VERSION_PATCH_INT                               13
 
 #else // !NEXT_DEVELOPMENT
 
@@ -57,6 +57,9 @@
 
 #define NEXT_MAX_ADDRESS_STRING_LENGTH                          256
 
+#define NEXT_MAX_SESSION_REPORT_TAGS                              8
+#define NEXT_MAX_SESSION_REPORT_TAG_LENGTH                       64
+
 #define NEXT_CONNECTION_TYPE_UNKNOWN                              0
 #define NEXT_CONNECTION_TYPE_WIRED                                1
 #define NEXT_CONNECTION_TYPE_WIFI                                 2
@@ -493,6 +496,8 @@ NEXT_EXPORT_FUNC const char * next_server_datacenter( struct next_server_t * ser
 
 NEXT_EXPORT_FUNC void next_server_session_event( struct next_server_t * server, const struct next_address_t * address, uint64_t server_events );
 
+NEXT_EXPORT_FUNC void next_server_session_report( struct next_server_t * server, const struct next_address_t * address, uint32_t event_code, const char ** tags, int num_tags );
+
 NEXT_EXPORT_FUNC void next_server_flush( struct next_server_t * server );
 
 NEXT_EXPORT_FUNC void next_server_set_packet_receive_callback( struct next_server_t * server, void ( *callback )( void * data, struct next_address_t * from, uint8_t * packet_data, int * begin, int * end ), void * callback_data );
@@ -145,6 +145,10 @@
 
 #define NEXT_CLIENT_RELAY_PING_TIME                                     6
 
+#define NEXT_MAX_PENDING_SESSION_REPORTS                               64
+#define NEXT_SESSION_REPORT_SEND_RATE                                 1.0
+#define NEXT_SESSION_REPORT_TIMEOUT                                    10
+
 #define NEXT_VALUE_TRACKER_HISTORY                                   1024
 // clang-format on
 
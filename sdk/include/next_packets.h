@@ -56,6 +56,8 @@ struct next_replay_protection_t;
 #define NEXT_BACKEND_CLIENT_RELAY_RESPONSE_PACKET                      57
 #define NEXT_BACKEND_SERVER_RELAY_REQUEST_PACKET                       58
 #define NEXT_BACKEND_SERVER_RELAY_RESPONSE_PACKET                      59
+#define NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET                     60
+#define NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET                    61
 // clang-format on
 
 // ------------------------------------------------------------------------------------------------------
@@ -456,6 +458,7 @@ struct NextBackendServerInitResponsePacket
     uint8_t upcoming_magic[8];
     uint8_t current_magic[8];
     uint8_t previous_magic[8];
+    bool upgrade_recommended;
 
     NextBackendServerInitResponsePacket()
     {
@@ -470,6 +473,7 @@ struct NextBackendServerInitResponsePacket
         serialize_bytes( stream, upcoming_magic, 8 );
         serialize_bytes( stream, current_magic, 8 );
         serialize_bytes( stream, previous_magic, 8 );
+        serialize_bool( stream, upgrade_recommended );
         return true;
     }
 };
@@ -697,6 +701,71 @@ struct NextBackendServerRelayResponsePacket
 
 // ------------------------------------------------------------------------------------------------------
 
+struct NextBackendSessionReportRequestPacket
+{
+    int version_major;
+    int version_minor;
+    int version_patch;
+    uint64_t buyer_id;
+    uint64_t datacenter_id;
+    uint64_t session_id;
+    uint64_t request_id;
+    uint32_t event_code;
+    int num_tags;
+    char tags[NEXT_MAX_SESSION_REPORT_TAGS][NEXT_MAX_SESSION_REPORT_TAG_LENGTH];
+
+    NextBackendSessionReportRequestPacket()
+    {
+        memset( this, 0, sizeof( NextBackendSessionReportRequestPacket ) );
+        version_major = NEXT_VERSION_MAJOR_INT;
+        version_minor = NEXT_VERSION_MINOR_INT;
+        version_patch = NEXT_VERSION_PATCH_INT;
+    }
+
+    template <typename Stream>
+    bool Serialize( Stream & stream )
+    {
+        serialize_bits( stream, version_major, 8 );
+        serialize_bits( stream, version_minor, 8 );
+        serialize_bits( stream, version_patch, 8 );
+        serialize_uint64( stream, buyer_id );
+        serialize_uint64( stream, datacenter_id );
+        serialize_uint64( stream, session_id );
+        serialize_uint64( stream, request_id );
+        serialize_uint32( stream, event_code );
+        serialize_int( stream, num_tags, 0, NEXT_MAX_SESSION_REPORT_TAGS );
+        for ( int i = 0; i < num_tags; i++ )
+        {
+            serialize_string( stream, tags[i], NEXT_MAX_SESSION_REPORT_TAG_LENGTH );
+        }
+        return true;
+    }
+};
+
+// ------------------------------------------------------------------------------------------------------
+
+struct NextBackendSessionReportResponsePacket
+{
+    uint64_t session_id;
+    uint64_t request_id;
+
+    NextBackendSessionReportResponsePacket()
+    {
+        session_id = 0;
+        request_id = 0;
+    }
+
+    template <typename Stream>
+    bool Serialize( Stream & stream )
+    {
+        serialize_uint64( stream, session_id );
+        serialize_uint64( stream, request_id );
+        return true;
+    }
+};
+
+// ------------------------------------------------------------------------------------------------------
+
 struct NextBackendSessionUpdateRequestPacket
 {
     int version_major;
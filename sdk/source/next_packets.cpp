@@ -775,6 +775,22 @@ int next_write_backend_packet( uint8_t packet_id, void * packet_object, uint8_t
         }
         break;
 
+        case NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET:
+        {
+            NextBackendSessionReportRequestPacket * packet = (NextBackendSessionReportRequestPacket *) packet_object;
+            if ( !packet->Serialize( stream ) )
+                return NEXT_ERROR;
+        }
+        break;
+
+        case NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET:
+        {
+            NextBackendSessionReportResponsePacket * packet = (NextBackendSessionReportResponsePacket *) packet_object;
+            if ( !packet->Serialize( stream ) )
+                return NEXT_ERROR;
+        }
+        break;
+
         default:
             return NEXT_ERROR;
     }
@@ -903,6 +919,22 @@ int next_read_backend_packet( uint8_t packet_id, uint8_t * packet_data, int begi
         }
         break;
 
+        case NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET:
+        {
+            NextBackendSessionReportRequestPacket * packet = (NextBackendSessionReportRequestPacket *) packet_object;
+            if ( !packet->Serialize( stream ) )
+                return NEXT_ERROR;
+        }
+        break;
+
+        case NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET:
+        {
+            NextBackendSessionReportResponsePacket * packet = (NextBackendSessionReportResponsePacket *) packet_object;
+            if ( !packet->Serialize( stream ) )
+                return NEXT_ERROR;
+        }
+        break;
+
         case NEXT_BACKEND_SESSION_UPDATE_REQUEST_PACKET:
         {
             NextBackendSessionUpdateRequestPacket * packet = (NextBackendSessionUpdateRequestPacket *) packet_object;
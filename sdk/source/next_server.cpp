@@ -35,6 +35,7 @@
 #define NEXT_SERVER_COMMAND_SET_PACKET_RECEIVE_CALLBACK             4
 #define NEXT_SERVER_COMMAND_SET_SEND_PACKET_TO_ADDRESS_CALLBACK     5
 #define NEXT_SERVER_COMMAND_SET_PAYLOAD_RECEIVE_CALLBACK            6
+#define NEXT_SERVER_COMMAND_SESSION_REPORT                          7
 // clang-format on
 
 struct next_server_command_t
@@ -55,6 +56,14 @@ struct next_server_command_session_event_t : public next_server_command_t
     uint64_t session_events;
 };
 
+struct next_server_command_session_report_t : public next_server_command_t
+{
+    next_address_t address;
+    uint32_t event_code;
+    int num_tags;
+    char tags[NEXT_MAX_SESSION_REPORT_TAGS][NEXT_MAX_SESSION_REPORT_TAG_LENGTH];
+};
+
 struct next_server_command_update_t : public next_server_command_t
 {
     float delta_time;
@@ -212,6 +221,12 @@ void next_server_internal_upgrade_session( next_server_internal_t * server, cons
 
 void next_server_internal_session_events( next_server_internal_t * server, const next_address_t * address, uint64_t session_events );
 
+void next_server_internal_session_report( next_server_internal_t * server, const next_address_t * address, uint32_t event_code, const char tags[][NEXT_MAX_SESSION_REPORT_TAG_LENGTH], int num_tags );
+
+void next_server_internal_remove_session_report( next_server_internal_t * server, int index );
+
+void next_server_internal_update_session_reports( next_server_internal_t * server );
+
 void next_server_internal_flush_session_update( next_server_internal_t * server );
 
 void next_server_internal_flush( next_server_internal_t * server );
@@ -375,6 +390,13 @@ struct next_server_internal_t
     next_value_tracker_t delta_time_tracker;
 
     NEXT_DECLARE_SENTINEL( 17 )
+
+    int num_pending_session_reports;
+    NextBackendSessionReportRequestPacket pending_session_report_packets[NEXT_MAX_PENDING_SESSION_REPORTS];
+    double pending_session_report_send_time[NEXT_MAX_PENDING_SESSION_REPORTS];
+    double pending_session_report_timeout_time[NEXT_MAX_PENDING_SESSION_REPORTS];
+
+    NEXT_DECLARE_SENTINEL( 18 )
 };
 
 void next_server_internal_initialize_sentinels( next_server_internal_t * server )
@@ -399,6 +421,7 @@ void next_server_internal_initialize_sentinels( next_server_internal_t * server
     NEXT_INITIALIZE_SENTINEL( server, 15 )
     NEXT_INITIALIZE_SENTINEL( server, 16 )
     NEXT_INITIALIZE_SENTINEL( server, 17 )
+    NEXT_INITIALIZE_SENTINEL( server, 18 )
 }
 
 void next_server_internal_verify_sentinels( next_server_internal_t * server )
@@ -423,6 +446,7 @@ void next_server_internal_verify_sentinels( next_server_internal_t * server )
     NEXT_VERIFY_SENTINEL( server, 15 )
     NEXT_VERIFY_SENTINEL( server, 16 )
     NEXT_VERIFY_SENTINEL( server, 17 )
+    NEXT_VERIFY_SENTINEL( server, 18 )
     if ( server->session_manager )
         next_session_manager_verify_sentinels( server->session_manager );
     if ( server->pending_session_manager )
@@ -1770,6 +1794,11 @@ void next_server_internal_process_network_next_packet( next_server_internal_t *
 
             next_printf( NEXT_LOG_LEVEL_INFO, "welcome to network next :)" );
 
+            if ( packet.upgrade_recommended )
+            {
+                next_printf( NEXT_LOG_LEVEL_WARN, "this version of the network next sdk (%s) is deprecated. please upgrade to the latest sdk", NEXT_VERSION_FULL );
+            }
+
             memcpy( server->upcoming_magic, packet.upcoming_magic, 8 );
             memcpy( server->current_magic, packet.current_magic, 8 );
             memcpy( server->previous_magic, packet.previous_magic, 8 );
@@ -2138,6 +2167,35 @@ void next_server_internal_process_network_next_packet( next_server_internal_t *
         }
     }
 
+    // backend session report response
+
+    if ( packet_id == NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET )
+    {
+        next_printf( NEXT_LOG_LEVEL_SPAM, "server processing session report response packet" );
+
+        NextBackendSessionReportResponsePacket packet;
+
+        if ( next_read_backend_packet( packet_id, packet_data, begin, end, &packet, next_signed_packets, next_server_backend_public_key ) != packet_id )
+        {
+            next_printf( NEXT_LOG_LEVEL_DEBUG, "server ignored session report response packet from backend. packet failed to read" );
+            return;
+        }
+
+        for ( int i = 0; i < server->num_pending_session_reports; i++ )
+        {
+            if ( server->pending_session_report_packets[i].request_id == packet.request_id && server->pending_session_report_packets[i].session_id == packet.session_id )
+            {
+                next_printf( NEXT_LOG_LEVEL_DEBUG, "server sent session report %u to backend for session %016" PRIx64, server->pending_session_report_packets[i].event_code, packet.session_id );
+                next_server_internal_remove_session_report( server, i );
+                return;
+            }
+        }
+
+        next_printf( NEXT_LOG_LEVEL_DEBUG, "server ignored session report response packet from backend. request id does not match" );
+
+        return;
+    }
+
     // backend client relay response
 
     if ( packet_id == NEXT_BACKEND_CLIENT_RELAY_RESPONSE_PACKET )
@@ -3060,6 +3118,145 @@ void next_server_internal_session_events( next_server_internal_t * server, const
     next_printf( NEXT_LOG_LEVEL_DEBUG, "server set session event %" PRIx64 " for session %" PRIx64 " at address %s", session_events, entry->session_id, next_address_to_string( address, buffer ) );
 }
 
+void next_server_internal_session_report( next_server_internal_t * server, const next_address_t * address, uint32_t event_code, const char tags[][NEXT_MAX_SESSION_REPORT_TAG_LENGTH], int num_tags )
+{
+    next_assert( server );
+    next_assert( address );
+    next_assert( num_tags >= 0 );
+    next_assert( num_tags <= NEXT_MAX_SESSION_REPORT_TAGS );
+
+    next_server_internal_verify_sentinels( server );
+
+    if ( next_global_config.disable_network_next )
+        return;
+
+    if ( server->state != NEXT_SERVER_STATE_INITIALIZED )
+        return;
+
+    char buffer[NEXT_MAX_ADDRESS_STRING_LENGTH];
+
+    next_session_entry_t * entry = next_session_manager_find_by_address( server->session_manager, address );
+    if ( !entry )
+    {
+        next_printf( NEXT_LOG_LEVEL_DEBUG, "could not find session at address %s. not sending session report %u", next_address_to_string( address, buffer ), event_code );
+        return;
+    }
+
+    if ( server->num_pending_session_reports == NEXT_MAX_PENDING_SESSION_REPORTS )
+    {
+        next_printf( NEXT_LOG_LEVEL_WARN, "server dropped session report %u for session %016" PRIx64 ". too many pending session reports", event_code, entry->session_id );
+        return;
+    }
+
+    const int index = server->num_pending_session_reports++;
+
+    NextBackendSessionReportRequestPacket & packet = server->pending_session_report_packets[index];
+
+    packet = NextBackendSessionReportRequestPacket();
+    packet.buyer_id = server->buyer_id;
+    packet.datacenter_id = server->datacenter_id;
+    packet.session_id = entry->session_id;
+    packet.request_id = next_random_uint64();
+    packet.event_code = event_code;
+    packet.num_tags = num_tags;
+    for ( int i = 0; i < num_tags; i++ )
+    {
+        next_copy_string( packet.tags[i], tags[i], NEXT_MAX_SESSION_REPORT_TAG_LENGTH );
+    }
+
+    const double current_time = next_platform_time();
+
+    server->pending_session_report_send_time[index] = current_time;
+    server->pending_session_report_timeout_time[index] = current_time + NEXT_SESSION_REPORT_TIMEOUT;
+
+    next_printf( NEXT_LOG_LEVEL_DEBUG, "server queued session report %u for session %016" PRIx64 " at address %s", event_code, entry->session_id, next_address_to_string( address, buffer ) );
+}
+
+void next_server_internal_remove_session_report( next_server_internal_t * server, int index )
+{
+    next_assert( index >= 0 );
+    next_assert( index < server->num_pending_session_reports );
+
+    const int last = server->num_pending_session_reports - 1;
+
+    if ( index != last )
+    {
+        server->pending_session_report_packets[index] = server->pending_session_report_packets[last];
+        server->pending_session_report_send_time[index] = server->pending_session_report_send_time[last];
+        server->pending_session_report_timeout_time[index] = server->pending_session_report_timeout_time[last];
+    }
+
+    server->num_pending_session_reports--;
+}
+
+void next_server_internal_update_session_reports( next_server_internal_t * server )
+{
+    next_assert( server );
+
+    next_server_internal_verify_sentinels( server );
+
+    next_assert( !next_global_config.disable_network_next );
+
+    if ( server->state != NEXT_SERVER_STATE_INITIALIZED )
+        return;
+
+    const double current_time = next_platform_time();
+
+    int i = 0;
+
+    while ( i < server->num_pending_session_reports )
+    {
+        NextBackendSessionReportRequestPacket & packet = server->pending_session_report_packets[i];
+
+        // have we timed out?
+
+        if ( server->pending_session_report_timeout_time[i] < current_time )
+        {
+            next_printf( NEXT_LOG_LEVEL_WARN, "server timed out sending session report %u for session %016" PRIx64, packet.event_code, packet.session_id );
+            next_server_internal_remove_session_report( server, i );
+            continue;
+        }
+
+        // should we resend the session report request packet?
+
+        if ( server->pending_session_report_send_time[i] < current_time )
+        {
+            next_printf( NEXT_LOG_LEVEL_DEBUG, "send session report request packet" );
+
+            uint8_t packet_data[NEXT_MAX_PACKET_BYTES];
+
+            next_assert( ( size_t( packet_data ) % 4 ) == 0 );
+
+            uint8_t magic[8];
+            memset( magic, 0, sizeof( magic ) );
+
+            uint8_t from_address_data[4];
+            uint8_t to_address_data[4];
+
+            next_address_data( &server->server_address, from_address_data );
+            next_address_data( &server->backend_address, to_address_data );
+            int packet_bytes = 0;
+            if ( next_write_backend_packet( NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET, &packet, packet_data, &packet_bytes, next_signed_packets, server->buyer_private_key, magic, from_address_data, to_address_data ) != NEXT_OK )
+            {
+                next_printf( NEXT_LOG_LEVEL_ERROR, "server failed to write session report request packet for backend" );
+                next_server_internal_remove_session_report( server, i );
+                continue;
+            }
+
+#if NEXT_ADVANCED_PACKET_FILTER
+            next_assert( next_basic_packet_filter( packet_data, packet_bytes ) );
+            next_assert( next_advanced_packet_filter( packet_data, magic, from_address_data, to_address_data, packet_bytes ) );
+#endif // #if NEXT_ADVANCED_PACKET_FILTER
+
+            next_server_internal_send_packet_to_backend( server, packet_data, packet_bytes );
+
+            server->pending_session_report_send_time[i] = current_time + NEXT_SESSION_REPORT_SEND_RATE;
+        }
+
+        i++;
+    }
+}
+
 void next_server_internal_flush_session_update( next_server_internal_t * server )
 {
     next_assert( server );
@@ -3159,6 +3356,16 @@ void next_server_internal_pump_commands( next_server_internal_t * server )
             }
             break;
 
+            case NEXT_SERVER_COMMAND_SESSION_REPORT:
+            {
+#if NEXT_SPIKE_TRACKING
+                next_printf( NEXT_LOG_LEVEL_SPAM, "server internal thread receives NEXT_SERVER_COMMAND_SESSION_REPORT" );
+#endif // #if NEXT_SPIKE_TRACKING
+                next_server_command_session_report_t * report = (next_server_command_session_report_t *) command;
+                next_server_internal_session_report( server, &report->address, report->event_code, report->tags, report->num_tags );
+            }
+            break;
+
             case NEXT_SERVER_COMMAND_UPDATE:
             {
 #if NEXT_SPIKE_TRACKING
@@ -4051,6 +4258,8 @@ static void next_server_update_internal( next_server_internal_t * server )
 
     next_server_internal_update_sessions( server );
 
+    next_server_internal_update_session_reports( server );
+
     next_server_internal_backend_update( server );
 
     next_server_internal_pump_commands( server );
@@ -4850,6 +5059,55 @@ void next_server_session_event( struct next_server_t * server, const struct next
     }
 }
 
+void next_server_session_report( struct next_server_t * server, const struct next_address_t * address, uint32_t event_code, const char ** tags, int num_tags )
+{
+    next_assert( server );
+    next_assert( address );
+    next_assert( server->internal );
+    next_assert( num_tags >= 0 );
+    next_assert( num_tags <= NEXT_MAX_SESSION_REPORT_TAGS );
+    next_assert( num_tags == 0 || tags );
+
+    if ( server->flushing )
+    {
+        next_printf( NEXT_LOG_LEVEL_WARN, "ignoring session report. server is flushed" );
+        return;
+    }
+
+    if ( num_tags > NEXT_MAX_SESSION_REPORT_TAGS )
+    {
+        num_tags = NEXT_MAX_SESSION_REPORT_TAGS;
+    }
+
+    // send session report command to internal server
+
+    next_server_command_session_report_t * command = (next_server_command_session_report_t *) next_malloc( server->context, sizeof( next_server_command_session_report_t ) );
+    if ( !command )
+    {
+        next_printf( NEXT_LOG_LEVEL_ERROR, "session report failed. could not create session report command" );
+        return;
+    }
+
+    memset( command, 0, sizeof( next_server_command_session_report_t ) );
+
+    command->type = NEXT_SERVER_COMMAND_SESSION_REPORT;
+    command->address = *address;
+    command->event_code = event_code;
+    command->num_tags = num_tags;
+    for ( int i = 0; i < num_tags; i++ )
+    {
+        next_copy_string( command->tags[i], tags[i], NEXT_MAX_SESSION_REPORT_TAG_LENGTH );
+    }
+
+    {
+#if NEXT_SPIKE_TRACKING
+        next_printf( NEXT_LOG_LEVEL_SPAM, "server queues up NEXT_SERVER_COMMAND_SESSION_REPORT from %s:%d", __FILE__, __LINE__ );
+#endif // #if NEXT_SPIKE_TRACKING
+        next_platform_mutex_guard( &server->internal->command_mutex );
+        next_queue_push( server->internal->command_queue, command );
+    }
+}
+
 void next_server_flush( struct next_server_t * server )
 {
     next_assert( server );
@@ -3695,6 +3695,7 @@ void test_server_init_response_packet()
         next_crypto_random_bytes( in.upcoming_magic, 8 );
         next_crypto_random_bytes( in.current_magic, 8 );
         next_crypto_random_bytes( in.previous_magic, 8 );
+        in.upgrade_recommended = true;
 
         int packet_bytes = 0;
         next_check( next_write_backend_packet( NEXT_BACKEND_SERVER_INIT_RESPONSE_PACKET, &in, packet_data, &packet_bytes, next_signed_packets, private_key, magic, from_address, to_address ) == NEXT_OK );
@@ -3717,6 +3718,7 @@ void test_server_init_response_packet()
         next_check( memcmp( in.upcoming_magic, out.upcoming_magic, 8 ) == 0 );
         next_check( memcmp( in.current_magic, out.current_magic, 8 ) == 0 );
         next_check( memcmp( in.previous_magic, out.previous_magic, 8 ) == 0 );
+        next_check( in.upgrade_recommended == out.upgrade_recommended );
     }
 }
 
@@ -4353,6 +4355,109 @@ void test_server_relay_response_packet()
     }
 }
 
+void test_session_report_request_packet()
+{
+    uint8_t packet_data[NEXT_MAX_PACKET_BYTES];
+    uint64_t iterations = 100;
+    for ( uint64_t j = 0; j < iterations; ++j )
+    {
+        unsigned char public_key[NEXT_CRYPTO_SIGN_PUBLICKEYBYTES];
+        unsigned char private_key[NEXT_CRYPTO_SIGN_SECRETKEYBYTES];
+        next_crypto_sign_keypair( public_key, private_key );
+
+        uint8_t magic[8];
+        uint8_t from_address[4];
+        uint8_t to_address[4];
+        next_crypto_random_bytes( magic, 8 );
+        next_crypto_random_bytes( from_address, 4 );
+        next_crypto_random_bytes( to_address, 4 );
+
+        static NextBackendSessionReportRequestPacket in, out;
+        in.buyer_id = next_random_uint64();
+        in.datacenter_id = next_random_uint64();
+        in.session_id = next_random_uint64();
+        in.request_id = next_random_uint64();
+        in.event_code = uint32_t( next_random_uint64() );
+        in.num_tags = rand() % ( NEXT_MAX_SESSION_REPORT_TAGS + 1 );
+        for ( int i = 0; i < in.num_tags; i++ )
+        {
+            snprintf( in.tags[i], NEXT_MAX_SESSION_REPORT_TAG_LENGTH, "tag %d %016" PRIx64, i, next_random_uint64() );
+        }
+
+        int packet_bytes = 0;
+        next_check( next_write_backend_packet( NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET, &in, packet_data, &packet_bytes, next_signed_packets, private_key, magic, from_address, to_address ) == NEXT_OK );
+
+        const uint8_t packet_id = packet_data[0];
+        next_check( packet_id == NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET );
+
+        next_check( next_basic_packet_filter( packet_data, packet_bytes ) );
+#if NEXT_ADVANCED_PACKET_FILTER
+        next_check( next_advanced_packet_filter( packet_data, magic, from_address, to_address, packet_bytes ) );
+#endif // #if NEXT_ADVANCED_PACKET_FILTER
+
+        const int begin = 18;
+        const int end = packet_bytes;
+
+        next_check( next_read_backend_packet( packet_id, packet_data, begin, end, &out, next_signed_packets, public_key ) == NEXT_BACKEND_SESSION_REPORT_REQUEST_PACKET );
+
+        next_check( in.version_major == out.version_major );
+        next_check( in.version_minor == out.version_minor );
+        next_check( in.version_patch == out.version_patch );
+        next_check( in.buyer_id == out.buyer_id );
+        next_check( in.datacenter_id == out.datacenter_id );
+        next_check( in.session_id == out.session_id );
+        next_check( in.request_id == out.request_id );
+        next_check( in.event_code == out.event_code );
+        next_check( in.num_tags == out.num_tags );
+        for ( int i = 0; i < in.num_tags; i++ )
+        {
+            next_check( strcmp( in.tags[i], out.tags[i] ) == 0 );
+        }
+    }
+}
+
+void test_session_report_response_packet()
+{
+    uint8_t packet_data[NEXT_MAX_PACKET_BYTES];
+    uint64_t iterations = 100;
+    for ( uint64_t i = 0; i < iterations; ++i )
+    {
+        unsigned char public_key[NEXT_CRYPTO_SIGN_PUBLICKEYBYTES];
+        unsigned char private_key[NEXT_CRYPTO_SIGN_SECRETKEYBYTES];
+        next_crypto_sign_keypair( public_key, private_key );
+
+        uint8_t magic[8];
+        uint8_t from_address[4];
+        uint8_t to_address[4];
+        next_crypto_random_bytes( magic, 8 );
+        next_crypto_random_bytes( from_address, 4 );
+        next_crypto_random_bytes( to_address, 4 );
+
+        static NextBackendSessionReportResponsePacket in, out;
+        in.session_id = next_random_uint64();
+        in.request_id = next_random_uint64();
+
+        int packet_bytes = 0;
+        next_check( next_write_backend_packet( NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET, &in, packet_data, &packet_bytes, next_signed_packets, private_key, magic, from_address, to_address ) == NEXT_OK );
+
+        const uint8_t packet_id = packet_data[0];
+        next_check( packet_id == NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET );
+
+        next_check( next_basic_packet_filter( packet_data, packet_bytes ) );
+#if NEXT_ADVANCED_PACKET_FILTER
+        next_check( next_advanced_packet_filter( packet_data, magic, from_address, to_address, packet_bytes ) );
+#endif // #if NEXT_ADVANCED_PACKET_FILTER
+
+        const int begin = 18;
+        const int end = packet_bytes;
+
+        next_check( next_read_backend_packet( packet_id, packet_data, begin, end, &out, next_signed_packets, public_key ) == NEXT_BACKEND_SESSION_REPORT_RESPONSE_PACKET );
+
+        next_check( in.session_id == out.session_id );
+        next_check( in.request_id == out.request_id );
+    }
+}
+
 static uint64_t test_passthrough_packets_client_packets_received;
 
 void test_passthrough_packets_client_packet_received_callback( next_client_t * client, void * context, const next_address_t * from, const uint8_t * packet_data, int packet_bytes )
@@ -4618,6 +4723,8 @@ void next_run_tests()
         RUN_TEST( test_client_relay_response_packet );
         RUN_TEST( test_server_relay_request_packet );
         RUN_TEST( test_server_relay_response_packet );
+        RUN_TEST( test_session_report_request_packet );
+        RUN_TEST( test_session_report_response_packet );
 #if NEXT_PLATFORM_CAN_RUN_SERVER
         RUN_TEST( test_passthrough_packets );
 #endif // #if NEXT_PLATFORM_CAN_RUN_SERVER
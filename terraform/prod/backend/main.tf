@@ -18,6 +18,7 @@ locals {
   google_zones                = ["us-central1-a", "us-central1-b", "us-central1-c"]   # IMPORTANT: c3 family is only available in these zones, not us-central1-f
   google_artifacts_bucket     = "gs://sloclap_network_next_backend_artifacts"
   google_database_bucket      = "gs://sloclap_network_next_database_files"
+  matrix_snapshot_bucket      = "gs://sloclap_network_next_prod_matrix_snapshots"
 
   cloudflare_api_token        = "~/secrets/terraform-cloudflare.txt"
   cloudflare_zone_id          = "eba5d882ea2aa23f92dfb50fbf7e3cf4"
@@ -59,6 +60,9 @@ locals {
   ping_key                    = file("~/secrets/prod-ping-key.txt")
 
   magic_key                   = file("~/secrets/prod-magic-key.txt")
+
+  session_data_private_keys   = try(file("~/secrets/prod-session-data-private-keys.txt"), "")
+  secrets_master_key          = try(file("~/secrets/prod-secrets-master-key.txt"), "")
 }
 
 # ----------------------------------------------------------------------------------------
@@ -384,6 +388,7 @@ locals {
     "server_relay_ping",
     "session_update",
     "session_summary",
+    "session_report",
   ]
   
 }
@@ -437,6 +442,7 @@ locals {
   bigquery_tables = {
     "session_update"      = file("../../../schemas/bigquery/session_update.json")
     "session_summary"     = file("../../../schemas/bigquery/session_summary.json")
+    "session_report"      = file("../../../schemas/bigquery/session_report.json")
     "server_init"         = file("../../../schemas/bigquery/server_init.json")
     "server_update"       = file("../../../schemas/bigquery/server_update.json")
     "relay_update"        = file("../../../schemas/bigquery/relay_update.json")
@@ -449,6 +455,7 @@ locals {
   bigquery_table_clustering = {
     "session_update"      = [ "session_id" ]
     "session_summary"     = [ "buyer_id", "user_hash" ]
+    "session_report"      = [ "session_id" ]
     "server_update"       = [ "datacenter_id", "buyer_id" ]
     "server_init"         = [ "datacenter_id", "buyer_id" ]
     "relay_update"        = [ "relay_id" ]
@@ -610,6 +617,7 @@ module "relay_gateway" {
     DATABASE_PATH="/app/database.bin"
     RELAY_BACKEND_PUBLIC_KEY=${local.relay_backend_public_key}
     RELAY_BACKEND_PRIVATE_KEY=${local.relay_backend_private_key}
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     PING_KEY=${local.ping_key}
     RELAY_BACKEND_ADDRESS=""
     EOF
@@ -671,6 +679,8 @@ module "relay_backend" {
     REDIS_PORTAL_HOSTNAME="${google_redis_instance.redis.host}:6379"
     RELAY_BACKEND_PUBLIC_KEY=${local.relay_backend_public_key}
     RELAY_BACKEND_PRIVATE_KEY=${local.relay_backend_private_key}
+    MATRIX_SNAPSHOT_URL="${local.matrix_snapshot_bucket}"
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     EOF
     sudo gsutil cp ${local.google_database_bucket}/prod.bin /app/database.bin
     sudo systemctl start app.service
@@ -731,6 +741,7 @@ module "api" {
     DATABASE_PATH="/app/database.bin"
     PGSQL_CONFIG="host=${google_sql_database_instance.postgres.ip_address.0.ip_address} port=5432 user=developer password=developer dbname=database sslmode=disable"
     API_PRIVATE_KEY=${local.api_private_key}
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     ALLOWED_ORIGIN="*"
     EOF
     gsutil cp ${local.google_database_bucket}/prod.bin /app/database.bin
@@ -908,9 +919,11 @@ module "server_backend" {
     MAGIC_URL="http://${module.magic_backend.address}/magic"
     RELAY_BACKEND_PUBLIC_KEY=${local.relay_backend_public_key}
     RELAY_BACKEND_PRIVATE_KEY=${local.relay_backend_private_key}
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     SERVER_BACKEND_ADDRESS="##########:40000"
     SERVER_BACKEND_PUBLIC_KEY=${local.server_backend_public_key}
     SERVER_BACKEND_PRIVATE_KEY=${local.server_backend_private_key}
+    SESSION_DATA_PRIVATE_KEYS="${local.session_data_private_keys}"
     ROUTE_MATRIX_URL="http://${module.relay_backend.address}/route_matrix"
     PING_KEY=${local.ping_key}
     IP2LOCATION_BUCKET_NAME=${local.ip2location_bucket_name}
@@ -168,6 +168,59 @@ resource "google_storage_bucket" "prod" {
   uniform_bucket_level_access = true
 }
 
+# matrix snapshots written by the leader relay backend. it prunes them after MATRIX_SNAPSHOT_RETENTION, the lifecycle rule is a backstop
+
+resource "google_storage_bucket" "dev_matrix_snapshots" {
+  name          = "${local.company_name}_network_next_dev_matrix_snapshots"
+  project       = google_project.storage.project_id
+  location      = "US"
+  force_destroy = true
+  public_access_prevention = "enforced"
+  uniform_bucket_level_access = true
+  lifecycle_rule {
+    condition {
+      age = 30
+    }
+    action {
+      type = "Delete"
+    }
+  }
+}
+
+resource "google_storage_bucket" "staging_matrix_snapshots" {
+  name          = "${local.company_name}_network_next_staging_matrix_snapshots"
+  project       = google_project.storage.project_id
+  location      = "US"
+  force_destroy = true
+  public_access_prevention = "enforced"
+  uniform_bucket_level_access = true
+  lifecycle_rule {
+    condition {
+      age = 30
+    }
+    action {
+      type = "Delete"
+    }
+  }
+}
+
+resource "google_storage_bucket" "prod_matrix_snapshots" {
+  name          = "${local.company_name}_network_next_prod_matrix_snapshots"
+  project       = google_project.storage.project_id
+  location      = "US"
+  force_destroy = true
+  public_access_prevention = "enforced"
+  uniform_bucket_level_access = true
+  lifecycle_rule {
+    condition {
+      age = 30
+    }
+    action {
+      type = "Delete"
+    }
+  }
+}
+
 # create service accounts so semaphore can upload artifacts
 
 resource "google_service_account" "terraform_storage" {
@@ -350,6 +403,13 @@ resource "google_storage_bucket_iam_member" "dev_runtime_dev_storage_admin" {
   depends_on = [google_storage_bucket.dev]
 }
 
+resource "google_storage_bucket_iam_member" "dev_runtime_dev_matrix_snapshots_storage_admin" {
+  bucket = google_storage_bucket.dev_matrix_snapshots.name
+  role   = "roles/storage.objectAdmin"
+  member = google_service_account.dev_runtime.member
+  depends_on = [google_storage_bucket.dev_matrix_snapshots]
+}
+
 resource "google_storage_bucket_iam_member" "terraform_dev_object_admin" {
   bucket = google_storage_bucket.terraform.name
   role   = "roles/storage.objectAdmin"
@@ -554,6 +614,13 @@ resource "google_storage_bucket_iam_member" "staging_runtime_staging_storage_adm
   depends_on = [google_storage_bucket.staging]
 }
 
+resource "google_storage_bucket_iam_member" "staging_runtime_staging_matrix_snapshots_storage_admin" {
+  bucket = google_storage_bucket.staging_matrix_snapshots.name
+  role   = "roles/storage.objectAdmin"
+  member = google_service_account.staging_runtime.member
+  depends_on = [google_storage_bucket.staging_matrix_snapshots]
+}
+
 resource "google_storage_bucket_iam_member" "terraform_staging_object_admin" {
   bucket = google_storage_bucket.terraform.name
   role   = "roles/storage.objectAdmin"
@@ -677,6 +744,13 @@ resource "google_storage_bucket_iam_member" "prod_runtime_prod_storage_admin" {
   depends_on = [google_storage_bucket.prod]
 }
 
+resource "google_storage_bucket_iam_member" "prod_runtime_prod_matrix_snapshots_storage_admin" {
+  bucket = google_storage_bucket.prod_matrix_snapshots.name
+  role   = "roles/storage.objectAdmin"
+  member = google_service_account.prod_runtime.member
+  depends_on = [google_storage_bucket.prod_matrix_snapshots]
+}
+
 resource "google_storage_bucket_iam_member" "terraform_prod_object_admin" {
   bucket = google_storage_bucket.terraform.name
   role   = "roles/storage.objectAdmin"
@@ -16,6 +16,7 @@ variable "google_zone" { type = string }
 variable "google_zones" { type = list(string) }
 variable "google_artifacts_bucket" { type = string }
 variable "google_database_bucket" { type = string }
+variable "matrix_snapshot_bucket" { type = string }
 
 variable "cloudflare_api_token" { type = string }
 variable "cloudflare_zone_id" { type = string }
@@ -43,6 +44,8 @@ locals {
   api_private_key            = file("~/secrets/staging-api-private-key.txt")
   ping_key                   = file("~/secrets/staging-ping-key.txt")
   magic_key                  = file("~/secrets/staging-magic-key.txt")
+  session_data_private_keys  = try(file("~/secrets/staging-session-data-private-keys.txt"), "")
+  secrets_master_key         = try(file("~/secrets/staging-secrets-master-key.txt"), "")
 }
 
 # ----------------------------------------------------------------------------------------
@@ -371,6 +374,7 @@ locals {
     "server_relay_ping",
     "session_update",
     "session_summary",
+    "session_report",
   ]
   
 }
@@ -424,6 +428,7 @@ locals {
   bigquery_tables = {
     "session_update"      = file("../../../schemas/bigquery/session_update.json")
     "session_summary"     = file("../../../schemas/bigquery/session_summary.json")
+    "session_report"      = file("../../../schemas/bigquery/session_report.json")
     "server_init"         = file("../../../schemas/bigquery/server_init.json")
     "server_update"       = file("../../../schemas/bigquery/server_update.json")
     "relay_update"        = file("../../../schemas/bigquery/relay_update.json")
@@ -436,6 +441,7 @@ locals {
   bigquery_table_clustering = {
     "session_update"      = [ "session_id" ]
     "session_summary"     = [ "buyer_id", "user_hash" ]
+    "session_report"      = [ "session_id" ]
     "server_update"       = [ "datacenter_id", "buyer_id" ]
     "server_init"         = [ "datacenter_id", "buyer_id" ]
     "relay_update"        = [ "relay_id" ]
@@ -594,6 +600,7 @@ module "relay_gateway" {
     DATABASE_PATH="/app/database.bin"
     RELAY_BACKEND_PUBLIC_KEY=${var.relay_backend_public_key}
     RELAY_BACKEND_PRIVATE_KEY=${local.relay_backend_private_key}
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     PING_KEY=${local.ping_key}
     RELAY_BACKEND_ADDRESS=""
     EOF
@@ -652,9 +659,12 @@ module "relay_backend" {
     ENABLE_GOOGLE_PUBSUB=true
     ENABLE_REDIS_TIME_SERIES=true
     REDIS_TIME_SERIES_HOSTNAME="${module.redis_time_series.address}:6379"
+    ENABLE_PORTAL_EVENTS=true
     REDIS_PORTAL_CLUSTER="${local.redis_portal_address}"
     RELAY_BACKEND_PUBLIC_KEY=${var.relay_backend_public_key}
     RELAY_BACKEND_PRIVATE_KEY=${local.relay_backend_private_key}
+    MATRIX_SNAPSHOT_URL="${var.matrix_snapshot_bucket}"
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     EOF
     sudo gsutil cp ${var.google_database_bucket}/staging.bin /app/database.bin
     sudo systemctl start app.service
@@ -715,6 +725,7 @@ module "api" {
     DATABASE_PATH="/app/database.bin"
     PGSQL_CONFIG="host=${google_sql_database_instance.postgres.ip_address.0.ip_address} port=5432 user=developer password=developer dbname=database sslmode=disable"
     API_PRIVATE_KEY=${local.api_private_key}
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     ALLOWED_ORIGIN="*"
     EOF
     sudo gsutil cp ${var.google_database_bucket}/staging.bin /app/database.bin
@@ -849,15 +860,18 @@ module "server_backend" {
     REDIS_CLUSTER="${local.redis_portal_address}"
     RELAY_BACKEND_PUBLIC_KEY=${var.relay_backend_public_key}
     RELAY_BACKEND_PRIVATE_KEY=${local.relay_backend_private_key}
+    SECRETS_MASTER_KEY="${local.secrets_master_key}"
     SERVER_BACKEND_ADDRESS="##########:40000"
     SERVER_BACKEND_PUBLIC_KEY=${var.server_backend_public_key}
     SERVER_BACKEND_PRIVATE_KEY=${local.server_backend_private_key}
+    SESSION_DATA_PRIVATE_KEYS="${local.session_data_private_keys}"
     ROUTE_MATRIX_URL="http://${module.relay_backend.address}/route_matrix"
     PING_KEY=${local.ping_key}
     IP2LOCATION_BUCKET_NAME=${var.ip2location_bucket_name}
     ENABLE_GOOGLE_PUBSUB=true
     ENABLE_REDIS_TIME_SERIES=true
     REDIS_TIME_SERIES_HOSTNAME="${module.redis_time_series.address}:6379"
+    ENABLE_PORTAL_EVENTS=true
     REDIS_PORTAL_CLUSTER="${local.redis_portal_address}"
     REDIS_RELAY_BACKEND_HOSTNAME="${google_redis_instance.redis_relay_backend.host}:6379"
     SESSION_CRUNCHER_URL="http://${module.session_cruncher.address}"
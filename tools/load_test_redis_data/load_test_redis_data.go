@@ -44,9 +44,9 @@ func RunRedisLeaderThreads(hostname string, threadCount int) {
 				leaderElection.Update(ctx)
 
 				if leaderElection.IsLeader() {
-					leaderElection.Store(ctx, "a", make([]byte, 1024*1024))
-					leaderElection.Store(ctx, "b", make([]byte, 10*1024*1024))
-					leaderElection.Store(ctx, "c", make([]byte, 100*1024*1024))
+					leaderElection.Store(ctx, "a", make([]byte, 1024*1024), 0)
+					leaderElection.Store(ctx, "b", make([]byte, 10*1024*1024), 0)
+					leaderElection.Store(ctx, "c", make([]byte, 100*1024*1024), 0)
 				}
 
 				a := leaderElection.Load(ctx, "a")
@@ -105,7 +105,7 @@ func RunRelayManagerThread(ctx context.Context, numRelays int, updateChan chan *
 				return
 
 			case update := <-updateChan:
-				relayManager.ProcessRelayUpdate(time.Now().Unix(), update.relayId, update.relayName, update.relayAddress, update.sessions, update.relayVersion, update.relayFlags, update.numSamples, update.sampleRelayId, update.sampleRTT, update.sampleJitter, update.samplePacketLoss, update.counters[:])
+				relayManager.ProcessRelayUpdate(time.Now().Unix(), update.relayId, update.relayName, update.relayAddress, update.sessions, update.relayVersion, update.relayFlags, update.numSamples, update.sampleRelayId, update.sampleRTT, update.sampleJitter, update.samplePacketLoss, nil, update.counters[:], nil)
 
 			case <-ticker.C:
 				start := time.Now()
@@ -12,12 +12,14 @@ import (
 	"crypto/ed25519"
 	crypto_rand "crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"golang.org/x/crypto/nacl/box"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -37,6 +39,7 @@ import (
 	"github.com/networknext/next/modules/core"
 	"github.com/networknext/next/modules/crypto"
 	db "github.com/networknext/next/modules/database"
+	"github.com/networknext/next/modules/envvar"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/modood/table"
@@ -67,6 +70,36 @@ func main() {
 	var relaysAlphaSort bool
 	relaysfs.BoolVar(&relaysAlphaSort, "alpha", false, "Sort relays by name, not by sessions carried")
 
+	rolloutfs := flag.NewFlagSet("rollout start", flag.ExitOnError)
+	var rolloutCanary string
+	rolloutfs.StringVar(&rolloutCanary, "canary", "", "Comma separated list of canary relays to upgrade first")
+	var rolloutPercent int
+	rolloutfs.IntVar(&rolloutPercent, "percent", 10, "Percentage of relays to upgrade after the canary relays")
+	var rolloutSellers string
+	rolloutfs.StringVar(&rolloutSellers, "sellers", "", "Comma separated list of seller codes to upgrade in order, after the percentage stage")
+	var rolloutSoak int64
+	rolloutfs.Int64Var(&rolloutSoak, "soak", 600, "Seconds each stage must soak before moving to the next")
+	var rolloutMaxRTT float64
+	rolloutfs.Float64Var(&rolloutMaxRTT, "max_rtt", 10, "Pause if an upgraded relay's mean rtt increases by more than this (milliseconds)")
+	var rolloutMaxErrorRate float64
+	rolloutfs.Float64Var(&rolloutMaxErrorRate, "max_error_rate", 0.1, "Pause if an upgraded relay's error rate increases by more than this (percent)")
+
+	costfs := flag.NewFlagSet("cost", flag.ExitOnError)
+	var costAt string
+	costfs.StringVar(&costAt, "at", "", "Get the cost matrix snapshot at this time: unix timestamp, RFC3339 time, or duration ago (eg. 2h)")
+	var costList bool
+	costfs.BoolVar(&costList, "list", false, "List the times of cost matrix snapshots")
+
+	auditfs := flag.NewFlagSet("audit", flag.ExitOnError)
+	var auditSince string
+	auditfs.StringVar(&auditSince, "since", "24h", "Show audit entries since this time: unix timestamp, RFC3339 time, or duration ago (eg. 2h)")
+	var auditActor string
+	auditfs.StringVar(&auditActor, "actor", "", "Only show audit entries by this actor")
+	var auditEntity string
+	auditfs.StringVar(&auditEntity, "entity", "", "Only show audit entries for this entity, eg. relay, buyer, database")
+	var auditVerbose bool
+	auditfs.BoolVar(&auditVerbose, "v", false, "Print the before and after of each change")
+
 	var selectCommand = &ffcli.Command{
 
 		Name:       "select",
@@ -81,7 +114,7 @@ func main() {
 
 	var keygenCommand = &ffcli.Command{
 		Name:       "keygen",
-		ShortUsage: "next keygen",
+		ShortUsage: "next keygen [session_data <stage|promote|retire>] [buyer <buyer public key base64>] [admin <operator>]",
 		ShortHelp:  "Generate new keypairs for network next",
 		Exec: func(ctx context.Context, args []string) error {
 			keygen(env, args)
@@ -375,13 +408,24 @@ func main() {
 
 	var costCommand = &ffcli.Command{
 		Name:       "cost",
-		ShortUsage: "next cost [output_file]",
+		ShortUsage: "next cost [flags] [output_file]",
 		ShortHelp:  "Get cost matrix from current environment",
+		FlagSet:    costfs,
 		Exec: func(ctx context.Context, args []string) error {
+			if costList {
+				listCostMatrixSnapshots(env)
+				return nil
+			}
 			output := "cost.bin"
 			if len(args) > 0 {
 				output = args[0]
 			}
+			if costAt != "" {
+				at := parseSnapshotTime(costAt)
+				getCostMatrixSnapshot(env, output, at)
+				fmt.Printf("Cost matrix snapshot at %s from %s saved to %s\n\n", time.Unix(at, 0).Format(time.RFC3339), env.Name, output)
+				return nil
+			}
 			getCostMatrix(env, output)
 			fmt.Printf("Cost matrix from %s saved to %s\n\n", env.Name, output)
 			return nil
@@ -450,6 +494,133 @@ func main() {
 		},
 	}
 
+	var rolloutStartCommand = &ffcli.Command{
+		Name:       "start",
+		ShortUsage: "next rollout start [flags] <version>",
+		ShortHelp:  "Start rolling out a relay version: canary relays, then a percentage, then per seller, then all relays",
+		FlagSet:    rolloutfs,
+		Exec: func(_ context.Context, args []string) error {
+
+			if len(args) != 1 {
+				handleRunTimeError(fmt.Sprintln("you must supply the relay version to roll out"), 0)
+			}
+
+			startRollout(env, args[0], splitList(rolloutCanary), rolloutPercent, splitList(rolloutSellers), rolloutSoak, float32(rolloutMaxRTT), float32(rolloutMaxErrorRate))
+
+			return nil
+		},
+	}
+
+	var rolloutPauseCommand = &ffcli.Command{
+		Name:       "pause",
+		ShortUsage: "next rollout pause",
+		ShortHelp:  "Pause the relay rollout in progress",
+		Exec: func(_ context.Context, args []string) error {
+			modifyRollout(env, "pause")
+			return nil
+		},
+	}
+
+	var rolloutResumeCommand = &ffcli.Command{
+		Name:       "resume",
+		ShortUsage: "next rollout resume",
+		ShortHelp:  "Resume a paused relay rollout, accepting upgraded relays as they are",
+		Exec: func(_ context.Context, args []string) error {
+			modifyRollout(env, "resume")
+			return nil
+		},
+	}
+
+	var rolloutAbortCommand = &ffcli.Command{
+		Name:       "abort",
+		ShortUsage: "next rollout abort",
+		ShortHelp:  "Abort the relay rollout. Relays go back to the version in the database",
+		Exec: func(_ context.Context, args []string) error {
+			modifyRollout(env, "abort")
+			return nil
+		},
+	}
+
+	var bootstrapTokenCommand = &ffcli.Command{
+		Name:       "token",
+		ShortUsage: "next bootstrap token <datacenter> [expiry_hours]",
+		ShortHelp:  "Create a one-time token a new relay in the datacenter uses to register itself",
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) < 1 {
+				handleRunTimeError(fmt.Sprintln("you must supply the datacenter name"), 0)
+			}
+			expiryHours := 24
+			if len(args) > 1 {
+				value, err := strconv.Atoi(args[1])
+				if err != nil {
+					handleRunTimeError(fmt.Sprintf("invalid expiry hours '%s'\n", args[1]), 0)
+				}
+				expiryHours = value
+			}
+			createRelayBootstrapToken(env, args[0], expiryHours)
+			return nil
+		},
+	}
+
+	var bootstrapApproveCommand = &ffcli.Command{
+		Name:       "approve",
+		ShortUsage: "next bootstrap approve <registration_id>",
+		ShortHelp:  "Approve a pending relay. This creates the relay in the database",
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) != 1 {
+				handleRunTimeError(fmt.Sprintln("you must supply the registration id"), 0)
+			}
+			modifyPendingRelay(env, "approve", args[0], "")
+			return nil
+		},
+	}
+
+	var bootstrapRejectCommand = &ffcli.Command{
+		Name:       "reject",
+		ShortUsage: "next bootstrap reject <registration_id> [reason]",
+		ShortHelp:  "Reject a pending relay",
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) < 1 {
+				handleRunTimeError(fmt.Sprintln("you must supply the registration id"), 0)
+			}
+			modifyPendingRelay(env, "reject", args[0], strings.Join(args[1:], " "))
+			return nil
+		},
+	}
+
+	var bootstrapCommand = &ffcli.Command{
+		Name:        "bootstrap",
+		ShortUsage:  "next bootstrap [token|approve|reject]",
+		ShortHelp:   "Show relays that registered with a bootstrap token and are waiting for approval",
+		Subcommands: []*ffcli.Command{bootstrapTokenCommand, bootstrapApproveCommand, bootstrapRejectCommand},
+		Exec: func(_ context.Context, args []string) error {
+			printPendingRelays(env)
+			return nil
+		},
+	}
+
+	var auditCommand = &ffcli.Command{
+		Name:       "audit",
+		ShortUsage: "next audit [flags]",
+		ShortHelp:  "Show the audit log of admin changes in the current environment",
+		FlagSet:    auditfs,
+		Exec: func(_ context.Context, args []string) error {
+			printAuditLog(env, parseSnapshotTime(auditSince), auditActor, auditEntity, auditVerbose)
+			return nil
+		},
+	}
+
+	var rolloutCommand = &ffcli.Command{
+		Name:        "rollout",
+		ShortUsage:  "next rollout [start|pause|resume|abort]",
+		ShortHelp:   "Show progress of the relay rollout in the current environment",
+		Subcommands: []*ffcli.Command{rolloutStartCommand, rolloutPauseCommand, rolloutResumeCommand, rolloutAbortCommand},
+		Exec: func(_ context.Context, args []string) error {
+			printRollout(env)
+			return nil
+		},
+	}
+
 	var commands = []*ffcli.Command{
 		keygenCommand,
 		configCommand,
@@ -468,6 +639,9 @@ func main() {
 		logCommand,
 		setupCommand,
 		loadCommand,
+		rolloutCommand,
+		bootstrapCommand,
+		auditCommand,
 		startCommand,
 		stopCommand,
 		restartCommand,
@@ -551,9 +725,12 @@ func replace(filename string, pattern string, replacement string) {
 }
 
 func generateBuyerKeypair() (buyerPublicKey []byte, buyerPrivateKey []byte) {
-
 	buyerId := make([]byte, 8)
 	crypto_rand.Read(buyerId)
+	return generateBuyerKeypairWithId(buyerId)
+}
+
+func generateBuyerKeypairWithId(buyerId []byte) (buyerPublicKey []byte, buyerPrivateKey []byte) {
 
 	publicKey, privateKey, err := ed25519.GenerateKey(nil)
 	if err != nil {
@@ -618,6 +795,22 @@ func readEnvSecret(env string, keypairs map[string]string, name string) {
 	keypairs[name] = strings.TrimSpace(string(data))
 }
 
+// sealEnvSecret encrypts a private key with the env's secrets master key before it is written to an env file.
+// Services decrypt it with the master key from SECRETS_MASTER_KEY_FILE.
+func sealEnvSecret(keypairs map[string]string, name string) string {
+	masterKey, err := base64.StdEncoding.DecodeString(keypairs["secrets_master_key"])
+	if err != nil {
+		fmt.Printf("\nerror: secrets master key is not valid base64: %v\n\n", err)
+		os.Exit(1)
+	}
+	sealed, err := envvar.EncryptSecret(keypairs[name], masterKey)
+	if err != nil {
+		fmt.Printf("\nerror: could not encrypt %s: %v\n\n", name, err)
+		os.Exit(1)
+	}
+	return sealed
+}
+
 func secretsAlreadyExist() bool {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -701,6 +894,21 @@ func selectEnvironment(args []string) error {
 
 func keygen(env Environment, regexes []string) {
 
+	if len(regexes) > 0 && regexes[0] == "session_data" {
+		keygenSessionData(env, regexes[1:])
+		return
+	}
+
+	if len(regexes) > 0 && regexes[0] == "buyer" {
+		keygenBuyer(regexes[1:])
+		return
+	}
+
+	if len(regexes) > 0 && regexes[0] == "admin" {
+		keygenAdmin(env, regexes[1:])
+		return
+	}
+
 	if secretsAlreadyExist() {
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("*** WARNING ***\n\nSecrets already exist.\n\nRunning keygen will overwrite your secrets, and you'll lose control of any system that you've already deployed.\n\nAre you sure you want to continue? (yes/no): ")
@@ -764,12 +972,21 @@ func keygen(env Environment, regexes []string) {
 			jwt.RegisteredClaims
 		}
 
+		// the shared admin key is for terraform (and func tests in local). audited changes are attributed to the subject,
+		// so operators use their own key from 'next keygen admin <operator>'
+
+		adminSubject := "terraform"
+		if envs[i] == "local" {
+			adminSubject = "func test"
+		}
+
 		adminClaims := Claims{
 			true,
 			true,
 			jwt.RegisteredClaims{
 				IssuedAt: jwt.NewNumericDate(time.Now()),
 				Issuer:   "next keygen",
+				Subject:  adminSubject,
 			},
 		}
 		adminToken := jwt.NewWithClaims(jwt.SigningMethodHS256, adminClaims)
@@ -800,6 +1017,8 @@ func keygen(env Environment, regexes []string) {
 		magicKey := [32]byte{}
 		common.RandomBytes(magicKey[:])
 
+		secretsMasterKey := crypto.Secrets_MasterKey()
+
 		fmt.Printf("	Relay backend public key       = %s\n", base64.StdEncoding.EncodeToString(relayBackendPublicKey[:]))
 		fmt.Printf("	Relay backend private key      = %s\n", base64.StdEncoding.EncodeToString(relayBackendPrivateKey[:]))
 		fmt.Printf("	Server backend public key      = %s\n", base64.StdEncoding.EncodeToString(serverBackendPublicKey[:]))
@@ -808,7 +1027,8 @@ func keygen(env Environment, regexes []string) {
 		fmt.Printf("	Admin API key                  = %s\n", adminAPIKey)
 		fmt.Printf("	Portal API key                 = %s\n", portalAPIKey)
 		fmt.Printf("	Ping key                       = %s\n", base64.StdEncoding.EncodeToString(pingKey[:]))
-		fmt.Printf("	Magic key                      = %s\n\n", base64.StdEncoding.EncodeToString(magicKey[:]))
+		fmt.Printf("	Magic key                      = %s\n", base64.StdEncoding.EncodeToString(magicKey[:]))
+		fmt.Printf("	Secrets master key             = %s\n\n", base64.StdEncoding.EncodeToString(secretsMasterKey))
 
 		m := make(map[string]string)
 
@@ -821,6 +1041,7 @@ func keygen(env Environment, regexes []string) {
 		m["portal_api_key"] = portalAPIKey
 		m["ping_key"] = base64.StdEncoding.EncodeToString(pingKey[:])
 		m["magic_key"] = base64.StdEncoding.EncodeToString(magicKey[:])
+		m["secrets_master_key"] = base64.StdEncoding.EncodeToString(secretsMasterKey)
 
 		keypairs[envs[i]] = m
 	}
@@ -861,6 +1082,7 @@ func keygen(env Environment, regexes []string) {
 		writeEnvSecret(k, v, "portal_api_key")
 		writeEnvSecret(k, v, "ping_key")
 		writeEnvSecret(k, v, "magic_key")
+		writeEnvSecret(k, v, "secrets_master_key")
 
 		fmt.Printf("\n")
 	}
@@ -868,6 +1090,143 @@ func keygen(env Environment, regexes []string) {
 	fmt.Printf("*** KEYGEN COMPLETE ***\n\n")
 }
 
+// keygenSessionData rotates the session data keys for the selected env without dropping live sessions.
+// The server backend signs session data with the first key and verifies with any of them, so a rotation is:
+//
+//	stage   - append a new key. deploy, so every server backend can verify it before any of them sign with it
+//	promote - move the newest key to the front. deploy, new session data is now signed with it
+//	retire  - drop every key except the first. deploy once in-flight sessions have moved to the new key
+func keygenSessionData(env Environment, args []string) {
+
+	if len(args) != 1 {
+		fmt.Printf("\nerror: usage is 'next keygen session_data <stage|promote|retire>'\n\n")
+		os.Exit(1)
+	}
+
+	// the keys are kept newest first as a comma separated list, exactly as SESSION_DATA_PRIVATE_KEYS expects.
+	// before the first rotation there is no list, and session data is signed with the server backend private key
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Printf("\nerror: could not get user home dir: %v\n\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s:\n\n", env.Name)
+
+	keys := make(map[string]string)
+
+	if fileExists(fmt.Sprintf("%s/secrets/%s-session-data-private-keys.txt", homeDir, env.Name)) {
+		readEnvSecret(env.Name, keys, "session_data_private_keys")
+	} else {
+		readEnvSecret(env.Name, keys, "server_backend_private_key")
+		keys["session_data_private_keys"] = keys["server_backend_private_key"]
+	}
+
+	privateKeys := strings.Split(keys["session_data_private_keys"], ",")
+
+	switch args[0] {
+
+	case "stage":
+		_, privateKey := crypto.Sign_KeyPair()
+		privateKeys = append(privateKeys, base64.StdEncoding.EncodeToString(privateKey))
+
+	case "promote":
+		if len(privateKeys) < 2 {
+			fmt.Printf("\nerror: no staged session data key to promote. run 'next keygen session_data stage' first\n\n")
+			os.Exit(1)
+		}
+		newest := privateKeys[len(privateKeys)-1]
+		privateKeys = append([]string{newest}, privateKeys[:len(privateKeys)-1]...)
+
+	case "retire":
+		privateKeys = privateKeys[:1]
+
+	default:
+		fmt.Printf("\nerror: unknown session data keygen stage '%s'\n\n", args[0])
+		os.Exit(1)
+	}
+
+	for i := range privateKeys {
+		privateKey, err := base64.StdEncoding.DecodeString(privateKeys[i])
+		if err != nil || len(privateKey) != crypto.Sign_PrivateKeySize {
+			fmt.Printf("\nerror: session data key %d is not a valid private key\n\n", i)
+			os.Exit(1)
+		}
+		fmt.Printf("	Session data key %d             = %08x\n", i, crypto.Sign_KeyId(privateKey[crypto.Sign_PrivateKeySize-crypto.Sign_PublicKeySize:]))
+	}
+
+	fmt.Printf("\n")
+
+	keys["session_data_private_keys"] = strings.Join(privateKeys, ",")
+
+	writeEnvSecret(env.Name, keys, "session_data_private_keys")
+
+	fmt.Printf("\n*** SESSION DATA KEYGEN COMPLETE. DEPLOY THE SERVER BACKEND ***\n\n")
+}
+
+// keygenBuyer generates a rotation keypair for an existing buyer. The new keys keep the buyer id, so the
+// public key can be added to the buyer with the admin API and is accepted alongside the current key.
+func keygenBuyer(args []string) {
+
+	if len(args) != 1 {
+		fmt.Printf("\nerror: usage is 'next keygen buyer <buyer public key base64>'\n\n")
+		os.Exit(1)
+	}
+
+	buyerPublicKey, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil || len(buyerPublicKey) != 40 {
+		fmt.Printf("\nerror: buyer public key is not valid\n\n")
+		os.Exit(1)
+	}
+
+	publicKey, privateKey := generateBuyerKeypairWithId(buyerPublicKey[:8])
+
+	fmt.Printf("\nbuyer id %016x:\n\n", binary.LittleEndian.Uint64(buyerPublicKey[:8]))
+	fmt.Printf("	Buyer public key              = %s\n", base64.StdEncoding.EncodeToString(publicKey))
+	fmt.Printf("	Buyer private key             = %s\n", base64.StdEncoding.EncodeToString(privateKey))
+
+	fmt.Printf("\nAdd the public key to the buyer with /admin/create_buyer_key, then switch game servers over to the new private key.\n")
+	fmt.Printf("Once every game server has switched, retire the current key by adding it with /admin/create_buyer_key and a not_after time.\n\n")
+}
+
+// keygenAdmin generates an admin api key for a named operator in the current env. The operator is the subject
+// of the JWT, so changes made with this key are attributed to them in the audit log.
+func keygenAdmin(env Environment, args []string) {
+
+	if len(args) != 1 || args[0] == "" {
+		fmt.Printf("\nerror: usage is 'next keygen admin <operator>'\n\n")
+		os.Exit(1)
+	}
+
+	keys := make(map[string]string)
+
+	readEnvSecret(env.Name, keys, "api_private_key")
+
+	claims := struct {
+		Admin  bool `json:"admin"`
+		Portal bool `json:"portal"`
+		jwt.RegisteredClaims
+	}{
+		Admin:  true,
+		Portal: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			Issuer:   "next keygen",
+			Subject:  args[0],
+		},
+	}
+
+	adminAPIKey, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(keys["api_private_key"]))
+	if err != nil {
+		fmt.Printf("\nerror: could not generate admin api key: %v\n\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n%s admin api key for %s:\n\n%s\n\n", env.Name, args[0], adminAPIKey)
+	fmt.Printf("Save it to ~/secrets/%s-operator-api-key.txt and the next tool will use it instead of the shared admin api key.\n\n", env.Name)
+}
+
 // ------------------------------------------------------------------------------
 
 func generateExampleDir() {
@@ -959,6 +1318,20 @@ func config(env Environment, regexes []string) {
 		}
 	}
 
+	// same for secrets master keys, which encrypt private keys in the database binary and env files
+
+	for _, env := range []string{"local", "dev", "staging", "prod"} {
+		filename := fmt.Sprintf("%s/%s-secrets-master-key.txt", secretsDir, env)
+		if !fileExists(filename) {
+			err := os.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString(crypto.Secrets_MasterKey())), 0666)
+			if err != nil {
+				fmt.Printf("\nerror: failed to write %s: %v\n\n", filename, err)
+				os.Exit(1)
+			}
+			fmt.Printf("back generated ~/secrets/%s-secrets-master-key.txt\n\n", env)
+		}
+	}
+
 	// IMPORTANT: if we don't have the global secrets yet (1.0 version of network next), we need to back generate them from the source code...
 
 	if !fileExists(fmt.Sprintf("%s/global-test-relay-public-key.txt", secretsDir)) {
@@ -1044,6 +1417,7 @@ func config(env Environment, regexes []string) {
 		readEnvSecret(env, keys, "admin_api_key")
 		readEnvSecret(env, keys, "portal_api_key")
 		readEnvSecret(env, keys, "ping_key")
+		readEnvSecret(env, keys, "secrets_master_key")
 
 		keypairs[env] = keys
 
@@ -1069,10 +1443,11 @@ func config(env Environment, regexes []string) {
 			replace(envFile, "^\\s*RELAY_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("RELAY_PRIVATE_KEY=\"%s\"", testRelayPrivateKey))
 
 			if v["secure"] != "true" {
-				replace(envFile, "^\\s*API_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("API_PRIVATE_KEY=\"%s\"", v["api_private_key"]))
-				replace(envFile, "^\\s*RELAY_BACKEND_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("RELAY_BACKEND_PRIVATE_KEY=\"%s\"", v["relay_backend_private_key"]))
-				replace(envFile, "^\\s*SERVER_BACKEND_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("SERVER_BACKEND_PRIVATE_KEY=\"%s\"", v["server_backend_private_key"]))
-				replace(envFile, "^\\s*PING_KEY\\s*=.*$", fmt.Sprintf("PING_KEY=\"%s\"", v["ping_key"]))
+				replace(envFile, "^\\s*SECRETS_MASTER_KEY_FILE\\s*=.*$", fmt.Sprintf("SECRETS_MASTER_KEY_FILE=\"~/secrets/%s-secrets-master-key.txt\"", k))
+				replace(envFile, "^\\s*API_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("API_PRIVATE_KEY=\"%s\"", sealEnvSecret(v, "api_private_key")))
+				replace(envFile, "^\\s*RELAY_BACKEND_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("RELAY_BACKEND_PRIVATE_KEY=\"%s\"", sealEnvSecret(v, "relay_backend_private_key")))
+				replace(envFile, "^\\s*SERVER_BACKEND_PRIVATE_KEY\\s*=.*$", fmt.Sprintf("SERVER_BACKEND_PRIVATE_KEY=\"%s\"", sealEnvSecret(v, "server_backend_private_key")))
+				replace(envFile, "^\\s*PING_KEY\\s*=.*$", fmt.Sprintf("PING_KEY=\"%s\"", sealEnvSecret(v, "ping_key")))
 			}
 		}
 	}
@@ -1683,7 +2058,9 @@ func secrets() {
 
 	fmt.Printf("\nzipping up secrets -> secrets.tar.gz\n\n")
 
-	if !bash("cd ~/secrets && rm -f secrets.tar.gz && tar -czvf secrets.tar.gz . 2> /dev/null") {
+	// operator api keys belong to the operator, not semaphore
+
+	if !bash("cd ~/secrets && rm -f secrets.tar.gz && tar --exclude='*-operator-api-key.txt' -czvf secrets.tar.gz . 2> /dev/null") {
 		fmt.Printf("\nerror: failed to tar gzip secrets :(\n\n")
 		os.Exit(1)
 	}
@@ -1772,12 +2149,20 @@ func GetBinary(apiKey string, url string) []byte {
 }
 
 func PutJSON(apiKey string, url string, requestData any, responseData any) error {
+	return sendJSON("PUT", apiKey, url, requestData, responseData)
+}
+
+func PostJSON(apiKey string, url string, requestData any, responseData any) error {
+	return sendJSON("POST", apiKey, url, requestData, responseData)
+}
+
+func sendJSON(method string, apiKey string, url string, requestData any, responseData any) error {
 
 	buffer := new(bytes.Buffer)
 
 	json.NewEncoder(buffer).Encode(requestData)
 
-	request, _ := http.NewRequest("PUT", url, buffer)
+	request, _ := http.NewRequest(method, url, buffer)
 
 	request.Header.Set("Authorization", "Bearer "+apiKey)
 
@@ -1830,12 +2215,18 @@ type AdminDatabaseResponse struct {
 	Error    string `json:"error"`
 }
 
+// getAdminAPIKey prefers the operator's own key, so changes they make are attributed to them in the audit log. The shared
+// admin api key is for terraform, and the api rejects audited changes made with keys that don't name an operator.
 func getAdminAPIKey() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		fmt.Printf("error: could not get users home dir: %v\n\n", err)
 		os.Exit(1)
 	}
+	operatorFilename := fmt.Sprintf("%s/secrets/%s-operator-api-key.txt", homeDir, env.Name)
+	if data, err := os.ReadFile(operatorFilename); err == nil {
+		return strings.TrimSpace(string(data))
+	}
 	filename := fmt.Sprintf("%s/secrets/%s-admin-api-key.txt", homeDir, env.Name)
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -2093,6 +2484,359 @@ func printRelayIds(env Environment, relayCount int64, alphaSort bool, regexName
 
 // ----------------------------------------------------------------
 
+type AdminRolloutRelay struct {
+	RelayName     string  `json:"relay_name"`
+	SellerCode    string  `json:"seller_code"`
+	Upgraded      bool    `json:"upgraded"`
+	TargetVersion string  `json:"target_version"`
+	RelayVersion  string  `json:"relay_version"`
+	Online        bool    `json:"online"`
+	RTT           float32 `json:"rtt"`
+	ErrorRate     float32 `json:"error_rate"`
+}
+
+type AdminRolloutsResponse struct {
+	Rollout          *common.Rollout     `json:"rollout"`
+	Stage            string              `json:"stage"`
+	NumRelays        int                 `json:"num_relays"`
+	NumUpgraded      int                 `json:"num_upgraded"`
+	NumRunningTarget int                 `json:"num_running_target"`
+	NumOffline       int                 `json:"num_offline"`
+	Relays           []AdminRolloutRelay `json:"relays"`
+	Error            string              `json:"error"`
+}
+
+type AdminStartRolloutRequest struct {
+	TargetVersion        string   `json:"target_version"`
+	CanaryRelays         []string `json:"canary_relays"`
+	Percent              int      `json:"percent"`
+	Sellers              []string `json:"sellers"`
+	SoakTime             int64    `json:"soak_time"`
+	MaxRTTIncrease       float32  `json:"max_rtt_increase"`
+	MaxErrorRateIncrease float32  `json:"max_error_rate_increase"`
+}
+
+type AdminRolloutResponse struct {
+	Error string `json:"error"`
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+func printRollout(env Environment) {
+
+	response := AdminRolloutsResponse{}
+
+	GetJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/rollouts", env.API_URL), &response)
+
+	if response.Error != "" {
+		fmt.Printf("error: %s\n\n", response.Error)
+		os.Exit(1)
+	}
+
+	if response.Rollout == nil {
+		fmt.Printf("no rollout\n\n")
+		return
+	}
+
+	rollout := response.Rollout
+
+	fmt.Printf("target version: %s\n", rollout.TargetVersion)
+	fmt.Printf("stage: %s\n", response.Stage)
+	fmt.Printf("started: %s\n", time.Unix(rollout.StartTime, 0).Format(time.RFC1123))
+	if rollout.Paused {
+		fmt.Printf("paused: %s\n", rollout.PauseReason)
+	}
+	fmt.Printf("upgraded: %d/%d relays (%d running %s, %d offline)\n\n", response.NumUpgraded, response.NumRelays, response.NumRunningTarget, rollout.TargetVersion, response.NumOffline)
+
+	type RolloutRow struct {
+		Name          string
+		Seller        string
+		Status        string
+		TargetVersion string
+		Version       string
+		RTT           string
+		ErrorRate     string
+	}
+
+	rows := make([]RolloutRow, 0, len(response.Relays))
+
+	for _, relay := range response.Relays {
+		row := RolloutRow{Name: relay.RelayName, Seller: relay.SellerCode, TargetVersion: relay.TargetVersion}
+		switch {
+		case !relay.Online:
+			row.Status = "offline"
+		case !relay.Upgraded:
+			row.Status = "waiting"
+		case relay.RelayVersion == rollout.TargetVersion:
+			row.Status = "upgraded"
+		default:
+			row.Status = "upgrading"
+		}
+		if relay.Online {
+			row.Version = relay.RelayVersion
+			row.RTT = fmt.Sprintf("%.1f", relay.RTT)
+			row.ErrorRate = fmt.Sprintf("%.4f%%", relay.ErrorRate)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) > 0 {
+		table.Output(rows)
+		fmt.Printf("\n")
+	}
+}
+
+func startRollout(env Environment, version string, canaryRelays []string, percent int, sellers []string, soakTime int64, maxRTTIncrease float32, maxErrorRateIncrease float32) {
+
+	request := AdminStartRolloutRequest{
+		TargetVersion:        version,
+		CanaryRelays:         canaryRelays,
+		Percent:              percent,
+		Sellers:              sellers,
+		SoakTime:             soakTime,
+		MaxRTTIncrease:       maxRTTIncrease,
+		MaxErrorRateIncrease: maxErrorRateIncrease,
+	}
+
+	response := AdminRolloutResponse{}
+
+	err := PutJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/start_rollout", env.API_URL), &request, &response)
+	if err != nil {
+		fmt.Printf("error: could not start rollout: %v\n\n", err)
+		os.Exit(1)
+	}
+
+	if response.Error != "" {
+		fmt.Printf("error: could not start rollout: %s\n\n", response.Error)
+		os.Exit(1)
+	}
+
+	fmt.Printf("started rollout to %s in %s\n\n", version, env.Name)
+}
+
+func modifyRollout(env Environment, action string) {
+
+	response := AdminRolloutResponse{}
+
+	err := PutJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/%s_rollout", env.API_URL, action), struct{}{}, &response)
+	if err != nil {
+		fmt.Printf("error: could not %s rollout: %v\n\n", action, err)
+		os.Exit(1)
+	}
+
+	if response.Error != "" {
+		fmt.Printf("error: could not %s rollout: %s\n\n", action, response.Error)
+		os.Exit(1)
+	}
+
+	pastTense := map[string]string{"pause": "paused", "resume": "resumed", "abort": "aborted"}
+
+	fmt.Printf("%s rollout in %s\n\n", pastTense[action], env.Name)
+}
+
+// ----------------------------------------------------------------
+
+type AdminCreateRelayBootstrapTokenRequest struct {
+	DatacenterId uint64 `json:"datacenter_id,string"`
+	ExpiryHours  int    `json:"expiry_hours"`
+}
+
+type AdminCreateRelayBootstrapTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+	Error     string `json:"error"`
+}
+
+type AdminPendingRelaysResponse struct {
+	PendingRelays []common.PendingRelay `json:"pending_relays"`
+	Error         string                `json:"error"`
+}
+
+type AdminRejectPendingRelayRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminPendingRelayResponse struct {
+	RelayId   uint64 `json:"relay_id,string"`
+	RelayName string `json:"relay_name"`
+	Error     string `json:"error"`
+}
+
+func createRelayBootstrapToken(env Environment, datacenterName string, expiryHours int) {
+
+	adminDatacentersResponse := AdminDatacentersResponse{}
+
+	GetJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/datacenters", env.API_URL), &adminDatacentersResponse)
+
+	request := AdminCreateRelayBootstrapTokenRequest{ExpiryHours: expiryHours}
+
+	for i := range adminDatacentersResponse.Datacenters {
+		if adminDatacentersResponse.Datacenters[i].DatacenterName == datacenterName {
+			request.DatacenterId = adminDatacentersResponse.Datacenters[i].DatacenterId
+		}
+	}
+
+	if request.DatacenterId == 0 {
+		fmt.Printf("error: could not find datacenter '%s'\n\n", datacenterName)
+		os.Exit(1)
+	}
+
+	response := AdminCreateRelayBootstrapTokenResponse{}
+
+	err := PostJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/create_relay_bootstrap_token", env.API_URL), &request, &response)
+	if err != nil {
+		fmt.Printf("error: could not create bootstrap token: %v\n\n", err)
+		os.Exit(1)
+	}
+
+	if response.Error != "" {
+		fmt.Printf("error: could not create bootstrap token: %s\n\n", response.Error)
+		os.Exit(1)
+	}
+
+	fmt.Printf("bootstrap token for a new relay in %s (expires %s):\n\n%s\n\n", datacenterName, time.Unix(response.ExpiresAt, 0).Format(time.RFC3339), response.Token)
+}
+
+func printPendingRelays(env Environment) {
+
+	response := AdminPendingRelaysResponse{}
+
+	GetJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/pending_relays", env.API_URL), &response)
+
+	if response.Error != "" {
+		fmt.Printf("error: could not get pending relays: %s\n\n", response.Error)
+		os.Exit(1)
+	}
+
+	if len(response.PendingRelays) == 0 {
+		fmt.Printf("no pending relays\n\n")
+		return
+	}
+
+	type PendingRelayRow struct {
+		Registration string
+		Status       string
+		Name         string
+		Datacenter   string
+		Address      string
+		Requested    string
+	}
+
+	adminDatacentersResponse := AdminDatacentersResponse{}
+
+	GetJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/datacenters", env.API_URL), &adminDatacentersResponse)
+
+	datacenterNames := make(map[uint64]string)
+	for i := range adminDatacentersResponse.Datacenters {
+		datacenterNames[adminDatacentersResponse.Datacenters[i].DatacenterId] = adminDatacentersResponse.Datacenters[i].DatacenterName
+	}
+
+	rows := make([]PendingRelayRow, len(response.PendingRelays))
+
+	for i := range response.PendingRelays {
+		pendingRelay := &response.PendingRelays[i]
+		rows[i].Registration = pendingRelay.RegistrationId
+		rows[i].Status = pendingRelay.Status
+		rows[i].Name = pendingRelay.RelayName
+		rows[i].Datacenter = datacenterNames[pendingRelay.DatacenterId]
+		rows[i].Address = pendingRelay.PublicAddress
+		rows[i].Requested = time.Unix(pendingRelay.RequestedAt, 0).Format(time.RFC3339)
+	}
+
+	table.Output(rows)
+
+	fmt.Printf("\n")
+}
+
+type AdminAuditResponse struct {
+	Entries []admin.AuditEntry `json:"entries"`
+	Error   string             `json:"error"`
+}
+
+func printAuditLog(env Environment, since int64, actor string, entity string, verbose bool) {
+
+	response := AdminAuditResponse{}
+
+	query := url.Values{}
+	query.Set("since", fmt.Sprintf("%d", since))
+	query.Set("actor", actor)
+	query.Set("entity", entity)
+
+	GetJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/audit?%s", env.API_URL, query.Encode()), &response)
+
+	if response.Error != "" {
+		fmt.Printf("error: could not get audit log: %s\n\n", response.Error)
+		os.Exit(1)
+	}
+
+	if len(response.Entries) == 0 {
+		fmt.Printf("no audit entries since %s\n\n", time.Unix(since, 0).Format(time.RFC3339))
+		return
+	}
+
+	if verbose {
+		for i := range response.Entries {
+			entry := &response.Entries[i]
+			fmt.Printf("%d: %s %s %s\n\n", entry.AuditId, time.Unix(entry.Timestamp, 0).Format(time.RFC3339), entry.Actor, entry.Endpoint)
+			fmt.Printf("    before: %s\n", string(entry.Before))
+			fmt.Printf("    after:  %s\n\n", string(entry.After))
+		}
+		return
+	}
+
+	type AuditRow struct {
+		Id       uint64
+		Time     string
+		Actor    string
+		Entity   string
+		Endpoint string
+	}
+
+	rows := make([]AuditRow, len(response.Entries))
+
+	for i := range response.Entries {
+		entry := &response.Entries[i]
+		rows[i].Id = entry.AuditId
+		rows[i].Time = time.Unix(entry.Timestamp, 0).Format(time.RFC3339)
+		rows[i].Actor = entry.Actor
+		rows[i].Entity = entry.Entity
+		rows[i].Endpoint = entry.Endpoint
+	}
+
+	table.Output(rows)
+
+	fmt.Printf("\n")
+}
+
+func modifyPendingRelay(env Environment, action string, registrationId string, reason string) {
+
+	response := AdminPendingRelayResponse{}
+
+	err := PutJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/%s_pending_relay/%s", env.API_URL, action, registrationId), &AdminRejectPendingRelayRequest{Reason: reason}, &response)
+	if err != nil {
+		fmt.Printf("error: could not %s pending relay: %v\n\n", action, err)
+		os.Exit(1)
+	}
+
+	if response.Error != "" {
+		fmt.Printf("error: could not %s pending relay: %s\n\n", action, response.Error)
+		os.Exit(1)
+	}
+
+	if action == "approve" {
+		fmt.Printf("approved relay %s. commit the database for it to go live\n\n", response.RelayName)
+	} else {
+		fmt.Printf("rejected pending relay %s\n\n", registrationId)
+	}
+}
+
+// ----------------------------------------------------------------
+
 type AdminDatacentersResponse struct {
 	Datacenters []admin.DatacenterData `json:"datacenters"`
 	Error       string                 `json:"error"`
@@ -2622,10 +3366,56 @@ func (e *Environment) Clean() {
 // -------------------------------------------------------------------------------------------
 
 func getCostMatrix(env Environment, fileName string) {
-
 	cost_matrix_binary := GetBinary(getAdminAPIKey(), fmt.Sprintf("%s/portal/cost_matrix", env.API_URL))
+	writeCostMatrix(fileName, cost_matrix_binary)
+}
+
+// parseSnapshotTime accepts a unix timestamp, an RFC3339 time, or a duration meaning that long ago
+
+func parseSnapshotTime(value string) int64 {
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return timestamp
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix()
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration).Unix()
+	}
+	handleRunTimeError(fmt.Sprintf("could not parse time '%s'. expected unix timestamp, RFC3339 time or duration\n", value), 1)
+	return 0
+}
+
+type MatrixSnapshotsResponse struct {
+	Timestamps []int64 `json:"timestamps"`
+	Error      string  `json:"error"`
+}
+
+func listCostMatrixSnapshots(env Environment) {
+	response := MatrixSnapshotsResponse{}
+	GetJSON(getAdminAPIKey(), fmt.Sprintf("%s/admin/matrix_snapshots/%s", env.API_URL, common.MatrixSnapshot_CostMatrix), &response)
+	if response.Error != "" {
+		handleRunTimeError(fmt.Sprintf("could not list cost matrix snapshots: %s\n", response.Error), 1)
+	}
+	if len(response.Timestamps) == 0 {
+		fmt.Printf("no cost matrix snapshots\n\n")
+		return
+	}
+	fmt.Printf("cost matrix snapshots: %d\n\n", len(response.Timestamps))
+	for _, timestamp := range response.Timestamps {
+		fmt.Printf("%d %s\n", timestamp, time.Unix(timestamp, 0).Format(time.RFC3339))
+	}
+	fmt.Printf("\n")
+}
+
+func getCostMatrixSnapshot(env Environment, fileName string, at int64) {
+	cost_matrix_binary := GetBinary(getAdminAPIKey(), fmt.Sprintf("%s/admin/matrix_snapshot/%s/%d", env.API_URL, common.MatrixSnapshot_CostMatrix, at))
+	writeCostMatrix(fileName, cost_matrix_binary)
+}
+
+func writeCostMatrix(fileName string, cost_matrix_binary []byte) {
 
-	os.WriteFile("cost.bin", cost_matrix_binary, 0644)
+	os.WriteFile(fileName, cost_matrix_binary, 0644)
 
 	w, err := os.Create("cost.html")
 	if err != nil {
@@ -2751,6 +3541,7 @@ func optimizeCostMatrix(costMatrixFilename, routeMatrixFilename string, costThre
 		RouteEntries:       core.Optimize(numRelays, numSegments, costMatrix.Costs, costMatrix.RelayPrice, costMatrix.RelayDatacenterIds, costMatrix.DestRelays),
 		Costs:              costMatrix.Costs,
 		RelayPrice:         costMatrix.RelayPrice,
+		RelayMTU:           costMatrix.RelayMTU,
 	}
 
 	routeMatrixData, err := routeMatrix.Write()